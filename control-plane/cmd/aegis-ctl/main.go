@@ -10,6 +10,9 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/version"
 )
 
 func main() {
@@ -24,13 +27,21 @@ func main() {
 	switch os.Args[1] {
 	case "status":
 		cmdStatus(baseURL, token)
+	case "version":
+		cmdVersion(baseURL, token)
 	case "backends":
 		if len(os.Args) < 3 {
-			die("usage: aegis-ctl backends <add|remove> ...")
+			die("usage: aegis-ctl backends <show|add|set|weight|remove> ...")
 		}
 		switch os.Args[2] {
+		case "show":
+			cmdBackendsShow(baseURL, token, os.Args[3:])
 		case "add":
 			cmdBackendsAdd(baseURL, token, os.Args[3:])
+		case "set":
+			cmdBackendsSet(baseURL, token, os.Args[3:])
+		case "weight":
+			cmdBackendsWeight(baseURL, token, os.Args[3:])
 		case "remove":
 			cmdBackendsRemove(baseURL, token, os.Args[3:])
 		default:
@@ -40,6 +51,28 @@ func main() {
 		cmdDrain(baseURL, token, os.Args[2:])
 	case "reload":
 		cmdReload(baseURL, token)
+	case "pause", "resume":
+		cmdTraffic(baseURL, token, os.Args[1])
+	case "ratelimit":
+		cmdRateLimit(baseURL, token, os.Args[2:])
+	case "split":
+		cmdSplit(baseURL, token, os.Args[2:])
+	case "switch":
+		cmdSwitch(baseURL, token, os.Args[2:])
+	case "mirror":
+		cmdMirror(baseURL, token, os.Args[2:])
+	case "canary":
+		cmdCanary(baseURL, token, os.Args[2:])
+	case "discovery":
+		cmdDiscovery(baseURL, token, os.Args[2:])
+	case "schedules":
+		cmdSchedules(baseURL, token)
+	case "loglevel":
+		cmdLogLevel(baseURL, token, os.Args[2:])
+	case "circuits":
+		cmdCircuits(baseURL, token, os.Args[2:])
+	case "connections":
+		cmdConnections(baseURL, token, os.Args[2:])
 	default:
 		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", os.Args[1])
 		usage()
@@ -52,10 +85,47 @@ func usage() {
 
 Commands:
   status                         List all backends with health state
+  version                        aegis-ctl's build and the server's
+  backends show <addr>           One backend's config, stats and health history
   backends add <addr> [-w N]     Add backend (weight default 100)
+  backends set <addr> -w N       Change a backend's weight (adds it if missing)
+  backends weight <addr> <N>     Shift traffic: set weight of an existing
+                                 backend; 0 stops new weighted traffic to it
   backends remove <addr>         Remove backend
+                                 add/set/weight/remove take --persist to also save
+                                 the change to the config file, and --if-match V
+                                 to apply it only if the config version is still V
   drain [--timeout 30]           Drain all connections
   reload                         Reload config from disk
+  pause                          Refuse new connections, keep existing ones
+  resume                         Accept new connections again
+  ratelimit [--rps N] [--burst N]
+                                 Change the global rate limit at runtime
+  split [POOL=PCT ...] [--if-match V]
+                                 Show or set the percentage of traffic per
+                                 backend pool; --clear removes the split
+  switch [POOL] [--if-match V]   Switch blue/green traffic to POOL (default:
+                                 the standby pool) once it's healthy
+  mirror [POOL PCT | off] [--if-match V]
+                                 Show mirroring, or copy PCT% of connections
+                                 to the shadow pool POOL; off stops it
+  canary [start POOL BASELINE [--target PCT] [--step PCT] [--interval SECS]
+         [--max-error-rate R] [--max-p99 MS] [--min-requests N] | abort]
+                                 Show the canary rollout, start shifting
+                                 traffic from BASELINE to POOL in steps, or
+                                 abort it back to BASELINE
+  discovery [confirm]            Show service discovery, or push the set it
+                                 holds back for shrinking the pool too far
+  schedules                      List scheduled config overlays with their
+                                 next and last application
+  loglevel [LEVEL] [--revert-after MIN]
+                                 Show or change the control plane's log
+                                 level, optionally reverting after MIN minutes
+  circuits                       List circuit breaker states
+  circuits reset <addr>          Close a backend's circuit breaker
+  connections [--backend ADDR] [--client-ip IP]
+                                 List active connections
+  connections close <id>         Forcibly close a connection
 
 Env:
   AEGIS_URL         Admin API base URL (default: http://localhost:9090)
@@ -63,7 +133,7 @@ Env:
 }
 
 func cmdStatus(baseURL, token string) {
-	data, code := request("GET", baseURL+"/backends", token, nil)
+	data, code := request("GET", baseURL+"/api/v1/backends", token, nil)
 	if code != 200 {
 		die("server returned %d: %s", code, data)
 	}
@@ -88,6 +158,96 @@ func cmdStatus(baseURL, token string) {
 	}
 }
 
+func cmdVersion(baseURL, token string) {
+	local := version.Get()
+	fmt.Printf("aegis-ctl:     %s (commit %s, %s)\n", local.Version, orUnknown(local.Commit), local.GoVersion)
+
+	data, code := request("GET", baseURL+"/api/v1/version", token, nil)
+	switch code {
+	case 200:
+	case 401:
+		die("unauthorized: set AEGIS_API_TOKEN")
+	default:
+		die("server returned %d: %s", code, data)
+	}
+	var resp struct {
+		version.Info
+		DataPlaneVersion string `json:"data_plane_version"`
+	}
+	must(json.Unmarshal(data, &resp))
+	fmt.Printf("control plane: %s (commit %s, built %s, %s, %s)\n",
+		resp.Version, orUnknown(resp.Commit), orUnknown(resp.BuildDate), resp.GoVersion, resp.Platform)
+	if resp.DataPlaneVersion != "" {
+		fmt.Printf("data plane:    %s\n", resp.DataPlaneVersion)
+	}
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+func cmdBackendsShow(baseURL, token string, args []string) {
+	if len(args) != 1 {
+		die("usage: aegis-ctl backends show <address>")
+	}
+	data, code := request("GET", baseURL+"/api/v1/backends/"+url.PathEscape(args[0]), token, nil)
+	switch code {
+	case 200:
+	case 401:
+		die("unauthorized: set AEGIS_API_TOKEN")
+	case 404:
+		die("backend not found: %s", args[0])
+	default:
+		die("server returned %d: %s", code, data)
+	}
+
+	var b struct {
+		Address           string  `json:"address"`
+		Weight            int     `json:"weight"`
+		Healthy           bool    `json:"healthy"`
+		CircuitState      string  `json:"circuit_state"`
+		ActiveConnections *int64  `json:"active_connections"`
+		TotalRequests     int64   `json:"total_requests"`
+		FailedRequests    int64   `json:"failed_requests"`
+		AvgLatencyMs      float64 `json:"avg_latency_ms"`
+		HealthHistory     *struct {
+			LastChecked         time.Time `json:"last_checked"`
+			ConsecutiveFailures int       `json:"consecutive_failures"`
+			Transitions         []struct {
+				Time    time.Time `json:"time"`
+				Healthy bool      `json:"healthy"`
+			} `json:"transitions"`
+		} `json:"health_history"`
+	}
+	must(json.Unmarshal(data, &b))
+
+	health := "healthy"
+	if !b.Healthy {
+		health = "unhealthy"
+	}
+	fmt.Printf("Address:   %s\nWeight:    %d\nHealth:    %s\n", b.Address, b.Weight, health)
+	if b.CircuitState != "" {
+		fmt.Printf("Circuit:   %s\n", b.CircuitState)
+	}
+	if b.ActiveConnections != nil {
+		fmt.Printf("Active:    %d connections\nRequests:  %d (%d failed)\nLatency:   %.1f ms avg\n",
+			*b.ActiveConnections, b.TotalRequests, b.FailedRequests, b.AvgLatencyMs)
+	}
+	if h := b.HealthHistory; h != nil {
+		fmt.Printf("Checked:   %s (%d consecutive failures)\n", h.LastChecked.Local().Format(time.DateTime), h.ConsecutiveFailures)
+		for _, t := range h.Transitions {
+			state := "healthy"
+			if !t.Healthy {
+				state = "unhealthy"
+			}
+			fmt.Printf("  %s  -> %s\n", t.Time.Local().Format(time.DateTime), state)
+		}
+	}
+}
+
 func cmdBackendsAdd(baseURL, token string, args []string) {
 	if len(args) < 1 {
 		die("usage: aegis-ctl backends add <address> [-w weight]")
@@ -105,7 +265,8 @@ func cmdBackendsAdd(baseURL, token string, args []string) {
 	}
 
 	body := map[string]interface{}{"address": addr, "weight": weight}
-	data, code := request("POST", baseURL+"/backends", token, body)
+	data, code := requestIfMatch("POST", baseURL+"/api/v1/backends"+persistQuery(args), token, body, ifMatch(args))
+	dieOnVersionError(code, data)
 	switch code {
 	case 201:
 		fmt.Printf("added %s (weight %d)\n", addr, weight)
@@ -118,12 +279,141 @@ func cmdBackendsAdd(baseURL, token string, args []string) {
 	}
 }
 
+func cmdBackendsSet(baseURL, token string, args []string) {
+	if len(args) < 3 {
+		die("usage: aegis-ctl backends set <address> -w weight")
+	}
+	addr := args[0]
+	weight := 0
+	for i := 1; i < len(args)-1; i++ {
+		if args[i] == "-w" || args[i] == "--weight" {
+			w, err := strconv.Atoi(args[i+1])
+			if err != nil || w <= 0 {
+				die("invalid weight: %s", args[i+1])
+			}
+			weight = w
+		}
+	}
+	if weight == 0 {
+		die("usage: aegis-ctl backends set <address> -w weight")
+	}
+
+	body := map[string]interface{}{"weight": weight}
+	data, code := requestIfMatch("PUT", baseURL+"/api/v1/backends/"+url.PathEscape(addr)+persistQuery(args), token, body, ifMatch(args))
+	dieOnVersionError(code, data)
+	switch code {
+	case 200:
+		fmt.Printf("updated %s (weight %d)\n", addr, weight)
+	case 201:
+		fmt.Printf("added %s (weight %d)\n", addr, weight)
+	case 401:
+		die("unauthorized: set AEGIS_API_TOKEN")
+	default:
+		die("server returned %d: %s", code, data)
+	}
+}
+
+func cmdBackendsWeight(baseURL, token string, args []string) {
+	if len(args) < 2 {
+		die("usage: aegis-ctl backends weight <address> <weight>")
+	}
+	addr := args[0]
+	weight, err := strconv.Atoi(args[1])
+	if err != nil || weight < 0 {
+		die("invalid weight: %s", args[1])
+	}
+
+	body := map[string]interface{}{"weight": weight}
+	data, code := requestIfMatch("PATCH", baseURL+"/api/v1/backends/"+url.PathEscape(addr)+persistQuery(args), token, body, ifMatch(args))
+	dieOnVersionError(code, data)
+	switch code {
+	case 200:
+		fmt.Printf("%s weight is now %d\n", addr, weight)
+	case 401:
+		die("unauthorized: set AEGIS_API_TOKEN")
+	case 404:
+		die("backend not found: %s", addr)
+	default:
+		die("server returned %d: %s", code, data)
+	}
+}
+
+// persistQuery asks the server to save a backend change to its config file
+// when --persist is among args.
+func persistQuery(args []string) string {
+	for _, a := range args {
+		if a == "--persist" || a == "-persist" {
+			return "?persist=true"
+		}
+	}
+	return ""
+}
+
+// ifMatch returns the version after --if-match in args, or "".
+func ifMatch(args []string) string {
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == "--if-match" || args[i] == "-if-match" {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// dieOnVersionError reports a change refused by If-Match.
+func dieOnVersionError(code int, data []byte) {
+	switch {
+	case code == 428:
+		die("the server requires --if-match: pass the ETag of GET /api/v1/backends or /config")
+	case code == 409 && bytes.Contains(data, []byte(`"version_conflict"`)):
+		die("config changed since that version; re-read it and retry: %s", data)
+	}
+}
+
+func cmdSwitch(baseURL, token string, args []string) {
+	body := map[string]any{}
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--if-match" || args[i] == "-if-match":
+			i++
+		case !strings.HasPrefix(args[i], "-") && body["to"] == nil:
+			body["to"] = args[i]
+		default:
+			die("usage: aegis-ctl switch [POOL] [--if-match V]")
+		}
+	}
+
+	data, code := requestIfMatch("POST", baseURL+"/api/v1/pools/switch", token, body, ifMatch(args))
+	dieOnVersionError(code, data)
+	switch code {
+	case 200:
+		var resp struct {
+			Active   string `json:"active"`
+			Previous string `json:"previous"`
+			Healthy  int    `json:"healthy"`
+			Total    int    `json:"total"`
+			Version  string `json:"version"`
+		}
+		must(json.Unmarshal(data, &resp))
+		if resp.Active == resp.Previous {
+			fmt.Printf("pool %s is already active\n", resp.Active)
+		} else {
+			fmt.Printf("traffic switched from %s to %s (%d/%d backends healthy)\n", resp.Previous, resp.Active, resp.Healthy, resp.Total)
+		}
+		fmt.Printf("config version %s\n", resp.Version)
+	case 401:
+		die("unauthorized: set AEGIS_API_TOKEN")
+	default:
+		die("server returned %d: %s", code, data)
+	}
+}
+
 func cmdBackendsRemove(baseURL, token string, args []string) {
 	if len(args) < 1 {
 		die("usage: aegis-ctl backends remove <address>")
 	}
 	addr := args[0]
-	data, code := request("DELETE", baseURL+"/backends/"+url.PathEscape(addr), token, nil)
+	data, code := requestIfMatch("DELETE", baseURL+"/api/v1/backends/"+url.PathEscape(addr)+persistQuery(args), token, nil, ifMatch(args))
+	dieOnVersionError(code, data)
 	switch code {
 	case 200:
 		fmt.Printf("removed %s\n", addr)
@@ -137,29 +427,56 @@ func cmdBackendsRemove(baseURL, token string, args []string) {
 }
 
 func cmdDrain(baseURL, token string, args []string) {
-	// --timeout parsed but API uses server-side default (30s); wired up when API supports it
+	timeout := 30
 	for i := 0; i < len(args)-1; i++ {
 		if args[i] == "--timeout" || args[i] == "-timeout" {
 			t, err := strconv.Atoi(strings.TrimSuffix(args[i+1], "s"))
 			if err != nil || t <= 0 {
 				die("invalid timeout: %s", args[i+1])
 			}
+			timeout = t
 		}
 	}
 
-	data, code := request("POST", baseURL+"/drain", token, nil)
+	data, code := request("POST", fmt.Sprintf("%s/api/v1/drain?timeout=%d", baseURL, timeout), token, nil)
 	switch code {
-	case 200:
-		fmt.Println("connections drained")
+	case 202:
 	case 401:
 		die("unauthorized: set AEGIS_API_TOKEN")
 	default:
 		die("server returned %d: %s", code, data)
 	}
+
+	var op operation
+	must(json.Unmarshal(data, &op))
+	fmt.Printf("draining (timeout %ds, operation %s)\n", timeout, op.ID)
+	for op.State == "running" {
+		time.Sleep(time.Second)
+		data, code = request("GET", baseURL+"/api/v1/operations/"+op.ID, token, nil)
+		if code != 200 {
+			die("server returned %d: %s", code, data)
+		}
+		must(json.Unmarshal(data, &op))
+		if active, ok := op.Progress["active_connections"]; ok && op.State == "running" {
+			fmt.Printf("  %d active connections\n", active)
+		}
+	}
+	if op.State != "succeeded" {
+		die("drain failed: %s", op.Error)
+	}
+	fmt.Println("connections drained")
+}
+
+// operation is the subset of the API's OperationStatus the CLI reads.
+type operation struct {
+	ID       string           `json:"id"`
+	State    string           `json:"state"`
+	Progress map[string]int64 `json:"progress"`
+	Error    string           `json:"error"`
 }
 
 func cmdReload(baseURL, token string) {
-	data, code := request("POST", baseURL+"/reload", token, nil)
+	data, code := request("POST", baseURL+"/api/v1/reload", token, nil)
 	switch code {
 	case 200:
 		fmt.Println("config reloaded")
@@ -170,7 +487,561 @@ func cmdReload(baseURL, token string) {
 	}
 }
 
+func cmdTraffic(baseURL, token, action string) {
+	data, code := request("POST", baseURL+"/api/v1/traffic/"+action, token, nil)
+	switch code {
+	case 200:
+		var resp struct {
+			Message string `json:"message"`
+		}
+		must(json.Unmarshal(data, &resp))
+		fmt.Println(resp.Message)
+	case 401:
+		die("unauthorized: set AEGIS_API_TOKEN")
+	default:
+		die("server returned %d: %s", code, data)
+	}
+}
+
+func cmdRateLimit(baseURL, token string, args []string) {
+	body := map[string]int{}
+	for i := 0; i < len(args)-1; i++ {
+		var key string
+		switch args[i] {
+		case "--rps", "-rps":
+			key = "requests_per_second"
+		case "--burst", "-burst":
+			key = "burst"
+		default:
+			continue
+		}
+		n, err := strconv.Atoi(args[i+1])
+		if err != nil || n < 0 {
+			die("invalid %s: %s", args[i], args[i+1])
+		}
+		body[key] = n
+		i++
+	}
+	if len(body) == 0 {
+		die("usage: aegis-ctl ratelimit [--rps N] [--burst N]")
+	}
+
+	data, code := request("PUT", baseURL+"/api/v1/rate-limit", token, body)
+	switch code {
+	case 200:
+		var resp struct {
+			RequestsPerSecond int `json:"requests_per_second"`
+			Burst             int `json:"burst"`
+		}
+		must(json.Unmarshal(data, &resp))
+		fmt.Printf("rate limit set to %d rps, burst %d\n", resp.RequestsPerSecond, resp.Burst)
+	case 401:
+		die("unauthorized: set AEGIS_API_TOKEN")
+	default:
+		die("server returned %d: %s", code, data)
+	}
+}
+
+func cmdSplit(baseURL, token string, args []string) {
+	var split map[string]int
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--if-match" || args[i] == "-if-match":
+			i++
+		case args[i] == "--clear" || args[i] == "-clear":
+			split = map[string]int{}
+		case strings.Contains(args[i], "="):
+			pool, pct, _ := strings.Cut(args[i], "=")
+			n, err := strconv.Atoi(pct)
+			if err != nil || pool == "" {
+				die("invalid split %q: want POOL=PERCENT", args[i])
+			}
+			if split == nil {
+				split = map[string]int{}
+			}
+			split[pool] = n
+		default:
+			die("usage: aegis-ctl split [POOL=PCT ...] [--clear] [--if-match V]")
+		}
+	}
+
+	var data []byte
+	var code int
+	if split == nil {
+		data, code = request("GET", baseURL+"/api/v1/traffic-split", token, nil)
+	} else {
+		data, code = requestIfMatch("PUT", baseURL+"/api/v1/traffic-split", token,
+			map[string]any{"split": split}, ifMatch(args))
+		dieOnVersionError(code, data)
+	}
+	switch code {
+	case 200:
+		var resp struct {
+			Pools []struct {
+				Pool     string   `json:"pool"`
+				Percent  int      `json:"percent"`
+				Backends []string `json:"backends"`
+			} `json:"pools"`
+			Version string `json:"version"`
+		}
+		must(json.Unmarshal(data, &resp))
+		if len(resp.Pools) == 0 {
+			fmt.Println("no traffic split: backends get traffic by weight")
+		} else {
+			fmt.Printf("%-16s  %-8s  %s\n", "POOL", "PERCENT", "BACKENDS")
+			for _, p := range resp.Pools {
+				fmt.Printf("%-16s  %-8s  %s\n", p.Pool, strconv.Itoa(p.Percent)+"%", strings.Join(p.Backends, ", "))
+			}
+		}
+		fmt.Printf("config version %s\n", resp.Version)
+	case 401:
+		die("unauthorized: set AEGIS_API_TOKEN")
+	default:
+		die("server returned %d: %s", code, data)
+	}
+}
+
+func cmdMirror(baseURL, token string, args []string) {
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--if-match" || args[i] == "-if-match":
+			i++
+		case !strings.HasPrefix(args[i], "-"):
+			positional = append(positional, args[i])
+		default:
+			die("usage: aegis-ctl mirror [POOL PCT | off] [--if-match V]")
+		}
+	}
+
+	var data []byte
+	var code int
+	switch {
+	case len(positional) == 0:
+		data, code = request("GET", baseURL+"/api/v1/mirror", token, nil)
+	case len(positional) == 1 && positional[0] == "off":
+		data, code = requestIfMatch("PUT", baseURL+"/api/v1/mirror", token, map[string]any{}, ifMatch(args))
+		dieOnVersionError(code, data)
+	case len(positional) == 2:
+		pct, err := strconv.Atoi(strings.TrimSuffix(positional[1], "%"))
+		if err != nil {
+			die("invalid percentage %q", positional[1])
+		}
+		data, code = requestIfMatch("PUT", baseURL+"/api/v1/mirror", token,
+			map[string]any{"pool": positional[0], "percent": pct}, ifMatch(args))
+		dieOnVersionError(code, data)
+	default:
+		die("usage: aegis-ctl mirror [POOL PCT | off] [--if-match V]")
+	}
+	switch code {
+	case 200:
+		var resp struct {
+			Pool     string   `json:"pool"`
+			Percent  int      `json:"percent"`
+			Backends []string `json:"backends"`
+			Version  string   `json:"version"`
+		}
+		must(json.Unmarshal(data, &resp))
+		if resp.Pool == "" {
+			fmt.Println("mirroring is off")
+		} else {
+			fmt.Printf("mirroring %d%% of connections to %s (%s)\n", resp.Percent, resp.Pool, strings.Join(resp.Backends, ", "))
+		}
+		fmt.Printf("config version %s\n", resp.Version)
+	case 401:
+		die("unauthorized: set AEGIS_API_TOKEN")
+	default:
+		die("server returned %d: %s", code, data)
+	}
+}
+
+func cmdDiscovery(baseURL, token string, args []string) {
+	type shrink struct {
+		Backends int       `json:"backends"`
+		Previous int       `json:"previous"`
+		Removed  []string  `json:"removed"`
+		Since    time.Time `json:"since"`
+	}
+	if len(args) > 0 {
+		if args[0] != "confirm" || len(args) != 1 {
+			die("usage: aegis-ctl discovery [confirm]")
+		}
+		data, code := request("POST", baseURL+"/api/v1/discovery/confirm", token, nil)
+		switch code {
+		case 202:
+			var held shrink
+			must(json.Unmarshal(data, &held))
+			fmt.Printf("pushing %d discovered backends in place of %d\n", held.Backends, held.Previous)
+		case 401:
+			die("unauthorized: set AEGIS_API_TOKEN")
+		case 409:
+			die("no discovered backend set is held back")
+		default:
+			die("server returned %d: %s", code, data)
+		}
+		return
+	}
+
+	data, code := request("GET", baseURL+"/api/v1/discovery", token, nil)
+	if code == 401 {
+		die("unauthorized: set AEGIS_API_TOKEN")
+	}
+	if code != 200 {
+		die("server returned %d: %s", code, data)
+	}
+	var resp struct {
+		Enabled  bool    `json:"enabled"`
+		Provider string  `json:"provider"`
+		Target   string  `json:"target"`
+		Merge    string  `json:"merge"`
+		Backends int     `json:"backends"`
+		Held     *shrink `json:"held"`
+	}
+	must(json.Unmarshal(data, &resp))
+	if !resp.Enabled {
+		fmt.Println("discovery off")
+		return
+	}
+	fmt.Printf("%s %s (merge %s): %d backends\n", resp.Provider, resp.Target, resp.Merge, resp.Backends)
+	if h := resp.Held; h != nil {
+		fmt.Printf("held since %s: %d backends in place of %d, removing %s\n",
+			h.Since.Local().Format(time.DateTime), h.Backends, h.Previous, strings.Join(h.Removed, ", "))
+		fmt.Println("run aegis-ctl discovery confirm to push them")
+	}
+}
+
+func cmdSchedules(baseURL, token string) {
+	data, code := request("GET", baseURL+"/api/v1/schedules", token, nil)
+	if code == 401 {
+		die("unauthorized: set AEGIS_API_TOKEN")
+	}
+	if code != 200 {
+		die("server returned %d: %s", code, data)
+	}
+	var resp struct {
+		Timezone  string `json:"timezone"`
+		Schedules []struct {
+			Name            string     `json:"name"`
+			Cron            string     `json:"cron"`
+			Duration        string     `json:"duration"`
+			Active          bool       `json:"active"`
+			NextApplication *time.Time `json:"next_application"`
+			LastApplied     *time.Time `json:"last_applied"`
+			RevertAt        *time.Time `json:"revert_at"`
+			LastError       string     `json:"last_error"`
+		} `json:"schedules"`
+	}
+	must(json.Unmarshal(data, &resp))
+	if len(resp.Schedules) == 0 {
+		fmt.Println("no schedules")
+		return
+	}
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return "-"
+		}
+		return t.Local().Format(time.DateTime)
+	}
+	fmt.Printf("times in %s; cron in %s\n", time.Local, resp.Timezone)
+	fmt.Printf("%-20s %-16s %-9s %-8s %-20s %s\n", "NAME", "CRON", "DURATION", "STATE", "NEXT", "LAST APPLIED")
+	for _, sc := range resp.Schedules {
+		duration, state := sc.Duration, "idle"
+		if duration == "" {
+			duration = "-"
+		}
+		if sc.Active {
+			state = "active"
+		}
+		fmt.Printf("%-20s %-16s %-9s %-8s %-20s %s\n", sc.Name, sc.Cron, duration, state, formatTime(sc.NextApplication), formatTime(sc.LastApplied))
+		if sc.RevertAt != nil {
+			fmt.Printf("  reverts at %s\n", formatTime(sc.RevertAt))
+		}
+		if sc.LastError != "" {
+			fmt.Printf("  last error: %s\n", sc.LastError)
+		}
+	}
+}
+
+func cmdCanary(baseURL, token string, args []string) {
+	const usage = "usage: aegis-ctl canary [start POOL BASELINE [--target PCT] [--step PCT] [--interval SECS] [--max-error-rate R] [--max-p99 MS] [--min-requests N] | abort]"
+	var data []byte
+	var code int
+	switch {
+	case len(args) == 0:
+		data, code = request("GET", baseURL+"/api/v1/canary", token, nil)
+	case args[0] == "abort":
+		data, code = request("DELETE", baseURL+"/api/v1/canary", token, nil)
+	case args[0] == "start" && len(args) >= 3:
+		body := map[string]any{"pool": args[1], "baseline": args[2]}
+		rest := args[3:]
+		for i := 0; i < len(rest)-1; i++ {
+			var key string
+			switch rest[i] {
+			case "--target", "-target":
+				key = "target_percent"
+			case "--step", "-step":
+				key = "step_percent"
+			case "--interval", "-interval":
+				key = "step_interval_secs"
+			case "--max-error-rate", "-max-error-rate":
+				key = "max_error_rate"
+			case "--max-p99", "-max-p99":
+				key = "max_p99_latency_ms"
+			case "--min-requests", "-min-requests":
+				key = "min_requests"
+			default:
+				die(usage)
+			}
+			n, err := strconv.ParseFloat(strings.TrimSuffix(rest[i+1], "%"), 64)
+			if err != nil || n < 0 {
+				die("invalid %s: %s", rest[i], rest[i+1])
+			}
+			body[key] = n
+			i++
+		}
+		data, code = request("POST", baseURL+"/api/v1/canary", token, body)
+	default:
+		die(usage)
+	}
+
+	switch code {
+	case 200, 202:
+		var resp struct {
+			State         string  `json:"state"`
+			Pool          string  `json:"pool"`
+			Baseline      string  `json:"baseline"`
+			Percent       int     `json:"percent"`
+			TargetPercent int     `json:"target_percent"`
+			StepInterval  string  `json:"step_interval"`
+			MaxErrorRate  float64 `json:"max_error_rate"`
+			Current       *struct {
+				Requests     int64    `json:"requests"`
+				ErrorRate    float64  `json:"error_rate"`
+				P99LatencyMs *float64 `json:"p99_latency_ms"`
+			} `json:"current"`
+			Steps []struct {
+				Percent      int      `json:"percent"`
+				Requests     int64    `json:"requests"`
+				ErrorRate    float64  `json:"error_rate"`
+				P99LatencyMs *float64 `json:"p99_latency_ms"`
+				Passed       bool     `json:"passed"`
+			} `json:"steps"`
+			Reason    string `json:"reason"`
+			Version   string `json:"version"`
+			LastError string `json:"last_error"`
+		}
+		must(json.Unmarshal(data, &resp))
+		if resp.State == "idle" {
+			fmt.Println("no canary rollout")
+			return
+		}
+		p99 := func(ms *float64) string {
+			if ms == nil {
+				return "-"
+			}
+			return strconv.FormatFloat(*ms, 'f', 1, 64) + "ms"
+		}
+		fmt.Printf("canary %s: %s at %d%% of %d%%, baseline %s\n", resp.Pool, resp.State, resp.Percent, resp.TargetPercent, resp.Baseline)
+		fmt.Printf("%-8s  %-10s  %-10s  %-10s  %s\n", "PERCENT", "REQUESTS", "ERRORS", "P99", "RESULT")
+		for _, st := range resp.Steps {
+			result := "failed"
+			if st.Passed {
+				result = "passed"
+			}
+			fmt.Printf("%-8s  %-10d  %-10s  %-10s  %s\n", strconv.Itoa(st.Percent)+"%", st.Requests,
+				strconv.FormatFloat(st.ErrorRate*100, 'f', 2, 64)+"%", p99(st.P99LatencyMs), result)
+		}
+		if c := resp.Current; c != nil {
+			fmt.Printf("%-8s  %-10d  %-10s  %-10s  %s\n", strconv.Itoa(resp.Percent)+"%", c.Requests,
+				strconv.FormatFloat(c.ErrorRate*100, 'f', 2, 64)+"%", p99(c.P99LatencyMs), "analysing, steps every "+resp.StepInterval)
+		}
+		if resp.Reason != "" {
+			fmt.Printf("reason: %s\n", resp.Reason)
+		}
+		if resp.LastError != "" {
+			fmt.Printf("last error: %s\n", resp.LastError)
+		}
+		if resp.Version != "" {
+			fmt.Printf("config version %s\n", resp.Version)
+		}
+	case 401:
+		die("unauthorized: set AEGIS_API_TOKEN")
+	default:
+		die("server returned %d: %s", code, data)
+	}
+}
+
+func cmdLogLevel(baseURL, token string, args []string) {
+	var body map[string]any
+	for i := 0; i < len(args); i++ {
+		switch {
+		case (args[i] == "--revert-after" || args[i] == "-revert-after") && i+1 < len(args):
+			m, err := strconv.ParseFloat(args[i+1], 64)
+			if err != nil || m <= 0 {
+				die("invalid --revert-after: %s", args[i+1])
+			}
+			if body == nil {
+				body = map[string]any{}
+			}
+			body["revert_after_secs"] = m * 60
+			i++
+		case !strings.HasPrefix(args[i], "-"):
+			if body == nil {
+				body = map[string]any{}
+			}
+			body["level"] = args[i]
+		default:
+			die("usage: aegis-ctl loglevel [debug|info|warn|error] [--revert-after MIN]")
+		}
+	}
+	method, reqBody := "GET", interface{}(nil)
+	if body != nil {
+		if _, ok := body["level"]; !ok {
+			die("usage: aegis-ctl loglevel [debug|info|warn|error] [--revert-after MIN]")
+		}
+		method, reqBody = "PUT", body
+	}
+
+	data, code := request(method, baseURL+"/api/v1/loglevel", token, reqBody)
+	switch code {
+	case 200:
+		var resp struct {
+			Level    string     `json:"level"`
+			RevertAt *time.Time `json:"revert_at"`
+			RevertTo string     `json:"revert_to"`
+		}
+		must(json.Unmarshal(data, &resp))
+		if resp.RevertAt != nil {
+			fmt.Printf("log level %s, reverting to %s at %s\n", resp.Level, resp.RevertTo, resp.RevertAt.Local().Format(time.RFC3339))
+		} else {
+			fmt.Printf("log level %s\n", resp.Level)
+		}
+	case 401:
+		die("unauthorized: set AEGIS_API_TOKEN")
+	default:
+		die("server returned %d: %s", code, data)
+	}
+}
+
+func cmdCircuits(baseURL, token string, args []string) {
+	if len(args) > 0 {
+		if args[0] != "reset" || len(args) != 2 {
+			die("usage: aegis-ctl circuits [reset <addr>]")
+		}
+		data, code := request("POST", baseURL+"/api/v1/circuit-breakers/"+url.PathEscape(args[1])+"/reset", token, nil)
+		switch code {
+		case 200:
+			fmt.Printf("circuit breaker for %s closed\n", args[1])
+		case 401:
+			die("unauthorized: set AEGIS_API_TOKEN")
+		case 404:
+			die("backend not found: %s", args[1])
+		default:
+			die("server returned %d: %s", code, data)
+		}
+		return
+	}
+
+	data, code := request("GET", baseURL+"/api/v1/circuit-breakers", token, nil)
+	if code == 401 {
+		die("unauthorized: set AEGIS_API_TOKEN")
+	}
+	if code != 200 {
+		die("server returned %d: %s", code, data)
+	}
+	var resp struct {
+		Breakers []struct {
+			Address      string     `json:"address"`
+			State        string     `json:"state"`
+			FailureCount *int64     `json:"failure_count"`
+			OpenedAt     *time.Time `json:"opened_at"`
+		} `json:"breakers"`
+	}
+	must(json.Unmarshal(data, &resp))
+	fmt.Printf("%-30s %-10s %-9s %s\n", "BACKEND", "STATE", "FAILURES", "OPENED")
+	for _, b := range resp.Breakers {
+		failures, opened := "-", "-"
+		if b.FailureCount != nil {
+			failures = fmt.Sprint(*b.FailureCount)
+		}
+		if b.OpenedAt != nil {
+			opened = b.OpenedAt.Local().Format(time.DateTime)
+		}
+		fmt.Printf("%-30s %-10s %-9s %s\n", b.Address, b.State, failures, opened)
+	}
+}
+
+func cmdConnections(baseURL, token string, args []string) {
+	if len(args) > 0 && args[0] == "close" {
+		if len(args) != 2 {
+			die("usage: aegis-ctl connections close <id>")
+		}
+		data, code := request("DELETE", baseURL+"/api/v1/connections/"+url.PathEscape(args[1]), token, nil)
+		switch code {
+		case 200:
+			fmt.Printf("connection %s closed\n", args[1])
+		case 401:
+			die("unauthorized: set AEGIS_API_TOKEN")
+		case 404:
+			die("no active connection %s", args[1])
+		default:
+			die("server returned %d: %s", code, data)
+		}
+		return
+	}
+
+	query := url.Values{}
+	for i := 0; i < len(args); i++ {
+		if i+1 >= len(args) {
+			die("usage: aegis-ctl connections [--backend ADDR] [--client-ip IP]")
+		}
+		switch args[i] {
+		case "--backend":
+			query.Set("backend", args[i+1])
+		case "--client-ip":
+			query.Set("client_ip", args[i+1])
+		default:
+			die("unknown flag: %s", args[i])
+		}
+		i++
+	}
+	target := baseURL + "/api/v1/connections"
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	data, code := request("GET", target, token, nil)
+	if code == 401 {
+		die("unauthorized: set AEGIS_API_TOKEN")
+	}
+	if code != 200 {
+		die("server returned %d: %s", code, data)
+	}
+	var resp struct {
+		Connections []struct {
+			ID      uint64 `json:"id"`
+			Client  string `json:"client"`
+			Backend string `json:"backend"`
+			AgeMs   int64  `json:"age_ms"`
+		} `json:"connections"`
+	}
+	must(json.Unmarshal(data, &resp))
+	fmt.Printf("%-8s %-24s %-30s %s\n", "ID", "CLIENT", "BACKEND", "AGE")
+	for _, c := range resp.Connections {
+		backend := c.Backend
+		if backend == "" {
+			backend = "-"
+		}
+		age := (time.Duration(c.AgeMs) * time.Millisecond).Round(time.Second)
+		fmt.Printf("%-8d %-24s %-30s %s\n", c.ID, c.Client, backend, age)
+	}
+}
+
 func request(method, rawURL, token string, body interface{}) ([]byte, int) {
+	return requestIfMatch(method, rawURL, token, body, "")
+}
+
+// requestIfMatch is request with an If-Match header when version is set.
+func requestIfMatch(method, rawURL, token string, body interface{}, version string) ([]byte, int) {
 	var bodyReader io.Reader
 	if body != nil {
 		b, err := json.Marshal(body)
@@ -186,6 +1057,9 @@ func request(method, rawURL, token string, body interface{}) ([]byte, int) {
 	if body != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
+	if version != "" {
+		req.Header.Set("If-Match", `"`+strings.Trim(version, `"`)+`"`)
+	}
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
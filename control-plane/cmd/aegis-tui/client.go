@@ -52,7 +52,7 @@ type DataPlaneStats struct {
 
 func fetchStatus(ctx context.Context, client *http.Client, baseURL string) (Status, error) {
 	var status Status
-	if err := getJSON(ctx, client, baseURL+"/status", &status); err != nil {
+	if err := getJSON(ctx, client, baseURL+"/api/v1/status", &status); err != nil {
 		return Status{}, err
 	}
 	return status, nil
@@ -63,7 +63,7 @@ func fetchBackends(ctx context.Context, client *http.Client, baseURL string) ([]
 		Backends    []Backend `json:"backends"`
 		UDPBackends []Backend `json:"udp_backends"`
 	}
-	if err := getJSON(ctx, client, baseURL+"/backends", &resp); err != nil {
+	if err := getJSON(ctx, client, baseURL+"/api/v1/backends", &resp); err != nil {
 		return nil, nil, err
 	}
 	return resp.Backends, resp.UDPBackends, nil
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// client calls one control plane's admin API.
+type client struct {
+	target
+	http *http.Client
+}
+
+func newClient(t target) (*client, error) {
+	c := &client{target: t, http: &http.Client{Timeout: 60 * time.Second}}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca_file %s holds no PEM certificates", t.CAFile)
+		}
+		c.http.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	}
+	return c, nil
+}
+
+// apiError is a response other than 2xx, with the error body the admin API
+// sends when there is one.
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *apiError) Error() string {
+	switch {
+	case e.Status == http.StatusUnauthorized:
+		return "unauthorized: pass --token, or set a token in the profile or AEGIS_API_TOKEN"
+	case e.Status == http.StatusPreconditionRequired:
+		return "the server requires --if-match: pass the version from aegisctl status or the ETag of GET /config"
+	case e.Code == "version_conflict":
+		return "config changed since that version; re-read it and retry: " + e.Message
+	case e.Code != "":
+		return fmt.Sprintf("server returned %d (%s): %s", e.Status, e.Code, e.Message)
+	}
+	return fmt.Sprintf("server returned %d: %s", e.Status, e.Message)
+}
+
+// request is one call to the admin API. Body is sent as is with
+// contentType, or JSON-encoded when it's anything but []byte.
+type request struct {
+	method      string
+	path        string
+	body        any
+	contentType string
+	ifMatch     string
+	accept      string
+}
+
+// do sends req and returns the body of a 2xx response, or an *apiError.
+func (c *client) do(req request) ([]byte, error) {
+	var body io.Reader
+	contentType := req.contentType
+	switch b := req.body.(type) {
+	case nil:
+	case []byte:
+		body = bytes.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			return nil, err
+		}
+		body, contentType = bytes.NewReader(data), "application/json"
+	}
+	r, err := http.NewRequest(req.method, strings.TrimSuffix(c.URL, "/")+"/api/v1"+req.path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		r.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	if req.accept != "" {
+		r.Header.Set("Accept", req.accept)
+	}
+	if req.ifMatch != "" {
+		r.Header.Set("If-Match", `"`+strings.Trim(req.ifMatch, `"`)+`"`)
+	}
+
+	resp, err := c.http.Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("connection failed: %w\n  is aegis running at %s?", err, c.URL)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return data, nil
+	}
+	apiErr := &apiError{Status: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+	var errBody struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &errBody) == nil && errBody.Error.Code != "" {
+		apiErr.Code, apiErr.Message = errBody.Error.Code, errBody.Error.Message
+	}
+	return nil, apiErr
+}
+
+// get fetches path, decoding the response into out, and returns the raw
+// body for JSON output.
+func (c *client) get(path string, out any) ([]byte, error) {
+	data, err := c.do(request{method: http.MethodGet, path: path})
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", path, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,588 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// The types below are the parts of the admin API's responses aegisctl
+// shows in tables; -o json prints the responses whole.
+
+type statusResponse struct {
+	Version string `json:"version"`
+	Config  struct {
+		Backends       int    `json:"backends"`
+		Algorithm      string `json:"algorithm"`
+		RateLimitRPS   int    `json:"rate_limit_rps"`
+		RateLimitBurst int    `json:"rate_limit_burst"`
+	} `json:"config"`
+	DataPlane *struct {
+		Version     string   `json:"version"`
+		Features    []string `json:"features"`
+		Legacy      bool     `json:"legacy"`
+		Unsupported []string `json:"unsupported"`
+	} `json:"data_plane"`
+	HA *struct {
+		Identity string `json:"identity"`
+		Leader   string `json:"leader"`
+		Leading  bool   `json:"leading"`
+	} `json:"ha"`
+	Applied *struct {
+		Version   string    `json:"version"`
+		AppliedAt time.Time `json:"applied_at"`
+		Replica   string    `json:"replica"`
+	} `json:"applied"`
+}
+
+type backend struct {
+	Address           string `json:"address"`
+	Weight            int    `json:"weight"`
+	Pool              string `json:"pool"`
+	Priority          int    `json:"priority"`
+	Backup            bool   `json:"backup"`
+	Zone              string `json:"zone"`
+	Region            string `json:"region"`
+	Healthy           bool   `json:"healthy"`
+	CircuitState      string `json:"circuit_state"`
+	ActiveConnections int64  `json:"active_connections"`
+	TotalRequests     int64  `json:"total_requests"`
+}
+
+type backendList struct {
+	Backends    []backend `json:"backends"`
+	UDPBackends []backend `json:"udp_backends"`
+}
+
+type topEntry struct {
+	Key       string  `json:"key"`
+	Requests  int64   `json:"requests"`
+	Errors    int64   `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	Bytes     int64   `json:"bytes"`
+}
+
+type topRankings struct {
+	ByTraffic   []topEntry `json:"by_traffic"`
+	ByErrorRate []topEntry `json:"by_error_rate"`
+}
+
+type topResponse struct {
+	Window           string      `json:"window"`
+	Backends         topRankings `json:"backends"`
+	Clients          topRankings `json:"clients"`
+	UntrackedClients int64       `json:"untracked_clients"`
+}
+
+func cmdStatus(e *env, args []string) error {
+	fs := e.flags("status")
+	if _, err := e.parse(fs, args, 0); err != nil {
+		return err
+	}
+	c, out, err := e.connect()
+	if err != nil {
+		return err
+	}
+	var status statusResponse
+	data, err := c.get("/status", &status)
+	if err != nil {
+		return err
+	}
+	if out.json {
+		return out.raw(data)
+	}
+
+	controlPlane := c.URL
+	if c.Profile != "" {
+		controlPlane += " (profile " + c.Profile + ")"
+	}
+	rateLimit := "off"
+	if status.Config.RateLimitRPS > 0 {
+		rateLimit = fmt.Sprintf("%d/s, burst %d", status.Config.RateLimitRPS, status.Config.RateLimitBurst)
+	}
+	dataPlane := "not connected"
+	if dp := status.DataPlane; dp != nil {
+		dataPlane = orDash(dp.Version)
+		switch {
+		case dp.Legacy:
+			dataPlane += " (predates the handshake)"
+		case len(dp.Unsupported) > 0:
+			dataPlane += " (lacks " + strings.Join(dp.Unsupported, ", ") + ")"
+		}
+	}
+	fields := [][2]string{
+		{"CONTROL PLANE", controlPlane},
+		{"VERSION", status.Version},
+		{"BACKENDS", strconv.Itoa(status.Config.Backends)},
+		{"ALGORITHM", status.Config.Algorithm},
+		{"RATE LIMIT", rateLimit},
+		{"DATA PLANE", dataPlane},
+	}
+	if ha := status.HA; ha != nil {
+		leader := orDash(ha.Leader)
+		if ha.Leading {
+			leader += " (this replica)"
+		}
+		fields = append(fields, [2]string{"REPLICA", ha.Identity}, [2]string{"LEADER", leader})
+	}
+	if a := status.Applied; a != nil {
+		applied := a.Version + " at " + a.AppliedAt.Local().Format(time.DateTime)
+		if a.Replica != "" {
+			applied += " by " + a.Replica
+		}
+		fields = append(fields, [2]string{"APPLIED", applied})
+	}
+	return out.fields(fields)
+}
+
+func cmdBackendsList(e *env, args []string) error {
+	fs := e.flags("backends list")
+	pool := fs.String("pool", "", "only the backends in this pool")
+	if _, err := e.parse(fs, args, 0); err != nil {
+		return err
+	}
+	c, out, err := e.connect()
+	if err != nil {
+		return err
+	}
+	var list backendList
+	data, err := c.get("/backends", &list)
+	if err != nil {
+		return err
+	}
+	if out.json && *pool == "" {
+		return out.raw(data)
+	}
+
+	var rows [][]string
+	shown := backendList{Backends: []backend{}, UDPBackends: []backend{}}
+	for _, set := range []struct {
+		proto    string
+		backends []backend
+		keep     *[]backend
+	}{{"tcp", list.Backends, &shown.Backends}, {"udp", list.UDPBackends, &shown.UDPBackends}} {
+		for _, b := range set.backends {
+			if *pool != "" && b.Pool != *pool {
+				continue
+			}
+			*set.keep = append(*set.keep, b)
+			health := "healthy"
+			if !b.Healthy {
+				health = "unhealthy"
+			}
+			priority := strconv.Itoa(b.Priority)
+			if b.Backup {
+				priority = "backup"
+			}
+			placement := strings.Trim(b.Region+"/"+b.Zone, "/")
+			rows = append(rows, []string{b.Address, set.proto, strconv.Itoa(b.Weight), orDash(b.Pool), priority,
+				orDash(placement), health, orDash(b.CircuitState), strconv.FormatInt(b.ActiveConnections, 10)})
+		}
+	}
+	if out.json {
+		return out.value(shown)
+	}
+	return out.table([]string{"ADDRESS", "PROTO", "WEIGHT", "POOL", "PRIORITY", "ZONE", "HEALTH", "CIRCUIT", "CONNS"}, rows)
+}
+
+func cmdBackendsAdd(e *env, args []string) error {
+	fs := e.flags("backends add")
+	weight := fs.Int("w", 100, "weight")
+	fs.IntVar(weight, "weight", 100, "weight")
+	pool := fs.String("pool", "", "pool for the traffic split")
+	priority := fs.Int("priority", 0, "failover tier, 0 first")
+	backup := fs.Bool("backup", false, "only take traffic when every primary is down")
+	zone := fs.String("zone", "", "zone for locality-aware balancing")
+	region := fs.String("region", "", "region for locality-aware balancing")
+	persist := fs.Bool("persist", false, "also save the change to the config file")
+	ifMatch := fs.String("if-match", "", "apply only if the config version is still this")
+	pos, err := e.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if *weight <= 0 {
+		return fmt.Errorf("invalid weight: %d", *weight)
+	}
+	c, out, err := e.connect()
+	if err != nil {
+		return err
+	}
+	body := map[string]any{"address": pos[0], "weight": *weight, "pool": *pool, "priority": *priority,
+		"backup": *backup, "zone": *zone, "region": *region}
+	data, err := c.do(request{method: http.MethodPost, path: "/backends" + persistQuery(*persist), body: body, ifMatch: *ifMatch})
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusConflict && apiErr.Code != "version_conflict" {
+		return fmt.Errorf("backend already exists: %s", pos[0])
+	}
+	if err != nil {
+		return err
+	}
+	if out.json {
+		return out.raw(data)
+	}
+	_, err = fmt.Fprintf(out.w, "added %s (weight %d)\n", pos[0], *weight)
+	return err
+}
+
+func cmdBackendsDrain(e *env, args []string) error {
+	fs := e.flags("backends drain")
+	persist := fs.Bool("persist", false, "also save the change to the config file")
+	ifMatch := fs.String("if-match", "", "apply only if the config version is still this")
+	pos, err := e.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	c, out, err := e.connect()
+	if err != nil {
+		return err
+	}
+	path := "/backends/" + url.PathEscape(pos[0])
+	var b backend
+	_, err = c.get(path, &b)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+		return fmt.Errorf("backend not found: %s", pos[0])
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := c.do(request{method: http.MethodPatch, path: path + persistQuery(*persist), body: map[string]int{"weight": 0}, ifMatch: *ifMatch}); err != nil {
+		return err
+	}
+	if out.json {
+		return out.value(map[string]any{"address": pos[0], "weight": 0, "previous_weight": b.Weight,
+			"active_connections": b.ActiveConnections})
+	}
+	_, err = fmt.Fprintf(out.w, "draining %s: weight 0 (was %d), %d connections still open\n", pos[0], b.Weight, b.ActiveConnections)
+	return err
+}
+
+// persistQuery asks the server to save a backend change to its config file.
+func persistQuery(persist bool) string {
+	if persist {
+		return "?persist=true"
+	}
+	return ""
+}
+
+func cmdConfigValidate(e *env, args []string) error {
+	fs := e.flags("config validate")
+	local := fs.Bool("local", false, "only check the file here, not with the data plane")
+	pos, err := e.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	out, err := newOutput(e.format, e.stdout)
+	if err != nil {
+		return err
+	}
+	data, cfg, err := readConfig(pos[0])
+	if err != nil {
+		return err
+	}
+	result := map[string]any{"file": pos[0], "valid": true, "version": cfg.Version(), "checked_by": "aegisctl"}
+	if !*local {
+		c, _, err := e.connect()
+		if err != nil {
+			return err
+		}
+		_, err = c.do(request{method: http.MethodPut, path: "/config?dry_run=true", body: data, contentType: "application/yaml"})
+		var apiErr *apiError
+		switch {
+		case errors.As(err, &apiErr) && apiErr.Code == "not_supported":
+			fmt.Fprintln(os.Stderr, "warning: the control plane can't dry-run configs in this mode; only checked here")
+		case err != nil:
+			return fmt.Errorf("%s: %w", pos[0], err)
+		default:
+			result["checked_by"] = "data_plane"
+		}
+	}
+	if out.json {
+		return out.value(result)
+	}
+	how := "the data plane accepts it"
+	if result["checked_by"] == "aegisctl" {
+		how = "checked locally"
+	}
+	_, err = fmt.Fprintf(out.w, "%s is valid (%s)\n", pos[0], how)
+	return err
+}
+
+func cmdConfigApply(e *env, args []string) error {
+	fs := e.flags("config apply")
+	ifMatch := fs.String("if-match", "", "apply only if the running config version is still this")
+	pos, err := e.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	data, _, err := readConfig(pos[0])
+	if err != nil {
+		return err
+	}
+	c, out, err := e.connect()
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(request{method: http.MethodPut, path: "/config", body: data, contentType: "application/yaml", ifMatch: *ifMatch})
+	if err != nil {
+		return err
+	}
+	if out.json {
+		return out.raw(resp)
+	}
+	var applied struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(resp, &applied); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out.w, "applied %s: version %s\n", pos[0], applied.Version)
+	return err
+}
+
+func cmdConfigDiff(e *env, args []string) error {
+	fs := e.flags("config diff")
+	pos, err := e.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	_, cfg, err := readConfig(pos[0])
+	if err != nil {
+		return err
+	}
+	// Both sides are rendered the way GET /config renders the running
+	// config, so formatting, comments and defaults left out of the file
+	// don't show up as differences, nor do the credentials it redacts
+	local, err := yaml.Marshal(cfg.Redacted())
+	if err != nil {
+		return err
+	}
+	c, out, err := e.connect()
+	if err != nil {
+		return err
+	}
+	running, err := c.do(request{method: http.MethodGet, path: "/config?format=yaml", accept: "application/yaml"})
+	if err != nil {
+		return err
+	}
+	version, running := splitHeader(running)
+
+	diff := unifiedDiff("running (version "+version+")", pos[0], string(running), string(local))
+	if out.json {
+		return out.value(map[string]any{"running_version": version, "differs": diff != "", "diff": diff})
+	}
+	if diff == "" {
+		diff = "no differences from the running config\n"
+	}
+	_, err = fmt.Fprint(out.w, diff)
+	return err
+}
+
+// splitHeader takes the "# version:" and "# applied_at:" comments off the
+// top of GET /config?format=yaml, returning the version.
+func splitHeader(data []byte) (string, []byte) {
+	var version string
+	for bytes.HasPrefix(data, []byte("# ")) {
+		line, rest, _ := bytes.Cut(data, []byte("\n"))
+		if v, ok := bytes.CutPrefix(line, []byte("# version: ")); ok {
+			version = string(v)
+		}
+		data = rest
+	}
+	return version, data
+}
+
+// readConfig reads and parses the config file at path, the checks the
+// control plane makes on loading it included.
+func readConfig(path string) ([]byte, *config.Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg, err := config.Parse(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return data, cfg, nil
+}
+
+func cmdMetricsTop(e *env, args []string) error {
+	fs := e.flags("metrics top")
+	n := fs.Int("n", 10, "entries per ranking, up to 100")
+	by := fs.String("by", "traffic", "rank by traffic or errors")
+	if _, err := e.parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *by != "traffic" && *by != "errors" {
+		return fmt.Errorf(`--by must be "traffic" or "errors", got %q`, *by)
+	}
+	c, out, err := e.connect()
+	if err != nil {
+		return err
+	}
+	var top topResponse
+	data, err := c.get("/top?n="+strconv.Itoa(*n), &top)
+	if err != nil {
+		return err
+	}
+	if out.json {
+		return out.raw(data)
+	}
+
+	header := []string{"", "REQUESTS", "ERRORS", "ERROR RATE", "BYTES"}
+	for i, ranking := range []struct {
+		name    string
+		ranking topRankings
+	}{{"BACKEND", top.Backends}, {"CLIENT", top.Clients}} {
+		entries := ranking.ranking.ByTraffic
+		if *by == "errors" {
+			entries = ranking.ranking.ByErrorRate
+		}
+		var rows [][]string
+		for _, t := range entries {
+			rows = append(rows, []string{t.Key, strconv.FormatInt(t.Requests, 10), strconv.FormatInt(t.Errors, 10),
+				strconv.FormatFloat(100*t.ErrorRate, 'f', 1, 64) + "%", formatBytes(t.Bytes)})
+		}
+		if i > 0 {
+			fmt.Fprintln(out.w)
+		}
+		header[0] = ranking.name
+		if err := out.table(header, rows); err != nil {
+			return err
+		}
+	}
+	if top.UntrackedClients > 0 {
+		fmt.Fprintf(out.w, "\n%d connections from clients beyond those tracked\n", top.UntrackedClients)
+	}
+	_, err = fmt.Fprintf(out.w, "\nover the last %s, by %s\n", top.Window, *by)
+	return err
+}
+
+// formatBytes is n in the largest binary unit it has one of.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func cmdProfilesList(e *env, args []string) error {
+	fs := e.flags("profiles list")
+	if _, err := e.parse(fs, args, 0); err != nil {
+		return err
+	}
+	out, err := newOutput(e.format, e.stdout)
+	if err != nil {
+		return err
+	}
+	profiles, err := loadProfiles(profilesPath())
+	if err != nil {
+		return err
+	}
+	if out.json {
+		return out.value(profiles)
+	}
+	var rows [][]string
+	for _, name := range profiles.names() {
+		p := profiles.Profiles[name]
+		current := ""
+		if name == profiles.Current {
+			current = "*"
+		}
+		auth := "none"
+		switch {
+		case p.TokenEnv != "":
+			auth = "$" + p.TokenEnv
+		case p.Token != "":
+			auth = "token"
+		}
+		rows = append(rows, []string{current, name, p.URL, auth})
+	}
+	return out.table([]string{"", "NAME", "URL", "TOKEN"}, rows)
+}
+
+func cmdProfilesSet(e *env, args []string) error {
+	fs := e.flags("profiles set")
+	// --url and --token are the global flags, here saved to the profile
+	tokenEnv := fs.String("token-env", "", "environment variable to read the token from")
+	caFile := fs.String("ca-file", "", "CA certificate to verify the admin API with")
+	pos, err := e.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	path := profilesPath()
+	profiles, err := loadProfiles(path)
+	if err != nil {
+		return err
+	}
+	p, existed := profiles.Profiles[pos[0]]
+	if e.url != "" {
+		p.URL = e.url
+	}
+	if p.URL == "" {
+		return errors.New("a new profile needs --url")
+	}
+	if e.token != "" {
+		p.Token, p.TokenEnv = e.token, ""
+	}
+	if *tokenEnv != "" {
+		p.TokenEnv, p.Token = *tokenEnv, ""
+	}
+	if *caFile != "" {
+		p.CAFile = *caFile
+	}
+	if profiles.Profiles == nil {
+		profiles.Profiles = make(map[string]Profile)
+	}
+	profiles.Profiles[pos[0]] = p
+	if profiles.Current == "" {
+		profiles.Current = pos[0]
+	}
+	if err := profiles.save(path); err != nil {
+		return err
+	}
+	verb := "added"
+	if existed {
+		verb = "updated"
+	}
+	_, err = fmt.Fprintf(e.stdout, "%s profile %s in %s\n", verb, pos[0], path)
+	return err
+}
+
+func cmdProfilesUse(e *env, args []string) error {
+	fs := e.flags("profiles use")
+	pos, err := e.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	path := profilesPath()
+	profiles, err := loadProfiles(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := profiles.Profiles[pos[0]]; !ok {
+		return fmt.Errorf("no profile %q in %s", pos[0], path)
+	}
+	profiles.Current = pos[0]
+	if err := profiles.save(path); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(e.stdout, "using profile %s\n", pos[0])
+	return err
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is how many unchanged lines a hunk shows around a change.
+const diffContext = 3
+
+// diffLine is one line of a diff: ' ' kept, '-' only in the old text, '+'
+// only in the new one.
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// diffLines lines up a and b by their longest common subsequence. Configs
+// are a few hundred lines, so the quadratic table is no concern.
+func diffLines(a, b []string) []diffLine {
+	// lcs[i][j] is the length of the LCS of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var out []diffLine
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, diffLine{' ', a[i]})
+			i, j = i+1, j+1
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, diffLine{'-', a[i]})
+			i++
+		default:
+			out = append(out, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		out = append(out, diffLine{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		out = append(out, diffLine{'+', b[j]})
+	}
+	return out
+}
+
+// unifiedDiff is the diff from old to new in unified format, "" when
+// they're the same.
+func unifiedDiff(oldName, newName, old, new string) string {
+	lines := diffLines(splitLines(old), splitLines(new))
+	// oldAt[k] and newAt[k] are the line numbers, from 1, lines[k] is at
+	// or would be at
+	oldAt, newAt := make([]int, len(lines)+1), make([]int, len(lines)+1)
+	oldAt[0], newAt[0] = 1, 1
+	for k, l := range lines {
+		oldAt[k+1], newAt[k+1] = oldAt[k], newAt[k]
+		if l.op != '+' {
+			oldAt[k+1]++
+		}
+		if l.op != '-' {
+			newAt[k+1]++
+		}
+	}
+
+	var b strings.Builder
+	for k := 0; k < len(lines); k++ {
+		if lines[k].op == ' ' {
+			continue
+		}
+		// A hunk runs from diffContext lines before a change to diffContext
+		// after the last change, taking in every change whose context would
+		// overlap or touch it: those at most 2*diffContext unchanged lines
+		// after the last
+		start, end := max(0, k-diffContext), k
+		for last := k; end < len(lines) && end-last-1 <= 2*diffContext; end++ {
+			if lines[end].op != ' ' {
+				last = end
+			}
+		}
+		for end > k+1 && lines[end-1].op == ' ' && end-1-lastChange(lines[:end]) > diffContext {
+			end--
+		}
+		if b.Len() == 0 {
+			fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n",
+			hunkRange(oldAt[start], oldAt[end]-oldAt[start]), hunkRange(newAt[start], newAt[end]-newAt[start]))
+		for _, l := range lines[start:end] {
+			b.WriteByte(l.op)
+			b.WriteString(l.text)
+			b.WriteByte('\n')
+		}
+		k = end - 1
+	}
+	return b.String()
+}
+
+// lastChange is the index of the last line of lines that isn't kept.
+func lastChange(lines []diffLine) int {
+	i := len(lines) - 1
+	for i > 0 && lines[i].op == ' ' {
+		i--
+	}
+	return i
+}
+
+// hunkRange is a hunk header's start,count; an empty range starts at the
+// line before it, as diff -u has it.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		start--
+	}
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	var old []string
+	for c := 'a'; c <= 't'; c++ {
+		old = append(old, "line "+string(c))
+	}
+	changed := append([]string(nil), old...)
+	changed[1] = "changed b"
+	// Three lines after the first change, so in the same hunk
+	changed[5] = "changed f"
+	// Far enough from the others for a hunk of its own
+	changed = append(changed[:16], changed[17:]...)
+	changed = append(changed, "added")
+
+	got := unifiedDiff("old", "new", strings.Join(old, "\n")+"\n", strings.Join(changed, "\n")+"\n")
+	want := `--- old
++++ new
+@@ -1,9 +1,9 @@
+ line a
+-line b
++changed b
+ line c
+ line d
+ line e
+-line f
++changed f
+ line g
+ line h
+ line i
+@@ -14,7 +14,7 @@
+ line n
+ line o
+ line p
+-line q
+ line r
+ line s
+ line t
++added
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if d := unifiedDiff("old", "new", "a\nb\n", "a\nb\n"); d != "" {
+		t.Errorf("same text: got %q", d)
+	}
+	if d := unifiedDiff("old", "new", "", "a\n"); d != "--- old\n+++ new\n@@ -0,0 +1 @@\n+a\n" {
+		t.Errorf("from nothing: got %q", d)
+	}
+}
+
+func TestUnifiedDiff_MergesHunksWithTouchingContext(t *testing.T) {
+	var old []string
+	for i := range 20 {
+		old = append(old, fmt.Sprint("line ", i))
+	}
+	for _, tc := range []struct {
+		// gap is how many unchanged lines are between the two changes
+		gap   int
+		hunks []string
+	}{
+		{gap: 2*diffContext - 1, hunks: []string{"@@ -1,11 +1,11 @@"}},
+		{gap: 2 * diffContext, hunks: []string{"@@ -1,12 +1,12 @@"}},
+		{gap: 2*diffContext + 1, hunks: []string{"@@ -1,5 +1,5 @@", "@@ -7,7 +7,7 @@"}},
+	} {
+		changed := append([]string(nil), old...)
+		changed[1] = "changed"
+		changed[2+tc.gap] = "changed"
+		got := unifiedDiff("old", "new", strings.Join(old, "\n")+"\n", strings.Join(changed, "\n")+"\n")
+		var hunks []string
+		for _, line := range strings.Split(got, "\n") {
+			if strings.HasPrefix(line, "@@") {
+				hunks = append(hunks, line)
+			}
+		}
+		if !slices.Equal(hunks, tc.hunks) {
+			t.Errorf("gap of %d: got hunks %q, want %q", tc.gap, hunks, tc.hunks)
+		}
+	}
+}
+
+func TestSplitHeader(t *testing.T) {
+	version, rest := splitHeader([]byte("# version: 0a1b\n# applied_at: 2026-10-14T10:00:00Z\nproxy:\n    # kept\n"))
+	if version != "0a1b" || string(rest) != "proxy:\n    # kept\n" {
+		t.Errorf("got %q, %q", version, rest)
+	}
+}
@@ -0,0 +1,171 @@
+// aegisctl is a client for the control plane's admin API that knows
+// several control planes by profile and prints tables for people or JSON
+// for scripts. aegis-ctl remains the quick tool for one control plane,
+// covering every runtime knob; aegisctl covers the day-to-day commands.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "error: "+err.Error())
+		}
+		os.Exit(1)
+	}
+}
+
+// command is one aegisctl command, named by one or two words.
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(e *env, args []string) error
+}
+
+var commands = []command{
+	{"status", "", "The control plane's version, config, data plane and leader", cmdStatus},
+	{"backends list", "[--pool P]", "Backends with weight, placement and health", cmdBackendsList},
+	{"backends add", "ADDR [-w N] [--pool P] [--priority N] [--backup] [--zone Z] [--region R] [--persist] [--if-match V]",
+		"Add a backend (weight default 100)", cmdBackendsAdd},
+	{"backends drain", "ADDR [--persist] [--if-match V]", "Set a backend's weight to 0 so it gets no new traffic", cmdBackendsDrain},
+	{"config validate", "FILE [--local]", "Check a config file locally, then have the data plane check it", cmdConfigValidate},
+	{"config apply", "FILE [--if-match V]", "Replace the running config with a file", cmdConfigApply},
+	{"config diff", "FILE", "Show how a file differs from the running config", cmdConfigDiff},
+	{"metrics top", "[-n N] [--by traffic|errors]", "Top backends and clients over the metrics window", cmdMetricsTop},
+	{"profiles list", "", "The profiles in the config file", cmdProfilesList},
+	{"profiles set", "NAME --url URL [--token-env VAR] [--ca-file F]", "Add or change a profile", cmdProfilesSet},
+	{"profiles use", "NAME", "Make a profile the current one", cmdProfilesUse},
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: aegisctl [flags] <command> [args]\n\nCommands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-18s %s\n", c.name, c.summary)
+		if c.args != "" {
+			fmt.Fprintf(w, "  %-18s   %s\n", "", c.args)
+		}
+	}
+	fmt.Fprint(w, `
+Flags, before the command or after it:
+  -p, --profile NAME   Control plane to talk to (default: $AEGISCTL_PROFILE,
+                       then the current profile)
+  --url URL            Admin API base URL, overriding the profile's
+  --token TOKEN        Bearer token, overriding the profile's
+  -o table|json        Output format (default table)
+
+Env:
+  AEGISCTL_CONFIG   Profiles file (default: aegis/aegisctl.yaml in the
+                    user config directory)
+  AEGISCTL_PROFILE  Profile to use
+  AEGIS_URL         Admin API base URL without a profile (default:
+                    http://localhost:9090)
+  AEGIS_API_TOKEN   Bearer token without a profile
+`)
+}
+
+func run(args []string, stdout io.Writer) error {
+	e := &env{stdout: stdout}
+	fs := e.flags("aegisctl")
+	fs.Usage = func() { usage(os.Stderr) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	args = fs.Args()
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return flag.ErrHelp
+	}
+	for _, c := range commands {
+		words := strings.Fields(c.name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == c.name {
+			e.usage = strings.TrimSpace("aegisctl " + c.name + " " + c.args)
+			return c.run(e, args[len(words):])
+		}
+	}
+	var known []string
+	for _, c := range commands {
+		if strings.HasPrefix(c.name, args[0]+" ") {
+			known = append(known, strings.TrimPrefix(c.name, args[0]+" "))
+		}
+	}
+	if known != nil {
+		return fmt.Errorf("usage: aegisctl %s <%s> ...", args[0], strings.Join(known, "|"))
+	}
+	usage(os.Stderr)
+	return fmt.Errorf("unknown command: %s", args[0])
+}
+
+// env is what every command shares: the global flags and where output
+// goes.
+type env struct {
+	profile string
+	url     string
+	token   string
+	format  string
+	stdout  io.Writer
+	// usage is the running command's usage line.
+	usage string
+}
+
+// flags returns a flag set for the command name with the global flags in
+// it, so they can come after the command too.
+func (e *env) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&e.profile, "profile", e.profile, "profile to use")
+	fs.StringVar(&e.profile, "p", e.profile, "profile to use")
+	fs.StringVar(&e.url, "url", e.url, "admin API base URL")
+	fs.StringVar(&e.token, "token", e.token, "bearer token")
+	fs.StringVar(&e.format, "o", e.format, "output format: table or json")
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, "Usage: "+e.usage) }
+	return fs
+}
+
+// parse parses args with flags and positional arguments in any order and
+// checks there are want positional ones.
+func (e *env) parse(fs *flag.FlagSet, args []string, want int) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional, args = append(positional, args[0]), args[1:]
+	}
+	if len(positional) != want {
+		fs.Usage()
+		return nil, flag.ErrHelp
+	}
+	return positional, nil
+}
+
+// connect picks the control plane from the flags and profiles.
+func (e *env) connect() (*client, *output, error) {
+	out, err := newOutput(e.format, e.stdout)
+	if err != nil {
+		return nil, nil, err
+	}
+	profiles, err := loadProfiles(profilesPath())
+	if err != nil {
+		return nil, nil, err
+	}
+	t, err := profiles.resolve(e.profile, e.url, e.token)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := newClient(t)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, out, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// output writes a command's result as a table for people or, with -o json,
+// as JSON for scripts.
+type output struct {
+	json bool
+	w    io.Writer
+}
+
+func newOutput(format string, w io.Writer) (*output, error) {
+	switch format {
+	case "", "table":
+		return &output{w: w}, nil
+	case "json":
+		return &output{json: true, w: w}, nil
+	}
+	return nil, fmt.Errorf(`-o must be "table" or "json", got %q`, format)
+}
+
+// raw writes a JSON response from the server, indented.
+func (o *output) raw(data []byte) error {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err := o.w.Write(buf.Bytes())
+	return err
+}
+
+// value writes v as indented JSON.
+func (o *output) value(v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(o.w, "%s\n", data)
+	return err
+}
+
+// table writes rows under header in aligned columns.
+func (o *output) table(header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(o.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// fields writes name and value pairs, one per line, values aligned.
+func (o *output) fields(pairs [][2]string) error {
+	tw := tabwriter.NewWriter(o.w, 0, 0, 2, ' ', 0)
+	for _, p := range pairs {
+		fmt.Fprintf(tw, "%s\t%s\n", p[0], p[1])
+	}
+	return tw.Flush()
+}
+
+// orDash stands in for an empty table cell.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultURL is the admin API a control plane listens on out of the box.
+const defaultURL = "http://localhost:9090"
+
+// Profiles is the aegisctl config file: the control planes it knows and the
+// one it talks to unless told otherwise.
+type Profiles struct {
+	Current  string             `yaml:"current,omitempty"`
+	Profiles map[string]Profile `yaml:"profiles,omitempty"`
+}
+
+// Profile is one control plane's admin API and how to authenticate to it.
+type Profile struct {
+	URL string `yaml:"url"`
+	// Token is the bearer token itself; TokenEnv names an environment
+	// variable to read it from instead, keeping it out of the file.
+	Token    string `yaml:"token,omitempty"`
+	TokenEnv string `yaml:"token_env,omitempty"`
+	// CAFile verifies an admin API served over TLS with a private CA.
+	CAFile string `yaml:"ca_file,omitempty"`
+}
+
+// profilesPath is $AEGISCTL_CONFIG, or aegis/aegisctl.yaml in the user's
+// config directory.
+func profilesPath() string {
+	if path := os.Getenv("AEGISCTL_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "aegisctl.yaml"
+	}
+	return filepath.Join(dir, "aegis", "aegisctl.yaml")
+}
+
+// loadProfiles reads the profiles in path, none if it doesn't exist.
+func loadProfiles(path string) (*Profiles, error) {
+	p := &Profiles{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+// save writes the profiles to path, readable by the user alone since they
+// may hold tokens.
+func (p *Profiles) save(path string) error {
+	data, err := yaml.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// names lists the profiles in order.
+func (p *Profiles) names() []string {
+	names := make([]string, 0, len(p.Profiles))
+	for name := range p.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// target is the control plane a command talks to.
+type target struct {
+	// Profile is empty when no profile was used.
+	Profile string
+	URL     string
+	Token   string
+	CAFile  string
+}
+
+// resolve picks the control plane to talk to. The profile is the one named,
+// else $AEGISCTL_PROFILE, else the current one; without any, $AEGIS_URL and
+// $AEGIS_API_TOKEN apply as they do for aegis-ctl. URL and token flags
+// override whatever the profile says.
+func (p *Profiles) resolve(name, urlFlag, tokenFlag string) (target, error) {
+	name = cmp.Or(name, os.Getenv("AEGISCTL_PROFILE"), p.Current)
+	t := target{URL: cmp.Or(os.Getenv("AEGIS_URL"), defaultURL), Token: os.Getenv("AEGIS_API_TOKEN")}
+	if name != "" {
+		prof, ok := p.Profiles[name]
+		if !ok {
+			return target{}, fmt.Errorf("no profile %q in %s", name, profilesPath())
+		}
+		t = target{Profile: name, URL: prof.URL, Token: prof.Token, CAFile: prof.CAFile}
+		if prof.TokenEnv != "" {
+			t.Token = os.Getenv(prof.TokenEnv)
+		}
+		if t.URL == "" {
+			return target{}, fmt.Errorf("profile %q has no url", name)
+		}
+	}
+	t.URL = cmp.Or(urlFlag, t.URL)
+	t.Token = cmp.Or(tokenFlag, t.Token)
+	return t, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestProfiles_Resolve(t *testing.T) {
+	t.Setenv("AEGIS_URL", "http://env:9090")
+	t.Setenv("AEGIS_API_TOKEN", "env-token")
+	t.Setenv("PROD_TOKEN", "prod-token")
+	p := &Profiles{
+		Current: "staging",
+		Profiles: map[string]Profile{
+			"staging": {URL: "http://staging:9090", Token: "staging-token"},
+			"prod":    {URL: "https://prod:9090", TokenEnv: "PROD_TOKEN", CAFile: "ca.pem"},
+		},
+	}
+	prod := target{Profile: "prod", URL: "https://prod:9090", Token: "prod-token", CAFile: "ca.pem"}
+	for _, tc := range []struct {
+		name                     string
+		profile, env, url, token string
+		want                     target
+	}{
+		{name: "current", want: target{Profile: "staging", URL: "http://staging:9090", Token: "staging-token"}},
+		{name: "named", profile: "prod", want: prod},
+		{name: "from env", env: "prod", want: prod},
+		{name: "flag over env", profile: "staging", env: "prod", want: target{Profile: "staging", URL: "http://staging:9090", Token: "staging-token"}},
+		{name: "flags override", url: "http://other:9090", token: "flag-token",
+			want: target{Profile: "staging", URL: "http://other:9090", Token: "flag-token"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("AEGISCTL_PROFILE", tc.env)
+			got, err := p.resolve(tc.profile, tc.url, tc.token)
+			if err != nil || got != tc.want {
+				t.Errorf("got %+v, %v; want %+v", got, err, tc.want)
+			}
+		})
+	}
+
+	t.Setenv("AEGISCTL_PROFILE", "")
+	if _, err := p.resolve("missing", "", ""); err == nil {
+		t.Error("resolved a missing profile")
+	}
+	none, err := (&Profiles{}).resolve("", "", "")
+	if err != nil || none != (target{URL: "http://env:9090", Token: "env-token"}) {
+		t.Errorf("without profiles: got %+v, %v", none, err)
+	}
+}
+
+func TestProfiles_SaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aegis", "aegisctl.yaml")
+	p, err := loadProfiles(path)
+	if err != nil || len(p.Profiles) != 0 {
+		t.Fatalf("missing file: got %+v, %v", p, err)
+	}
+	p.Current = "prod"
+	p.Profiles = map[string]Profile{"prod": {URL: "https://prod:9090", TokenEnv: "PROD_TOKEN"}}
+	if err := p.save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadProfiles(path)
+	if err != nil || loaded.Current != "prod" || loaded.Profiles["prod"] != p.Profiles["prod"] {
+		t.Errorf("got %+v, %v", loaded, err)
+	}
+}
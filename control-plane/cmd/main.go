@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"net/http"
@@ -10,11 +11,27 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/lazzerex/aegis/control-plane/internal/accesslog"
+	"github.com/lazzerex/aegis/control-plane/internal/acme"
 	"github.com/lazzerex/aegis/control-plane/internal/api"
+	"github.com/lazzerex/aegis/control-plane/internal/audit"
+	"github.com/lazzerex/aegis/control-plane/internal/backendsfile"
+	"github.com/lazzerex/aegis/control-plane/internal/canary"
+	"github.com/lazzerex/aegis/control-plane/internal/certs"
 	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/darklaunch"
+	"github.com/lazzerex/aegis/control-plane/internal/discovery"
+	"github.com/lazzerex/aegis/control-plane/internal/events"
 	"github.com/lazzerex/aegis/control-plane/internal/grpc"
 	"github.com/lazzerex/aegis/control-plane/internal/health"
+	"github.com/lazzerex/aegis/control-plane/internal/leader"
 	"github.com/lazzerex/aegis/control-plane/internal/metrics"
+	"github.com/lazzerex/aegis/control-plane/internal/schedule"
+	"github.com/lazzerex/aegis/control-plane/internal/slowstart"
+	"github.com/lazzerex/aegis/control-plane/internal/store"
+	"github.com/lazzerex/aegis/control-plane/internal/version"
+	"github.com/lazzerex/aegis/control-plane/internal/xds"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
@@ -22,11 +39,31 @@ var (
 	configFile = flag.String("config", "config.yaml", "Path to configuration file")
 )
 
+// dataPlane is what the admin API and health checker push changes through:
+// the gRPC client to aegis-data, or the xDS server when xds.enabled is set.
+type dataPlane interface {
+	UpdateConfig(cfg *config.Config) error
+	ReloadBackendsWithHealth(backends []config.Backend, healthState map[string]bool) error
+	DrainConnections(ctx context.Context, timeoutSeconds int) error
+}
+
 func main() {
+	// Set when the control plane stops for losing leadership, so it's
+	// restarted; deferred first so it runs after every other deferred call
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
 	flag.Parse()
 
-	// Initialize logger
-	logger, err := zap.NewProduction()
+	// Initialize logger. The level can be changed at runtime via
+	// PUT /api/v1/loglevel.
+	logLevel := zap.NewAtomicLevelAt(zap.InfoLevel)
+	zapConfig := zap.NewProductionConfig()
+	zapConfig.Level = logLevel
+	logger, err := zapConfig.Build()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to initialize logger: %v\n", err)
 		os.Exit(1)
@@ -39,79 +76,350 @@ func main() {
 		logger.Fatal("Failed to load configuration", zap.Error(err))
 	}
 
+	build := version.Get()
 	logger.Info("Starting proxy control plane",
 		zap.String("config_file", *configFile),
-		zap.String("version", "0.1.0"))
+		zap.String("version", build.Version),
+		zap.String("commit", build.Commit),
+		zap.String("build_date", build.BuildDate),
+		zap.String("go_version", build.GoVersion))
+
+	// Cancelled once shutdown starts; the data plane streams below run until
+	// then and their deferred cleanups wait for them to exit
+	runCtx, stopRun := context.WithCancel(context.Background())
+	defer stopRun()
 
 	// Initialize metrics
 	metricsCollector := metrics.NewCollector()
+	metrics.ConfigureRuntime(cfg.Metrics.Runtime)
+	// Control plane events for GET /events
+	feed := events.NewFeed()
 
-	// Initialize gRPC client to Rust data plane
-	grpcClient, err := grpc.NewClient(cfg.GRPC, logger)
+	// With ha, this replica only does what changes the data plane once
+	// it's elected leader
+	var elector *leader.Elector
+	if cfg.HA.Enabled {
+		elector, err = leader.New(cfg.HA, logger)
+		if err != nil {
+			logger.Fatal("Failed to set up leader election", zap.Error(err))
+		}
+	}
+
+	var dp dataPlane
+	var grpcClient *grpc.Client
+	var xdsServer *xds.Server
+	if cfg.XDS.Enabled {
+		// Serve xDS to Envoy instead of driving the Rust data plane
+		xdsServer = xds.NewServer(cfg.XDS, logger)
+		dp = xdsServer
+	} else {
+		// Initialize gRPC client to Rust data plane
+		grpcClient, err = grpc.NewClient(cfg.GRPC, logger)
+		if err != nil {
+			logger.Fatal("Failed to create gRPC client", zap.Error(err))
+		}
+		defer grpcClient.Close()
+		dp = grpcClient
+	}
+
+	// Initialize health checker; it's started by the leader
+	healthChecker := health.NewChecker(cfg, dp, logger)
+	healthChecker.SetEventFeed(feed)
+	defer healthChecker.Stop()
+
+	if grpcClient != nil {
+		// Start pulling metrics from data plane, labelled with its address
+		// if it doesn't report an instance ID
+		metricsCollector.SetDefaultInstance(cfg.GRPC.ControlPlaneAddress)
+		metricsCollector.SetBackends(cfg.Proxy.BackendAddresses())
+		metricsCollector.SetSLOs(cfg.Metrics.SLOs)
+		metricsCollector.SetTopWindow(cfg.Metrics.TopWindow)
+		metricsCollector.SetLatencyWindow(cfg.Metrics.LatencyWindow)
+		var metricsStream *grpc.MetricsStream
+		if cfg.Metrics.Transport == "poll" {
+			metricsStream = grpcClient.PollMetrics(runCtx, cfg.Metrics.PollInterval, metricsCollector)
+		} else {
+			metricsStream = grpcClient.StreamMetrics(runCtx, metricsCollector)
+		}
+		defer metricsStream.Stop()
+	}
+
+	// Runtime state that outlives this process, and with ha is shared by
+	// the replicas
+	stateStore, err := store.New(cfg.Store)
 	if err != nil {
-		logger.Fatal("Failed to create gRPC client", zap.Error(err))
+		logger.Fatal("Failed to open the state store", zap.Error(err))
+	}
+	defer stateStore.Close()
+
+	auditLog, err := audit.New(cfg.Admin.Audit, logger)
+	if err != nil {
+		logger.Fatal("Failed to initialize audit log", zap.Error(err))
+	}
+	defer auditLog.Close()
+	if err := auditLog.SetStore(stateStore); err != nil {
+		logger.Warn("Failed to load the audit log from the state store; it starts empty", zap.Error(err))
+	}
+
+	// Initialize REST API
+	apiServer := api.NewServer(cfg, *configFile, dp, healthChecker, metricsCollector, logger)
+	apiServer.SetEventFeed(feed)
+	apiServer.SetAuditLog(auditLog)
+	apiServer.SetStore(stateStore)
+	apiServer.SetLogLevel(logLevel)
+
+	// Apply scheduled overlays through the API server, so they're pushed
+	// and versioned like any other change. It runs without schedules too:
+	// a reload may add some. It and the controllers below are run by the
+	// leader.
+	scheduler := schedule.New(cfg.Scheduler, apiServer, logger)
+	scheduler.SetEventFeed(feed)
+	apiServer.SetScheduler(scheduler)
+	if n := len(cfg.Scheduler.Schedules); n > 0 {
+		logger.Info("Scheduling config overlays", zap.Int("schedules", n), zap.String("timezone", cfg.Scheduler.Location().String()))
+	}
+
+	// Canary rollouts step the traffic split through the API server too,
+	// judging each step on the streamed metrics
+	canaryController := canary.New(apiServer, metricsCollector, logger)
+	canaryController.SetEventFeed(feed)
+	canaryController.SetStore(stateStore)
+	apiServer.SetCanary(canaryController)
+
+	// Backends added by reloads and the backend API are ramped up to their
+	// weight through the API server as well
+	slowStart := slowstart.New(apiServer, logger)
+	slowStart.SetEventFeed(feed)
+	apiServer.SetSlowStart(slowStart)
+
+	// Dark launches are switched on and off as their windows open and
+	// close, by pushing the backends again through the API server
+	darkLaunch := darklaunch.New(apiServer, logger)
+	darkLaunch.SetEventFeed(feed)
+	apiServer.SetDarkLaunch(darkLaunch)
+
+	// Backends found by proxy.discovery join and leave the running config
+	// through the API server too, as backend reloads
+	discoverer := discovery.New(apiServer, logger)
+	apiServer.SetDiscovery(discoverer)
+
+	// and so do the ones in proxy.backends_file, whenever it changes
+	backendsFile := backendsfile.New(apiServer, logger)
+	apiServer.SetBackendsFileWatcher(backendsFile)
+
+	// Certificates in proxy.tls are read from the operator's files or
+	// obtained from the ACME CA, and pushed to the data plane together as
+	// secrets, apart from the config
+	var certFiles *certs.Watcher
+	var certManager *acme.Manager
+	if grpcClient != nil {
+		secrets := certs.NewSecrets(grpcClient)
+		certFiles = certs.NewWatcher(apiServer, secrets.Source("files"), logger)
+		apiServer.SetCertificateWatcher(certFiles)
+		certManager = acme.New(apiServer, secrets.Source("acme"), stateStore, logger)
+		apiServer.SetCertificateManager(certManager)
+	}
+
+	// Every exported metric, with metrics.namespace and const_labels applied
+	gatherer := metrics.NewGatherer(cfg.Metrics, prometheus.DefaultGatherer)
+	fleetGatherer := metrics.NewGatherer(cfg.Metrics, metrics.NewFleetGatherer(prometheus.DefaultGatherer))
+	metricsServer := metrics.NewServer(metricsCollector, gatherer, fleetGatherer)
+
+	// startServers starts the admin API and metrics servers, straight
+	// away on a follower, whose read-only API serves while it waits
+	startServers := func() {
+		apiTLS := serverTLS(runCtx, cfg.Admin.TLS, logger)
+		go func() {
+			logger.Info("Starting admin API", zap.String("address", cfg.Admin.APIAddress), zap.Bool("tls", apiTLS != nil))
+			if err := apiServer.Start(cfg.Admin.APIAddress, apiTLS); err != nil && err != http.ErrServerClosed {
+				logger.Fatal("Admin API server error", zap.Error(err))
+			}
+		}()
+
+		metricsTLS := serverTLS(runCtx, cfg.Admin.MetricsTLS, logger)
+		go func() {
+			logger.Info("Starting metrics server", zap.String("address", cfg.Admin.MetricsAddress), zap.Bool("tls", metricsTLS != nil))
+			if err := metricsServer.Start(cfg.Admin.MetricsAddress, metricsTLS); err != nil && err != http.ErrServerClosed {
+				logger.Fatal("Metrics server error", zap.Error(err))
+			}
+		}()
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	// campaign gets what the election ends with: ErrLost, or nil once
+	// shutdown has released the lease
+	campaign := make(chan error, 1)
+	if elector != nil {
+		apiServer.SetLeadership(elector)
+		startServers()
+		logger.Info("Campaigning for leadership", zap.String("identity", cfg.HA.Identity), zap.String("backend", cfg.HA.Backend))
+		go func() { campaign <- elector.Run(runCtx) }()
+		select {
+		case <-elector.Elected():
+		case <-sigChan:
+			logger.Info("Shutting down gracefully...")
+			stopRun()
+			<-campaign
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			shutdownServers(ctx, apiServer, metricsServer, logger)
+			logger.Info("Shutdown complete")
+			return
+		}
+	}
+
+	// Everything from here on changes the data plane, which only the
+	// leader does
+	if xdsServer != nil {
+		go func() {
+			logger.Info("Starting xDS server", zap.String("address", cfg.XDS.Address))
+			if err := xdsServer.Start(cfg.XDS.Address); err != nil {
+				logger.Fatal("xDS server error", zap.Error(err))
+			}
+		}()
+		defer xdsServer.Stop()
 	}
-	defer grpcClient.Close()
 
 	// Send initial configuration to data plane
-	if err := grpcClient.UpdateConfig(cfg); err != nil {
+	if err := dp.UpdateConfig(cfg); err != nil {
 		logger.Fatal("Failed to send initial config to data plane", zap.Error(err))
 	}
 
-	// Re-push config if the data plane restarts independently and reconnects
-	grpcClient.WatchReconnect()
+	if grpcClient != nil {
+		// Re-push config if the data plane restarts independently and reconnects
+		grpcClient.SetEventFeed(feed)
+		grpcClient.WatchReconnect()
+	}
 
-	// Initialize health checker
-	healthChecker := health.NewChecker(cfg, grpcClient, logger)
 	healthChecker.Start()
-	defer healthChecker.Stop()
 
-	// Start metrics streaming from data plane
-	grpcClient.StreamMetrics(metricsCollector)
+	// Forward data plane access logs to the configured sinks
+	if cfg.AccessLog.Enabled && grpcClient != nil {
+		// The collector also counts every record towards GET /api/v1/top
+		forwarder, err := accesslog.NewForwarder(cfg.AccessLog, logger, metricsCollector.TopClientsSink())
+		if err != nil {
+			logger.Fatal("Failed to initialize access log sinks", zap.Error(err))
+		}
+		done := grpcClient.StreamAccessLogs(runCtx, forwarder)
+		defer func() {
+			<-done
+			if err := forwarder.Close(); err != nil {
+				logger.Error("Error closing access log sinks", zap.Error(err))
+			}
+		}()
+	}
 
-	// Initialize REST API
-	apiServer := api.NewServer(cfg, *configFile, grpcClient, healthChecker, metricsCollector, logger)
+	// Turn data plane events into metrics, log lines and webhook notifications
+	if grpcClient != nil {
+		dispatcher := events.NewDispatcher(cfg.Events, logger)
+		done := grpcClient.StreamEvents(runCtx, dispatcher)
+		defer func() {
+			<-done
+			dispatcher.Close()
+		}()
+	}
 
-	// Start API server
-	go func() {
-		logger.Info("Starting admin API", zap.String("address", cfg.Admin.APIAddress))
-		if err := apiServer.Start(cfg.Admin.APIAddress); err != nil && err != http.ErrServerClosed {
-			logger.Fatal("Admin API server error", zap.Error(err))
+	if elector != nil {
+		// The leader before may have persisted changes to the config file
+		// since this replica loaded it
+		if err := apiServer.TakeOver(); err != nil {
+			logger.Error("Failed to apply the config file on taking over; keeping the config loaded at startup", zap.Error(err))
 		}
-	}()
+	}
 
-	// Start metrics server
-	metricsServer := metrics.NewServer(metricsCollector)
-	go func() {
-		logger.Info("Starting metrics server", zap.String("address", cfg.Admin.MetricsAddress))
-		if err := metricsServer.Start(cfg.Admin.MetricsAddress); err != nil && err != http.ErrServerClosed {
-			logger.Fatal("Metrics server error", zap.Error(err))
-		}
-	}()
+	go apiServer.PersistState(runCtx)
+	go scheduler.Run(runCtx)
+	go canaryController.Run(runCtx)
+	go slowStart.Run(runCtx)
+	go darkLaunch.Run(runCtx)
+	go discoverer.Run(runCtx)
+	go backendsFile.Run(runCtx)
+	if certManager != nil {
+		go certFiles.Run(runCtx)
+		go certManager.Run(runCtx)
+	}
 
-	// Wait for interrupt signal
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	<-sigChan
+	if elector == nil {
+		startServers()
+	}
 
-	logger.Info("Shutting down gracefully...")
+	// Push the same metrics to an OpenTelemetry collector
+	if otlp := cfg.Telemetry.OTLP; otlp.Endpoint != "" {
+		logger.Info("Exporting metrics over OTLP", zap.String("endpoint", otlp.Endpoint), zap.Duration("interval", otlp.Interval))
+		go metrics.NewOTLPExporter(otlp, gatherer, logger).Run(runCtx)
+	}
+	// ...and to a Prometheus remote-write endpoint, where nothing can scrape
+	if rw := cfg.Telemetry.RemoteWrite; rw.URL != "" {
+		logger.Info("Pushing metrics over Prometheus remote write", zap.String("url", rw.URL), zap.Duration("interval", rw.Interval))
+		go metrics.NewRemoteWriter(rw, gatherer, logger).Run(runCtx)
+	}
+	// ...and to InfluxDB
+	if in := cfg.Telemetry.InfluxDB; in.URL != "" {
+		logger.Info("Writing metrics to InfluxDB", zap.String("url", in.URL), zap.String("bucket", in.Bucket), zap.Duration("interval", in.Interval))
+		go metrics.NewInfluxExporter(in, gatherer, logger).Run(runCtx)
+	}
+	// ...and to Graphite
+	if g := cfg.Telemetry.Graphite; g.Host != "" {
+		bridge, err := metrics.NewGraphiteBridge(g, gatherer, logger)
+		if err != nil {
+			logger.Fatal("Failed to set up the Graphite exporter", zap.Error(err))
+		}
+		logger.Info("Pushing metrics to Graphite", zap.String("host", g.Host), zap.Int("port", g.Port), zap.Duration("interval", g.Interval))
+		go bridge.Run(runCtx)
+	}
+
+	// Wait for interrupt signal, or for leadership to be lost
+	select {
+	case <-sigChan:
+		logger.Info("Shutting down gracefully...")
+	case err := <-campaign:
+		logger.Error("Shutting down so the control plane restarts as a follower", zap.Error(err))
+		exitCode = 1
+	}
+	stopRun()
 
 	// Graceful shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	// Drain connections in data plane
-	if err := grpcClient.DrainConnections(ctx, 30); err != nil {
-		logger.Error("Failed to drain connections", zap.Error(err))
+	if elector == nil {
+		// Drain connections in data plane
+		if err := dp.DrainConnections(ctx, 30); err != nil {
+			logger.Error("Failed to drain connections", zap.Error(err))
+		}
+	} else if exitCode == 0 {
+		// The data plane carries on under the next leader, so it isn't
+		// drained; releasing the lease hands it over straight away
+		<-campaign
 	}
 
-	// Shutdown API servers
+	shutdownServers(ctx, apiServer, metricsServer, logger)
+	logger.Info("Shutdown complete")
+}
+
+// shutdownServers shuts the admin API and metrics servers down, waiting
+// until ctx ends for the requests they're serving.
+func shutdownServers(ctx context.Context, apiServer *api.Server, metricsServer *metrics.Server, logger *zap.Logger) {
 	if err := apiServer.Shutdown(ctx); err != nil {
 		logger.Error("Error shutting down API server", zap.Error(err))
 	}
 	if err := metricsServer.Shutdown(ctx); err != nil {
 		logger.Error("Error shutting down metrics server", zap.Error(err))
 	}
+}
 
-	logger.Info("Shutdown complete")
+// serverTLS loads tlsCfg and keeps it reloading until ctx ends, or returns
+// nil when TLS isn't configured for that server.
+func serverTLS(ctx context.Context, tlsCfg config.TLSServerConfig, logger *zap.Logger) *tls.Config {
+	if !tlsCfg.Enabled() {
+		return nil
+	}
+	reloader, err := certs.NewReloader(tlsCfg, logger)
+	if err != nil {
+		logger.Fatal("Failed to load TLS certificate", zap.Error(err))
+	}
+	go reloader.Watch(ctx)
+	return reloader.TLSConfig()
 }
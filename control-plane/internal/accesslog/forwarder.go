@@ -0,0 +1,118 @@
+package accesslog
+
+import (
+	"sync"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var (
+	recordsForwarded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aegis_access_log_records_total",
+		Help: "Access log records written per sink",
+	}, []string{"sink"})
+	recordsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aegis_access_log_dropped_total",
+		Help: "Access log records dropped per sink because its queue was full",
+	}, []string{"sink"})
+	sinkErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aegis_access_log_sink_errors_total",
+		Help: "Access log write failures per sink",
+	}, []string{"sink"})
+)
+
+// Forwarder fans records from the data plane's access log stream out to
+// every configured sink. Each sink gets its own bounded queue and goroutine
+// so a slow sink (Kafka during a broker failover) can't stall the others.
+type Forwarder struct {
+	block   bool
+	logger  *zap.Logger
+	workers []*worker
+	wg      sync.WaitGroup
+}
+
+type worker struct {
+	sink  Sink
+	queue chan *pb.AccessLogRecord
+}
+
+// NewForwarder opens every sink in cfg.Sinks and forwards to extra too. If
+// any sink fails to open the ones already opened are closed and the error
+// is returned.
+func NewForwarder(cfg config.AccessLogConfig, logger *zap.Logger, extra ...Sink) (*Forwarder, error) {
+	sinks := make([]Sink, 0, len(cfg.Sinks)+len(extra))
+	for _, sc := range cfg.Sinks {
+		sink, err := NewSink(sc, logger)
+		if err != nil {
+			for _, s := range sinks {
+				s.Close()
+			}
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	sinks = append(sinks, extra...)
+	return newForwarder(sinks, cfg.BufferSize, cfg.Overflow == "block", logger), nil
+}
+
+func newForwarder(sinks []Sink, bufferSize int, block bool, logger *zap.Logger) *Forwarder {
+	f := &Forwarder{block: block, logger: logger}
+	for _, sink := range sinks {
+		w := &worker{sink: sink, queue: make(chan *pb.AccessLogRecord, bufferSize)}
+		f.workers = append(f.workers, w)
+		f.wg.Add(1)
+		go f.run(w)
+	}
+	return f
+}
+
+func (f *Forwarder) run(w *worker) {
+	defer f.wg.Done()
+	name := w.sink.Name()
+	for rec := range w.queue {
+		if err := w.sink.Write(rec); err != nil {
+			sinkErrors.WithLabelValues(name).Inc()
+			f.logger.Warn("Access log sink write failed", zap.String("sink", name), zap.Error(err))
+			continue
+		}
+		recordsForwarded.WithLabelValues(name).Inc()
+	}
+}
+
+// Forward queues rec on every sink. In drop mode a full queue discards the
+// record for that sink only; in block mode Forward waits for space, which
+// in turn stops the stream reader and applies backpressure upstream.
+func (f *Forwarder) Forward(rec *pb.AccessLogRecord) {
+	for _, w := range f.workers {
+		if f.block {
+			w.queue <- rec
+			continue
+		}
+		select {
+		case w.queue <- rec:
+		default:
+			recordsDropped.WithLabelValues(w.sink.Name()).Inc()
+		}
+	}
+}
+
+// Close flushes queued records and closes every sink. Forward must not be
+// called after Close.
+func (f *Forwarder) Close() error {
+	for _, w := range f.workers {
+		close(w.queue)
+	}
+	f.wg.Wait()
+
+	var firstErr error
+	for _, w := range f.workers {
+		if err := w.sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
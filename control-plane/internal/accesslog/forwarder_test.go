@@ -0,0 +1,133 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+type memorySink struct {
+	name    string
+	release chan struct{}
+
+	mu      sync.Mutex
+	records []*pb.AccessLogRecord
+}
+
+func (m *memorySink) Name() string { return m.name }
+
+func (m *memorySink) Write(rec *pb.AccessLogRecord) error {
+	if m.release != nil {
+		<-m.release
+	}
+	m.mu.Lock()
+	m.records = append(m.records, rec)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *memorySink) Close() error { return nil }
+
+func (m *memorySink) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.records)
+}
+
+func TestForwarder_DropModeDiscardsWhenQueueFull(t *testing.T) {
+	stuck := &memorySink{name: "forwarder-test-stuck", release: make(chan struct{})}
+	fast := &memorySink{name: "forwarder-test-fast"}
+	f := newForwarder([]Sink{stuck, fast}, 1, false, zap.NewNop())
+
+	// stuck's worker holds one record in Write, its queue holds one more;
+	// everything beyond that is dropped for stuck only.
+	for i := 0; i < 5; i++ {
+		f.Forward(&pb.AccessLogRecord{Backend: "b"})
+		deadline := time.Now().Add(time.Second)
+		for fast.count() < i+1 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	close(stuck.release)
+	f.Close()
+
+	if got := fast.count(); got != 5 {
+		t.Errorf("fast sink records: got %d, want 5 (a slow sink must not affect others)", got)
+	}
+	if got := stuck.count(); got < 1 || got > 2 {
+		t.Errorf("stuck sink records: got %d, want 1-2", got)
+	}
+	if dropped := testutil.ToFloat64(recordsDropped.WithLabelValues("forwarder-test-stuck")); dropped < 3 {
+		t.Errorf("dropped counter: got %v, want >= 3", dropped)
+	}
+}
+
+func TestForwarder_BlockModeDeliversEverything(t *testing.T) {
+	sink := &memorySink{name: "forwarder-test-block"}
+	f := newForwarder([]Sink{sink}, 1, true, zap.NewNop())
+
+	for i := 0; i < 50; i++ {
+		f.Forward(&pb.AccessLogRecord{Backend: "b"})
+	}
+	f.Close()
+
+	if got := sink.count(); got != 50 {
+		t.Errorf("records: got %d, want 50", got)
+	}
+}
+
+func TestFileSink_WritesJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := NewForwarder(config.AccessLogConfig{
+		BufferSize: 10,
+		Overflow:   "drop",
+		Sinks:      []config.AccessLogSink{{Type: "file", Path: path}},
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewForwarder: %v", err)
+	}
+
+	f.Forward(&pb.AccessLogRecord{Timestamp: 1700000000000, Protocol: "tcp", ClientIp: "10.0.0.1", Backend: "b:1", BytesSent: 10})
+	f.Forward(&pb.AccessLogRecord{Protocol: "udp", Backend: "b:2", Error: "timeout"})
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("lines: got %d, want 2", len(lines))
+	}
+
+	var first, second map[string]interface{}
+	json.Unmarshal(lines[0], &first)
+	json.Unmarshal(lines[1], &second)
+	if first["client_ip"] != "10.0.0.1" || first["bytes_sent"] != float64(10) {
+		t.Errorf("first record: got %v", first)
+	}
+	if first["error"] != nil {
+		t.Errorf("successful record should have null error, got %v", first["error"])
+	}
+	if second["error"] != "timeout" {
+		t.Errorf("second record error: got %v, want timeout", second["error"])
+	}
+}
+
+func TestNewForwarder_RejectsUnknownSink(t *testing.T) {
+	_, err := NewForwarder(config.AccessLogConfig{Sinks: []config.AccessLogSink{{Type: "carrier-pigeon"}}}, zap.NewNop())
+	if err == nil {
+		t.Fatal("expected error for unknown sink type")
+	}
+}
@@ -0,0 +1,157 @@
+package accesslog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+// Sink is a destination for access log records. Write is only ever called
+// from one goroutine per sink, so implementations needn't be thread-safe.
+type Sink interface {
+	Name() string
+	Write(rec *pb.AccessLogRecord) error
+	Close() error
+}
+
+// entry is the JSON shape written by every sink. It matches the data
+// plane's own access_log lines so downstream parsers see one schema.
+type entry struct {
+	Timestamp     time.Time `json:"timestamp"`
+	Protocol      string    `json:"protocol"`
+	ClientIP      string    `json:"client_ip"`
+	Backend       string    `json:"backend"`
+	BytesSent     uint64    `json:"bytes_sent"`
+	BytesReceived uint64    `json:"bytes_received"`
+	DurationMs    float64   `json:"duration_ms"`
+	Error         *string   `json:"error"`
+}
+
+func encode(rec *pb.AccessLogRecord) ([]byte, error) {
+	e := entry{
+		Timestamp:     time.UnixMilli(rec.Timestamp).UTC(),
+		Protocol:      rec.Protocol,
+		ClientIP:      rec.ClientIp,
+		Backend:       rec.Backend,
+		BytesSent:     rec.BytesSent,
+		BytesReceived: rec.BytesReceived,
+		DurationMs:    rec.DurationMs,
+	}
+	if rec.Error != "" {
+		e.Error = &rec.Error
+	}
+	return json.Marshal(e)
+}
+
+// NewSink builds the sink described by cfg.
+func NewSink(cfg config.AccessLogSink, logger *zap.Logger) (Sink, error) {
+	switch cfg.Type {
+	case "stdout":
+		return &writerSink{name: "stdout", w: os.Stdout}, nil
+	case "file":
+		f, err := os.OpenFile(cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open access log file %q: %w", cfg.Path, err)
+		}
+		return &writerSink{name: "file:" + cfg.Path, w: f, closer: f}, nil
+	case "kafka":
+		return newKafkaSink(cfg, logger), nil
+	default:
+		return nil, fmt.Errorf("unknown access log sink type %q", cfg.Type)
+	}
+}
+
+// writerSink writes newline-delimited JSON to stdout or a file.
+type writerSink struct {
+	name   string
+	w      io.Writer
+	closer io.Closer
+	buf    []byte
+}
+
+func (s *writerSink) Name() string { return s.name }
+
+func (s *writerSink) Write(rec *pb.AccessLogRecord) error {
+	line, err := encode(rec)
+	if err != nil {
+		return err
+	}
+	s.buf = append(append(s.buf[:0], line...), '\n')
+	_, err = s.w.Write(s.buf)
+	return err
+}
+
+func (s *writerSink) Close() error {
+	if s.closer != nil {
+		return s.closer.Close()
+	}
+	return nil
+}
+
+// kafkaSink publishes each record as one message keyed by backend address,
+// so a backend's records stay ordered within a partition. The writer is
+// async — kafka-go batches internally and reports failures through the
+// completion callback instead of blocking Write on a broker round trip.
+type kafkaSink struct {
+	name   string
+	writer *kafka.Writer
+}
+
+func newKafkaSink(cfg config.AccessLogSink, logger *zap.Logger) *kafkaSink {
+	var mu sync.Mutex
+	var lastLogged time.Time
+	return &kafkaSink{
+		name: "kafka:" + cfg.Topic,
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(cfg.Brokers...),
+			Topic:        cfg.Topic,
+			Balancer:     &kafka.Hash{},
+			BatchTimeout: 100 * time.Millisecond,
+			Async:        true,
+			Completion: func(messages []kafka.Message, err error) {
+				if err == nil {
+					return
+				}
+				// Rate-limit: a broker outage fails every batch.
+				mu.Lock()
+				defer mu.Unlock()
+				if time.Since(lastLogged) < 10*time.Second {
+					return
+				}
+				lastLogged = time.Now()
+				logger.Error("Failed to publish access log records to Kafka",
+					zap.String("brokers", strings.Join(cfg.Brokers, ",")),
+					zap.String("topic", cfg.Topic),
+					zap.Int("records", len(messages)),
+					zap.Error(err))
+			},
+		},
+	}
+}
+
+func (s *kafkaSink) Name() string { return s.name }
+
+func (s *kafkaSink) Write(rec *pb.AccessLogRecord) error {
+	value, err := encode(rec)
+	if err != nil {
+		return err
+	}
+	return s.writer.WriteMessages(context.Background(), kafka.Message{
+		Key:   []byte(rec.Backend),
+		Value: value,
+	})
+}
+
+func (s *kafkaSink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,477 @@
+// Package acme obtains the certificates in proxy.tls.acme from an ACME CA
+// such as Let's Encrypt, renews them before they expire, and pushes them
+// to the data plane as the secrets it terminates TLS with.
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/certs"
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/store"
+	"go.uber.org/zap"
+)
+
+const (
+	// checkInterval is how often the certificates are checked for
+	// renewal.
+	checkInterval = time.Hour
+	// retryInterval is how long a failed push, or a certificate that
+	// couldn't be obtained, waits before it's tried again; the latter's
+	// wait doubles with each failure in a row up to maxRetryInterval, to
+	// keep clear of the CA's rate limits.
+	retryInterval    = 5 * time.Minute
+	maxRetryInterval = 6 * time.Hour
+	// obtainTimeout bounds obtaining one certificate, DNS propagation
+	// included.
+	obtainTimeout = 10 * time.Minute
+	// pollInterval is how often a pending order or authorization is
+	// looked at again, and a dns-01 record looked up.
+	pollInterval = 2 * time.Second
+)
+
+// State store keys: the account key, and each certificate by its name.
+const (
+	accountKey     = "acme/account"
+	certificateKey = "acme/certificates/"
+)
+
+// Source holds the running config. The API server is one.
+type Source interface {
+	// ProxyTLS returns the running proxy.tls.
+	ProxyTLS() config.ProxyTLSConfig
+}
+
+// Pusher sends the data plane the certificates to terminate TLS with, the
+// first being the one for clients naming none of them. A source of
+// certs.Secrets, merging them with the operator's, is one.
+type Pusher interface {
+	UpdateSecrets(ctx context.Context, certificates []certs.Certificate) error
+}
+
+// Status is where one configured certificate stands.
+type Status struct {
+	Name    string
+	Domains []string
+	// NotAfter is when the certificate held expires, zero if there's none
+	// yet.
+	NotAfter time.Time
+	// Error is why it last couldn't be obtained, and RetryAt when that's
+	// tried again; both are empty once it has been.
+	Error   string
+	RetryAt time.Time
+}
+
+// failure is a certificate that couldn't be obtained, count times in a
+// row.
+type failure struct {
+	count   int
+	err     error
+	retryAt time.Time
+}
+
+// Manager keeps the running config's certificates obtained and pushed.
+// They're kept in the state store with the ACME account key, so a
+// restarted or newly elected control plane pushes the ones it finds there
+// and only asks the CA for missing or expiring ones. A certificate that
+// can't be renewed stays in use until it expires.
+type Manager struct {
+	source  Source
+	pusher  Pusher
+	store   store.Store
+	logger  *zap.Logger
+	changed chan struct{}
+
+	httpClient *http.Client
+	lookupTXT  func(ctx context.Context, name string) ([]string, error)
+	poll       time.Duration
+	retry      time.Duration
+
+	// cfg is the ACME config the failures are for; a changed one is
+	// tried again straight away
+	cfg      config.ACMEConfig
+	failures map[string]*failure
+	// pushed is what the data plane last accepted
+	pushed []certs.Certificate
+
+	mu     sync.Mutex
+	status []Status
+}
+
+// New returns a manager for source's certificates, kept in st. Nothing is
+// obtained or pushed until Run.
+func New(source Source, pusher Pusher, st store.Store, logger *zap.Logger) *Manager {
+	return &Manager{
+		source:     source,
+		pusher:     pusher,
+		store:      st,
+		logger:     logger,
+		changed:    make(chan struct{}, 1),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		lookupTXT:  net.DefaultResolver.LookupTXT,
+		poll:       pollInterval,
+		retry:      retryInterval,
+		failures:   make(map[string]*failure),
+	}
+}
+
+// Changed tells the manager the running config was replaced, so changed
+// certificates are obtained straight away. It doesn't block, so it's safe
+// to call with the API's apply lock held.
+func (m *Manager) Changed() {
+	select {
+	case m.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Status returns where each configured certificate stands, in config
+// order.
+func (m *Manager) Status() []Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.status)
+}
+
+// Run checks the certificates every checkInterval, and when a failure's
+// retry is due, until ctx is done.
+func (m *Manager) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.changed:
+		case <-timer.C:
+		}
+		timer.Reset(m.sync(ctx))
+	}
+}
+
+// sync pushes the certificates in the state store, then obtains the ones
+// missing or due for renewal and pushes them too, returning how long
+// until it's to run again.
+func (m *Manager) sync(ctx context.Context) time.Duration {
+	cfg := m.source.ProxyTLS().ACME
+	if !reflect.DeepEqual(cfg, m.cfg) {
+		m.cfg, m.failures = cfg, make(map[string]*failure)
+	}
+	next := checkInterval
+	now := time.Now()
+
+	held := make([]*certs.Certificate, len(cfg.Certificates))
+	var due []int
+	for i, want := range cfg.Certificates {
+		have, err := m.load(ctx, want)
+		if err != nil {
+			m.logger.Error("Failed to read certificate from the state store", zap.String("certificate", want.Name), zap.Error(err))
+		}
+		held[i] = have
+		if have != nil && now.Before(have.NotAfter.Add(-cfg.RenewBefore)) {
+			continue
+		}
+		if f := m.failures[want.Name]; f != nil && now.Before(f.retryAt) {
+			next = min(next, f.retryAt.Sub(now))
+			continue
+		}
+		due = append(due, i)
+	}
+
+	// What's held already doesn't wait for what's being obtained, which
+	// takes a while
+	pushed := m.push(ctx, held, now)
+	if len(due) > 0 {
+		sess := m.newSession(ctx, cfg)
+		for _, i := range due {
+			want := cfg.Certificates[i]
+			if got, err := m.renew(ctx, sess, want); err != nil {
+				next = min(next, m.fail(want, held[i], err))
+			} else {
+				delete(m.failures, want.Name)
+				held[i] = &got
+			}
+		}
+		sess.close()
+		pushed = m.push(ctx, held, now)
+	}
+
+	status := make([]Status, len(cfg.Certificates))
+	for i, want := range cfg.Certificates {
+		status[i] = Status{Name: want.Name, Domains: want.Domains}
+		if held[i] != nil {
+			status[i].NotAfter = held[i].NotAfter
+		}
+		if f := m.failures[want.Name]; f != nil {
+			status[i].Error, status[i].RetryAt = f.err.Error(), f.retryAt
+		}
+	}
+	m.mu.Lock()
+	m.status = status
+	m.mu.Unlock()
+
+	if !pushed {
+		return min(next, m.retry)
+	}
+	return next
+}
+
+// push sends the data plane the unexpired certificates of held, in config
+// order, unless it has them already, reporting whether it does.
+func (m *Manager) push(ctx context.Context, held []*certs.Certificate, now time.Time) bool {
+	var set []certs.Certificate
+	for _, cert := range held {
+		if cert != nil && now.Before(cert.NotAfter) {
+			set = append(set, *cert)
+		}
+	}
+	if slices.EqualFunc(set, m.pushed, sameCertificate) {
+		return true
+	}
+	if err := m.pusher.UpdateSecrets(ctx, set); err != nil {
+		m.logger.Error("Failed to push certificates to the data plane", zap.Int("certificates", len(set)), zap.Error(err))
+		return false
+	}
+	m.pushed = set
+	m.logger.Info("Pushed certificates to the data plane", zap.Int("certificates", len(set)))
+	return true
+}
+
+// fail records that want couldn't be obtained, returning how long until
+// it's tried again.
+func (m *Manager) fail(want config.ACMECertificate, have *certs.Certificate, err error) time.Duration {
+	f := m.failures[want.Name]
+	if f == nil {
+		f = &failure{}
+		m.failures[want.Name] = f
+	}
+	f.count++
+	f.err = err
+	wait := min(m.retry<<min(f.count-1, 16), maxRetryInterval)
+	f.retryAt = time.Now().Add(wait)
+
+	fields := []zap.Field{zap.String("certificate", want.Name), zap.Strings("domains", want.Domains),
+		zap.Int("failures", f.count), zap.Duration("retry_in", wait), zap.Error(err)}
+	if have != nil {
+		fields = append(fields, zap.Time("expires", have.NotAfter))
+		m.logger.Error("Failed to renew certificate; keeping the current one until it expires", fields...)
+	} else {
+		m.logger.Error("Failed to obtain certificate", fields...)
+	}
+	return wait
+}
+
+func sameCertificate(a, b certs.Certificate) bool {
+	return a.Name == b.Name && bytes.Equal(a.Chain, b.Chain)
+}
+
+// storedCertificate is a certificate as kept in the state store.
+type storedCertificate struct {
+	Domains []string `json:"domains"`
+	Chain   string   `json:"chain"`
+	Key     string   `json:"key"`
+}
+
+// load returns want's certificate from the state store, or nil if there's
+// none for its domains.
+func (m *Manager) load(ctx context.Context, want config.ACMECertificate) (*certs.Certificate, error) {
+	var stored storedCertificate
+	found, err := store.GetJSON(ctx, m.store, certificateKey+want.Name, &stored)
+	if err != nil || !found || !slices.Equal(stored.Domains, want.Domains) {
+		return nil, err
+	}
+	cert, err := certs.Parse(want.Name, []byte(stored.Chain), []byte(stored.Key))
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// renew obtains want and keeps it in the state store.
+func (m *Manager) renew(ctx context.Context, sess *session, want config.ACMECertificate) (certs.Certificate, error) {
+	if sess.err != nil {
+		return certs.Certificate{}, sess.err
+	}
+	ctx, cancel := context.WithTimeout(ctx, obtainTimeout)
+	defer cancel()
+	m.logger.Info("Obtaining certificate", zap.String("certificate", want.Name), zap.Strings("domains", want.Domains))
+	cert, err := sess.obtain(ctx, want)
+	if err != nil {
+		return certs.Certificate{}, err
+	}
+	stored := storedCertificate{Domains: want.Domains, Chain: string(cert.Chain), Key: string(cert.Key)}
+	if err := store.PutJSON(ctx, m.store, certificateKey+want.Name, stored); err != nil {
+		// It's pushed anyway; the next leader obtains it again
+		m.logger.Error("Failed to keep certificate in the state store", zap.String("certificate", want.Name), zap.Error(err))
+	}
+	m.logger.Info("Obtained certificate", zap.String("certificate", want.Name), zap.Time("expires", cert.NotAfter))
+	return cert, nil
+}
+
+// session is the account and challenge solver certificates are obtained
+// with in one sync: err, when set, is why there are none.
+type session struct {
+	client *client
+	solver solver
+	http   *httpSolver
+	cfg    config.ACMEConfig
+	err    error
+}
+
+// newSession registers the account and starts the challenge solver.
+func (m *Manager) newSession(ctx context.Context, cfg config.ACMEConfig) *session {
+	s := &session{cfg: cfg}
+	key, err := m.accountKey(ctx)
+	if err != nil {
+		s.err = err
+		return s
+	}
+	if s.client, err = dial(ctx, m.httpClient, cfg.DirectoryURL, key, m.poll); err == nil {
+		err = s.client.register(ctx, cfg.Email)
+	}
+	if err != nil {
+		s.err = err
+		return s
+	}
+	switch cfg.Challenge {
+	case config.ACMEChallengeDNS01:
+		s.solver = &dnsSolver{cfg: cfg.DNS, lookupTXT: m.lookupTXT, poll: m.poll, logger: m.logger}
+	default:
+		s.http, s.err = listenHTTP(cfg.HTTPAddress)
+		s.solver = s.http
+	}
+	return s
+}
+
+func (s *session) close() {
+	if s.http != nil {
+		s.http.close()
+	}
+}
+
+// obtain orders want, answers the challenges of the domains the account
+// isn't yet authorized for, and downloads the certificate issued for a
+// new key.
+func (s *session) obtain(ctx context.Context, want config.ACMECertificate) (certs.Certificate, error) {
+	o, orderURL, err := s.client.newOrder(ctx, want.Domains)
+	if err != nil {
+		return certs.Certificate{}, err
+	}
+	for _, url := range o.Authorizations {
+		if err := s.authorize(ctx, url); err != nil {
+			return certs.Certificate{}, err
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return certs.Certificate{}, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: want.Domains}, key)
+	if err != nil {
+		return certs.Certificate{}, err
+	}
+	if _, err := s.client.post(ctx, o.Finalize, map[string]string{"csr": b64(csr)}, nil); err != nil {
+		return certs.Certificate{}, fmt.Errorf("ACME finalize: %w", err)
+	}
+	issued, err := s.client.waitOrder(ctx, orderURL, "valid")
+	if err != nil {
+		return certs.Certificate{}, err
+	}
+	chain, err := s.client.certificate(ctx, issued.Certificate)
+	if err != nil {
+		return certs.Certificate{}, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return certs.Certificate{}, err
+	}
+	return certs.Parse(want.Name, chain, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+// authorize answers the challenge of the authorization at url, unless
+// it's valid already, and waits for the CA to check it.
+func (s *session) authorize(ctx context.Context, url string) error {
+	var a authorization
+	if _, err := s.client.post(ctx, url, nil, &a); err != nil {
+		return fmt.Errorf("ACME authorization: %w", err)
+	}
+	if a.Status == "valid" {
+		return nil
+	}
+	i := slices.IndexFunc(a.Challenges, func(ch challenge) bool { return ch.Type == s.cfg.Challenge })
+	if i < 0 {
+		return fmt.Errorf("%s for %s: %w", s.cfg.Challenge, a.Identifier.Value, errNoChallenge)
+	}
+	ch := a.Challenges[i]
+	keyAuth, err := s.client.keyAuthorization(ch.Token)
+	if err != nil {
+		return err
+	}
+	if err := s.solver.present(ctx, a.Identifier.Value, ch.Token, keyAuth); err != nil {
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+		defer cancel()
+		s.solver.cleanup(ctx, a.Identifier.Value, ch.Token, keyAuth)
+	}()
+	if _, err := s.client.post(ctx, ch.URL, struct{}{}, nil); err != nil {
+		return fmt.Errorf("ACME challenge: %w", err)
+	}
+	return s.client.waitAuthorization(ctx, url)
+}
+
+// accountKey returns the ACME account key from the state store, making
+// one the first time.
+func (m *Manager) accountKey(ctx context.Context) (*ecdsa.PrivateKey, error) {
+	var stored struct {
+		Key string `json:"key"`
+	}
+	found, err := store.GetJSON(ctx, m.store, accountKey, &stored)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		block, _ := pem.Decode([]byte(stored.Key))
+		if block == nil {
+			return nil, errors.New("ACME account key in the state store is not PEM")
+		}
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("ACME account key in the state store: %w", err)
+		}
+		if key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("ACME account key in the state store is on %s, not P-256", key.Curve.Params().Name)
+		}
+		return key, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	stored.Key = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	if err := store.PutJSON(ctx, m.store, accountKey, stored); err != nil {
+		return nil, fmt.Errorf("failed to keep the ACME account key: %w", err)
+	}
+	return key, nil
+}
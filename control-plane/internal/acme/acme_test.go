@@ -0,0 +1,453 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/certs"
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/store"
+	"go.uber.org/zap"
+)
+
+// fakeCA is an ACME CA checking JWS signatures and challenge answers, and
+// issuing certificates valid for 90 days.
+type fakeCA struct {
+	t      *testing.T
+	server *httptest.Server
+	// validate checks the answer to a challenge
+	validate func(typ, domain, token, keyAuth string) bool
+
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+
+	mu       sync.Mutex
+	nonces   map[string]bool
+	accounts map[string]*ecdsa.PublicKey
+	orders   int
+	authzs   map[string]*authorization
+	order    *order
+	domains  []string
+	issued   []byte
+}
+
+func newFakeCA(t *testing.T) *fakeCA {
+	ca := &fakeCA{t: t, nonces: make(map[string]bool), accounts: make(map[string]*ecdsa.PublicKey), authzs: make(map[string]*authorization)}
+	var err error
+	if ca.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "Fake ACME CA"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(365 * 24 * time.Hour),
+		IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &ca.caKey.PublicKey, ca.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.caCert, _ = x509.ParseCertificate(der)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /directory", func(w http.ResponseWriter, r *http.Request) {
+		base := "https://" + r.Host
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"newNonce": base + "/nonce", "newAccount": base + "/account", "newOrder": base + "/order",
+		})
+	})
+	mux.HandleFunc("HEAD /nonce", func(w http.ResponseWriter, r *http.Request) {
+		ca.mu.Lock()
+		defer ca.mu.Unlock()
+		ca.addNonce(w)
+	})
+	mux.HandleFunc("POST /", ca.handlePost)
+	ca.server = httptest.NewTLSServer(mux)
+	t.Cleanup(ca.server.Close)
+	return ca
+}
+
+func (ca *fakeCA) addNonce(w http.ResponseWriter) {
+	nonce := fmt.Sprint(time.Now().UnixNano())
+	ca.nonces[nonce] = true
+	w.Header().Set("Replay-Nonce", nonce)
+}
+
+func (ca *fakeCA) problem(w http.ResponseWriter, status int, typ, detail string) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(Problem{Type: "urn:ietf:params:acme:error:" + typ, Detail: detail, Status: status})
+}
+
+// handlePost checks the JWS and answers the request it carries.
+func (ca *fakeCA) handlePost(w http.ResponseWriter, r *http.Request) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	base := "https://" + r.Host
+
+	var jws struct{ Protected, Payload, Signature string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		ca.problem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+	headerJSON, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	var header struct {
+		Alg, Nonce, URL, Kid string
+		JWK                  *jwk
+	}
+	_ = json.Unmarshal(headerJSON, &header)
+	var key *ecdsa.PublicKey
+	switch {
+	case header.JWK != nil && r.URL.Path == "/account":
+		x, _ := base64.RawURLEncoding.DecodeString(header.JWK.X)
+		y, _ := base64.RawURLEncoding.DecodeString(header.JWK.Y)
+		key, _ = ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+	default:
+		key = ca.accounts[header.Kid]
+	}
+	sig, _ := base64.RawURLEncoding.DecodeString(jws.Signature)
+	digest := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if key == nil || header.Alg != "ES256" || len(sig) != 64 ||
+		!ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		ca.problem(w, http.StatusUnauthorized, "unauthorized", "bad signature")
+		return
+	}
+	if header.URL != base+r.URL.Path {
+		ca.problem(w, http.StatusUnauthorized, "unauthorized", "url mismatch")
+		return
+	}
+	if !ca.nonces[header.Nonce] {
+		ca.addNonce(w)
+		ca.problem(w, http.StatusBadRequest, "badNonce", "stale nonce")
+		return
+	}
+	delete(ca.nonces, header.Nonce)
+	ca.addNonce(w)
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+
+	path := r.URL.Path
+	switch {
+	case path == "/account":
+		kid := base + "/account/" + thumbprintOf(key)
+		ca.accounts[kid] = key
+		w.Header().Set("Location", kid)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"status":"valid"}`))
+
+	case path == "/order":
+		var req struct{ Identifiers []identifier }
+		_ = json.Unmarshal(payload, &req)
+		ca.orders++
+		ca.domains = nil
+		ca.order = &order{Status: "pending", Finalize: base + "/finalize"}
+		for i, id := range req.Identifiers {
+			ca.domains = append(ca.domains, id.Value)
+			name := fmt.Sprint(i)
+			ca.authzs[name] = &authorization{
+				Status:     "pending",
+				Identifier: identifier{Type: "dns", Value: strings.TrimPrefix(id.Value, "*.")},
+				Wildcard:   strings.HasPrefix(id.Value, "*."),
+				Challenges: []challenge{
+					{Type: "http-01", URL: base + "/challenge/" + name, Token: "token-" + name, Status: "pending"},
+					{Type: "dns-01", URL: base + "/challenge/" + name, Token: "token-" + name, Status: "pending"},
+				},
+			}
+			ca.order.Authorizations = append(ca.order.Authorizations, base+"/authz/"+name)
+		}
+		w.Header().Set("Location", base+"/order/1")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(ca.order)
+
+	case strings.HasPrefix(path, "/authz/"):
+		_ = json.NewEncoder(w).Encode(ca.authzs[strings.TrimPrefix(path, "/authz/")])
+
+	case strings.HasPrefix(path, "/challenge/"):
+		a := ca.authzs[strings.TrimPrefix(path, "/challenge/")]
+		token := a.Challenges[0].Token
+		keyAuth := token + "." + thumbprintOf(key)
+		// Either challenge type the client picked is checked
+		valid := ca.validate("http-01", a.Identifier.Value, token, keyAuth) || ca.validate("dns-01", a.Identifier.Value, token, keyAuth)
+		if valid {
+			a.Status = "valid"
+		} else {
+			a.Status = "invalid"
+			a.Challenges[0].Error = &Problem{Type: "urn:ietf:params:acme:error:incorrectResponse", Detail: "wrong answer"}
+		}
+		_ = json.NewEncoder(w).Encode(a.Challenges[0])
+
+	case path == "/finalize":
+		var req struct{ CSR string }
+		_ = json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil || strings.Join(csr.DNSNames, ",") != strings.Join(ca.domains, ",") {
+			ca.problem(w, http.StatusBadRequest, "badCSR", fmt.Sprintf("got %v", csr))
+			return
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(time.Now().UnixNano()), DNSNames: csr.DNSNames,
+			NotBefore: time.Now().Add(-time.Minute), NotAfter: time.Now().Add(90 * 24 * time.Hour),
+			KeyUsage: x509.KeyUsageDigitalSignature, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		leaf, err := x509.CreateCertificate(rand.Reader, tmpl, ca.caCert, csr.PublicKey, ca.caKey)
+		if err != nil {
+			ca.t.Error(err)
+		}
+		ca.issued = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf}),
+			pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.caCert.Raw})...)
+		ca.order.Status, ca.order.Certificate = "processing", base+"/certificate/1"
+		_ = json.NewEncoder(w).Encode(ca.order)
+
+	case path == "/order/1":
+		// Issued on the first poll after finalizing
+		if ca.order.Status == "processing" {
+			ca.order.Status = "valid"
+		}
+		_ = json.NewEncoder(w).Encode(ca.order)
+
+	case path == "/certificate/1":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		_, _ = w.Write(ca.issued)
+
+	default:
+		ca.problem(w, http.StatusNotFound, "malformed", "no such resource")
+	}
+}
+
+func thumbprintOf(key *ecdsa.PublicKey) string {
+	pub, _ := key.ECDH()
+	point := pub.Bytes()
+	data, _ := json.Marshal(jwk{Crv: "P-256", Kty: "EC", X: b64(point[1:33]), Y: b64(point[33:])})
+	digest := sha256.Sum256(data)
+	return b64(digest[:])
+}
+
+type fakeSource struct {
+	mu  sync.Mutex
+	cfg config.ACMEConfig
+}
+
+func (f *fakeSource) ProxyTLS() config.ProxyTLSConfig {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return config.ProxyTLSConfig{ACME: f.cfg}
+}
+
+type fakePusher struct {
+	pushes [][]certs.Certificate
+	err    error
+}
+
+func (f *fakePusher) UpdateSecrets(_ context.Context, certificates []certs.Certificate) error {
+	f.pushes = append(f.pushes, certificates)
+	return f.err
+}
+
+// freeAddress returns a loopback address nothing listens on.
+func freeAddress(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func newTestManager(ca *fakeCA, cfg config.ACMEConfig, st store.Store) (*Manager, *fakePusher) {
+	pusher := &fakePusher{}
+	m := New(&fakeSource{cfg: cfg}, pusher, st, zap.NewNop())
+	m.httpClient = ca.server.Client()
+	m.poll = time.Millisecond
+	return m, pusher
+}
+
+func testACMEConfig(ca *fakeCA) config.ACMEConfig {
+	return config.ACMEConfig{
+		DirectoryURL: ca.server.URL + "/directory",
+		Email:        "ops@example.com",
+		AcceptTOS:    true,
+		Certificates: []config.ACMECertificate{{Name: "example.com", Domains: []string{"example.com", "www.example.com"}}},
+		Challenge:    config.ACMEChallengeHTTP01,
+		RenewBefore:  config.DefaultACMERenewBefore,
+	}
+}
+
+func TestManager_ObtainsWithHTTP01AndReusesStored(t *testing.T) {
+	ca := newFakeCA(t)
+	cfg := testACMEConfig(ca)
+	cfg.HTTPAddress = freeAddress(t)
+	ca.validate = func(typ, _, token, keyAuth string) bool {
+		if typ != "http-01" {
+			return false
+		}
+		resp, err := http.Get("http://" + cfg.HTTPAddress + challengePath + token)
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body) == keyAuth
+	}
+	st := store.NewMemory()
+	m, pusher := newTestManager(ca, cfg, st)
+
+	if next := m.sync(context.Background()); next != checkInterval {
+		t.Errorf("next check in %s, want %s", next, checkInterval)
+	}
+	if len(pusher.pushes) != 1 || len(pusher.pushes[0]) != 1 {
+		t.Fatalf("got pushes %v, want one of one certificate", pusher.pushes)
+	}
+	got := pusher.pushes[0][0]
+	if got.Name != "example.com" || strings.Join(got.ServerNames, ",") != "example.com,www.example.com" {
+		t.Errorf("pushed %s for %v", got.Name, got.ServerNames)
+	}
+	if status := m.Status(); len(status) != 1 || status[0].NotAfter.IsZero() || status[0].Error != "" {
+		t.Errorf("status: got %+v", status)
+	}
+	if _, err := net.Dial("tcp", cfg.HTTPAddress); err == nil {
+		t.Error("http-01 listener left open")
+	}
+
+	// Another manager on the same store, as after a restart or failover,
+	// pushes the stored certificate without ordering another
+	m2, pusher2 := newTestManager(ca, cfg, st)
+	m2.sync(context.Background())
+	if ca.orders != 1 {
+		t.Errorf("got %d orders, want 1", ca.orders)
+	}
+	if len(pusher2.pushes) != 1 || string(pusher2.pushes[0][0].Chain) != string(got.Chain) {
+		t.Errorf("stored certificate not pushed: %v", pusher2.pushes)
+	}
+	// and pushes nothing while it's unchanged
+	m2.sync(context.Background())
+	if len(pusher2.pushes) != 1 {
+		t.Errorf("got %d pushes, want 1", len(pusher2.pushes))
+	}
+}
+
+func TestManager_RenewsBeforeExpiry(t *testing.T) {
+	ca := newFakeCA(t)
+	cfg := testACMEConfig(ca)
+	cfg.HTTPAddress = freeAddress(t)
+	ca.validate = func(string, string, string, string) bool { return true }
+	// Longer than the 90 days certificates are issued for
+	cfg.RenewBefore = 100 * 24 * time.Hour
+	m, pusher := newTestManager(ca, cfg, store.NewMemory())
+
+	m.sync(context.Background())
+	m.sync(context.Background())
+	if ca.orders != 2 || len(pusher.pushes) != 2 {
+		t.Errorf("got %d orders and %d pushes, want 2 of each", ca.orders, len(pusher.pushes))
+	}
+}
+
+func TestManager_ObtainsWithDNS01(t *testing.T) {
+	ca := newFakeCA(t)
+	cfg := testACMEConfig(ca)
+	cfg.Challenge = config.ACMEChallengeDNS01
+	cfg.Certificates = []config.ACMECertificate{{Name: "wildcard", Domains: []string{"*.example.com"}}}
+	cfg.DNS.PropagationTimeout = time.Second
+	records := filepath.Join(t.TempDir(), "records")
+	cfg.DNS.Command = []string{"sh", "-c", `echo "$@" >> "$0"`, records}
+
+	// The resolver sees what the command presented, and so does the CA
+	published := func(fqdn string) []string {
+		data, _ := os.ReadFile(records)
+		var values []string
+		for _, line := range strings.Split(string(data), "\n") {
+			if f := strings.Fields(line); len(f) == 3 && f[0] == "present" && f[1] == fqdn {
+				values = append(values, f[2])
+			}
+		}
+		return values
+	}
+	ca.validate = func(typ, domain, _, keyAuth string) bool {
+		fqdn, value := dnsRecord(domain, keyAuth)
+		return typ == "dns-01" && strings.Join(published(fqdn), ",") == value
+	}
+	m, pusher := newTestManager(ca, cfg, store.NewMemory())
+	m.lookupTXT = func(_ context.Context, name string) ([]string, error) { return published(name), nil }
+
+	m.sync(context.Background())
+	if len(pusher.pushes) != 1 || len(pusher.pushes[0]) != 1 || pusher.pushes[0][0].ServerNames[0] != "*.example.com" {
+		t.Fatalf("got pushes %v, status %+v", pusher.pushes, m.Status())
+	}
+	data, _ := os.ReadFile(records)
+	if !strings.Contains(string(data), "cleanup _acme-challenge.example.com. ") {
+		t.Errorf("record not cleaned up: %q", data)
+	}
+}
+
+func TestManager_BacksOffAfterFailure(t *testing.T) {
+	ca := newFakeCA(t)
+	cfg := testACMEConfig(ca)
+	cfg.HTTPAddress = freeAddress(t)
+	ca.validate = func(string, string, string, string) bool { return false }
+	m, pusher := newTestManager(ca, cfg, store.NewMemory())
+
+	if next := m.sync(context.Background()); next != retryInterval {
+		t.Errorf("first retry in %s, want %s", next, retryInterval)
+	}
+	status := m.Status()
+	if len(status) != 1 || !strings.Contains(status[0].Error, "wrong answer") || status[0].RetryAt.IsZero() {
+		t.Errorf("status: got %+v", status)
+	}
+	// Not retried before it's due
+	m.sync(context.Background())
+	if ca.orders != 1 || len(pusher.pushes) != 0 {
+		t.Errorf("got %d orders and %d pushes, want 1 and 0", ca.orders, len(pusher.pushes))
+	}
+
+	m.failures["example.com"].retryAt = time.Time{}
+	if next := m.sync(context.Background()); next != 2*retryInterval {
+		t.Errorf("second retry in %s, want %s", next, 2*retryInterval)
+	}
+}
+
+func TestManager_RejectsStoredKeyNotOnP256(t *testing.T) {
+	ca := newFakeCA(t)
+	cfg := testACMEConfig(ca)
+	cfg.HTTPAddress = freeAddress(t)
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := store.NewMemory()
+	stored := map[string]string{"key": string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))}
+	if err := store.PutJSON(context.Background(), st, accountKey, stored); err != nil {
+		t.Fatal(err)
+	}
+	m, pusher := newTestManager(ca, cfg, st)
+
+	m.sync(context.Background())
+	status := m.Status()
+	if len(status) != 1 || !strings.Contains(status[0].Error, "not P-256") {
+		t.Errorf("status: got %+v", status)
+	}
+	if ca.orders != 0 || len(pusher.pushes) != 0 {
+		t.Errorf("got %d orders and %d pushes, want none", ca.orders, len(pusher.pushes))
+	}
+}
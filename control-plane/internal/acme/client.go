@@ -0,0 +1,379 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxResponseSize bounds what's read of a CA's response; a certificate
+// chain is a few kilobytes.
+const maxResponseSize = 1 << 20
+
+// Problem is an error a CA answered with (RFC 8555 section 6.7).
+type Problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+func (p *Problem) Error() string {
+	return fmt.Sprintf("%s: %s", strings.TrimPrefix(p.Type, "urn:ietf:params:acme:error:"), p.Detail)
+}
+
+// badNonce is the problem a CA answers a request with a stale nonce with;
+// the request is sent once more with the fresh nonce that came with it.
+const badNonce = "urn:ietf:params:acme:error:badNonce"
+
+type directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type order struct {
+	Status         string       `json:"status"`
+	Identifiers    []identifier `json:"identifiers"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate"`
+	Error          *Problem     `json:"error"`
+}
+
+type authorization struct {
+	Status     string      `json:"status"`
+	Identifier identifier  `json:"identifier"`
+	Challenges []challenge `json:"challenges"`
+	Wildcard   bool        `json:"wildcard"`
+}
+
+type challenge struct {
+	Type   string   `json:"type"`
+	URL    string   `json:"url"`
+	Token  string   `json:"token"`
+	Status string   `json:"status"`
+	Error  *Problem `json:"error"`
+}
+
+// client speaks ACME to one CA as the account of key. Every request but
+// the directory's is a JWS signed with the key, carrying a nonce from the
+// CA's previous response.
+type client struct {
+	http  *http.Client
+	dir   directory
+	key   *ecdsa.PrivateKey
+	kid   string
+	nonce string
+	poll  time.Duration
+}
+
+// dial fetches the directory at url.
+func dial(ctx context.Context, httpClient *http.Client, url string, key *ecdsa.PrivateKey, poll time.Duration) (*client, error) {
+	c := &client{http: httpClient, key: key, poll: poll}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ACME directory: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ACME directory %s: %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&c.dir); err != nil {
+		return nil, fmt.Errorf("ACME directory %s: %w", url, err)
+	}
+	if c.dir.NewNonce == "" || c.dir.NewAccount == "" || c.dir.NewOrder == "" {
+		return nil, fmt.Errorf("ACME directory %s lacks newNonce, newAccount or newOrder", url)
+	}
+	return c, nil
+}
+
+// register finds the account of the client's key, creating it if the CA
+// has none. The CA answers a key it knows with the account's URL, so
+// it's called every time rather than the URL being kept.
+func (c *client) register(ctx context.Context, email string) error {
+	payload := map[string]any{"termsOfServiceAgreed": true}
+	if email != "" {
+		payload["contact"] = []string{"mailto:" + email}
+	}
+	resp, err := c.post(ctx, c.dir.NewAccount, payload, nil)
+	if err != nil {
+		return fmt.Errorf("ACME account: %w", err)
+	}
+	c.kid = resp.header.Get("Location")
+	if c.kid == "" {
+		return errors.New("ACME account: the CA returned no account URL")
+	}
+	return nil
+}
+
+// newOrder asks for a certificate for domains, returning the order and its
+// URL.
+func (c *client) newOrder(ctx context.Context, domains []string) (*order, string, error) {
+	ids := make([]identifier, len(domains))
+	for i, d := range domains {
+		ids[i] = identifier{Type: "dns", Value: d}
+	}
+	var o order
+	resp, err := c.post(ctx, c.dir.NewOrder, map[string]any{"identifiers": ids}, &o)
+	if err != nil {
+		return nil, "", fmt.Errorf("ACME order: %w", err)
+	}
+	return &o, resp.header.Get("Location"), nil
+}
+
+// waitAuthorization polls url until the authorization is no longer
+// pending, returning an error unless it's valid.
+func (c *client) waitAuthorization(ctx context.Context, url string) error {
+	for {
+		var a authorization
+		if _, err := c.post(ctx, url, nil, &a); err != nil {
+			return err
+		}
+		switch a.Status {
+		case "valid":
+			return nil
+		case "pending", "processing":
+		default:
+			for _, ch := range a.Challenges {
+				if ch.Error != nil {
+					return fmt.Errorf("%s for %s is %s: %w", ch.Type, a.Identifier.Value, a.Status, ch.Error)
+				}
+			}
+			return fmt.Errorf("authorization for %s is %s", a.Identifier.Value, a.Status)
+		}
+		if err := c.sleep(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// waitOrder polls url until the order is neither pending nor processing,
+// returning an error unless it's in want.
+func (c *client) waitOrder(ctx context.Context, url string, want string) (*order, error) {
+	for {
+		var o order
+		if _, err := c.post(ctx, url, nil, &o); err != nil {
+			return nil, err
+		}
+		switch o.Status {
+		case want:
+			return &o, nil
+		case "pending", "processing":
+		default:
+			if o.Error != nil {
+				return nil, fmt.Errorf("order is %s: %w", o.Status, o.Error)
+			}
+			return nil, fmt.Errorf("order is %s, not %s", o.Status, want)
+		}
+		if err := c.sleep(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *client) sleep(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(c.poll):
+		return nil
+	}
+}
+
+// certificate downloads the PEM chain at url.
+func (c *client) certificate(ctx context.Context, url string) ([]byte, error) {
+	resp, err := c.post(ctx, url, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ACME certificate: %w", err)
+	}
+	return resp.body, nil
+}
+
+// keyAuthorization is what proves to the CA that the account asking for a
+// certificate answers challenge token.
+func (c *client) keyAuthorization(token string) (string, error) {
+	tp, err := thumbprint(c.key)
+	if err != nil {
+		return "", err
+	}
+	return token + "." + tp, nil
+}
+
+type response struct {
+	header http.Header
+	body   []byte
+}
+
+// post sends payload, JSON, to url as a JWS, or a POST-as-GET when it's
+// nil, and decodes the response into v unless it's nil.
+func (c *client) post(ctx context.Context, url string, payload any, v any) (*response, error) {
+	body := []byte{}
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := c.send(ctx, url, body)
+	var p *Problem
+	if errors.As(err, &p) && p.Type == badNonce {
+		resp, err = c.send(ctx, url, body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if v != nil {
+		if err := json.Unmarshal(resp.body, v); err != nil {
+			return nil, fmt.Errorf("%s: %w", url, err)
+		}
+	}
+	return resp, nil
+}
+
+func (c *client) send(ctx context.Context, url string, payload []byte) (*response, error) {
+	if c.nonce == "" {
+		if err := c.fetchNonce(ctx); err != nil {
+			return nil, err
+		}
+	}
+	signed, err := c.sign(url, payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(signed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/jose+json")
+	req.Header.Set("Accept", "application/json, application/pem-certificate-chain")
+	httpResp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	c.nonce = httpResp.Header.Get("Replay-Nonce")
+	data, err := io.ReadAll(io.LimitReader(httpResp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if httpResp.StatusCode >= 400 {
+		p := &Problem{Status: httpResp.StatusCode}
+		if json.Unmarshal(data, p) != nil || p.Type == "" {
+			return nil, fmt.Errorf("%s: %s", url, httpResp.Status)
+		}
+		return nil, p
+	}
+	return &response{header: httpResp.Header, body: data}, nil
+}
+
+func (c *client) fetchNonce(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.dir.NewNonce, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("ACME nonce: %w", err)
+	}
+	resp.Body.Close()
+	if c.nonce = resp.Header.Get("Replay-Nonce"); c.nonce == "" {
+		return fmt.Errorf("ACME nonce: %s returned none", c.dir.NewNonce)
+	}
+	return nil
+}
+
+// sign wraps payload in a flattened JWS for url (RFC 8555 section 6.2),
+// naming the key by its account URL once there is one and by the key
+// itself before.
+func (c *client) sign(url string, payload []byte) ([]byte, error) {
+	protected := map[string]any{"alg": "ES256", "nonce": c.nonce, "url": url}
+	if c.kid != "" {
+		protected["kid"] = c.kid
+	} else {
+		key, err := publicJWK(c.key)
+		if err != nil {
+			return nil, err
+		}
+		protected["jwk"] = key
+	}
+	c.nonce = ""
+	header, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+	signingInput := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return json.Marshal(map[string]string{
+		"protected": b64(header),
+		"payload":   b64(payload),
+		"signature": b64(sig),
+	})
+}
+
+// jwk is a P-256 public key; its fields are in the order RFC 7638 hashes
+// them in.
+type jwk struct {
+	Crv string `json:"crv"`
+	Kty string `json:"kty"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicJWK returns key's public key as a JWK, failing for keys not on
+// P-256, the only curve ES256 signs with.
+func publicJWK(key *ecdsa.PrivateKey) (jwk, error) {
+	if key.Curve != elliptic.P256() {
+		return jwk{}, fmt.Errorf("ACME account key is on %s, not P-256", key.Curve.Params().Name)
+	}
+	pub, err := key.PublicKey.ECDH()
+	if err != nil {
+		return jwk{}, err
+	}
+	point := pub.Bytes() // 0x04, X, Y
+	return jwk{Crv: "P-256", Kty: "EC", X: b64(point[1:33]), Y: b64(point[33:])}, nil
+}
+
+// thumbprint is the RFC 7638 thumbprint of key's public key.
+func thumbprint(key *ecdsa.PrivateKey) (string, error) {
+	k, err := publicJWK(key)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(k)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(data)
+	return b64(digest[:]), nil
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,144 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"go.uber.org/zap"
+)
+
+// solver answers the challenges of one type.
+type solver interface {
+	// present answers the challenge token for domain, returning once the
+	// CA can see the answer.
+	present(ctx context.Context, domain, token, keyAuth string) error
+	// cleanup takes the answer down again.
+	cleanup(ctx context.Context, domain, token, keyAuth string)
+}
+
+// challengePath is where http-01 answers are served (RFC 8555 section
+// 8.3).
+const challengePath = "/.well-known/acme-challenge/"
+
+// httpSolver answers http-01 challenges on its own listener, open only
+// while certificates are being obtained.
+type httpSolver struct {
+	server *http.Server
+
+	mu      sync.Mutex
+	answers map[string]string // key authorizations by token
+}
+
+// listenHTTP starts serving http-01 answers on address.
+func listenHTTP(address string) (*httpSolver, error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("http-01 listener: %w", err)
+	}
+	s := &httpSolver{answers: make(map[string]string)}
+	s.server = &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	go func() { _ = s.server.Serve(ln) }()
+	return s, nil
+}
+
+func (s *httpSolver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.URL.Path, challengePath)
+	s.mu.Lock()
+	answer, found := s.answers[token]
+	s.mu.Unlock()
+	if !ok || !found || r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(answer))
+}
+
+func (s *httpSolver) present(_ context.Context, _, token, keyAuth string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.answers[token] = keyAuth
+	return nil
+}
+
+func (s *httpSolver) cleanup(_ context.Context, _, token, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.answers, token)
+}
+
+func (s *httpSolver) close() {
+	_ = s.server.Close()
+}
+
+// dnsSolver answers dns-01 challenges with TXT records published by the
+// configured command, waiting for each to show up in DNS.
+type dnsSolver struct {
+	cfg       config.ACMEDNSConfig
+	lookupTXT func(ctx context.Context, name string) ([]string, error)
+	poll      time.Duration
+	logger    *zap.Logger
+}
+
+// dnsRecord returns the name and value of the TXT record answering a
+// dns-01 challenge for domain (RFC 8555 section 8.4).
+func dnsRecord(domain, keyAuth string) (fqdn, value string) {
+	digest := sha256.Sum256([]byte(keyAuth))
+	return "_acme-challenge." + strings.TrimPrefix(domain, "*.") + ".", b64(digest[:])
+}
+
+func (s *dnsSolver) present(ctx context.Context, domain, _, keyAuth string) error {
+	fqdn, value := dnsRecord(domain, keyAuth)
+	if err := s.run(ctx, "present", fqdn, value); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.PropagationTimeout)
+	defer cancel()
+	for {
+		records, err := s.lookupTXT(ctx, fqdn)
+		if err == nil && slices.Contains(records, value) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("TXT record %s not seen within %s", fqdn, s.cfg.PropagationTimeout)
+		case <-time.After(s.poll):
+		}
+	}
+}
+
+func (s *dnsSolver) cleanup(ctx context.Context, domain, _, keyAuth string) {
+	fqdn, value := dnsRecord(domain, keyAuth)
+	if err := s.run(ctx, "cleanup", fqdn, value); err != nil {
+		s.logger.Warn("Failed to remove ACME challenge TXT record", zap.String("record", fqdn), zap.Error(err))
+	}
+}
+
+// run runs the command with action, fqdn and value appended.
+func (s *dnsSolver) run(ctx context.Context, action, fqdn, value string) error {
+	args := append(slices.Clone(s.cfg.Command[1:]), action, fqdn, value)
+	out, err := exec.CommandContext(ctx, s.cfg.Command[0], args...).CombinedOutput()
+	if err != nil {
+		if out = bytes.TrimSpace(out); len(out) > 0 {
+			err = fmt.Errorf("%w: %s", err, out)
+		}
+		return fmt.Errorf("dns-01 %s %s: %w", action, fqdn, err)
+	}
+	return nil
+}
+
+// errNoChallenge is returned when the CA doesn't offer the configured
+// challenge type for a domain.
+var errNoChallenge = errors.New("the CA doesn't offer the configured challenge")
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/lazzerex/aegis/control-plane/internal/audit"
+)
+
+// maxAuditQuery caps GET /audit?limit=.
+const maxAuditQuery = 1000
+
+type auditKey struct{}
+
+// hashingBody hashes a request body as the handler reads it.
+type hashingBody struct {
+	io.ReadCloser
+	h hash.Hash
+	n int64
+}
+
+func (b *hashingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.h.Write(p[:n])
+	b.n += int64(n)
+	return n, err
+}
+
+// audited records the request in the audit log once it's been answered.
+// requireRole runs inside it and fills in the caller, so rejected
+// attempts are recorded too.
+func (s *Server) audited(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		who := &caller{}
+		body := &hashingBody{ReadCloser: r.Body, h: sha256.New()}
+		r.Body = body
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), auditKey{}, who)))
+
+		// Hash whatever the handler left unread, within the largest body
+		// any handler accepts
+		io.Copy(io.Discard, io.LimitReader(body, maxConfigBody))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		e := audit.Entry{
+			Time:       start.UTC(),
+			Caller:     who.name,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Query:      r.URL.RawQuery,
+			RemoteAddr: r.RemoteAddr,
+			RequestID:  middleware.GetReqID(r.Context()),
+			Status:     status,
+			Result:     audit.ResultFor(status),
+			DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+		}
+		if who.name != "" {
+			e.Role = who.role.String()
+		}
+		if body.n > 0 {
+			e.BodySHA256 = hex.EncodeToString(body.h.Sum(nil))
+		}
+		s.audit.Record(e)
+	})
+}
+
+// noteCaller tells audited and logRequests who the request is from.
+func noteCaller(ctx context.Context, c caller) {
+	for _, key := range []any{auditKey{}, requestLogKey{}} {
+		if slot, ok := ctx.Value(key).(*caller); ok {
+			*slot = c
+		}
+	}
+}
+
+// handleAudit lists recent audit entries, newest first.
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if s.audit == nil {
+		writeError(w, r, http.StatusNotImplemented, ErrCodeNotSupported, "The audit log is not enabled")
+		return
+	}
+	q := r.URL.Query()
+	f := audit.Filter{
+		Caller:     q.Get("caller"),
+		Method:     q.Get("method"),
+		PathPrefix: q.Get("path"),
+		Result:     q.Get("result"),
+		Limit:      100,
+	}
+	switch f.Result {
+	case "", audit.ResultSuccess, audit.ResultDenied, audit.ResultFailed:
+	default:
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "result must be success, denied or failed")
+		return
+	}
+	for name, dst := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if raw := q.Get(name); raw != "" {
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid "+name+": want an RFC 3339 time such as 2024-05-01T12:00:00Z")
+				return
+			}
+			*dst = t
+		}
+	}
+	if raw := q.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxAuditQuery {
+			writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "limit must be between 1 and "+strconv.Itoa(maxAuditQuery))
+			return
+		}
+		f.Limit = n
+	}
+
+	writeJSON(w, http.StatusOK, AuditResponse{Entries: s.audit.Query(f)})
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/lazzerex/aegis/control-plane/internal/auth"
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"go.uber.org/zap"
+)
+
+type callerKey struct{}
+
+// caller identifies who authorized a request: an API key's name or a
+// token's subject.
+type caller struct {
+	name string
+	role auth.Role
+}
+
+// callerName returns the name of whoever authorized the request, or ""
+// when auth is disabled.
+func callerName(ctx context.Context) string {
+	c, _ := ctx.Value(callerKey{}).(caller)
+	return c.name
+}
+
+// presentedKey extracts the caller's key from "Authorization: Bearer <key>"
+// or, for tools that can't set Authorization, "X-API-Key: <key>".
+func presentedKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// matchAPIKey returns the key equal to presented. Both sides are hashed
+// first so the comparison takes the same time whatever the key lengths, and
+// every key is compared so timing doesn't reveal which matched.
+func matchAPIKey(keys []config.APIKeyConfig, presented string) (config.APIKeyConfig, bool) {
+	want := sha256.Sum256([]byte(presented))
+	var match config.APIKeyConfig
+	found := 0
+	for _, k := range keys {
+		have := sha256.Sum256([]byte(k.Key))
+		if subtle.ConstantTimeCompare(want[:], have[:]) == 1 && found == 0 {
+			match = k
+			found = 1
+		}
+	}
+	return match, found == 1
+}
+
+// authEnabled reports whether any credential is configured. With none,
+// as in local development, every route is open.
+func (s *Server) authEnabled(admin config.AdminConfig) bool {
+	return len(admin.Keys()) > 0 || s.verifier != nil
+}
+
+// authenticate resolves the request's credentials to a caller. API keys
+// are tried first; anything else is verified as an OIDC token when an
+// issuer is configured.
+func (s *Server) authenticate(r *http.Request, admin config.AdminConfig) (caller, error) {
+	presented := presentedKey(r)
+	if presented == "" {
+		return caller{}, errors.New("no credentials")
+	}
+	if key, ok := matchAPIKey(admin.Keys(), presented); ok {
+		role, err := auth.ParseRole(key.Role)
+		if err != nil {
+			return caller{}, err
+		}
+		return caller{name: key.Name, role: role}, nil
+	}
+	if s.verifier == nil {
+		return caller{}, errors.New("unknown API key")
+	}
+	claims, err := s.verifier.Verify(r.Context(), presented)
+	if err != nil {
+		return caller{}, err
+	}
+	return caller{name: claims.Subject(), role: s.verifier.Role(claims)}, nil
+}
+
+// requireRole guards a route with the given role. Viewer routes are only
+// guarded when admin.protect_reads is set. Unauthenticated requests get
+// 401 and callers without the role 403; every authorized call is logged
+// with the caller's name, and mutating ones also go to the audit log.
+func (s *Server) requireRole(required auth.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.mu.RLock()
+			admin := s.config.Admin
+			s.mu.RUnlock()
+			if !s.authEnabled(admin) || (required == auth.RoleViewer && !admin.ProtectReads) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			c, err := s.authenticate(r, admin)
+			if err != nil {
+				s.logger.Warn("Rejected unauthenticated admin API request",
+					zap.String("method", r.Method),
+					zap.String("path", r.URL.Path),
+					zap.String("remote_addr", r.RemoteAddr),
+					zap.Error(err))
+				writeError(w, r, http.StatusUnauthorized, ErrCodeUnauthorized, "Missing or invalid credentials")
+				return
+			}
+			noteCaller(r.Context(), c)
+			if !c.role.Allows(required) {
+				s.logger.Warn("Rejected admin API request for insufficient role",
+					zap.String("caller", c.name),
+					zap.Stringer("role", c.role),
+					zap.Stringer("required", required),
+					zap.String("method", r.Method),
+					zap.String("path", r.URL.Path))
+				writeError(w, r, http.StatusForbidden, ErrCodeForbidden, "This action requires the "+required.String()+" role")
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, c)))
+		})
+	}
+}
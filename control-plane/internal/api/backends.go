@@ -0,0 +1,510 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/events"
+	"github.com/lazzerex/aegis/control-plane/internal/health"
+	"github.com/lazzerex/aegis/control-plane/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// The /backends/{address} routes manage TCP backends only; UDP backends
+// are still configured through the config file.
+
+// requestError is how a backend change refuses a request.
+type requestError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (s *Server) handleListBackends(w http.ResponseWriter, r *http.Request) {
+	healthState, circuitStates, backendStats := s.backendState()
+
+	s.mu.RLock()
+	response := BackendListResponse{
+		Backends:    buildBackendEntries(s.config.Proxy.Backends, healthState, circuitStates, backendStats),
+		UDPBackends: buildBackendEntries(s.config.Proxy.UdpBackends, healthState, circuitStates, backendStats),
+	}
+	version := s.config.Version()
+	s.mu.RUnlock()
+
+	// Backend changes can be made conditional on this, like PUT /config
+	w.Header().Set("ETag", `"`+version+`"`)
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) handleGetBackend(w http.ResponseWriter, r *http.Request) {
+	address, ok := addressParam(w, r)
+	if !ok {
+		return
+	}
+	healthState, circuitStates, backendStats := s.backendState()
+
+	s.mu.RLock()
+	i := indexOfBackend(s.config.Proxy.Backends, address)
+	var entries []BackendEntry
+	if i >= 0 {
+		entries = buildBackendEntries(s.config.Proxy.Backends[i:i+1], healthState, circuitStates, backendStats)
+	}
+	version := s.config.Version()
+	s.mu.RUnlock()
+
+	if entries == nil {
+		writeError(w, r, http.StatusNotFound, ErrCodeBackendNotFound, "Backend not found")
+		return
+	}
+	detail := BackendDetail{BackendEntry: entries[0]}
+	if provider, ok := s.healthChecker.(healthHistoryProvider); ok {
+		if h, ok := provider.HealthHistory(address); ok {
+			detail.HealthHistory = healthHistoryBody(h)
+		}
+	}
+	w.Header().Set("ETag", `"`+version+`"`)
+	writeJSON(w, http.StatusOK, detail)
+}
+
+func healthHistoryBody(h health.History) *HealthHistoryBody {
+	body := &HealthHistoryBody{
+		LastChecked:         h.LastChecked.UTC(),
+		ConsecutiveFailures: h.ConsecutiveFailures,
+		Transitions:         make([]HealthTransition, len(h.Transitions)),
+	}
+	for i, t := range h.Transitions {
+		body.Transitions[i] = HealthTransition{Time: t.Time.UTC(), Healthy: t.Healthy}
+	}
+	return body
+}
+
+func (s *Server) backendState() (map[string]bool, map[string]string, map[string]metrics.BackendStat) {
+	healthState := s.healthChecker.GetHealthState()
+	if s.circuitStates == nil {
+		return healthState, nil, nil
+	}
+	return healthState, s.circuitStates.BackendCircuitStates(), s.circuitStates.BackendStats()
+}
+
+func buildBackendEntries(backends []config.Backend, healthState map[string]bool, circuitStates map[string]string, backendStats map[string]metrics.BackendStat) []BackendEntry {
+	entries := make([]BackendEntry, len(backends))
+	for i, b := range backends {
+		entry := BackendEntry{
+			Address:      b.Address,
+			Weight:       b.Weight,
+			Pool:         b.Pool,
+			Priority:     b.Priority,
+			Backup:       b.Backup,
+			Zone:         b.Zone,
+			Region:       b.Region,
+			Healthy:      healthState[b.Address],
+			CircuitState: circuitStates[b.Address],
+			HealthCheck: &HealthCheckBody{
+				IntervalSecs: b.HealthCheck.Interval.Seconds(),
+				TimeoutSecs:  b.HealthCheck.Timeout.Seconds(),
+				Path:         b.HealthCheck.Path,
+				Scheme:       b.HealthCheck.Scheme,
+			},
+		}
+		if stat, ok := backendStats[b.Address]; ok {
+			entry.BackendStats = &BackendStats{
+				ActiveConnections: stat.ActiveConnections,
+				TotalRequests:     stat.TotalRequests,
+				FailedRequests:    stat.FailedRequests,
+				AvgLatencyMs:      stat.AvgLatencyMs,
+			}
+		}
+		entries[i] = entry
+	}
+	return entries
+}
+
+func (s *Server) handleAddBackend(w http.ResponseWriter, r *http.Request) {
+	var req AddBackendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Address == "" {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request: address required")
+		return
+	}
+	if _, _, err := net.SplitHostPort(req.Address); err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid address: must be host:port")
+		return
+	}
+
+	if !validPriority(w, r, &req.Priority) {
+		return
+	}
+
+	backend := newBackend(req.Address)
+	if req.Weight > 0 {
+		backend.Weight = req.Weight
+	}
+	backend.Pool = req.Pool
+	backend.Priority = req.Priority
+	backend.Backup = req.Backup
+	backend.Zone = req.Zone
+	backend.Region = req.Region
+	if rerr := applyHealthCheck(&backend.HealthCheck, req.HealthCheck); rerr != nil {
+		writeError(w, r, rerr.status, rerr.code, rerr.message)
+		return
+	}
+
+	ok := s.changeBackends(w, r, func(backends []config.Backend) ([]config.Backend, *requestError) {
+		if indexOfBackend(backends, backend.Address) >= 0 {
+			return nil, &requestError{http.StatusConflict, ErrCodeBackendExists, "Backend already exists"}
+		}
+		return append(backends, backend), nil
+	})
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, BackendChangeResponse{
+		Status:  "added",
+		Address: backend.Address,
+		Weight:  backend.Weight,
+	})
+}
+
+func (s *Server) handleUpdateBackend(w http.ResponseWriter, r *http.Request) {
+	address, ok := addressParam(w, r)
+	if !ok {
+		return
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid address: must be host:port")
+		return
+	}
+	var req UpdateBackendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request: body must be a JSON object")
+		return
+	}
+	if req.Weight < 0 {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request: weight must be >= 0")
+		return
+	}
+	if !validPriority(w, r, req.Priority) {
+		return
+	}
+
+	var result config.Backend
+	created := false
+	ok = s.changeBackends(w, r, func(backends []config.Backend) ([]config.Backend, *requestError) {
+		i := indexOfBackend(backends, address)
+		if i < 0 {
+			created = true
+			backends = append(backends, newBackend(address))
+			i = len(backends) - 1
+		}
+		b := backends[i]
+		if req.Weight > 0 {
+			b.Weight = req.Weight
+		}
+		if req.Pool != "" {
+			b.Pool = req.Pool
+		}
+		if req.Priority != nil {
+			b.Priority = *req.Priority
+		}
+		if req.Backup != nil {
+			b.Backup = *req.Backup
+		}
+		if req.Zone != nil {
+			b.Zone = *req.Zone
+		}
+		if req.Region != nil {
+			b.Region = *req.Region
+		}
+		if rerr := applyHealthCheck(&b.HealthCheck, req.HealthCheck); rerr != nil {
+			return nil, rerr
+		}
+		backends[i] = b
+		result = b
+		return backends, nil
+	})
+	if !ok {
+		return
+	}
+
+	status, code := "updated", http.StatusOK
+	if created {
+		status, code = "added", http.StatusCreated
+	}
+	writeJSON(w, code, BackendChangeResponse{
+		Status:  status,
+		Address: result.Address,
+		Weight:  result.Weight,
+	})
+}
+
+// handlePatchBackend changes fields of an existing backend, typically its
+// weight for gradual traffic shifting, and replies with the updated entry.
+func (s *Server) handlePatchBackend(w http.ResponseWriter, r *http.Request) {
+	address, ok := addressParam(w, r)
+	if !ok {
+		return
+	}
+	var req PatchBackendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request: body must be a JSON object")
+		return
+	}
+	if req.Weight != nil && *req.Weight < 0 {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request: weight must be >= 0")
+		return
+	}
+	if !validPriority(w, r, req.Priority) {
+		return
+	}
+
+	var previous, result config.Backend
+	ok = s.changeBackends(w, r, func(backends []config.Backend) ([]config.Backend, *requestError) {
+		i := indexOfBackend(backends, address)
+		if i < 0 {
+			return nil, &requestError{http.StatusNotFound, ErrCodeBackendNotFound, "Backend not found"}
+		}
+		previous = backends[i]
+		b := backends[i]
+		if req.Weight != nil {
+			b.Weight = *req.Weight
+		}
+		if req.Pool != nil {
+			b.Pool = *req.Pool
+		}
+		if req.Priority != nil {
+			b.Priority = *req.Priority
+		}
+		if req.Backup != nil {
+			b.Backup = *req.Backup
+		}
+		if req.Zone != nil {
+			b.Zone = *req.Zone
+		}
+		if req.Region != nil {
+			b.Region = *req.Region
+		}
+		if rerr := applyHealthCheck(&b.HealthCheck, req.HealthCheck); rerr != nil {
+			return nil, rerr
+		}
+		backends[i] = b
+		result = b
+		return backends, nil
+	})
+	if !ok {
+		return
+	}
+
+	if previous.Weight != result.Weight {
+		s.logger.Info("Backend weight changed",
+			zap.String("backend", address),
+			zap.Int("from", previous.Weight),
+			zap.Int("to", result.Weight),
+			zap.String("caller", callerName(r.Context())))
+	}
+	healthState, circuitStates, backendStats := s.backendState()
+	writeJSON(w, http.StatusOK, buildBackendEntries([]config.Backend{result}, healthState, circuitStates, backendStats)[0])
+}
+
+func (s *Server) handleRemoveBackend(w http.ResponseWriter, r *http.Request) {
+	address, ok := addressParam(w, r)
+	if !ok {
+		return
+	}
+
+	ok = s.changeBackends(w, r, func(backends []config.Backend) ([]config.Backend, *requestError) {
+		i := indexOfBackend(backends, address)
+		if i < 0 {
+			return nil, &requestError{http.StatusNotFound, ErrCodeBackendNotFound, "Backend not found"}
+		}
+		return append(backends[:i], backends[i+1:]...), nil
+	})
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, BackendChangeResponse{
+		Status:  "removed",
+		Address: address,
+	})
+}
+
+// changeBackends applies change to a copy of the TCP backend list, pushes
+// the result to the data plane with ReloadBackends (not a full config push)
+// and adopts it only once the data plane has accepted it. Changes are
+// serialized so two requests can't push lists based on the same original,
+// and conditional on If-Match like PUT /config; a list that leaves a pool
+// of the traffic split empty is refused. It writes the error
+// response itself and reports whether to go on; on success the new config
+// version is set as the ETag.
+func (s *Server) changeBackends(w http.ResponseWriter, r *http.Request, change func([]config.Backend) ([]config.Backend, *requestError)) bool {
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	s.mu.RLock()
+	current := append([]config.Backend(nil), s.config.Proxy.Backends...)
+	proxy, xds := s.config.Proxy, s.config.XDS
+	version := s.config.Version()
+	s.mu.RUnlock()
+
+	if !s.checkIfMatch(w, r, version) {
+		return false
+	}
+
+	// change may edit current in place, so take the active tier first
+	healthState := s.healthChecker.GetHealthState()
+	from, hadTier := config.ActiveTier(proxy.ServingBackends(current), healthState)
+	found := config.FoundBackends(current, proxy.Discovery.Merge)
+	updated, rerr := change(current)
+	if rerr != nil {
+		writeError(w, r, rerr.status, rerr.code, rerr.message)
+		return false
+	}
+	// A backend added while discovery's merge policy has the configured
+	// ones stand by stands by with them
+	if merge := proxy.Discovery.Merge; proxy.Discovery.Enabled() && merge != config.MergeUnion {
+		updated = config.MergeDiscovered(updated, found, merge)
+	}
+	proxy.Backends = updated
+	if errs := config.ValidateBackups("backends", updated); len(errs) > 0 {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidConfig, strings.Join(errs, "; "))
+		return false
+	}
+	if errs := config.ValidateTrafficSplit(proxy); len(errs) > 0 {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidConfig,
+			strings.Join(errs, "; ")+"; change the traffic split first")
+		return false
+	}
+	if errs := config.ValidateBlueGreen(proxy); len(errs) > 0 {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidConfig, strings.Join(errs, "; "))
+		return false
+	}
+	if errs := config.ValidateMirror(proxy); len(errs) > 0 {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidConfig, strings.Join(errs, "; "))
+		return false
+	}
+	if errs := config.ValidateRouting(&config.Config{Proxy: proxy, XDS: xds}); len(errs) > 0 {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidConfig, strings.Join(errs, "; "))
+		return false
+	}
+
+	// An added backend starts at a fraction of its weight
+	updated, commit := s.beginSlowStart(s.RunningProxy().Backends, updated, proxy.LoadBalancing.SlowStart)
+	if err := s.grpcClient.ReloadBackendsWithHealth(updated, healthState); err != nil {
+		s.logger.Error("Failed to push backend change to data plane", zap.Error(err))
+		writeError(w, r, http.StatusInternalServerError, ErrCodeDataPlaneError, "Failed to update data plane")
+		return false
+	}
+	commit()
+
+	s.mu.Lock()
+	s.config.Proxy.Backends = updated
+	s.appliedAt = time.Now()
+	s.appliedChanged()
+	cfg := s.config
+	version = cfg.Version()
+	s.mu.Unlock()
+	w.Header().Set("ETag", `"`+version+`"`)
+	s.healthChecker.Reload(cfg)
+	s.trackBackends(cfg.Proxy)
+	s.feed.Publish(events.TypeBackendsChanged, fmt.Sprintf("Backend list changed via API (%d backends)", len(updated)),
+		map[string]string{"caller": callerName(r.Context()), "backends": strconv.Itoa(len(updated))})
+	// Draining the last serving primary moves traffic to the backups just
+	// as a failed health check would
+	to, hasTier := config.ActiveTier(proxy.ServingBackends(updated), healthState)
+	if hadTier && hasTier && from != to {
+		s.feed.Publish(events.TypePriorityFailover, fmt.Sprintf("Traffic moved from tier %s to %s", from, to),
+			map[string]string{"from": from.String(), "to": to.String(), "caller": callerName(r.Context())})
+	}
+
+	if s.persistBackends(r) {
+		if err := config.SaveBackends(s.configPath, s.slowStartTargets(config.StaticBackends(updated))); err != nil {
+			s.logger.Error("Failed to save backends to config file", zap.String("path", s.configPath), zap.Error(err))
+			writeError(w, r, http.StatusInternalServerError, ErrCodePersistFailed, "Backend change is live but was not saved: "+err.Error())
+			return false
+		}
+	}
+	return true
+}
+
+// persistBackends reports whether a backend change should be written back
+// to the config file: ?persist= when given, else admin.persist_backends.
+func (s *Server) persistBackends(r *http.Request) bool {
+	if persist, err := strconv.ParseBool(r.URL.Query().Get("persist")); err == nil {
+		return persist
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config.Admin.PersistBackends
+}
+
+func addressParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	address, err := url.PathUnescape(chi.URLParam(r, "address"))
+	if err != nil || address == "" {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid address")
+		return "", false
+	}
+	return address, true
+}
+
+func indexOfBackend(backends []config.Backend, address string) int {
+	for i, b := range backends {
+		if b.Address == address {
+			return i
+		}
+	}
+	return -1
+}
+
+// newBackend returns a backend with the defaults config.Load would give it.
+func newBackend(address string) config.Backend {
+	return config.Backend{
+		Address: address,
+		Weight:  100,
+		HealthCheck: config.HealthCheckConfig{
+			Interval: 5 * time.Second,
+			Timeout:  2 * time.Second,
+			Scheme:   "http",
+		},
+	}
+}
+
+// validPriority reports whether priority, if given, is in range, writing a
+// 400 if not.
+func validPriority(w http.ResponseWriter, r *http.Request, priority *int) bool {
+	if priority != nil && (*priority < 0 || *priority > config.MaxPriority) {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest,
+			fmt.Sprintf("Invalid request: priority must be between 0 and %d", config.MaxPriority))
+		return false
+	}
+	return true
+}
+
+func applyHealthCheck(hc *config.HealthCheckConfig, body *HealthCheckBody) *requestError {
+	if body == nil {
+		return nil
+	}
+	if body.Scheme != "" && body.Scheme != "http" && body.Scheme != "https" {
+		return &requestError{http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request: health_check.scheme must be \"http\" or \"https\""}
+	}
+	if body.IntervalSecs > 0 {
+		hc.Interval = time.Duration(body.IntervalSecs * float64(time.Second))
+	}
+	if body.TimeoutSecs > 0 {
+		hc.Timeout = time.Duration(body.TimeoutSecs * float64(time.Second))
+	}
+	if body.Path != "" {
+		hc.Path = body.Path
+	}
+	if body.Scheme != "" {
+		hc.Scheme = body.Scheme
+	}
+	return nil
+}
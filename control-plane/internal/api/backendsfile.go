@@ -0,0 +1,69 @@
+package api
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/events"
+	"go.uber.org/zap"
+)
+
+// SetBackendsFileWatcher has w read the backends file again whenever a
+// config push could change which file it is.
+func (s *Server) SetBackendsFileWatcher(w backendsFileWatcher) {
+	s.backendsFile = w
+}
+
+// BackendsFile returns the running proxy.backends_file, for the backends
+// file watcher.
+func (s *Server) BackendsFile() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config.Proxy.BackendsFile
+}
+
+// SetFileBackends replaces the running config's TCP backends from
+// path, the backends file, with backends and pushes them as a backend
+// reload. A path that's no longer the running one is ignored, so a read
+// racing a config push can't bring back the old file's backends.
+func (s *Server) SetFileBackends(path string, backends []config.Backend) error {
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	s.mu.RLock()
+	proxy, xds := s.config.Proxy, s.config.XDS
+	s.mu.RUnlock()
+	if proxy.BackendsFile == "" || proxy.BackendsFile != path {
+		return nil
+	}
+
+	current := proxy.Backends
+	proxy.Backends = config.MergeFileBackends(current, backends)
+	updated, err := s.pushBackends("backends file", proxy, xds, current)
+	if err != nil || slices.Equal(updated, current) {
+		return err
+	}
+	added, removed := diffBackends(current, updated, func(b config.Backend) bool { return b.FromFile })
+	if len(added) > 0 || len(removed) > 0 {
+		s.feed.Publish(events.TypeBackendsChanged,
+			fmt.Sprintf("Backend list changed by the backends file (%d added, %d removed)", len(added), len(removed)),
+			map[string]string{"caller": "backends_file", "backends": strconv.Itoa(len(updated)),
+				"added": strings.Join(added, ","), "removed": strings.Join(removed, ",")})
+	}
+	s.logger.Info("Applied backends file",
+		zap.String("path", path),
+		zap.Strings("added", added),
+		zap.Strings("removed", removed))
+	return nil
+}
+
+// backendsFileChanged tells the watcher the config was pushed. The caller
+// holds applyMu.
+func (s *Server) backendsFileChanged() {
+	if s.backendsFile != nil {
+		s.backendsFile.Changed()
+	}
+}
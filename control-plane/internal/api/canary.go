@@ -0,0 +1,175 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/canary"
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"go.uber.org/zap"
+)
+
+// SetCanary serves c's rollouts at /canary.
+func (s *Server) SetCanary(c canaryController) {
+	s.canary = c
+}
+
+// ApplyTrafficSplit makes split the running config's traffic split and
+// pushes it as a new version, for the canary controller's steps.
+func (s *Server) ApplyTrafficSplit(split map[string]int) (string, error) {
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	s.mu.RLock()
+	current := s.config
+	s.mu.RUnlock()
+
+	next := *current
+	next.Proxy.TrafficSplit = split
+	if errs := append(config.ValidateTrafficSplit(next.Proxy), config.ValidateRouting(&next)...); len(errs) > 0 {
+		return "", errors.New(strings.Join(errs, "; "))
+	}
+	if err := s.applyConfig(&next); err != nil {
+		return "", fmt.Errorf("failed to update data plane: %w", err)
+	}
+	return next.Version(), nil
+}
+
+// RunningProxy returns the proxy section of the running config.
+func (s *Server) RunningProxy() config.ProxyConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config.Proxy
+}
+
+func (s *Server) handleGetCanary(w http.ResponseWriter, r *http.Request) {
+	if s.canary == nil {
+		writeError(w, r, http.StatusNotImplemented, ErrCodeNotSupported, "Canary rollouts are not supported in this mode")
+		return
+	}
+	st, ok := s.canary.Status()
+	if !ok {
+		writeJSON(w, http.StatusOK, CanaryResponse{State: "idle", Steps: []CanaryStep{}})
+		return
+	}
+	writeJSON(w, http.StatusOK, canaryResponse(st))
+}
+
+// handleStartCanary starts a rollout of a canary pool: its first step is
+// pushed before the response, the rest follow in the background as each
+// passes. The two pools must make a valid traffic split.
+func (s *Server) handleStartCanary(w http.ResponseWriter, r *http.Request) {
+	if s.canary == nil {
+		writeError(w, r, http.StatusNotImplemented, ErrCodeNotSupported, "Canary rollouts are not supported in this mode")
+		return
+	}
+	var req CanaryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+	spec := canary.Spec{
+		Pool:          req.Pool,
+		Baseline:      req.Baseline,
+		TargetPercent: req.TargetPercent,
+		StepPercent:   req.StepPercent,
+		StepInterval:  time.Duration(req.StepIntervalSecs * float64(time.Second)),
+		MaxErrorRate:  req.MaxErrorRate,
+		MaxLatency:    time.Duration(req.MaxP99LatencyMs * float64(time.Millisecond)),
+		MinRequests:   req.MinRequests,
+	}.WithDefaults()
+	if errs := spec.Validate(); len(errs) > 0 {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, strings.Join(errs, "; "))
+		return
+	}
+	// Catch pools that can't be split between before anything is pushed
+	s.mu.RLock()
+	next := *s.config
+	s.mu.RUnlock()
+	next.Proxy.TrafficSplit = map[string]int{spec.Pool: spec.StepPercent, spec.Baseline: 100 - spec.StepPercent}
+	if errs := append(config.ValidateTrafficSplit(next.Proxy), config.ValidateRouting(&next)...); len(errs) > 0 {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidConfig, strings.Join(errs, "; "))
+		return
+	}
+
+	st, err := s.canary.Start(spec, callerName(r.Context()))
+	switch {
+	case errors.Is(err, canary.ErrRunning):
+		writeError(w, r, http.StatusConflict, ErrCodeConflict, "A canary rollout is already in progress")
+		return
+	case err != nil:
+		s.logger.Error("Failed to start canary rollout", zap.Error(err))
+		writeError(w, r, http.StatusInternalServerError, ErrCodeDataPlaneError, "Failed to start canary rollout: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, canaryResponse(st))
+}
+
+// handleAbortCanary rolls the rollout in progress back, all traffic going
+// to the baseline pool again.
+func (s *Server) handleAbortCanary(w http.ResponseWriter, r *http.Request) {
+	if s.canary == nil {
+		writeError(w, r, http.StatusNotImplemented, ErrCodeNotSupported, "Canary rollouts are not supported in this mode")
+		return
+	}
+	st, err := s.canary.Abort(callerName(r.Context()))
+	switch {
+	case errors.Is(err, canary.ErrNotRunning):
+		writeError(w, r, http.StatusConflict, ErrCodeConflict, "No canary rollout is in progress")
+		return
+	case err != nil:
+		// The controller keeps retrying the rollback
+		writeError(w, r, http.StatusInternalServerError, ErrCodeDataPlaneError, "Failed to roll back canary, retrying: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, canaryResponse(st))
+}
+
+func canaryResponse(st canary.Status) CanaryResponse {
+	resp := CanaryResponse{
+		State:           st.State,
+		Pool:            st.Pool,
+		Baseline:        st.Baseline,
+		Percent:         st.Percent,
+		TargetPercent:   st.TargetPercent,
+		StepPercent:     st.StepPercent,
+		StepInterval:    st.StepInterval.String(),
+		MaxErrorRate:    st.MaxErrorRate,
+		MaxP99LatencyMs: float64(st.MaxLatency) / float64(time.Millisecond),
+		MinRequests:     st.MinRequests,
+		Steps:           make([]CanaryStep, 0, len(st.Steps)),
+		Started:         optionalTime(st.Started),
+		Finished:        optionalTime(st.Finished),
+		Reason:          st.Reason,
+		Version:         st.Version,
+		LastError:       st.LastError,
+	}
+	if st.State == canary.StateProgressing {
+		step := canaryStep(st.Current)
+		resp.Current = &step
+	}
+	for _, a := range st.Steps {
+		resp.Steps = append(resp.Steps, canaryStep(a))
+	}
+	return resp
+}
+
+func canaryStep(a canary.Analysis) CanaryStep {
+	step := CanaryStep{
+		Percent:   a.Percent,
+		Started:   a.Started,
+		Requests:  a.Requests,
+		Errors:    a.Errors,
+		ErrorRate: a.ErrorRate(),
+		Passed:    a.Passed,
+	}
+	if a.Connects > 0 {
+		p99 := a.P99LatencyMs
+		step.P99LatencyMs = &p99
+	}
+	return step
+}
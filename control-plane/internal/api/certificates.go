@@ -0,0 +1,68 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+)
+
+// SetCertificateManager has m look at the certificates again whenever a
+// config push could change them, and back GET /certificates.
+func (s *Server) SetCertificateManager(m certificateManager) {
+	s.certificates = m
+}
+
+// SetCertificateWatcher has w look at the certificate files again whenever
+// a config push could change them, and back GET /certificates too.
+func (s *Server) SetCertificateWatcher(w certificateWatcher) {
+	s.certificateFiles = w
+}
+
+// ProxyTLS returns the running proxy.tls, for the certificate manager and
+// watcher.
+func (s *Server) ProxyTLS() config.ProxyTLSConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config.Proxy.TLS
+}
+
+// certificatesChanged tells the manager and watcher the config was pushed.
+// The caller holds applyMu.
+func (s *Server) certificatesChanged() {
+	if s.certificateFiles != nil {
+		s.certificateFiles.Changed()
+	}
+	if s.certificates != nil {
+		s.certificates.Changed()
+	}
+}
+
+func (s *Server) handleGetCertificates(w http.ResponseWriter, r *http.Request) {
+	resp := CertificatesResponse{Enabled: s.ProxyTLS().Enabled(), Certificates: []CertificateStatus{}}
+	if s.certificateFiles != nil {
+		for _, st := range s.certificateFiles.Status() {
+			c := CertificateStatus{Name: st.Name, Source: CertificateSourceFile, Domains: st.ServerNames,
+				CertFile: st.CertFile, Error: st.Error}
+			if !st.NotAfter.IsZero() {
+				c.NotAfter = &st.NotAfter
+				c.ExpiresIn = time.Until(st.NotAfter).Round(time.Second).String()
+			}
+			resp.Certificates = append(resp.Certificates, c)
+		}
+	}
+	if s.certificates != nil {
+		for _, st := range s.certificates.Status() {
+			c := CertificateStatus{Name: st.Name, Source: CertificateSourceACME, Domains: st.Domains, Error: st.Error}
+			if !st.NotAfter.IsZero() {
+				c.NotAfter = &st.NotAfter
+				c.ExpiresIn = time.Until(st.NotAfter).Round(time.Second).String()
+			}
+			if !st.RetryAt.IsZero() {
+				c.RetryAt = &st.RetryAt
+			}
+			resp.Certificates = append(resp.Certificates, c)
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// dataPlaneBreaker is one element of the circuit_breakers command output.
+type dataPlaneBreaker struct {
+	Backend        string `json:"backend"`
+	State          string `json:"state"`
+	FailureCount   int64  `json:"failure_count"`
+	ErrorThreshold int64  `json:"error_threshold"`
+	OpenedAtMs     *int64 `json:"opened_at_ms"`
+	LastFailureMs  *int64 `json:"last_failure_ms"`
+}
+
+// handleListCircuitBreakers lists the breaker of every configured backend.
+// Backends the data plane hasn't sent traffic to yet have no breaker and
+// are reported closed.
+func (s *Server) handleListCircuitBreakers(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	addresses := make([]string, 0, len(s.config.Proxy.Backends))
+	for _, b := range s.config.Proxy.Backends {
+		addresses = append(addresses, b.Address)
+	}
+	s.mu.RUnlock()
+
+	byAddress := make(map[string]*CircuitBreakerEntry)
+	source := "metrics"
+	if executor, ok := s.grpcClient.(commandExecutor); ok && s.dataPlaneSupports("circuit_breakers") {
+		result, err := executor.ExecuteCommand(r.Context(), "circuit_breakers", nil)
+		if err != nil {
+			s.logger.Error("Failed to read circuit breakers", zap.Error(err))
+			writeCommandError(w, r, err)
+			return
+		}
+		var breakers []dataPlaneBreaker
+		if err := json.Unmarshal(result.Output, &breakers); err != nil {
+			writeError(w, r, http.StatusBadGateway, ErrCodeDataPlaneError, "Invalid circuit_breakers output: "+err.Error())
+			return
+		}
+		for _, b := range breakers {
+			byAddress[b.Backend] = &CircuitBreakerEntry{
+				Address:        b.Backend,
+				State:          normalizeCircuitState(b.State),
+				FailureCount:   &b.FailureCount,
+				ErrorThreshold: &b.ErrorThreshold,
+				OpenedAt:       millisTime(b.OpenedAtMs),
+				LastFailureAt:  millisTime(b.LastFailureMs),
+			}
+		}
+		source = "data_plane"
+	} else if s.circuitStates != nil {
+		for address, state := range s.circuitStates.BackendCircuitStates() {
+			byAddress[address] = &CircuitBreakerEntry{Address: address, State: normalizeCircuitState(state)}
+		}
+	}
+
+	for _, address := range addresses {
+		if _, ok := byAddress[address]; !ok {
+			byAddress[address] = &CircuitBreakerEntry{Address: address, State: "closed"}
+		}
+	}
+	if s.circuitStates != nil {
+		for address, stat := range s.circuitStates.BackendStats() {
+			if e, ok := byAddress[address]; ok {
+				failed := stat.FailedRequests
+				e.FailedRequests = &failed
+			}
+		}
+	}
+
+	resp := CircuitBreakersResponse{Breakers: make([]CircuitBreakerEntry, 0, len(byAddress)), Source: source}
+	for _, e := range byAddress {
+		resp.Breakers = append(resp.Breakers, *e)
+	}
+	sort.Slice(resp.Breakers, func(i, j int) bool { return resp.Breakers[i].Address < resp.Breakers[j].Address })
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleResetCircuitBreaker closes a backend's breaker so it gets traffic
+// again straight away instead of after the breaker timeout. If it trips
+// again, the backend is still failing.
+func (s *Server) handleResetCircuitBreaker(w http.ResponseWriter, r *http.Request) {
+	address, ok := addressParam(w, r)
+	if !ok {
+		return
+	}
+	executor, ok := s.grpcClient.(commandExecutor)
+	if !ok {
+		writeError(w, r, http.StatusNotImplemented, ErrCodeNotSupported, "Resetting circuit breakers is not supported in this mode")
+		return
+	}
+	if !s.dataPlaneSupports("reset_circuit") {
+		writeError(w, r, http.StatusNotImplemented, ErrCodeNotSupported, "The data plane does not support resetting circuit breakers")
+		return
+	}
+
+	s.mu.RLock()
+	configured := indexOfBackend(s.config.Proxy.Backends, address) >= 0
+	s.mu.RUnlock()
+	if !configured {
+		writeError(w, r, http.StatusNotFound, ErrCodeBackendNotFound, "Backend not found: "+address)
+		return
+	}
+
+	message := "circuit breaker already closed"
+	result, err := executor.ExecuteCommand(r.Context(), "reset_circuit", map[string]string{"backend": address})
+	switch {
+	case status.Code(err) == codes.NotFound:
+		// No traffic has reached the backend yet, so there's nothing to close
+	case err != nil:
+		s.logger.Error("Failed to reset circuit breaker", zap.String("backend", address), zap.Error(err))
+		writeCommandError(w, r, err)
+		return
+	default:
+		message = result.Message
+	}
+
+	s.logger.Info("Circuit breaker reset",
+		zap.String("backend", address),
+		zap.String("caller", callerName(r.Context())))
+	writeJSON(w, http.StatusOK, CircuitResetResponse{
+		Address: address,
+		State:   "closed",
+		Message: message,
+	})
+}
+
+// normalizeCircuitState maps the data plane's state names (Closed, Open,
+// HalfOpen) to the API's.
+func normalizeCircuitState(state string) string {
+	switch strings.ToLower(state) {
+	case "closed":
+		return "closed"
+	case "open":
+		return "open"
+	case "halfopen", "half_open", "half-open":
+		return "half_open"
+	default:
+		return "unknown"
+	}
+}
+
+func millisTime(ms *int64) *time.Time {
+	if ms == nil {
+		return nil
+	}
+	t := time.UnixMilli(*ms).UTC()
+	return &t
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// handleGetConfig returns the configuration the control plane is running
+// with, which may differ from the file on disk after API changes or an
+// edit that hasn't been reloaded. JSON by default; YAML with ?format=yaml
+// or an Accept header asking for it. The version is also sent as the ETag.
+func (s *Server) handleGetConfig(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	cfg := s.config.Redacted()
+	version := s.config.Version()
+	appliedAt := s.appliedAt
+	s.mu.RUnlock()
+
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to encode configuration: "+err.Error())
+		return
+	}
+	w.Header().Set("ETag", `"`+version+`"`)
+
+	if wantsYAML(r) {
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "# version: %s\n# applied_at: %s\n", version, appliedAt.UTC().Format(time.RFC3339))
+		buf.Write(data)
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(buf.Bytes())
+		return
+	}
+
+	// Round-trip through YAML so the JSON keys match the file's
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to encode configuration: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, ConfigResponse{
+		Version:   version,
+		AppliedAt: appliedAt.UTC(),
+		Config:    doc,
+	})
+}
+
+// maxConfigBody bounds a PUT /config body; it matches the default gRPC
+// message limit the config has to fit through anyway.
+const maxConfigBody = 16 << 20
+
+// handlePutConfig replaces the running config with the YAML (or JSON)
+// document in the body. An If-Match header carrying the version from GET
+// /config makes the update conditional: if anything changed the config in
+// between, nothing is applied and the caller gets 409 to re-read and
+// retry. The file on disk is not touched, so a later reload replaces the
+// posted config.
+func (s *Server) handlePutConfig(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxConfigBody))
+	if err != nil {
+		status, code := http.StatusBadRequest, ErrCodeInvalidRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status, code = http.StatusRequestEntityTooLarge, ErrCodeBodyTooLarge
+		}
+		writeError(w, r, status, code, "Failed to read request body: "+err.Error())
+		return
+	}
+
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	s.mu.RLock()
+	current := s.config
+	version := current.Version()
+	s.mu.RUnlock()
+
+	if !s.checkIfMatch(w, r, version) {
+		return
+	}
+
+	cfg, err := config.ParseWithSecrets(body, current)
+	if err != nil {
+		countReload("api", err)
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidConfig, err.Error())
+		return
+	}
+
+	if r.URL.Query().Get("dry_run") == "true" {
+		s.dryRunReload(w, r, cfg)
+		return
+	}
+
+	err = s.applyConfig(cfg)
+	countReload("api", err)
+	if err != nil {
+		s.logger.Error("Failed to apply posted config", zap.Error(err))
+		writeError(w, r, http.StatusInternalServerError, ErrCodeDataPlaneError, "Failed to update data plane: "+err.Error())
+		return
+	}
+	s.reloadSchedules(cfg)
+
+	s.mu.RLock()
+	appliedAt := s.appliedAt
+	s.mu.RUnlock()
+	newVersion := cfg.Version()
+	s.logger.Info("Configuration replaced via API",
+		zap.String("caller", callerName(r.Context())),
+		zap.String("previous_version", version),
+		zap.String("version", newVersion))
+
+	w.Header().Set("ETag", `"`+newVersion+`"`)
+	writeJSON(w, http.StatusOK, ConfigAppliedResponse{
+		Status:    "applied",
+		Version:   newVersion,
+		AppliedAt: appliedAt.UTC(),
+	})
+}
+
+// checkIfMatch makes a config mutation conditional on the version from
+// the ETag of GET /config or /backends. A stale If-Match gets 409; none at
+// all gets 428 when admin.require_if_match is set. Callers hold applyMu so
+// version can't move before the change is applied. It writes the error
+// response itself and reports whether to go on.
+func (s *Server) checkIfMatch(w http.ResponseWriter, r *http.Request, version string) bool {
+	match := r.Header.Get("If-Match")
+	if match == "" {
+		s.mu.RLock()
+		required := s.config.Admin.RequireIfMatch
+		s.mu.RUnlock()
+		if required {
+			writeError(w, r, http.StatusPreconditionRequired, ErrCodeVersionRequired,
+				"If-Match is required: send the ETag from GET /config or /backends")
+			return false
+		}
+		return true
+	}
+	for _, tag := range strings.Split(match, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || strings.Trim(tag, `"`) == version {
+			return true
+		}
+	}
+	writeError(w, r, http.StatusConflict, ErrCodeVersionConflict,
+		fmt.Sprintf("Configuration has changed: running version is %s", version))
+	return false
+}
+
+func wantsYAML(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "yaml"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "yaml")
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/http"
+	"net/netip"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lazzerex/aegis/control-plane/internal/grpc"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// handleListConnections lists the data plane's active TCP connections,
+// optionally only those to one backend or from one client IP.
+func (s *Server) handleListConnections(w http.ResponseWriter, r *http.Request) {
+	manager, ok := s.grpcClient.(connectionManager)
+	if !ok {
+		writeError(w, r, http.StatusNotImplemented, ErrCodeNotSupported, "Listing connections is not supported in this mode")
+		return
+	}
+
+	filter := grpc.ConnectionFilter{Backend: r.URL.Query().Get("backend")}
+	if raw := r.URL.Query().Get("client_ip"); raw != "" {
+		ip, err := netip.ParseAddr(raw)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid client_ip: "+raw)
+			return
+		}
+		filter.ClientIP = ip
+	}
+
+	conns, err := manager.ListConnections(r.Context(), filter)
+	if err != nil {
+		s.logger.Error("Failed to list connections", zap.Error(err))
+		writeCommandError(w, r, err)
+		return
+	}
+	resp := ConnectionsResponse{Connections: make([]ConnectionEntry, len(conns)), Count: len(conns)}
+	for i, c := range conns {
+		resp.Connections[i] = connectionEntry(c)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleCloseConnection forcibly closes one connection, e.g. an abusive
+// client's. The data plane closes both sides without waiting for either.
+func (s *Server) handleCloseConnection(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid connection ID: "+chi.URLParam(r, "id"))
+		return
+	}
+	manager, ok := s.grpcClient.(connectionManager)
+	if !ok {
+		writeError(w, r, http.StatusNotImplemented, ErrCodeNotSupported, "Closing connections is not supported in this mode")
+		return
+	}
+
+	conn, err := manager.CloseConnection(r.Context(), id)
+	if status.Code(err) == codes.NotFound {
+		writeError(w, r, http.StatusNotFound, ErrCodeConnectionNotFound, "No active connection "+strconv.FormatUint(id, 10))
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to close connection", zap.Uint64("id", id), zap.Error(err))
+		writeCommandError(w, r, err)
+		return
+	}
+
+	s.logger.Info("Connection closed by operator",
+		zap.Uint64("id", id),
+		zap.String("client", conn.Client),
+		zap.String("backend", conn.Backend),
+		zap.String("caller", callerName(r.Context())))
+	writeJSON(w, http.StatusOK, CloseConnectionResponse{Connection: connectionEntry(*conn), Message: "connection closed"})
+}
+
+func connectionEntry(c grpc.Connection) ConnectionEntry {
+	return ConnectionEntry{ID: c.ID, Client: c.Client, Backend: c.Backend, AgeMs: c.Age.Milliseconds()}
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/events"
+	"go.uber.org/zap"
+)
+
+// SetDarkLaunch has c push the dark launches as their windows open and
+// close, and tells it about every config push that could change them.
+func (s *Server) SetDarkLaunch(c darkLauncher) {
+	s.darkLaunch = c
+}
+
+// DarkLaunches returns the running dark launch rules and the zone their
+// windows are read in, for the dark launch controller.
+func (s *Server) DarkLaunches() ([]config.DarkLaunchRule, *time.Location) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config.Proxy.DarkLaunches, s.config.Scheduler.Location()
+}
+
+// RefreshDarkLaunches pushes the running TCP backends again, which gives
+// the data plane the dark launches on now. Which ones are on isn't part
+// of the config, so it's no new version.
+func (s *Server) RefreshDarkLaunches() error {
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	s.mu.RLock()
+	backends := s.config.Proxy.Backends
+	s.mu.RUnlock()
+	return s.grpcClient.ReloadBackendsWithHealth(backends, s.healthChecker.GetHealthState())
+}
+
+// darkLaunchesChanged tells the controller the config was pushed. The
+// caller holds applyMu.
+func (s *Server) darkLaunchesChanged() {
+	if s.darkLaunch != nil {
+		s.darkLaunch.Changed()
+	}
+}
+
+func (s *Server) handleGetDarkLaunches(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	resp := darkLaunchesResponse(s.config, time.Now())
+	s.mu.RUnlock()
+	w.Header().Set("ETag", `"`+resp.Version+`"`)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handlePutDarkLaunches replaces the dark launch rules, taking the new
+// rules' pools out of the default rotation and putting pools no rule
+// sends to any more back in. Like a header rule change it's pushed as a
+// new config version and lives in memory until the next reload.
+func (s *Server) handlePutDarkLaunches(w http.ResponseWriter, r *http.Request) {
+	var req DarkLaunchesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	s.mu.RLock()
+	current := s.config
+	version := current.Version()
+	s.mu.RUnlock()
+
+	if !s.checkIfMatch(w, r, version) {
+		return
+	}
+
+	next := *current
+	next.Proxy.DarkLaunches = nil
+	for _, rule := range req.Rules {
+		dl := config.DarkLaunchRule{Name: rule.Name, Pool: rule.Pool, Percent: rule.Percent}
+		for _, tw := range rule.Windows {
+			dl.Windows = append(dl.Windows, config.TimeWindow{Days: tw.Days, Start: tw.Start, End: tw.End})
+		}
+		next.Proxy.DarkLaunches = append(next.Proxy.DarkLaunches, dl)
+	}
+	if errs := config.ValidateRouting(&next); len(errs) > 0 {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidConfig, strings.Join(errs, "; "))
+		return
+	}
+	if err := s.applyConfig(&next); err != nil {
+		s.logger.Error("Failed to push dark launches to data plane", zap.Error(err))
+		writeError(w, r, http.StatusInternalServerError, ErrCodeDataPlaneError, "Failed to update data plane: "+err.Error())
+		return
+	}
+
+	resp := darkLaunchesResponse(&next, time.Now())
+	s.feed.Publish(events.TypeDarkLaunchesChanged, "Dark launches set to "+formatDarkLaunches(next.Proxy.DarkLaunches),
+		map[string]string{"rules": strconv.Itoa(len(next.Proxy.DarkLaunches)), "caller": callerName(r.Context()), "version": resp.Version})
+	s.logger.Info("Dark launches changed via API",
+		zap.String("caller", callerName(r.Context())),
+		zap.String("previous", formatDarkLaunches(current.Proxy.DarkLaunches)),
+		zap.String("rules", formatDarkLaunches(next.Proxy.DarkLaunches)),
+		zap.String("version", resp.Version))
+	w.Header().Set("ETag", `"`+resp.Version+`"`)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// darkLaunchesResponse reports cfg's dark launches as of now, with their
+// pools' backends.
+func darkLaunchesResponse(cfg *config.Config, now time.Time) DarkLaunchesResponse {
+	loc := cfg.Scheduler.Location()
+	now = now.In(loc)
+	resp := DarkLaunchesResponse{
+		Timezone: loc.String(),
+		Rules:    make([]DarkLaunch, 0, len(cfg.Proxy.DarkLaunches)),
+		Version:  cfg.Version(),
+	}
+	for _, rule := range cfg.Proxy.DarkLaunches {
+		entry := DarkLaunch{
+			Name:       rule.Name,
+			Pool:       rule.Pool,
+			Percent:    rule.Percent,
+			Windows:    make([]TimeWindow, 0, len(rule.Windows)),
+			Active:     rule.ActiveAt(now),
+			NextChange: optionalTime(rule.NextChange(now)),
+			Backends:   []string{},
+		}
+		for _, tw := range rule.Windows {
+			entry.Windows = append(entry.Windows, TimeWindow{Days: tw.Days, Start: tw.Start, End: tw.End})
+		}
+		for _, b := range config.PoolBackends(cfg.Proxy.Backends, rule.Pool) {
+			entry.Backends = append(entry.Backends, b.Address)
+		}
+		resp.Rules = append(resp.Rules, entry)
+	}
+	return resp
+}
+
+// formatDarkLaunches renders dark launch rules for logs and events, e.g.
+// "checkout 5% to beta, search 10% to beta".
+func formatDarkLaunches(rules []config.DarkLaunchRule) string {
+	if len(rules) == 0 {
+		return "none"
+	}
+	parts := make([]string, len(rules))
+	for i, r := range rules {
+		parts[i] = r.Name + " " + strconv.Itoa(r.Percent) + "% to " + r.Pool
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lazzerex/aegis/control-plane/internal/auth"
+)
+
+// debugRoutes serves net/http/pprof and expvar under /debug, for admins
+// only, while admin.debug_endpoints is on. The flag is read per request so
+// a reload can turn them on or off.
+func (s *Server) debugRoutes(r chi.Router) {
+	r.Use(s.debugEnabled)
+	r.Use(s.requireRole(auth.RoleAdmin))
+
+	r.Handle("/pprof", http.RedirectHandler("/debug/pprof/", http.StatusMovedPermanently))
+	// Index also serves the named profiles: heap, goroutine, allocs, ...
+	r.HandleFunc("/pprof/*", pprof.Index)
+	r.HandleFunc("/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/pprof/profile", pprof.Profile)
+	r.HandleFunc("/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/pprof/trace", pprof.Trace)
+	r.Handle("/vars", expvar.Handler())
+}
+
+func (s *Server) debugEnabled(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		enabled := s.config.Admin.DebugEndpoints
+		s.mu.RUnlock()
+		if !enabled {
+			writeError(w, r, http.StatusNotFound, ErrCodeNotSupported, "Debug endpoints are disabled; set admin.debug_endpoints")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/discovery"
+	"github.com/lazzerex/aegis/control-plane/internal/events"
+	"go.uber.org/zap"
+)
+
+// SetDiscovery has c look up the discovered backends again whenever a
+// config push could change the discovery config.
+func (s *Server) SetDiscovery(c discoverer) {
+	s.discovery = c
+}
+
+// Discovery returns the running discovery config, for the discovery
+// controller.
+func (s *Server) Discovery() config.DiscoveryConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config.Proxy.Discovery
+}
+
+// SetDiscoveredBackends replaces the running config's discovered TCP
+// backends with found and pushes them as a backend reload. Like a slow
+// start step it's no config change of its own, so it isn't persisted,
+// but the backends that came and went are published as an event.
+func (s *Server) SetDiscoveredBackends(found []config.Backend) error {
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	s.mu.RLock()
+	proxy, xds := s.config.Proxy, s.config.XDS
+	s.mu.RUnlock()
+	if !proxy.Discovery.Enabled() {
+		return nil
+	}
+
+	current := proxy.Backends
+	proxy.Backends = config.MergeDiscovered(current, found, proxy.Discovery.Merge)
+	updated, err := s.pushBackends("discovered backends", proxy, xds, current)
+	if err != nil {
+		return err
+	}
+	added, removed := diffBackends(current, updated, func(b config.Backend) bool { return b.Discovered })
+	if len(added) > 0 || len(removed) > 0 {
+		s.feed.Publish(events.TypeBackendsChanged,
+			fmt.Sprintf("Backend list changed by discovery (%d added, %d removed)", len(added), len(removed)),
+			map[string]string{"caller": "discovery", "backends": strconv.Itoa(len(updated)),
+				"added": strings.Join(added, ","), "removed": strings.Join(removed, ",")})
+		s.logger.Info("Discovered backends changed",
+			zap.String("target", proxy.Discovery.Target()),
+			zap.Strings("added", added),
+			zap.Strings("removed", removed))
+	}
+	return nil
+}
+
+// pushBackends pushes proxy's backends, the running ones current with
+// those of a source, what, replaced, as a backend reload, ramping up the
+// ones added. It returns the backends now running, current when nothing
+// changed. The caller holds applyMu.
+func (s *Server) pushBackends(what string, proxy config.ProxyConfig, xds config.XDSConfig, current []config.Backend) ([]config.Backend, error) {
+	if errs := config.ValidateRouting(&config.Config{Proxy: proxy, XDS: xds}); len(errs) > 0 {
+		return current, fmt.Errorf("%s rejected: %s", what, strings.Join(errs, "; "))
+	}
+	// A backend added this way is ramped up like one added by hand
+	updated, commit := s.beginSlowStart(current, proxy.Backends, proxy.LoadBalancing.SlowStart)
+	if slices.Equal(updated, current) {
+		return current, nil
+	}
+	if err := s.grpcClient.ReloadBackendsWithHealth(updated, s.healthChecker.GetHealthState()); err != nil {
+		return current, err
+	}
+	commit()
+
+	s.mu.Lock()
+	s.config.Proxy.Backends = updated
+	s.appliedAt = time.Now()
+	s.appliedChanged()
+	cfg := s.config
+	s.mu.Unlock()
+	s.healthChecker.Reload(cfg)
+	s.trackBackends(cfg.Proxy)
+	return updated, nil
+}
+
+// mergeDiscovered returns next's TCP backends with what was discovered in
+// previous among them, or none discovered if next doesn't discover any.
+func mergeDiscovered(next, previous config.ProxyConfig) []config.Backend {
+	if !next.Discovery.Enabled() {
+		return config.MergeDiscovered(next.Backends, nil, config.MergeUnion)
+	}
+	found := config.FoundBackends(previous.Backends, previous.Discovery.Merge)
+	return config.MergeDiscovered(next.Backends, found, next.Discovery.Merge)
+}
+
+// diffBackends returns the addresses of the backends from a source, the
+// ones from says are, in next but not previous, and in previous but not
+// next.
+func diffBackends(previous, next []config.Backend, from func(config.Backend) bool) (added, removed []string) {
+	has := func(backends []config.Backend, address string) bool {
+		return slices.ContainsFunc(backends, func(b config.Backend) bool { return from(b) && b.Address == address })
+	}
+	for _, b := range next {
+		if from(b) && !has(previous, b.Address) {
+			added = append(added, b.Address)
+		}
+	}
+	for _, b := range previous {
+		if from(b) && !has(next, b.Address) {
+			removed = append(removed, b.Address)
+		}
+	}
+	return added, removed
+}
+
+// discoveryChanged tells the controller the config was pushed. The caller
+// holds applyMu.
+func (s *Server) discoveryChanged() {
+	if s.discovery != nil {
+		s.discovery.Changed()
+	}
+}
+
+func (s *Server) handleGetDiscovery(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	proxy := s.config.Proxy
+	s.mu.RUnlock()
+
+	resp := DiscoveryResponse{Enabled: proxy.Discovery.Enabled()}
+	if resp.Enabled {
+		resp.Provider, resp.Target, resp.Merge = proxy.Discovery.Provider, proxy.Discovery.Target(), proxy.Discovery.Merge
+	}
+	for _, b := range proxy.Backends {
+		if b.Discovered {
+			resp.Backends++
+		}
+	}
+	if s.discovery != nil {
+		if shrink, ok := s.discovery.Held(); ok {
+			held := discoveryShrink(shrink)
+			resp.Held = &held
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleConfirmDiscovery lets through the set held back for shrinking the
+// pool too far, as after removing instances on purpose. It's pushed in
+// the background, unless the provider sends another set first.
+func (s *Server) handleConfirmDiscovery(w http.ResponseWriter, r *http.Request) {
+	if s.discovery == nil {
+		writeError(w, r, http.StatusNotImplemented, ErrCodeNotSupported, "Service discovery is not supported in this mode")
+		return
+	}
+	shrink, err := s.discovery.Confirm(callerName(r.Context()))
+	if errors.Is(err, discovery.ErrNothingHeld) {
+		writeError(w, r, http.StatusConflict, ErrCodeConflict, "No discovered backend set is held back")
+		return
+	}
+	writeJSON(w, http.StatusAccepted, discoveryShrink(shrink))
+}
+
+func discoveryShrink(shrink discovery.Shrink) DiscoveryShrink {
+	return DiscoveryShrink{
+		Backends: shrink.Backends,
+		Previous: shrink.Previous,
+		Removed:  append([]string{}, shrink.Removed...),
+		Since:    shrink.Since,
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/events"
+	"go.uber.org/zap"
+)
+
+func (s *Server) handleGetHeaderRules(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	resp := headerRulesResponse(s.config.Proxy)
+	resp.Version = s.config.Version()
+	s.mu.RUnlock()
+	w.Header().Set("ETag", `"`+resp.Version+`"`)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handlePutHeaderRules replaces the header rules, taking the new rules'
+// pools out of the default rotation and putting pools no rule routes to
+// any more back in. Like a mirror change it's pushed as a new config
+// version and lives in memory until the next reload.
+func (s *Server) handlePutHeaderRules(w http.ResponseWriter, r *http.Request) {
+	var req HeaderRulesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	s.mu.RLock()
+	current := s.config
+	version := current.Version()
+	s.mu.RUnlock()
+
+	if !s.checkIfMatch(w, r, version) {
+		return
+	}
+
+	next := *current
+	next.Proxy.HeaderRules = nil
+	for _, rule := range req.Rules {
+		hr := config.HeaderRule{Name: rule.Name, Pool: rule.Pool}
+		for _, m := range rule.Match {
+			hr.Match = append(hr.Match, config.HeaderMatch{Header: m.Header, Exact: m.Exact, Regex: m.Regex})
+		}
+		next.Proxy.HeaderRules = append(next.Proxy.HeaderRules, hr)
+	}
+	if errs := config.ValidateRouting(&next); len(errs) > 0 {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidConfig, strings.Join(errs, "; "))
+		return
+	}
+	if err := s.applyConfig(&next); err != nil {
+		s.logger.Error("Failed to push header rules to data plane", zap.Error(err))
+		writeError(w, r, http.StatusInternalServerError, ErrCodeDataPlaneError, "Failed to update data plane: "+err.Error())
+		return
+	}
+
+	resp := headerRulesResponse(next.Proxy)
+	resp.Version = next.Version()
+	s.feed.Publish(events.TypeHeaderRulesChanged, "Header rules set to "+formatHeaderRules(next.Proxy.HeaderRules),
+		map[string]string{"rules": strconv.Itoa(len(next.Proxy.HeaderRules)), "caller": callerName(r.Context()), "version": resp.Version})
+	s.logger.Info("Header rules changed via API",
+		zap.String("caller", callerName(r.Context())),
+		zap.String("previous", formatHeaderRules(current.Proxy.HeaderRules)),
+		zap.String("rules", formatHeaderRules(next.Proxy.HeaderRules)),
+		zap.String("version", resp.Version))
+	w.Header().Set("ETag", `"`+resp.Version+`"`)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// headerRulesResponse reports the header rules with their pools' backends.
+func headerRulesResponse(p config.ProxyConfig) HeaderRulesResponse {
+	resp := HeaderRulesResponse{Rules: make([]HeaderRule, 0, len(p.HeaderRules))}
+	for _, rule := range p.HeaderRules {
+		entry := HeaderRule{Name: rule.Name, Pool: rule.Pool, Match: make([]HeaderMatch, 0, len(rule.Match)), Backends: []string{}}
+		for _, m := range rule.Match {
+			entry.Match = append(entry.Match, HeaderMatch{Header: m.Header, Exact: m.Exact, Regex: m.Regex})
+		}
+		for _, b := range config.PoolBackends(p.Backends, rule.Pool) {
+			entry.Backends = append(entry.Backends, b.Address)
+		}
+		resp.Rules = append(resp.Rules, entry)
+	}
+	return resp
+}
+
+// formatHeaderRules renders header rules for logs and events, e.g.
+// "debug to staging, qa to qa".
+func formatHeaderRules(rules []config.HeaderRule) string {
+	if len(rules) == 0 {
+		return "none"
+	}
+	parts := make([]string, len(rules))
+	for i, r := range rules {
+		parts[i] = r.Name + " to " + r.Pool
+	}
+	return strings.Join(parts, ", ")
+}
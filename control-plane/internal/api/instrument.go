@@ -0,0 +1,45 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	adminRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aegis_admin_requests_total",
+		Help: "Admin API requests, by method, route pattern and status code",
+	}, []string{"method", "route", "code"})
+	adminRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "aegis_admin_request_duration_seconds",
+		Help:    "Admin API request latency, by method and route pattern",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+	configReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aegis_config_reloads_total",
+		Help: "Config reloads from disk (source=file) or PUT /config (source=api), by result",
+	}, []string{"source", "result"})
+)
+
+// observeRequest records one admin API request. route is the chi pattern
+// rather than the path, so backend addresses and IDs don't each get a
+// series.
+func observeRequest(method, route string, status int, latency time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	adminRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	adminRequestDuration.WithLabelValues(method, route).Observe(latency.Seconds())
+}
+
+// countReload records the outcome of a config reload or replacement.
+func countReload(source string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	configReloads.WithLabelValues(source, result).Inc()
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"go.uber.org/zap"
+)
+
+// leaderHeader names the leader in a follower's refusal, for clients to go
+// there instead.
+const leaderHeader = "X-Aegis-Leader"
+
+// SetLeadership makes this server one replica of an HA control plane: it
+// only makes changes while l says it's the leader, and /status and
+// /readyz report which replica is.
+func (s *Server) SetLeadership(l leadership) {
+	s.leader = l
+}
+
+// TakeOver applies the config file if it changed since this replica
+// loaded it, which the leader before it may have persisted changes to, and
+// lets changes through from then on. A replica just elected calls it once
+// it's pushed the config it has, before anything else changes it.
+func (s *Server) TakeOver() error {
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+	s.tookOver.Store(true)
+	cfg, err := config.Load(s.configPath)
+	if err != nil {
+		return err
+	}
+	s.mu.RLock()
+	unchanged := cfg.Version() == s.config.Version()
+	s.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+	s.logger.Info("Config file changed while following; applying it", zap.String("path", s.configPath))
+	err = s.applyConfig(cfg)
+	countReload("file", err)
+	if err != nil {
+		return err
+	}
+	s.reloadSchedules(cfg)
+	return nil
+}
+
+// leaderOnly refuses the request with 503 on a follower, naming the
+// leader when one is known, and on a leader yet to take over.
+func (s *Server) leaderOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.leader == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		leader, leading := s.leader.Leader()
+		if leading && s.tookOver.Load() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		message := "This control plane replica is a follower; no leader is elected yet"
+		switch {
+		case leading:
+			message = "This control plane replica was just elected leader and is taking over; try again shortly"
+		case leader != "":
+			w.Header().Set(leaderHeader, leader)
+			message = "This control plane replica is a follower; send changes to the leader, " + leader
+		}
+		writeError(w, r, http.StatusServiceUnavailable, ErrCodeNotLeader, message)
+	})
+}
+
+// haStatus is /status's ha, nil without leader election.
+func (s *Server) haStatus() *HAStatus {
+	if s.leader == nil {
+		return nil
+	}
+	leader, leading := s.leader.Leader()
+	return &HAStatus{Identity: s.leader.Identity(), Leader: leader, Leading: leading}
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// maxLogLevelRevert caps revert_after_secs on PUT /loglevel.
+const maxLogLevelRevert = 24 * time.Hour
+
+// logLevel is the control plane's runtime log level and any pending
+// revert of a temporary change.
+type logLevel struct {
+	mu    sync.Mutex
+	level *zap.AtomicLevel
+	// revert fires at revertAt to set the level back to revertTo, the
+	// level from before the first temporary change.
+	revert   *time.Timer
+	revertAt time.Time
+	revertTo zapcore.Level
+	// gen tells a timer that fires after being replaced that it's stale.
+	gen uint64
+}
+
+// SetLogLevel lets GET and PUT /loglevel read and change level, which
+// should be the level the control plane's logger was built with.
+func (s *Server) SetLogLevel(level zap.AtomicLevel) {
+	s.logLevel.mu.Lock()
+	defer s.logLevel.mu.Unlock()
+	s.logLevel.level = &level
+}
+
+func (s *Server) handleGetLogLevel(w http.ResponseWriter, r *http.Request) {
+	s.logLevel.mu.Lock()
+	defer s.logLevel.mu.Unlock()
+	if s.logLevel.level == nil {
+		writeError(w, r, http.StatusNotImplemented, ErrCodeNotSupported, "Changing the log level is not supported in this mode")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.logLevel.responseLocked())
+}
+
+// handlePutLogLevel changes the log level without a restart, typically to
+// debug during an incident. With revert_after_secs the change is
+// temporary: the level goes back to what it was before, even if the
+// temporary level is changed again in the meantime.
+func (s *Server) handlePutLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req LogLevelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+	var level zapcore.Level
+	switch req.Level {
+	case "debug", "info", "warn", "error":
+		level, _ = zapcore.ParseLevel(req.Level)
+	default:
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "level must be debug, info, warn or error")
+		return
+	}
+	revertAfter := time.Duration(req.RevertAfterSecs * float64(time.Second))
+	if revertAfter < 0 || revertAfter > maxLogLevelRevert {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("revert_after_secs must be between 0 and %.0f", maxLogLevelRevert.Seconds()))
+		return
+	}
+
+	l := &s.logLevel
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.level == nil {
+		writeError(w, r, http.StatusNotImplemented, ErrCodeNotSupported, "Changing the log level is not supported in this mode")
+		return
+	}
+
+	previous := l.level.Level()
+	l.gen++
+	if l.revert != nil {
+		l.revert.Stop()
+		l.revert = nil
+		if revertAfter > 0 {
+			// Still temporary: keep the level to go back to
+			previous = l.revertTo
+		}
+	}
+	l.level.SetLevel(level)
+	if revertAfter > 0 {
+		l.revertTo = previous
+		l.revertAt = time.Now().Add(revertAfter)
+		gen := l.gen
+		l.revert = time.AfterFunc(revertAfter, func() { s.revertLogLevel(gen) })
+	}
+
+	fields := []zap.Field{
+		zap.Stringer("level", level),
+		zap.String("caller", callerName(r.Context())),
+	}
+	if l.revert != nil {
+		fields = append(fields, zap.Time("revert_at", l.revertAt), zap.Stringer("revert_to", l.revertTo))
+	}
+	s.logger.Info("Log level changed via API", fields...)
+	writeJSON(w, http.StatusOK, l.responseLocked())
+}
+
+// revertLogLevel ends the temporary change made in generation gen, unless
+// the level has been changed again since.
+func (s *Server) revertLogLevel(gen uint64) {
+	l := &s.logLevel
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.gen != gen {
+		return
+	}
+	l.level.SetLevel(l.revertTo)
+	l.revert = nil
+	s.logger.Info("Temporary log level change reverted", zap.Stringer("level", l.revertTo))
+}
+
+func (l *logLevel) responseLocked() LogLevelResponse {
+	resp := LogLevelResponse{Level: l.level.Level().String()}
+	if l.revert != nil {
+		at := l.revertAt.UTC()
+		resp.RevertAt = &at
+		resp.RevertTo = l.revertTo.String()
+	}
+	return resp
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/events"
+	"go.uber.org/zap"
+)
+
+func (s *Server) handleGetMirror(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	resp := mirrorResponse(s.config.Proxy)
+	resp.Version = s.config.Version()
+	s.mu.RUnlock()
+	w.Header().Set("ETag", `"`+resp.Version+`"`)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handlePutMirror replaces the mirror settings: which pool shadows the
+// serving backends and what percentage of connections it's copied. An
+// empty pool turns mirroring off and puts the old shadow pool's backends
+// back in rotation. Like a traffic split change it's pushed as a new
+// config version and lives in memory until the next reload.
+func (s *Server) handlePutMirror(w http.ResponseWriter, r *http.Request) {
+	var req MirrorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	s.mu.RLock()
+	current := s.config
+	version := current.Version()
+	s.mu.RUnlock()
+
+	if !s.checkIfMatch(w, r, version) {
+		return
+	}
+
+	next := *current
+	next.Proxy.Mirror = config.MirrorConfig{Pool: req.Pool, Percent: req.Percent}
+	if errs := append(config.ValidateMirror(next.Proxy), config.ValidateRouting(&next)...); len(errs) > 0 {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidConfig, strings.Join(errs, "; "))
+		return
+	}
+	if err := s.applyConfig(&next); err != nil {
+		s.logger.Error("Failed to push mirror change to data plane", zap.Error(err))
+		writeError(w, r, http.StatusInternalServerError, ErrCodeDataPlaneError, "Failed to update data plane: "+err.Error())
+		return
+	}
+
+	resp := mirrorResponse(next.Proxy)
+	resp.Version = next.Version()
+	s.feed.Publish(events.TypeMirrorChanged, "Mirroring set to "+formatMirror(next.Proxy.Mirror),
+		map[string]string{"pool": req.Pool, "caller": callerName(r.Context()), "version": resp.Version})
+	s.logger.Info("Mirroring changed via API",
+		zap.String("caller", callerName(r.Context())),
+		zap.String("previous", formatMirror(current.Proxy.Mirror)),
+		zap.String("mirror", formatMirror(next.Proxy.Mirror)),
+		zap.String("version", resp.Version))
+	w.Header().Set("ETag", `"`+resp.Version+`"`)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// mirrorResponse reports the mirror settings and the shadow pool's
+// backends.
+func mirrorResponse(p config.ProxyConfig) MirrorResponse {
+	resp := MirrorResponse{Pool: p.Mirror.Pool, Percent: p.Mirror.Percent, Backends: []string{}}
+	for _, b := range p.MirrorBackends() {
+		resp.Backends = append(resp.Backends, b.Address)
+	}
+	return resp
+}
+
+// formatMirror renders mirror settings for logs and events, e.g.
+// "10% to shadow".
+func formatMirror(m config.MirrorConfig) string {
+	if m.Pool == "" {
+		return "off"
+	}
+	return fmt.Sprintf("%d%% to %s", m.Percent, m.Pool)
+}
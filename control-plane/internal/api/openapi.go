@@ -0,0 +1,230 @@
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/auth"
+	"github.com/lazzerex/aegis/control-plane/internal/version"
+)
+
+//go:embed docs.html
+var docsHTML []byte
+
+// handleOpenAPI serves the OpenAPI 3 document for /api/v1, generated from
+// the route table and the request/response types.
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openAPIDocument(s.apiRoutes()))
+}
+
+// handleAPIDocs serves Swagger UI pointed at /api/v1/openapi.json. The page
+// is embedded; the Swagger UI scripts themselves load from a CDN.
+func (s *Server) handleAPIDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsHTML)
+}
+
+// chiParam matches a chi path parameter, with or without a regexp.
+var chiParam = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+type jsonObject = map[string]interface{}
+
+func openAPIDocument(routes []route) jsonObject {
+	g := &schemaGen{components: jsonObject{}}
+	paths := jsonObject{}
+	for _, rt := range routes {
+		path := chiParam.ReplaceAllString(rt.pattern, "{$1}")
+		item, _ := paths[path].(jsonObject)
+		if item == nil {
+			item = jsonObject{}
+			paths[path] = item
+		}
+		item[strings.ToLower(rt.method)] = g.operation(rt)
+	}
+	g.components["ErrorResponse"] = g.schema(reflect.TypeOf(ErrorResponse{}))
+
+	return jsonObject{
+		"openapi": "3.0.3",
+		"info": jsonObject{
+			"title":   "Aegis admin API",
+			"version": version.Version,
+		},
+		"servers": []jsonObject{{"url": "/api/v1"}},
+		"paths":   paths,
+		"components": jsonObject{
+			"schemas": g.components,
+			"securitySchemes": jsonObject{
+				"bearerAuth": jsonObject{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+func (g *schemaGen) operation(rt route) jsonObject {
+	op := jsonObject{"summary": rt.summary}
+
+	var params []jsonObject
+	for _, m := range chiParam.FindAllStringSubmatch(rt.pattern, -1) {
+		params = append(params, jsonObject{
+			"name": m[1], "in": "path", "required": true, "schema": jsonObject{"type": "string"},
+		})
+	}
+	for _, q := range rt.query {
+		params = append(params, jsonObject{
+			"name": q.name, "in": "query", "description": q.description, "schema": jsonObject{"type": "string"},
+		})
+	}
+	if params != nil {
+		op["parameters"] = params
+	}
+
+	if rt.body != nil {
+		types := rt.bodyTypes
+		if types == nil {
+			types = []string{"application/json"}
+		}
+		content := jsonObject{}
+		for _, t := range types {
+			content[t] = jsonObject{"schema": g.schema(reflect.TypeOf(rt.body))}
+		}
+		op["requestBody"] = jsonObject{"required": true, "content": content}
+	}
+
+	status := rt.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := jsonObject{"description": http.StatusText(status)}
+	switch {
+	case rt.stream:
+		success["description"] = "Server-sent events; each data line is one of these"
+		success["content"] = jsonObject{"text/event-stream": jsonObject{"schema": g.schema(reflect.TypeOf(rt.response))}}
+	case rt.response != nil:
+		success["content"] = jsonObject{"application/json": jsonObject{"schema": g.schema(reflect.TypeOf(rt.response))}}
+	}
+	op["responses"] = jsonObject{
+		strconv.Itoa(status): success,
+		"default": jsonObject{
+			"description": "Error",
+			"content": jsonObject{"application/json": jsonObject{
+				"schema": jsonObject{"$ref": "#/components/schemas/ErrorResponse"},
+			}},
+		},
+	}
+
+	if rt.role != auth.RoleNone {
+		op["security"] = []jsonObject{{"bearerAuth": []string{}}}
+		op["x-required-role"] = rt.role.String()
+		op["description"] = "Requires the " + rt.role.String() + " role."
+		if rt.role == auth.RoleViewer {
+			op["description"] = "Requires the viewer role when admin.protect_reads is set."
+		}
+	}
+	return op
+}
+
+// schemaGen builds JSON schemas from Go types the way encoding/json
+// encodes them. Named structs become components referenced by $ref.
+type schemaGen struct {
+	components jsonObject
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+)
+
+func (g *schemaGen) schema(t reflect.Type) jsonObject {
+	switch t {
+	case timeType:
+		return jsonObject{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return jsonObject{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := g.schema(t.Elem())
+		if _, isRef := s["$ref"]; isRef {
+			return jsonObject{"allOf": []jsonObject{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.Bool:
+		return jsonObject{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return jsonObject{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return jsonObject{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return jsonObject{"type": "number"}
+	case reflect.String:
+		return jsonObject{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return jsonObject{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return jsonObject{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name := t.Name()
+		if _, done := g.components[name]; !done {
+			// Placeholder first so recursive types terminate
+			g.components[name] = jsonObject{}
+			g.components[name] = g.object(t)
+		}
+		return jsonObject{"$ref": "#/components/schemas/" + name}
+	default:
+		return jsonObject{}
+	}
+}
+
+func (g *schemaGen) object(t reflect.Type) jsonObject {
+	props := jsonObject{}
+	var required []string
+	g.fields(t, props, &required, true)
+	obj := jsonObject{"type": "object", "properties": props}
+	if required != nil {
+		obj["required"] = required
+	}
+	return obj
+}
+
+// fields adds t's JSON fields to props. Embedded structs are flattened the
+// way encoding/json does; fields of an embedded pointer are never required.
+func (g *schemaGen) fields(t reflect.Type, props jsonObject, required *[]string, canRequire bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			embedded := f.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				g.fields(embedded, props, required, canRequire && f.Type.Kind() != reflect.Pointer)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = g.schema(f.Type)
+		if canRequire && !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+			*required = append(*required, name)
+		}
+	}
+}
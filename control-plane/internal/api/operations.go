@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// maxOperations is how many operations are remembered for polling; the
+// oldest finished ones are forgotten first.
+const maxOperations = 100
+
+// Operation states.
+const (
+	OperationRunning   = "running"
+	OperationSucceeded = "succeeded"
+	OperationFailed    = "failed"
+)
+
+// operation is a long-running admin action (currently only drains) that
+// runs in the background and is polled at GET /operations/{id}.
+type operation struct {
+	status OperationStatus
+	cancel context.CancelFunc
+	// progress, if set, is sampled into status.Progress while running.
+	progress func() map[string]int64
+}
+
+// operations tracks background operations. The zero value is ready to use.
+type operations struct {
+	mu    sync.Mutex
+	byID  map[string]*operation
+	order []string
+}
+
+// start runs fn in the background as a new operation of kind typ and
+// returns its initial status. Only one operation of a kind runs at a
+// time: if one is already running, start returns that one and false.
+// fn gets the operation's ID and a context cancelled after timeout or when
+// the server shuts down; its error, if any, becomes the result.
+func (o *operations) start(typ string, timeout time.Duration, progress func() map[string]int64, fn func(ctx context.Context, id string) (string, error)) (OperationStatus, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, id := range o.order {
+		if op := o.byID[id]; op.status.Type == typ && op.status.State == OperationRunning {
+			return op.status, false
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	deadline, _ := ctx.Deadline()
+	op := &operation{
+		status: OperationStatus{
+			ID:        newOperationID(),
+			Type:      typ,
+			State:     OperationRunning,
+			StartedAt: time.Now().UTC(),
+			Deadline:  deadline.UTC(),
+		},
+		cancel:   cancel,
+		progress: progress,
+	}
+	if o.byID == nil {
+		o.byID = make(map[string]*operation)
+	}
+	o.byID[op.status.ID] = op
+	o.order = append(o.order, op.status.ID)
+	o.evictLocked()
+
+	go func() {
+		defer cancel()
+		message, err := fn(ctx, op.status.ID)
+
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		finished := time.Now().UTC()
+		op.status.FinishedAt = &finished
+		if err != nil {
+			op.status.State = OperationFailed
+			op.status.Error = err.Error()
+		} else {
+			op.status.State = OperationSucceeded
+			op.status.Message = message
+		}
+	}()
+	return op.status, true
+}
+
+// get returns the current status of operation id.
+func (o *operations) get(id string) (OperationStatus, bool) {
+	o.mu.Lock()
+	op, ok := o.byID[id]
+	var progress func() map[string]int64
+	var status OperationStatus
+	if ok {
+		status = op.status
+		if status.State == OperationRunning {
+			progress = op.progress
+		}
+	}
+	o.mu.Unlock()
+
+	if progress != nil {
+		status.Progress = progress()
+	}
+	return status, ok
+}
+
+// cancelAll cancels every running operation, for shutdown.
+func (o *operations) cancelAll() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, op := range o.byID {
+		op.cancel()
+	}
+}
+
+func (o *operations) evictLocked() {
+	for i := 0; len(o.order) > maxOperations && i < len(o.order); {
+		id := o.order[i]
+		if o.byID[id].status.State == OperationRunning {
+			i++
+			continue
+		}
+		delete(o.byID, id)
+		o.order = append(o.order[:i], o.order[i+1:]...)
+	}
+}
+
+func newOperationID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "op-" + hex.EncodeToString(b)
+}
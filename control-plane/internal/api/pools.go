@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/lazzerex/aegis/control-plane/internal/events"
+	"go.uber.org/zap"
+)
+
+// handleSwitchPools moves all traffic from the active blue/green pool to
+// the other one in a single config push. The target pool has to pass the
+// pre-flight check first: at least blue_green.min_healthy_percent of its
+// backends healthy by their last health check, where a backend not yet
+// checked doesn't count. Switching to the pool already active changes
+// nothing. Like a traffic split change the switch lives in memory until
+// the next reload, which goes back to the file's active pool.
+func (s *Server) handleSwitchPools(w http.ResponseWriter, r *http.Request) {
+	var req PoolSwitchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	s.mu.RLock()
+	current := s.config
+	version := current.Version()
+	s.mu.RUnlock()
+
+	if !s.checkIfMatch(w, r, version) {
+		return
+	}
+	bg := current.Proxy.BlueGreen
+	if len(bg.Pools) == 0 {
+		writeError(w, r, http.StatusConflict, ErrCodeConflict, "proxy.blue_green is not configured")
+		return
+	}
+	to := req.To
+	if to == "" {
+		to = bg.Standby()
+	}
+	if to != bg.Pools[0] && to != bg.Pools[1] {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Pool %q is not one of %q", to, bg.Pools))
+		return
+	}
+
+	healthState := s.healthChecker.GetHealthState()
+	resp := PoolSwitchResponse{Active: to, Previous: bg.Active}
+	for _, b := range current.Proxy.Backends {
+		if b.Pool == to {
+			resp.Total++
+			if healthState[b.Address] {
+				resp.Healthy++
+			}
+		}
+	}
+	if to == bg.Active {
+		resp.Version = version
+		w.Header().Set("ETag", `"`+version+`"`)
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	if resp.Healthy*100 < bg.MinHealthyPercent*resp.Total {
+		writeError(w, r, http.StatusConflict, ErrCodePoolUnhealthy,
+			fmt.Sprintf("Pool %s has %d of %d backends healthy, %d%% required", to, resp.Healthy, resp.Total, bg.MinHealthyPercent))
+		return
+	}
+
+	next := *current
+	next.Proxy.BlueGreen.Active = to
+	if err := s.applyConfig(&next); err != nil {
+		s.logger.Error("Failed to push pool switch to data plane", zap.Error(err))
+		writeError(w, r, http.StatusInternalServerError, ErrCodeDataPlaneError, "Failed to update data plane: "+err.Error())
+		return
+	}
+
+	resp.Version = next.Version()
+	s.feed.Publish(events.TypePoolSwitched, fmt.Sprintf("Traffic switched from pool %s to %s", bg.Active, to),
+		map[string]string{"from": bg.Active, "to": to, "caller": callerName(r.Context()), "version": resp.Version})
+	s.logger.Info("Blue/green pool switched via API",
+		zap.String("caller", callerName(r.Context())),
+		zap.String("from", bg.Active),
+		zap.String("to", to),
+		zap.String("healthy", strconv.Itoa(resp.Healthy)+"/"+strconv.Itoa(resp.Total)),
+		zap.String("version", resp.Version))
+	w.Header().Set("ETag", `"`+resp.Version+`"`)
+	writeJSON(w, http.StatusOK, resp)
+}
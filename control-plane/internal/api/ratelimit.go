@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/events"
+	"go.uber.org/zap"
+)
+
+// handlePutRateLimit changes the global rate limit without a config push,
+// for clamping traffic during an incident. Fields left out of the body keep
+// their current value. Like backend changes, the new limit lives in memory
+// until the next reload.
+func (s *Server) handlePutRateLimit(w http.ResponseWriter, r *http.Request) {
+	updater, ok := s.grpcClient.(rateLimitUpdater)
+	if !ok {
+		writeError(w, r, http.StatusNotImplemented, ErrCodeNotSupported, "Changing the rate limit at runtime is not supported in this mode")
+		return
+	}
+
+	var req RateLimitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+	if req.RequestsPerSecond == nil && req.Burst == nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "requests_per_second or burst is required")
+		return
+	}
+	if (req.RequestsPerSecond != nil && *req.RequestsPerSecond < 0) || (req.Burst != nil && *req.Burst < 0) {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Rate limit values must be >= 0")
+		return
+	}
+
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	s.mu.RLock()
+	previous := s.config.Proxy.Traffic.RateLimit
+	s.mu.RUnlock()
+
+	limit := previous
+	if req.RequestsPerSecond != nil {
+		limit.RequestsPerSecond = *req.RequestsPerSecond
+	}
+	if req.Burst != nil {
+		limit.Burst = *req.Burst
+	}
+
+	if err := updater.UpdateRateLimit(r.Context(), limit); err != nil {
+		s.logger.Error("Failed to push rate limit to data plane", zap.Error(err))
+		writeError(w, r, http.StatusInternalServerError, ErrCodeDataPlaneError, "Failed to update data plane: "+err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.config.Proxy.Traffic.RateLimit = limit
+	s.appliedAt = time.Now()
+	s.appliedChanged()
+	s.mu.Unlock()
+
+	s.feed.Publish(events.TypeRateLimitChanged,
+		fmt.Sprintf("Rate limit set to %d rps, burst %d", limit.RequestsPerSecond, limit.Burst),
+		map[string]string{
+			"caller":              callerName(r.Context()),
+			"requests_per_second": strconv.Itoa(limit.RequestsPerSecond),
+			"burst":               strconv.Itoa(limit.Burst),
+		})
+	s.logger.Info("Rate limit changed via API",
+		zap.String("caller", callerName(r.Context())),
+		zap.Int("previous_requests_per_second", previous.RequestsPerSecond),
+		zap.Int("previous_burst", previous.Burst),
+		zap.Int("requests_per_second", limit.RequestsPerSecond),
+		zap.Int("burst", limit.Burst))
+	writeJSON(w, http.StatusOK, RateLimitResponse{
+		RequestsPerSecond: limit.RequestsPerSecond,
+		Burst:             limit.Burst,
+	})
+}
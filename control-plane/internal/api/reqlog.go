@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type requestLogKey struct{}
+
+// probePaths are polled by orchestrators every few seconds, so they're
+// only logged at debug level.
+var probePaths = map[string]bool{"/livez": true, "/readyz": true}
+
+// logRequests writes one structured access log line per admin API request
+// to the control plane's logger, and records it in the admin request
+// metrics, once the response is done. It runs inside middleware.RequestID
+// for the ID; requireRole fills in the caller.
+func (s *Server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		who := &caller{}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		defer func() {
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			latency := time.Since(start)
+			var route string
+			if rctx := chi.RouteContext(r.Context()); rctx != nil {
+				route = rctx.RoutePattern()
+			}
+			observeRequest(r.Method, route, status, latency)
+
+			level := zapcore.InfoLevel
+			switch {
+			case status >= http.StatusInternalServerError:
+				level = zapcore.WarnLevel
+			case probePaths[r.URL.Path]:
+				level = zapcore.DebugLevel
+			}
+			ce := s.logger.Check(level, "Admin API request")
+			if ce == nil {
+				return
+			}
+			fields := []zap.Field{
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.Int("status", status),
+				zap.Int("bytes", ww.BytesWritten()),
+				zap.Duration("latency", latency),
+				zap.String("remote_addr", r.RemoteAddr),
+				zap.String("request_id", middleware.GetReqID(r.Context())),
+			}
+			if who.name != "" {
+				fields = append(fields, zap.String("caller", who.name), zap.Stringer("role", who.role))
+			}
+			ce.Write(fields...)
+		}()
+
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), requestLogKey{}, who)))
+	})
+}
@@ -0,0 +1,269 @@
+package api
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/auth"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRouter_V1AndLegacyPaths(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{"localhost:3000": true}}, "")
+	h := s.router()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/backends", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/api/v1/backends: got %d", rec.Code)
+	}
+	if rec.Header().Get("Deprecation") != "" {
+		t.Error("versioned path marked deprecated")
+	}
+	var resp BackendListResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || len(resp.Backends) != 2 {
+		t.Errorf("backend list: got %+v (%v)", resp, err)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/backends", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("legacy /backends: got %d", rec.Code)
+	}
+	if rec.Header().Get("Deprecation") != "true" || rec.Header().Get("Link") != `</api/v1/backends>; rel="successor-version"` {
+		t.Errorf("legacy headers: got %v", rec.Header())
+	}
+}
+
+func TestRouter_ErrorEnvelope(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "secret")
+	h := s.router()
+
+	for _, tc := range []struct {
+		method, path, token string
+		wantStatus          int
+		wantCode            string
+	}{
+		{http.MethodDelete, "/api/v1/backends/nope:1", "secret", http.StatusNotFound, ErrCodeBackendNotFound},
+		{http.MethodPost, "/api/v1/reload", "", http.StatusUnauthorized, ErrCodeUnauthorized},
+		{http.MethodPost, "/drain", "wrong", http.StatusUnauthorized, ErrCodeUnauthorized},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		var env ErrorResponse
+		if err := json.NewDecoder(rec.Body).Decode(&env); err != nil {
+			t.Fatalf("%s %s: body is not an error envelope: %v", tc.method, tc.path, err)
+		}
+		if rec.Code != tc.wantStatus || env.Error.Code != tc.wantCode {
+			t.Errorf("%s %s: got %d %q, want %d %q", tc.method, tc.path, rec.Code, env.Error.Code, tc.wantStatus, tc.wantCode)
+		}
+		if env.Error.Message == "" || env.Error.RequestID == "" {
+			t.Errorf("%s %s: envelope missing message or request_id: %+v", tc.method, tc.path, env.Error)
+		}
+	}
+}
+
+func TestRouter_OpenAPIDocument(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "secret")
+	h := s.router()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("openapi.json: got %d", rec.Code)
+	}
+	var doc struct {
+		OpenAPI    string                               `json:"openapi"`
+		Paths      map[string]map[string]map[string]any `json:"paths"`
+		Components struct{ Schemas map[string]any }     `json:"components"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if doc.OpenAPI != "3.0.3" {
+		t.Errorf("openapi version: got %q", doc.OpenAPI)
+	}
+	for _, rt := range s.apiRoutes() {
+		path := chiParam.ReplaceAllString(rt.pattern, "{$1}")
+		op, ok := doc.Paths[path][strings.ToLower(rt.method)]
+		if !ok {
+			t.Errorf("%s %s missing from the document", rt.method, path)
+			continue
+		}
+		if _, secured := op["security"]; secured != (rt.role != auth.RoleNone) {
+			t.Errorf("%s %s: security listed = %v, want %v", rt.method, path, secured, rt.role != auth.RoleNone)
+		}
+	}
+	if _, ok := doc.Paths["/backends/{address}"]; !ok {
+		t.Error("chi regexp not stripped from /backends/{address:.+}")
+	}
+	for _, name := range []string{"BackendListResponse", "BackendEntry", "ErrorResponse"} {
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("schema %s missing", name)
+		}
+	}
+	detail, _ := doc.Components.Schemas["BackendDetail"].(map[string]any)
+	props, _ := detail["properties"].(map[string]any)
+	for _, field := range []string{"address", "active_connections", "health_history"} {
+		if _, ok := props[field]; !ok {
+			t.Errorf("BackendDetail schema missing flattened field %q: %v", field, detail)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/docs", nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("docs: got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestRouter_UI(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "secret")
+	rec := httptest.NewRecorder()
+	s.router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ui", nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("/ui: got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	// The page's actions go through the API, which does the auth
+	for _, path := range []string{"/metrics/stream", "/drain", "/backends/"} {
+		if !strings.Contains(rec.Body.String(), path) {
+			t.Errorf("/ui doesn't use %s", path)
+		}
+	}
+}
+
+func TestRouter_DebugEndpoints(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "secret")
+	h := s.router()
+	get := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("/debug/pprof/", "secret"); rec.Code != http.StatusNotFound {
+		t.Errorf("disabled: got %d, want 404", rec.Code)
+	}
+
+	s.config.Admin.DebugEndpoints = true
+	if rec := get("/debug/pprof/", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("no credentials: got %d, want 401", rec.Code)
+	}
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/goroutine?debug=1", "/debug/pprof/cmdline", "/debug/vars"} {
+		if rec := get(path, "secret"); rec.Code != http.StatusOK {
+			t.Errorf("%s: got %d, want 200", path, rec.Code)
+		}
+	}
+	if rec := get("/debug/vars", "secret"); !strings.Contains(rec.Body.String(), `"memstats"`) {
+		t.Errorf("/debug/vars: missing memstats in %.200s", rec.Body.String())
+	}
+}
+
+func TestRouter_LogsRequests(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "secret")
+	core, logs := observer.New(zapcore.InfoLevel)
+	s.logger = zap.New(core)
+	s.config.Admin.ProtectReads = true
+	h := s.router()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/backends", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Request-Id", "req-42")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/livez", nil))
+
+	entries := logs.FilterMessage("Admin API request").All()
+	if len(entries) != 1 {
+		t.Fatalf("got %d request log entries, want 1 (probes log at debug): %v", len(entries), entries)
+	}
+	fields := entries[0].ContextMap()
+	if fields["path"] != "/api/v1/backends" || fields["status"] != int64(http.StatusOK) ||
+		fields["request_id"] != "req-42" || fields["caller"] != "api_token" {
+		t.Errorf("fields: got %v", fields)
+	}
+	if _, ok := fields["latency"]; !ok {
+		t.Errorf("no latency in %v", fields)
+	}
+}
+
+func TestRouter_RecordsRequestMetrics(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
+	h := s.router()
+
+	route := "/api/v1/backends/{address:.+}"
+	before := testutil.ToFloat64(adminRequests.WithLabelValues(http.MethodGet, route, "404"))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/backends/nope:1", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/backends/other:1", nil))
+
+	if got := testutil.ToFloat64(adminRequests.WithLabelValues(http.MethodGet, route, "404")) - before; got != 2 {
+		t.Errorf("requests for %s: got %v, want 2 under the route pattern", route, got)
+	}
+}
+
+func TestRouter_GzipNegotiation(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
+	h := s.router()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/backends", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding: got %q, want gzip", rec.Header().Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp BackendListResponse
+	if err := json.NewDecoder(zr).Decode(&resp); err != nil || len(resp.Backends) != 2 {
+		t.Errorf("decoded body: got %+v (%v)", resp, err)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/backends", nil))
+	if rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("compressed without Accept-Encoding: %q", rec.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	slow := withTimeout(20*time.Millisecond, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		w.Write([]byte("too late"))
+	}))
+	rec := httptest.NewRecorder()
+	slow.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	var env ErrorResponse
+	json.NewDecoder(rec.Body).Decode(&env)
+	if rec.Code != http.StatusServiceUnavailable || env.Error.Code != ErrCodeTimeout {
+		t.Errorf("slow handler: got %d %q, want 503 %q", rec.Code, env.Error.Code, ErrCodeTimeout)
+	}
+
+	fast := withTimeout(time.Second, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "1")
+		writeJSON(w, http.StatusCreated, map[string]string{"ok": "yes"})
+	}))
+	rec = httptest.NewRecorder()
+	fast.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/fast", nil))
+	if rec.Code != http.StatusCreated || rec.Header().Get("X-Test") != "1" || !strings.Contains(rec.Body.String(), `"ok":"yes"`) {
+		t.Errorf("fast handler: got %d %v %q", rec.Code, rec.Header(), rec.Body.String())
+	}
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lazzerex/aegis/control-plane/internal/auth"
+	"github.com/lazzerex/aegis/control-plane/internal/events"
+)
+
+// route is one admin API endpoint. The same table registers the handlers
+// and generates the OpenAPI document, so the two can't drift apart.
+type route struct {
+	method string
+	// pattern is the chi pattern relative to the mount point.
+	pattern string
+	// role is the minimum role required; RoleNone means no auth at all.
+	role    auth.Role
+	handler http.HandlerFunc
+	summary string
+	query   []queryParam
+	// body is a value of the request body type, nil for none.
+	body interface{}
+	// bodyTypes overrides the request body's media types (default JSON).
+	bodyTypes []string
+	// status is the success status, 200 if zero.
+	status int
+	// response is a value of the success response body type, nil for none.
+	response interface{}
+	// stream marks a text/event-stream response.
+	stream bool
+	// local marks a change to this replica alone, which an HA follower
+	// makes too; it refuses every other change.
+	local bool
+}
+
+type queryParam struct {
+	name        string
+	description string
+}
+
+var (
+	persistParam = queryParam{"persist", "true or false: also write the change to the config file (default: admin.persist_backends)"}
+	dryRunParam  = queryParam{"dry_run", "true to have the data plane validate the config without applying it"}
+)
+
+// apiRoutes lists every /api/v1 endpoint.
+func (s *Server) apiRoutes() []route {
+	return []route{
+		{method: http.MethodGet, pattern: "/health", role: auth.RoleNone, handler: s.handleHealth,
+			summary: "Backend health and data plane connectivity", response: HealthResponse{}},
+		{method: http.MethodGet, pattern: "/status", role: auth.RoleViewer, handler: s.handleStatus,
+			summary: "Version and running configuration summary", response: StatusResponse{}},
+		{method: http.MethodGet, pattern: "/version", role: auth.RoleViewer, handler: s.handleVersion,
+			summary: "Build version, commit, build date and Go version", response: VersionResponse{}},
+		{method: http.MethodGet, pattern: "/config", role: auth.RoleOperator, handler: s.handleGetConfig,
+			summary: "The running config with secrets redacted; YAML with ?format=yaml",
+			query:   []queryParam{{"format", "json (default) or yaml"}}, response: ConfigResponse{}},
+		{method: http.MethodPut, pattern: "/config", role: auth.RoleAdmin, handler: s.handlePutConfig,
+			summary: "Replace the running config; conditional on If-Match",
+			query:   []queryParam{dryRunParam}, body: "", bodyTypes: []string{"application/yaml", "application/json"},
+			response: ConfigAppliedResponse{}},
+		{method: http.MethodGet, pattern: "/backends", role: auth.RoleViewer, handler: s.handleListBackends,
+			summary: "List backends with health, circuit state and stats", response: BackendListResponse{}},
+		{method: http.MethodGet, pattern: "/metrics", role: auth.RoleViewer, handler: s.handleMetrics,
+			summary: "Latest metrics snapshot, global and per backend", response: MetricsSnapshot{}},
+		{method: http.MethodGet, pattern: "/metrics/stream", role: auth.RoleViewer, handler: s.handleMetricsStream,
+			summary: "Live metrics snapshots as server-sent \"metrics\" events", response: MetricsSnapshot{}, stream: true},
+		{method: http.MethodGet, pattern: "/top", role: auth.RoleViewer, handler: s.handleTop,
+			summary: "Backends and clients ranked by traffic and error rate over metrics.top_window",
+			query:   []queryParam{{"n", "Entries per ranking, 1 to 100 (default 10)"}}, response: TopResponse{}},
+		{method: http.MethodGet, pattern: "/events", role: auth.RoleViewer, handler: s.handleEvents,
+			summary: "Control plane events as server-sent events, resumable with Last-Event-ID",
+			query: []queryParam{
+				{"types", "Comma-separated event types to receive"},
+				{"last_event_id", "Resume after this event ID, for clients that can't set Last-Event-ID"},
+			}, response: events.Event{}, stream: true},
+		{method: http.MethodPost, pattern: "/reload", role: auth.RoleOperator, handler: s.handleReload,
+			summary: "Reload the config from disk, optionally from another file in its directory",
+			query:   []queryParam{dryRunParam}, body: ReloadRequest{}, response: OperationResponse{}},
+		{method: http.MethodPost, pattern: "/drain", role: auth.RoleOperator, handler: s.handleDrain,
+			summary: "Start draining connections in the background",
+			query:   []queryParam{{"timeout", "Seconds or a duration such as 90s; 1s to 1h, default 30s"}},
+			status:  http.StatusAccepted, response: OperationStatus{}},
+		{method: http.MethodGet, pattern: "/operations/{id}", role: auth.RoleOperator, handler: s.handleGetOperation,
+			summary: "Status of a background operation", response: OperationStatus{}},
+		{method: http.MethodGet, pattern: "/backends/{address:.+}", role: auth.RoleViewer, handler: s.handleGetBackend,
+			summary: "One backend with its config, health history, circuit state and stats", response: BackendDetail{}},
+		{method: http.MethodPost, pattern: "/backends", role: auth.RoleAdmin, handler: s.handleAddBackend,
+			summary: "Add a backend", query: []queryParam{persistParam}, body: AddBackendRequest{},
+			status: http.StatusCreated, response: BackendChangeResponse{}},
+		{method: http.MethodPut, pattern: "/backends/{address:.+}", role: auth.RoleAdmin, handler: s.handleUpdateBackend,
+			summary: "Create or replace a backend", query: []queryParam{persistParam}, body: UpdateBackendRequest{},
+			response: BackendChangeResponse{}},
+		{method: http.MethodPatch, pattern: "/backends/{address:.+}", role: auth.RoleOperator, handler: s.handlePatchBackend,
+			summary: "Change a backend's weight or health check", query: []queryParam{persistParam},
+			body: PatchBackendRequest{}, response: BackendEntry{}},
+		{method: http.MethodDelete, pattern: "/backends/{address:.+}", role: auth.RoleAdmin, handler: s.handleRemoveBackend,
+			summary: "Remove a backend", query: []queryParam{persistParam}, response: BackendChangeResponse{}},
+		{method: http.MethodPost, pattern: "/dataplane/commands/{name}", role: auth.RoleOperator, handler: s.handleDataPlaneCommand,
+			summary: "Run an operator command on the data plane", body: CommandRequest{}, response: CommandResponse{}},
+		{method: http.MethodPost, pattern: "/traffic/pause", role: auth.RoleOperator, handler: s.handleTraffic(true),
+			summary: "Refuse new connections, keep existing ones", response: TrafficResponse{}},
+		{method: http.MethodPost, pattern: "/traffic/resume", role: auth.RoleOperator, handler: s.handleTraffic(false),
+			summary: "Accept new connections again", response: TrafficResponse{}},
+		{method: http.MethodPut, pattern: "/rate-limit", role: auth.RoleOperator, handler: s.handlePutRateLimit,
+			summary: "Change the global rate limit at runtime", body: RateLimitRequest{}, response: RateLimitResponse{}},
+		{method: http.MethodGet, pattern: "/traffic-split", role: auth.RoleViewer, handler: s.handleGetTrafficSplit,
+			summary: "Percentage of traffic per backend pool", response: TrafficSplitResponse{}},
+		{method: http.MethodPut, pattern: "/traffic-split", role: auth.RoleOperator, handler: s.handlePutTrafficSplit,
+			summary: "Set the percentage of traffic per backend pool, e.g. for a canary", body: TrafficSplitRequest{},
+			response: TrafficSplitResponse{}},
+		{method: http.MethodGet, pattern: "/mirror", role: auth.RoleViewer, handler: s.handleGetMirror,
+			summary: "Traffic mirroring to the shadow pool", response: MirrorResponse{}},
+		{method: http.MethodPut, pattern: "/mirror", role: auth.RoleOperator, handler: s.handlePutMirror,
+			summary: "Set the shadow pool and the percentage of connections mirrored to it", body: MirrorRequest{},
+			response: MirrorResponse{}},
+		{method: http.MethodGet, pattern: "/header-rules", role: auth.RoleViewer, handler: s.handleGetHeaderRules,
+			summary: "Rules sending connections to a backend pool by their HTTP request headers", response: HeaderRulesResponse{}},
+		{method: http.MethodPut, pattern: "/header-rules", role: auth.RoleOperator, handler: s.handlePutHeaderRules,
+			summary: "Replace the header rules", body: HeaderRulesRequest{}, response: HeaderRulesResponse{}},
+		{method: http.MethodGet, pattern: "/dark-launches", role: auth.RoleViewer, handler: s.handleGetDarkLaunches,
+			summary: "Rules sending a share of connections to an experimental pool during time windows", response: DarkLaunchesResponse{}},
+		{method: http.MethodPut, pattern: "/dark-launches", role: auth.RoleOperator, handler: s.handlePutDarkLaunches,
+			summary: "Replace the dark launch rules", body: DarkLaunchesRequest{}, response: DarkLaunchesResponse{}},
+		{method: http.MethodPost, pattern: "/pools/switch", role: auth.RoleOperator, handler: s.handleSwitchPools,
+			summary: "Switch blue/green traffic to the standby pool once its backends are healthy", body: PoolSwitchRequest{},
+			response: PoolSwitchResponse{}},
+		{method: http.MethodGet, pattern: "/canary", role: auth.RoleViewer, handler: s.handleGetCanary,
+			summary: "Progress of the canary rollout in progress, or the last one", response: CanaryResponse{}},
+		{method: http.MethodPost, pattern: "/canary", role: auth.RoleOperator, handler: s.handleStartCanary,
+			summary: "Shift traffic to a canary pool in steps, rolling back if its error rate or latency regresses",
+			body:    CanaryRequest{}, status: http.StatusAccepted, response: CanaryResponse{}},
+		{method: http.MethodDelete, pattern: "/canary", role: auth.RoleOperator, handler: s.handleAbortCanary,
+			summary: "Abort the canary rollout, putting all traffic back on the baseline pool", response: CanaryResponse{}},
+		{method: http.MethodGet, pattern: "/slow-start", role: auth.RoleViewer, handler: s.handleGetSlowStart,
+			summary: "Backends added to the running config on their way up to their weight", response: SlowStartResponse{}},
+		{method: http.MethodGet, pattern: "/discovery", role: auth.RoleViewer, handler: s.handleGetDiscovery,
+			summary: "Service discovery, with the discovered set held back for shrinking the pool too far", response: DiscoveryResponse{}},
+		{method: http.MethodPost, pattern: "/discovery/confirm", role: auth.RoleOperator, handler: s.handleConfirmDiscovery,
+			summary: "Push the discovered set held back for shrinking the pool too far", status: http.StatusAccepted,
+			response: DiscoveryShrink{}},
+		{method: http.MethodGet, pattern: "/certificates", role: auth.RoleViewer, handler: s.handleGetCertificates,
+			summary: "Certificates the data plane terminates TLS with, with their expiry and renewal failures", response: CertificatesResponse{}},
+		{method: http.MethodGet, pattern: "/schedules", role: auth.RoleViewer, handler: s.handleGetSchedules,
+			summary: "Scheduled config overlays, with their next and last application", response: SchedulesResponse{}},
+		{method: http.MethodGet, pattern: "/loglevel", role: auth.RoleOperator, handler: s.handleGetLogLevel,
+			summary: "The control plane's log level", response: LogLevelResponse{}},
+		{method: http.MethodPut, pattern: "/loglevel", role: auth.RoleOperator, handler: s.handlePutLogLevel,
+			summary: "Change the control plane's log level, optionally for a while", body: LogLevelRequest{}, response: LogLevelResponse{},
+			local: true},
+		{method: http.MethodGet, pattern: "/circuit-breakers", role: auth.RoleViewer, handler: s.handleListCircuitBreakers,
+			summary: "Circuit breaker state per backend", response: CircuitBreakersResponse{}},
+		{method: http.MethodPost, pattern: "/circuit-breakers/{address}/reset", role: auth.RoleOperator, handler: s.handleResetCircuitBreaker,
+			summary: "Close a backend's circuit breaker", response: CircuitResetResponse{}},
+		{method: http.MethodGet, pattern: "/connections", role: auth.RoleOperator, handler: s.handleListConnections,
+			summary: "Active TCP connections through the data plane, oldest first",
+			query: []queryParam{
+				{"backend", "Only connections routed to this backend"},
+				{"client_ip", "Only connections from this client IP"},
+			}, response: ConnectionsResponse{}},
+		{method: http.MethodDelete, pattern: "/connections/{id}", role: auth.RoleOperator, handler: s.handleCloseConnection,
+			summary: "Forcibly close an active connection", response: CloseConnectionResponse{}},
+		{method: http.MethodGet, pattern: "/audit", role: auth.RoleAdmin, handler: s.handleAudit,
+			summary: "Recent mutating API calls, newest first",
+			query: []queryParam{
+				{"caller", "Only calls by this API key name or token subject"},
+				{"method", "Only calls with this HTTP method"},
+				{"path", "Only calls to paths starting with this"},
+				{"result", "success, denied or failed"},
+				{"since", "Only calls at or after this RFC 3339 time"},
+				{"until", "Only calls before this RFC 3339 time"},
+				{"limit", "At most this many entries, 1 to 1000 (default 100)"},
+			}, response: AuditResponse{}},
+		{method: http.MethodGet, pattern: "/openapi.json", role: auth.RoleNone, handler: s.handleOpenAPI,
+			summary: "This document"},
+		{method: http.MethodGet, pattern: "/docs", role: auth.RoleNone, handler: s.handleAPIDocs,
+			summary: "Swagger UI for this document"},
+	}
+}
+
+// routes registers the API relative to its mount point. Everything but
+// the streams is bounded by admin.http.request_timeout.
+func (s *Server) routes(r chi.Router) {
+	s.mu.RLock()
+	timeout := s.config.Admin.HTTP.RequestTimeout
+	s.mu.RUnlock()
+
+	for _, rt := range s.apiRoutes() {
+		var h http.Handler = rt.handler
+		// Callers are authenticated before a follower refuses them
+		if rt.method != http.MethodGet && !rt.local {
+			h = s.leaderOnly(h)
+		}
+		if rt.role != auth.RoleNone {
+			h = s.requireRole(rt.role)(h)
+		}
+		if rt.method != http.MethodGet {
+			h = s.audited(h)
+		}
+		if timeout > 0 && !rt.stream {
+			h = withTimeout(timeout, h)
+		}
+		r.Method(rt.method, rt.pattern, h)
+	}
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"go.uber.org/zap"
+)
+
+// SetScheduler serves sched's schedules at GET /schedules and tells it
+// about every config reload or replacement.
+func (s *Server) SetScheduler(sched scheduleSource) {
+	s.scheduler = sched
+}
+
+// ApplyOverlay applies a scheduled overlay to the running config and
+// pushes it as a new version, the way an API change is. Weights for
+// backends no longer in the config are skipped with a warning rather than
+// failing the whole overlay.
+func (s *Server) ApplyOverlay(schedule string, overlay config.Overlay) (config.Overlay, string, error) {
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	s.mu.RLock()
+	current := s.config
+	s.mu.RUnlock()
+
+	next := *current
+	var undo config.Overlay
+	var missing []string
+	next.Proxy, undo, missing = overlay.Apply(current.Proxy)
+	if len(missing) > 0 {
+		s.logger.Warn("Scheduled overlay sets weights of backends not in the config",
+			zap.String("schedule", schedule),
+			zap.Strings("backends", missing))
+	}
+	if err := s.applyConfig(&next); err != nil {
+		return config.Overlay{}, "", fmt.Errorf("failed to update data plane: %w", err)
+	}
+	return undo, next.Version(), nil
+}
+
+// reloadSchedules hands the scheduler cfg's schedules after cfg replaced
+// the running config.
+func (s *Server) reloadSchedules(cfg *config.Config) {
+	if s.scheduler != nil {
+		s.scheduler.Reload(cfg.Scheduler)
+	}
+}
+
+func (s *Server) handleGetSchedules(w http.ResponseWriter, r *http.Request) {
+	resp := SchedulesResponse{Schedules: []ScheduleStatus{}}
+	if s.scheduler == nil {
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	resp.Timezone = s.scheduler.Timezone()
+	for _, st := range s.scheduler.Statuses() {
+		resp.Schedules = append(resp.Schedules, ScheduleStatus{
+			Name:            st.Name,
+			Cron:            st.Cron,
+			Duration:        formatDuration(st.Duration),
+			Active:          st.Active,
+			NextApplication: optionalTime(st.NextApply),
+			LastApplied:     optionalTime(st.LastApplied),
+			RevertAt:        optionalTime(st.RevertAt),
+			LastReverted:    optionalTime(st.LastReverted),
+			LastError:       st.LastError,
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// formatDuration renders a schedule's duration; empty for one that never
+// reverts.
+func formatDuration(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	return d.String()
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
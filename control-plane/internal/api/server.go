@@ -2,18 +2,40 @@ package api
 
 import (
 	"context"
+	"crypto/tls"
 	_ "embed"
 	"encoding/json"
+	"fmt"
 	"net/http"
-	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/lazzerex/aegis/control-plane/internal/acme"
+	"github.com/lazzerex/aegis/control-plane/internal/audit"
+	"github.com/lazzerex/aegis/control-plane/internal/auth"
+	"github.com/lazzerex/aegis/control-plane/internal/canary"
+	"github.com/lazzerex/aegis/control-plane/internal/certs"
 	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/discovery"
+	"github.com/lazzerex/aegis/control-plane/internal/events"
+	"github.com/lazzerex/aegis/control-plane/internal/grpc"
+	"github.com/lazzerex/aegis/control-plane/internal/health"
 	"github.com/lazzerex/aegis/control-plane/internal/metrics"
+	"github.com/lazzerex/aegis/control-plane/internal/schedule"
+	"github.com/lazzerex/aegis/control-plane/internal/slowstart"
+	"github.com/lazzerex/aegis/control-plane/internal/store"
+	"github.com/lazzerex/aegis/control-plane/internal/version"
+	pb "github.com/lazzerex/aegis/control-plane/proto"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 //go:embed dashboard.html
@@ -30,6 +52,12 @@ type healthStateTracker interface {
 	Reload(cfg *config.Config)
 }
 
+// healthHistoryProvider is implemented by the health checker; without it
+// GET /backends/{address} omits health_history.
+type healthHistoryProvider interface {
+	HealthHistory(address string) (health.History, bool)
+}
+
 // circuitStateProvider is optional — a Server without one (e.g. in tests)
 // just omits circuit_state and per-backend stats from backend responses.
 type circuitStateProvider interface {
@@ -37,55 +65,300 @@ type circuitStateProvider interface {
 	BackendStats() map[string]metrics.BackendStat
 }
 
+// metricsSubscriber is implemented by the metrics collector; it backs
+// GET /metrics/stream.
+type metricsSubscriber interface {
+	Subscribe() (<-chan *pb.MetricsData, func())
+}
+
+// metricsSource is implemented by the metrics collector; it backs GET
+// /metrics.
+type metricsSource interface {
+	Latest() *pb.MetricsData
+}
+
+// topTalkersSource is implemented by the metrics collector; it backs GET
+// /top.
+type topTalkersSource interface {
+	Top(n int) metrics.Top
+}
+
+// dataPlaneInfoProvider is implemented by the gRPC client but not the xDS
+// server; /status includes the handshake result only when it's available.
+type dataPlaneInfoProvider interface {
+	DataPlaneInfo() *grpc.DataPlaneInfo
+}
+
+// commandExecutor is implemented by the gRPC client only; the xDS server
+// has no data plane process to send operator commands to.
+type commandExecutor interface {
+	ExecuteCommand(ctx context.Context, name string, args map[string]string) (*grpc.CommandResult, error)
+}
+
+// connectivityReporter is implemented by the gRPC client only; /health
+// includes data_plane when it's available.
+type connectivityReporter interface {
+	Connectivity() grpc.Connectivity
+}
+
+// configValidator is implemented by the gRPC client only; it backs
+// POST /reload?dry_run=true.
+type configValidator interface {
+	ValidateConfig(ctx context.Context, cfg *config.Config) error
+}
+
+// rateLimitUpdater is implemented by the gRPC client only; it backs
+// PUT /rate-limit.
+type rateLimitUpdater interface {
+	UpdateRateLimit(ctx context.Context, limit config.RateLimitConfig) error
+}
+
+// connectionManager is implemented by the gRPC client only; it backs
+// GET /connections and DELETE /connections/{id}.
+type connectionManager interface {
+	ListConnections(ctx context.Context, filter grpc.ConnectionFilter) ([]grpc.Connection, error)
+	CloseConnection(ctx context.Context, id uint64) (*grpc.Connection, error)
+}
+
+// scheduleSource is implemented by the scheduler; it backs GET /schedules
+// and is told the new schedules whenever the whole config is replaced.
+type scheduleSource interface {
+	Statuses() []schedule.Status
+	Timezone() string
+	Reload(cfg config.SchedulerConfig)
+}
+
+// canaryController is implemented by the canary controller; it backs
+// /canary.
+type canaryController interface {
+	Status() (canary.Status, bool)
+	Start(spec canary.Spec, caller string) (canary.Status, error)
+	Abort(caller string) (canary.Status, error)
+}
+
+// slowStarter is implemented by the slow start controller; it ramps up
+// the backends that changes add and backs /slow-start.
+type slowStarter interface {
+	Begin(previous, next []config.Backend, cfg config.SlowStartConfig) ([]config.Backend, func())
+	Targets(backends []config.Backend) []config.Backend
+	Ramps() []slowstart.Ramp
+}
+
+// darkLauncher is implemented by the dark launch controller; it's told
+// about every config push so it knows when the next window opens or
+// closes.
+type darkLauncher interface {
+	Changed()
+}
+
+// discoverer is implemented by the discovery controller; it's told about
+// every config push so a changed discovery config is looked up at once,
+// and backs /discovery.
+type discoverer interface {
+	Changed()
+	Held() (discovery.Shrink, bool)
+	Confirm(caller string) (discovery.Shrink, error)
+}
+
+// backendsFileWatcher is implemented by the backends file watcher; it's
+// told about every config push so a changed proxy.backends_file is read at
+// once.
+type backendsFileWatcher interface {
+	Changed()
+}
+
+// certificateManager is implemented by the ACME certificate manager; it's
+// told about every config push so changed certificates are obtained at
+// once, and backs /certificates.
+type certificateManager interface {
+	Changed()
+	Status() []acme.Status
+}
+
+// certificateWatcher is implemented by the watcher of the operator's
+// certificate files, told about config pushes and backing /certificates
+// in the same way.
+type certificateWatcher interface {
+	Changed()
+	Status() []certs.FileStatus
+}
+
+// leadership is implemented by the leader elector of an HA control plane.
+type leadership interface {
+	Identity() string
+	Leader() (identity string, leading bool)
+}
+
+// backendSetTracker is implemented by the metrics collector; it's told the
+// backend set after every change so removed backends' series go away.
+type backendSetTracker interface {
+	SetBackends(addresses []string)
+}
+
 type Server struct {
-	mu            sync.RWMutex
-	config        *config.Config
+	mu sync.RWMutex
+	// applyMu serializes changes pushed to the data plane, held across the
+	// push so the in-memory config is only updated once it's accepted.
+	applyMu sync.Mutex
+	config  *config.Config
+	// appliedAt is when config was last accepted by the data plane.
+	appliedAt time.Time
+	// configPath is guarded by applyMu.
 	configPath    string
 	grpcClient    grpcBackendClient
 	healthChecker healthStateTracker
 	circuitStates circuitStateProvider
-	logger        *zap.Logger
-	server        *http.Server
+	// verifier is built once at startup; changing admin.oidc needs a
+	// restart, unlike API keys which are re-read on every request.
+	verifier *auth.Verifier
+	ops      operations
+	// feed backs GET /events; nil when the server was built without one.
+	feed *events.Feed
+	// audit records mutating calls and backs GET /audit; may be nil.
+	audit *audit.Log
+	// scheduler is nil when the server was built without one.
+	scheduler scheduleSource
+	// canary is nil when the server was built without one.
+	canary canaryController
+	// slowStart is nil when the server was built without one.
+	slowStart slowStarter
+	// darkLaunch is nil when the server was built without one.
+	darkLaunch darkLauncher
+	// discovery is nil when the server was built without one.
+	discovery discoverer
+	// backendsFile is nil when the server was built without one.
+	backendsFile backendsFileWatcher
+	// certificates is nil when the server was built without a manager.
+	certificates certificateManager
+	// certificateFiles is nil when the server was built without a watcher.
+	certificateFiles certificateWatcher
+	// leader is nil unless ha is enabled, and tookOver is set once this
+	// replica, elected, has taken over.
+	leader   leadership
+	tookOver atomic.Bool
+	// store keeps the version applied last, written by PersistState when
+	// applied wakes it; nil when the server was built without one.
+	store    store.Store
+	applied  chan struct{}
+	logger   *zap.Logger
+	logLevel logLevel
+	server   *http.Server
 }
 
 func NewServer(cfg *config.Config, configPath string, client grpcBackendClient, checker healthStateTracker, circuitStates circuitStateProvider, logger *zap.Logger) *Server {
-	return &Server{
+	s := &Server{
 		config:        cfg,
+		appliedAt:     time.Now(),
 		configPath:    configPath,
 		grpcClient:    client,
 		healthChecker: checker,
 		circuitStates: circuitStates,
+		applied:       make(chan struct{}, 1),
 		logger:        logger,
 	}
+	if cfg.Admin.OIDC.Issuer != "" {
+		s.verifier = auth.NewVerifier(cfg.Admin.OIDC, logger)
+	}
+	return s
+}
+
+// SetEventFeed makes the server publish config applies, backend and rate
+// limit changes and drains to feed, and serve it at GET /events.
+func (s *Server) SetEventFeed(feed *events.Feed) {
+	s.feed = feed
+}
+
+// SetAuditLog records every mutating API call to log and serves it at
+// GET /audit.
+func (s *Server) SetAuditLog(log *audit.Log) {
+	s.audit = log
+}
+
+// Start serves on address, over HTTPS when tlsConfig is non-nil.
+func (s *Server) Start(address string, tlsConfig *tls.Config) error {
+	s.mu.RLock()
+	h := s.config.Admin.HTTP
+	s.mu.RUnlock()
+	s.server = &http.Server{
+		Addr:              address,
+		Handler:           s.router(),
+		ReadHeaderTimeout: h.ReadHeaderTimeout,
+		ReadTimeout:       h.ReadTimeout,
+		WriteTimeout:      h.WriteTimeout,
+		IdleTimeout:       h.IdleTimeout,
+	}
+
+	if tlsConfig != nil {
+		s.server.TLSConfig = tlsConfig
+		return s.server.ListenAndServeTLS("", "")
+	}
+	return s.server.ListenAndServe()
+}
+
+// compressibleTypes are the responses gzipped (or deflated) for clients
+// that accept it.
+var compressibleTypes = []string{"application/json", "application/yaml", "text/html", "text/plain"}
+
+// limitBody rejects request bodies over admin.http.max_body_bytes: up
+// front when Content-Length says so, otherwise once a handler reads past
+// the limit.
+func (s *Server) limitBody(next http.Handler) http.Handler {
+	s.mu.RLock()
+	limit := s.config.Admin.HTTP.MaxBodyBytes
+	s.mu.RUnlock()
+	if limit <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			writeError(w, r, http.StatusRequestEntityTooLarge, ErrCodeBodyTooLarge,
+				fmt.Sprintf("Request body exceeds the %d-byte limit", limit))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
 }
 
-func (s *Server) Start(address string) error {
+func (s *Server) router() http.Handler {
 	r := chi.NewRouter()
 
 	// Middleware
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
 	r.Use(middleware.RequestID)
+	r.Use(s.logRequests)
+	r.Use(middleware.Recoverer)
+	r.Use(s.limitBody)
+	// Negotiated per request; event streams aren't in the list
+	r.Use(middleware.Compress(5, compressibleTypes...))
 
-	// Routes
-	r.Get("/health", s.handleHealth)
-	r.Get("/status", s.handleStatus)
-	r.Get("/backends", s.handleListBackends)
+	r.Route("/api/v1", s.routes)
 	r.Get("/dashboard", s.handleDashboard)
-	r.With(s.requireToken).Post("/reload", s.handleReload)
-	r.With(s.requireToken).Post("/drain", s.handleDrain)
-	r.With(s.requireToken).Post("/backends", s.handleAddBackend)
-	r.With(s.requireToken).Delete("/backends/{address:.+}", s.handleRemoveBackend)
-
-	s.server = &http.Server{
-		Addr:    address,
-		Handler: r,
-	}
+	r.Get("/ui", s.handleUI)
+	// Orchestrator probes; unversioned by convention and never behind auth
+	r.Get("/livez", s.handleLivez)
+	r.Get("/readyz", s.handleReadyz)
+	r.Route("/debug", s.debugRoutes)
+
+	// Pre-v1 paths stay as aliases of the same handlers so existing scripts
+	// keep working; responses point at the successor.
+	r.Group(func(r chi.Router) {
+		r.Use(legacyAlias)
+		s.routes(r)
+	})
+	return r
+}
 
-	return s.server.ListenAndServe()
+// legacyAlias marks responses on unversioned paths as deprecated.
+func legacyAlias(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "</api/v1"+r.URL.Path+">; rel=\"successor-version\"")
+		next.ServeHTTP(w, r)
+	})
 }
 
 func (s *Server) Shutdown(ctx context.Context) error {
+	s.ops.cancelAll()
 	if s.server != nil {
 		return s.server.Shutdown(ctx)
 	}
@@ -93,111 +366,279 @@ func (s *Server) Shutdown(ctx context.Context) error {
 }
 
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	healthState := s.healthChecker.GetHealthState()
+	response := HealthResponse{
+		Status:   "ok",
+		Backends: s.healthChecker.GetHealthState(),
+	}
+	if reporter, ok := s.grpcClient.(connectivityReporter); ok {
+		info := reporter.Connectivity()
+		response.DataPlane = &info
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleLivez answers as long as the process can serve HTTP. It checks
+// nothing else, so a data plane or backend outage never gets the control
+// plane restarted.
+func (s *Server) handleLivez(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, LivenessResponse{Status: "ok"})
+}
+
+// handleReadyz answers 503 while any of the admin.readiness checks fails,
+// so orchestrators only route admin traffic to a control plane that can
+// actually change the data plane.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	readiness := s.config.Admin.Readiness
+	s.mu.RUnlock()
+
+	var conn *grpc.Connectivity
+	if reporter, ok := s.grpcClient.(connectivityReporter); ok {
+		info := reporter.Connectivity()
+		conn = &info
+	}
 
-	response := map[string]interface{}{
-		"status":   "ok",
-		"backends": healthState,
+	response := ReadinessResponse{Status: "ready", Checks: make([]ReadinessCheck, 0, len(readiness.Checks))}
+	for _, name := range readiness.Checks {
+		check := ReadinessCheck{Name: name, OK: true}
+		switch name {
+		case config.ReadyCheckConfig:
+			// Without a connectivity reporter (xDS mode) the initial push
+			// at startup is the only one that had to succeed
+			if conn != nil && conn.LastConfigPush == nil {
+				check.OK = false
+				check.Message = "no config has been accepted by the data plane yet"
+			}
+		case config.ReadyCheckDataPlane:
+			if conn != nil && conn.State != "READY" {
+				check.OK = false
+				check.Message = "data plane connection is " + conn.State
+			}
+		case config.ReadyCheckLeader:
+			if status := s.haStatus(); status != nil && !status.Leading {
+				check.OK = false
+				check.Message = "this replica is a follower"
+				if status.Leader != "" {
+					check.Message += "; the leader is " + status.Leader
+				}
+			}
+		case config.ReadyCheckBackends:
+			healthy := 0
+			for _, ok := range s.healthChecker.GetHealthState() {
+				if ok {
+					healthy++
+				}
+			}
+			if healthy < readiness.MinHealthyBackends {
+				check.OK = false
+				check.Message = fmt.Sprintf("%d healthy backends, need %d", healthy, readiness.MinHealthyBackends)
+			}
+		}
+		if !check.OK {
+			response.Status = "not_ready"
+		}
+		response.Checks = append(response.Checks, check)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	code := http.StatusOK
+	if response.Status != "ready" {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, response)
 }
 
 func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
-	response := map[string]interface{}{
-		"version": "0.1.0",
-		"config": map[string]interface{}{
-			"backends":             len(s.config.Proxy.Backends),
-			"algorithm":            s.config.Proxy.LoadBalancing.Algorithm,
-			"session_affinity":     s.config.Proxy.LoadBalancing.SessionAffinity,
-			"rate_limit_rps":       s.config.Proxy.Traffic.RateLimit.RequestsPerSecond,
-			"rate_limit_burst":     s.config.Proxy.Traffic.RateLimit.Burst,
-			"cb_threshold":         s.config.Proxy.CircuitBreaker.ErrorThreshold,
-			"cb_timeout_secs":      s.config.Proxy.CircuitBreaker.Timeout.Seconds(),
-			"connect_timeout_secs": s.config.Proxy.Traffic.Timeout.Connect.Seconds(),
-			"idle_timeout_secs":    s.config.Proxy.Traffic.Timeout.Idle.Seconds(),
-			"read_timeout_secs":    s.config.Proxy.Traffic.Timeout.Read.Seconds(),
+	s.mu.RLock()
+	cfg := s.config
+	s.mu.RUnlock()
+
+	response := StatusResponse{
+		Version: version.Version,
+		Config: StatusConfig{
+			Backends:           len(cfg.Proxy.Backends),
+			Algorithm:          cfg.Proxy.LoadBalancing.Algorithm,
+			SessionAffinity:    cfg.Proxy.LoadBalancing.SessionAffinity,
+			UDPAffinity:        cfg.Proxy.LoadBalancing.UDPAffinity.Mode,
+			StickyCookie:       cfg.Proxy.LoadBalancing.StickyCookie.Name,
+			RateLimitRPS:       cfg.Proxy.Traffic.RateLimit.RequestsPerSecond,
+			RateLimitBurst:     cfg.Proxy.Traffic.RateLimit.Burst,
+			CBThreshold:        cfg.Proxy.CircuitBreaker.ErrorThreshold,
+			CBTimeoutSecs:      cfg.Proxy.CircuitBreaker.Timeout.Seconds(),
+			ConnectTimeoutSecs: cfg.Proxy.Traffic.Timeout.Connect.Seconds(),
+			IdleTimeoutSecs:    cfg.Proxy.Traffic.Timeout.Idle.Seconds(),
+			ReadTimeoutSecs:    cfg.Proxy.Traffic.Timeout.Read.Seconds(),
 		},
 	}
+	if p, ok := s.grpcClient.(dataPlaneInfoProvider); ok {
+		// nil until the first config push has completed the handshake
+		response.DataPlane = p.DataPlaneInfo()
+	}
+	response.HA = s.haStatus()
+	response.Applied = s.storedApplied(r.Context())
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, http.StatusOK, response)
 }
 
+// handleVersion reports exactly which control plane build is running, and
+// the data plane's version once the handshake has reported it.
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	response := VersionResponse{Info: version.Get()}
+	if p, ok := s.grpcClient.(dataPlaneInfoProvider); ok {
+		if info := p.DataPlaneInfo(); info != nil {
+			response.DataPlaneVersion = info.Version
+		}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleReload re-reads the config file and applies it. The optional JSON
+// body {"path": "..."} reads another file instead, which then becomes the
+// file later reloads and persisted backend changes use.
 func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
-	cfg, err := config.Load(s.configPath)
+	var req ReloadRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request: body must be a JSON object")
+			return
+		}
+	}
+
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	path := s.configPath
+	if req.Path != "" {
+		var err error
+		if path, err = resolveConfigPath(s.configPath, req.Path); err != nil {
+			writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid path: "+err.Error())
+			return
+		}
+	}
+
+	cfg, err := config.Load(path)
 	if err != nil {
-		s.logger.Error("Failed to reload config", zap.Error(err))
-		http.Error(w, "Failed to reload configuration", http.StatusInternalServerError)
+		countReload("file", err)
+		s.logger.Error("Failed to reload config", zap.String("path", path), zap.Error(err))
+		writeError(w, r, http.StatusInternalServerError, ErrCodeConfigLoadFailed, "Failed to reload configuration: "+err.Error())
 		return
 	}
 
-	if err := s.grpcClient.UpdateConfig(cfg); err != nil {
+	if r.URL.Query().Get("dry_run") == "true" {
+		s.dryRunReload(w, r, cfg)
+		return
+	}
+
+	err = s.applyConfig(cfg)
+	countReload("file", err)
+	if err != nil {
 		s.logger.Error("Failed to update data plane config", zap.Error(err))
-		http.Error(w, "Failed to update data plane", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeDataPlaneError, "Failed to update data plane")
 		return
 	}
+	s.reloadSchedules(cfg)
+	if path != s.configPath {
+		s.logger.Info("Config file changed by reload", zap.String("from", s.configPath), zap.String("to", path))
+		s.configPath = path
+	}
+
+	writeJSON(w, http.StatusOK, OperationResponse{
+		Status:  "reloaded",
+		Message: "Configuration reloaded successfully",
+		Path:    path,
+	})
+}
+
+// resolveConfigPath resolves a reload path against the current config
+// file's directory and refuses anything outside it (after symlinks), or
+// not named *.yaml/*.yml: load errors quote the file back to the caller,
+// so an arbitrary path would let an operator read files off the host.
+func resolveConfigPath(current, requested string) (string, error) {
+	dir, err := filepath.EvalSymlinks(filepath.Dir(current))
+	if err != nil {
+		return "", err
+	}
+	path := requested
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(current), path)
+	}
+	if ext := filepath.Ext(path); ext != ".yaml" && ext != ".yml" {
+		return "", fmt.Errorf("%s is not a .yaml or .yml file", requested)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(dir, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the config directory %s", requested, dir)
+	}
+	return path, nil
+}
+
+// applyConfig pushes cfg to the data plane and, once it's accepted, makes
+// it the running config. The caller holds applyMu.
+func (s *Server) applyConfig(cfg *config.Config) error {
+	s.mu.RLock()
+	previousProxy := s.config.Proxy
+	s.mu.RUnlock()
+	previous := previousProxy.Backends
+	// The backends discovered so far stay until the next lookup
+	cfg.Proxy.Backends = mergeDiscovered(cfg.Proxy, previousProxy)
+	var commit func()
+	cfg.Proxy.Backends, commit = s.beginSlowStart(previous, cfg.Proxy.Backends, cfg.Proxy.LoadBalancing.SlowStart)
+	if err := s.grpcClient.UpdateConfig(cfg); err != nil {
+		return err
+	}
+	commit()
 
 	s.healthChecker.Reload(cfg)
+	s.trackBackends(cfg.Proxy)
 
 	s.mu.Lock()
 	s.config = cfg
+	s.appliedAt = time.Now()
+	s.appliedChanged()
 	s.mu.Unlock()
+	s.darkLaunchesChanged()
+	s.discoveryChanged()
+	s.backendsFileChanged()
+	s.certificatesChanged()
+
+	version := cfg.Version()
+	s.feed.Publish(events.TypeConfigApplied, "Configuration "+version+" applied",
+		map[string]string{"version": version})
+	return nil
+}
 
-	response := map[string]interface{}{
-		"status":  "reloaded",
-		"message": "Configuration reloaded successfully",
+// trackBackends tells the metrics collector, if it's there, which
+// backends are configured now.
+func (s *Server) trackBackends(proxy config.ProxyConfig) {
+	if t, ok := s.circuitStates.(backendSetTracker); ok {
+		t.SetBackends(proxy.BackendAddresses())
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
 }
 
-func (s *Server) handleListBackends(w http.ResponseWriter, r *http.Request) {
-	healthState := s.healthChecker.GetHealthState()
-
-	var circuitStates map[string]string
-	var backendStats map[string]metrics.BackendStat
-	if s.circuitStates != nil {
-		circuitStates = s.circuitStates.BackendCircuitStates()
-		backendStats = s.circuitStates.BackendStats()
+// dryRunReload has the data plane validate cfg (from disk, or a PUT body)
+// without applying it, so an operator can check an edit before it's live.
+func (s *Server) dryRunReload(w http.ResponseWriter, r *http.Request, cfg *config.Config) {
+	validator, ok := s.grpcClient.(configValidator)
+	if !ok {
+		writeError(w, r, http.StatusNotImplemented, ErrCodeNotSupported, "Dry run is not supported in this mode")
+		return
+	}
+	if err := validator.ValidateConfig(r.Context(), cfg); err != nil {
+		s.logger.Warn("Dry-run reload rejected", zap.Error(err))
+		writeError(w, r, http.StatusUnprocessableEntity, ErrCodeConfigRejected, err.Error())
+		return
 	}
 
-	s.mu.RLock()
-	backends := buildBackendEntries(s.config.Proxy.Backends, healthState, circuitStates, backendStats)
-	udpBackends := buildBackendEntries(s.config.Proxy.UdpBackends, healthState, circuitStates, backendStats)
-	s.mu.RUnlock()
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"backends":     backends,
-		"udp_backends": udpBackends,
+	writeJSON(w, http.StatusOK, OperationResponse{
+		Status:  "valid",
+		Message: "Configuration accepted by the data plane (not applied)",
 	})
 }
 
-func buildBackendEntries(backends []config.Backend, healthState map[string]bool, circuitStates map[string]string, backendStats map[string]metrics.BackendStat) []map[string]interface{} {
-	entries := make([]map[string]interface{}, len(backends))
-	for i, b := range backends {
-		entry := map[string]interface{}{
-			"address": b.Address,
-			"weight":  b.Weight,
-			"healthy": healthState[b.Address],
-		}
-		if state, ok := circuitStates[b.Address]; ok {
-			entry["circuit_state"] = state
-		}
-		if stat, ok := backendStats[b.Address]; ok {
-			entry["active_connections"] = stat.ActiveConnections
-			entry["total_requests"] = stat.TotalRequests
-			entry["failed_requests"] = stat.FailedRequests
-			entry["avg_latency_ms"] = stat.AvgLatencyMs
-		}
-		entries[i] = entry
-	}
-	return entries
-}
-
 // handleDashboard serves a lightweight, read-only static page (no auth,
 // same as /health and /backends) that polls the existing JSON endpoints
 // client-side. Not a management UI — no write actions are exposed here.
@@ -206,133 +647,191 @@ func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
 	w.Write(dashboardHTML)
 }
 
-func (s *Server) handleAddBackend(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Address string `json:"address"`
-		Weight  int    `json:"weight"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Address == "" {
-		http.Error(w, "Invalid request: address required", http.StatusBadRequest)
+// handleDrain starts draining connections in the background and returns
+// the operation to poll. ?timeout= is seconds or a Go duration ("90s"),
+// 30s by default.
+func (s *Server) handleDrain(w http.ResponseWriter, r *http.Request) {
+	timeout, err := parseDrainTimeout(r.URL.Query().Get("timeout"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid timeout: "+err.Error())
 		return
 	}
-	if req.Weight <= 0 {
-		req.Weight = 100
+
+	// The RPC gets a little longer than the data plane's own deadline so a
+	// drain that uses all of it still reports its result.
+	op, started := s.ops.start("drain", timeout+drainGrace, s.drainProgress, func(ctx context.Context, opID string) (string, error) {
+		s.feed.Publish(events.TypeDrainStarted, "Draining connections",
+			map[string]string{"operation": opID, "timeout": timeout.String()})
+		if err := s.grpcClient.DrainConnections(ctx, int(timeout.Seconds())); err != nil {
+			s.logger.Error("Failed to drain connections", zap.Error(err))
+			s.feed.Publish(events.TypeDrainFinished, "Drain failed: "+err.Error(),
+				map[string]string{"operation": opID, "state": OperationFailed})
+			return "", err
+		}
+		s.feed.Publish(events.TypeDrainFinished, "Connections drained",
+			map[string]string{"operation": opID, "state": OperationSucceeded})
+		return "Connections drained successfully", nil
+	})
+	if !started {
+		writeError(w, r, http.StatusConflict, ErrCodeConflict, "A drain is already running: "+op.ID)
+		return
 	}
 
-	s.mu.Lock()
-	for _, b := range s.config.Proxy.Backends {
-		if b.Address == req.Address {
-			s.mu.Unlock()
-			http.Error(w, "Backend already exists", http.StatusConflict)
-			return
+	s.logger.Info("Drain started",
+		zap.String("operation", op.ID),
+		zap.Duration("timeout", timeout),
+		zap.String("caller", callerName(r.Context())))
+	w.Header().Set("Location", "/api/v1/operations/"+op.ID)
+	writeJSON(w, http.StatusAccepted, op)
+}
+
+const (
+	defaultDrainTimeout = 30 * time.Second
+	maxDrainTimeout     = time.Hour
+	drainGrace          = 5 * time.Second
+)
+
+func parseDrainTimeout(raw string) (time.Duration, error) {
+	if raw == "" {
+		return defaultDrainTimeout, nil
+	}
+	timeout, err := time.ParseDuration(raw)
+	if err != nil {
+		secs, convErr := strconv.Atoi(raw)
+		if convErr != nil {
+			return 0, err
 		}
+		timeout = time.Duration(secs) * time.Second
 	}
-	newBackend := config.Backend{
-		Address: req.Address,
-		Weight:  req.Weight,
-		HealthCheck: config.HealthCheckConfig{
-			Interval: 5 * time.Second,
-			Timeout:  2 * time.Second,
-		},
+	if timeout < time.Second || timeout > maxDrainTimeout {
+		return 0, fmt.Errorf("must be between 1s and %s", maxDrainTimeout)
 	}
-	s.config.Proxy.Backends = append(s.config.Proxy.Backends, newBackend)
-	backends := s.config.Proxy.Backends
-	s.mu.Unlock()
+	return timeout.Truncate(time.Second), nil
+}
 
-	healthState := s.healthChecker.GetHealthState()
-	if err := s.grpcClient.ReloadBackendsWithHealth(backends, healthState); err != nil {
-		s.logger.Error("Failed to push new backend to data plane", zap.Error(err))
-		s.mu.Lock()
-		s.config.Proxy.Backends = s.config.Proxy.Backends[:len(s.config.Proxy.Backends)-1]
-		s.mu.Unlock()
-		http.Error(w, "Failed to update data plane", http.StatusInternalServerError)
-		return
+// drainProgress reports active connections while a drain runs, when the
+// metrics collector is available.
+func (s *Server) drainProgress() map[string]int64 {
+	if s.circuitStates == nil {
+		return nil
 	}
+	var active int64
+	for _, stat := range s.circuitStates.BackendStats() {
+		active += stat.ActiveConnections
+	}
+	return map[string]int64{"active_connections": active}
+}
 
-	s.healthChecker.Reload(s.config)
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":  "added",
-		"address": req.Address,
-		"weight":  req.Weight,
-	})
+func (s *Server) handleGetOperation(w http.ResponseWriter, r *http.Request) {
+	op, ok := s.ops.get(chi.URLParam(r, "id"))
+	if !ok {
+		writeError(w, r, http.StatusNotFound, ErrCodeOperationNotFound, "Operation not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, op)
 }
 
-func (s *Server) handleRemoveBackend(w http.ResponseWriter, r *http.Request) {
-	address, err := url.PathUnescape(chi.URLParam(r, "address"))
-	if err != nil || address == "" {
-		http.Error(w, "Invalid address", http.StatusBadRequest)
+// handleDataPlaneCommand forwards an operator command to the data plane. The
+// optional JSON body is a flat object of string arguments, e.g.
+// {"enabled": "true", "filter": "aegis_data::tcp_proxy=debug"} for
+// debug_logging.
+func (s *Server) handleDataPlaneCommand(w http.ResponseWriter, r *http.Request) {
+	executor, ok := s.grpcClient.(commandExecutor)
+	if !ok {
+		writeError(w, r, http.StatusNotImplemented, ErrCodeNotSupported, "Data plane commands are not supported in this mode")
 		return
 	}
 
-	s.mu.Lock()
-	found := false
-	original := make([]config.Backend, len(s.config.Proxy.Backends))
-	copy(original, s.config.Proxy.Backends)
-	filtered := make([]config.Backend, 0, len(s.config.Proxy.Backends))
-	for _, b := range s.config.Proxy.Backends {
-		if b.Address == address {
-			found = true
-		} else {
-			filtered = append(filtered, b)
+	name := chi.URLParam(r, "name")
+	var args CommandRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+			writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request: body must be a JSON object of string arguments")
+			return
 		}
 	}
-	if !found {
-		s.mu.Unlock()
-		http.Error(w, "Backend not found", http.StatusNotFound)
-		return
-	}
-	s.config.Proxy.Backends = filtered
-	backends := filtered
-	s.mu.Unlock()
 
-	healthState := s.healthChecker.GetHealthState()
-	if err := s.grpcClient.ReloadBackendsWithHealth(backends, healthState); err != nil {
-		s.logger.Error("Failed to remove backend from data plane", zap.Error(err))
-		s.mu.Lock()
-		s.config.Proxy.Backends = original
-		s.mu.Unlock()
-		http.Error(w, "Failed to update data plane", http.StatusInternalServerError)
+	result, err := executor.ExecuteCommand(r.Context(), name, args)
+	if err != nil {
+		s.logger.Error("Data plane command failed", zap.String("command", name), zap.Error(err))
+		writeCommandError(w, r, err)
 		return
 	}
 
-	s.healthChecker.Reload(s.config)
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":  "removed",
-		"address": address,
+	s.logger.Info("Data plane command executed",
+		zap.String("command", name),
+		zap.String("caller", callerName(r.Context())),
+		zap.String("message", result.Message))
+	writeJSON(w, http.StatusOK, CommandResponse{
+		Command: name,
+		Message: result.Message,
+		Output:  result.Output,
 	})
 }
 
-func (s *Server) handleDrain(w http.ResponseWriter, r *http.Request) {
-	if err := s.grpcClient.DrainConnections(r.Context(), 30); err != nil {
-		s.logger.Error("Failed to drain connections", zap.Error(err))
-		http.Error(w, "Failed to drain connections", http.StatusInternalServerError)
-		return
+// writeCommandError maps an ExecuteCommand failure to an HTTP error.
+func writeCommandError(w http.ResponseWriter, r *http.Request, err error) {
+	httpStatus, code := http.StatusBadGateway, ErrCodeDataPlaneError
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		httpStatus, code = http.StatusBadRequest, ErrCodeUnknownCommand
+	case codes.NotFound:
+		httpStatus, code = http.StatusNotFound, ErrCodeBackendNotFound
+	case codes.FailedPrecondition:
+		httpStatus, code = http.StatusConflict, ErrCodeConflict
+	case codes.Unimplemented:
+		httpStatus, code = http.StatusNotImplemented, ErrCodeNotSupported
 	}
+	writeError(w, r, httpStatus, code, err.Error())
+}
 
-	response := map[string]interface{}{
-		"status":  "drained",
-		"message": "Connections drained successfully",
+// dataPlaneSupports reports whether the data plane advertised command in
+// its handshake. An older data plane would reject the command as unknown;
+// when nothing is known (legacy handshake, no handshake yet) it's assumed
+// supported and the data plane gets to answer.
+func (s *Server) dataPlaneSupports(command string) bool {
+	p, ok := s.grpcClient.(dataPlaneInfoProvider)
+	if !ok {
+		return true
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	info := p.DataPlaneInfo()
+	return info == nil || info.Legacy || slices.Contains(info.Features, "command:"+command)
 }
 
-func (s *Server) requireToken(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if s.config.Admin.APIToken == "" {
-			next.ServeHTTP(w, r)
+// handleTraffic returns the handler for POST /traffic/pause or /resume.
+// Pausing makes the data plane refuse new connections (and new UDP
+// sessions) while existing ones continue — a maintenance window without
+// a drain. It lasts until resumed or the data plane restarts.
+func (s *Server) handleTraffic(pause bool) http.HandlerFunc {
+	command := "resume_traffic"
+	if pause {
+		command = "pause_traffic"
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		executor, ok := s.grpcClient.(commandExecutor)
+		if !ok {
+			writeError(w, r, http.StatusNotImplemented, ErrCodeNotSupported, "Pausing traffic is not supported in this mode")
 			return
 		}
-		if r.Header.Get("Authorization") != "Bearer "+s.config.Admin.APIToken {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		if !s.dataPlaneSupports(command) {
+			writeError(w, r, http.StatusNotImplemented, ErrCodeNotSupported, "The data plane does not support pausing traffic")
 			return
 		}
-		next.ServeHTTP(w, r)
-	})
+
+		result, err := executor.ExecuteCommand(r.Context(), command, nil)
+		if err != nil {
+			s.logger.Error("Failed to change traffic state", zap.String("command", command), zap.Error(err))
+			writeCommandError(w, r, err)
+			return
+		}
+
+		s.logger.Info("Traffic state changed",
+			zap.Bool("paused", pause),
+			zap.String("caller", callerName(r.Context())),
+			zap.String("message", result.Message))
+		writeJSON(w, http.StatusOK, TrafficResponse{
+			Paused:  pause,
+			Message: result.Message,
+		})
+	}
 }
@@ -1,19 +1,41 @@
 package api
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/lazzerex/aegis/control-plane/internal/acme"
+	"github.com/lazzerex/aegis/control-plane/internal/auth"
+	"github.com/lazzerex/aegis/control-plane/internal/canary"
+	"github.com/lazzerex/aegis/control-plane/internal/certs"
 	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/discovery"
+	"github.com/lazzerex/aegis/control-plane/internal/events"
+	"github.com/lazzerex/aegis/control-plane/internal/grpc"
+	"github.com/lazzerex/aegis/control-plane/internal/health"
 	"github.com/lazzerex/aegis/control-plane/internal/metrics"
+	"github.com/lazzerex/aegis/control-plane/internal/schedule"
+	"github.com/lazzerex/aegis/control-plane/internal/slowstart"
+	"github.com/lazzerex/aegis/control-plane/internal/store"
+	"github.com/lazzerex/aegis/control-plane/internal/version"
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func setURLParam(ctx context.Context, key, value string) context.Context {
@@ -54,6 +76,82 @@ type mockCircuitStates struct {
 func (m *mockCircuitStates) BackendCircuitStates() map[string]string      { return m.states }
 func (m *mockCircuitStates) BackendStats() map[string]metrics.BackendStat { return m.stats }
 
+type mockMetricsSource struct {
+	mockCircuitStates
+	latest *pb.MetricsData
+}
+
+func (m *mockMetricsSource) Latest() *pb.MetricsData { return m.latest }
+
+type mockTopTalkers struct {
+	mockCircuitStates
+	top metrics.Top
+	n   int
+}
+
+func (m *mockTopTalkers) Top(n int) metrics.Top {
+	m.n = n
+	return m.top
+}
+
+type mockBackendTracker struct {
+	mockCircuitStates
+	backends []string
+}
+
+func (m *mockBackendTracker) SetBackends(addresses []string) { m.backends = addresses }
+
+type mockDataPlaneInfo struct {
+	mockGRPC
+	info *grpc.DataPlaneInfo
+}
+
+func (m *mockDataPlaneInfo) DataPlaneInfo() *grpc.DataPlaneInfo { return m.info }
+
+type mockCommands struct {
+	mockGRPC
+	gotName string
+	gotArgs map[string]string
+	result  *grpc.CommandResult
+	err     error
+}
+
+func (m *mockCommands) ExecuteCommand(_ context.Context, name string, args map[string]string) (*grpc.CommandResult, error) {
+	m.gotName, m.gotArgs = name, args
+	return m.result, m.err
+}
+
+type mockValidator struct {
+	mockGRPC
+	err error
+}
+
+func (m *mockValidator) ValidateConfig(_ context.Context, _ *config.Config) error { return m.err }
+
+type mockConnectivity struct {
+	mockGRPC
+	info grpc.Connectivity
+}
+
+func (m *mockConnectivity) Connectivity() grpc.Connectivity { return m.info }
+
+// mockDrain blocks DrainConnections until release is closed.
+type mockDrain struct {
+	mockGRPC
+	release    chan struct{}
+	gotTimeout int
+}
+
+func (m *mockDrain) DrainConnections(ctx context.Context, timeoutSeconds int) error {
+	m.gotTimeout = timeoutSeconds
+	select {
+	case <-m.release:
+		return m.drainErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // ── helpers ──────────────────────────────────────────────────────────────────
 
 func testServer(grpc grpcBackendClient, health healthStateTracker, token string) *Server {
@@ -126,6 +224,77 @@ func TestHandleHealth_ReturnsBackendStates(t *testing.T) {
 	}
 }
 
+func TestHandleHealth_IncludesDataPlaneConnectivity(t *testing.T) {
+	pushed := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	dp := &mockConnectivity{info: grpc.Connectivity{State: "TRANSIENT_FAILURE", LastConfigPush: &pushed}}
+	s := testServer(dp, &mockHealth{state: map[string]bool{}}, "")
+
+	rec := httptest.NewRecorder()
+	s.handleHealth(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	var resp struct {
+		DataPlane map[string]interface{} `json:"data_plane"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.DataPlane["state"] != "TRANSIENT_FAILURE" {
+		t.Errorf("state: got %v", resp.DataPlane["state"])
+	}
+	if resp.DataPlane["last_config_push"] != "2026-01-02T03:04:05Z" {
+		t.Errorf("last_config_push: got %v", resp.DataPlane["last_config_push"])
+	}
+	if _, ok := resp.DataPlane["last_metrics"]; ok {
+		t.Error("last_metrics should be omitted before the first snapshot")
+	}
+}
+
+func TestHandleReadyz(t *testing.T) {
+	pushed := time.Now()
+	dp := &mockConnectivity{info: grpc.Connectivity{State: "READY", LastConfigPush: &pushed}}
+	h := &mockHealth{state: map[string]bool{"localhost:3000": true, "localhost:3001": false}}
+	s := testServer(dp, h, "secret")
+	s.config.Admin.Readiness = config.ReadinessConfig{
+		Checks:             []string{config.ReadyCheckConfig, config.ReadyCheckDataPlane, config.ReadyCheckBackends},
+		MinHealthyBackends: 1,
+	}
+	router := s.router()
+
+	probe := func(path string) (int, ReadinessResponse) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var resp ReadinessResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return rec.Code, resp
+	}
+
+	if code, resp := probe("/readyz"); code != http.StatusOK || resp.Status != "ready" || len(resp.Checks) != 3 {
+		t.Errorf("ready: got %d %+v", code, resp)
+	}
+
+	dp.info = grpc.Connectivity{State: "TRANSIENT_FAILURE"}
+	h.state["localhost:3000"] = false
+	code, resp := probe("/readyz")
+	if code != http.StatusServiceUnavailable || resp.Status != "not_ready" {
+		t.Fatalf("not ready: got %d %+v", code, resp)
+	}
+	for _, c := range resp.Checks {
+		if c.OK || c.Message == "" {
+			t.Errorf("check %s: got %+v, want a failure with a reason", c.Name, c)
+		}
+	}
+
+	// Liveness ignores all of that, and neither probe needs credentials
+	if code, _ := probe("/livez"); code != http.StatusOK {
+		t.Errorf("livez: got %d, want 200", code)
+	}
+
+	s.config.Admin.Readiness.Checks = []string{config.ReadyCheckConfig}
+	s.config.Admin.Readiness.MinHealthyBackends = 0
+	dp.info.LastConfigPush = &pushed
+	if code, resp := probe("/readyz"); code != http.StatusOK || len(resp.Checks) != 1 {
+		t.Errorf("config check only: got %d %+v", code, resp)
+	}
+}
+
 func TestHandleListBackends(t *testing.T) {
 	h := &mockHealth{state: map[string]bool{"localhost:3000": true, "localhost:3001": false}}
 	s := testServer(&mockGRPC{}, h, "")
@@ -169,231 +338,947 @@ func TestHandleStatus_IncludesSessionAffinity(t *testing.T) {
 	}
 }
 
-func TestHandleStatus_IncludesRateLimitAndCircuitBreakerConfig(t *testing.T) {
-	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
-	s.config.Proxy.Traffic.RateLimit.RequestsPerSecond = 1000
-	s.config.Proxy.Traffic.RateLimit.Burst = 100
-	s.config.Proxy.CircuitBreaker.ErrorThreshold = 5
-	s.config.Proxy.CircuitBreaker.Timeout = 30 * time.Second
+func TestHandleStatus_IncludesDataPlaneHandshake(t *testing.T) {
+	dp := &mockDataPlaneInfo{info: &grpc.DataPlaneInfo{
+		Version:     "0.2.0",
+		Features:    []string{"lb:round_robin"},
+		Unsupported: []string{"udp"},
+	}}
+	s := testServer(dp, &mockHealth{state: map[string]bool{}}, "")
 
 	req := httptest.NewRequest(http.MethodGet, "/status", nil)
 	rec := httptest.NewRecorder()
 	s.handleStatus(rec, req)
 
 	var resp struct {
-		Config struct {
-			RateLimitRPS   int     `json:"rate_limit_rps"`
-			RateLimitBurst int     `json:"rate_limit_burst"`
-			CBThreshold    int     `json:"cb_threshold"`
-			CBTimeoutSecs  float64 `json:"cb_timeout_secs"`
-		} `json:"config"`
+		DataPlane *grpc.DataPlaneInfo `json:"data_plane"`
 	}
 	json.NewDecoder(rec.Body).Decode(&resp)
-
-	if resp.Config.RateLimitRPS != 1000 {
-		t.Errorf("rate_limit_rps: got %d, want 1000", resp.Config.RateLimitRPS)
+	if resp.DataPlane == nil || resp.DataPlane.Version != "0.2.0" {
+		t.Fatalf("data_plane: got %+v, want version 0.2.0", resp.DataPlane)
 	}
-	if resp.Config.RateLimitBurst != 100 {
-		t.Errorf("rate_limit_burst: got %d, want 100", resp.Config.RateLimitBurst)
+	if len(resp.DataPlane.Unsupported) != 1 || resp.DataPlane.Unsupported[0] != "udp" {
+		t.Errorf("data_plane.unsupported: got %v, want [udp]", resp.DataPlane.Unsupported)
 	}
-	if resp.Config.CBThreshold != 5 {
-		t.Errorf("cb_threshold: got %d, want 5", resp.Config.CBThreshold)
+}
+
+func TestHandleVersion(t *testing.T) {
+	s := testServer(&mockDataPlaneInfo{info: &grpc.DataPlaneInfo{Version: "0.2.0"}}, &mockHealth{state: map[string]bool{}}, "")
+	rec := httptest.NewRecorder()
+	s.router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/version", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status: got %d", rec.Code)
 	}
-	if resp.Config.CBTimeoutSecs != 30 {
-		t.Errorf("cb_timeout_secs: got %v, want 30", resp.Config.CBTimeoutSecs)
+
+	var resp map[string]any
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp["version"] != version.Version || resp["go_version"] != runtime.Version() || resp["data_plane_version"] != "0.2.0" {
+		t.Errorf("got %v", resp)
+	}
+	for _, field := range []string{"commit", "build_date", "platform"} {
+		if _, ok := resp[field]; !ok {
+			t.Errorf("missing %q in %v", field, resp)
+		}
 	}
 }
 
-func TestHandleListBackends_IncludesUDPBackends(t *testing.T) {
-	h := &mockHealth{state: map[string]bool{
-		"localhost:3000":    true,
-		"localhost:3001":    false,
-		"udp-backend1:5000": true,
-	}}
-	s := testServer(&mockGRPC{}, h, "")
-	s.config.Proxy.UdpBackends = []config.Backend{
-		{Address: "udp-backend1:5000", Weight: 100},
+func TestTrafficSplit(t *testing.T) {
+	grpcMock := &mockGRPC{}
+	s := testServer(grpcMock, &mockHealth{state: map[string]bool{}}, "")
+	s.config.Proxy.LoadBalancing.Algorithm = "weighted_round_robin"
+	s.config.Proxy.Backends[0].Pool = "stable"
+	s.config.Proxy.Backends[1].Pool = "canary"
+	put := func(body, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/traffic-split", strings.NewReader(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		s.router().ServeHTTP(rec, req)
+		return rec
 	}
 
-	req := httptest.NewRequest(http.MethodGet, "/backends", nil)
-	rec := httptest.NewRecorder()
-	s.handleListBackends(rec, req)
+	if rec := put(`{"split":{"stable":90,"canary":5}}`, ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad total: got %d", rec.Code)
+	}
+	if rec := put(`{"split":{"stable":90,"canary":10}}`, `"stale"`); rec.Code != http.StatusConflict {
+		t.Errorf("stale If-Match: got %d", rec.Code)
+	}
+	if grpcMock.reloadCalls != 0 || s.config.Proxy.TrafficSplit != nil {
+		t.Fatal("rejected split was applied")
+	}
 
-	var resp map[string]interface{}
+	rec := put(`{"split":{"stable":90,"canary":10}}`, `"`+s.config.Version()+`"`)
+	var resp TrafficSplitResponse
 	json.NewDecoder(rec.Body).Decode(&resp)
-
-	backends := resp["backends"].([]interface{})
-	if len(backends) != 2 {
-		t.Fatalf("expected 2 TCP backends, got %d", len(backends))
+	if rec.Code != http.StatusOK || resp.Split["canary"] != 10 || len(resp.Pools) != 2 || resp.Pools[0].Backends[0] != "localhost:3001" {
+		t.Fatalf("got %d %+v", rec.Code, resp)
 	}
-
-	udpBackends, ok := resp["udp_backends"].([]interface{})
-	if !ok {
-		t.Fatal("udp_backends missing from response")
+	if resp.Version != s.config.Version() || rec.Header().Get("ETag") != `"`+resp.Version+`"` {
+		t.Errorf("version: got %q, ETag %q", resp.Version, rec.Header().Get("ETag"))
 	}
-	if len(udpBackends) != 1 {
-		t.Fatalf("expected 1 UDP backend, got %d", len(udpBackends))
+	if grpcMock.reloadCalls != 1 {
+		t.Errorf("health not restored after push: %d reloads", grpcMock.reloadCalls)
 	}
-	entry := udpBackends[0].(map[string]interface{})
-	if entry["address"] != "udp-backend1:5000" {
-		t.Errorf("address: got %v, want udp-backend1:5000", entry["address"])
+
+	// The canary pool can't be emptied while the split sends it traffic
+	rec = httptest.NewRecorder()
+	s.router().ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/v1/backends/localhost:3001", nil))
+	if rec.Code != http.StatusBadRequest || len(s.config.Proxy.Backends) != 2 {
+		t.Errorf("removing the canary: got %d, %d backends", rec.Code, len(s.config.Proxy.Backends))
 	}
-	if entry["healthy"] != true {
-		t.Errorf("healthy: got %v, want true", entry["healthy"])
+
+	if rec := put(`{"split":{}}`, ""); rec.Code != http.StatusOK || s.config.Proxy.TrafficSplit != nil {
+		t.Errorf("clearing: got %d, split %v", rec.Code, s.config.Proxy.TrafficSplit)
 	}
 }
 
-func TestHandleListBackends_OmitsStatsWhenProviderAbsent(t *testing.T) {
-	h := &mockHealth{state: map[string]bool{"localhost:3000": true, "localhost:3001": false}}
-	s := testServer(&mockGRPC{}, h, "")
+func TestSwitchPools(t *testing.T) {
+	health := &mockHealth{state: map[string]bool{"localhost:3000": true}}
+	s := testServer(&mockGRPC{}, health, "")
+	s.SetEventFeed(events.NewFeed())
+	sub := s.feed.Subscribe(0)
+	defer sub.Close()
+	s.config.Proxy.Backends[0].Pool = "blue"
+	s.config.Proxy.Backends[1].Pool = "green"
+	s.config.Proxy.Backends = append(s.config.Proxy.Backends, config.Backend{Address: "localhost:3002", Weight: 50, Pool: "green"})
+	s.config.Proxy.BlueGreen = config.BlueGreenConfig{Pools: []string{"blue", "green"}, Active: "blue", MinHealthyPercent: 100}
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/pools/switch", strings.NewReader(body)))
+		return rec
+	}
 
-	req := httptest.NewRequest(http.MethodGet, "/backends", nil)
-	rec := httptest.NewRecorder()
-	s.handleListBackends(rec, req)
+	// One of green's two backends is healthy
+	health.state["localhost:3001"] = true
+	if rec := post(""); rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), ErrCodePoolUnhealthy) {
+		t.Errorf("unhealthy target: got %d %s", rec.Code, rec.Body)
+	}
+	if rec := post(`{"to":"red"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown pool: got %d", rec.Code)
+	}
 
-	var resp map[string]interface{}
+	health.state["localhost:3002"] = true
+	rec := post("")
+	var resp PoolSwitchResponse
 	json.NewDecoder(rec.Body).Decode(&resp)
-	backends := resp["backends"].([]interface{})
-	first := backends[0].(map[string]interface{})
-	if _, ok := first["circuit_state"]; ok {
-		t.Error("circuit_state should be absent when no circuitStates provider is set")
+	if rec.Code != http.StatusOK || resp.Active != "green" || resp.Previous != "blue" || resp.Healthy != 2 || resp.Total != 2 {
+		t.Fatalf("got %d %+v", rec.Code, resp)
 	}
-	if _, ok := first["total_requests"]; ok {
-		t.Error("total_requests should be absent when no circuitStates provider is set")
+	if s.config.Proxy.BlueGreen.Active != "green" || resp.Version != s.config.Version() {
+		t.Errorf("config: active %q, version %q vs %q", s.config.Proxy.BlueGreen.Active, resp.Version, s.config.Version())
+	}
+	serving := s.config.Proxy.ServingBackends(s.config.Proxy.Backends)
+	if len(serving) != 2 || serving[0].Address != "localhost:3001" {
+		t.Errorf("serving after switch: got %+v", serving)
+	}
+	if ev := <-sub.Events; ev.Type != events.TypeConfigApplied {
+		t.Errorf("first event: got %s", ev.Type)
+	}
+	if ev := <-sub.Events; ev.Type != events.TypePoolSwitched || ev.Attributes["to"] != "green" {
+		t.Errorf("switch event: got %+v", ev)
 	}
-}
 
-func TestHandleListBackends_IncludesStatsWhenProviderPresent(t *testing.T) {
-	h := &mockHealth{state: map[string]bool{"localhost:3000": true, "localhost:3001": false}}
-	s := testServer(&mockGRPC{}, h, "")
-	s.circuitStates = &mockCircuitStates{
-		states: map[string]string{"localhost:3000": "Closed"},
-		stats: map[string]metrics.BackendStat{
-			"localhost:3000": {ActiveConnections: 3, TotalRequests: 42, FailedRequests: 1, AvgLatencyMs: 2.5},
-		},
+	// Switching to the active pool changes nothing
+	version := s.config.Version()
+	if rec := post(`{"to":"green"}`); rec.Code != http.StatusOK || s.config.Version() != version {
+		t.Errorf("no-op switch: got %d", rec.Code)
 	}
+}
 
-	req := httptest.NewRequest(http.MethodGet, "/backends", nil)
-	rec := httptest.NewRecorder()
-	s.handleListBackends(rec, req)
+func TestPutMirror(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{}, "")
+	s.SetEventFeed(events.NewFeed())
+	sub := s.feed.Subscribe(0)
+	defer sub.Close()
+	s.config.Proxy.Backends[1].Pool = "shadow"
+	put := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.router().ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/api/v1/mirror", strings.NewReader(body)))
+		return rec
+	}
 
-	var resp map[string]interface{}
+	rec := put(`{"pool":"shadow","percent":10}`)
+	var resp MirrorResponse
 	json.NewDecoder(rec.Body).Decode(&resp)
-	backends := resp["backends"].([]interface{})
-
-	var found map[string]interface{}
-	for _, b := range backends {
-		entry := b.(map[string]interface{})
-		if entry["address"] == "localhost:3000" {
-			found = entry
-		}
+	if rec.Code != http.StatusOK || resp.Pool != "shadow" || resp.Percent != 10 || len(resp.Backends) != 1 || resp.Backends[0] != "localhost:3001" {
+		t.Fatalf("got %d %+v", rec.Code, resp)
 	}
-	if found == nil {
-		t.Fatal("localhost:3000 not found in response")
+	if s.config.Proxy.Mirror.Percent != 10 || resp.Version != s.config.Version() {
+		t.Errorf("config: got %+v", s.config.Proxy.Mirror)
 	}
-	if found["circuit_state"] != "Closed" {
-		t.Errorf("circuit_state: got %v, want Closed", found["circuit_state"])
+	<-sub.Events // config_applied
+	if ev := <-sub.Events; ev.Type != events.TypeMirrorChanged || ev.Message != "Mirroring set to 10% to shadow" {
+		t.Errorf("event: got %+v", ev)
 	}
-	if found["total_requests"] != float64(42) {
-		t.Errorf("total_requests: got %v, want 42", found["total_requests"])
+
+	if rec := put(`{"pool":"canary","percent":10}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("pool without backends: got %d", rec.Code)
 	}
-	if found["failed_requests"] != float64(1) {
-		t.Errorf("failed_requests: got %v, want 1", found["failed_requests"])
+	// The shadow pool's only backend can't be dropped while it's mirrored to
+	rec = httptest.NewRecorder()
+	s.router().ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/v1/backends/localhost:3001", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("removing the last shadow: got %d", rec.Code)
 	}
 
-	// localhost:3001 has no reported stats — must not appear at all.
-	for _, b := range backends {
-		entry := b.(map[string]interface{})
-		if entry["address"] == "localhost:3001" {
-			if _, ok := entry["total_requests"]; ok {
-				t.Error("localhost:3001 should have no total_requests (never reported)")
-			}
-		}
+	if rec := put(`{}`); rec.Code != http.StatusOK || s.config.Proxy.Mirror.Pool != "" {
+		t.Errorf("turning mirroring off: got %d %+v", rec.Code, s.config.Proxy.Mirror)
+	}
+	rec = httptest.NewRecorder()
+	s.router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/mirror", nil))
+	if !strings.Contains(rec.Body.String(), `"pool":""`) {
+		t.Errorf("GET after off: got %s", rec.Body)
 	}
 }
 
-func TestHandleAddBackend_Success(t *testing.T) {
-	g := &mockGRPC{}
-	h := &mockHealth{state: map[string]bool{}}
-	s := testServer(g, h, "")
-
-	body := `{"address":"localhost:3002","weight":75}`
-	req := httptest.NewRequest(http.MethodPost, "/backends", bytes.NewBufferString(body))
-	rec := httptest.NewRecorder()
-	s.handleAddBackend(rec, req)
+func TestPutHeaderRules(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{}, "")
+	s.SetEventFeed(events.NewFeed())
+	sub := s.feed.Subscribe(0)
+	defer sub.Close()
+	s.config.Proxy.Backends[1].Pool = "staging"
+	put := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.router().ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/api/v1/header-rules", strings.NewReader(body)))
+		return rec
+	}
 
-	if rec.Code != http.StatusCreated {
-		t.Fatalf("status: got %d, want 201", rec.Code)
+	rec := put(`{"rules":[{"name":"debug","match":[{"header":"X-Debug","exact":"1"}],"pool":"staging"}]}`)
+	var resp HeaderRulesResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || len(resp.Rules) != 1 || len(resp.Rules[0].Backends) != 1 || resp.Rules[0].Backends[0] != "localhost:3001" {
+		t.Fatalf("got %d %+v", rec.Code, resp)
 	}
-	if g.reloadCalls != 1 {
-		t.Errorf("ReloadBackendsWithHealth calls: got %d, want 1", g.reloadCalls)
+	if len(s.config.Proxy.HeaderRules) != 1 || resp.Version != s.config.Version() {
+		t.Errorf("config: got %+v", s.config.Proxy.HeaderRules)
 	}
-	if h.reloadCalls != 1 {
-		t.Errorf("healthChecker.Reload calls: got %d, want 1", h.reloadCalls)
+	<-sub.Events // config_applied
+	if ev := <-sub.Events; ev.Type != events.TypeHeaderRulesChanged || ev.Message != "Header rules set to debug to staging" {
+		t.Errorf("event: got %+v", ev)
 	}
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	found := false
-	for _, b := range s.config.Proxy.Backends {
-		if b.Address == "localhost:3002" && b.Weight == 75 {
-			found = true
-		}
+
+	if rec := put(`{"rules":[{"name":"debug","match":[{"header":"X-Debug","regex":"("}],"pool":"staging"}]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad regex: got %d", rec.Code)
 	}
-	if !found {
-		t.Error("backend not added to config")
+	// The staging pool's only backend can't be dropped while a rule routes
+	// to it
+	rec = httptest.NewRecorder()
+	s.router().ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/v1/backends/localhost:3001", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("removing the last staging backend: got %d", rec.Code)
+	}
+
+	if rec := put(`{"rules":[]}`); rec.Code != http.StatusOK || s.config.Proxy.HeaderRules != nil {
+		t.Errorf("removing the rules: got %d %+v", rec.Code, s.config.Proxy.HeaderRules)
 	}
 }
 
-func TestHandleAddBackend_Duplicate(t *testing.T) {
-	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
+type mockDarkLauncher struct {
+	changes int
+}
 
-	body := `{"address":"localhost:3000","weight":100}`
-	req := httptest.NewRequest(http.MethodPost, "/backends", bytes.NewBufferString(body))
-	rec := httptest.NewRecorder()
-	s.handleAddBackend(rec, req)
+func (m *mockDarkLauncher) Changed() { m.changes++ }
+
+func TestDarkLaunches(t *testing.T) {
+	grpc := &mockGRPC{}
+	s := testServer(grpc, &mockHealth{}, "")
+	s.SetEventFeed(events.NewFeed())
+	sub := s.feed.Subscribe(0)
+	defer sub.Close()
+	launcher := &mockDarkLauncher{}
+	s.SetDarkLaunch(launcher)
+	s.config.Proxy.Backends[1].Pool = "beta"
+	call := func(method, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.router().ServeHTTP(rec, httptest.NewRequest(method, "/api/v1/dark-launches", strings.NewReader(body)))
+		return rec
+	}
 
-	if rec.Code != http.StatusConflict {
-		t.Errorf("status: got %d, want 409", rec.Code)
+	rec := call(http.MethodPut, `{"rules":[{"name":"checkout","pool":"beta","percent":5,"windows":[{"days":["mon"],"start":"09:00","end":"17:00"}]},`+
+		`{"name":"search","pool":"beta","percent":10}]}`)
+	var resp DarkLaunchesResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || len(resp.Rules) != 2 || resp.Rules[0].NextChange == nil || !resp.Rules[1].Active ||
+		resp.Rules[1].NextChange != nil || len(resp.Rules[1].Backends) != 1 || resp.Rules[1].Backends[0] != "localhost:3001" {
+		t.Fatalf("got %d %+v", rec.Code, resp)
+	}
+	if len(s.config.Proxy.DarkLaunches) != 2 || resp.Version != s.config.Version() || launcher.changes != 1 {
+		t.Errorf("config: got %+v, %d changes", s.config.Proxy.DarkLaunches, launcher.changes)
+	}
+	<-sub.Events // config_applied
+	if ev := <-sub.Events; ev.Type != events.TypeDarkLaunchesChanged || ev.Message != "Dark launches set to checkout 5% to beta, search 10% to beta" {
+		t.Errorf("event: got %+v", ev)
 	}
-}
 
-func TestHandleAddBackend_MissingAddress(t *testing.T) {
-	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
+	if rec := call(http.MethodPut, `{"rules":[{"name":"all","pool":"beta","percent":101}]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad percent: got %d", rec.Code)
+	}
+	resp = DarkLaunchesResponse{}
+	if rec := call(http.MethodGet, ""); json.NewDecoder(rec.Body).Decode(&resp) != nil || resp.Timezone != "Local" || len(resp.Rules) != 2 {
+		t.Errorf("get: got %d %+v", rec.Code, resp)
+	}
 
-	req := httptest.NewRequest(http.MethodPost, "/backends", bytes.NewBufferString(`{"weight":100}`))
-	rec := httptest.NewRecorder()
-	s.handleAddBackend(rec, req)
+	// A window edge pushes the backends again without a new version
+	before := s.config.Version()
+	if err := s.RefreshDarkLaunches(); err != nil || grpc.reloadCalls != 1 || s.config.Version() != before {
+		t.Errorf("refresh: %v, %d reloads", err, grpc.reloadCalls)
+	}
 
-	if rec.Code != http.StatusBadRequest {
-		t.Errorf("status: got %d, want 400", rec.Code)
+	if rec := call(http.MethodPut, `{"rules":[]}`); rec.Code != http.StatusOK || s.config.Proxy.DarkLaunches != nil {
+		t.Errorf("removing the rules: got %d %+v", rec.Code, s.config.Proxy.DarkLaunches)
 	}
 }
 
-func TestHandleRemoveBackend_Success(t *testing.T) {
-	g := &mockGRPC{}
-	h := &mockHealth{state: map[string]bool{}}
-	s := testServer(g, h, "")
+type mockDiscoverer struct {
+	changes  int
+	held     *discovery.Shrink
+	confirms int
+}
 
-	// Use chi context to supply URL param
-	req := httptest.NewRequest(http.MethodDelete, "/backends/localhost:3000", nil)
-	req = req.WithContext(setURLParam(req.Context(), "address", "localhost:3000"))
-	rec := httptest.NewRecorder()
-	s.handleRemoveBackend(rec, req)
+func (m *mockDiscoverer) Changed() { m.changes++ }
 
-	if rec.Code != http.StatusOK {
-		t.Fatalf("status: got %d, want 200 (body: %s)", rec.Code, rec.Body.String())
-	}
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	for _, b := range s.config.Proxy.Backends {
-		if b.Address == "localhost:3000" {
-			t.Error("backend still in config after removal")
-		}
+func (m *mockDiscoverer) Held() (discovery.Shrink, bool) {
+	if m.held == nil {
+		return discovery.Shrink{}, false
 	}
+	return *m.held, true
 }
 
-func TestHandleRemoveBackend_NotFound(t *testing.T) {
-	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
+func (m *mockDiscoverer) Confirm(string) (discovery.Shrink, error) {
+	if m.held == nil {
+		return discovery.Shrink{}, discovery.ErrNothingHeld
+	}
+	m.confirms++
+	return *m.held, nil
+}
+
+func TestDiscoveryConfirm(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{}, "")
+	call := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.router().ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+	if rec := call(http.MethodPost, "/api/v1/discovery/confirm"); rec.Code != http.StatusNotImplemented {
+		t.Errorf("without a controller: got %d", rec.Code)
+	}
+	discoverer := &mockDiscoverer{}
+	s.SetDiscovery(discoverer)
+	s.config.Proxy.Discovery = config.DiscoveryConfig{Provider: config.DiscoveryDNSSRV, Service: "_api._tcp.example.com",
+		Merge: config.MergeUnion}
+	s.config.Proxy.Backends = append(s.config.Proxy.Backends, config.Backend{Address: "api-1:80", Weight: 100, Discovered: true})
+
+	var resp DiscoveryResponse
+	json.NewDecoder(call(http.MethodGet, "/api/v1/discovery").Body).Decode(&resp)
+	if !resp.Enabled || resp.Target != "_api._tcp.example.com" || resp.Backends != 1 || resp.Held != nil {
+		t.Errorf("status: got %+v", resp)
+	}
+	if rec := call(http.MethodPost, "/api/v1/discovery/confirm"); rec.Code != http.StatusConflict {
+		t.Errorf("nothing held: got %d", rec.Code)
+	}
+
+	discoverer.held = &discovery.Shrink{Backends: 0, Previous: 1, Removed: []string{"api-1:80"}, Since: time.Now()}
+	resp = DiscoveryResponse{}
+	json.NewDecoder(call(http.MethodGet, "/api/v1/discovery").Body).Decode(&resp)
+	if resp.Held == nil || resp.Held.Previous != 1 || len(resp.Held.Removed) != 1 {
+		t.Errorf("held: got %+v", resp.Held)
+	}
+	rec := call(http.MethodPost, "/api/v1/discovery/confirm")
+	var shrink DiscoveryShrink
+	json.NewDecoder(rec.Body).Decode(&shrink)
+	if rec.Code != http.StatusAccepted || shrink.Removed[0] != "api-1:80" || discoverer.confirms != 1 {
+		t.Errorf("confirm: got %d %+v, %d confirms", rec.Code, shrink, discoverer.confirms)
+	}
+}
+
+type mockCertificates struct{ status []acme.Status }
+
+func (m *mockCertificates) Changed() {}
+
+func (m *mockCertificates) Status() []acme.Status { return m.status }
+
+type mockCertificateFiles struct{ status []certs.FileStatus }
+
+func (m *mockCertificateFiles) Changed() {}
+
+func (m *mockCertificateFiles) Status() []certs.FileStatus { return m.status }
+
+func TestGetCertificates(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{}, "")
+	get := func() (resp CertificatesResponse) {
+		rec := httptest.NewRecorder()
+		s.router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/certificates", nil))
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return resp
+	}
+	if resp := get(); resp.Enabled || resp.Certificates == nil || len(resp.Certificates) != 0 {
+		t.Errorf("without TLS: got %+v", resp)
+	}
+
+	s.config.Proxy.TLS.ACME.Certificates = []config.ACMECertificate{{Name: "example.com", Domains: []string{"example.com"}}}
+	expires := time.Now().Add(60 * 24 * time.Hour)
+	s.SetCertificateManager(&mockCertificates{status: []acme.Status{
+		{Name: "example.com", Domains: []string{"example.com"}, NotAfter: expires},
+		{Name: "api", Domains: []string{"api.example.com"}, Error: "incorrectResponse: wrong answer", RetryAt: time.Now()},
+	}})
+	s.SetCertificateWatcher(&mockCertificateFiles{status: []certs.FileStatus{
+		{Name: "site", CertFile: "/etc/aegis/tls/site.crt", KeyFile: "/etc/aegis/tls/site.key",
+			ServerNames: []string{"internal.example.com"}, NotAfter: expires},
+	}})
+	resp := get()
+	if !resp.Enabled || len(resp.Certificates) != 3 {
+		t.Fatalf("got %+v", resp)
+	}
+	if c := resp.Certificates[0]; c.Source != CertificateSourceFile || c.CertFile == "" || c.Domains[0] != "internal.example.com" || c.NotAfter == nil {
+		t.Errorf("file: got %+v", c)
+	}
+	if c := resp.Certificates[1]; c.Source != CertificateSourceACME || c.NotAfter == nil || !c.NotAfter.Equal(expires) || c.ExpiresIn == "" || c.RetryAt != nil {
+		t.Errorf("obtained: got %+v", c)
+	}
+	if c := resp.Certificates[2]; c.NotAfter != nil || c.Error == "" || c.RetryAt == nil {
+		t.Errorf("failing: got %+v", c)
+	}
+}
+
+func TestSetDiscoveredBackends(t *testing.T) {
+	grpc := &mockGRPC{}
+	s := testServer(grpc, &mockHealth{}, "")
+	s.SetEventFeed(events.NewFeed())
+	sub := s.feed.Subscribe(0)
+	defer sub.Close()
+	discoverer := &mockDiscoverer{}
+	s.SetDiscovery(discoverer)
+	s.config.Proxy.Discovery = config.DiscoveryConfig{Provider: config.DiscoveryDNSSRV, Service: "_api._tcp.example.com"}
+	found := []config.Backend{{Address: "api-1:80", Weight: 100}, {Address: "localhost:3000", Weight: 10}}
+
+	if err := s.SetDiscoveredBackends(found); err != nil || grpc.reloadCalls != 1 {
+		t.Fatalf("push: %v, %d reloads", err, grpc.reloadCalls)
+	}
+	backends := s.config.Proxy.Backends
+	if len(backends) != 3 || !backends[2].Discovered || backends[2].Address != "api-1:80" || backends[0].Weight != 100 {
+		t.Fatalf("got %+v", backends)
+	}
+	if ev := <-sub.Events; ev.Type != events.TypeBackendsChanged || ev.Attributes["added"] != "api-1:80" || ev.Attributes["caller"] != "discovery" {
+		t.Errorf("event: got %+v", ev)
+	}
+	// Found again, nothing's pushed
+	if err := s.SetDiscoveredBackends(found); err != nil || grpc.reloadCalls != 1 {
+		t.Errorf("unchanged: %v, %d reloads", err, grpc.reloadCalls)
+	}
+
+	// A reload from the file keeps what was found until the next lookup
+	next := *s.config
+	next.Proxy.Backends = []config.Backend{{Address: "localhost:3000", Weight: 100}}
+	if err := s.applyConfig(&next); err != nil || len(s.config.Proxy.Backends) != 2 || discoverer.changes != 1 {
+		t.Fatalf("reload: %v, %+v, %d changes", err, s.config.Proxy.Backends, discoverer.changes)
+	}
+	// and one turning discovery off drops it
+	next = *s.config
+	next.Proxy.Discovery = config.DiscoveryConfig{}
+	if err := s.applyConfig(&next); err != nil || len(s.config.Proxy.Backends) != 1 {
+		t.Errorf("discovery off: %v, %+v", err, s.config.Proxy.Backends)
+	}
+}
+
+func TestSetDiscoveredBackends_Fallback(t *testing.T) {
+	grpc := &mockGRPC{}
+	s := testServer(grpc, &mockHealth{}, "")
+	s.SetDiscovery(&mockDiscoverer{})
+	s.config.Proxy.Discovery = config.DiscoveryConfig{Provider: config.DiscoveryDNSSRV, Service: "_api._tcp.example.com",
+		Merge: config.MergeFallback}
+	s.config.Proxy.Backends = s.config.Proxy.Backends[:1]
+	standby := func() []string {
+		var addresses []string
+		for _, b := range s.config.Proxy.Backends {
+			if b.Standby {
+				addresses = append(addresses, b.Address)
+			}
+		}
+		return addresses
+	}
+
+	if err := s.SetDiscoveredBackends([]config.Backend{{Address: "api-1:80", Weight: 100}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := standby(); !slices.Equal(got, []string{"localhost:3000"}) {
+		t.Fatalf("found: standing by %v", got)
+	}
+	// A reload keeps the configured backends standing by
+	next := *s.config
+	next.Proxy.Backends = []config.Backend{{Address: "localhost:3000", Weight: 100}}
+	if err := s.applyConfig(&next); err != nil || !slices.Equal(standby(), []string{"localhost:3000"}) {
+		t.Fatalf("reload: %v, standing by %v", err, standby())
+	}
+	if static := config.StaticBackends(s.config.Proxy.Backends); len(static) != 1 || static[0].Standby {
+		t.Errorf("static: %+v", static)
+	}
+	// and they serve again once nothing's found
+	if err := s.SetDiscoveredBackends(nil); err != nil || len(standby()) != 0 || len(s.config.Proxy.Backends) != 1 {
+		t.Errorf("nothing found: %v, %+v", err, s.config.Proxy.Backends)
+	}
+}
+
+func TestSetFileBackends(t *testing.T) {
+	grpc := &mockGRPC{}
+	s := testServer(grpc, &mockHealth{}, "")
+	s.SetEventFeed(events.NewFeed())
+	sub := s.feed.Subscribe(0)
+	defer sub.Close()
+	s.config.Proxy.BackendsFile = "/etc/aegis/backends.yaml"
+	s.config.Proxy.Discovery = config.DiscoveryConfig{Provider: config.DiscoveryDNSSRV, Service: "_api._tcp.example.com"}
+	if err := s.SetDiscoveredBackends([]config.Backend{{Address: "api-1:80", Weight: 100}}); err != nil {
+		t.Fatal(err)
+	}
+	<-sub.Events
+
+	// A file from before a config push is ignored
+	file := []config.Backend{{Address: "api-1:80", Weight: 50, FromFile: true}, {Address: "api-2:80", Weight: 100, FromFile: true}}
+	if err := s.SetFileBackends("/etc/aegis/old.yaml", file); err != nil || grpc.reloadCalls != 1 {
+		t.Fatalf("old file: %v, %d reloads", err, grpc.reloadCalls)
+	}
+	if err := s.SetFileBackends("/etc/aegis/backends.yaml", file); err != nil || grpc.reloadCalls != 2 {
+		t.Fatalf("push: %v, %d reloads", err, grpc.reloadCalls)
+	}
+	// The file's api-1 takes the place of the discovered one
+	backends := s.config.Proxy.Backends
+	if len(backends) != 4 || !backends[2].FromFile || backends[2].Weight != 50 || backends[2].Discovered {
+		t.Fatalf("got %+v", backends)
+	}
+	if ev := <-sub.Events; ev.Attributes["added"] != "api-1:80,api-2:80" || ev.Attributes["caller"] != "backends_file" {
+		t.Errorf("event: got %+v", ev)
+	}
+
+	// Turning discovery off keeps the file's backends
+	next := *s.config
+	next.Proxy.Discovery = config.DiscoveryConfig{}
+	if err := s.applyConfig(&next); err != nil || len(s.config.Proxy.Backends) != 4 {
+		t.Errorf("discovery off: %v, %+v", err, s.config.Proxy.Backends)
+	}
+}
+
+type mockScheduler struct {
+	statuses []schedule.Status
+	reloaded []config.SchedulerConfig
+}
+
+func (m *mockScheduler) Statuses() []schedule.Status       { return m.statuses }
+func (m *mockScheduler) Timezone() string                  { return "UTC" }
+func (m *mockScheduler) Reload(cfg config.SchedulerConfig) { m.reloaded = append(m.reloaded, cfg) }
+
+func TestSchedules(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{}, "")
+	next := time.Date(2026, 10, 18, 2, 0, 0, 0, time.UTC)
+	sched := &mockScheduler{statuses: []schedule.Status{{Name: "drain", Cron: "0 2 * * 0", Duration: 2 * time.Hour, NextApply: next}}}
+	s.SetScheduler(sched)
+
+	rec := httptest.NewRecorder()
+	s.router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/schedules", nil))
+	var resp SchedulesResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || resp.Timezone != "UTC" || len(resp.Schedules) != 1 {
+		t.Fatalf("got %d %+v", rec.Code, resp)
+	}
+	if sc := resp.Schedules[0]; sc.Duration != "2h0m0s" || sc.NextApplication == nil || !sc.NextApplication.Equal(next) || sc.LastApplied != nil {
+		t.Errorf("schedule: got %+v", sc)
+	}
+
+	// Overlays are pushed as new config versions, and undone the same way
+	before := s.config.Version()
+	undo, version, err := s.ApplyOverlay("drain", config.Overlay{Weights: map[string]int{"localhost:3001": 0}})
+	if err != nil || version == before || version != s.config.Version() || s.config.Proxy.Backends[1].Weight != 0 {
+		t.Fatalf("apply: got %v %q %+v", err, version, s.config.Proxy.Backends)
+	}
+	if _, _, err := s.ApplyOverlay("drain", undo); err != nil || s.config.Proxy.Backends[1].Weight != 50 {
+		t.Errorf("undo: got %v %+v", err, s.config.Proxy.Backends)
+	}
+
+	// Replacing the config hands the scheduler its schedules
+	s.configPath = writeTempConfig(t)
+	rec = httptest.NewRecorder()
+	s.router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/reload", nil))
+	if rec.Code != http.StatusOK || len(sched.reloaded) != 1 {
+		t.Errorf("reload: got %d, %d scheduler reloads", rec.Code, len(sched.reloaded))
+	}
+}
+
+func TestCanary(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{}, "")
+	s.config.Proxy.LoadBalancing.Algorithm = "weighted_round_robin"
+	s.config.Proxy.Backends[0].Pool = "stable"
+	s.config.Proxy.Backends[1].Pool = "canary"
+	do := func(method, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.router().ServeHTTP(rec, httptest.NewRequest(method, "/api/v1/canary", strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := do(http.MethodGet, ""); rec.Code != http.StatusNotImplemented {
+		t.Errorf("without a controller: got %d", rec.Code)
+	}
+	s.SetCanary(canary.New(s, nil, zap.NewNop()))
+	if rec := do(http.MethodGet, ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"state":"idle"`) {
+		t.Errorf("idle: got %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodPost, `{"pool":"canary","baseline":"stable","target_percent":120}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad target: got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, `{"pool":"canary","baseline":"blue"}`); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), ErrCodeInvalidConfig) {
+		t.Errorf("baseline without backends: got %d %s", rec.Code, rec.Body)
+	}
+
+	rec := do(http.MethodPost, `{"pool":"canary","baseline":"stable","step_percent":20,"step_interval_secs":60}`)
+	var resp CanaryResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusAccepted || resp.State != canary.StateProgressing || resp.Percent != 20 || resp.TargetPercent != 100 || resp.Current == nil {
+		t.Fatalf("start: got %d %+v", rec.Code, resp)
+	}
+	if split := s.config.Proxy.TrafficSplit; split["canary"] != 20 || split["stable"] != 80 || resp.Version != s.config.Version() {
+		t.Errorf("split: got %v, version %q", split, resp.Version)
+	}
+	if rec := do(http.MethodPost, `{"pool":"canary","baseline":"stable"}`); rec.Code != http.StatusConflict {
+		t.Errorf("second rollout: got %d", rec.Code)
+	}
+
+	rec = do(http.MethodDelete, "")
+	resp = CanaryResponse{}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || resp.State != canary.StateRolledBack || resp.Finished == nil {
+		t.Fatalf("abort: got %d %+v", rec.Code, resp)
+	}
+	if split := s.config.Proxy.TrafficSplit; split["canary"] != 0 || split["stable"] != 100 {
+		t.Errorf("split after abort: got %v", split)
+	}
+	if rec := do(http.MethodDelete, ""); rec.Code != http.StatusConflict {
+		t.Errorf("abort when done: got %d", rec.Code)
+	}
+}
+
+func TestSlowStart(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{}, "")
+	s.config.Proxy.LoadBalancing.Algorithm = "weighted_round_robin"
+	s.config.Proxy.LoadBalancing.SlowStart = config.SlowStartConfig{Duration: time.Minute, InitialPercent: 10, Interval: 10 * time.Second}
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.router().ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := call(http.MethodGet, "/api/v1/slow-start", ""); rec.Code != http.StatusNotImplemented {
+		t.Errorf("without a controller: got %d", rec.Code)
+	}
+	s.SetSlowStart(slowstart.New(s, zap.NewNop()))
+	if rec := call(http.MethodPost, "/api/v1/backends", `{"address":"localhost:3002","weight":200}`); rec.Code != http.StatusCreated {
+		t.Fatalf("add: got %d %s", rec.Code, rec.Body)
+	}
+	if w := s.config.Proxy.Backends[2].Weight; w != 20 {
+		t.Errorf("added backend: got weight %d, want 20", w)
+	}
+
+	rec := call(http.MethodGet, "/api/v1/slow-start", "")
+	var resp SlowStartResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || !resp.Enabled || resp.Duration != "1m0s" || len(resp.Backends) != 1 ||
+		resp.Backends[0].Weight != 20 || resp.Backends[0].TargetWeight != 200 {
+		t.Fatalf("status: got %d %+v", rec.Code, resp)
+	}
+
+	// Stepping goes through the running config, like any backend change
+	if err := s.RampBackends(func(b []config.Backend) []config.Backend { b[2].Weight = 80; return b }); err != nil {
+		t.Fatalf("RampBackends: %v", err)
+	}
+	if w := s.config.Proxy.Backends[2].Weight; w != 80 {
+		t.Errorf("stepped backend: got weight %d", w)
+	}
+
+	// A weight set by hand ends the ramp
+	if rec := call(http.MethodPatch, "/api/v1/backends/localhost:3002", `{"weight":150}`); rec.Code != http.StatusOK {
+		t.Fatalf("patch: got %d %s", rec.Code, rec.Body)
+	}
+	resp = SlowStartResponse{}
+	json.NewDecoder(call(http.MethodGet, "/api/v1/slow-start", "").Body).Decode(&resp)
+	if len(resp.Backends) != 0 || s.config.Proxy.Backends[2].Weight != 150 {
+		t.Errorf("after patch: got %+v, weight %d", resp, s.config.Proxy.Backends[2].Weight)
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
+	put := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.router().ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/api/v1/loglevel", strings.NewReader(body)))
+		return rec
+	}
+	if rec := put(`{"level":"debug"}`); rec.Code != http.StatusNotImplemented {
+		t.Fatalf("without a level: got %d", rec.Code)
+	}
+
+	level := zap.NewAtomicLevelAt(zap.InfoLevel)
+	s.SetLogLevel(level)
+	for _, body := range []string{`{"level":"trace"}`, `{"level":"fatal"}`, `{"level":"debug","revert_after_secs":-1}`} {
+		if rec := put(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d", body, rec.Code)
+		}
+	}
+
+	// A second temporary change still reverts to the original level
+	put(`{"level":"warn","revert_after_secs":60}`)
+	rec := put(`{"level":"debug","revert_after_secs":0.05}`)
+	var resp LogLevelResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || resp.Level != "debug" || resp.RevertTo != "info" || resp.RevertAt == nil {
+		t.Fatalf("got %d %+v", rec.Code, resp)
+	}
+	if level.Level() != zap.DebugLevel {
+		t.Errorf("level: got %s", level.Level())
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for level.Level() != zap.InfoLevel && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if level.Level() != zap.InfoLevel {
+		t.Fatalf("not reverted: %s", level.Level())
+	}
+
+	rec = httptest.NewRecorder()
+	s.router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/loglevel", nil))
+	resp = LogLevelResponse{}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.Level != "info" || resp.RevertAt != nil {
+		t.Errorf("after revert: got %+v", resp)
+	}
+}
+
+func TestHandleStatus_IncludesRateLimitAndCircuitBreakerConfig(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
+	s.config.Proxy.Traffic.RateLimit.RequestsPerSecond = 1000
+	s.config.Proxy.Traffic.RateLimit.Burst = 100
+	s.config.Proxy.CircuitBreaker.ErrorThreshold = 5
+	s.config.Proxy.CircuitBreaker.Timeout = 30 * time.Second
+
+	req := httptest.NewRequest(http.MethodGet, "/status", nil)
+	rec := httptest.NewRecorder()
+	s.handleStatus(rec, req)
+
+	var resp struct {
+		Config struct {
+			RateLimitRPS   int     `json:"rate_limit_rps"`
+			RateLimitBurst int     `json:"rate_limit_burst"`
+			CBThreshold    int     `json:"cb_threshold"`
+			CBTimeoutSecs  float64 `json:"cb_timeout_secs"`
+		} `json:"config"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+
+	if resp.Config.RateLimitRPS != 1000 {
+		t.Errorf("rate_limit_rps: got %d, want 1000", resp.Config.RateLimitRPS)
+	}
+	if resp.Config.RateLimitBurst != 100 {
+		t.Errorf("rate_limit_burst: got %d, want 100", resp.Config.RateLimitBurst)
+	}
+	if resp.Config.CBThreshold != 5 {
+		t.Errorf("cb_threshold: got %d, want 5", resp.Config.CBThreshold)
+	}
+	if resp.Config.CBTimeoutSecs != 30 {
+		t.Errorf("cb_timeout_secs: got %v, want 30", resp.Config.CBTimeoutSecs)
+	}
+}
+
+func TestHandleListBackends_IncludesUDPBackends(t *testing.T) {
+	h := &mockHealth{state: map[string]bool{
+		"localhost:3000":    true,
+		"localhost:3001":    false,
+		"udp-backend1:5000": true,
+	}}
+	s := testServer(&mockGRPC{}, h, "")
+	s.config.Proxy.UdpBackends = []config.Backend{
+		{Address: "udp-backend1:5000", Weight: 100},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/backends", nil)
+	rec := httptest.NewRecorder()
+	s.handleListBackends(rec, req)
+
+	var resp map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&resp)
+
+	backends := resp["backends"].([]interface{})
+	if len(backends) != 2 {
+		t.Fatalf("expected 2 TCP backends, got %d", len(backends))
+	}
+
+	udpBackends, ok := resp["udp_backends"].([]interface{})
+	if !ok {
+		t.Fatal("udp_backends missing from response")
+	}
+	if len(udpBackends) != 1 {
+		t.Fatalf("expected 1 UDP backend, got %d", len(udpBackends))
+	}
+	entry := udpBackends[0].(map[string]interface{})
+	if entry["address"] != "udp-backend1:5000" {
+		t.Errorf("address: got %v, want udp-backend1:5000", entry["address"])
+	}
+	if entry["healthy"] != true {
+		t.Errorf("healthy: got %v, want true", entry["healthy"])
+	}
+}
+
+func TestHandleListBackends_OmitsStatsWhenProviderAbsent(t *testing.T) {
+	h := &mockHealth{state: map[string]bool{"localhost:3000": true, "localhost:3001": false}}
+	s := testServer(&mockGRPC{}, h, "")
+
+	req := httptest.NewRequest(http.MethodGet, "/backends", nil)
+	rec := httptest.NewRecorder()
+	s.handleListBackends(rec, req)
+
+	var resp map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	backends := resp["backends"].([]interface{})
+	first := backends[0].(map[string]interface{})
+	if _, ok := first["circuit_state"]; ok {
+		t.Error("circuit_state should be absent when no circuitStates provider is set")
+	}
+	if _, ok := first["total_requests"]; ok {
+		t.Error("total_requests should be absent when no circuitStates provider is set")
+	}
+}
+
+func TestHandleListBackends_IncludesStatsWhenProviderPresent(t *testing.T) {
+	h := &mockHealth{state: map[string]bool{"localhost:3000": true, "localhost:3001": false}}
+	s := testServer(&mockGRPC{}, h, "")
+	s.circuitStates = &mockCircuitStates{
+		states: map[string]string{"localhost:3000": "Closed"},
+		stats: map[string]metrics.BackendStat{
+			"localhost:3000": {ActiveConnections: 3, TotalRequests: 42, FailedRequests: 1, AvgLatencyMs: 2.5},
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/backends", nil)
+	rec := httptest.NewRecorder()
+	s.handleListBackends(rec, req)
+
+	var resp map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	backends := resp["backends"].([]interface{})
+
+	var found map[string]interface{}
+	for _, b := range backends {
+		entry := b.(map[string]interface{})
+		if entry["address"] == "localhost:3000" {
+			found = entry
+		}
+	}
+	if found == nil {
+		t.Fatal("localhost:3000 not found in response")
+	}
+	if found["circuit_state"] != "Closed" {
+		t.Errorf("circuit_state: got %v, want Closed", found["circuit_state"])
+	}
+	if found["total_requests"] != float64(42) {
+		t.Errorf("total_requests: got %v, want 42", found["total_requests"])
+	}
+	if found["failed_requests"] != float64(1) {
+		t.Errorf("failed_requests: got %v, want 1", found["failed_requests"])
+	}
+
+	// localhost:3001 has no reported stats — must not appear at all.
+	for _, b := range backends {
+		entry := b.(map[string]interface{})
+		if entry["address"] == "localhost:3001" {
+			if _, ok := entry["total_requests"]; ok {
+				t.Error("localhost:3001 should have no total_requests (never reported)")
+			}
+		}
+	}
+}
+
+func TestHandleAddBackend_Success(t *testing.T) {
+	g := &mockGRPC{}
+	h := &mockHealth{state: map[string]bool{}}
+	s := testServer(g, h, "")
+
+	body := `{"address":"localhost:3002","weight":75}`
+	req := httptest.NewRequest(http.MethodPost, "/backends", bytes.NewBufferString(body))
+	rec := httptest.NewRecorder()
+	s.handleAddBackend(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status: got %d, want 201", rec.Code)
+	}
+	if g.reloadCalls != 1 {
+		t.Errorf("ReloadBackendsWithHealth calls: got %d, want 1", g.reloadCalls)
+	}
+	if h.reloadCalls != 1 {
+		t.Errorf("healthChecker.Reload calls: got %d, want 1", h.reloadCalls)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	found := false
+	for _, b := range s.config.Proxy.Backends {
+		if b.Address == "localhost:3002" && b.Weight == 75 {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("backend not added to config")
+	}
+}
+
+func TestHandleAddBackend_Duplicate(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
+
+	body := `{"address":"localhost:3000","weight":100}`
+	req := httptest.NewRequest(http.MethodPost, "/backends", bytes.NewBufferString(body))
+	rec := httptest.NewRecorder()
+	s.handleAddBackend(rec, req)
+
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status: got %d, want 409", rec.Code)
+	}
+}
+
+func TestHandleAddBackend_MissingAddress(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
+
+	req := httptest.NewRequest(http.MethodPost, "/backends", bytes.NewBufferString(`{"weight":100}`))
+	rec := httptest.NewRecorder()
+	s.handleAddBackend(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status: got %d, want 400", rec.Code)
+	}
+}
+
+func TestHandleRemoveBackend_Success(t *testing.T) {
+	g := &mockGRPC{}
+	h := &mockHealth{state: map[string]bool{}}
+	s := testServer(g, h, "")
+	tracker := &mockBackendTracker{}
+	s.circuitStates = tracker
+
+	// Use chi context to supply URL param
+	req := httptest.NewRequest(http.MethodDelete, "/backends/localhost:3000", nil)
+	req = req.WithContext(setURLParam(req.Context(), "address", "localhost:3000"))
+	rec := httptest.NewRecorder()
+	s.handleRemoveBackend(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status: got %d, want 200 (body: %s)", rec.Code, rec.Body.String())
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, b := range s.config.Proxy.Backends {
+		if b.Address == "localhost:3000" {
+			t.Error("backend still in config after removal")
+		}
+	}
+	if len(tracker.backends) != len(s.config.Proxy.Backends) || slices.Contains(tracker.backends, "localhost:3000") {
+		t.Errorf("metrics backend set: got %v", tracker.backends)
+	}
+}
+
+func TestHandleRemoveBackend_NotFound(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
 
 	req := httptest.NewRequest(http.MethodDelete, "/backends/nonexistent:9999", nil)
 	req = req.WithContext(setURLParam(req.Context(), "address", "nonexistent:9999"))
@@ -405,6 +1290,464 @@ func TestHandleRemoveBackend_NotFound(t *testing.T) {
 	}
 }
 
+func TestHandleGetBackend(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{"localhost:3001": true}}, "")
+
+	req := httptest.NewRequest(http.MethodGet, "/backends/localhost:3001", nil)
+	req = req.WithContext(setURLParam(req.Context(), "address", "localhost:3001"))
+	rec := httptest.NewRecorder()
+	s.handleGetBackend(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status: got %d, want 200 (body: %s)", rec.Code, rec.Body.String())
+	}
+	var got BackendEntry
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Address != "localhost:3001" || got.Weight != 50 || !got.Healthy {
+		t.Errorf("backend: got %+v", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/backends/nonexistent:9999", nil)
+	req = req.WithContext(setURLParam(req.Context(), "address", "nonexistent:9999"))
+	rec = httptest.NewRecorder()
+	s.handleGetBackend(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown backend: got %d, want 404", rec.Code)
+	}
+}
+
+// mockHealthHistory adds per-backend history to mockHealth.
+type mockHealthHistory struct {
+	mockHealth
+	history map[string]health.History
+}
+
+func (m *mockHealthHistory) HealthHistory(address string) (health.History, bool) {
+	h, ok := m.history[address]
+	return h, ok
+}
+
+func TestHandleGetBackend_MergesHealthHistoryAndStats(t *testing.T) {
+	checked := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	h := &mockHealthHistory{
+		mockHealth: mockHealth{state: map[string]bool{"localhost:3000": false}},
+		history: map[string]health.History{"localhost:3000": {
+			LastChecked:         checked,
+			ConsecutiveFailures: 3,
+			Transitions:         []health.Transition{{Time: checked.Add(-10 * time.Second), Healthy: false}},
+		}},
+	}
+	s := testServer(&mockGRPC{}, h, "")
+	s.circuitStates = &mockCircuitStates{
+		states: map[string]string{"localhost:3000": "Open"},
+		stats:  map[string]metrics.BackendStat{"localhost:3000": {ActiveConnections: 2, TotalRequests: 40, FailedRequests: 9, AvgLatencyMs: 12.5}},
+	}
+
+	get := func(address string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/backends/"+address, nil)
+		req = req.WithContext(setURLParam(req.Context(), "address", address))
+		rec := httptest.NewRecorder()
+		s.handleGetBackend(rec, req)
+		return rec
+	}
+
+	var got BackendDetail
+	if err := json.NewDecoder(get("localhost:3000").Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Weight != 100 || got.Healthy || got.CircuitState != "Open" || got.HealthCheck == nil {
+		t.Errorf("config and health: got %+v", got.BackendEntry)
+	}
+	if got.BackendStats == nil || got.ActiveConnections != 2 || got.FailedRequests != 9 || got.AvgLatencyMs != 12.5 {
+		t.Errorf("stats: got %+v", got.BackendStats)
+	}
+	hh := got.HealthHistory
+	if hh == nil || !hh.LastChecked.Equal(checked) || hh.ConsecutiveFailures != 3 || len(hh.Transitions) != 1 || hh.Transitions[0].Healthy {
+		t.Errorf("health history: got %+v", hh)
+	}
+
+	var unchecked map[string]interface{}
+	json.NewDecoder(get("localhost:3001").Body).Decode(&unchecked)
+	if _, ok := unchecked["health_history"]; ok {
+		t.Error("health_history should be omitted before the first check")
+	}
+}
+
+func TestHandleUpdateBackend_UpdatesOrCreates(t *testing.T) {
+	g := &mockGRPC{}
+	s := testServer(g, &mockHealth{state: map[string]bool{}}, "")
+
+	put := func(address, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/backends/"+address, bytes.NewBufferString(body))
+		req = req.WithContext(setURLParam(req.Context(), "address", address))
+		rec := httptest.NewRecorder()
+		s.handleUpdateBackend(rec, req)
+		return rec
+	}
+
+	if rec := put("localhost:3000", `{"weight":10,"health_check":{"path":"/healthz"}}`); rec.Code != http.StatusOK {
+		t.Fatalf("update: got %d, want 200 (body: %s)", rec.Code, rec.Body.String())
+	}
+	if rec := put("localhost:3005", `{}`); rec.Code != http.StatusCreated {
+		t.Fatalf("create: got %d, want 201 (body: %s)", rec.Code, rec.Body.String())
+	}
+	if rec := put("localhost:3000", `{"health_check":{"scheme":"ftp"}}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad scheme: got %d, want 400", rec.Code)
+	}
+	if g.reloadCalls != 2 {
+		t.Errorf("ReloadBackendsWithHealth calls: got %d, want 2", g.reloadCalls)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	backends := s.config.Proxy.Backends
+	if len(backends) != 3 {
+		t.Fatalf("backends: got %d, want 3", len(backends))
+	}
+	if backends[0].Weight != 10 || backends[0].HealthCheck.Path != "/healthz" {
+		t.Errorf("updated backend: got %+v", backends[0])
+	}
+	if backends[2].Address != "localhost:3005" || backends[2].Weight != 100 || backends[2].HealthCheck.Interval != 5*time.Second {
+		t.Errorf("created backend should get defaults: got %+v", backends[2])
+	}
+}
+
+func TestHandlePatchBackend_AppliesZeroWeight(t *testing.T) {
+	g := &mockGRPC{}
+	s := testServer(g, &mockHealth{state: map[string]bool{}}, "")
+
+	patch := func(address, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/backends/"+address, bytes.NewBufferString(body))
+		req = req.WithContext(setURLParam(req.Context(), "address", address))
+		rec := httptest.NewRecorder()
+		s.handlePatchBackend(rec, req)
+		return rec
+	}
+
+	rec := patch("localhost:3001", `{"weight":0}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status: got %d, want 200 (body: %s)", rec.Code, rec.Body.String())
+	}
+	var got BackendEntry
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Address != "localhost:3001" || got.Weight != 0 {
+		t.Errorf("response: got %+v", got)
+	}
+	if w := s.config.Proxy.Backends[1].Weight; w != 0 {
+		t.Errorf("weight in config: got %d, want 0", w)
+	}
+	if g.reloadCalls != 1 {
+		t.Errorf("ReloadBackendsWithHealth calls: got %d, want 1", g.reloadCalls)
+	}
+
+	if rec := patch("localhost:3001", `{"health_check":{"path":"/ready"}}`); rec.Code != http.StatusOK || s.config.Proxy.Backends[1].Weight != 0 {
+		t.Errorf("patch without weight changed it: status %d, weight %d", rec.Code, s.config.Proxy.Backends[1].Weight)
+	}
+	if rec := patch("nonexistent:9999", `{"weight":10}`); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown backend: got %d, want 404", rec.Code)
+	}
+	if rec := patch("localhost:3000", `{"weight":-1}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("negative weight: got %d, want 400", rec.Code)
+	}
+}
+
+func TestHandlePatchBackend_SetsPriority(t *testing.T) {
+	g := &mockGRPC{}
+	s := testServer(g, &mockHealth{state: map[string]bool{}}, "")
+
+	patch := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/backends/localhost:3001", bytes.NewBufferString(body))
+		req = req.WithContext(setURLParam(req.Context(), "address", "localhost:3001"))
+		rec := httptest.NewRecorder()
+		s.handlePatchBackend(rec, req)
+		return rec
+	}
+
+	rec := patch(`{"priority":1}`)
+	var got BackendEntry
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || got.Priority != 1 || s.config.Proxy.Backends[1].Priority != 1 {
+		t.Errorf("status %d, response %+v, config %+v", rec.Code, got, s.config.Proxy.Backends[1])
+	}
+	if rec := patch(`{"weight":5}`); rec.Code != http.StatusOK || s.config.Proxy.Backends[1].Priority != 1 {
+		t.Errorf("patch without priority changed it: status %d, priority %d", rec.Code, s.config.Proxy.Backends[1].Priority)
+	}
+	if rec := patch(`{"priority":128}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("priority out of range: got %d, want 400", rec.Code)
+	}
+}
+
+func TestHandlePatchBackend_SetsZone(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
+	h := s.router()
+	patch := func(body string) BackendEntry {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPatch, "/api/v1/backends/localhost:3000", strings.NewReader(body)))
+		var got BackendEntry
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %v", rec.Code, err)
+		}
+		return got
+	}
+
+	if got := patch(`{"zone":"us-east-1a","region":"us-east-1"}`); got.Zone != "us-east-1a" || got.Region != "us-east-1" {
+		t.Errorf("response: got %+v", got)
+	}
+	// Only the fields present change
+	if got := patch(`{"zone":""}`); got.Zone != "" || s.config.Proxy.Backends[0].Region != "us-east-1" {
+		t.Errorf("clearing zone: got %+v, config %+v", got, s.config.Proxy.Backends[0])
+	}
+}
+
+func TestBackendChanges_DrainingLastPrimaryFailsOverToBackup(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
+	s.SetEventFeed(events.NewFeed())
+	sub := s.feed.Subscribe(0)
+	defer sub.Close()
+	h := s.router()
+	call := func(method, path, body string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec.Code
+	}
+
+	if code := call(http.MethodPost, "/api/v1/backends", `{"address":"spare:3000","backup":true}`); code != http.StatusCreated {
+		t.Fatalf("add backup: got %d", code)
+	}
+	call(http.MethodPatch, "/api/v1/backends/localhost:3000", `{"weight":0}`)
+	call(http.MethodPatch, "/api/v1/backends/localhost:3001", `{"weight":0}`)
+
+	var failovers []events.Event
+	for len(sub.Events) > 0 {
+		if ev := <-sub.Events; ev.Type == events.TypePriorityFailover {
+			failovers = append(failovers, ev)
+		}
+	}
+	if len(failovers) != 1 || failovers[0].Attributes["from"] != "0" || failovers[0].Attributes["to"] != "backup" {
+		t.Errorf("failover events: got %+v", failovers)
+	}
+
+	// The backups can't be all that's left
+	if code := call(http.MethodPatch, "/api/v1/backends/localhost:3000", `{"backup":true}`); code != http.StatusOK {
+		t.Errorf("first primary to backup: got %d", code)
+	}
+	if code := call(http.MethodPatch, "/api/v1/backends/localhost:3001", `{"backup":true}`); code != http.StatusBadRequest {
+		t.Errorf("last primary to backup: got %d, want 400", code)
+	}
+}
+
+func TestBackendChanges_IfMatch(t *testing.T) {
+	g := &mockGRPC{}
+	s := testServer(g, &mockHealth{state: map[string]bool{}}, "")
+	h := s.router()
+
+	call := func(method, path, body, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	errCode := func(rec *httptest.ResponseRecorder) string {
+		var env ErrorResponse
+		json.NewDecoder(rec.Body).Decode(&env)
+		return env.Error.Code
+	}
+
+	etag := call(http.MethodGet, "/api/v1/backends", "", "").Header().Get("ETag")
+	if etag != `"`+s.config.Version()+`"` {
+		t.Fatalf("GET /backends ETag: got %q", etag)
+	}
+
+	rec := call(http.MethodPatch, "/api/v1/backends/localhost:3001", `{"weight":10}`, etag)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("matching If-Match: got %d (body: %s)", rec.Code, rec.Body.String())
+	}
+	next := rec.Header().Get("ETag")
+	if next == etag || next != `"`+s.config.Version()+`"` {
+		t.Errorf("ETag after change: got %q, was %q", next, etag)
+	}
+
+	// Someone else's change landed in between
+	rec = call(http.MethodDelete, "/api/v1/backends/localhost:3001", "", etag)
+	if rec.Code != http.StatusConflict || errCode(rec) != ErrCodeVersionConflict {
+		t.Errorf("stale If-Match: got %d %q, want 409 %q", rec.Code, errCode(rec), ErrCodeVersionConflict)
+	}
+	if len(s.config.Proxy.Backends) != 2 || g.reloadCalls != 1 {
+		t.Errorf("stale write applied: %d backends, %d reloads", len(s.config.Proxy.Backends), g.reloadCalls)
+	}
+
+	// It's part of the config, so this moves the version too
+	s.config.Admin.RequireIfMatch = true
+	rec = call(http.MethodPost, "/api/v1/backends", `{"address":"localhost:3002"}`, "")
+	if rec.Code != http.StatusPreconditionRequired || errCode(rec) != ErrCodeVersionRequired {
+		t.Errorf("missing If-Match: got %d %q, want 428 %q", rec.Code, errCode(rec), ErrCodeVersionRequired)
+	}
+	if rec := call(http.MethodPost, "/api/v1/backends", `{"address":"localhost:3002"}`, `"other", W/"`+s.config.Version()+`"`); rec.Code != http.StatusCreated {
+		t.Errorf("If-Match list: got %d (body: %s)", rec.Code, rec.Body.String())
+	}
+}
+
+func TestChangeBackends_DataPlaneFailureKeepsConfig(t *testing.T) {
+	h := &mockHealth{state: map[string]bool{}}
+	s := testServer(&mockGRPC{reloadErr: errors.New("unavailable")}, h, "")
+
+	req := httptest.NewRequest(http.MethodDelete, "/backends/localhost:3000", nil)
+	req = req.WithContext(setURLParam(req.Context(), "address", "localhost:3000"))
+	rec := httptest.NewRecorder()
+	s.handleRemoveBackend(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status: got %d, want 500", rec.Code)
+	}
+	if len(s.config.Proxy.Backends) != 2 {
+		t.Errorf("backends: got %d, want 2 (change not adopted)", len(s.config.Proxy.Backends))
+	}
+	if h.reloadCalls != 0 {
+		t.Error("health checker reloaded for a rejected change")
+	}
+}
+
+func TestChangeBackends_PersistsToConfigFile(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
+	s.configPath = writeTempConfig(t)
+
+	body := `{"address":"db4.internal:5432","weight":25}`
+	req := httptest.NewRequest(http.MethodPost, "/backends", bytes.NewBufferString(body))
+	rec := httptest.NewRecorder()
+	s.handleAddBackend(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status: got %d, want 201 (body: %s)", rec.Code, rec.Body.String())
+	}
+	if cfg, err := config.Load(s.configPath); err != nil || len(cfg.Proxy.Backends) != 0 {
+		t.Fatalf("config file changed without persist: %v", err)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/backends?persist=true", bytes.NewBufferString(`{"address":"db5.internal:5432"}`))
+	rec = httptest.NewRecorder()
+	s.handleAddBackend(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status: got %d, want 201 (body: %s)", rec.Code, rec.Body.String())
+	}
+
+	cfg, err := config.Load(s.configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Proxy.Backends) != 4 {
+		t.Fatalf("saved backends: got %d, want 4", len(cfg.Proxy.Backends))
+	}
+	if got := cfg.Proxy.Backends[2]; got.Address != "db4.internal:5432" || got.Weight != 25 {
+		t.Errorf("saved backend: got %+v", got)
+	}
+}
+
+func TestHandleGetConfig_RedactsAndVersions(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "secret-token")
+
+	req := httptest.NewRequest(http.MethodGet, "/config", nil)
+	rec := httptest.NewRecorder()
+	s.handleGetConfig(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status: got %d, want 200", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "secret-token") {
+		t.Error("response contains the API token")
+	}
+	var got ConfigResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != s.config.Version() || rec.Header().Get("ETag") != `"`+got.Version+`"` {
+		t.Errorf("version: got %q, ETag %q", got.Version, rec.Header().Get("ETag"))
+	}
+	proxy, _ := got.Config["proxy"].(map[string]interface{})
+	if backends, _ := proxy["backends"].([]interface{}); len(backends) != 2 {
+		t.Errorf("config.proxy.backends: got %v", proxy["backends"])
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/config?format=yaml", nil)
+	rec = httptest.NewRecorder()
+	s.handleGetConfig(rec, req)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/yaml" {
+		t.Errorf("yaml Content-Type: got %q", ct)
+	}
+	if !strings.HasPrefix(rec.Body.String(), "# version: "+got.Version) || strings.Contains(rec.Body.String(), "secret-token") {
+		t.Errorf("yaml body: got %s", rec.Body.String())
+	}
+}
+
+func TestHandlePutConfig_RoundTripsWithVersionCheck(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
+	cfg, err := config.Load(writeTempConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Admin.APIToken = "secret-token"
+	s.config = cfg
+
+	get := httptest.NewRecorder()
+	s.handleGetConfig(get, httptest.NewRequest(http.MethodGet, "/config?format=yaml", nil))
+	etag := get.Header().Get("ETag")
+	edited := strings.Replace(get.Body.String(), "algorithm: round_robin", "algorithm: least_connections", 1)
+
+	put := func(body, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/config", strings.NewReader(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		s.handlePutConfig(rec, req)
+		return rec
+	}
+
+	rec := put(edited, etag)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("put: got %d, want 200 (body: %s)", rec.Code, rec.Body.String())
+	}
+	if s.config.Proxy.LoadBalancing.Algorithm != "least_connections" {
+		t.Errorf("algorithm: got %q, want least_connections", s.config.Proxy.LoadBalancing.Algorithm)
+	}
+	if s.config.Admin.APIToken != "secret-token" {
+		t.Errorf("redacted token not restored: got %q", s.config.Admin.APIToken)
+	}
+	if rec.Header().Get("ETag") == etag {
+		t.Error("ETag unchanged after a config change")
+	}
+
+	if rec := put(edited, etag); rec.Code != http.StatusConflict {
+		t.Errorf("stale If-Match: got %d, want 409", rec.Code)
+	}
+	if rec := put("proxy:\n  listen:\n    tcp: \"0.0.0.0:8080\"\n  bakends: []\n", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown field: got %d, want 400", rec.Code)
+	}
+}
+
+func TestHandlePutConfig_DataPlaneFailureKeepsConfig(t *testing.T) {
+	s := testServer(&mockGRPC{updateErr: errors.New("prepare rejected")}, &mockHealth{state: map[string]bool{}}, "")
+	before := s.config
+	body, _ := os.ReadFile(writeTempConfig(t))
+
+	rec := httptest.NewRecorder()
+	s.handlePutConfig(rec, httptest.NewRequest(http.MethodPut, "/config", bytes.NewReader(body)))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status: got %d, want 500", rec.Code)
+	}
+	if s.config != before {
+		t.Error("running config replaced although the data plane rejected it")
+	}
+}
+
 func TestHandleReload_UsesConfigPath(t *testing.T) {
 	configPath := writeTempConfig(t)
 	g := &mockGRPC{}
@@ -412,12 +1755,73 @@ func TestHandleReload_UsesConfigPath(t *testing.T) {
 	s := testServer(g, h, "")
 	s.configPath = configPath
 
+	reloads := testutil.ToFloat64(configReloads.WithLabelValues("file", "success"))
 	req := httptest.NewRequest(http.MethodPost, "/reload", nil)
 	rec := httptest.NewRecorder()
 	s.handleReload(rec, req)
 
-	if rec.Code != http.StatusOK {
-		t.Fatalf("status: got %d, want 200 (body: %s)", rec.Code, rec.Body.String())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status: got %d, want 200 (body: %s)", rec.Code, rec.Body.String())
+	}
+	if got := testutil.ToFloat64(configReloads.WithLabelValues("file", "success")) - reloads; got != 1 {
+		t.Errorf("aegis_config_reloads_total{source=file,result=success}: got %v more, want 1", got)
+	}
+}
+
+func TestHandleReload_DryRunDoesNotApply(t *testing.T) {
+	configPath := writeTempConfig(t)
+	g := &mockValidator{mockGRPC: mockGRPC{updateErr: errors.New("must not be called")}}
+	h := &mockHealth{state: map[string]bool{}}
+	s := testServer(g, h, "")
+	s.configPath = configPath
+
+	req := httptest.NewRequest(http.MethodPost, "/reload?dry_run=true", nil)
+	rec := httptest.NewRecorder()
+	s.handleReload(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status: got %d, want 200 (body: %s)", rec.Code, rec.Body.String())
+	}
+	if h.reloadCalls != 0 {
+		t.Error("dry run reloaded the health checker")
+	}
+
+	g.err = errors.New("data plane rejected config: unsupported load balancing algorithm")
+	rec = httptest.NewRecorder()
+	s.handleReload(rec, req)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("rejected dry run: got %d, want 422", rec.Code)
+	}
+}
+
+func TestHandleReload_BodyPath(t *testing.T) {
+	configPath := writeTempConfig(t)
+	data, _ := os.ReadFile(configPath)
+	alt := filepath.Join(filepath.Dir(configPath), "alt.yaml")
+	if err := os.WriteFile(alt, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	outside := writeTempConfig(t)
+
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
+	s.configPath = configPath
+	reload := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/reload", bytes.NewBufferString(`{"path":"`+path+`"}`))
+		rec := httptest.NewRecorder()
+		s.handleReload(rec, req)
+		return rec
+	}
+
+	if rec := reload("alt.yaml"); rec.Code != http.StatusOK {
+		t.Fatalf("relative path: got %d, want 200 (body: %s)", rec.Code, rec.Body.String())
+	}
+	if s.configPath != alt {
+		t.Errorf("configPath: got %q, want %q", s.configPath, alt)
+	}
+	if rec := reload(outside); rec.Code != http.StatusBadRequest {
+		t.Errorf("path outside config dir: got %d, want 400", rec.Code)
+	}
+	if rec := reload("../../etc/passwd"); rec.Code != http.StatusBadRequest {
+		t.Errorf("non-yaml path: got %d, want 400", rec.Code)
 	}
 }
 
@@ -436,35 +1840,154 @@ func TestHandleReload_WrongPathFails(t *testing.T) {
 	}
 }
 
-func TestRequireToken_AllowsWhenEmpty(t *testing.T) {
+func TestHandleDrain_RunsInBackground(t *testing.T) {
+	g := &mockDrain{release: make(chan struct{})}
+	s := testServer(g, &mockHealth{state: map[string]bool{}}, "")
+	s.circuitStates = &mockCircuitStates{stats: map[string]metrics.BackendStat{
+		"localhost:3000": {ActiveConnections: 3},
+		"localhost:3001": {ActiveConnections: 4},
+	}}
+
+	rec := httptest.NewRecorder()
+	s.handleDrain(rec, httptest.NewRequest(http.MethodPost, "/drain?timeout=90s", nil))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status: got %d, want 202 (body: %s)", rec.Code, rec.Body.String())
+	}
+	var started OperationStatus
+	if err := json.NewDecoder(rec.Body).Decode(&started); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Header().Get("Location") != "/api/v1/operations/"+started.ID || started.State != OperationRunning {
+		t.Fatalf("started: got %+v, Location %q", started, rec.Header().Get("Location"))
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleDrain(rec, httptest.NewRequest(http.MethodPost, "/drain", nil))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("second drain: got %d, want 409", rec.Code)
+	}
+
+	poll := func() OperationStatus {
+		req := httptest.NewRequest(http.MethodGet, "/operations/"+started.ID, nil)
+		req = req.WithContext(setURLParam(req.Context(), "id", started.ID))
+		rec := httptest.NewRecorder()
+		s.handleGetOperation(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("poll: got %d, want 200", rec.Code)
+		}
+		var op OperationStatus
+		json.NewDecoder(rec.Body).Decode(&op)
+		return op
+	}
+	if op := poll(); op.State != OperationRunning || op.Progress["active_connections"] != 7 {
+		t.Errorf("while running: got %+v", op)
+	}
+
+	close(g.release)
+	deadline := time.Now().Add(2 * time.Second)
+	op := poll()
+	for op.State == OperationRunning && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		op = poll()
+	}
+	if op.State != OperationSucceeded || op.FinishedAt == nil || op.Progress != nil {
+		t.Errorf("after drain: got %+v", op)
+	}
+	if g.gotTimeout != 90 {
+		t.Errorf("data plane timeout: got %d, want 90", g.gotTimeout)
+	}
+}
+
+func TestHandleDrain_RejectsBadTimeout(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
+	for _, timeout := range []string{"soon", "0", "2h"} {
+		rec := httptest.NewRecorder()
+		s.handleDrain(rec, httptest.NewRequest(http.MethodPost, "/drain?timeout="+timeout, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("timeout=%s: got %d, want 400", timeout, rec.Code)
+		}
+	}
+}
+
+func TestHandleGetOperation_NotFound(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
+	req := httptest.NewRequest(http.MethodGet, "/operations/op-missing", nil)
+	req = req.WithContext(setURLParam(req.Context(), "id", "op-missing"))
+	rec := httptest.NewRecorder()
+	s.handleGetOperation(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status: got %d, want 404", rec.Code)
+	}
+}
+
+type mockTraffic struct {
+	mockCommands
+	info *grpc.DataPlaneInfo
+}
+
+func (m *mockTraffic) DataPlaneInfo() *grpc.DataPlaneInfo { return m.info }
+
+func TestHandleTraffic_PauseAndResume(t *testing.T) {
+	g := &mockTraffic{
+		mockCommands: mockCommands{result: &grpc.CommandResult{Message: "traffic paused, 3 active connections kept"}},
+		info:         &grpc.DataPlaneInfo{Features: []string{"command:pause_traffic", "command:resume_traffic"}},
+	}
+	s := testServer(g, &mockHealth{state: map[string]bool{}}, "")
+
+	rec := httptest.NewRecorder()
+	s.handleTraffic(true)(rec, httptest.NewRequest(http.MethodPost, "/traffic/pause", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("pause: got %d, want 200 (body: %s)", rec.Code, rec.Body.String())
+	}
+	var got TrafficResponse
+	json.NewDecoder(rec.Body).Decode(&got)
+	if !got.Paused || g.gotName != "pause_traffic" || got.Message != g.result.Message {
+		t.Errorf("pause: got %+v, command %q", got, g.gotName)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleTraffic(false)(rec, httptest.NewRequest(http.MethodPost, "/traffic/resume", nil))
+	if rec.Code != http.StatusOK || g.gotName != "resume_traffic" {
+		t.Errorf("resume: got %d, command %q", rec.Code, g.gotName)
+	}
+
+	g.info.Features = nil
+	rec = httptest.NewRecorder()
+	s.handleTraffic(true)(rec, httptest.NewRequest(http.MethodPost, "/traffic/pause", nil))
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("data plane without the feature: got %d, want 501", rec.Code)
+	}
+}
+
+func TestRequireRole_AllowsWhenEmpty(t *testing.T) {
 	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
 
 	called := false
 	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true })
 	req := httptest.NewRequest(http.MethodPost, "/", nil)
 	rec := httptest.NewRecorder()
-	s.requireToken(next).ServeHTTP(rec, req)
+	s.requireRole(auth.RoleAdmin)(next).ServeHTTP(rec, req)
 
 	if !called {
 		t.Error("next handler not called when token is empty")
 	}
 }
 
-func TestRequireToken_BlocksWrongToken(t *testing.T) {
+func TestRequireRole_BlocksWrongToken(t *testing.T) {
 	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "secret")
 
 	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
 	req := httptest.NewRequest(http.MethodPost, "/", nil)
 	req.Header.Set("Authorization", "Bearer wrong")
 	rec := httptest.NewRecorder()
-	s.requireToken(next).ServeHTTP(rec, req)
+	s.requireRole(auth.RoleAdmin)(next).ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusUnauthorized {
 		t.Errorf("status: got %d, want 401", rec.Code)
 	}
 }
 
-func TestRequireToken_AllowsCorrectToken(t *testing.T) {
+func TestRequireRole_AllowsCorrectToken(t *testing.T) {
 	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "secret")
 
 	called := false
@@ -472,9 +1995,608 @@ func TestRequireToken_AllowsCorrectToken(t *testing.T) {
 	req := httptest.NewRequest(http.MethodPost, "/", nil)
 	req.Header.Set("Authorization", "Bearer secret")
 	rec := httptest.NewRecorder()
-	s.requireToken(next).ServeHTTP(rec, req)
+	s.requireRole(auth.RoleAdmin)(next).ServeHTTP(rec, req)
 
 	if !called {
 		t.Error("next handler not called with correct token")
 	}
 }
+
+func TestHandleDataPlaneCommand_ForwardsNameAndArgs(t *testing.T) {
+	dp := &mockCommands{result: &grpc.CommandResult{
+		Message: "1 active connections",
+		Output:  json.RawMessage(`[{"id":1,"client":"10.0.0.1:5000"}]`),
+	}}
+	s := testServer(dp, &mockHealth{}, "")
+
+	req := httptest.NewRequest(http.MethodPost, "/dataplane/commands/dump_connections", bytes.NewBufferString(`{"verbose":"1"}`))
+	req = req.WithContext(setURLParam(req.Context(), "name", "dump_connections"))
+	rec := httptest.NewRecorder()
+	s.handleDataPlaneCommand(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status: got %d, want 200 (%s)", rec.Code, rec.Body.String())
+	}
+	if dp.gotName != "dump_connections" || dp.gotArgs["verbose"] != "1" {
+		t.Errorf("forwarded %q %v", dp.gotName, dp.gotArgs)
+	}
+	var resp struct {
+		Output []map[string]interface{} `json:"output"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if len(resp.Output) != 1 || resp.Output[0]["client"] != "10.0.0.1:5000" {
+		t.Errorf("output not passed through as JSON: %+v", resp.Output)
+	}
+}
+
+func TestHandleDataPlaneCommand_MapsErrors(t *testing.T) {
+	dp := &mockCommands{err: status.Error(codes.InvalidArgument, "unknown command")}
+	s := testServer(dp, &mockHealth{}, "")
+
+	req := httptest.NewRequest(http.MethodPost, "/dataplane/commands/bogus", nil)
+	req = req.WithContext(setURLParam(req.Context(), "name", "bogus"))
+	rec := httptest.NewRecorder()
+	s.handleDataPlaneCommand(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown command: got %d, want 400", rec.Code)
+	}
+
+	s = testServer(&mockGRPC{}, &mockHealth{}, "")
+	rec = httptest.NewRecorder()
+	s.handleDataPlaneCommand(rec, req)
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("data plane without commands: got %d, want 501", rec.Code)
+	}
+}
+
+func TestListCircuitBreakers_FromDataPlane(t *testing.T) {
+	g := &mockTraffic{
+		mockCommands: mockCommands{result: &grpc.CommandResult{
+			Output: json.RawMessage(`[{"backend":"localhost:3000","state":"Open","failure_count":5,"error_threshold":5,"opened_at_ms":1700000000000,"last_failure_ms":1700000001000}]`),
+		}},
+		info: &grpc.DataPlaneInfo{Features: []string{"command:circuit_breakers"}},
+	}
+	s := testServer(g, &mockHealth{state: map[string]bool{}}, "")
+	s.circuitStates = &mockCircuitStates{stats: map[string]metrics.BackendStat{
+		"localhost:3000": {FailedRequests: 12},
+	}}
+
+	rec := httptest.NewRecorder()
+	s.handleListCircuitBreakers(rec, httptest.NewRequest(http.MethodGet, "/circuit-breakers", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200 (body: %s)", rec.Code, rec.Body.String())
+	}
+	var got CircuitBreakersResponse
+	json.NewDecoder(rec.Body).Decode(&got)
+	if got.Source != "data_plane" || len(got.Breakers) != 2 || g.gotName != "circuit_breakers" {
+		t.Fatalf("got %+v, command %q", got, g.gotName)
+	}
+	open := got.Breakers[0]
+	if open.Address != "localhost:3000" || open.State != "open" || *open.FailureCount != 5 || *open.FailedRequests != 12 {
+		t.Errorf("open breaker: got %+v", open)
+	}
+	if open.OpenedAt == nil || !open.OpenedAt.Equal(time.UnixMilli(1700000000000)) {
+		t.Errorf("opened_at: got %v", open.OpenedAt)
+	}
+	if untried := got.Breakers[1]; untried.Address != "localhost:3001" || untried.State != "closed" {
+		t.Errorf("backend without a breaker: got %+v", untried)
+	}
+}
+
+func TestListCircuitBreakers_FallsBackToMetrics(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
+	s.circuitStates = &mockCircuitStates{states: map[string]string{"localhost:3001": "HalfOpen"}}
+
+	rec := httptest.NewRecorder()
+	s.handleListCircuitBreakers(rec, httptest.NewRequest(http.MethodGet, "/circuit-breakers", nil))
+	var got CircuitBreakersResponse
+	json.NewDecoder(rec.Body).Decode(&got)
+	if got.Source != "metrics" || len(got.Breakers) != 2 || got.Breakers[1].State != "half_open" || got.Breakers[1].FailureCount != nil {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestResetCircuitBreaker(t *testing.T) {
+	g := &mockCommands{result: &grpc.CommandResult{Message: "circuit breaker for localhost:3000 closed"}}
+	s := testServer(g, &mockHealth{state: map[string]bool{}}, "")
+
+	rec := httptest.NewRecorder()
+	s.handleResetCircuitBreaker(rec, reqWithAddress(http.MethodPost, "/circuit-breakers/localhost:3000/reset", "localhost:3000"))
+	if rec.Code != http.StatusOK || g.gotName != "reset_circuit" || g.gotArgs["backend"] != "localhost:3000" {
+		t.Fatalf("got %d, command %q %v (body: %s)", rec.Code, g.gotName, g.gotArgs, rec.Body.String())
+	}
+
+	// Never-tried backends have no breaker on the data plane; already closed
+	g.err = status.Error(codes.NotFound, "no circuit breaker")
+	rec = httptest.NewRecorder()
+	s.handleResetCircuitBreaker(rec, reqWithAddress(http.MethodPost, "/", "localhost:3001"))
+	if rec.Code != http.StatusOK {
+		t.Errorf("breaker not created yet: got %d, want 200", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleResetCircuitBreaker(rec, reqWithAddress(http.MethodPost, "/", "10.0.0.9:80"))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unconfigured backend: got %d, want 404", rec.Code)
+	}
+}
+
+func reqWithAddress(method, target, address string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	return req.WithContext(setURLParam(req.Context(), "address", address))
+}
+
+type mockRateLimit struct {
+	mockGRPC
+	got *config.RateLimitConfig
+	err error
+}
+
+func (m *mockRateLimit) UpdateRateLimit(_ context.Context, limit config.RateLimitConfig) error {
+	m.got = &limit
+	return m.err
+}
+
+func TestPutRateLimit(t *testing.T) {
+	g := &mockRateLimit{}
+	s := testServer(g, &mockHealth{state: map[string]bool{}}, "")
+	s.config.Proxy.Traffic.RateLimit = config.RateLimitConfig{RequestsPerSecond: 1000, Burst: 100}
+
+	rec := httptest.NewRecorder()
+	s.handlePutRateLimit(rec, httptest.NewRequest(http.MethodPut, "/rate-limit", strings.NewReader(`{"requests_per_second":50}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200 (body: %s)", rec.Code, rec.Body.String())
+	}
+	want := config.RateLimitConfig{RequestsPerSecond: 50, Burst: 100}
+	if g.got == nil || *g.got != want || s.config.Proxy.Traffic.RateLimit != want {
+		t.Errorf("pushed %v, running %v, want %v (burst unchanged)", g.got, s.config.Proxy.Traffic.RateLimit, want)
+	}
+
+	for _, body := range []string{`{}`, `{"burst":-1}`, `not json`} {
+		rec = httptest.NewRecorder()
+		s.handlePutRateLimit(rec, httptest.NewRequest(http.MethodPut, "/rate-limit", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("body %s: got %d, want 400", body, rec.Code)
+		}
+	}
+
+	g.err = errors.New("unavailable")
+	rec = httptest.NewRecorder()
+	s.handlePutRateLimit(rec, httptest.NewRequest(http.MethodPut, "/rate-limit", strings.NewReader(`{"burst":1}`)))
+	if rec.Code != http.StatusInternalServerError || s.config.Proxy.Traffic.RateLimit != want {
+		t.Errorf("rejected push: got %d, running %v", rec.Code, s.config.Proxy.Traffic.RateLimit)
+	}
+}
+
+func TestMetricsStream(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
+	collector := metrics.NewCollector()
+	s.circuitStates = collector
+	collector.UpdateFromProto(&pb.MetricsData{
+		ActiveConnections: 3,
+		Timestamp:         1700000000000,
+		BackendMetrics:    []*pb.BackendMetrics{{Address: "localhost:3000", CircuitState: "HalfOpen"}},
+	})
+
+	srv := httptest.NewServer(s.router())
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/v1/metrics/stream", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type: got %q", ct)
+	}
+
+	events := bufio.NewScanner(resp.Body)
+	nextData := func() MetricsSnapshot {
+		t.Helper()
+		for events.Scan() {
+			if data, ok := strings.CutPrefix(events.Text(), "data: "); ok {
+				var snap MetricsSnapshot
+				if err := json.Unmarshal([]byte(data), &snap); err != nil {
+					t.Fatalf("bad event data %q: %v", data, err)
+				}
+				return snap
+			}
+		}
+		t.Fatalf("stream ended: %v", events.Err())
+		return MetricsSnapshot{}
+	}
+
+	first := nextData()
+	if first.ActiveConnections != 3 || len(first.Backends) != 1 || first.Backends[0].CircuitState != "half_open" {
+		t.Errorf("latest snapshot on connect: got %+v", first)
+	}
+	if !first.Timestamp.Equal(time.UnixMilli(1700000000000)) {
+		t.Errorf("timestamp: got %v", first.Timestamp)
+	}
+
+	collector.UpdateFromProto(&pb.MetricsData{ActiveConnections: 7})
+	if next := nextData(); next.ActiveConnections != 7 {
+		t.Errorf("pushed snapshot: got %+v", next)
+	}
+
+	// GET /metrics serves the same snapshot in one read
+	rec := httptest.NewRecorder()
+	s.router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/metrics", nil))
+	var snap MetricsSnapshot
+	json.NewDecoder(rec.Body).Decode(&snap)
+	if rec.Code != http.StatusOK || snap.ActiveConnections != 7 {
+		t.Errorf("GET /metrics: got %d %+v", rec.Code, snap)
+	}
+}
+
+func TestHandleMetrics_BeforeFirstSnapshot(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
+	rec := httptest.NewRecorder()
+	s.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("without a collector: got %d", rec.Code)
+	}
+
+	s.circuitStates = &mockMetricsSource{}
+	rec = httptest.NewRecorder()
+	s.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), ErrCodeMetricsUnavailable) {
+		t.Errorf("no snapshot yet: got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestHandleTop(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
+	src := &mockTopTalkers{top: metrics.Top{
+		Window:             5 * time.Minute,
+		BackendsByTraffic:  []metrics.TopEntry{{Key: "localhost:3000", Requests: 10, Errors: 1, ErrorRate: 0.1, Bytes: 4096}},
+		ClientsByErrorRate: []metrics.TopEntry{{Key: "10.0.0.1", Requests: 2, Errors: 2, ErrorRate: 1}},
+	}}
+	s.circuitStates = src
+
+	rec := httptest.NewRecorder()
+	s.router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/top?n=3", nil))
+	var resp TopResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || src.n != 3 || resp.Window != "5m0s" {
+		t.Fatalf("GET /top: got %d n=%d %+v", rec.Code, src.n, resp)
+	}
+	if len(resp.Backends.ByTraffic) != 1 || resp.Backends.ByTraffic[0].Bytes != 4096 || resp.Backends.ByErrorRate == nil {
+		t.Errorf("backends: got %+v", resp.Backends)
+	}
+	if len(resp.Clients.ByErrorRate) != 1 || resp.Clients.ByErrorRate[0].Key != "10.0.0.1" {
+		t.Errorf("clients: got %+v", resp.Clients)
+	}
+
+	rec = httptest.NewRecorder()
+	s.router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/top?n=0", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("n=0: got %d", rec.Code)
+	}
+}
+
+func TestEvents_ResumesFromLastEventID(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
+	s.SetEventFeed(events.NewFeed())
+	s.feed.Publish(events.TypeConfigApplied, "first", nil)
+	s.feed.Publish(events.TypeBackendHealthChanged, "second", map[string]string{"backend": "localhost:3000"})
+
+	srv := httptest.NewServer(s.router())
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/v1/events", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	lines := bufio.NewScanner(resp.Body)
+	next := func() (id, event string, ev events.Event) {
+		t.Helper()
+		for lines.Scan() {
+			line := lines.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); err != nil {
+					t.Fatalf("bad event data %q: %v", line, err)
+				}
+			case line == "" && event != "":
+				return id, event, ev
+			}
+		}
+		t.Fatalf("stream ended: %v", lines.Err())
+		return
+	}
+
+	id, event, ev := next()
+	if id != "2" || event != events.TypeBackendHealthChanged || ev.Attributes["backend"] != "localhost:3000" {
+		t.Errorf("backlog: got id %q event %q %+v, want only the event after 1", id, event, ev)
+	}
+
+	s.feed.Publish(events.TypeDrainStarted, "live", nil)
+	if id, event, _ := next(); id != "3" || event != events.TypeDrainStarted {
+		t.Errorf("live: got id %q event %q", id, event)
+	}
+}
+
+func TestEvents_OutlivesWriteTimeout(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
+	s.SetEventFeed(events.NewFeed())
+	// Streams aren't bounded by the handler timeout either
+	s.config.Admin.HTTP.RequestTimeout = 50 * time.Millisecond
+
+	srv := httptest.NewUnstartedServer(s.router())
+	srv.Config.ReadTimeout = 50 * time.Millisecond
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/v1/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	time.Sleep(150 * time.Millisecond)
+	s.feed.Publish(events.TypeDrainStarted, "late", nil)
+	lines := bufio.NewScanner(resp.Body)
+	for lines.Scan() {
+		if lines.Text() == "event: "+events.TypeDrainStarted {
+			return
+		}
+	}
+	t.Fatalf("stream ended before the event: %v", lines.Err())
+}
+
+func TestRouter_LimitsBodySize(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
+	s.config.Admin.HTTP.MaxBodyBytes = 64
+	router := s.router()
+
+	rec := httptest.NewRecorder()
+	big := `{"address":"localhost:3002","weight":75,"padding":"` + strings.Repeat("x", 64) + `"}`
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/backends", strings.NewReader(big)))
+	var env ErrorResponse
+	json.NewDecoder(rec.Body).Decode(&env)
+	if rec.Code != http.StatusRequestEntityTooLarge || env.Error.Code != ErrCodeBodyTooLarge {
+		t.Fatalf("oversized body: got %d %q, want 413 %q", rec.Code, env.Error.Code, ErrCodeBodyTooLarge)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/backends", strings.NewReader(`{"address":"localhost:3002"}`)))
+	if rec.Code != http.StatusCreated {
+		t.Errorf("small body: got %d, want 201", rec.Code)
+	}
+}
+
+func TestEvents_NoFeed(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
+	rec := httptest.NewRecorder()
+	s.handleEvents(rec, httptest.NewRequest(http.MethodGet, "/events", nil))
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("got %d, want 501", rec.Code)
+	}
+}
+
+type mockConnections struct {
+	mockGRPC
+	conns       []grpc.Connection
+	gotFilter   grpc.ConnectionFilter
+	closed      []uint64
+	unsupported bool
+}
+
+func (m *mockConnections) ListConnections(_ context.Context, filter grpc.ConnectionFilter) ([]grpc.Connection, error) {
+	m.gotFilter = filter
+	return m.conns, nil
+}
+
+func (m *mockConnections) CloseConnection(_ context.Context, id uint64) (*grpc.Connection, error) {
+	if m.unsupported {
+		return nil, status.Error(codes.Unimplemented, "not supported")
+	}
+	for _, c := range m.conns {
+		if c.ID == id {
+			m.closed = append(m.closed, id)
+			return &c, nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "no active connection %d", id)
+}
+
+func TestConnections_ListAndClose(t *testing.T) {
+	g := &mockConnections{conns: []grpc.Connection{
+		{ID: 3, Client: "10.0.0.7:41000", Backend: "localhost:3000", Age: 2500 * time.Millisecond},
+	}}
+	s := testServer(g, &mockHealth{state: map[string]bool{}}, "")
+	h := s.router()
+
+	call := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	rec := call(http.MethodGet, "/api/v1/connections?backend=localhost:3000&client_ip=10.0.0.7")
+	var list ConnectionsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("list: got %d (%v)", rec.Code, err)
+	}
+	if list.Count != 1 || list.Connections[0] != (ConnectionEntry{ID: 3, Client: "10.0.0.7:41000", Backend: "localhost:3000", AgeMs: 2500}) {
+		t.Errorf("list: got %+v", list)
+	}
+	if g.gotFilter.Backend != "localhost:3000" || g.gotFilter.ClientIP.String() != "10.0.0.7" {
+		t.Errorf("filter: got %+v", g.gotFilter)
+	}
+	if rec := call(http.MethodGet, "/api/v1/connections?client_ip=nope"); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad client_ip: got %d, want 400", rec.Code)
+	}
+
+	rec = call(http.MethodDelete, "/api/v1/connections/3")
+	var closed CloseConnectionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&closed); err != nil || rec.Code != http.StatusOK || closed.Connection.Client != "10.0.0.7:41000" {
+		t.Errorf("close: got %d %+v (%v)", rec.Code, closed, err)
+	}
+	if len(g.closed) != 1 || g.closed[0] != 3 {
+		t.Errorf("closed: got %v", g.closed)
+	}
+
+	expectCode := func(target string, wantStatus int, wantCode string) {
+		t.Helper()
+		rec := call(http.MethodDelete, target)
+		var env ErrorResponse
+		json.NewDecoder(rec.Body).Decode(&env)
+		if rec.Code != wantStatus || env.Error.Code != wantCode {
+			t.Errorf("DELETE %s: got %d %q, want %d %q", target, rec.Code, env.Error.Code, wantStatus, wantCode)
+		}
+	}
+	expectCode("/api/v1/connections/4", http.StatusNotFound, ErrCodeConnectionNotFound)
+	expectCode("/api/v1/connections/abc", http.StatusBadRequest, ErrCodeInvalidRequest)
+	g.unsupported = true
+	expectCode("/api/v1/connections/3", http.StatusNotImplemented, ErrCodeNotSupported)
+
+	// xDS mode has no data plane connection table
+	s.grpcClient = &mockGRPC{}
+	if rec := call(http.MethodGet, "/api/v1/connections"); rec.Code != http.StatusNotImplemented {
+		t.Errorf("xDS mode: got %d, want 501", rec.Code)
+	}
+}
+
+type mockLeadership struct {
+	leader  string
+	leading bool
+}
+
+func (m *mockLeadership) Identity() string       { return "cp-1" }
+func (m *mockLeadership) Leader() (string, bool) { return m.leader, m.leading }
+
+func TestLeaderOnly(t *testing.T) {
+	g := &mockConnections{conns: []grpc.Connection{{ID: 3, Client: "10.0.0.7:41000", Backend: "localhost:3000"}}}
+	s := testServer(g, &mockHealth{state: map[string]bool{}}, "")
+	s.configPath = writeTempConfig(t)
+	ha := &mockLeadership{leader: "cp-2"}
+	s.SetLeadership(ha)
+	h := s.router()
+
+	call := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+	refused := func(what string, wantLeader string) {
+		t.Helper()
+		rec := call(http.MethodDelete, "/api/v1/connections/3")
+		var env ErrorResponse
+		json.NewDecoder(rec.Body).Decode(&env)
+		if rec.Code != http.StatusServiceUnavailable || env.Error.Code != ErrCodeNotLeader || rec.Header().Get(leaderHeader) != wantLeader {
+			t.Errorf("%s: got %d %q, leader %q", what, rec.Code, env.Error.Code, rec.Header().Get(leaderHeader))
+		}
+	}
+
+	// A follower serves reads and refuses changes, pointing at the leader
+	refused("follower", "cp-2")
+	if rec := call(http.MethodGet, "/api/v1/connections"); rec.Code != http.StatusOK {
+		t.Errorf("follower read: got %d", rec.Code)
+	}
+	if rec := call(http.MethodPut, "/api/v1/loglevel"); rec.Code == http.StatusServiceUnavailable {
+		t.Error("follower refused its own log level")
+	}
+	var status StatusResponse
+	json.NewDecoder(call(http.MethodGet, "/api/v1/status").Body).Decode(&status)
+	if status.HA == nil || *status.HA != (HAStatus{Identity: "cp-1", Leader: "cp-2"}) {
+		t.Errorf("status: got %+v", status.HA)
+	}
+
+	// Elected but yet to take over
+	ha.leader, ha.leading = "cp-1", true
+	refused("taking over", "")
+
+	if err := s.TakeOver(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.config.Proxy.Backends) != 0 {
+		t.Errorf("took over with %d backends, not the file's", len(s.config.Proxy.Backends))
+	}
+	if rec := call(http.MethodDelete, "/api/v1/connections/3"); rec.Code != http.StatusOK || len(g.closed) != 1 {
+		t.Errorf("leader: got %d", rec.Code)
+	}
+}
+
+func TestHandleReadyz_Leader(t *testing.T) {
+	s := testServer(&mockGRPC{}, &mockHealth{state: map[string]bool{}}, "")
+	s.config.Admin.Readiness.Checks = []string{config.ReadyCheckLeader}
+	probe := func() (int, ReadinessResponse) {
+		rec := httptest.NewRecorder()
+		s.router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var resp ReadinessResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return rec.Code, resp
+	}
+
+	// Without leader election every replica leads
+	if code, _ := probe(); code != http.StatusOK {
+		t.Errorf("no HA: got %d", code)
+	}
+	ha := &mockLeadership{leader: "cp-2"}
+	s.SetLeadership(ha)
+	if code, resp := probe(); code != http.StatusServiceUnavailable || !strings.Contains(resp.Checks[0].Message, "cp-2") {
+		t.Errorf("follower: got %d %+v", code, resp)
+	}
+	ha.leader, ha.leading = "cp-1", true
+	if code, _ := probe(); code != http.StatusOK {
+		t.Errorf("leader: got %d", code)
+	}
+}
+
+func TestPersistState(t *testing.T) {
+	g := &mockRateLimit{}
+	s := testServer(g, &mockHealth{state: map[string]bool{}}, "")
+	s.applied = make(chan struct{}, 1)
+	status := func() *AppliedConfig {
+		rec := httptest.NewRecorder()
+		s.handleStatus(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+		var resp StatusResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return resp.Applied
+	}
+	if status() != nil {
+		t.Error("applied without a store")
+	}
+	s.SetStore(store.NewMemory())
+	s.SetLeadership(&mockLeadership{leader: "cp-1", leading: true})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.PersistState(ctx)
+
+	waitApplied := func(version string) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			if a := status(); a != nil && a.Version == version {
+				if a.Replica != "cp-1" {
+					t.Errorf("replica: got %q", a.Replica)
+				}
+				return
+			}
+		}
+		t.Fatalf("applied: got %+v, want version %s", status(), version)
+	}
+	waitApplied(s.config.Version())
+
+	rec := httptest.NewRecorder()
+	s.handlePutRateLimit(rec, httptest.NewRequest(http.MethodPut, "/rate-limit", strings.NewReader(`{"requests_per_second":50}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rate limit: got %d", rec.Code)
+	}
+	waitApplied(s.config.Version())
+}
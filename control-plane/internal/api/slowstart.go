@@ -0,0 +1,89 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+)
+
+// SetSlowStart has c ramp up the backends config changes add, and serves
+// its ramps at /slow-start.
+func (s *Server) SetSlowStart(c slowStarter) {
+	s.slowStart = c
+}
+
+// RampBackends pushes the running TCP backends with the weights weigh
+// gives them, for the slow start controller's steps. Unlike a change
+// through the backend API it isn't published as an event or persisted:
+// the config file keeps the weights the backends are ramped up to.
+func (s *Server) RampBackends(weigh func([]config.Backend) []config.Backend) error {
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	s.mu.RLock()
+	current := s.config.Proxy.Backends
+	s.mu.RUnlock()
+
+	updated := weigh(slices.Clone(current))
+	if slices.Equal(updated, current) {
+		return nil
+	}
+	if err := s.grpcClient.ReloadBackendsWithHealth(updated, s.healthChecker.GetHealthState()); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.config.Proxy.Backends = updated
+	s.appliedAt = time.Now()
+	s.appliedChanged()
+	cfg := s.config
+	s.mu.Unlock()
+	s.healthChecker.Reload(cfg)
+	return nil
+}
+
+// beginSlowStart gives next, the backends about to replace previous, the
+// weights slow start has them start or stay at; commit is called once the
+// data plane has accepted them. The caller holds applyMu.
+func (s *Server) beginSlowStart(previous, next []config.Backend, cfg config.SlowStartConfig) ([]config.Backend, func()) {
+	if s.slowStart == nil {
+		return next, func() {}
+	}
+	return s.slowStart.Begin(previous, next, cfg)
+}
+
+// slowStartTargets returns backends at the weights they're being ramped
+// up to.
+func (s *Server) slowStartTargets(backends []config.Backend) []config.Backend {
+	if s.slowStart == nil {
+		return backends
+	}
+	return s.slowStart.Targets(backends)
+}
+
+func (s *Server) handleGetSlowStart(w http.ResponseWriter, r *http.Request) {
+	if s.slowStart == nil {
+		writeError(w, r, http.StatusNotImplemented, ErrCodeNotSupported, "Slow start is not supported in this mode")
+		return
+	}
+	s.mu.RLock()
+	cfg := s.config.Proxy.LoadBalancing.SlowStart
+	s.mu.RUnlock()
+
+	resp := SlowStartResponse{Enabled: cfg.Enabled(), Backends: []SlowStartBackend{}}
+	if cfg.Enabled() {
+		resp.Duration, resp.InitialPercent, resp.Interval = cfg.Duration.String(), cfg.InitialPercent, cfg.Interval.String()
+	}
+	for _, ramp := range s.slowStart.Ramps() {
+		resp.Backends = append(resp.Backends, SlowStartBackend{
+			Address:      ramp.Address,
+			Weight:       ramp.Weight,
+			TargetWeight: ramp.Target,
+			Started:      ramp.Started,
+			Ends:         ramp.Ends,
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
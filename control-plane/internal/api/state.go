@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+
+	"github.com/lazzerex/aegis/control-plane/internal/store"
+	"go.uber.org/zap"
+)
+
+// appliedKey is where the config last applied is kept in the state store.
+const appliedKey = "config/applied"
+
+// SetStore keeps the version of the config last applied in st, for
+// /status to report on every replica sharing it.
+func (s *Server) SetStore(st store.Store) {
+	s.store = st
+}
+
+// PersistState writes the version of the config last applied to the
+// state store, then again whenever a change is applied, until ctx is
+// done. The leader runs it.
+func (s *Server) PersistState(ctx context.Context) {
+	if s.store == nil {
+		return
+	}
+	for {
+		s.saveApplied(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.applied:
+		}
+	}
+}
+
+// appliedChanged wakes PersistState, if it's waiting.
+func (s *Server) appliedChanged() {
+	select {
+	case s.applied <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Server) saveApplied(ctx context.Context) {
+	s.mu.RLock()
+	applied := AppliedConfig{Version: s.config.Version(), AppliedAt: s.appliedAt.UTC()}
+	s.mu.RUnlock()
+	if s.leader != nil {
+		applied.Replica = s.leader.Identity()
+	}
+	if err := store.PutJSON(ctx, s.store, appliedKey, applied); err != nil && ctx.Err() == nil {
+		s.logger.Warn("Failed to save the applied config version to the state store", zap.String("version", applied.Version), zap.Error(err))
+	}
+}
+
+// storedApplied is /status's applied, nil without a state store or
+// anything in it.
+func (s *Server) storedApplied(ctx context.Context) *AppliedConfig {
+	if s.store == nil {
+		return nil
+	}
+	var applied AppliedConfig
+	ok, err := store.GetJSON(ctx, s.store, appliedKey, &applied)
+	if err != nil {
+		s.logger.Warn("Failed to read the applied config version from the state store", zap.Error(err))
+	}
+	if !ok {
+		return nil
+	}
+	return &applied
+}
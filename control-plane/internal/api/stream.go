@@ -0,0 +1,276 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/events"
+	"github.com/lazzerex/aegis/control-plane/internal/metrics"
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+	"go.uber.org/zap"
+)
+
+// streamHeartbeat is how often an idle event stream gets a comment line, so
+// proxies between the API and the client don't time the connection out.
+const streamHeartbeat = 15 * time.Second
+
+// sseStream writes a server-sent events response.
+type sseStream struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+// newSSEStream sends the event stream headers. The caller owns the
+// response from then on and ends it by returning. Streams outlive the
+// admin server's read and write timeouts, so their deadlines are cleared.
+func newSSEStream(w http.ResponseWriter) (*sseStream, error) {
+	rc := http.NewResponseController(w)
+	// Not supported by test recorders; a real connection always is
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	// Stop nginx and similar from buffering the stream
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return nil, err
+	}
+	return &sseStream{w: w, rc: rc}, nil
+}
+
+// send writes one event; id is omitted when empty. data must not contain
+// newlines, which JSON without indentation never does.
+func (s *sseStream) send(event, id string, data []byte) error {
+	if id != "" {
+		if _, err := fmt.Fprintf(s.w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+func (s *sseStream) heartbeat() error {
+	if _, err := fmt.Fprint(s.w, ": keepalive\n\n"); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// handleMetrics returns the latest metrics snapshot from the data plane as
+// JSON, the same document as a GET /metrics/stream event, for tools that
+// want one structured read rather than Prometheus text.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	src, ok := s.circuitStates.(metricsSource)
+	if !ok {
+		writeError(w, r, http.StatusNotImplemented, ErrCodeNotSupported, "Metrics snapshots are not available in this mode")
+		return
+	}
+	data := src.Latest()
+	if data == nil {
+		writeError(w, r, http.StatusServiceUnavailable, ErrCodeMetricsUnavailable, "The data plane hasn't reported metrics yet")
+		return
+	}
+	writeJSON(w, http.StatusOK, metricsSnapshot(data))
+}
+
+// maxTopQuery caps GET /top?n=.
+const maxTopQuery = 100
+
+// handleTop ranks backends and clients by traffic and error rate over the
+// metrics.top_window, for finding what's behind an incident at a glance.
+func (s *Server) handleTop(w http.ResponseWriter, r *http.Request) {
+	src, ok := s.circuitStates.(topTalkersSource)
+	if !ok {
+		writeError(w, r, http.StatusNotImplemented, ErrCodeNotSupported, "Top talkers are not available in this mode")
+		return
+	}
+	n := 10
+	if raw := r.URL.Query().Get("n"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v < 1 || v > maxTopQuery {
+			writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "n must be between 1 and "+strconv.Itoa(maxTopQuery))
+			return
+		}
+		n = v
+	}
+
+	top := src.Top(n)
+	writeJSON(w, http.StatusOK, TopResponse{
+		Window: top.Window.String(),
+		Backends: TopRankings{
+			ByTraffic:   topEntries(top.BackendsByTraffic),
+			ByErrorRate: topEntries(top.BackendsByErrorRate),
+		},
+		Clients: TopRankings{
+			ByTraffic:   topEntries(top.ClientsByTraffic),
+			ByErrorRate: topEntries(top.ClientsByErrorRate),
+		},
+		UntrackedClients: top.UntrackedClients,
+	})
+}
+
+func topEntries(entries []metrics.TopEntry) []TopEntry {
+	out := make([]TopEntry, len(entries))
+	for i, e := range entries {
+		out[i] = TopEntry{Key: e.Key, Requests: e.Requests, Errors: e.Errors, ErrorRate: e.ErrorRate, Bytes: e.Bytes}
+	}
+	return out
+}
+
+// handleMetricsStream pushes every metrics snapshot the data plane reports
+// as a "metrics" server-sent event, starting with the latest one, so
+// dashboards can follow traffic without scraping Prometheus.
+func (s *Server) handleMetricsStream(w http.ResponseWriter, r *http.Request) {
+	sub, ok := s.circuitStates.(metricsSubscriber)
+	if !ok {
+		writeError(w, r, http.StatusNotImplemented, ErrCodeNotSupported, "Metrics streaming is not available in this mode")
+		return
+	}
+
+	snapshots, unsubscribe := sub.Subscribe()
+	defer unsubscribe()
+
+	stream, err := newSSEStream(w)
+	if err != nil {
+		s.logger.Debug("Metrics stream not started", zap.Error(err))
+		return
+	}
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case data := <-snapshots:
+			payload, err := json.Marshal(metricsSnapshot(data))
+			if err != nil {
+				return
+			}
+			if err := stream.send("metrics", "", payload); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := stream.heartbeat(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func metricsSnapshot(data *pb.MetricsData) MetricsSnapshot {
+	snap := MetricsSnapshot{
+		Timestamp:         time.UnixMilli(data.Timestamp).UTC(),
+		ActiveConnections: data.ActiveConnections,
+		TotalConnections:  data.TotalConnections,
+		BytesSent:         data.BytesSent,
+		BytesReceived:     data.BytesReceived,
+		AvgLatencyMs:      data.AvgLatencyMs,
+		P99LatencyMs:      data.P99LatencyMs,
+		Backends:          make([]BackendMetricsSnapshot, len(data.BackendMetrics)),
+	}
+	for i, b := range data.BackendMetrics {
+		snap.Backends[i] = BackendMetricsSnapshot{
+			Address:           b.Address,
+			ActiveConnections: b.ActiveConnections,
+			TotalRequests:     b.TotalRequests,
+			FailedRequests:    b.FailedRequests,
+			AvgLatencyMs:      b.AvgLatencyMs,
+			CircuitState:      normalizeCircuitState(b.CircuitState),
+		}
+	}
+	return snap
+}
+
+// handleEvents streams control plane events (health transitions, config
+// applies, drains, data plane connectivity) as server-sent events named
+// after their type, each with its sequence ID. A client reconnecting with
+// Last-Event-ID (or ?last_event_id=) first gets the events it missed; if
+// some are no longer in history, a "gap" event comes first. ?types= takes
+// a comma-separated list of event types to receive.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if s.feed == nil {
+		writeError(w, r, http.StatusNotImplemented, ErrCodeNotSupported, "The event feed is not enabled")
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	var after uint64
+	if lastID != "" {
+		var err error
+		if after, err = strconv.ParseUint(lastID, 10, 64); err != nil {
+			writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid Last-Event-ID: "+lastID)
+			return
+		}
+	}
+	var types map[string]bool
+	if raw := r.URL.Query().Get("types"); raw != "" {
+		types = make(map[string]bool)
+		for _, t := range strings.Split(raw, ",") {
+			types[strings.TrimSpace(t)] = true
+		}
+	}
+
+	sub := s.feed.Subscribe(after)
+	defer sub.Close()
+
+	stream, err := newSSEStream(w)
+	if err != nil {
+		s.logger.Debug("Event stream not started", zap.Error(err))
+		return
+	}
+	send := func(ev events.Event) error {
+		if types != nil && !types[ev.Type] {
+			return nil
+		}
+		payload, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		return stream.send(ev.Type, strconv.FormatUint(ev.ID, 10), payload)
+	}
+
+	if sub.Missed {
+		if err := stream.send("gap", "", []byte(`{"message":"some events since the given ID are no longer available"}`)); err != nil {
+			return
+		}
+	}
+	for _, ev := range sub.Backlog {
+		if err := send(ev); err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-sub.Events:
+			if !ok {
+				// Fell behind; the client resumes from its last ID
+				return
+			}
+			if err := send(ev); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := stream.heartbeat(); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// withTimeout answers with 503 if next hasn't finished within timeout,
+// and cancels its context so gRPC calls and other context-aware work stop.
+// Like http.TimeoutHandler, the response is buffered until next returns, so
+// it's not for streams. next keeps running after the deadline with
+// nowhere to write, so a slow change may still be applied; the audit log
+// records how it actually ended.
+func withTimeout(timeout time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		tw := &timeoutWriter{h: make(http.Header)}
+		done := make(chan struct{})
+		panicked := make(chan any, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked <- p
+				}
+			}()
+			next.ServeHTTP(tw, r.WithContext(ctx))
+			close(done)
+		}()
+
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			for k, v := range tw.h {
+				w.Header()[k] = v
+			}
+			if tw.code == 0 {
+				tw.code = http.StatusOK
+			}
+			w.WriteHeader(tw.code)
+			w.Write(tw.buf.Bytes())
+		case <-ctx.Done():
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			tw.timedOut = true
+			if ctx.Err() == context.DeadlineExceeded {
+				writeError(w, r, http.StatusServiceUnavailable, ErrCodeTimeout,
+					fmt.Sprintf("Request did not complete within %s; it may still take effect", timeout))
+			}
+		}
+	})
+}
+
+// timeoutWriter buffers a response for withTimeout and drops writes after
+// the deadline.
+type timeoutWriter struct {
+	mu       sync.Mutex
+	h        http.Header
+	buf      bytes.Buffer
+	code     int
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.h }
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	return tw.buf.Write(p)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.code != 0 {
+		return
+	}
+	tw.code = code
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/events"
+	"go.uber.org/zap"
+)
+
+func (s *Server) handleGetTrafficSplit(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	resp := trafficSplitResponse(s.config.Proxy)
+	resp.Version = s.config.Version()
+	s.mu.RUnlock()
+	w.Header().Set("ETag", `"`+resp.Version+`"`)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handlePutTrafficSplit sets the percentage of weighted traffic each
+// backend pool gets, for canaries driven by CD tooling: step the canary up
+// with one PUT per stage, conditional on If-Match like the other config
+// changes. An empty split goes back to plain backend weights. The split is
+// pushed as a new config version; like backend changes it lives in memory
+// until the next reload.
+func (s *Server) handlePutTrafficSplit(w http.ResponseWriter, r *http.Request) {
+	var req TrafficSplitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+	if len(req.Split) == 0 {
+		req.Split = nil
+	}
+
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	s.mu.RLock()
+	current := s.config
+	version := current.Version()
+	s.mu.RUnlock()
+
+	if !s.checkIfMatch(w, r, version) {
+		return
+	}
+
+	next := *current
+	next.Proxy.TrafficSplit = req.Split
+	if errs := append(config.ValidateTrafficSplit(next.Proxy), config.ValidateRouting(&next)...); len(errs) > 0 {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidConfig, strings.Join(errs, "; "))
+		return
+	}
+
+	if err := s.grpcClient.UpdateConfig(&next); err != nil {
+		s.logger.Error("Failed to push traffic split to data plane", zap.Error(err))
+		writeError(w, r, http.StatusInternalServerError, ErrCodeDataPlaneError, "Failed to update data plane: "+err.Error())
+		return
+	}
+	// A config push marks every backend healthy; put the known state back
+	if err := s.grpcClient.ReloadBackendsWithHealth(next.Proxy.Backends, s.healthChecker.GetHealthState()); err != nil {
+		s.logger.Warn("Failed to restore backend health after traffic split change", zap.Error(err))
+	}
+
+	s.mu.Lock()
+	s.config = &next
+	s.appliedAt = time.Now()
+	s.appliedChanged()
+	s.mu.Unlock()
+
+	resp := trafficSplitResponse(next.Proxy)
+	resp.Version = next.Version()
+	s.feed.Publish(events.TypeTrafficSplitChanged, "Traffic split set to "+formatSplit(req.Split),
+		map[string]string{"caller": callerName(r.Context()), "version": resp.Version})
+	s.logger.Info("Traffic split changed via API",
+		zap.String("caller", callerName(r.Context())),
+		zap.String("previous", formatSplit(current.Proxy.TrafficSplit)),
+		zap.String("split", formatSplit(req.Split)),
+		zap.String("version", resp.Version))
+	w.Header().Set("ETag", `"`+resp.Version+`"`)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// trafficSplitResponse reports the split and the backends of each pool it
+// names.
+func trafficSplitResponse(p config.ProxyConfig) TrafficSplitResponse {
+	resp := TrafficSplitResponse{Split: p.TrafficSplit, Pools: []TrafficSplitPool{}}
+	if resp.Split == nil {
+		resp.Split = map[string]int{}
+	}
+	for _, pool := range sortedKeys(p.TrafficSplit) {
+		entry := TrafficSplitPool{Pool: pool, Percent: p.TrafficSplit[pool], Backends: []string{}}
+		for _, b := range p.Backends {
+			if b.Pool == pool {
+				entry.Backends = append(entry.Backends, b.Address)
+			}
+		}
+		resp.Pools = append(resp.Pools, entry)
+	}
+	return resp
+}
+
+// formatSplit renders a split for logs and events, e.g. "canary=5,stable=95".
+func formatSplit(split map[string]int) string {
+	if len(split) == 0 {
+		return "none"
+	}
+	parts := make([]string, 0, len(split))
+	for _, pool := range sortedKeys(split) {
+		parts = append(parts, pool+"="+strconv.Itoa(split[pool]))
+	}
+	return strings.Join(parts, ",")
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,690 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/lazzerex/aegis/control-plane/internal/audit"
+	"github.com/lazzerex/aegis/control-plane/internal/grpc"
+	"github.com/lazzerex/aegis/control-plane/internal/version"
+)
+
+// Request and response bodies of the /api/v1 contract. Fields are only
+// ever added; renaming or removing one needs a new API version.
+
+// LivenessResponse is the body of GET /livez.
+type LivenessResponse struct {
+	Status string `json:"status"`
+}
+
+// ReadinessResponse is the body of GET /readyz: status is "ready" or
+// "not_ready", with one entry per configured check.
+type ReadinessResponse struct {
+	Status string           `json:"status"`
+	Checks []ReadinessCheck `json:"checks"`
+}
+
+type ReadinessCheck struct {
+	Name string `json:"name"`
+	OK   bool   `json:"ok"`
+	// Message says why a failing check failed.
+	Message string `json:"message,omitempty"`
+}
+
+type HealthResponse struct {
+	Status   string          `json:"status"`
+	Backends map[string]bool `json:"backends"`
+	// DataPlane is omitted in xDS mode.
+	DataPlane *grpc.Connectivity `json:"data_plane,omitempty"`
+}
+
+type StatusResponse struct {
+	Version string       `json:"version"`
+	Config  StatusConfig `json:"config"`
+	// DataPlane is omitted until the first config push has completed the
+	// handshake, and in xDS mode.
+	DataPlane *grpc.DataPlaneInfo `json:"data_plane,omitempty"`
+	// HA is omitted unless ha is enabled.
+	HA *HAStatus `json:"ha,omitempty"`
+	// Applied is the config the leader applied last, as the state store
+	// has it; omitted until it's been written there.
+	Applied *AppliedConfig `json:"applied,omitempty"`
+}
+
+// AppliedConfig is a version of the config applied to the data plane.
+type AppliedConfig struct {
+	Version   string    `json:"version"`
+	AppliedAt time.Time `json:"applied_at"`
+	// Replica is the HA replica that applied it, empty without ha.
+	Replica string `json:"replica,omitempty"`
+}
+
+// HAStatus says which replica of an HA control plane leads.
+type HAStatus struct {
+	// Identity is this replica's, and Leader the leader's, empty while
+	// none is known.
+	Identity string `json:"identity"`
+	Leader   string `json:"leader,omitempty"`
+	Leading  bool   `json:"leading"`
+}
+
+// VersionResponse is the body of GET /version.
+type VersionResponse struct {
+	version.Info
+	// DataPlaneVersion is omitted until the handshake has completed, and
+	// for data planes that predate it.
+	DataPlaneVersion string `json:"data_plane_version,omitempty"`
+}
+
+type StatusConfig struct {
+	Backends           int     `json:"backends"`
+	Algorithm          string  `json:"algorithm"`
+	SessionAffinity    bool    `json:"session_affinity"`
+	UDPAffinity        string  `json:"udp_affinity,omitempty"`
+	StickyCookie       string  `json:"sticky_cookie,omitempty"`
+	RateLimitRPS       int     `json:"rate_limit_rps"`
+	RateLimitBurst     int     `json:"rate_limit_burst"`
+	CBThreshold        int     `json:"cb_threshold"`
+	CBTimeoutSecs      float64 `json:"cb_timeout_secs"`
+	ConnectTimeoutSecs float64 `json:"connect_timeout_secs"`
+	IdleTimeoutSecs    float64 `json:"idle_timeout_secs"`
+	ReadTimeoutSecs    float64 `json:"read_timeout_secs"`
+}
+
+type BackendListResponse struct {
+	Backends    []BackendEntry `json:"backends"`
+	UDPBackends []BackendEntry `json:"udp_backends"`
+}
+
+type BackendEntry struct {
+	Address  string `json:"address"`
+	Weight   int    `json:"weight"`
+	Pool     string `json:"pool,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+	Backup   bool   `json:"backup,omitempty"`
+	Zone     string `json:"zone,omitempty"`
+	Region   string `json:"region,omitempty"`
+	Healthy  bool   `json:"healthy"`
+	// CircuitState and the stats below are omitted until the data plane
+	// has reported on the backend.
+	CircuitState string           `json:"circuit_state,omitempty"`
+	HealthCheck  *HealthCheckBody `json:"health_check,omitempty"`
+	*BackendStats
+}
+
+// BackendDetail is the body of GET /backends/{address}: the list entry
+// plus the backend's recent health checks.
+type BackendDetail struct {
+	BackendEntry
+	// HealthHistory is omitted until the first health check completes.
+	HealthHistory *HealthHistoryBody `json:"health_history,omitempty"`
+}
+
+type HealthHistoryBody struct {
+	LastChecked         time.Time `json:"last_checked"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	// Transitions are the most recent health changes, oldest first.
+	Transitions []HealthTransition `json:"transitions"`
+}
+
+type HealthTransition struct {
+	Time    time.Time `json:"time"`
+	Healthy bool      `json:"healthy"`
+}
+
+type HealthCheckBody struct {
+	IntervalSecs float64 `json:"interval_secs"`
+	TimeoutSecs  float64 `json:"timeout_secs"`
+	Path         string  `json:"path,omitempty"`
+	Scheme       string  `json:"scheme,omitempty"`
+}
+
+type BackendStats struct {
+	ActiveConnections int64   `json:"active_connections"`
+	TotalRequests     int64   `json:"total_requests"`
+	FailedRequests    int64   `json:"failed_requests"`
+	AvgLatencyMs      float64 `json:"avg_latency_ms"`
+}
+
+type AddBackendRequest struct {
+	Address string `json:"address"`
+	// Weight defaults to 100 when zero or negative.
+	Weight int `json:"weight"`
+	// Pool names the backend's pool for the traffic split.
+	Pool string `json:"pool,omitempty"`
+	// Priority is the backend's failover tier, 0 first.
+	Priority int `json:"priority,omitempty"`
+	// Backup makes the backend a last resort behind every primary.
+	Backup bool `json:"backup,omitempty"`
+	// Zone and Region place the backend for locality-aware balancing.
+	Zone   string `json:"zone,omitempty"`
+	Region string `json:"region,omitempty"`
+	// HealthCheck defaults to a 5s interval and 2s timeout; zero fields
+	// inside it take the same defaults.
+	HealthCheck *HealthCheckBody `json:"health_check,omitempty"`
+}
+
+// UpdateBackendRequest is the body of PUT /backends/{address}, which
+// creates the backend if it doesn't exist. Omitted fields keep their
+// current value, or the POST defaults for a new backend.
+type UpdateBackendRequest struct {
+	Weight int `json:"weight"`
+	// Pool keeps the current pool when empty.
+	Pool        string           `json:"pool,omitempty"`
+	Priority    *int             `json:"priority,omitempty"`
+	Backup      *bool            `json:"backup,omitempty"`
+	Zone        *string          `json:"zone,omitempty"`
+	Region      *string          `json:"region,omitempty"`
+	HealthCheck *HealthCheckBody `json:"health_check,omitempty"`
+}
+
+// PatchBackendRequest is the body of PATCH /backends/{address}. Only the
+// fields present are changed; unlike PUT, a weight of 0 is applied, which
+// takes the backend out of weighted rotation without removing it.
+type PatchBackendRequest struct {
+	Weight *int `json:"weight"`
+	// Pool moves the backend to another pool; "" takes it out of any.
+	Pool        *string          `json:"pool,omitempty"`
+	Priority    *int             `json:"priority,omitempty"`
+	Backup      *bool            `json:"backup,omitempty"`
+	Zone        *string          `json:"zone,omitempty"`
+	Region      *string          `json:"region,omitempty"`
+	HealthCheck *HealthCheckBody `json:"health_check,omitempty"`
+}
+
+type BackendChangeResponse struct {
+	Status  string `json:"status"`
+	Address string `json:"address"`
+	Weight  int    `json:"weight,omitempty"`
+}
+
+// ConfigResponse is the JSON form of GET /config. Config uses the same
+// keys as the YAML file; credentials are redacted.
+type ConfigResponse struct {
+	Version   string                 `json:"version"`
+	AppliedAt time.Time              `json:"applied_at"`
+	Config    map[string]interface{} `json:"config"`
+}
+
+// ConfigAppliedResponse is the reply to PUT /config.
+type ConfigAppliedResponse struct {
+	Status    string    `json:"status"`
+	Version   string    `json:"version"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+// ReloadRequest is the optional body of POST /reload.
+type ReloadRequest struct {
+	// Path is resolved against the current config file's directory and
+	// must stay inside it.
+	Path string `json:"path"`
+}
+
+// OperationResponse reports the outcome of reload and dry-run.
+type OperationResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	// Path is the config file a reload read.
+	Path string `json:"path,omitempty"`
+}
+
+// OperationStatus describes a background operation: the reply to
+// POST /drain and GET /operations/{id}.
+type OperationStatus struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	State      string     `json:"state"`
+	StartedAt  time.Time  `json:"started_at"`
+	Deadline   time.Time  `json:"deadline"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Progress is sampled when polled while running; for a drain it holds
+	// active_connections across all backends.
+	Progress map[string]int64 `json:"progress,omitempty"`
+	Message  string           `json:"message,omitempty"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// CommandRequest is the optional body of POST /dataplane/commands/{name}:
+// a flat object of string arguments.
+type CommandRequest map[string]string
+
+type CommandResponse struct {
+	Command string          `json:"command"`
+	Message string          `json:"message"`
+	Output  json.RawMessage `json:"output,omitempty"`
+}
+
+type TrafficResponse struct {
+	Paused  bool   `json:"paused"`
+	Message string `json:"message"`
+}
+
+// MetricsSnapshot is the body of GET /metrics and one "metrics" event on
+// GET /metrics/stream. Counts
+// are cumulative since the data plane started.
+type MetricsSnapshot struct {
+	Timestamp         time.Time                `json:"timestamp"`
+	ActiveConnections int64                    `json:"active_connections"`
+	TotalConnections  int64                    `json:"total_connections"`
+	BytesSent         int64                    `json:"bytes_sent"`
+	BytesReceived     int64                    `json:"bytes_received"`
+	AvgLatencyMs      float64                  `json:"avg_latency_ms"`
+	P99LatencyMs      float64                  `json:"p99_latency_ms"`
+	Backends          []BackendMetricsSnapshot `json:"backends"`
+}
+
+type BackendMetricsSnapshot struct {
+	Address           string  `json:"address"`
+	ActiveConnections int64   `json:"active_connections"`
+	TotalRequests     int64   `json:"total_requests"`
+	FailedRequests    int64   `json:"failed_requests"`
+	AvgLatencyMs      float64 `json:"avg_latency_ms"`
+	CircuitState      string  `json:"circuit_state"`
+}
+
+// RateLimitRequest is the body of PUT /rate-limit; omitted fields keep
+// their current value.
+type RateLimitRequest struct {
+	RequestsPerSecond *int `json:"requests_per_second"`
+	Burst             *int `json:"burst"`
+}
+
+type RateLimitResponse struct {
+	RequestsPerSecond int `json:"requests_per_second"`
+	Burst             int `json:"burst"`
+}
+
+// TrafficSplitRequest is the body of PUT /traffic-split: percentages of
+// weighted traffic by backend pool, totalling 100.
+type TrafficSplitRequest struct {
+	Split map[string]int `json:"split"`
+}
+
+type TrafficSplitResponse struct {
+	Split   map[string]int     `json:"split"`
+	Pools   []TrafficSplitPool `json:"pools"`
+	Version string             `json:"version"`
+}
+
+type TrafficSplitPool struct {
+	Pool     string   `json:"pool"`
+	Percent  int      `json:"percent"`
+	Backends []string `json:"backends"`
+}
+
+// MirrorRequest is the body of PUT /mirror; an empty pool turns mirroring
+// off.
+type MirrorRequest struct {
+	Pool    string `json:"pool"`
+	Percent int    `json:"percent"`
+}
+
+type MirrorResponse struct {
+	Pool     string   `json:"pool"`
+	Percent  int      `json:"percent"`
+	Backends []string `json:"backends"`
+	Version  string   `json:"version"`
+}
+
+// HeaderRulesRequest is the body of PUT /header-rules: the whole rule
+// list, replacing the running one. An empty list removes every rule.
+type HeaderRulesRequest struct {
+	Rules []HeaderRule `json:"rules"`
+}
+
+type HeaderRule struct {
+	Name  string        `json:"name"`
+	Match []HeaderMatch `json:"match"`
+	Pool  string        `json:"pool"`
+	// Backends are the pool's; ignored in requests.
+	Backends []string `json:"backends,omitempty"`
+}
+
+type HeaderMatch struct {
+	Header string `json:"header"`
+	Exact  string `json:"exact,omitempty"`
+	Regex  string `json:"regex,omitempty"`
+}
+
+type HeaderRulesResponse struct {
+	Rules   []HeaderRule `json:"rules"`
+	Version string       `json:"version"`
+}
+
+// DarkLaunchesRequest is the body of PUT /dark-launches: the whole rule
+// list, replacing the running one. An empty list removes every rule.
+type DarkLaunchesRequest struct {
+	Rules []DarkLaunch `json:"rules"`
+}
+
+type DarkLaunch struct {
+	Name    string       `json:"name"`
+	Pool    string       `json:"pool"`
+	Percent int          `json:"percent"`
+	Windows []TimeWindow `json:"windows"`
+	// Active, NextChange and Backends are ignored in requests. NextChange
+	// is unset for a rule without windows.
+	Active     bool       `json:"active"`
+	NextChange *time.Time `json:"next_change,omitempty"`
+	Backends   []string   `json:"backends,omitempty"`
+}
+
+type TimeWindow struct {
+	Days  []string `json:"days,omitempty"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+}
+
+type DarkLaunchesResponse struct {
+	// Timezone is the zone the windows are read in, scheduler.timezone.
+	Timezone string       `json:"timezone"`
+	Rules    []DarkLaunch `json:"rules"`
+	Version  string       `json:"version"`
+}
+
+// PoolSwitchRequest is the body of POST /pools/switch. To defaults to the
+// standby pool.
+type PoolSwitchRequest struct {
+	To string `json:"to,omitempty"`
+}
+
+type PoolSwitchResponse struct {
+	Active   string `json:"active"`
+	Previous string `json:"previous"`
+	// Healthy of the active pool's Total backends passed their last health
+	// check when traffic switched to it.
+	Healthy int    `json:"healthy"`
+	Total   int    `json:"total"`
+	Version string `json:"version"`
+}
+
+// CanaryRequest is the body of POST /canary. Unset fields take the
+// defaults: a target of 100%, steps of 10% every 300 seconds, a 1% error
+// rate, no latency check and 100 requests a step.
+type CanaryRequest struct {
+	Pool             string  `json:"pool"`
+	Baseline         string  `json:"baseline"`
+	TargetPercent    int     `json:"target_percent,omitempty"`
+	StepPercent      int     `json:"step_percent,omitempty"`
+	StepIntervalSecs float64 `json:"step_interval_secs,omitempty"`
+	// MaxErrorRate is a fraction, 0.01 being 1%.
+	MaxErrorRate    float64 `json:"max_error_rate,omitempty"`
+	MaxP99LatencyMs float64 `json:"max_p99_latency_ms,omitempty"`
+	MinRequests     int64   `json:"min_requests,omitempty"`
+}
+
+type CanaryResponse struct {
+	// State is idle before the first rollout, then progressing,
+	// succeeded, rolled_back or abandoned.
+	State           string  `json:"state"`
+	Pool            string  `json:"pool,omitempty"`
+	Baseline        string  `json:"baseline,omitempty"`
+	Percent         int     `json:"percent"`
+	TargetPercent   int     `json:"target_percent,omitempty"`
+	StepPercent     int     `json:"step_percent,omitempty"`
+	StepInterval    string  `json:"step_interval,omitempty"`
+	MaxErrorRate    float64 `json:"max_error_rate,omitempty"`
+	MaxP99LatencyMs float64 `json:"max_p99_latency_ms,omitempty"`
+	MinRequests     int64   `json:"min_requests,omitempty"`
+	// Current is the step under way while progressing.
+	Current  *CanaryStep  `json:"current,omitempty"`
+	Steps    []CanaryStep `json:"steps"`
+	Started  *time.Time   `json:"started,omitempty"`
+	Finished *time.Time   `json:"finished,omitempty"`
+	// Reason says why the rollout was rolled back or abandoned.
+	Reason    string `json:"reason,omitempty"`
+	Version   string `json:"version,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// SlowStartResponse is proxy.load_balancing.slow_start and the backends
+// being ramped up under it.
+type SlowStartResponse struct {
+	Enabled        bool               `json:"enabled"`
+	Duration       string             `json:"duration,omitempty"`
+	InitialPercent int                `json:"initial_percent,omitempty"`
+	Interval       string             `json:"interval,omitempty"`
+	Backends       []SlowStartBackend `json:"backends"`
+}
+
+// DiscoveryResponse is proxy.discovery and what it found.
+type DiscoveryResponse struct {
+	Enabled  bool   `json:"enabled"`
+	Provider string `json:"provider,omitempty"`
+	Target   string `json:"target,omitempty"`
+	Merge    string `json:"merge,omitempty"`
+	// Backends is how many discovered backends the running config has.
+	Backends int `json:"backends"`
+	// Held is the set held back for shrinking the pool too far, waiting
+	// for POST /discovery/confirm.
+	Held *DiscoveryShrink `json:"held,omitempty"`
+}
+
+// CertificatesResponse is where the certificates of proxy.tls stand.
+type CertificatesResponse struct {
+	Enabled      bool                `json:"enabled"`
+	Certificates []CertificateStatus `json:"certificates"`
+}
+
+// Certificate sources.
+const (
+	CertificateSourceFile = "file"
+	CertificateSourceACME = "acme"
+)
+
+// CertificateStatus is one certificate, read from the operator's files or
+// obtained through ACME, in the order the data plane gets them. NotAfter
+// is unset until one has been read or obtained; Error is set while it
+// can't be, and RetryAt when ACME tries again.
+type CertificateStatus struct {
+	Name      string     `json:"name"`
+	Source    string     `json:"source"`
+	Domains   []string   `json:"domains"`
+	CertFile  string     `json:"cert_file,omitempty"`
+	NotAfter  *time.Time `json:"not_after,omitempty"`
+	ExpiresIn string     `json:"expires_in,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	RetryAt   *time.Time `json:"retry_at,omitempty"`
+}
+
+// DiscoveryShrink is a discovered set held back for shrinking the pool
+// beyond proxy.discovery.max_removal_percent or min_backends.
+type DiscoveryShrink struct {
+	Backends int       `json:"backends"`
+	Previous int       `json:"previous"`
+	Removed  []string  `json:"removed"`
+	Since    time.Time `json:"since"`
+}
+
+// SlowStartBackend is a backend on its way up to its configured weight.
+type SlowStartBackend struct {
+	Address      string    `json:"address"`
+	Weight       int       `json:"weight"`
+	TargetWeight int       `json:"target_weight"`
+	Started      time.Time `json:"started"`
+	Ends         time.Time `json:"ends"`
+}
+
+// CanaryStep is the canary pool's traffic during one step of a rollout.
+type CanaryStep struct {
+	Percent   int       `json:"percent"`
+	Started   time.Time `json:"started"`
+	Requests  int64     `json:"requests"`
+	Errors    int64     `json:"errors"`
+	ErrorRate float64   `json:"error_rate"`
+	// P99LatencyMs is the p99 connect latency, absent before any connect
+	// was timed.
+	P99LatencyMs *float64 `json:"p99_latency_ms,omitempty"`
+	Passed       bool     `json:"passed"`
+}
+
+// LogLevelRequest is the body of PUT /loglevel. With RevertAfterSecs the
+// change is temporary.
+type LogLevelRequest struct {
+	Level           string  `json:"level"`
+	RevertAfterSecs float64 `json:"revert_after_secs,omitempty"`
+}
+
+type LogLevelResponse struct {
+	Level string `json:"level"`
+	// RevertAt and RevertTo are set while a temporary change is pending.
+	RevertAt *time.Time `json:"revert_at,omitempty"`
+	RevertTo string     `json:"revert_to,omitempty"`
+}
+
+type SchedulesResponse struct {
+	// Timezone is the zone the cron expressions are read in.
+	Timezone  string           `json:"timezone,omitempty"`
+	Schedules []ScheduleStatus `json:"schedules"`
+}
+
+type ScheduleStatus struct {
+	Name string `json:"name"`
+	Cron string `json:"cron"`
+	// Duration is empty for a schedule that's never reverted.
+	Duration        string     `json:"duration,omitempty"`
+	Active          bool       `json:"active"`
+	NextApplication *time.Time `json:"next_application,omitempty"`
+	LastApplied     *time.Time `json:"last_applied,omitempty"`
+	// RevertAt is set while an overlay with a duration is in effect.
+	RevertAt     *time.Time `json:"revert_at,omitempty"`
+	LastReverted *time.Time `json:"last_reverted,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+type CircuitBreakersResponse struct {
+	Breakers []CircuitBreakerEntry `json:"breakers"`
+	// Source is "data_plane" when the breakers were read from the data
+	// plane directly, or "metrics" when only the state from the last
+	// metrics report is known (older data plane, or xDS mode).
+	Source string `json:"source"`
+}
+
+type CircuitBreakerEntry struct {
+	Address string `json:"address"`
+	// State is closed, open, half_open, or unknown.
+	State          string     `json:"state"`
+	FailureCount   *int64     `json:"failure_count,omitempty"`
+	ErrorThreshold *int64     `json:"error_threshold,omitempty"`
+	OpenedAt       *time.Time `json:"opened_at,omitempty"`
+	LastFailureAt  *time.Time `json:"last_failure_at,omitempty"`
+	// FailedRequests is the data plane's running count of failed requests
+	// to the backend, which unlike FailureCount isn't reset on recovery.
+	FailedRequests *int64 `json:"failed_requests,omitempty"`
+}
+
+type CircuitResetResponse struct {
+	Address string `json:"address"`
+	State   string `json:"state"`
+	Message string `json:"message"`
+}
+
+// ConnectionsResponse is the body of GET /connections.
+type ConnectionsResponse struct {
+	// Connections are oldest first.
+	Connections []ConnectionEntry `json:"connections"`
+	Count       int               `json:"count"`
+}
+
+type ConnectionEntry struct {
+	ID     uint64 `json:"id"`
+	Client string `json:"client"`
+	// Backend is empty until the data plane has picked one.
+	Backend string `json:"backend"`
+	AgeMs   int64  `json:"age_ms"`
+}
+
+type CloseConnectionResponse struct {
+	Connection ConnectionEntry `json:"connection"`
+	Message    string          `json:"message"`
+}
+
+// TopResponse is the body of GET /top: the heaviest and most failing
+// backends and clients over the last Window. Clients are only ranked
+// while the access log stream is enabled.
+type TopResponse struct {
+	Window   string      `json:"window"`
+	Backends TopRankings `json:"backends"`
+	Clients  TopRankings `json:"clients"`
+	// UntrackedClients counts connections from clients beyond the number
+	// the control plane keeps, which are in neither ranking.
+	UntrackedClients int64 `json:"untracked_clients"`
+}
+
+type TopRankings struct {
+	// ByTraffic is ordered by bytes in both directions, then requests.
+	ByTraffic []TopEntry `json:"by_traffic"`
+	// ByErrorRate only holds entries with errors.
+	ByErrorRate []TopEntry `json:"by_error_rate"`
+}
+
+// TopEntry is a backend address or client IP and its traffic over the
+// window.
+type TopEntry struct {
+	Key       string  `json:"key"`
+	Requests  int64   `json:"requests"`
+	Errors    int64   `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	Bytes     int64   `json:"bytes"`
+}
+
+// AuditResponse is the body of GET /audit.
+type AuditResponse struct {
+	Entries []audit.Entry `json:"entries"`
+}
+
+// Machine-readable error codes. Clients should branch on these, not on
+// messages, which are for humans and may change.
+const (
+	ErrCodeInvalidRequest     = "invalid_request"
+	ErrCodeUnauthorized       = "unauthorized"
+	ErrCodeForbidden          = "forbidden"
+	ErrCodeBackendNotFound    = "backend_not_found"
+	ErrCodeBackendExists      = "backend_exists"
+	ErrCodeConfigLoadFailed   = "config_load_failed"
+	ErrCodeConfigRejected     = "config_rejected"
+	ErrCodeInvalidConfig      = "invalid_config"
+	ErrCodeVersionConflict    = "version_conflict"
+	ErrCodeVersionRequired    = "version_required"
+	ErrCodeDataPlaneError     = "data_plane_error"
+	ErrCodeUnknownCommand     = "unknown_command"
+	ErrCodeConflict           = "conflict"
+	ErrCodeNotSupported       = "not_supported"
+	ErrCodePersistFailed      = "persist_failed"
+	ErrCodeInternal           = "internal_error"
+	ErrCodeOperationNotFound  = "operation_not_found"
+	ErrCodeConnectionNotFound = "connection_not_found"
+	ErrCodeBodyTooLarge       = "body_too_large"
+	ErrCodeMetricsUnavailable = "metrics_unavailable"
+	ErrCodeTimeout            = "timeout"
+	ErrCodePoolUnhealthy      = "pool_unhealthy"
+	ErrCodeNotLeader          = "not_leader"
+)
+
+// ErrorResponse is the body of every non-2xx response.
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+type ErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// RequestID matches the X-Request-Id logged for the request.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	writeJSON(w, status, ErrorResponse{Error: ErrorBody{
+		Code:      code,
+		Message:   message,
+		RequestID: middleware.GetReqID(r.Context()),
+	}})
+}
@@ -0,0 +1,18 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+//go:embed ui.html
+var uiHTML []byte
+
+// handleUI serves the management console: backend health, live traffic
+// graphs from /metrics/stream, and weight and drain controls. It's a static
+// page that calls the REST API with the key the user enters, so it needs no
+// auth of its own; every action is checked by the endpoint it calls.
+func (s *Server) handleUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(uiHTML)
+}
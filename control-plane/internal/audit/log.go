@@ -0,0 +1,276 @@
+// Package audit records mutating admin API calls: who made them, what they
+// asked for, when, and how it went.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/syslog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var sinkErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "aegis_audit_sink_errors_total",
+	Help: "Audit log write failures per sink",
+}, []string{"sink"})
+
+// Results, derived from the response status.
+const (
+	ResultSuccess = "success"
+	// ResultDenied is a 401 or 403: the caller wasn't allowed to try.
+	ResultDenied = "denied"
+	ResultFailed = "failed"
+)
+
+// Entry is one audited API call. Caller is empty when the request carried
+// no valid credentials, or auth is disabled.
+type Entry struct {
+	ID         uint64    `json:"id"`
+	Time       time.Time `json:"time"`
+	Caller     string    `json:"caller"`
+	Role       string    `json:"role,omitempty"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Query      string    `json:"query,omitempty"`
+	RemoteAddr string    `json:"remote_addr"`
+	RequestID  string    `json:"request_id,omitempty"`
+	// BodySHA256 is the hex SHA-256 of the request body, empty for none.
+	// The body itself isn't kept: configs and keys can carry secrets.
+	BodySHA256 string  `json:"body_sha256,omitempty"`
+	Status     int     `json:"status"`
+	Result     string  `json:"result"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// ResultFor maps an HTTP status to a Result.
+func ResultFor(status int) string {
+	switch {
+	case status == 401 || status == 403:
+		return ResultDenied
+	case status >= 400:
+		return ResultFailed
+	default:
+		return ResultSuccess
+	}
+}
+
+// Filter selects entries for Query. Zero fields match everything.
+type Filter struct {
+	Caller string
+	Method string
+	// PathPrefix matches paths starting with it.
+	PathPrefix string
+	Result     string
+	Since      time.Time
+	Until      time.Time
+	// Limit caps the number of entries returned; 0 means no cap.
+	Limit int
+}
+
+func (f Filter) match(e *Entry) bool {
+	return (f.Caller == "" || e.Caller == f.Caller) &&
+		(f.Method == "" || strings.EqualFold(e.Method, f.Method)) &&
+		strings.HasPrefix(e.Path, f.PathPrefix) &&
+		(f.Result == "" || e.Result == f.Result) &&
+		(f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || e.Time.Before(f.Until))
+}
+
+// storePrefix is where entries are kept in the state store.
+const storePrefix = "audit/"
+
+// Log keeps recent entries in memory and writes every entry to the
+// configured sinks. A nil *Log discards everything recorded to it.
+type Log struct {
+	mu      sync.Mutex
+	lastID  uint64
+	size    int
+	history []Entry
+	sinks   []sink
+	// store keeps the entries in history too, with those of other
+	// replicas sharing it
+	store  store.Store
+	logger *zap.Logger
+}
+
+type sink struct {
+	name string
+	w    io.WriteCloser
+}
+
+// New opens the sinks in cfg. If one fails to open, the others are closed
+// and the error returned.
+func New(cfg config.AuditConfig, logger *zap.Logger) (*Log, error) {
+	l := &Log{size: cfg.History, logger: logger}
+	if cfg.File != "" {
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit log file %q: %w", cfg.File, err)
+		}
+		l.sinks = append(l.sinks, sink{name: "file:" + cfg.File, w: f})
+	}
+	if cfg.Syslog.Enabled {
+		w, err := syslog.Dial(cfg.Syslog.Network, cfg.Syslog.Address, syslog.LOG_NOTICE|syslog.LOG_AUTH, cfg.Syslog.Tag)
+		if err != nil {
+			l.Close()
+			return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+		}
+		l.sinks = append(l.sinks, sink{name: "syslog", w: w})
+	}
+	return l, nil
+}
+
+// SetStore keeps recent entries in st as well, loading the last of those
+// there already, so they outlive a restart and GET /audit on any HA
+// replica sharing st lists every replica's. An entry is kept in st until
+// the replica that recorded it, or loaded it, has recorded History more.
+func (l *Log) SetStore(st store.Store) error {
+	if l == nil || l.size == 0 {
+		return nil
+	}
+	entries, err := stored(st)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.store = st
+	l.history = entries[max(0, len(entries)-l.size):]
+	for _, e := range l.history {
+		l.lastID = max(l.lastID, e.ID)
+	}
+	return nil
+}
+
+// stored returns the entries in st, oldest first.
+func stored(st store.Store) ([]Entry, error) {
+	values, err := st.List(context.Background(), storePrefix)
+	if err != nil {
+		return nil, err
+	}
+	keys := slices.Sorted(maps.Keys(values))
+	entries := make([]Entry, 0, len(keys))
+	for _, key := range keys {
+		var e Entry
+		if json.Unmarshal(values[key], &e) == nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// storeKey orders entries by time, then ID, replicas' IDs overlapping.
+func storeKey(e *Entry) string {
+	return fmt.Sprintf("%s%020d-%d", storePrefix, e.Time.UnixNano(), e.ID)
+}
+
+// Record assigns e an ID and stores it. Sinks are written synchronously so
+// an entry is durable before the response goes out; failures are logged
+// and counted but don't fail the request.
+func (l *Log) Record(e Entry) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lastID++
+	e.ID = l.lastID
+	var evicted *Entry
+	if len(l.history) == l.size && l.size > 0 {
+		evicted = &Entry{}
+		*evicted = l.history[0]
+		l.history = append(l.history[:0], l.history[1:]...)
+	}
+	if l.size > 0 {
+		l.history = append(l.history, e)
+	}
+	if l.store != nil {
+		l.storeLocked(&e, evicted)
+	}
+
+	if len(l.sinks) == 0 {
+		return
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	for _, s := range l.sinks {
+		if _, err := s.w.Write(line); err != nil {
+			sinkErrors.WithLabelValues(s.name).Inc()
+			l.logger.Error("Audit log sink write failed", zap.String("sink", s.name), zap.Error(err))
+		}
+	}
+}
+
+// storeLocked writes e to the store and deletes the entry it evicted
+// from history, logging and counting a failure. The caller holds l.mu.
+func (l *Log) storeLocked(e, evicted *Entry) {
+	err := store.PutJSON(context.Background(), l.store, storeKey(e), e)
+	if err == nil && evicted != nil {
+		err = l.store.Delete(context.Background(), storeKey(evicted))
+	}
+	if err != nil {
+		sinkErrors.WithLabelValues("store").Inc()
+		l.logger.Error("Audit log state store write failed", zap.Error(err))
+	}
+}
+
+// Query returns the entries that match f, newest first: those in the
+// state store with one, unless it can't be read, else those in memory.
+func (l *Log) Query(f Filter) []Entry {
+	l.mu.Lock()
+	history, st := slices.Clone(l.history), l.store
+	l.mu.Unlock()
+	if st != nil {
+		entries, err := stored(st)
+		if err == nil {
+			history = entries
+		} else {
+			l.logger.Warn("Failed to read the audit log from the state store; listing this replica's", zap.Error(err))
+		}
+	}
+
+	out := []Entry{}
+	for i := len(history) - 1; i >= 0; i-- {
+		if f.Limit > 0 && len(out) == f.Limit {
+			break
+		}
+		if f.match(&history[i]) {
+			out = append(out, history[i])
+		}
+	}
+	return out
+}
+
+// Close closes the sinks.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var first error
+	for _, s := range l.sinks {
+		if err := s.w.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	l.sinks = nil
+	return first
+}
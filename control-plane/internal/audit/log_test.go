@@ -0,0 +1,126 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/store"
+	"go.uber.org/zap"
+)
+
+func TestLog_QueryFiltersNewestFirst(t *testing.T) {
+	l, err := New(config.AuditConfig{History: 3}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for _, e := range []Entry{
+		{Caller: "ci", Method: "POST", Path: "/api/v1/reload", Status: 200},
+		{Caller: "oncall", Method: "DELETE", Path: "/api/v1/backends/a:1", Status: 404},
+		{Caller: "ci", Method: "POST", Path: "/api/v1/backends", Status: 201},
+		{Caller: "", Method: "PUT", Path: "/api/v1/config", Status: 401},
+	} {
+		e.Time = time.Now()
+		e.Result = ResultFor(e.Status)
+		l.Record(e)
+	}
+
+	all := l.Query(Filter{})
+	if len(all) != 3 || all[0].ID != 4 || all[2].ID != 2 {
+		t.Fatalf("history: got %+v, want IDs 4,3,2", all)
+	}
+	if got := l.Query(Filter{Caller: "ci"}); len(got) != 1 || got[0].ID != 3 {
+		t.Errorf("caller filter: got %+v", got)
+	}
+	if got := l.Query(Filter{PathPrefix: "/api/v1/backends"}); len(got) != 2 {
+		t.Errorf("path filter: got %+v", got)
+	}
+	if got := l.Query(Filter{Result: ResultDenied}); len(got) != 1 || got[0].Method != "PUT" {
+		t.Errorf("result filter: got %+v", got)
+	}
+	if got := l.Query(Filter{Method: "delete", Since: start}); len(got) != 1 || got[0].Result != ResultFailed {
+		t.Errorf("method filter: got %+v", got)
+	}
+	if got := l.Query(Filter{Limit: 1}); len(got) != 1 || got[0].ID != 4 {
+		t.Errorf("limit: got %+v", got)
+	}
+	if got := l.Query(Filter{Until: start}); len(got) != 0 {
+		t.Errorf("until: got %+v", got)
+	}
+}
+
+func TestLog_FileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := New(config.AuditConfig{History: 10, File: path}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Record(Entry{Caller: "ci", Method: "POST", Path: "/api/v1/drain", Status: 202, Result: ResultSuccess})
+	l.Record(Entry{Caller: "ci", Method: "POST", Path: "/api/v1/reload", Status: 200, Result: ResultSuccess})
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []Entry
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		lines = append(lines, e)
+	}
+	if len(lines) != 2 || lines[0].ID != 1 || lines[1].Path != "/api/v1/reload" {
+		t.Errorf("file contents: got %+v", lines)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("file mode: got %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestLog_NilDiscards(t *testing.T) {
+	var l *Log
+	l.Record(Entry{Method: "POST"})
+	if err := l.Close(); err != nil {
+		t.Errorf("Close on nil: %v", err)
+	}
+}
+
+func TestLog_Store(t *testing.T) {
+	st := store.NewMemory()
+	first, _ := New(config.AuditConfig{History: 2}, zap.NewNop())
+	if err := first.SetStore(st); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := range 3 {
+		first.Record(Entry{Time: start.Add(time.Duration(i) * time.Second), Caller: "ci", Method: "POST", Path: "/api/v1/reload"})
+	}
+	if kept, _ := st.List(context.Background(), "audit/"); len(kept) != 2 {
+		t.Errorf("kept %d entries in the store, want History's 2", len(kept))
+	}
+
+	// A restart, or another replica, picks up where it left off
+	next, _ := New(config.AuditConfig{History: 2}, zap.NewNop())
+	if err := next.SetStore(st); err != nil {
+		t.Fatal(err)
+	}
+	next.Record(Entry{Time: start.Add(time.Minute), Caller: "oncall", Method: "DELETE", Path: "/api/v1/canary"})
+	all := first.Query(Filter{})
+	if len(all) != 2 || all[0].ID != 4 || all[0].Caller != "oncall" || all[1].ID != 3 {
+		t.Errorf("shared history: got %+v, want IDs 4,3", all)
+	}
+	if got := next.Query(Filter{Caller: "ci"}); len(got) != 1 || got[0].ID != 3 {
+		t.Errorf("filtered: got %+v", got)
+	}
+}
@@ -0,0 +1,364 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"go.uber.org/zap"
+)
+
+// clockSkew is the leeway allowed on exp and nbf.
+const clockSkew = 30 * time.Second
+
+// minRefetch stops a stream of tokens with unknown key IDs from turning
+// into a stream of JWKS fetches.
+const minRefetch = time.Minute
+
+// Claims is a verified token's payload.
+type Claims map[string]interface{}
+
+// Subject returns the sub claim, used to name the caller in audit logs.
+func (c Claims) Subject() string {
+	sub, _ := c["sub"].(string)
+	return sub
+}
+
+// Verifier validates bearer JWTs signed by an OIDC issuer and maps their
+// claims to a Role. Signing keys are discovered through the issuer's
+// /.well-known/openid-configuration and cached.
+type Verifier struct {
+	cfg    config.OIDCConfig
+	client *http.Client
+	logger *zap.Logger
+	now    func() time.Time
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// NewVerifier returns a Verifier for cfg. Nothing is fetched until the
+// first token arrives, so an unreachable issuer doesn't block startup.
+func NewVerifier(cfg config.OIDCConfig, logger *zap.Logger) *Verifier {
+	return &Verifier{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		logger: logger,
+		now:    time.Now,
+	}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks raw's signature, issuer, audience and validity window.
+func (v *Verifier) Verify(ctx context.Context, raw string) (Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token is not a JWT")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("failed to decode token header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode token signature: %w", err)
+	}
+
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("failed to decode token claims: %w", err)
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *Verifier) checkClaims(claims Claims) error {
+	if iss, _ := claims["iss"].(string); iss != v.cfg.Issuer {
+		return fmt.Errorf("token issuer %q does not match %q", iss, v.cfg.Issuer)
+	}
+	if v.cfg.Audience != "" && !hasAudience(claims["aud"], v.cfg.Audience) {
+		return fmt.Errorf("token audience does not include %q", v.cfg.Audience)
+	}
+
+	now := v.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("token has no exp claim")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return errors.New("token has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token is not valid yet")
+	}
+	return nil
+}
+
+func hasAudience(aud interface{}, want string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == want
+	case []interface{}:
+		for _, v := range a {
+			if s, _ := v.(string); s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Role maps the configured role claim to the highest role it grants.
+func (v *Verifier) Role(claims Claims) Role {
+	var values []string
+	switch c := claims[v.cfg.RoleClaim].(type) {
+	case string:
+		values = strings.Fields(c)
+	case []interface{}:
+		for _, item := range c {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	best := RoleNone
+	for _, value := range values {
+		name := value
+		if len(v.cfg.RoleMapping) > 0 {
+			name = v.cfg.RoleMapping[value]
+		}
+		if role, err := ParseRole(name); err == nil && role > best {
+			best = role
+		}
+	}
+	return best
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512":
+		hash = crypto.SHA512
+	default:
+		// Everything else, notably "none" and the HMAC algorithms, is
+		// refused: an issuer's public keys can't verify them safely.
+		return fmt.Errorf("unsupported token algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("algorithm %s does not match RSA key", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(k, hash, digest, sig); err != nil {
+			return errors.New("invalid token signature")
+		}
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return fmt.Errorf("algorithm %s does not match EC key", alg)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("invalid token signature")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("invalid token signature")
+		}
+	default:
+		return errors.New("unsupported signing key type")
+	}
+	return nil
+}
+
+// key returns the signing key for kid, fetching the JWKS when the cache is
+// empty, stale, or lacks kid (the issuer may have rotated keys).
+func (v *Verifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	age := v.now().Sub(v.fetched)
+	key, ok := v.keys[kid]
+	stale := v.keys == nil || age > v.cfg.JWKSRefresh
+	if ok && !stale {
+		return key, nil
+	}
+	if !stale && age < minRefetch {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	keys, err := v.fetchKeys(ctx)
+	if err != nil {
+		if ok {
+			// Keep trusting the cached key rather than locking everyone
+			// out while the issuer is unreachable.
+			v.logger.Warn("Failed to refresh OIDC signing keys", zap.Error(err))
+			return key, nil
+		}
+		return nil, err
+	}
+	v.keys = keys
+	v.fetched = v.now()
+
+	if key, ok = keys[kid]; !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+type discoveryDoc struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (v *Verifier) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	var doc discoveryDoc
+	wellKnown := strings.TrimSuffix(v.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := v.getJSON(ctx, wellKnown, &doc); err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC discovery document: %w", err)
+	}
+	if doc.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery document has no jwks_uri")
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := v.getJSON(ctx, doc.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC signing keys: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			v.logger.Warn("Skipping unusable OIDC signing key", zap.String("kid", k.Kid), zap.Error(err))
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	v.logger.Info("Fetched OIDC signing keys", zap.String("issuer", v.cfg.Issuer), zap.Int("keys", len(keys)))
+	return keys, nil
+}
+
+func (v *Verifier) getJSON(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned HTTP %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key parameter: %w", err)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func decodeSegment(seg string, out interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"go.uber.org/zap"
+)
+
+// testIssuer serves discovery and a JWKS for its keys, and signs tokens.
+type testIssuer struct {
+	srv    *httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+	jwks   []map[string]string
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &testIssuer{rsaKey: rsaKey, ecKey: ecKey}
+	iss.jwks = []map[string]string{
+		{"kty": "RSA", "kid": "rsa1", "use": "sig",
+			"n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec1", "crv": "P-256",
+			"x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": iss.srv.URL, "jwks_uri": iss.srv.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": iss.jwks})
+	})
+	iss.srv = httptest.NewServer(mux)
+	t.Cleanup(iss.srv.Close)
+	return iss
+}
+
+func (iss *testIssuer) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch alg {
+	case "RS256":
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, iss.rsaKey, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, iss.ecKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + b64(sig)
+}
+
+func (iss *testIssuer) verifier() *Verifier {
+	return NewVerifier(config.OIDCConfig{
+		Issuer:      iss.srv.URL,
+		Audience:    "aegis",
+		RoleClaim:   "groups",
+		RoleMapping: map[string]string{"sre": "operator", "platform": "admin"},
+		JWKSRefresh: time.Hour,
+	}, zap.NewNop())
+}
+
+func (iss *testIssuer) claims(extra map[string]interface{}) map[string]interface{} {
+	c := map[string]interface{}{
+		"iss": iss.srv.URL,
+		"aud": []string{"aegis", "other"},
+		"sub": "alice",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range extra {
+		c[k] = v
+	}
+	return c
+}
+
+func TestVerifier_AcceptsSignedTokensAndMapsRoles(t *testing.T) {
+	iss := newTestIssuer(t)
+	v := iss.verifier()
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		alg, kid string
+		groups   []string
+		want     Role
+	}{
+		{"RS256", "rsa1", []string{"sre"}, RoleOperator},
+		{"ES256", "ec1", []string{"sre", "platform"}, RoleAdmin},
+		{"RS256", "rsa1", []string{"marketing"}, RoleNone},
+	} {
+		token := iss.sign(t, tc.alg, tc.kid, iss.claims(map[string]interface{}{"groups": tc.groups}))
+		claims, err := v.Verify(ctx, token)
+		if err != nil {
+			t.Fatalf("%s: Verify: %v", tc.alg, err)
+		}
+		if claims.Subject() != "alice" {
+			t.Errorf("subject: got %q", claims.Subject())
+		}
+		if got := v.Role(claims); got != tc.want {
+			t.Errorf("%v: role got %v, want %v", tc.groups, got, tc.want)
+		}
+	}
+}
+
+func TestVerifier_RejectsBadTokens(t *testing.T) {
+	iss := newTestIssuer(t)
+	v := iss.verifier()
+	ctx := context.Background()
+
+	good := iss.sign(t, "RS256", "rsa1", iss.claims(nil))
+	parts := strings.Split(good, ".")
+	noneHeader := b64([]byte(`{"alg":"none","kid":"rsa1"}`))
+
+	for name, token := range map[string]string{
+		"expired":        iss.sign(t, "RS256", "rsa1", iss.claims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})),
+		"wrong audience": iss.sign(t, "RS256", "rsa1", iss.claims(map[string]interface{}{"aud": "someone-else"})),
+		"wrong issuer":   iss.sign(t, "RS256", "rsa1", iss.claims(map[string]interface{}{"iss": "https://evil.example"})),
+		"unknown kid":    iss.sign(t, "RS256", "rsa9", iss.claims(nil)),
+		"alg none":       noneHeader + "." + parts[1] + ".",
+		"tampered":       parts[0] + "." + b64([]byte(`{"iss":"x","sub":"mallory"}`)) + "." + parts[2],
+		"key confusion":  iss.sign(t, "ES256", "rsa1", iss.claims(nil)),
+	} {
+		if _, err := v.Verify(ctx, token); err == nil {
+			t.Errorf("%s: expected Verify to fail", name)
+		}
+	}
+}
+
+func TestVerifier_RefetchesKeysOnRotation(t *testing.T) {
+	iss := newTestIssuer(t)
+	v := iss.verifier()
+	ctx := context.Background()
+
+	if _, err := v.Verify(ctx, iss.sign(t, "RS256", "rsa1", iss.claims(nil))); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	// Rotate: the RSA key is republished under a new kid.
+	iss.jwks[0]["kid"] = "rsa2"
+	token := iss.sign(t, "RS256", "rsa2", iss.claims(nil))
+	if _, err := v.Verify(ctx, token); err == nil {
+		t.Fatal("unknown kid accepted within the refetch interval")
+	}
+
+	v.now = func() time.Time { return time.Now().Add(2 * minRefetch) }
+	if _, err := v.Verify(ctx, token); err != nil {
+		t.Errorf("rotated key not picked up: %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+// Package auth holds the admin API's roles and the OIDC token verifier.
+package auth
+
+import "fmt"
+
+// Role is an admin API permission level. Each role includes the
+// permissions of the ones below it.
+type Role int
+
+const (
+	RoleNone Role = iota
+	// RoleViewer may read status and backends.
+	RoleViewer
+	// RoleOperator may also reload config, drain traffic and run data
+	// plane commands.
+	RoleOperator
+	// RoleAdmin may also change the config, e.g. add or remove backends.
+	RoleAdmin
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleOperator:
+		return "operator"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+// ParseRole parses a role name as used in config.
+func ParseRole(name string) (Role, error) {
+	switch name {
+	case "viewer":
+		return RoleViewer, nil
+	case "operator":
+		return RoleOperator, nil
+	case "admin":
+		return RoleAdmin, nil
+	default:
+		return RoleNone, fmt.Errorf("unknown role %q (want viewer, operator or admin)", name)
+	}
+}
+
+// Allows reports whether r grants the permissions of required.
+func (r Role) Allows(required Role) bool {
+	return r >= required
+}
@@ -0,0 +1,110 @@
+// Package backendsfile applies proxy.backends_file whenever the file
+// changes, apart from the config file it's named in.
+package backendsfile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"go.uber.org/zap"
+)
+
+// pollInterval is how often the file's modification time is checked.
+const pollInterval = 2 * time.Second
+
+// Applier holds the running config and pushes backend changes. The API
+// server is one, so the pushes are serialized with API changes.
+type Applier interface {
+	// BackendsFile returns the running proxy.backends_file.
+	BackendsFile() string
+	// SetFileBackends replaces the backends from path with backends and
+	// pushes them, if path is still the running file and that changes
+	// anything.
+	SetFileBackends(path string, backends []config.Backend) error
+}
+
+// Controller reads the running backends file again whenever its
+// modification time or size changes. Automation replacing the file with a
+// broken or half-written one, or removing it, leaves the backends read
+// before in place.
+type Controller struct {
+	applier Applier
+	logger  *zap.Logger
+	changed chan struct{}
+	poll    time.Duration
+
+	// path and stamp are the file last read, and its modification time
+	// and size then
+	path, stamp string
+}
+
+// New returns a controller for applier's backends file. Nothing is read
+// until Run.
+func New(applier Applier, logger *zap.Logger) *Controller {
+	return &Controller{
+		applier: applier,
+		logger:  logger,
+		changed: make(chan struct{}, 1),
+		poll:    pollInterval,
+	}
+}
+
+// Changed tells the controller the running config was replaced, so a
+// changed backends file setting is picked up straight away. It doesn't
+// block, so it's safe to call with the API's apply lock held.
+func (c *Controller) Changed() {
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Run checks the file every poll until ctx is done.
+func (c *Controller) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.poll)
+	defer ticker.Stop()
+	for {
+		c.sync()
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.changed:
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync reads the file and pushes its backends if it changed since it was
+// last read. A failed read is logged once per change of the file; a
+// failed push is tried again at the next poll.
+func (c *Controller) sync() {
+	path := c.applier.BackendsFile()
+	if path == "" {
+		c.path, c.stamp = "", ""
+		return
+	}
+	var stamp string
+	info, err := os.Stat(path)
+	if err == nil {
+		stamp = fmt.Sprintf("%d:%d", info.ModTime().UnixNano(), info.Size())
+	}
+	if path == c.path && stamp == c.stamp {
+		return
+	}
+
+	backends, err := config.ReadBackendsFile(path)
+	if err != nil {
+		c.logger.Error("Failed to read backends file; keeping the backends read before",
+			zap.String("path", path), zap.Error(err))
+		c.path, c.stamp = path, stamp
+		return
+	}
+	if err := c.applier.SetFileBackends(path, backends); err != nil {
+		c.logger.Error("Failed to push backends file", zap.String("path", path), zap.Int("backends", len(backends)), zap.Error(err))
+		return
+	}
+	c.path, c.stamp = path, stamp
+}
@@ -0,0 +1,78 @@
+package backendsfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"go.uber.org/zap"
+)
+
+type fakeApplier struct {
+	path   string
+	pushed [][]config.Backend
+	fail   bool
+}
+
+func (f *fakeApplier) BackendsFile() string { return f.path }
+
+func (f *fakeApplier) SetFileBackends(path string, backends []config.Backend) error {
+	if f.fail {
+		return errors.New("data plane unavailable")
+	}
+	f.pushed = append(f.pushed, backends)
+	return nil
+}
+
+func TestController_PushesChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backends.yaml")
+	write := func(content string, mtime time.Time) {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, mtime, mtime)
+	}
+	start := time.Now().Add(-time.Hour)
+	write("- address: 10.0.0.1:80\n", start)
+	applier := &fakeApplier{path: path}
+	c := New(applier, zap.NewNop())
+
+	c.sync()
+	if len(applier.pushed) != 1 || applier.pushed[0][0].Address != "10.0.0.1:80" || !applier.pushed[0][0].FromFile {
+		t.Fatalf("first read: %+v", applier.pushed)
+	}
+	if c.sync(); len(applier.pushed) != 1 {
+		t.Errorf("unchanged: %d pushes", len(applier.pushed))
+	}
+
+	// A broken file keeps what was read, and is only read again once it
+	// changes
+	write("- address: [", start.Add(time.Minute))
+	c.sync()
+	c.sync()
+	if len(applier.pushed) != 1 || c.stamp == "" {
+		t.Errorf("broken: %d pushes", len(applier.pushed))
+	}
+
+	// A failed push is tried again
+	write("- address: 10.0.0.1:80\n- address: 10.0.0.2:80\n", start.Add(2*time.Minute))
+	applier.fail = true
+	c.sync()
+	applier.fail = false
+	if c.sync(); len(applier.pushed) != 2 || len(applier.pushed[1]) != 2 {
+		t.Errorf("retried: %+v", applier.pushed)
+	}
+
+	// Removing it keeps the backends too
+	os.Remove(path)
+	if c.sync(); len(applier.pushed) != 2 {
+		t.Errorf("removed: %d pushes", len(applier.pushed))
+	}
+	applier.path = ""
+	if c.sync(); c.path != "" || len(applier.pushed) != 2 {
+		t.Errorf("off: %q, %d pushes", c.path, len(applier.pushed))
+	}
+}
@@ -0,0 +1,671 @@
+// Package canary rolls traffic out to a canary backend pool in steps,
+// judging the pool's error rate and latency on the streamed metrics at
+// each step and rolling back as soon as they regress.
+package canary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/events"
+	"github.com/lazzerex/aegis/control-plane/internal/metrics"
+	"github.com/lazzerex/aegis/control-plane/internal/store"
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+	"go.uber.org/zap"
+)
+
+// Rollout states.
+const (
+	StateProgressing = "progressing"
+	// StateSucceeded is a rollout that passed its last step at the target
+	// percentage, which it leaves in place.
+	StateSucceeded = "succeeded"
+	// StateRolledBack is a rollout that regressed or was aborted, with all
+	// traffic put back on the baseline pool.
+	StateRolledBack = "rolled_back"
+	// StateAbandoned is a rollout whose traffic split was replaced from
+	// outside, by a reload or the traffic split API; it's left alone.
+	StateAbandoned = "abandoned"
+)
+
+// Defaults for the parts of a Spec left unset.
+const (
+	DefaultStepPercent  = 10
+	DefaultStepInterval = 5 * time.Minute
+	DefaultMaxErrorRate = 0.01
+	DefaultMinRequests  = 100
+)
+
+// retryPush is how long after a failed push it's tried again.
+const retryPush = 30 * time.Second
+
+// storeKey is where the rollout is kept in the state store.
+const storeKey = "canary/rollout"
+
+var (
+	ErrRunning    = errors.New("a canary rollout is already in progress")
+	ErrNotRunning = errors.New("no canary rollout is in progress")
+)
+
+// Applier pushes a rollout's traffic splits. The API server is one, so
+// each step is serialized with API changes and versioned like them.
+type Applier interface {
+	// ApplyTrafficSplit makes split the running config's traffic split.
+	ApplyTrafficSplit(split map[string]int) (version string, err error)
+	// RunningProxy is the proxy section of the running config.
+	RunningProxy() config.ProxyConfig
+}
+
+// MetricsSource streams data plane snapshots; the metrics collector is
+// one.
+type MetricsSource interface {
+	Subscribe() (<-chan *pb.MetricsData, func())
+}
+
+// Spec describes a rollout: the canary pool's share of traffic grows by
+// StepPercent every StepInterval until it reaches TargetPercent, the rest
+// going to the baseline pool.
+type Spec struct {
+	Pool          string
+	Baseline      string
+	TargetPercent int
+	StepPercent   int
+	// StepInterval is how long each step runs before it's judged; a step
+	// that hasn't seen MinRequests connections yet runs on until it has.
+	StepInterval time.Duration
+	// MaxErrorRate is the highest share of the canary's connections that
+	// may fail, 0.01 being 1%.
+	MaxErrorRate float64
+	// MaxLatency caps the canary's p99 connect latency; 0 doesn't check
+	// latency.
+	MaxLatency  time.Duration
+	MinRequests int64
+}
+
+// WithDefaults fills in what s leaves unset: a target of 100% and the
+// Default* values.
+func (s Spec) WithDefaults() Spec {
+	if s.TargetPercent == 0 {
+		s.TargetPercent = 100
+	}
+	if s.StepPercent == 0 {
+		s.StepPercent = min(DefaultStepPercent, s.TargetPercent)
+	}
+	if s.StepInterval == 0 {
+		s.StepInterval = DefaultStepInterval
+	}
+	if s.MaxErrorRate == 0 {
+		s.MaxErrorRate = DefaultMaxErrorRate
+	}
+	if s.MinRequests == 0 {
+		s.MinRequests = DefaultMinRequests
+	}
+	return s
+}
+
+// Validate checks s on its own; whether its pools can be split between is
+// up to config.ValidateTrafficSplit when the first step is pushed.
+func (s Spec) Validate() []string {
+	var errs []string
+	if s.Pool == "" || s.Baseline == "" {
+		errs = append(errs, "pool and baseline are required")
+	} else if s.Pool == s.Baseline {
+		errs = append(errs, "pool and baseline must differ")
+	}
+	if s.TargetPercent < 1 || s.TargetPercent > 100 {
+		errs = append(errs, fmt.Sprintf("target_percent must be between 1 and 100, got %d", s.TargetPercent))
+	}
+	if s.StepPercent < 1 || s.StepPercent > s.TargetPercent {
+		errs = append(errs, fmt.Sprintf("step_percent must be between 1 and target_percent, got %d", s.StepPercent))
+	}
+	if s.StepInterval < time.Second {
+		errs = append(errs, fmt.Sprintf("step_interval must be at least 1s, got %s", s.StepInterval))
+	}
+	if s.MaxErrorRate < 0 || s.MaxErrorRate > 1 {
+		errs = append(errs, fmt.Sprintf("max_error_rate must be between 0 and 1, got %g", s.MaxErrorRate))
+	}
+	if s.MaxLatency < 0 {
+		errs = append(errs, "max_p99_latency must be >= 0")
+	}
+	if s.MinRequests < 0 {
+		errs = append(errs, "min_requests must be >= 0")
+	}
+	return errs
+}
+
+// Analysis is what the canary pool's backends reported during one step.
+// Requests counts connection attempts, Errors those that failed.
+type Analysis struct {
+	Percent  int
+	Started  time.Time
+	Requests int64
+	Errors   int64
+	// Connects is how many connect latencies P99LatencyMs was estimated
+	// from; P99LatencyMs means nothing while it's 0.
+	Connects     uint64
+	P99LatencyMs float64
+	// Passed is set on a finished step that met the criteria.
+	Passed bool
+}
+
+// ErrorRate is the share of Requests that failed.
+func (a Analysis) ErrorRate() float64 {
+	if a.Requests == 0 {
+		return 0
+	}
+	return float64(a.Errors) / float64(a.Requests)
+}
+
+// Status is a rollout's progress, for GET /canary.
+type Status struct {
+	Spec
+	State   string
+	Percent int
+	// Current is the step under way while progressing; Steps are those
+	// finished, oldest first.
+	Current  Analysis
+	Steps    []Analysis
+	Started  time.Time
+	Finished time.Time
+	// Reason says why a rollout was rolled back or abandoned.
+	Reason string
+	// Version is the config version of the rollout's last push.
+	Version string
+	// LastError is the last failed push, cleared by the next success.
+	LastError string
+}
+
+// Controller runs one rollout at a time, keeping the last one's status
+// once it's over. Without a state store rollouts live in memory, and a
+// control plane restart leaves the split where the last step put it.
+type Controller struct {
+	applier Applier
+	source  MetricsSource
+	logger  *zap.Logger
+	feed    *events.Feed
+	store   store.Store
+	wake    chan struct{}
+
+	mu      sync.Mutex
+	rollout *rollout
+}
+
+type rollout struct {
+	Status
+	split    map[string]int
+	backends map[string]bool
+	// last holds each instance's cumulative totals per canary backend as
+	// last reported
+	last map[string]map[string]*totals
+	// rollback is the reason for a rollback waiting on retryAt; without
+	// one, it's the next promotion that is
+	rollback string
+	retryAt  time.Time
+	bounds   []float64
+	counts   []uint64
+}
+
+type totals struct {
+	requests, errors int64
+	counts           []uint64
+	count            uint64
+}
+
+func New(applier Applier, source MetricsSource, logger *zap.Logger) *Controller {
+	return &Controller{
+		applier: applier,
+		source:  source,
+		logger:  logger,
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// SetEventFeed publishes canary_* events to feed.
+func (c *Controller) SetEventFeed(feed *events.Feed) {
+	c.feed = feed
+}
+
+// SetStore keeps the rollout in st as it moves on, for Run to carry it on
+// after a restart, or on the replica that takes over as leader.
+func (c *Controller) SetStore(st store.Store) {
+	c.store = st
+}
+
+// Status reports the rollout in progress, or the last one; false before
+// the first.
+func (c *Controller) Status() (Status, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rollout == nil {
+		return Status{}, false
+	}
+	st := c.rollout.Status
+	st.Steps = slices.Clone(st.Steps)
+	return st, true
+}
+
+// Start pushes spec's first step, which must have been validated, and
+// returns the rollout's status.
+func (c *Controller) Start(spec Spec, caller string) (Status, error) {
+	return c.start(spec, caller, time.Now())
+}
+
+// Abort rolls back the rollout in progress. A failed push is retried in
+// the background; the error says it hasn't happened yet.
+func (c *Controller) Abort(caller string) (Status, error) {
+	return c.abort(caller, time.Now())
+}
+
+// Run follows the metrics stream and moves the rollout on until ctx is
+// done, first picking up the one in the state store.
+func (c *Controller) Run(ctx context.Context) {
+	c.restore(time.Now())
+	snapshots, unsubscribe := c.source.Subscribe()
+	defer unsubscribe()
+	for {
+		timer := time.NewTimer(time.Hour)
+		if next, ok := c.nextEvaluation(); ok {
+			timer.Reset(time.Until(next))
+		}
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case data := <-snapshots:
+			timer.Stop()
+			c.observe(data, time.Now())
+		case <-c.wake:
+			timer.Stop()
+		case <-timer.C:
+			c.evaluate(time.Now())
+		}
+	}
+}
+
+func (c *Controller) start(spec Spec, caller string, now time.Time) (Status, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rollout != nil && c.rollout.State == StateProgressing {
+		return Status{}, ErrRunning
+	}
+
+	r := &rollout{
+		Status:   Status{Spec: spec, State: StateProgressing, Started: now},
+		backends: make(map[string]bool),
+		last:     make(map[string]map[string]*totals),
+	}
+	for _, b := range c.applier.RunningProxy().Backends {
+		if b.Pool == spec.Pool {
+			r.backends[b.Address] = true
+		}
+	}
+	percent := min(spec.StepPercent, spec.TargetPercent)
+	if err := c.pushLocked(r, percent); err != nil {
+		return Status{}, err
+	}
+	r.Percent = percent
+	r.beginStep(now)
+	c.rollout = r
+	c.saveLocked(r)
+	c.signal()
+
+	c.feed.Publish(events.TypeCanaryStarted,
+		fmt.Sprintf("Canary rollout of pool %s started at %d%%", spec.Pool, percent),
+		map[string]string{"pool": spec.Pool, "percent": fmt.Sprint(percent), "caller": caller, "version": r.Version})
+	c.logger.Info("Canary rollout started",
+		zap.String("pool", spec.Pool),
+		zap.String("baseline", spec.Baseline),
+		zap.Int("percent", percent),
+		zap.Int("target_percent", spec.TargetPercent),
+		zap.String("caller", caller),
+		zap.String("version", r.Version))
+	return r.Status, nil
+}
+
+func (c *Controller) abort(caller string, now time.Time) (Status, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := c.rollout
+	if r == nil || r.State != StateProgressing {
+		return Status{}, ErrNotRunning
+	}
+	err := c.rollBackLocked(r, "aborted by "+caller, now)
+	return r.Status, err
+}
+
+// observe adds data's canary traffic to the current step, then judges it.
+func (c *Controller) observe(data *pb.MetricsData, now time.Time) {
+	c.mu.Lock()
+	r := c.rollout
+	if r == nil || r.State != StateProgressing {
+		c.mu.Unlock()
+		return
+	}
+	r.add(data)
+	c.mu.Unlock()
+	c.evaluate(now)
+}
+
+// evaluate rolls back a step that has seen enough traffic to fail its
+// criteria, promotes one that has run its interval and passed, and retries
+// a failed push once it's due.
+func (c *Controller) evaluate(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := c.rollout
+	if r == nil || r.State != StateProgressing {
+		return
+	}
+	if !maps.Equal(c.applier.RunningProxy().TrafficSplit, r.split) {
+		c.abandonLocked(r, now)
+		return
+	}
+	if !r.retryAt.IsZero() {
+		if now.Before(r.retryAt) {
+			return
+		}
+		if r.rollback != "" {
+			c.rollBackLocked(r, r.rollback, now)
+		} else {
+			c.promoteLocked(r, now)
+		}
+		return
+	}
+
+	a := r.Current
+	if a.Requests == 0 || a.Requests < r.MinRequests {
+		return
+	}
+	if reason := r.regression(); reason != "" {
+		c.rollBackLocked(r, reason, now)
+		return
+	}
+	if !now.Before(a.Started.Add(r.StepInterval)) {
+		c.promoteLocked(r, now)
+	}
+}
+
+// nextEvaluation is when the current step ends or a failed push is due
+// again.
+func (c *Controller) nextEvaluation() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := c.rollout
+	if r == nil || r.State != StateProgressing {
+		return time.Time{}, false
+	}
+	if !r.retryAt.IsZero() {
+		return r.retryAt, true
+	}
+	return r.Current.Started.Add(r.StepInterval), true
+}
+
+// promoteLocked finishes a passed step: it grows the canary's share by a
+// step, or completes a rollout already at its target. The caller holds
+// c.mu.
+func (c *Controller) promoteLocked(r *rollout, now time.Time) {
+	if r.Percent == r.TargetPercent {
+		r.finishStep(true)
+		r.State, r.Finished = StateSucceeded, now
+		c.saveLocked(r)
+		c.feed.Publish(events.TypeCanaryCompleted,
+			fmt.Sprintf("Canary rollout of pool %s completed at %d%%", r.Pool, r.Percent),
+			map[string]string{"pool": r.Pool, "percent": fmt.Sprint(r.Percent), "version": r.Version})
+		c.logger.Info("Canary rollout completed", zap.String("pool", r.Pool), zap.Int("percent", r.Percent))
+		return
+	}
+
+	percent := min(r.Percent+r.StepPercent, r.TargetPercent)
+	if err := c.pushLocked(r, percent); err != nil {
+		r.retryAt = now.Add(retryPush)
+		c.logger.Error("Failed to push canary step", zap.String("pool", r.Pool), zap.Int("percent", percent), zap.Error(err))
+		return
+	}
+	r.finishStep(true)
+	r.Percent, r.retryAt = percent, time.Time{}
+	r.beginStep(now)
+	c.saveLocked(r)
+	c.feed.Publish(events.TypeCanaryPromoted,
+		fmt.Sprintf("Canary pool %s promoted to %d%%", r.Pool, percent),
+		map[string]string{"pool": r.Pool, "percent": fmt.Sprint(percent), "version": r.Version})
+	c.logger.Info("Canary promoted",
+		zap.String("pool", r.Pool),
+		zap.Int("percent", percent),
+		zap.String("version", r.Version))
+}
+
+// rollBackLocked gives the baseline all the traffic again; a failed push
+// is retried after retryPush. The caller holds c.mu.
+func (c *Controller) rollBackLocked(r *rollout, reason string, now time.Time) error {
+	if err := c.pushLocked(r, 0); err != nil {
+		r.rollback, r.retryAt = reason, now.Add(retryPush)
+		c.logger.Error("Failed to roll back canary", zap.String("pool", r.Pool), zap.String("reason", reason), zap.Error(err))
+		c.saveLocked(r)
+		return err
+	}
+	r.finishStep(false)
+	r.State, r.Finished, r.Reason = StateRolledBack, now, reason
+	r.Percent, r.rollback, r.retryAt = 0, "", time.Time{}
+	c.saveLocked(r)
+	c.feed.Publish(events.TypeCanaryRolledBack,
+		fmt.Sprintf("Canary rollout of pool %s rolled back: %s", r.Pool, reason),
+		map[string]string{"pool": r.Pool, "reason": reason, "version": r.Version})
+	c.logger.Warn("Canary rolled back",
+		zap.String("pool", r.Pool),
+		zap.String("reason", reason),
+		zap.String("version", r.Version))
+	return nil
+}
+
+// abandonLocked stops a rollout whose split someone else replaced,
+// leaving theirs in place. The caller holds c.mu.
+func (c *Controller) abandonLocked(r *rollout, now time.Time) {
+	r.State, r.Finished = StateAbandoned, now
+	r.Reason = "traffic split changed outside the rollout"
+	c.saveLocked(r)
+	c.logger.Warn("Canary rollout abandoned: traffic split changed outside it", zap.String("pool", r.Pool))
+}
+
+// pushLocked gives the canary percent of the traffic and the baseline the
+// rest.
+func (c *Controller) pushLocked(r *rollout, percent int) error {
+	split := map[string]int{r.Pool: percent, r.Baseline: 100 - percent}
+	version, err := c.applier.ApplyTrafficSplit(split)
+	if err != nil {
+		r.LastError = err.Error()
+		return err
+	}
+	r.split, r.Version, r.LastError = split, version, ""
+	return nil
+}
+
+// saved is a rollout as kept in the state store.
+type saved struct {
+	Status
+	// Rollback is the reason for a rollback yet to be pushed.
+	Rollback string `json:",omitempty"`
+}
+
+// saveLocked keeps r in the state store, if there's one; failing to is
+// logged, the rollout going on regardless. The caller holds c.mu.
+func (c *Controller) saveLocked(r *rollout) {
+	if c.store == nil {
+		return
+	}
+	if err := store.PutJSON(context.Background(), c.store, storeKey, saved{Status: r.Status, Rollback: r.rollback}); err != nil {
+		c.logger.Warn("Failed to save the canary rollout to the state store", zap.String("pool", r.Pool), zap.Error(err))
+	}
+}
+
+// restore takes up the rollout in the state store, saved by this replica
+// before a restart or by the leader before it, unless one was started
+// since. One in progress is pushed again, the config pushed on starting
+// having no split, and its current step begins again, as does a rollback
+// that hadn't gone through.
+func (c *Controller) restore(now time.Time) {
+	if c.store == nil {
+		return
+	}
+	var sv saved
+	ok, err := store.GetJSON(context.Background(), c.store, storeKey, &sv)
+	if err != nil {
+		c.logger.Warn("Failed to read the canary rollout from the state store", zap.Error(err))
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !ok || c.rollout != nil {
+		return
+	}
+	r := &rollout{Status: sv.Status, backends: make(map[string]bool), last: make(map[string]map[string]*totals)}
+	c.rollout = r
+	if r.State != StateProgressing {
+		return
+	}
+	if sv.Rollback != "" {
+		c.rollBackLocked(r, sv.Rollback, now)
+		return
+	}
+	for _, b := range c.applier.RunningProxy().Backends {
+		if b.Pool == r.Pool {
+			r.backends[b.Address] = true
+		}
+	}
+	if err := c.pushLocked(r, r.Percent); err != nil {
+		r.State, r.Finished = StateAbandoned, now
+		r.Reason = "couldn't be resumed: " + err.Error()
+		c.logger.Error("Failed to resume canary rollout", zap.String("pool", r.Pool), zap.Int("percent", r.Percent), zap.Error(err))
+		c.saveLocked(r)
+		return
+	}
+	r.beginStep(now)
+	c.saveLocked(r)
+	c.logger.Info("Resumed canary rollout",
+		zap.String("pool", r.Pool),
+		zap.Int("percent", r.Percent),
+		zap.String("version", r.Version))
+}
+
+func (c *Controller) signal() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *rollout) beginStep(now time.Time) {
+	r.Current = Analysis{Percent: r.Percent, Started: now}
+	r.bounds, r.counts = nil, nil
+}
+
+func (r *rollout) finishStep(passed bool) {
+	r.Current.Passed = passed
+	r.Steps = append(r.Steps, r.Current)
+	r.Current = Analysis{}
+}
+
+// regression says how the current step fails the criteria, empty if it
+// doesn't.
+func (r *rollout) regression() string {
+	a := r.Current
+	if rate := a.ErrorRate(); rate > r.MaxErrorRate {
+		return fmt.Sprintf("error rate %.2f%% over %.2f%% at %d%%", rate*100, r.MaxErrorRate*100, a.Percent)
+	}
+	if r.MaxLatency > 0 && a.Connects > 0 {
+		if limit := float64(r.MaxLatency) / float64(time.Millisecond); a.P99LatencyMs > limit {
+			return fmt.Sprintf("p99 connect latency %.1fms over %s at %d%%", a.P99LatencyMs, r.MaxLatency, a.Percent)
+		}
+	}
+	return ""
+}
+
+// add folds one instance's snapshot of the canary backends into the
+// current step. Each backend's first snapshot from an instance only sets
+// the baseline its later totals are counted from.
+func (r *rollout) add(data *pb.MetricsData) {
+	last := r.last[data.InstanceId]
+	if last == nil {
+		last = make(map[string]*totals)
+		r.last[data.InstanceId] = last
+	}
+	for _, b := range data.BackendMetrics {
+		if !r.backends[b.Address] {
+			continue
+		}
+		t := last[b.Address]
+		first := t == nil
+		if first {
+			t = &totals{}
+			last[b.Address] = t
+		}
+		requests := delta(&t.requests, b.TotalRequests+b.FailedRequests)
+		errs := delta(&t.errors, b.FailedRequests)
+		var counts []uint64
+		var count uint64
+		ok := false
+		if hist := b.ConnectLatency; hist != nil && len(hist.Counts) == len(hist.BoundsMs) {
+			counts, count, ok = t.histDelta(hist)
+		}
+		if first {
+			continue
+		}
+		r.Current.Requests += requests
+		r.Current.Errors += errs
+		if !ok {
+			continue
+		}
+		bounds := b.ConnectLatency.BoundsMs
+		if r.bounds == nil {
+			r.bounds = slices.Clone(bounds)
+			r.counts = make([]uint64, len(bounds))
+		}
+		if !slices.Equal(r.bounds, bounds) {
+			continue
+		}
+		for i := range counts {
+			r.counts[i] += counts[i]
+		}
+		r.Current.Connects += count
+	}
+	if r.Current.Connects > 0 {
+		r.Current.P99LatencyMs = metrics.HistogramQuantile(0.99, r.bounds, r.counts, r.Current.Connects)
+	}
+}
+
+// histDelta returns the connects hist adds to those last seen, false when
+// there's nothing to compare it with.
+func (t *totals) histDelta(hist *pb.LatencyHistogram) ([]uint64, uint64, bool) {
+	prev, prevCount := t.counts, t.count
+	t.counts, t.count = slices.Clone(hist.Counts), hist.Count
+	if len(prev) != len(hist.Counts) {
+		return nil, 0, false
+	}
+	if hist.Count < prevCount {
+		// The data plane restarted and started over
+		return hist.Counts, hist.Count, true
+	}
+	counts := make([]uint64, len(hist.Counts))
+	for i, n := range hist.Counts {
+		if n >= prev[i] {
+			counts[i] = n - prev[i]
+		}
+	}
+	return counts, hist.Count - prevCount, true
+}
+
+// delta returns how far a cumulative total moved since *last and records
+// it; a total that went down started over with a data plane restart.
+func delta(last *int64, total int64) int64 {
+	d := total - *last
+	if d < 0 {
+		d = total
+	}
+	*last = total
+	return d
+}
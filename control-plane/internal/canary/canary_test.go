@@ -0,0 +1,233 @@
+package canary
+
+import (
+	"errors"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/events"
+	"github.com/lazzerex/aegis/control-plane/internal/store"
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+	"go.uber.org/zap"
+)
+
+// fakeApplier keeps the split it was last given.
+type fakeApplier struct {
+	proxy  config.ProxyConfig
+	pushes int
+	fail   bool
+}
+
+func (f *fakeApplier) ApplyTrafficSplit(split map[string]int) (string, error) {
+	if f.fail {
+		return "", errors.New("data plane unavailable")
+	}
+	f.pushes++
+	f.proxy.TrafficSplit = maps.Clone(split)
+	return "v" + string(rune('0'+f.pushes)), nil
+}
+
+func (f *fakeApplier) RunningProxy() config.ProxyConfig { return f.proxy }
+
+func newApplier() *fakeApplier {
+	return &fakeApplier{proxy: config.ProxyConfig{Backends: []config.Backend{
+		{Address: "stable:3000", Pool: "stable"},
+		{Address: "canary:3000", Pool: "canary"},
+	}}}
+}
+
+func spec() Spec {
+	return Spec{Pool: "canary", Baseline: "stable", TargetPercent: 50, StepPercent: 25,
+		StepInterval: time.Minute, MaxLatency: 100 * time.Millisecond}.WithDefaults()
+}
+
+var t0 = time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+
+// snapshot reports the canary backend's cumulative totals, with every
+// connect timed under fast ms or, past it, under 1000ms.
+func snapshot(requests, failed int64, fast, slow uint64) *pb.MetricsData {
+	return &pb.MetricsData{InstanceId: "dp-1", BackendMetrics: []*pb.BackendMetrics{
+		{Address: "stable:3000", TotalRequests: 1000, FailedRequests: 500},
+		{Address: "canary:3000", TotalRequests: requests, FailedRequests: failed,
+			ConnectLatency: &pb.LatencyHistogram{BoundsMs: []float64{10, 1000}, Counts: []uint64{fast, fast + slow}, Count: fast + slow}},
+	}}
+}
+
+func TestSpec_Validate(t *testing.T) {
+	if errs := spec().Validate(); len(errs) > 0 {
+		t.Errorf("valid spec: %v", errs)
+	}
+	s := Spec{Pool: "canary", Baseline: "canary", StepPercent: 60, TargetPercent: 50, MaxErrorRate: 2}.WithDefaults()
+	got := strings.Join(s.Validate(), "\n")
+	for _, want := range []string{"must differ", "step_percent", "max_error_rate"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+	if d := (Spec{}).WithDefaults(); d.TargetPercent != 100 || d.StepPercent != 10 || d.MinRequests != 100 {
+		t.Errorf("defaults: got %+v", d)
+	}
+}
+
+func TestController_PromotesToTarget(t *testing.T) {
+	applier := newApplier()
+	c := New(applier, nil, zap.NewNop())
+	feed := events.NewFeed()
+	c.SetEventFeed(feed)
+	sub := feed.Subscribe(0)
+
+	st, err := c.start(spec(), "alice", t0)
+	if err != nil || st.Percent != 25 || applier.proxy.TrafficSplit["canary"] != 25 || applier.proxy.TrafficSplit["stable"] != 75 {
+		t.Fatalf("start: got %+v, split %v, %v", st, applier.proxy.TrafficSplit, err)
+	}
+	if ev := <-sub.Events; ev.Type != events.TypeCanaryStarted || ev.Attributes["caller"] != "alice" {
+		t.Errorf("started event: got %+v", ev)
+	}
+	if _, err := c.start(spec(), "bob", t0); !errors.Is(err, ErrRunning) {
+		t.Errorf("second start: got %v", err)
+	}
+
+	// The first snapshot is the baseline; the step has to see min_requests
+	// and run its interval before it's promoted
+	c.observe(snapshot(1000, 10, 900, 100), t0.Add(time.Second))
+	c.observe(snapshot(1150, 10, 1050, 100), t0.Add(30*time.Second))
+	if st, _ := c.Status(); st.Percent != 25 || st.Current.Requests != 150 || st.Current.Errors != 0 || st.Current.Connects != 150 {
+		t.Fatalf("mid-step: got %+v", st)
+	}
+	c.evaluate(t0.Add(time.Minute))
+	st, _ = c.Status()
+	if st.Percent != 50 || len(st.Steps) != 1 || !st.Steps[0].Passed || st.Version != "v2" {
+		t.Fatalf("promoted: got %+v", st)
+	}
+	if ev := <-sub.Events; ev.Type != events.TypeCanaryPromoted || ev.Attributes["percent"] != "50" {
+		t.Errorf("promoted event: got %+v", ev)
+	}
+
+	// At the target, a passed step completes the rollout and leaves the
+	// split alone
+	c.observe(snapshot(1300, 11, 1200, 100), t0.Add(90*time.Second))
+	c.evaluate(t0.Add(2 * time.Minute))
+	st, _ = c.Status()
+	if st.State != StateSucceeded || st.Percent != 50 || len(st.Steps) != 2 || applier.pushes != 2 {
+		t.Errorf("completed: got %+v after %d pushes", st, applier.pushes)
+	}
+	if ev := <-sub.Events; ev.Type != events.TypeCanaryCompleted {
+		t.Errorf("completed event: got %+v", ev)
+	}
+}
+
+func TestController_RollsBackOnRegression(t *testing.T) {
+	for name, tc := range map[string]struct {
+		next *pb.MetricsData
+		want string
+	}{
+		"errors":  {snapshot(1100, 20, 1000, 100), "error rate 9.09% over 1.00%"},
+		"latency": {snapshot(1110, 10, 900, 210), "p99 connect latency"},
+	} {
+		applier := newApplier()
+		c := New(applier, nil, zap.NewNop())
+		if _, err := c.start(spec(), "alice", t0); err != nil {
+			t.Fatalf("%s: start: %v", name, err)
+		}
+		c.observe(snapshot(1000, 10, 900, 100), t0.Add(time.Second))
+		// Judged as soon as min_requests is reached, before the interval
+		// is up
+		c.observe(tc.next, t0.Add(10*time.Second))
+		st, _ := c.Status()
+		if st.State != StateRolledBack || !strings.Contains(st.Reason, tc.want) || st.Percent != 0 {
+			t.Errorf("%s: got %+v", name, st)
+		}
+		if split := applier.proxy.TrafficSplit; split["canary"] != 0 || split["stable"] != 100 {
+			t.Errorf("%s: split after rollback: %v", name, split)
+		}
+	}
+}
+
+func TestController_AbortRetriesFailedRollback(t *testing.T) {
+	applier := newApplier()
+	c := New(applier, nil, zap.NewNop())
+	if _, err := c.start(spec(), "alice", t0); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	applier.fail = true
+	if _, err := c.abort("bob", t0.Add(time.Second)); err == nil {
+		t.Fatal("abort succeeded while the data plane was down")
+	}
+	if next, ok := c.nextEvaluation(); !ok || !next.Equal(t0.Add(time.Second+retryPush)) {
+		t.Errorf("retry: got %s", next)
+	}
+
+	applier.fail = false
+	c.evaluate(t0.Add(time.Second + retryPush))
+	if st, _ := c.Status(); st.State != StateRolledBack || st.Reason != "aborted by bob" || st.LastError != "" {
+		t.Errorf("retried rollback: got %+v", st)
+	}
+	if _, err := c.abort("bob", t0.Add(time.Hour)); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("abort when done: got %v", err)
+	}
+}
+
+func TestController_AbandonsReplacedSplit(t *testing.T) {
+	applier := newApplier()
+	c := New(applier, nil, zap.NewNop())
+	if _, err := c.start(spec(), "alice", t0); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	// A reload puts the file's split back
+	applier.proxy.TrafficSplit = nil
+	c.evaluate(t0.Add(time.Minute))
+	if st, _ := c.Status(); st.State != StateAbandoned || applier.pushes != 1 {
+		t.Errorf("abandoned: got %+v after %d pushes", st, applier.pushes)
+	}
+}
+
+func TestController_ResumesFromStore(t *testing.T) {
+	st := store.NewMemory()
+	first := New(newApplier(), nil, zap.NewNop())
+	first.SetStore(st)
+	if _, err := first.start(spec(), "alice", t0); err != nil {
+		t.Fatal(err)
+	}
+	first.observe(snapshot(1000, 10, 900, 100), t0.Add(time.Second))
+	first.observe(snapshot(1150, 10, 1050, 100), t0.Add(30*time.Second))
+	first.evaluate(t0.Add(time.Minute))
+
+	// The replica taking over pushed the file's config, without the split
+	applier := newApplier()
+	next := New(applier, nil, zap.NewNop())
+	next.SetStore(st)
+	next.restore(t0.Add(2 * time.Minute))
+	got, ok := next.Status()
+	if !ok || got.State != StateProgressing || got.Percent != 50 || len(got.Steps) != 1 || !got.Current.Started.Equal(t0.Add(2*time.Minute)) {
+		t.Fatalf("resumed: got %+v", got)
+	}
+	if applier.proxy.TrafficSplit["canary"] != 50 || applier.pushes != 1 {
+		t.Errorf("resumed split: got %v after %d pushes", applier.proxy.TrafficSplit, applier.pushes)
+	}
+	c := New(newApplier(), nil, zap.NewNop())
+	c.SetStore(st)
+	if _, err := next.abort("bob", t0.Add(3*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	// One that's over is only reported
+	c.restore(t0.Add(4 * time.Minute))
+	if got, _ := c.Status(); got.State != StateRolledBack || got.Reason != "aborted by bob" || c.applier.(*fakeApplier).pushes != 0 {
+		t.Errorf("finished: got %+v", got)
+	}
+
+	// as is one whose pools are gone
+	if _, err := c.start(spec(), "alice", t0); err != nil {
+		t.Fatal(err)
+	}
+	gone := &fakeApplier{fail: true}
+	c = New(gone, nil, zap.NewNop())
+	c.SetStore(st)
+	c.restore(t0.Add(5 * time.Minute))
+	if got, _ := c.Status(); got.State != StateAbandoned || !strings.Contains(got.Reason, "couldn't be resumed") {
+		t.Errorf("not resumable: got %+v", got)
+	}
+}
@@ -0,0 +1,47 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Certificate is a certificate the data plane terminates TLS with, as
+// pushed to it.
+type Certificate struct {
+	// Name identifies it to the data plane and in logs.
+	Name string
+	// Chain is PEM, the leaf first; Key is the leaf's private key, PEM.
+	Chain []byte
+	Key   []byte
+	// ServerNames are the leaf's DNS names, which the data plane picks it
+	// by, and NotAfter when it expires.
+	ServerNames []string
+	NotAfter    time.Time
+}
+
+// Parse checks chain and key are a pair and the leaf has a DNS name
+// clients can ask for, and returns the certificate named name.
+func Parse(name string, chain, key []byte) (Certificate, error) {
+	pair, err := tls.X509KeyPair(chain, key)
+	if err != nil {
+		return Certificate{}, fmt.Errorf("certificate %s: %w", name, err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return Certificate{}, fmt.Errorf("certificate %s: %w", name, err)
+	}
+	if len(leaf.DNSNames) == 0 {
+		return Certificate{}, fmt.Errorf("certificate %s: %w", name, errors.New("no DNS names in its subject alternative names"))
+	}
+	return Certificate{
+		Name:        name,
+		Chain:       slices.Clone(chain),
+		Key:         slices.Clone(key),
+		ServerNames: slices.Clone(leaf.DNSNames),
+		NotAfter:    leaf.NotAfter,
+	}, nil
+}
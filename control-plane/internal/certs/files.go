@@ -0,0 +1,254 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"go.uber.org/zap"
+)
+
+// pollInterval is how often the certificate files' modification times are
+// checked.
+const pollInterval = 2 * time.Second
+
+// Source holds the running config. The API server is one.
+type Source interface {
+	// ProxyTLS returns the running proxy.tls.
+	ProxyTLS() config.ProxyTLSConfig
+}
+
+// FileStatus is where one of the operator's certificates stands.
+type FileStatus struct {
+	Name     string
+	CertFile string
+	KeyFile  string
+	// ServerNames and NotAfter are the certificate in use's, empty if
+	// none could be read yet.
+	ServerNames []string
+	NotAfter    time.Time
+	// Error is why the files couldn't be read when they last changed.
+	Error string
+}
+
+// Watcher keeps the operator's certificates pushed: those of
+// proxy.tls.certificates, and every <name>.crt with its <name>.key in
+// proxy.tls.directory. They're read again whenever a file's modification
+// time or size changes, and pushed straight away if that changes
+// anything. Files that can't be read, or aren't a pair, leave the
+// certificate read from them before in use; removing a pair from the
+// directory stops it being served.
+type Watcher struct {
+	source  Source
+	pusher  Pusher
+	logger  *zap.Logger
+	changed chan struct{}
+	poll    time.Duration
+
+	// stamp is the files last read, with their modification times and
+	// sizes then, and held the certificates read from them by chain file
+	stamp string
+	held  map[string]Certificate
+	// set is the certificates to push, pending while the data plane
+	// hasn't accepted them
+	set     []Certificate
+	pending bool
+
+	mu     sync.Mutex
+	status []FileStatus
+}
+
+// NewWatcher returns a watcher for source's certificate files. Nothing is
+// read until Run.
+func NewWatcher(source Source, pusher Pusher, logger *zap.Logger) *Watcher {
+	return &Watcher{
+		source:  source,
+		pusher:  pusher,
+		logger:  logger,
+		changed: make(chan struct{}, 1),
+		poll:    pollInterval,
+		held:    make(map[string]Certificate),
+	}
+}
+
+// Changed tells the watcher the running config was replaced, so changed
+// certificate settings are picked up straight away. It doesn't block, so
+// it's safe to call with the API's apply lock held.
+func (w *Watcher) Changed() {
+	select {
+	case w.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Status returns where each certificate stands, those of
+// proxy.tls.certificates first.
+func (w *Watcher) Status() []FileStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return slices.Clone(w.status)
+}
+
+// Run checks the files every poll until ctx is done.
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.poll)
+	defer ticker.Stop()
+	for {
+		w.sync(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.changed:
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync reads the files again if they changed since they were last read,
+// and pushes the certificates if that changed them. A failed read is
+// logged once per change of the files; a failed push is tried again at
+// the next poll.
+func (w *Watcher) sync(ctx context.Context) {
+	t := w.source.ProxyTLS()
+	list, dirErr := certificateFiles(t)
+	stamp := fileStamp(list)
+	if dirErr != nil {
+		stamp += dirErr.Error()
+	}
+	if stamp == w.stamp {
+		if w.pending {
+			w.push(ctx)
+		}
+		return
+	}
+	if dirErr != nil {
+		w.logger.Error("Failed to read certificate directory", zap.String("directory", t.Directory), zap.Error(dirErr))
+	}
+
+	held := make(map[string]Certificate)
+	status := make([]FileStatus, 0, len(list))
+	var set []Certificate
+	seen := make(map[string]bool)
+	for _, files := range list {
+		st := FileStatus{Name: files.Name, CertFile: files.CertFile, KeyFile: files.KeyFile}
+		if seen[files.Name] {
+			st.Error = fmt.Sprintf("certificate %s: name is used more than once", files.Name)
+			w.logger.Error("Skipping certificate files", zap.String("cert_file", files.CertFile), zap.String("error", st.Error))
+			status = append(status, st)
+			continue
+		}
+		seen[files.Name] = true
+
+		cert, err := readFiles(files)
+		prev, ok := w.held[files.CertFile]
+		switch {
+		case err == nil:
+			if !ok || !sameCertificate(prev, cert) {
+				w.logger.Info("Read certificate files", zap.String("certificate", cert.Name),
+					zap.String("cert_file", files.CertFile), zap.Time("expires", cert.NotAfter))
+			}
+		case ok:
+			st.Error = err.Error()
+			w.logger.Error("Failed to read certificate files; keeping the certificate read before",
+				zap.String("cert_file", files.CertFile), zap.Error(err))
+			cert = prev
+			cert.Name = files.Name
+		default:
+			st.Error = err.Error()
+			w.logger.Error("Failed to read certificate files", zap.String("cert_file", files.CertFile), zap.Error(err))
+			status = append(status, st)
+			continue
+		}
+		held[files.CertFile] = cert
+		set = append(set, cert)
+		st.ServerNames, st.NotAfter = cert.ServerNames, cert.NotAfter
+		status = append(status, st)
+	}
+
+	w.stamp, w.held = stamp, held
+	w.mu.Lock()
+	w.status = status
+	w.mu.Unlock()
+	if !slices.EqualFunc(set, w.set, sameCertificate) {
+		w.set, w.pending = set, true
+	}
+	if w.pending {
+		w.push(ctx)
+	}
+}
+
+func (w *Watcher) push(ctx context.Context) {
+	if err := w.pusher.UpdateSecrets(ctx, w.set); err != nil {
+		w.logger.Error("Failed to push certificate files to the data plane", zap.Int("certificates", len(w.set)), zap.Error(err))
+		return
+	}
+	w.pending = false
+	w.logger.Info("Pushed certificate files to the data plane", zap.Int("certificates", len(w.set)))
+}
+
+// certificateFiles returns the certificate files of t, those found in
+// t.Directory after t.Certificates. The error is the directory's, whose
+// pairs are left out when it can't be read.
+func certificateFiles(t config.ProxyTLSConfig) ([]config.CertificateFiles, error) {
+	list := slices.Clone(t.Certificates)
+	if t.Directory == "" {
+		return list, nil
+	}
+	entries, err := os.ReadDir(t.Directory)
+	if err != nil {
+		return list, err
+	}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".crt")
+		if !ok || name == "" || e.IsDir() {
+			continue
+		}
+		list = append(list, config.CertificateFiles{
+			Name:     name,
+			CertFile: filepath.Join(t.Directory, e.Name()),
+			KeyFile:  filepath.Join(t.Directory, name+".key"),
+		})
+	}
+	return list, nil
+}
+
+// fileStamp sums up the names, modification times and sizes of list's
+// files, missing ones included.
+func fileStamp(list []config.CertificateFiles) string {
+	var stamp strings.Builder
+	for _, files := range list {
+		stamp.WriteString(files.Name + "=")
+		for _, path := range []string{files.CertFile, files.KeyFile} {
+			if info, err := os.Stat(path); err == nil {
+				fmt.Fprintf(&stamp, "%s:%d:%d;", path, info.ModTime().UnixNano(), info.Size())
+			} else {
+				fmt.Fprintf(&stamp, "%s:-;", path)
+			}
+		}
+	}
+	return stamp.String()
+}
+
+// readFiles reads and checks one certificate's chain and key.
+func readFiles(files config.CertificateFiles) (Certificate, error) {
+	chain, err := os.ReadFile(files.CertFile)
+	if err != nil {
+		return Certificate{}, fmt.Errorf("certificate %s: %w", files.Name, err)
+	}
+	key, err := os.ReadFile(files.KeyFile)
+	if err != nil {
+		return Certificate{}, fmt.Errorf("certificate %s: %w", files.Name, err)
+	}
+	return Parse(files.Name, chain, key)
+}
+
+func sameCertificate(a, b Certificate) bool {
+	return a.Name == b.Name && bytes.Equal(a.Chain, b.Chain) && bytes.Equal(a.Key, b.Key)
+}
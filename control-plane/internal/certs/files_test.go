@@ -0,0 +1,163 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"go.uber.org/zap"
+)
+
+// newPair returns a PEM self-signed certificate for serverName and its
+// key.
+func newPair(t *testing.T, serverName string) (chain, key []byte) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: serverName},
+		DNSNames:     []string{serverName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeFile writes data to path with a modification time later than any
+// write before, however quick the writes.
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	stamp := time.Now()
+	if info, err := os.Stat(path); err == nil {
+		stamp = info.ModTime().Add(time.Second)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, stamp, stamp); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type tlsSource struct{ cfg config.ProxyTLSConfig }
+
+func (s *tlsSource) ProxyTLS() config.ProxyTLSConfig { return s.cfg }
+
+type fakePusher struct {
+	pushes [][]Certificate
+	err    error
+}
+
+func (p *fakePusher) UpdateSecrets(_ context.Context, certificates []Certificate) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.pushes = append(p.pushes, slices.Clone(certificates))
+	return nil
+}
+
+func (p *fakePusher) last() []string {
+	if len(p.pushes) == 0 {
+		return nil
+	}
+	var names []string
+	for _, c := range p.pushes[len(p.pushes)-1] {
+		names = append(names, c.Name+"="+c.ServerNames[0])
+	}
+	return names
+}
+
+func TestWatcher_PushesRotatedPairsOnly(t *testing.T) {
+	dir, sni := t.TempDir(), t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "site.pem"), filepath.Join(dir, "site-key.pem")
+	chain, key := newPair(t, "example.com")
+	writeFile(t, certPath, chain)
+	writeFile(t, keyPath, key)
+	chain, key = newPair(t, "api.example.com")
+	writeFile(t, filepath.Join(sni, "api.crt"), chain)
+	writeFile(t, filepath.Join(sni, "api.key"), key)
+	writeFile(t, filepath.Join(sni, "README"), []byte("not a certificate"))
+
+	source := &tlsSource{cfg: config.ProxyTLSConfig{
+		Certificates: []config.CertificateFiles{{Name: "site", CertFile: certPath, KeyFile: keyPath}},
+		Directory:    sni,
+	}}
+	pusher := &fakePusher{}
+	w := NewWatcher(source, pusher, zap.NewNop())
+	ctx := context.Background()
+
+	w.sync(ctx)
+	if got := pusher.last(); !slices.Equal(got, []string{"site=example.com", "api=api.example.com"}) {
+		t.Fatalf("first push: got %v", got)
+	}
+	w.sync(ctx)
+	if len(pusher.pushes) != 1 {
+		t.Fatalf("unchanged files: got %d pushes, want 1", len(pusher.pushes))
+	}
+
+	// The new chain lands before its key: the old pair stays in use
+	chain, key = newPair(t, "www.example.com")
+	writeFile(t, certPath, chain)
+	w.sync(ctx)
+	if len(pusher.pushes) != 1 {
+		t.Fatalf("mismatched pair: got %d pushes, want 1", len(pusher.pushes))
+	}
+	if st := w.Status()[0]; st.Error == "" || !slices.Equal(st.ServerNames, []string{"example.com"}) {
+		t.Errorf("mismatched pair: status %+v", st)
+	}
+	writeFile(t, keyPath, key)
+	w.sync(ctx)
+	if got := pusher.last(); !slices.Equal(got, []string{"site=www.example.com", "api=api.example.com"}) {
+		t.Fatalf("rotated pair: got %v", got)
+	}
+	if st := w.Status()[0]; st.Error != "" || st.NotAfter.IsZero() {
+		t.Errorf("rotated pair: status %+v", st)
+	}
+
+	// Removing a pair from the directory stops it being served
+	os.Remove(filepath.Join(sni, "api.crt"))
+	w.sync(ctx)
+	if got := pusher.last(); !slices.Equal(got, []string{"site=www.example.com"}) {
+		t.Fatalf("removed pair: got %v", got)
+	}
+}
+
+func TestWatcher_RetriesFailedPush(t *testing.T) {
+	dir := t.TempDir()
+	chain, key := newPair(t, "example.com")
+	writeFile(t, filepath.Join(dir, "example.crt"), chain)
+	writeFile(t, filepath.Join(dir, "example.key"), key)
+	pusher := &fakePusher{err: errors.New("data plane unavailable")}
+	w := NewWatcher(&tlsSource{cfg: config.ProxyTLSConfig{Directory: dir}}, pusher, zap.NewNop())
+
+	w.sync(context.Background())
+	pusher.err = nil
+	w.sync(context.Background())
+	if got := pusher.last(); !slices.Equal(got, []string{"example=example.com"}) {
+		t.Fatalf("retried push: got %v", got)
+	}
+}
@@ -0,0 +1,127 @@
+// Package certs serves TLS certificates for the admin API and metrics
+// server, reloading them when the files change on disk, and holds the
+// ones the data plane terminates TLS with, watching the operator's files
+// for them.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"go.uber.org/zap"
+)
+
+// Reloader holds the current TLS config for one server. Certificate
+// rotation tools (cert-manager, certbot) replace files in place, so Watch
+// polls their modification times and swaps in the new pair; handshakes in
+// flight keep the config they started with.
+type Reloader struct {
+	cfg    config.TLSServerConfig
+	logger *zap.Logger
+
+	current atomic.Pointer[tls.Config]
+	stamp   string // modification times of the files last loaded
+}
+
+// NewReloader loads cfg's certificate, key and optional client CA. An
+// error here is fatal for the caller: a server must not start without the
+// certificate it was configured with.
+func NewReloader(cfg config.TLSServerConfig, logger *zap.Logger) (*Reloader, error) {
+	r := &Reloader{cfg: cfg, logger: logger}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns a config for http.Server.TLSConfig that always hands
+// out the most recently loaded certificate and client CA.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current.Load(), nil
+		},
+	}
+}
+
+// Watch reloads the files whenever their modification time changes, until
+// ctx is cancelled. A failed reload is logged and the previous
+// certificate stays in use.
+func (r *Reloader) Watch(ctx context.Context) {
+	if r.cfg.ReloadInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(r.cfg.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			stamp, err := r.fileStamp()
+			if err != nil || stamp == r.stamp {
+				continue
+			}
+			if err := r.load(); err != nil {
+				r.logger.Error("Failed to reload TLS certificate; keeping the previous one",
+					zap.String("cert_file", r.cfg.CertFile), zap.Error(err))
+				continue
+			}
+			r.logger.Info("Reloaded TLS certificate", zap.String("cert_file", r.cfg.CertFile))
+		}
+	}
+}
+
+func (r *Reloader) fileStamp() (string, error) {
+	var stamp string
+	for _, path := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.ClientCAFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		stamp += fmt.Sprintf("%s:%d:%d;", path, info.ModTime().UnixNano(), info.Size())
+	}
+	return stamp, nil
+}
+
+func (r *Reloader) load() error {
+	stamp, err := r.fileStamp()
+	if err != nil {
+		return fmt.Errorf("failed to stat TLS files: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS key pair %q: %w", r.cfg.CertFile, err)
+	}
+
+	tlsCfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if r.cfg.ClientCAFile != "" {
+		caPEM, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA %q: %w", r.cfg.ClientCAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return fmt.Errorf("failed to parse client CA %q", r.cfg.ClientCAFile)
+		}
+		tlsCfg.ClientCAs = pool
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	r.current.Store(tlsCfg)
+	r.stamp = stamp
+	return nil
+}
@@ -0,0 +1,161 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"go.uber.org/zap"
+)
+
+// writeCert writes a self-signed certificate for localhost with the given
+// common name, and returns it parsed.
+func writeCert(t *testing.T, certPath, keyPath, cn string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+// serve starts an HTTPS server with tlsCfg and returns its address.
+func serve(t *testing.T, tlsCfg *tls.Config) string {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", tlsCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+func servedCN(t *testing.T, addr string) string {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true}) //nolint:gosec
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestReloader_PicksUpRotatedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeCert(t, certPath, keyPath, "first")
+
+	r, err := NewReloader(config.TLSServerConfig{
+		CertFile: certPath, KeyFile: keyPath, ReloadInterval: 10 * time.Millisecond,
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewReloader: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx)
+
+	addr := serve(t, r.TLSConfig())
+	if cn := servedCN(t, addr); cn != "first" {
+		t.Fatalf("initial certificate: got %q", cn)
+	}
+
+	// A broken write must not replace the working certificate.
+	os.WriteFile(certPath, []byte("garbage"), 0o600)
+	time.Sleep(50 * time.Millisecond)
+	if cn := servedCN(t, addr); cn != "first" {
+		t.Fatalf("after bad write: got %q, want the previous certificate", cn)
+	}
+
+	writeCert(t, certPath, keyPath, "second")
+	deadline := time.Now().Add(2 * time.Second)
+	for servedCN(t, addr) != "second" {
+		if time.Now().After(deadline) {
+			t.Fatal("rotated certificate never served")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReloader_RequiresClientCertWithCA(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	caCert := writeCert(t, certPath, keyPath, "server")
+
+	r, err := NewReloader(config.TLSServerConfig{
+		CertFile: certPath, KeyFile: keyPath, ClientCAFile: certPath, ReloadInterval: time.Second,
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewReloader: %v", err)
+	}
+	addr := serve(t, r.TLSConfig())
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	anonymous := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	if _, err := anonymous.Get("https://" + addr); err == nil {
+		t.Error("request without a client certificate succeeded")
+	}
+
+	// The server's own self-signed pair doubles as a client cert from the CA.
+	clientCert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authed := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs: pool, Certificates: []tls.Certificate{clientCert},
+	}}}
+	resp, err := authed.Get("https://" + addr)
+	if err != nil {
+		t.Fatalf("request with client certificate: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestNewReloader_FailsOnMissingFiles(t *testing.T) {
+	_, err := NewReloader(config.TLSServerConfig{CertFile: "/nonexistent.crt", KeyFile: "/nonexistent.key"}, zap.NewNop())
+	if err == nil {
+		t.Fatal("expected error for missing certificate files")
+	}
+}
@@ -0,0 +1,63 @@
+package certs
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// Pusher sends the data plane the certificates to terminate TLS with, the
+// first being the one for clients naming none of them. The gRPC client is
+// one.
+type Pusher interface {
+	UpdateSecrets(ctx context.Context, certificates []Certificate) error
+}
+
+// Secrets merges the certificates of several sources, such as the
+// operator's files and ACME, into the one set the data plane takes, and
+// pushes it whenever a source's change. The sources' certificates come in
+// the order the sources were added.
+type Secrets struct {
+	pusher Pusher
+
+	mu      sync.Mutex // serializes pushes, so the last set pushed wins
+	sources []string
+	sets    map[string][]Certificate
+}
+
+// NewSecrets returns a merger pushing through pusher.
+func NewSecrets(pusher Pusher) *Secrets {
+	return &Secrets{pusher: pusher, sets: make(map[string][]Certificate)}
+}
+
+// Source adds the source name, returning the Pusher it pushes its own
+// certificates through. A failed push is returned to the source that
+// made it, whose set is pushed with the next one regardless.
+func (s *Secrets) Source(name string) Pusher {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !slices.Contains(s.sources, name) {
+		s.sources = append(s.sources, name)
+	}
+	return sourcePusher{secrets: s, name: name}
+}
+
+type sourcePusher struct {
+	secrets *Secrets
+	name    string
+}
+
+func (p sourcePusher) UpdateSecrets(ctx context.Context, certificates []Certificate) error {
+	return p.secrets.update(ctx, p.name, certificates)
+}
+
+func (s *Secrets) update(ctx context.Context, source string, certificates []Certificate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sets[source] = slices.Clone(certificates)
+	var all []Certificate
+	for _, name := range s.sources {
+		all = append(all, s.sets[name]...)
+	}
+	return s.pusher.UpdateSecrets(ctx, all)
+}
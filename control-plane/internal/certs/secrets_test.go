@@ -0,0 +1,24 @@
+package certs
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestSecrets_MergesSourcesInOrder(t *testing.T) {
+	pusher := &fakePusher{}
+	secrets := NewSecrets(pusher)
+	files, acme := secrets.Source("files"), secrets.Source("acme")
+	ctx := context.Background()
+
+	acme.UpdateSecrets(ctx, []Certificate{{Name: "le", ServerNames: []string{"example.com"}}})
+	files.UpdateSecrets(ctx, []Certificate{{Name: "own", ServerNames: []string{"internal.example.com"}}})
+	if got := pusher.last(); !slices.Equal(got, []string{"own=internal.example.com", "le=example.com"}) {
+		t.Fatalf("got %v", got)
+	}
+	files.UpdateSecrets(ctx, nil)
+	if got := pusher.last(); !slices.Equal(got, []string{"le=example.com"}) {
+		t.Fatalf("after emptying files: got %v", got)
+	}
+}
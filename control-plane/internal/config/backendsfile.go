@@ -0,0 +1,55 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ReadBackendsFile reads a proxy.backends_file: a YAML or JSON list of
+// backends, each with the fields of one in proxy.backends. They get the
+// same defaults, and are marked as read from the file. An empty file is
+// no backends.
+func ReadBackendsFile(path string) ([]Backend, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backends file: %w", err)
+	}
+	var backends []Backend
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&backends); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse backends file %s: %w", path, err)
+	}
+	for i := range backends {
+		setBackendDefaults(&backends[i])
+		backends[i].Discovered, backends[i].FromFile = false, true
+	}
+	if errs := validateBackends("proxy.backends_file", backends); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid backends file %s: %s", path, strings.Join(errs, "; "))
+	}
+	return backends, nil
+}
+
+// MergeFileBackends returns backends with the ones read from the backends
+// file among them replaced by file. A backend in file that backends has
+// configured is left as configured; one it has discovered gives way to
+// the file's. Neither slice is modified.
+func MergeFileBackends(backends, file []Backend) []Backend {
+	merged := slices.DeleteFunc(slices.Clone(backends), func(b Backend) bool {
+		return b.FromFile || b.Discovered && slices.ContainsFunc(file, func(f Backend) bool { return f.Address == b.Address })
+	})
+	for _, b := range file {
+		if !slices.ContainsFunc(merged, func(s Backend) bool { return s.Address == b.Address }) {
+			b.FromFile = true
+			merged = append(merged, b)
+		}
+	}
+	return merged
+}
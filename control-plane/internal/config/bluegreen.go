@@ -0,0 +1,84 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+)
+
+// BlueGreenConfig names two backend pools of which only the active one
+// takes traffic, so a new release can be deployed to the idle pool, health
+// checked there, and cut over to at once with POST /pools/switch. Unlike a
+// traffic split it works with every algorithm.
+type BlueGreenConfig struct {
+	// Pools are the two pool names, e.g. [blue, green].
+	Pools []string `yaml:"pools,omitempty"`
+	// Active is the pool taking traffic, the first by default. The other
+	// pool's backends are still health checked but aren't given to the
+	// data plane.
+	Active string `yaml:"active,omitempty"`
+	// MinHealthyPercent of the target pool's backends must be passing
+	// their health checks for a switch to go ahead. Default 100.
+	MinHealthyPercent int `yaml:"min_healthy_percent,omitempty"`
+}
+
+// Standby returns the pool that isn't active, or "" without blue/green.
+func (bg BlueGreenConfig) Standby() string {
+	for _, pool := range bg.Pools {
+		if pool != bg.Active {
+			return pool
+		}
+	}
+	return ""
+}
+
+// ServingBackends returns backends, a version of p.Backends, as the data
+// plane is given them: weighted by the traffic split, and without the
+// backends standing by for discovery, the standby blue/green pool, the
+// mirror's shadow pool or the RoutedPools.
+func (p ProxyConfig) ServingBackends(backends []Backend) []Backend {
+	if slices.ContainsFunc(backends, func(b Backend) bool { return b.Standby }) {
+		backends = slices.DeleteFunc(slices.Clone(backends), func(b Backend) bool { return b.Standby })
+	}
+	backends = SplitWeights(backends, p.TrafficSplit)
+	standby, shadow, routed := p.BlueGreen.Standby(), p.Mirror.Pool, p.RoutedPools()
+	if standby == "" && shadow == "" && len(routed) == 0 {
+		return backends
+	}
+	serving := make([]Backend, 0, len(backends))
+	for _, b := range backends {
+		if b.Pool == "" || (b.Pool != standby && b.Pool != shadow && !slices.Contains(routed, b.Pool)) {
+			serving = append(serving, b)
+		}
+	}
+	return serving
+}
+
+// ValidateBlueGreen checks p.BlueGreen against p's TCP backends: two
+// distinct pools, each with a backend, one of them active. It's exported
+// for the backend API, which mustn't empty a pool.
+func ValidateBlueGreen(p ProxyConfig) []string {
+	bg := p.BlueGreen
+	if len(bg.Pools) == 0 {
+		return nil
+	}
+	if len(bg.Pools) != 2 || bg.Pools[0] == "" || bg.Pools[0] == bg.Pools[1] {
+		return []string{fmt.Sprintf("proxy.blue_green.pools must name two different pools, got %q", bg.Pools)}
+	}
+	var errs []string
+	members := make(map[string]int)
+	for _, b := range p.Backends {
+		members[b.Pool]++
+	}
+	for _, pool := range bg.Pools {
+		if members[pool] == 0 {
+			errs = append(errs, fmt.Sprintf("proxy.blue_green: no backend in proxy.backends has pool %q", pool))
+		}
+	}
+	if bg.Active != bg.Pools[0] && bg.Active != bg.Pools[1] {
+		errs = append(errs, fmt.Sprintf("proxy.blue_green.active must be one of %q, got %q", bg.Pools, bg.Active))
+	}
+	if bg.MinHealthyPercent < 1 || bg.MinHealthyPercent > 100 {
+		errs = append(errs, fmt.Sprintf("proxy.blue_green.min_healthy_percent must be between 1 and 100, got %d", bg.MinHealthyPercent))
+	}
+	return errs
+}
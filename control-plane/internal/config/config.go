@@ -1,8 +1,16 @@
 package config
 
 import (
+	"bytes"
+	"cmp"
 	"fmt"
+	"io"
+	"math/big"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
@@ -13,6 +21,15 @@ type Config struct {
 	Proxy ProxyConfig `yaml:"proxy"`
 	Admin AdminConfig `yaml:"admin"`
 	GRPC  GRPCConfig  `yaml:"grpc"`
+	XDS   XDSConfig   `yaml:"xds"`
+
+	AccessLog AccessLogConfig `yaml:"access_log"`
+	Events    EventsConfig    `yaml:"events"`
+	Metrics   MetricsConfig   `yaml:"metrics"`
+	Telemetry TelemetryConfig `yaml:"telemetry"`
+	Scheduler SchedulerConfig `yaml:"scheduler"`
+	HA        HAConfig        `yaml:"ha"`
+	Store     StoreConfig     `yaml:"store"`
 }
 
 type ProxyConfig struct {
@@ -22,6 +39,56 @@ type ProxyConfig struct {
 	LoadBalancing  LoadBalancingConfig  `yaml:"load_balancing"`
 	Traffic        TrafficConfig        `yaml:"traffic"`
 	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+	// TrafficSplit divides weighted traffic between backend pools by
+	// percentage, e.g. {stable: 95, canary: 5}; see SplitWeights.
+	TrafficSplit map[string]int `yaml:"traffic_split,omitempty"`
+	// BlueGreen switches all traffic between two backend pools; see
+	// ServingBackends.
+	BlueGreen BlueGreenConfig `yaml:"blue_green,omitempty"`
+	// Mirror copies a share of connections to a shadow pool; see
+	// MirrorConfig.
+	Mirror MirrorConfig `yaml:"mirror,omitempty"`
+	// HTTPRoutes give path prefixes their own retry, hedging, timeout
+	// and rate-limit settings, for HTTP listeners only.
+	HTTPRoutes []HTTPRoute `yaml:"http_routes,omitempty"`
+	// HeaderRules send connections to a pool by their HTTP request
+	// headers; see HeaderRule.
+	HeaderRules []HeaderRule `yaml:"header_rules,omitempty"`
+	// SNIRoutes send TLS connections to a pool by the server name in
+	// their ClientHello; see SNIRoute.
+	SNIRoutes []SNIRoute `yaml:"sni_routes,omitempty"`
+	// TLS terminates TLS on the TCP listener; see ProxyTLSConfig.
+	TLS ProxyTLSConfig `yaml:"tls,omitempty"`
+	// GeoIP is the database GeoRoutes place clients with.
+	GeoIP GeoIPConfig `yaml:"geoip,omitempty"`
+	// GeoRoutes send connections to a pool by the client's country or
+	// continent; see GeoRoute.
+	GeoRoutes []GeoRoute `yaml:"geo_routes,omitempty"`
+	// DarkLaunches send a share of connections to an experimental pool
+	// during time windows; see DarkLaunchRule.
+	DarkLaunches []DarkLaunchRule `yaml:"dark_launches,omitempty"`
+	// Discovery adds TCP backends found in a service registry to
+	// Backends; see DiscoveryConfig.
+	Discovery DiscoveryConfig `yaml:"discovery,omitempty"`
+	// BackendsFile is a YAML or JSON list of more TCP backends, managed
+	// apart from this file and applied whenever it changes; see
+	// ReadBackendsFile.
+	BackendsFile string `yaml:"backends_file,omitempty"`
+	// ReloadDebounce is how long health transitions are collected before
+	// they're pushed to the data plane as a single ReloadBackends, so a
+	// flapping fleet costs one push per window instead of one per flap.
+	ReloadDebounce time.Duration `yaml:"reload_debounce"`
+}
+
+// BackendAddresses lists the addresses of every TCP and UDP backend.
+func (p ProxyConfig) BackendAddresses() []string {
+	addresses := make([]string, 0, len(p.Backends)+len(p.UdpBackends))
+	for _, backends := range [][]Backend{p.Backends, p.UdpBackends} {
+		for _, b := range backends {
+			addresses = append(addresses, b.Address)
+		}
+	}
+	return addresses
 }
 
 type ListenConfig struct {
@@ -30,26 +97,114 @@ type ListenConfig struct {
 }
 
 type Backend struct {
-	Address     string            `yaml:"address"`
-	Weight      int               `yaml:"weight"`
+	Address string `yaml:"address"`
+	Weight  int    `yaml:"weight"`
+	// Pool groups backends for proxy.traffic_split.
+	Pool string `yaml:"pool,omitempty"`
+	// Priority is the backend's failover tier, 0 (the default) first: a
+	// tier only gets traffic while every backend in the tiers before it
+	// is unhealthy.
+	Priority int `yaml:"priority,omitempty"`
+	// Backup backends are a last resort: they only get traffic while
+	// every primary, whatever its priority, is unhealthy or drained to
+	// weight 0. Priority orders backups among themselves.
+	Backup bool `yaml:"backup,omitempty"`
+	// Zone and Region place the backend for
+	// proxy.load_balancing.locality, e.g. us-east-1a and us-east-1.
+	Zone        string            `yaml:"zone,omitempty"`
+	Region      string            `yaml:"region,omitempty"`
 	HealthCheck HealthCheckConfig `yaml:"health_check"`
+	// Discovered backends were found by proxy.discovery rather than
+	// configured; they're replaced by what it finds next, and never
+	// persisted.
+	Discovered bool `yaml:"discovered,omitempty"`
+	// FromFile backends were read from proxy.backends_file; they're
+	// replaced when it changes, and never persisted here.
+	FromFile bool `yaml:"from_file,omitempty"`
+	// Standby configured backends are kept out of the pool by
+	// proxy.discovery.merge while discovery finds backends in their
+	// place. They're still persisted, as configured.
+	Standby bool `yaml:"-"`
 }
 
 type HealthCheckConfig struct {
 	Interval time.Duration `yaml:"interval"`
 	Timeout  time.Duration `yaml:"timeout"`
-	Path     string        `yaml:"path"`
-	Scheme   string        `yaml:"scheme"`
+	Path     string        `yaml:"path,omitempty"`
+	Scheme   string        `yaml:"scheme,omitempty"`
 }
 
+// Load balancing algorithms. AlgorithmWeighted is an alias of
+// AlgorithmWeightedRoundRobin.
+const (
+	AlgorithmRoundRobin         = "round_robin"
+	AlgorithmWeightedRoundRobin = "weighted_round_robin"
+	AlgorithmWeighted           = "weighted"
+	AlgorithmLeastConnections   = "least_connections"
+	AlgorithmConsistentHash     = "consistent_hash"
+	// AlgorithmRandom picks a healthy backend uniformly at random.
+	AlgorithmRandom = "random"
+	// AlgorithmLatencyEWMA picks the backend with the lowest moving average
+	// connect latency weighted by its active connections.
+	AlgorithmLatencyEWMA = "latency_ewma"
+)
+
 type LoadBalancingConfig struct {
 	Algorithm       string `yaml:"algorithm"`
 	SessionAffinity bool   `yaml:"session_affinity"`
+	// Hash configures consistent_hash.
+	Hash HashConfig `yaml:"hash,omitempty"`
+	// Locality keeps traffic in the data plane's own zone.
+	Locality LocalityConfig `yaml:"locality,omitempty"`
+	// UDPAffinity sticks UDP clients to a backend.
+	UDPAffinity UDPAffinityConfig `yaml:"udp_affinity,omitempty"`
+	// StickyCookie keeps HTTP clients on a backend with a cookie.
+	StickyCookie StickyCookieConfig `yaml:"sticky_cookie,omitempty"`
+	// SlowStart ramps added backends up to their weight.
+	SlowStart SlowStartConfig `yaml:"slow_start,omitempty"`
+}
+
+// What consistent_hash hashes to pick a backend.
+const (
+	HashKeySourceIP = "source_ip"
+	HashKeyHeader   = "header"
+	HashKeyCookie   = "cookie"
+
+	HashMethodRing   = "ring"
+	HashMethodMaglev = "maglev"
+
+	// DefaultMaglevTableSize and MaxMaglevTableSize match Envoy's Maglev
+	// defaults; the size must be prime.
+	DefaultMaglevTableSize = 65537
+	MaxMaglevTableSize     = 5000011
+)
+
+// HashConfig is how consistent_hash maps connections onto backends.
+type HashConfig struct {
+	// Key is source_ip (the default), or header or cookie for the value of
+	// the HTTP header or cookie called Name in a TCP connection's first
+	// request. Connections without it, and UDP, hash the source IP.
+	Key  string `yaml:"key,omitempty"`
+	Name string `yaml:"name,omitempty"`
+	// VirtualNodes is the number of ring points per backend; more spread
+	// keys more evenly. Default 160.
+	VirtualNodes int `yaml:"virtual_nodes,omitempty"`
+	// Method is ring (the default) or maglev. A Maglev lookup table of
+	// TableSize entries spreads keys near evenly and moves close to the
+	// minimum of them when backends come and go, at the cost of
+	// rebuilding the table on every change. VirtualNodes only applies to
+	// ring, TableSize only to maglev.
+	Method    string `yaml:"method,omitempty"`
+	TableSize int    `yaml:"table_size,omitempty"`
 }
 
 type TrafficConfig struct {
-	RateLimit RateLimitConfig `yaml:"rate_limit"`
-	Timeout   TimeoutConfig   `yaml:"timeout"`
+	RateLimit       RateLimitConfig       `yaml:"rate_limit"`
+	Timeout         TimeoutConfig         `yaml:"timeout"`
+	ConnectionLimit ConnectionLimitConfig `yaml:"connection_limit,omitempty"`
+	// Retry is the retry policy of HTTP requests their route doesn't
+	// override, under xds.listener_mode http.
+	Retry RouteRetryPolicy `yaml:"retry,omitempty"`
 }
 
 type RateLimitConfig struct {
@@ -69,15 +224,344 @@ type CircuitBreakerConfig struct {
 }
 
 type AdminConfig struct {
-	APIAddress     string `yaml:"api_address"`
-	MetricsAddress string `yaml:"metrics_address"`
-	APIToken       string `yaml:"api_token"`
+	APIAddress     string         `yaml:"api_address"`
+	MetricsAddress string         `yaml:"metrics_address"`
+	APIToken       string         `yaml:"api_token"`
+	APIKeys        []APIKeyConfig `yaml:"api_keys"`
+	OIDC           OIDCConfig     `yaml:"oidc"`
+	// TLS serves the admin API over HTTPS, MetricsTLS the metrics server.
+	TLS        TLSServerConfig `yaml:"tls"`
+	MetricsTLS TLSServerConfig `yaml:"metrics_tls"`
+	// ProtectReads requires the viewer role for GET /status and /backends.
+	// Off by default so the dashboard and TUI keep working without
+	// credentials; /health is always open for orchestrator probes.
+	ProtectReads bool `yaml:"protect_reads"`
+	// PersistBackends writes backend changes made through the API back to
+	// the config file, so they survive a restart or reload. A request can
+	// override it with ?persist=true|false.
+	PersistBackends bool `yaml:"persist_backends"`
+	// RequireIfMatch rejects PUT /config and backend changes that don't
+	// carry the current config version in If-Match, so clients can't
+	// overwrite changes they haven't seen.
+	RequireIfMatch bool `yaml:"require_if_match"`
+	// DebugEndpoints serves pprof profiles and expvar at /debug on the
+	// admin API, to admins. It needs admin auth to be configured: profiles
+	// expose memory contents and command lines.
+	DebugEndpoints bool `yaml:"debug_endpoints"`
+	// Audit records every mutating admin API call.
+	Audit     AuditConfig     `yaml:"audit"`
+	Readiness ReadinessConfig `yaml:"readiness"`
+	HTTP      AdminHTTPConfig `yaml:"http"`
+}
+
+// AdminHTTPConfig bounds how slowly admin API clients may talk and how much
+// they may send, so a handful of idle or trickling connections can't tie up
+// the management port. Unset values take the defaults. The event and
+// metrics streams are exempt from the timeouts.
+type AdminHTTPConfig struct {
+	// ReadHeaderTimeout defaults to 5s, ReadTimeout to 30s, WriteTimeout to
+	// 60s and IdleTimeout to 2m.
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	// RequestTimeout bounds how long an API handler may take before the
+	// client gets 503; 30s by default. Keep it below WriteTimeout so the
+	// error can still be sent.
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	// MaxBodyBytes caps every request body; it defaults to 16 MiB, the
+	// largest config PUT /config accepts.
+	MaxBodyBytes int64 `yaml:"max_body_bytes"`
+}
+
+// ReadinessConfig picks what GET /readyz checks. Checks are "config" (a
+// config has been accepted by the data plane), "data_plane" (the gRPC
+// connection is up) and "backends" (at least MinHealthyBackends are
+// healthy); all three by default. "leader" (this replica is the ha
+// leader) routes admin traffic to the leader only.
+type ReadinessConfig struct {
+	Checks []string `yaml:"checks"`
+	// MinHealthyBackends defaults to 1.
+	MinHealthyBackends int `yaml:"min_healthy_backends"`
+}
+
+// Readiness check names.
+const (
+	ReadyCheckConfig    = "config"
+	ReadyCheckDataPlane = "data_plane"
+	ReadyCheckBackends  = "backends"
+	ReadyCheckLeader    = "leader"
+)
+
+// AuditConfig controls the admin API audit log. The last History entries
+// are always kept in memory for GET /audit; File and Syslog are optional
+// durable copies. Changing the sinks needs a restart.
+type AuditConfig struct {
+	// History defaults to 1000.
+	History int `yaml:"history"`
+	// File, when set, gets one JSON line per entry, appended.
+	File   string       `yaml:"file"`
+	Syslog SyslogConfig `yaml:"syslog"`
+}
+
+// SyslogConfig sends audit entries to syslog when Enabled. An empty
+// Network means the local syslog daemon; otherwise Network is udp, tcp or
+// unix and Address is required.
+type SyslogConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Network string `yaml:"network"`
+	Address string `yaml:"address"`
+	// Tag defaults to "aegis".
+	Tag string `yaml:"tag"`
+}
+
+// TLSServerConfig enables HTTPS on an admin-side server when CertFile is
+// set. ClientCAFile additionally requires clients to present a certificate
+// signed by that CA (mTLS). Files are re-read when they change.
+type TLSServerConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+	// ReloadInterval is how often the files are checked for changes.
+	// Defaults to 10s.
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+}
+
+// Enabled reports whether TLS is configured.
+func (t TLSServerConfig) Enabled() bool {
+	return t.CertFile != ""
+}
+
+// APIKeyConfig is one admin API credential. Name identifies the caller in
+// audit logs; it is never compared against, only Key is.
+type APIKeyConfig struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+	// Role is viewer, operator or admin; defaults to admin so keys keep
+	// the full access the single api_token always had.
+	Role string `yaml:"role"`
+}
+
+// OIDCConfig enables bearer JWT authentication against an OIDC issuer.
+// Disabled when Issuer is empty.
+type OIDCConfig struct {
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+	// RoleClaim names the claim holding the caller's roles or groups, as a
+	// string or a list of strings. Defaults to "roles".
+	RoleClaim string `yaml:"role_claim"`
+	// RoleMapping maps claim values to viewer/operator/admin. When empty,
+	// claim values are taken as role names directly. The highest mapped
+	// role wins.
+	RoleMapping map[string]string `yaml:"role_mapping"`
+	// JWKSRefresh is how long fetched signing keys are trusted before the
+	// issuer's JWKS is fetched again. Defaults to 1h.
+	JWKSRefresh time.Duration `yaml:"jwks_refresh"`
+}
+
+// Keys returns every accepted admin API key with its role filled in. The
+// single legacy api_token, if set, is included under the name "api_token".
+func (a AdminConfig) Keys() []APIKeyConfig {
+	keys := make([]APIKeyConfig, 0, len(a.APIKeys)+1)
+	for _, k := range a.APIKeys {
+		if k.Role == "" {
+			k.Role = "admin"
+		}
+		keys = append(keys, k)
+	}
+	if a.APIToken != "" {
+		keys = append(keys, APIKeyConfig{Name: "api_token", Key: a.APIToken, Role: "admin"})
+	}
+	return keys
 }
 
 type GRPCConfig struct {
-	ControlPlaneAddress string `yaml:"control_plane_address"`
-	TLSCACert           string `yaml:"tls_ca_cert"`
-	TLSSkipVerify       bool   `yaml:"tls_skip_verify"`
+	ControlPlaneAddress string      `yaml:"control_plane_address"`
+	TLSCACert           string      `yaml:"tls_ca_cert"`
+	TLSSkipVerify       bool        `yaml:"tls_skip_verify"`
+	Retry               RetryConfig `yaml:"retry"`
+	// Compression is "none" or "gzip". gzip trades CPU for bandwidth, which
+	// pays off for large configs and busy streams across a WAN link.
+	Compression string `yaml:"compression"`
+	// Message size limits in bytes. gRPC's 4 MiB default can be exceeded
+	// by a config with thousands of backends; the data plane's matching
+	// limit is AEGIS_GRPC_MAX_MESSAGE_BYTES.
+	MaxSendMsgSize int `yaml:"max_send_msg_size"`
+	MaxRecvMsgSize int `yaml:"max_recv_msg_size"`
+}
+
+// RetryConfig controls the unary retry interceptor for idempotent control
+// RPCs (UpdateConfig, ReloadBackends). MaxAttempts of 1 disables retries.
+type RetryConfig struct {
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	// Budget is a token bucket shared by all calls: each failed attempt
+	// costs one token, each success refunds BudgetTokenRatio, and retries
+	// stop while the bucket is at or below half full — so a data plane
+	// that's actually down doesn't get hammered by every caller at once.
+	BudgetMaxTokens  float64 `yaml:"budget_max_tokens"`
+	BudgetTokenRatio float64 `yaml:"budget_token_ratio"`
+}
+
+// XDSConfig switches the control plane into xDS server mode: instead of
+// dialing the Rust data plane at grpc.control_plane_address, it serves
+// CDS/EDS/LDS/RDS on Address for Envoy (or any ADS client) to consume.
+type XDSConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Address string `yaml:"address"`
+	// ListenerMode is "tcp" (tcp_proxy, same L4 behaviour as aegis-data)
+	// or "http" (HTTP connection manager with routes served over RDS).
+	ListenerMode string `yaml:"listener_mode"`
+}
+
+// AccessLogConfig controls forwarding of the data plane's access log stream
+// (one record per finished connection/session) to one or more sinks.
+type AccessLogConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// BufferSize is the per-sink queue length. When a sink falls behind,
+	// Overflow decides what happens: "drop" discards new records (counted
+	// in aegis_access_log_dropped_total), "block" stops reading the stream
+	// so gRPC flow control pushes back on the data plane instead.
+	BufferSize int             `yaml:"buffer_size"`
+	Overflow   string          `yaml:"overflow"`
+	Sinks      []AccessLogSink `yaml:"sinks"`
+}
+
+type AccessLogSink struct {
+	Type    string   `yaml:"type"` // stdout, file or kafka
+	Path    string   `yaml:"path"`
+	Brokers []string `yaml:"brokers"`
+	Topic   string   `yaml:"topic"`
+}
+
+// EventsConfig controls delivery of structured data plane events (circuit
+// breaker transitions, rate limiting, connection resets). Every event is
+// always counted in aegis_dataplane_events_total and logged; webhooks are
+// optional extra destinations.
+type EventsConfig struct {
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+}
+
+type WebhookConfig struct {
+	URL string `yaml:"url"`
+	// Types limits delivery to the listed event types (e.g. circuit_opened);
+	// empty means every type.
+	Types   []string      `yaml:"types"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// MetricsConfig selects how metrics are pulled from the data plane:
+// "stream" (StreamMetrics, the default) or "poll" (GetStats every
+// PollInterval) for networks where long-lived streams get cut.
+type MetricsConfig struct {
+	Transport    string        `yaml:"transport"`
+	PollInterval time.Duration `yaml:"poll_interval"`
+	// Namespace prefixes every exported metric name, e.g. "edge" turns
+	// proxy_active_connections into edge_proxy_active_connections.
+	Namespace string `yaml:"namespace,omitempty"`
+	// ConstLabels are added to every exported series, e.g. cluster,
+	// environment and region, so deployments sharing a Prometheus don't
+	// collide.
+	ConstLabels map[string]string `yaml:"const_labels,omitempty"`
+	// Include, when set, limits exported metrics to the names matching one
+	// of its globs (path.Match syntax, e.g. "proxy_backend_*"); Exclude
+	// then drops the names matching one of its. Names are matched before
+	// Namespace is applied.
+	Include []string `yaml:"include,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty"`
+	// Runtime exposes the control plane process's own Go runtime (GC,
+	// goroutines, memory, scheduler) and process (CPU, RSS, file
+	// descriptors) metrics alongside the application ones.
+	Runtime bool `yaml:"runtime,omitempty"`
+	// SLOs are objectives the control plane tracks against the streamed
+	// metrics, exporting their error budget burn rates.
+	SLOs []SLOConfig `yaml:"slos,omitempty"`
+	// LatencyWindow is how far back the per-backend connect latency
+	// quantiles (proxy_backend_latency_ms) look. Default 5m.
+	LatencyWindow time.Duration `yaml:"latency_window,omitempty"`
+	// TopWindow is how far back GET /api/v1/top ranks backends and
+	// clients by traffic and error rate. Default 5m.
+	TopWindow time.Duration `yaml:"top_window,omitempty"`
+}
+
+// SLOConfig is one service level objective: the fraction of connections,
+// to Backend or to every backend when it's empty, that must be good. With
+// Latency unset a connection is good unless it failed; with it set, good
+// means a backend connect latency at or under Latency, e.g. objective 0.99
+// with latency 250ms for a 250ms p99.
+type SLOConfig struct {
+	Name      string        `yaml:"name"`
+	Backend   string        `yaml:"backend,omitempty"`
+	Objective float64       `yaml:"objective"`
+	Latency   time.Duration `yaml:"latency,omitempty"`
+	// Windows are the look-back windows a burn rate is exported for, by
+	// default 5m, 30m, 1h and 6h for the usual multi-window alerts.
+	Windows []time.Duration `yaml:"windows,omitempty"`
+}
+
+// TelemetryConfig configures pushing metrics to systems other than the
+// Prometheus scrape endpoint.
+type TelemetryConfig struct {
+	OTLP        OTLPConfig        `yaml:"otlp"`
+	RemoteWrite RemoteWriteConfig `yaml:"remote_write"`
+	InfluxDB    InfluxDBConfig    `yaml:"influxdb"`
+	Graphite    GraphiteConfig    `yaml:"graphite"`
+}
+
+// OTLPConfig pushes the control plane's metrics to an OpenTelemetry
+// collector over OTLP/HTTP every Interval. An empty Endpoint disables it.
+type OTLPConfig struct {
+	// Endpoint is the collector's base URL, e.g. http://otel-collector:4318;
+	// /v1/metrics is appended unless the path already ends with it.
+	Endpoint string `yaml:"endpoint"`
+	// Headers are sent with every export, typically for authentication.
+	Headers  map[string]string `yaml:"headers,omitempty"`
+	Interval time.Duration     `yaml:"interval"`
+	Timeout  time.Duration     `yaml:"timeout"`
+}
+
+// RemoteWriteConfig pushes the control plane's metrics to a Prometheus
+// remote-write endpoint (Mimir, Thanos Receive, VictoriaMetrics, ...) every
+// Interval, for where the control plane can't be scraped. An empty URL
+// disables it.
+type RemoteWriteConfig struct {
+	// URL is the full remote-write URL, e.g. http://mimir:9009/api/v1/push.
+	URL string `yaml:"url"`
+	// Headers are sent with every push, typically Authorization or
+	// X-Scope-OrgID.
+	Headers  map[string]string `yaml:"headers,omitempty"`
+	Interval time.Duration     `yaml:"interval"`
+	Timeout  time.Duration     `yaml:"timeout"`
+}
+
+// InfluxDBConfig writes the control plane's metrics to an InfluxDB v2
+// bucket in line protocol every Interval. An empty URL disables it.
+type InfluxDBConfig struct {
+	// URL is the server's base URL, e.g. http://influxdb:8086; the write
+	// goes to its /api/v2/write.
+	URL    string `yaml:"url"`
+	Org    string `yaml:"org,omitempty"`
+	Bucket string `yaml:"bucket"`
+	// Token is sent as "Authorization: Token <token>".
+	Token    string        `yaml:"token,omitempty"`
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// GraphiteConfig pushes the control plane's metrics to a Graphite
+// server's plaintext listener every Interval, with the same series as the
+// Prometheus endpoint. An empty Host disables it.
+type GraphiteConfig struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	// Prefix is prepended to every path, e.g. "aegis.prod".
+	Prefix string `yaml:"prefix,omitempty"`
+	// Tags sends labels as Graphite tags (path;label=value) rather than
+	// path components.
+	Tags     bool          `yaml:"tags,omitempty"`
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
 }
 
 func Load(filename string) (*Config, error) {
@@ -85,32 +569,130 @@ func Load(filename string) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
+	return Parse(data)
+}
+
+// Parse is Load for a config document already in memory.
+func Parse(data []byte) (*Config, error) {
+	return parse(data, nil)
+}
+
+// ParseWithSecrets parses a config submitted over the admin API. Values
+// left as the placeholder GET /config shows in place of credentials are
+// taken from current, so a fetched config can be edited and sent back.
+// Unknown keys are rejected rather than ignored, since a typo would
+// otherwise silently drop a setting from the running config.
+func ParseWithSecrets(data []byte, current *Config) (*Config, error) {
+	return parse(data, current)
+}
 
+func parse(data []byte, current *Config) (*Config, error) {
 	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(current != nil)
+	if err := dec.Decode(&cfg); err != nil && err != io.EOF {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
 	}
+	if current != nil {
+		if err := cfg.unredact(current); err != nil {
+			return nil, err
+		}
+	}
+
+	// The file's backends get the defaults and validation of the config's
+	if cfg.Proxy.BackendsFile != "" {
+		file, err := ReadBackendsFile(cfg.Proxy.BackendsFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Proxy.Backends = MergeFileBackends(cfg.Proxy.Backends, file)
+	}
 
 	// Set defaults
 	if cfg.Proxy.LoadBalancing.Algorithm == "" {
-		cfg.Proxy.LoadBalancing.Algorithm = "round_robin"
+		cfg.Proxy.LoadBalancing.Algorithm = AlgorithmRoundRobin
 	}
-
-	for i := range cfg.Proxy.Backends {
-		if cfg.Proxy.Backends[i].Weight == 0 {
-			cfg.Proxy.Backends[i].Weight = 100
+	if cfg.Proxy.LoadBalancing.Hash.Key == "" {
+		cfg.Proxy.LoadBalancing.Hash.Key = HashKeySourceIP
+	}
+	if cfg.Proxy.LoadBalancing.Hash.VirtualNodes == 0 {
+		cfg.Proxy.LoadBalancing.Hash.VirtualNodes = 160
+	}
+	if cfg.Proxy.LoadBalancing.Hash.Method == "" {
+		cfg.Proxy.LoadBalancing.Hash.Method = HashMethodRing
+	}
+	if cfg.Proxy.LoadBalancing.Hash.TableSize == 0 {
+		cfg.Proxy.LoadBalancing.Hash.TableSize = DefaultMaglevTableSize
+	}
+	if a := &cfg.Proxy.LoadBalancing.UDPAffinity; a.Enabled() && a.IdleTimeout == 0 {
+		a.IdleTimeout = DefaultUDPIdleTimeout
+	}
+	if sc := &cfg.Proxy.LoadBalancing.StickyCookie; sc.Enabled() && sc.Path == "" {
+		sc.Path = "/"
+	}
+	for i := range cfg.Proxy.TLS.Certificates {
+		if files := &cfg.Proxy.TLS.Certificates[i]; files.Name == "" && files.CertFile != "" {
+			base := filepath.Base(files.CertFile)
+			files.Name = strings.TrimSuffix(base, filepath.Ext(base))
 		}
-		if cfg.Proxy.Backends[i].HealthCheck.Interval == 0 {
-			cfg.Proxy.Backends[i].HealthCheck.Interval = 5 * time.Second
+	}
+	if a := &cfg.Proxy.TLS.ACME; a.Enabled() {
+		a.DirectoryURL = cmp.Or(a.DirectoryURL, LetsEncryptDirectory)
+		a.Challenge = cmp.Or(a.Challenge, ACMEChallengeHTTP01)
+		a.RenewBefore = cmp.Or(a.RenewBefore, DefaultACMERenewBefore)
+		switch a.Challenge {
+		case ACMEChallengeHTTP01:
+			a.HTTPAddress = cmp.Or(a.HTTPAddress, ":80")
+		case ACMEChallengeDNS01:
+			a.DNS.PropagationTimeout = cmp.Or(a.DNS.PropagationTimeout, DefaultACMEPropagationTimeout)
+		}
+		for i := range a.Certificates {
+			if cert := &a.Certificates[i]; cert.Name == "" && len(cert.Domains) > 0 {
+				cert.Name = cert.Domains[0]
+			}
 		}
-		if cfg.Proxy.Backends[i].HealthCheck.Timeout == 0 {
-			cfg.Proxy.Backends[i].HealthCheck.Timeout = 2 * time.Second
+	}
+	if ss := &cfg.Proxy.LoadBalancing.SlowStart; ss.Enabled() {
+		if ss.InitialPercent == 0 {
+			ss.InitialPercent = DefaultSlowStartInitialPercent
 		}
-		if cfg.Proxy.Backends[i].HealthCheck.Scheme == "" {
-			cfg.Proxy.Backends[i].HealthCheck.Scheme = "http"
+		if ss.Interval == 0 {
+			ss.Interval = min(DefaultSlowStartInterval, ss.Duration)
+		}
+	}
+	if bg := &cfg.Proxy.BlueGreen; len(bg.Pools) > 0 {
+		if bg.Active == "" {
+			bg.Active = bg.Pools[0]
+		}
+		if bg.MinHealthyPercent == 0 {
+			bg.MinHealthyPercent = 100
 		}
 	}
 
+	if l := &cfg.Proxy.Traffic.ConnectionLimit; l.Overflow == "" {
+		l.Overflow = OverflowReject
+	} else if l.Queues() {
+		if l.QueueSize == 0 {
+			l.QueueSize = l.Max
+		}
+		if l.QueueTimeout == 0 {
+			l.QueueTimeout = DefaultQueueTimeout
+		}
+	}
+	if r := &cfg.Proxy.Traffic.Retry; r.Attempts > 1 && len(r.RetryOn) == 0 {
+		r.RetryOn = append([]string(nil), DefaultRetryOn...)
+	}
+	// A route without retry_on retries on proxy.traffic.retry's, if set
+	for i := range cfg.Proxy.HTTPRoutes {
+		if r := &cfg.Proxy.HTTPRoutes[i]; r.Retry.Attempts > 1 && len(r.Retry.RetryOn) == 0 && len(cfg.Proxy.Traffic.Retry.RetryOn) == 0 {
+			r.Retry.RetryOn = append([]string(nil), DefaultRetryOn...)
+		}
+	}
+
+	for i := range cfg.Proxy.Backends {
+		setBackendDefaults(&cfg.Proxy.Backends[i])
+	}
+
 	for i := range cfg.Proxy.UdpBackends {
 		if cfg.Proxy.UdpBackends[i].Weight == 0 {
 			cfg.Proxy.UdpBackends[i].Weight = 100
@@ -123,25 +705,282 @@ func Load(filename string) (*Config, error) {
 		}
 	}
 
+	if d := &cfg.Proxy.Discovery; d.Enabled() {
+		if d.Interval == 0 {
+			d.Interval = DefaultDiscoveryInterval
+		}
+		if d.Provider == DiscoveryDocker && d.DockerHost == "" {
+			d.DockerHost = DefaultDockerHost
+		}
+		if (d.Provider == DiscoveryAWS || d.Provider == DiscoveryCloudMap) && d.Region == "" {
+			d.Region = cmp.Or(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"))
+		}
+		if d.Provider == DiscoveryNomad {
+			d.NomadAddress = cmp.Or(d.NomadAddress, os.Getenv("NOMAD_ADDR"), DefaultNomadAddress)
+			d.Namespace = cmp.Or(d.Namespace, os.Getenv("NOMAD_NAMESPACE"))
+		}
+		if d.HealthCheck.Interval == 0 {
+			d.HealthCheck.Interval = 5 * time.Second
+		}
+		if d.HealthCheck.Timeout == 0 {
+			d.HealthCheck.Timeout = 2 * time.Second
+		}
+		if d.HealthCheck.Scheme == "" {
+			d.HealthCheck.Scheme = "http"
+		}
+		if d.Merge == "" {
+			d.Merge = MergeUnion
+		}
+		if d.HoldEmpty == 0 {
+			d.HoldEmpty = 2 * d.Interval
+		}
+	}
+
+	if cfg.GRPC.Retry.MaxAttempts == 0 {
+		cfg.GRPC.Retry.MaxAttempts = 3
+	}
+	if cfg.GRPC.Retry.InitialBackoff == 0 {
+		cfg.GRPC.Retry.InitialBackoff = 100 * time.Millisecond
+	}
+	if cfg.GRPC.Retry.MaxBackoff == 0 {
+		cfg.GRPC.Retry.MaxBackoff = time.Second
+	}
+	if cfg.GRPC.Retry.BudgetMaxTokens == 0 {
+		cfg.GRPC.Retry.BudgetMaxTokens = 10
+	}
+	if cfg.GRPC.Retry.BudgetTokenRatio == 0 {
+		cfg.GRPC.Retry.BudgetTokenRatio = 0.1
+	}
+
+	if cfg.GRPC.Compression == "" {
+		cfg.GRPC.Compression = "none"
+	}
+	if cfg.GRPC.MaxSendMsgSize == 0 {
+		cfg.GRPC.MaxSendMsgSize = 16 << 20
+	}
+	if cfg.GRPC.MaxRecvMsgSize == 0 {
+		cfg.GRPC.MaxRecvMsgSize = 16 << 20
+	}
+
+	if cfg.XDS.Address == "" {
+		cfg.XDS.Address = "0.0.0.0:18000"
+	}
+	if cfg.XDS.ListenerMode == "" {
+		cfg.XDS.ListenerMode = "tcp"
+	}
+
+	if cfg.AccessLog.BufferSize == 0 {
+		cfg.AccessLog.BufferSize = 1024
+	}
+	if cfg.AccessLog.Overflow == "" {
+		cfg.AccessLog.Overflow = "drop"
+	}
+	if cfg.AccessLog.Enabled && len(cfg.AccessLog.Sinks) == 0 {
+		cfg.AccessLog.Sinks = []AccessLogSink{{Type: "stdout"}}
+	}
+
+	if cfg.Proxy.ReloadDebounce == 0 {
+		cfg.Proxy.ReloadDebounce = 250 * time.Millisecond
+	}
+
+	if cfg.Metrics.Transport == "" {
+		cfg.Metrics.Transport = "stream"
+	}
+	if cfg.Metrics.PollInterval == 0 {
+		cfg.Metrics.PollInterval = 5 * time.Second
+	}
+	if cfg.Metrics.LatencyWindow == 0 {
+		cfg.Metrics.LatencyWindow = 5 * time.Minute
+	}
+	if cfg.Metrics.TopWindow == 0 {
+		cfg.Metrics.TopWindow = 5 * time.Minute
+	}
+	for i := range cfg.Metrics.SLOs {
+		if len(cfg.Metrics.SLOs[i].Windows) == 0 {
+			cfg.Metrics.SLOs[i].Windows = []time.Duration{5 * time.Minute, 30 * time.Minute, time.Hour, 6 * time.Hour}
+		}
+	}
+
+	if cfg.Telemetry.OTLP.Interval == 0 {
+		cfg.Telemetry.OTLP.Interval = 30 * time.Second
+	}
+	if cfg.Telemetry.OTLP.Timeout == 0 {
+		cfg.Telemetry.OTLP.Timeout = 10 * time.Second
+	}
+	if cfg.Telemetry.RemoteWrite.Interval == 0 {
+		cfg.Telemetry.RemoteWrite.Interval = 30 * time.Second
+	}
+	if cfg.Telemetry.RemoteWrite.Timeout == 0 {
+		cfg.Telemetry.RemoteWrite.Timeout = 10 * time.Second
+	}
+	if cfg.Telemetry.InfluxDB.Interval == 0 {
+		cfg.Telemetry.InfluxDB.Interval = 30 * time.Second
+	}
+	if cfg.Telemetry.InfluxDB.Timeout == 0 {
+		cfg.Telemetry.InfluxDB.Timeout = 10 * time.Second
+	}
+	if cfg.Telemetry.Graphite.Port == 0 {
+		cfg.Telemetry.Graphite.Port = 2003
+	}
+	if cfg.Telemetry.Graphite.Interval == 0 {
+		cfg.Telemetry.Graphite.Interval = 30 * time.Second
+	}
+	if cfg.Telemetry.Graphite.Timeout == 0 {
+		cfg.Telemetry.Graphite.Timeout = 10 * time.Second
+	}
+
+	for i := range cfg.Events.Webhooks {
+		if cfg.Events.Webhooks[i].Timeout == 0 {
+			cfg.Events.Webhooks[i].Timeout = 5 * time.Second
+		}
+	}
+
 	if token := os.Getenv("AEGIS_API_TOKEN"); token != "" {
 		cfg.Admin.APIToken = token
 	}
+	if env := os.Getenv("AEGIS_API_KEYS"); env != "" {
+		keys, err := parseAPIKeys(env)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AEGIS_API_KEYS: %w", err)
+		}
+		for _, k := range keys {
+			// A config fetched from the API and sent back already has
+			// them, so skip exact copies instead of reporting duplicates
+			if !containsKey(cfg.Admin.APIKeys, k) {
+				cfg.Admin.APIKeys = append(cfg.Admin.APIKeys, k)
+			}
+		}
+	}
+	for _, t := range []*TLSServerConfig{&cfg.Admin.TLS, &cfg.Admin.MetricsTLS} {
+		if t.ReloadInterval == 0 {
+			t.ReloadInterval = 10 * time.Second
+		}
+	}
+	if cfg.Admin.OIDC.RoleClaim == "" {
+		cfg.Admin.OIDC.RoleClaim = "roles"
+	}
+	if cfg.Admin.OIDC.JWKSRefresh == 0 {
+		cfg.Admin.OIDC.JWKSRefresh = time.Hour
+	}
+	if h := &cfg.HA; h.Enabled {
+		if h.Identity == "" {
+			h.Identity, _ = os.Hostname()
+		}
+		h.LeaseDuration = cmp.Or(h.LeaseDuration, DefaultLeaseDuration)
+		h.RenewDeadline = cmp.Or(h.RenewDeadline, DefaultRenewDeadline)
+		h.RetryPeriod = cmp.Or(h.RetryPeriod, DefaultRetryPeriod)
+		if h.Backend == HABackendKubernetes {
+			h.Lease = cmp.Or(h.Lease, "aegis-control-plane")
+		}
+		if h.Backend == HABackendEtcd {
+			h.Key = cmp.Or(h.Key, "/aegis/leader")
+		}
+	}
+	cfg.Store.Backend = cmp.Or(cfg.Store.Backend, StoreMemory)
+	cfg.Store.Timeout = cmp.Or(cfg.Store.Timeout, DefaultStoreTimeout)
+	switch st := &cfg.Store; st.Backend {
+	case StoreEtcd:
+		st.Prefix = cmp.Or(st.Prefix, "/aegis/state/")
+		st.Password = cmp.Or(os.Getenv("AEGIS_STORE_PASSWORD"), st.Password)
+	case StoreRedis:
+		st.Key = cmp.Or(st.Key, "aegis:state")
+		st.Password = cmp.Or(os.Getenv("AEGIS_STORE_PASSWORD"), st.Password)
+	}
+	if cfg.Admin.Readiness.Checks == nil {
+		cfg.Admin.Readiness.Checks = []string{ReadyCheckConfig, ReadyCheckDataPlane, ReadyCheckBackends}
+	}
+	if cfg.Admin.Readiness.MinHealthyBackends == 0 {
+		cfg.Admin.Readiness.MinHealthyBackends = 1
+	}
+	if cfg.Admin.Audit.History == 0 {
+		cfg.Admin.Audit.History = 1000
+	}
+	if cfg.Admin.Audit.Syslog.Tag == "" {
+		cfg.Admin.Audit.Syslog.Tag = "aegis"
+	}
+	if cfg.Admin.HTTP.ReadHeaderTimeout == 0 {
+		cfg.Admin.HTTP.ReadHeaderTimeout = 5 * time.Second
+	}
+	if cfg.Admin.HTTP.ReadTimeout == 0 {
+		cfg.Admin.HTTP.ReadTimeout = 30 * time.Second
+	}
+	if cfg.Admin.HTTP.WriteTimeout == 0 {
+		cfg.Admin.HTTP.WriteTimeout = time.Minute
+	}
+	if cfg.Admin.HTTP.IdleTimeout == 0 {
+		cfg.Admin.HTTP.IdleTimeout = 2 * time.Minute
+	}
+	if cfg.Admin.HTTP.RequestTimeout == 0 {
+		cfg.Admin.HTTP.RequestTimeout = 30 * time.Second
+	}
+	if cfg.Admin.HTTP.MaxBodyBytes == 0 {
+		cfg.Admin.HTTP.MaxBodyBytes = 16 << 20
+	}
 
 	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
+	if err := resolveGeoRoutes(&cfg.Proxy); err != nil {
+		return nil, err
+	}
 
 	return &cfg, nil
 }
 
-// validAlgorithms mirrors data-plane/src/load_balancer.rs's Algorithm::from_str
+// setBackendDefaults gives a TCP backend the weight and health check it
+// has when they're left out.
+func setBackendDefaults(b *Backend) {
+	if b.Weight == 0 {
+		b.Weight = 100
+	}
+	if b.HealthCheck.Interval == 0 {
+		b.HealthCheck.Interval = 5 * time.Second
+	}
+	if b.HealthCheck.Timeout == 0 {
+		b.HealthCheck.Timeout = 2 * time.Second
+	}
+	if b.HealthCheck.Scheme == "" {
+		b.HealthCheck.Scheme = "http"
+	}
+}
+
+func containsKey(keys []APIKeyConfig, k APIKeyConfig) bool {
+	for _, existing := range keys {
+		if existing == k {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAPIKeys reads the AEGIS_API_KEYS format: comma-separated name=key
+// pairs, e.g. "ci=abc123,oncall=def456".
+func parseAPIKeys(env string) ([]APIKeyConfig, error) {
+	var keys []APIKeyConfig
+	for _, entry := range strings.Split(env, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, key, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("entry %q is not name=key", entry)
+		}
+		keys = append(keys, APIKeyConfig{Name: strings.TrimSpace(name), Key: strings.TrimSpace(key)})
+	}
+	return keys, nil
+}
+
+// validAlgorithms mirrors data-plane/src/load_balancer.rs's Algorithm::parse
 // match arms — kept in sync manually since the two sides don't share types.
 var validAlgorithms = map[string]bool{
-	"round_robin":          true,
-	"weighted_round_robin": true,
-	"weighted":             true,
-	"least_connections":    true,
-	"consistent_hash":      true,
+	AlgorithmRoundRobin:         true,
+	AlgorithmWeightedRoundRobin: true,
+	AlgorithmWeighted:           true,
+	AlgorithmLeastConnections:   true,
+	AlgorithmConsistentHash:     true,
+	AlgorithmRandom:             true,
+	AlgorithmLatencyEWMA:        true,
 }
 
 // Validate rejects a config that parsed as valid YAML but is semantically
@@ -160,24 +999,118 @@ func (c *Config) Validate() error {
 	if c.Admin.MetricsAddress == "" {
 		errs = append(errs, "admin.metrics_address is required")
 	}
-	if c.GRPC.ControlPlaneAddress == "" {
+	errs = append(errs, validateAPIKeys(c.Admin.APIKeys)...)
+	errs = append(errs, validateOIDC(c.Admin.OIDC)...)
+	errs = append(errs, validateServerTLS("admin.tls", c.Admin.TLS)...)
+	errs = append(errs, validateServerTLS("admin.metrics_tls", c.Admin.MetricsTLS)...)
+	errs = append(errs, validateAudit(c.Admin.Audit)...)
+	errs = append(errs, validateReadiness(c.Admin.Readiness)...)
+	errs = append(errs, validateAdminHTTP(c.Admin.HTTP)...)
+	if c.Admin.DebugEndpoints && len(c.Admin.Keys()) == 0 && c.Admin.OIDC.Issuer == "" {
+		errs = append(errs, "admin.debug_endpoints requires admin auth: set api_token, api_keys or oidc")
+	}
+	if c.GRPC.ControlPlaneAddress == "" && !c.XDS.Enabled {
 		errs = append(errs, "grpc.control_plane_address is required")
 	}
+	if c.XDS.Enabled {
+		if c.XDS.Address == "" {
+			errs = append(errs, "xds.address is required when xds.enabled is set")
+		}
+		if m := c.XDS.ListenerMode; m != "tcp" && m != "http" {
+			errs = append(errs, fmt.Sprintf("xds.listener_mode must be \"tcp\" or \"http\", got %q", m))
+		}
+	}
 	if !validAlgorithms[c.Proxy.LoadBalancing.Algorithm] {
-		errs = append(errs, fmt.Sprintf("proxy.load_balancing.algorithm: unknown algorithm %q", c.Proxy.LoadBalancing.Algorithm))
+		errs = append(errs, fmt.Sprintf("proxy.load_balancing.algorithm: unknown algorithm %q (want round_robin, weighted_round_robin, least_connections, consistent_hash, random or latency_ewma)", c.Proxy.LoadBalancing.Algorithm))
 	}
+	errs = append(errs, validateHash(c.Proxy.LoadBalancing.Hash)...)
+	errs = append(errs, validateLocality(c.Proxy)...)
+	errs = append(errs, validateUDPAffinity(c.Proxy)...)
+	errs = append(errs, validateStickyCookie(c)...)
+	errs = append(errs, validateSlowStart(c.Proxy)...)
 	if c.Proxy.Traffic.RateLimit.RequestsPerSecond < 0 {
 		errs = append(errs, "proxy.traffic.rate_limit.requests_per_second must be >= 0")
 	}
 	if c.Proxy.Traffic.RateLimit.Burst < 0 {
 		errs = append(errs, "proxy.traffic.rate_limit.burst must be >= 0")
 	}
+	errs = append(errs, validateConnectionLimit(c)...)
 	if c.Proxy.CircuitBreaker.ErrorThreshold < 0 {
 		errs = append(errs, "proxy.circuit_breaker.error_threshold must be >= 0")
 	}
+	if c.Proxy.ReloadDebounce < 0 {
+		errs = append(errs, "proxy.reload_debounce must be >= 0")
+	}
+
+	if m := c.GRPC.Compression; m != "none" && m != "gzip" {
+		errs = append(errs, fmt.Sprintf("grpc.compression must be \"none\" or \"gzip\", got %q", m))
+	}
+	if c.GRPC.MaxSendMsgSize < 0 || c.GRPC.MaxRecvMsgSize < 0 {
+		errs = append(errs, "grpc message size limits must be >= 0")
+	}
+	if c.GRPC.Retry.MaxAttempts < 0 {
+		errs = append(errs, "grpc.retry.max_attempts must be >= 0")
+	}
+	if c.GRPC.Retry.InitialBackoff < 0 || c.GRPC.Retry.MaxBackoff < 0 {
+		errs = append(errs, "grpc.retry backoff durations must be >= 0")
+	}
+	if c.GRPC.Retry.BudgetMaxTokens < 0 || c.GRPC.Retry.BudgetTokenRatio < 0 {
+		errs = append(errs, "grpc.retry budget values must be >= 0")
+	}
+
+	if t := c.Metrics.Transport; t != "stream" && t != "poll" {
+		errs = append(errs, fmt.Sprintf("metrics.transport must be \"stream\" or \"poll\", got %q", t))
+	}
+	if c.Metrics.Transport == "poll" && c.Metrics.PollInterval <= 0 {
+		errs = append(errs, "metrics.poll_interval must be > 0")
+	}
+	if ns := c.Metrics.Namespace; ns != "" && !metricNameRE.MatchString(ns) {
+		errs = append(errs, fmt.Sprintf("metrics.namespace must be a valid metric name prefix, got %q", ns))
+	}
+	for name := range c.Metrics.ConstLabels {
+		if !labelNameRE.MatchString(name) || strings.HasPrefix(name, "__") {
+			errs = append(errs, fmt.Sprintf("metrics.const_labels: invalid label name %q", name))
+		}
+	}
+	for _, glob := range c.Metrics.Include {
+		if _, err := path.Match(glob, ""); err != nil {
+			errs = append(errs, fmt.Sprintf("metrics.include: invalid pattern %q", glob))
+		}
+	}
+	for _, glob := range c.Metrics.Exclude {
+		if _, err := path.Match(glob, ""); err != nil {
+			errs = append(errs, fmt.Sprintf("metrics.exclude: invalid pattern %q", glob))
+		}
+	}
+	if w := c.Metrics.LatencyWindow; w < 30*time.Second || w > 24*time.Hour {
+		errs = append(errs, fmt.Sprintf("metrics.latency_window must be between 30s and 24h, got %s", w))
+	}
+	if w := c.Metrics.TopWindow; w < 30*time.Second || w > 24*time.Hour {
+		errs = append(errs, fmt.Sprintf("metrics.top_window must be between 30s and 24h, got %s", w))
+	}
+
+	errs = append(errs, validateAccessLog(c.AccessLog)...)
+	errs = append(errs, validateEvents(c.Events)...)
+	errs = append(errs, validateTelemetry(c.Telemetry)...)
+	errs = append(errs, validateSLOs(c.Metrics.SLOs)...)
 
 	errs = append(errs, validateBackends("proxy.backends", c.Proxy.Backends)...)
 	errs = append(errs, validateBackends("proxy.udp_backends", c.Proxy.UdpBackends)...)
+	errs = append(errs, ValidateBackups("proxy.backends", c.Proxy.Backends)...)
+	errs = append(errs, ValidateBackups("proxy.udp_backends", c.Proxy.UdpBackends)...)
+	errs = append(errs, validateDiscovery(c.Proxy.Discovery)...)
+	errs = append(errs, ValidateTrafficSplit(c.Proxy)...)
+	errs = append(errs, ValidateBlueGreen(c.Proxy)...)
+	errs = append(errs, ValidateMirror(c.Proxy)...)
+	errs = append(errs, validateHTTPRoutes(c)...)
+	errs = append(errs, ValidateHeaderRules(c)...)
+	errs = append(errs, ValidateSNIRoutes(c)...)
+	errs = append(errs, validateProxyTLS(c)...)
+	errs = append(errs, ValidateGeoRoutes(c)...)
+	errs = append(errs, ValidateDarkLaunches(c)...)
+	errs = append(errs, validateScheduler(c.Scheduler, c.Proxy)...)
+	errs = append(errs, validateHA(c.HA)...)
+	errs = append(errs, validateStore(c.Store)...)
 
 	if len(errs) > 0 {
 		return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(errs, "\n  - "))
@@ -200,9 +1133,298 @@ func validateBackends(field string, backends []Backend) []string {
 		if b.Weight < 0 {
 			errs = append(errs, fmt.Sprintf("%s[%d] (%s): weight must be >= 0", field, i, b.Address))
 		}
+		if b.Priority < 0 || b.Priority > MaxPriority {
+			errs = append(errs, fmt.Sprintf("%s[%d] (%s): priority must be between 0 and %d", field, i, b.Address, MaxPriority))
+		}
 		if s := b.HealthCheck.Scheme; s != "" && s != "http" && s != "https" {
 			errs = append(errs, fmt.Sprintf("%s[%d] (%s): health_check.scheme must be \"http\" or \"https\", got %q", field, i, b.Address, s))
 		}
 	}
 	return errs
 }
+
+func validateAccessLog(al AccessLogConfig) []string {
+	if !al.Enabled {
+		return nil
+	}
+	var errs []string
+	if al.BufferSize < 0 {
+		errs = append(errs, "access_log.buffer_size must be >= 0")
+	}
+	if al.Overflow != "drop" && al.Overflow != "block" {
+		errs = append(errs, fmt.Sprintf("access_log.overflow must be \"drop\" or \"block\", got %q", al.Overflow))
+	}
+	for i, sink := range al.Sinks {
+		switch sink.Type {
+		case "stdout":
+		case "file":
+			if sink.Path == "" {
+				errs = append(errs, fmt.Sprintf("access_log.sinks[%d]: file sink requires path", i))
+			}
+		case "kafka":
+			if len(sink.Brokers) == 0 || sink.Topic == "" {
+				errs = append(errs, fmt.Sprintf("access_log.sinks[%d]: kafka sink requires brokers and topic", i))
+			}
+		default:
+			errs = append(errs, fmt.Sprintf("access_log.sinks[%d]: unknown sink type %q", i, sink.Type))
+		}
+	}
+	return errs
+}
+
+// metricNameRE and labelNameRE are Prometheus's rules for metric and
+// label names.
+var (
+	metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRE  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// validEventTypes mirrors the EventType enum in proto/proxy.proto, lowercased
+// the way events.TypeName renders it.
+var validEventTypes = map[string]bool{
+	"circuit_opened":      true,
+	"circuit_half_open":   true,
+	"circuit_closed":      true,
+	"rate_limit_exceeded": true,
+	"connection_reset":    true,
+}
+
+func validateEvents(ev EventsConfig) []string {
+	var errs []string
+	for i, wh := range ev.Webhooks {
+		if wh.URL == "" {
+			errs = append(errs, fmt.Sprintf("events.webhooks[%d].url is required", i))
+		} else if u, err := url.Parse(wh.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			errs = append(errs, fmt.Sprintf("events.webhooks[%d].url must be an http(s) URL, got %q", i, wh.URL))
+		}
+		if wh.Timeout < 0 {
+			errs = append(errs, fmt.Sprintf("events.webhooks[%d].timeout must be >= 0", i))
+		}
+		for _, t := range wh.Types {
+			if !validEventTypes[t] {
+				errs = append(errs, fmt.Sprintf("events.webhooks[%d]: unknown event type %q", i, t))
+			}
+		}
+	}
+	return errs
+}
+
+func validateTelemetry(t TelemetryConfig) []string {
+	var errs []string
+	if o := t.OTLP; o.Endpoint != "" {
+		errs = append(errs, validatePush("telemetry.otlp", "endpoint", o.Endpoint, o.Headers, o.Interval, o.Timeout)...)
+	}
+	if rw := t.RemoteWrite; rw.URL != "" {
+		errs = append(errs, validatePush("telemetry.remote_write", "url", rw.URL, rw.Headers, rw.Interval, rw.Timeout)...)
+	}
+	if in := t.InfluxDB; in.URL != "" {
+		errs = append(errs, validatePush("telemetry.influxdb", "url", in.URL, nil, in.Interval, in.Timeout)...)
+		if in.Bucket == "" {
+			errs = append(errs, "telemetry.influxdb.bucket is required")
+		}
+	}
+	if g := t.Graphite; g.Host != "" {
+		if g.Port < 1 || g.Port > 65535 {
+			errs = append(errs, fmt.Sprintf("telemetry.graphite.port must be 1-65535, got %d", g.Port))
+		}
+		if g.Interval <= 0 {
+			errs = append(errs, "telemetry.graphite.interval must be > 0")
+		}
+		if g.Timeout < 0 {
+			errs = append(errs, "telemetry.graphite.timeout must be >= 0")
+		}
+		if strings.ContainsAny(g.Prefix, " \t\n;") {
+			errs = append(errs, fmt.Sprintf("telemetry.graphite.prefix must not contain whitespace or ';', got %q", g.Prefix))
+		}
+	}
+	return errs
+}
+
+func validateHash(h HashConfig) []string {
+	var errs []string
+	switch h.Key {
+	case HashKeySourceIP:
+	case HashKeyHeader, HashKeyCookie:
+		if h.Name == "" {
+			errs = append(errs, fmt.Sprintf("proxy.load_balancing.hash.name is required for key %q", h.Key))
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("proxy.load_balancing.hash.key must be source_ip, header or cookie, got %q", h.Key))
+	}
+	if h.VirtualNodes < 1 || h.VirtualNodes > 10000 {
+		errs = append(errs, fmt.Sprintf("proxy.load_balancing.hash.virtual_nodes must be between 1 and 10000, got %d", h.VirtualNodes))
+	}
+	switch h.Method {
+	case HashMethodRing, HashMethodMaglev:
+	default:
+		errs = append(errs, fmt.Sprintf("proxy.load_balancing.hash.method must be ring or maglev, got %q", h.Method))
+	}
+	// Each backend's preference list only visits every slot when the size
+	// is prime
+	if h.TableSize < 2 || h.TableSize > MaxMaglevTableSize || !big.NewInt(int64(h.TableSize)).ProbablyPrime(0) {
+		errs = append(errs, fmt.Sprintf("proxy.load_balancing.hash.table_size must be a prime up to %d, got %d", MaxMaglevTableSize, h.TableSize))
+	}
+	return errs
+}
+
+func validateSLOs(slos []SLOConfig) []string {
+	var errs []string
+	seen := make(map[string]bool, len(slos))
+	for i, slo := range slos {
+		if slo.Name == "" {
+			errs = append(errs, fmt.Sprintf("metrics.slos[%d].name is required", i))
+		} else if seen[slo.Name] {
+			errs = append(errs, fmt.Sprintf("metrics.slos: duplicate name %q", slo.Name))
+		}
+		seen[slo.Name] = true
+		if slo.Objective <= 0 || slo.Objective >= 1 {
+			errs = append(errs, fmt.Sprintf("metrics.slos[%d].objective must be between 0 and 1 exclusive, got %v", i, slo.Objective))
+		}
+		if slo.Latency < 0 {
+			errs = append(errs, fmt.Sprintf("metrics.slos[%d].latency must be >= 0", i))
+		}
+		for _, w := range slo.Windows {
+			if w <= 0 {
+				errs = append(errs, fmt.Sprintf("metrics.slos[%d].windows must be > 0", i))
+				break
+			}
+		}
+	}
+	return errs
+}
+
+// validatePush checks the settings shared by the metrics push exporters.
+func validatePush(field, urlField, rawURL string, headers map[string]string, interval, timeout time.Duration) []string {
+	var errs []string
+	if u, err := url.Parse(rawURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Sprintf("%s.%s must be an http(s) URL, got %q", field, urlField, rawURL))
+	}
+	if interval <= 0 {
+		errs = append(errs, field+".interval must be > 0")
+	}
+	if timeout < 0 {
+		errs = append(errs, field+".timeout must be >= 0")
+	}
+	for name := range headers {
+		if name == "" || strings.ContainsAny(name, " :\r\n") {
+			errs = append(errs, fmt.Sprintf("%s.headers: invalid header name %q", field, name))
+		}
+	}
+	return errs
+}
+
+func validateAPIKeys(keys []APIKeyConfig) []string {
+	var errs []string
+	seen := make(map[string]bool, len(keys))
+	for i, k := range keys {
+		if k.Name == "" {
+			errs = append(errs, fmt.Sprintf("admin.api_keys[%d].name is required", i))
+		} else if seen[k.Name] {
+			errs = append(errs, fmt.Sprintf("admin.api_keys: duplicate name %q", k.Name))
+		}
+		seen[k.Name] = true
+		if k.Key == "" {
+			errs = append(errs, fmt.Sprintf("admin.api_keys[%d].key is required", i))
+		}
+		if k.Role != "" && !validRoles[k.Role] {
+			errs = append(errs, fmt.Sprintf("admin.api_keys[%d]: unknown role %q", i, k.Role))
+		}
+	}
+	return errs
+}
+
+// validRoles mirrors auth.ParseRole.
+var validRoles = map[string]bool{
+	"viewer":   true,
+	"operator": true,
+	"admin":    true,
+}
+
+func validateOIDC(o OIDCConfig) []string {
+	if o.Issuer == "" {
+		return nil
+	}
+	var errs []string
+	if u, err := url.Parse(o.Issuer); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Sprintf("admin.oidc.issuer must be an http(s) URL, got %q", o.Issuer))
+	}
+	for value, role := range o.RoleMapping {
+		if !validRoles[role] {
+			errs = append(errs, fmt.Sprintf("admin.oidc.role_mapping[%q]: unknown role %q", value, role))
+		}
+	}
+	if o.JWKSRefresh < 0 {
+		errs = append(errs, "admin.oidc.jwks_refresh must be >= 0")
+	}
+	return errs
+}
+
+func validateAudit(a AuditConfig) []string {
+	var errs []string
+	if a.History < 0 {
+		errs = append(errs, "admin.audit.history must be >= 0")
+	}
+	if a.Syslog.Enabled {
+		switch a.Syslog.Network {
+		case "":
+		case "udp", "tcp", "unix":
+			if a.Syslog.Address == "" {
+				errs = append(errs, fmt.Sprintf("admin.audit.syslog.address is required with network %q", a.Syslog.Network))
+			}
+		default:
+			errs = append(errs, fmt.Sprintf("admin.audit.syslog.network must be udp, tcp or unix, got %q", a.Syslog.Network))
+		}
+	}
+	return errs
+}
+
+func validateReadiness(r ReadinessConfig) []string {
+	var errs []string
+	for _, check := range r.Checks {
+		switch check {
+		case ReadyCheckConfig, ReadyCheckDataPlane, ReadyCheckBackends, ReadyCheckLeader:
+		default:
+			errs = append(errs, fmt.Sprintf("admin.readiness.checks: unknown check %q (want config, data_plane, backends or leader)", check))
+		}
+	}
+	if r.MinHealthyBackends < 0 {
+		errs = append(errs, "admin.readiness.min_healthy_backends must be >= 0")
+	}
+	return errs
+}
+
+func validateAdminHTTP(h AdminHTTPConfig) []string {
+	var errs []string
+	for _, t := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"read_header_timeout", h.ReadHeaderTimeout},
+		{"read_timeout", h.ReadTimeout},
+		{"write_timeout", h.WriteTimeout},
+		{"idle_timeout", h.IdleTimeout},
+		{"request_timeout", h.RequestTimeout},
+	} {
+		if t.d < 0 {
+			errs = append(errs, fmt.Sprintf("admin.http.%s must be >= 0", t.name))
+		}
+	}
+	if h.MaxBodyBytes < 0 {
+		errs = append(errs, "admin.http.max_body_bytes must be >= 0")
+	}
+	return errs
+}
+
+func validateServerTLS(prefix string, t TLSServerConfig) []string {
+	var errs []string
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		errs = append(errs, fmt.Sprintf("%s.cert_file and %s.key_file must be set together", prefix, prefix))
+	}
+	if t.ClientCAFile != "" && t.CertFile == "" {
+		errs = append(errs, fmt.Sprintf("%s.client_ca_file requires cert_file and key_file", prefix))
+	}
+	if t.ReloadInterval < 0 {
+		errs = append(errs, fmt.Sprintf("%s.reload_interval must be >= 0", prefix))
+	}
+	return errs
+}
@@ -1,7 +1,11 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -163,6 +167,161 @@ func TestLoad_MissingFileReturnsError(t *testing.T) {
 	}
 }
 
+func TestValidate_LoadBalancingAlgorithms(t *testing.T) {
+	for _, alg := range []string{"round_robin", "weighted_round_robin", "weighted", "least_connections", "consistent_hash", "random", "latency_ewma"} {
+		_, err := Load(writeTempConfig(t, strings.Replace(configWithToken, "load_balancing: {}", "load_balancing: {algorithm: "+alg+"}", 1)))
+		if err != nil {
+			t.Errorf("%s rejected: %v", alg, err)
+		}
+	}
+
+	_, err := Load(writeTempConfig(t, strings.Replace(configWithToken, "load_balancing: {}", "load_balancing: {algorithm: least_latency}", 1)))
+	if err == nil || !strings.Contains(err.Error(), `unknown algorithm "least_latency"`) {
+		t.Errorf("expected unknown algorithm error, got %v", err)
+	}
+}
+
+func TestLoad_ConsistentHashConfig(t *testing.T) {
+	cfg, err := Load(writeTempConfig(t, configWithToken))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if h := cfg.Proxy.LoadBalancing.Hash; h.Key != HashKeySourceIP || h.VirtualNodes != 160 ||
+		h.Method != HashMethodRing || h.TableSize != DefaultMaglevTableSize {
+		t.Errorf("hash defaults: got %+v", h)
+	}
+
+	cfg, err = Load(writeTempConfig(t, strings.Replace(configWithToken, "load_balancing: {}",
+		"load_balancing: {algorithm: consistent_hash, hash: {key: header, name: X-User-Id, virtual_nodes: 40}}", 1)))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if h := cfg.Proxy.LoadBalancing.Hash; h.Key != HashKeyHeader || h.Name != "X-User-Id" || h.VirtualNodes != 40 {
+		t.Errorf("hash: got %+v", h)
+	}
+
+	_, err = Load(writeTempConfig(t, strings.Replace(configWithToken, "load_balancing: {}",
+		"load_balancing: {algorithm: consistent_hash, hash: {key: cookie, virtual_nodes: -1}}", 1)))
+	for _, want := range []string{
+		`proxy.load_balancing.hash.name is required for key "cookie"`,
+		"proxy.load_balancing.hash.virtual_nodes must be between 1 and 10000",
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q, got %v", want, err)
+		}
+	}
+}
+
+func TestActiveTier(t *testing.T) {
+	backends := []Backend{
+		{Address: "a:1", Weight: 100},
+		{Address: "b:1", Weight: 100, Priority: 1},
+		{Address: "spare:1", Weight: 100, Backup: true},
+	}
+	down := func(addrs ...string) map[string]bool {
+		m := make(map[string]bool)
+		for _, a := range addrs {
+			m[a] = false
+		}
+		return m
+	}
+	for _, tc := range []struct {
+		health map[string]bool
+		want   string
+		ok     bool
+	}{
+		{nil, "0", true},
+		{down("a:1"), "1", true},
+		{down("a:1", "b:1"), "backup", true},
+		{down("a:1", "b:1", "spare:1"), "0", false},
+	} {
+		if got, ok := ActiveTier(backends, tc.health); got.String() != tc.want || ok != tc.ok {
+			t.Errorf("%v: got %s, %v; want %s, %v", tc.health, got, ok, tc.want, tc.ok)
+		}
+	}
+
+	// Drained primaries give way to a backup, but still beat nothing
+	backends[1].Weight = 0
+	if got, _ := ActiveTier(backends, down("a:1")); got.String() != "backup" {
+		t.Errorf("primaries drained: got %s, want backup", got)
+	}
+	if got, _ := ActiveTier(backends, down("a:1", "spare:1")); got.String() != "1" {
+		t.Errorf("drained primary without a backup: got %s, want 1", got)
+	}
+}
+
+func TestValidate_AllBackupsRejected(t *testing.T) {
+	cfg, err := Load(writeTempConfig(t, configWithToken))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	cfg.Proxy.Backends = []Backend{{Address: "a:1", Backup: true}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "proxy.backends: at least one backend must not be a backup") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestValidate_BackendPriority(t *testing.T) {
+	cfg, err := Load(writeTempConfig(t, configWithToken))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	cfg.Proxy.Backends = []Backend{{Address: "a:1", Priority: 3}, {Address: "b:1", Priority: MaxPriority + 1}}
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "proxy.backends[1] (b:1): priority must be between 0 and 127") ||
+		strings.Contains(err.Error(), "backends[0]") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestValidate_Locality(t *testing.T) {
+	cfg, err := Load(writeTempConfig(t, strings.Replace(configWithToken, "load_balancing: {}",
+		"load_balancing:\n    locality:\n      policy: prefer_local\n      zone: us-east-1a\n      spillover_percent: 5", 1)))
+	if err == nil || !strings.Contains(err.Error(), "prefer_local needs backends with a zone or region") {
+		t.Fatalf("Load without zoned backends: got %v", err)
+	}
+
+	cfg, err = Load(writeTempConfig(t, configWithToken))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	cfg.Proxy.Backends = []Backend{{Address: "a:1", Zone: "us-east-1a"}, {Address: "b:1", Zone: "us-east-1b"}}
+	cfg.Proxy.LoadBalancing.Locality = LocalityConfig{Policy: LocalityPreferLocal, SpilloverPercent: 5, MinHealthyPercent: 50}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("valid locality: got %v", err)
+	}
+	cfg.Proxy.LoadBalancing.Algorithm = AlgorithmConsistentHash
+	cfg.Proxy.LoadBalancing.Locality.SpilloverPercent = 101
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "can't be used with consistent_hash") ||
+		!strings.Contains(err.Error(), "spillover_percent must be between 0 and 100, got 101") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestLoad_MaglevHashConfig(t *testing.T) {
+	cfg, err := Load(writeTempConfig(t, strings.Replace(configWithToken, "load_balancing: {}",
+		"load_balancing: {algorithm: consistent_hash, hash: {method: maglev, table_size: 251}}", 1)))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if h := cfg.Proxy.LoadBalancing.Hash; h.Method != HashMethodMaglev || h.TableSize != 251 {
+		t.Errorf("hash: got %+v", h)
+	}
+
+	for _, tc := range []struct{ hash, want string }{
+		{"{method: maglev, table_size: 65536}", "proxy.load_balancing.hash.table_size must be a prime"},
+		{"{method: maglev, table_size: 5000113}", "proxy.load_balancing.hash.table_size must be a prime"},
+		{"{method: jump}", `proxy.load_balancing.hash.method must be ring or maglev, got "jump"`},
+	} {
+		_, err := Load(writeTempConfig(t, strings.Replace(configWithToken, "load_balancing: {}",
+			"load_balancing: {algorithm: consistent_hash, hash: "+tc.hash+"}", 1)))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected %q, got %v", tc.hash, tc.want, err)
+		}
+	}
+}
+
 // Regression test: valid YAML that's semantically broken (missing required
 // address, unknown algorithm, negative rate limit) used to load and apply
 // without complaint. Load() must now reject it before it reaches the data
@@ -253,3 +412,1578 @@ func TestLoad_EnvTokenEmpty_UsesConfigFile(t *testing.T) {
 		t.Errorf("token: got %q, want %q", cfg.Admin.APIToken, "from-file")
 	}
 }
+
+func TestLoad_EventWebhooks(t *testing.T) {
+	path := writeTempConfig(t, configWithToken+`
+events:
+  webhooks:
+    - url: "https://hooks.example.com/aegis"
+      types: ["circuit_opened"]
+    - url: "ftp://nope"
+      types: ["circuit_exploded"]
+`)
+	_, err := Load(path)
+	if err == nil {
+		t.Fatal("expected error for invalid webhook, got nil")
+	}
+	for _, want := range []string{
+		"events.webhooks[1].url must be an http(s) URL",
+		`unknown event type "circuit_exploded"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error missing %q, got: %v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "webhooks[0]") {
+		t.Errorf("valid webhook rejected: %v", err)
+	}
+}
+
+func TestLoad_MetricsTransport(t *testing.T) {
+	cfg, err := Load(writeTempConfig(t, configWithToken))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Metrics.Transport != "stream" || cfg.Metrics.PollInterval != 5*time.Second || cfg.Metrics.TopWindow != 5*time.Minute ||
+		cfg.Metrics.LatencyWindow != 5*time.Minute {
+		t.Errorf("metrics defaults: got %+v, want stream / 5s / 5m windows", cfg.Metrics)
+	}
+
+	_, err = Load(writeTempConfig(t, configWithToken+`
+metrics:
+  transport: carrier-pigeon
+  top_window: 1s
+  latency_window: 48h
+`))
+	if err == nil || !strings.Contains(err.Error(), `metrics.transport must be "stream" or "poll"`) {
+		t.Errorf("expected transport validation error, got %v", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "metrics.top_window must be between 30s and 24h") {
+		t.Errorf("expected top_window validation error, got %v", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "metrics.latency_window must be between 30s and 24h") {
+		t.Errorf("expected latency_window validation error, got %v", err)
+	}
+}
+
+func TestLoad_MetricsNamespaceAndConstLabels(t *testing.T) {
+	cfg, err := Load(writeTempConfig(t, configWithToken+`
+metrics:
+  namespace: edge
+  const_labels:
+    cluster: prod
+`))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Metrics.Namespace != "edge" || cfg.Metrics.ConstLabels["cluster"] != "prod" {
+		t.Errorf("metrics: got %+v", cfg.Metrics)
+	}
+
+	_, err = Load(writeTempConfig(t, configWithToken+`
+metrics:
+  namespace: "my-edge"
+  const_labels:
+    __name__: x
+    "data center": y
+  exclude: ["proxy_backend_[*"]
+`))
+	if err == nil {
+		t.Fatal("expected error for invalid namespace and labels, got nil")
+	}
+	for _, want := range []string{
+		"metrics.namespace must be a valid metric name prefix",
+		`invalid label name "__name__"`,
+		`invalid label name "data center"`,
+		`metrics.exclude: invalid pattern "proxy_backend_[*"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error missing %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestLoad_TelemetryOTLP(t *testing.T) {
+	cfg, err := Load(writeTempConfig(t, configWithToken+`
+telemetry:
+  otlp:
+    endpoint: "http://otel-collector:4318"
+    headers:
+      x-api-key: secret
+`))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if o := cfg.Telemetry.OTLP; o.Interval != 30*time.Second || o.Timeout != 10*time.Second || o.Headers["x-api-key"] != "secret" {
+		t.Errorf("otlp: got %+v, want 30s / 10s with the header", o)
+	}
+	if g := cfg.Telemetry.Graphite; g.Port != 2003 || g.Interval != 30*time.Second {
+		t.Errorf("graphite defaults: got %+v, want port 2003 every 30s", g)
+	}
+
+	_, err = Load(writeTempConfig(t, configWithToken+`
+telemetry:
+  otlp:
+    endpoint: "otel-collector:4318"
+    interval: -1s
+  remote_write:
+    url: "http://mimir:9009/api/v1/push"
+    timeout: -1s
+  influxdb:
+    url: "http://influxdb:8086"
+  graphite:
+    host: graphite
+    port: 70000
+`))
+	if err == nil {
+		t.Fatal("expected error for invalid otlp config, got nil")
+	}
+	for _, want := range []string{
+		"telemetry.otlp.endpoint must be an http(s) URL",
+		"telemetry.otlp.interval must be > 0",
+		"telemetry.remote_write.timeout must be >= 0",
+		"telemetry.influxdb.bucket is required",
+		"telemetry.graphite.port must be 1-65535",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error missing %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestLoad_SLOs(t *testing.T) {
+	cfg, err := Load(writeTempConfig(t, configWithToken+`
+metrics:
+  slos:
+    - name: availability
+      objective: 0.999
+    - name: api-latency
+      backend: "127.0.0.1:3000"
+      objective: 0.99
+      latency: 250ms
+      windows: [1h]
+`))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	slos := cfg.Metrics.SLOs
+	if len(slos) != 2 || len(slos[0].Windows) != 4 || slos[0].Windows[0] != 5*time.Minute {
+		t.Fatalf("slos: got %+v, want the default windows on the first", slos)
+	}
+	if s := slos[1]; s.Latency != 250*time.Millisecond || len(s.Windows) != 1 || s.Windows[0] != time.Hour {
+		t.Errorf("latency slo: got %+v", s)
+	}
+
+	_, err = Load(writeTempConfig(t, configWithToken+`
+metrics:
+  slos:
+    - name: a
+      objective: 1
+    - name: a
+      objective: 0.9
+      windows: [0s]
+`))
+	if err == nil {
+		t.Fatal("expected error for invalid slos, got nil")
+	}
+	for _, want := range []string{
+		"metrics.slos[0].objective must be between 0 and 1",
+		`metrics.slos: duplicate name "a"`,
+		"metrics.slos[1].windows must be > 0",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error missing %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestLoad_EnvAPIKeys(t *testing.T) {
+	path := writeTempConfig(t, configWithToken)
+
+	t.Setenv("AEGIS_API_TOKEN", "")
+	t.Setenv("AEGIS_API_KEYS", "ci=abc123, oncall=def456")
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	keys := cfg.Admin.Keys()
+	if len(keys) != 3 || keys[0] != (APIKeyConfig{Name: "ci", Key: "abc123", Role: "admin"}) || keys[1].Name != "oncall" {
+		t.Errorf("keys: got %+v", keys)
+	}
+	if keys[2] != (APIKeyConfig{Name: "api_token", Key: "from-file", Role: "admin"}) {
+		t.Errorf("legacy api_token not included as a key: %+v", keys[2])
+	}
+
+	t.Setenv("AEGIS_API_KEYS", "ci=abc,ci=def")
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), `duplicate name "ci"`) {
+		t.Errorf("expected duplicate name error, got %v", err)
+	}
+
+	t.Setenv("AEGIS_API_KEYS", "no-separator")
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "AEGIS_API_KEYS") {
+		t.Errorf("expected malformed env error, got %v", err)
+	}
+}
+
+func TestValidate_ServerTLS(t *testing.T) {
+	cfg, err := Load(writeTempConfig(t, configWithToken))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Admin.TLS.Enabled() || cfg.Admin.TLS.ReloadInterval != 10*time.Second {
+		t.Errorf("tls defaults: got %+v", cfg.Admin.TLS)
+	}
+
+	cfg.Admin.TLS = TLSServerConfig{CertFile: "/etc/aegis/tls.crt"}
+	cfg.Admin.MetricsTLS = TLSServerConfig{ClientCAFile: "/etc/aegis/ca.crt"}
+	err = cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, want := range []string{
+		"admin.tls.cert_file and admin.tls.key_file must be set together",
+		"admin.metrics_tls.client_ca_file requires cert_file and key_file",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error missing %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestValidate_Audit(t *testing.T) {
+	cfg, err := Load(writeTempConfig(t, configWithToken))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Admin.Audit.History != 1000 || cfg.Admin.Audit.Syslog.Tag != "aegis" {
+		t.Errorf("audit defaults: got %+v", cfg.Admin.Audit)
+	}
+
+	cfg.Admin.Audit.History = -1
+	cfg.Admin.Audit.Syslog = SyslogConfig{Enabled: true, Network: "tcp"}
+	err = cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, want := range []string{
+		"admin.audit.history must be >= 0",
+		`admin.audit.syslog.address is required with network "tcp"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error missing %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestValidate_Readiness(t *testing.T) {
+	cfg, err := Load(writeTempConfig(t, configWithToken))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(cfg.Admin.Readiness.Checks) != 3 || cfg.Admin.Readiness.MinHealthyBackends != 1 {
+		t.Errorf("readiness defaults: got %+v", cfg.Admin.Readiness)
+	}
+
+	cfg, err = Load(writeTempConfig(t, strings.Replace(configWithToken, "admin:\n", "admin:\n  readiness:\n    checks: []\n", 1)))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(cfg.Admin.Readiness.Checks) != 0 {
+		t.Errorf("explicitly empty checks replaced by defaults: %v", cfg.Admin.Readiness.Checks)
+	}
+
+	cfg.Admin.Readiness.Checks = []string{"backends", "vibes"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), `unknown check "vibes"`) {
+		t.Errorf("expected unknown check error, got %v", err)
+	}
+}
+
+func TestValidate_AdminHTTP(t *testing.T) {
+	cfg, err := Load(writeTempConfig(t, strings.Replace(configWithToken, "admin:\n", "admin:\n  http:\n    write_timeout: 5s\n", 1)))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	h := cfg.Admin.HTTP
+	if h.WriteTimeout != 5*time.Second || h.ReadHeaderTimeout != 5*time.Second || h.IdleTimeout != 2*time.Minute || h.MaxBodyBytes != 16<<20 {
+		t.Errorf("admin.http: got %+v", h)
+	}
+
+	cfg.Admin.HTTP.ReadTimeout = -time.Second
+	cfg.Admin.HTTP.MaxBodyBytes = -1
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "admin.http.read_timeout") || !strings.Contains(err.Error(), "admin.http.max_body_bytes") {
+		t.Errorf("expected admin.http errors, got %v", err)
+	}
+}
+
+func TestValidate_DebugEndpointsNeedAuth(t *testing.T) {
+	cfg, err := Load(writeTempConfig(t, configWithToken))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	cfg.Admin.DebugEndpoints = true
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("with a token: %v", err)
+	}
+	cfg.Admin.APIToken = ""
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "admin.debug_endpoints requires admin auth") {
+		t.Errorf("without auth: got %v", err)
+	}
+}
+
+func TestValidateTrafficSplit(t *testing.T) {
+	p := ProxyConfig{
+		Backends: []Backend{
+			{Address: "a:1", Weight: 100, Pool: "stable"},
+			{Address: "b:1", Weight: 100, Pool: "canary"},
+		},
+		LoadBalancing: LoadBalancingConfig{Algorithm: "weighted_round_robin"},
+		TrafficSplit:  map[string]int{"stable": 95, "canary": 5},
+	}
+	if errs := ValidateTrafficSplit(p); len(errs) > 0 {
+		t.Errorf("valid split: %v", errs)
+	}
+
+	for name, tc := range map[string]struct {
+		split     map[string]int
+		algorithm string
+		want      string
+	}{
+		"total":     {map[string]int{"stable": 90, "canary": 5}, "weighted", "must total 100"},
+		"empty":     {map[string]int{"stable": 100, "blue": 0}, "weighted", `no backend in proxy.backends has pool "blue"`},
+		"range":     {map[string]int{"stable": 110, "canary": -10}, "weighted", "between 0 and 100"},
+		"algorithm": {map[string]int{"stable": 100}, "round_robin", "needs proxy.load_balancing.algorithm"},
+	} {
+		p.TrafficSplit, p.LoadBalancing.Algorithm = tc.split, tc.algorithm
+		errs := ValidateTrafficSplit(p)
+		if !strings.Contains(strings.Join(errs, "\n"), tc.want) {
+			t.Errorf("%s: got %v, want %q", name, errs, tc.want)
+		}
+	}
+}
+
+func TestSplitWeights(t *testing.T) {
+	backends := []Backend{
+		{Address: "a:1", Weight: 100, Pool: "stable"},
+		{Address: "b:1", Weight: 100, Pool: "stable"},
+		{Address: "c:1", Weight: 200, Pool: "stable"},
+		{Address: "d:1", Weight: 100, Pool: "canary"},
+		{Address: "e:1", Weight: 100},
+	}
+	got := SplitWeights(backends, map[string]int{"stable": 80, "canary": 20})
+	want := []int{1, 1, 2, 1, 0}
+	for i, b := range got {
+		if b.Weight != want[i] {
+			t.Errorf("%s: got weight %d, want %d", b.Address, b.Weight, want[i])
+		}
+	}
+	if backends[0].Weight != 100 {
+		t.Error("SplitWeights modified its input")
+	}
+	if got := SplitWeights(backends, nil); got[4].Weight != 100 {
+		t.Errorf("without a split: got %+v", got)
+	}
+}
+
+func TestValidateBlueGreen(t *testing.T) {
+	p := ProxyConfig{
+		Backends: []Backend{
+			{Address: "a:1", Weight: 100, Pool: "blue"},
+			{Address: "b:1", Weight: 100, Pool: "green"},
+			{Address: "c:1", Weight: 100},
+		},
+		BlueGreen: BlueGreenConfig{Pools: []string{"blue", "green"}, Active: "green", MinHealthyPercent: 100},
+	}
+	if errs := ValidateBlueGreen(p); len(errs) > 0 {
+		t.Errorf("valid blue/green: %v", errs)
+	}
+	// The standby pool is left out; backends in neither pool keep serving
+	serving := p.ServingBackends(p.Backends)
+	if len(serving) != 2 || serving[0].Address != "b:1" || serving[1].Address != "c:1" {
+		t.Errorf("serving: got %+v", serving)
+	}
+
+	for name, tc := range map[string]struct {
+		bg   BlueGreenConfig
+		want string
+	}{
+		"pools":  {BlueGreenConfig{Pools: []string{"blue", "blue"}, Active: "blue", MinHealthyPercent: 100}, "must name two different pools"},
+		"empty":  {BlueGreenConfig{Pools: []string{"blue", "red"}, Active: "blue", MinHealthyPercent: 100}, `no backend in proxy.backends has pool "red"`},
+		"active": {BlueGreenConfig{Pools: []string{"blue", "green"}, Active: "red", MinHealthyPercent: 100}, "active must be one of"},
+		"min":    {BlueGreenConfig{Pools: []string{"blue", "green"}, Active: "blue", MinHealthyPercent: 0}, "min_healthy_percent must be between 1 and 100"},
+	} {
+		p.BlueGreen = tc.bg
+		if errs := ValidateBlueGreen(p); !strings.Contains(strings.Join(errs, "\n"), tc.want) {
+			t.Errorf("%s: got %v, want %q", name, errs, tc.want)
+		}
+	}
+
+	p.TrafficSplit = map[string]int{"blue": 100}
+	if errs := ValidateTrafficSplit(p); !strings.Contains(strings.Join(errs, "\n"), "can't be combined with proxy.blue_green") {
+		t.Errorf("with a traffic split: got %v", errs)
+	}
+}
+
+func TestValidateMirror(t *testing.T) {
+	p := ProxyConfig{
+		Backends: []Backend{
+			{Address: "a:1", Weight: 100},
+			{Address: "b:1", Weight: 100, Pool: "shadow"},
+		},
+		Mirror: MirrorConfig{Pool: "shadow", Percent: 10},
+	}
+	if errs := ValidateMirror(p); len(errs) > 0 {
+		t.Errorf("valid mirror: %v", errs)
+	}
+	if serving := p.ServingBackends(p.Backends); len(serving) != 1 || serving[0].Address != "a:1" {
+		t.Errorf("serving: got %+v", serving)
+	}
+
+	for name, tc := range map[string]struct {
+		edit func(p *ProxyConfig)
+		want string
+	}{
+		"percent": {func(p *ProxyConfig) { p.Mirror.Percent = 101 }, "between 0 and 100"},
+		"empty":   {func(p *ProxyConfig) { p.Mirror.Pool = "canary" }, `no backend in proxy.backends has pool "canary"`},
+		"all":     {func(p *ProxyConfig) { p.Backends = p.Backends[1:] }, "leaving none to serve"},
+		"split":   {func(p *ProxyConfig) { p.TrafficSplit = map[string]int{"shadow": 100} }, "proxy.traffic_split"},
+		"bg":      {func(p *ProxyConfig) { p.BlueGreen.Pools = []string{"blue", "shadow"} }, "proxy.blue_green pool"},
+	} {
+		q := p
+		tc.edit(&q)
+		if errs := ValidateMirror(q); !strings.Contains(strings.Join(errs, "\n"), tc.want) {
+			t.Errorf("%s: got %v, want %q", name, errs, tc.want)
+		}
+	}
+}
+
+func TestValidateHTTPRoutes(t *testing.T) {
+	c := Config{Proxy: ProxyConfig{HTTPRoutes: []HTTPRoute{
+		{Name: "api", Prefix: "/api/", Retry: RouteRetryPolicy{Attempts: 3}},
+		{Name: "search", Prefix: "/search/",
+			Retry: RouteRetryPolicy{Attempts: 2, Idempotent: true},
+			Hedge: HedgePolicy{Delay: 50 * time.Millisecond}},
+	}}}
+	if errs := validateHTTPRoutes(&c); len(errs) > 0 {
+		t.Errorf("valid routes: %v", errs)
+	}
+
+	for name, tc := range map[string]struct {
+		edit func(c *Config)
+		want string
+	}{
+		"unsafe":   {func(c *Config) { c.Proxy.HTTPRoutes[1].Retry.Idempotent = false }, "hedge needs retry.idempotent"},
+		"attempts": {func(c *Config) { c.Proxy.HTTPRoutes[1].Retry.Attempts = 1 }, "retry.attempts of at least 2"},
+		"timeouts": {func(c *Config) { c.Proxy.HTTPRoutes[1].Retry.PerTryTimeout = time.Second }, "not both"},
+		"prefix":   {func(c *Config) { c.Proxy.HTTPRoutes[1].Prefix = "search" }, "must start with /"},
+		"dup":      {func(c *Config) { c.Proxy.HTTPRoutes[1].Name = "api" }, `duplicate name "api"`},
+		"tcp":      {func(c *Config) { c.XDS = XDSConfig{Enabled: true, ListenerMode: "tcp"} }, "xds.listener_mode http"},
+		"hedge timeout": {func(c *Config) { c.Proxy.HTTPRoutes[1].Timeout.Request = 50 * time.Millisecond },
+			"hedge.delay 50ms must be shorter than timeout.request 50ms"},
+		// A per-try timeout inherited from proxy.traffic.retry conflicts too
+		"inherited try": {func(c *Config) {
+			c.Proxy.Traffic.Retry.PerTryTimeout = 2 * time.Second
+			c.Proxy.HTTPRoutes[0].Timeout.Request = time.Second
+		}, "http_routes[0]: retry.per_try_timeout 2s must be shorter than timeout.request 1s"},
+		"burst":  {func(c *Config) { c.Proxy.HTTPRoutes[0].RateLimit.Burst = 10 }, "rate_limit.burst needs requests_per_second"},
+		"read":   {func(c *Config) { c.Proxy.HTTPRoutes[0].Timeout.Read = -time.Second }, "timeout.read must be >= 0"},
+		"global": {func(c *Config) { c.Proxy.Traffic.Retry.Attempts = -1 }, "proxy.traffic.retry.attempts must be >= 0"},
+		"global tcp": {func(c *Config) {
+			c.Proxy.HTTPRoutes = nil
+			c.Proxy.Traffic.Retry.Attempts = 2
+			c.XDS = XDSConfig{Enabled: true, ListenerMode: "tcp"}
+		}, "proxy.traffic.retry needs xds.listener_mode http"},
+	} {
+		q := c
+		q.Proxy.HTTPRoutes = append([]HTTPRoute(nil), c.Proxy.HTTPRoutes...)
+		tc.edit(&q)
+		if errs := validateHTTPRoutes(&q); !strings.Contains(strings.Join(errs, "\n"), tc.want) {
+			t.Errorf("%s: got %v, want %q", name, errs, tc.want)
+		}
+	}
+}
+
+func TestRouteTraffic(t *testing.T) {
+	p := ProxyConfig{Traffic: TrafficConfig{
+		Timeout: TimeoutConfig{Read: 30 * time.Second},
+		Retry:   RouteRetryPolicy{Attempts: 3, PerTryTimeout: time.Second, RetryOn: []string{"5xx"}, Idempotent: true},
+	}}
+	if got := p.RouteTraffic(HTTPRoute{}); got.Retry.Attempts != 3 || got.ReadTimeout != 30*time.Second || got.RateLimit.RequestsPerSecond != 0 {
+		t.Errorf("defaults: got %+v", got)
+	}
+
+	// What the route sets wins, field by field
+	got := p.RouteTraffic(HTTPRoute{
+		Retry:     RouteRetryPolicy{Attempts: 2, RetryOn: []string{"reset"}},
+		Timeout:   RouteTimeout{Read: 5 * time.Second},
+		RateLimit: RateLimitConfig{RequestsPerSecond: 20},
+	})
+	if got.Retry.Attempts != 2 || got.Retry.PerTryTimeout != time.Second || strings.Join(got.Retry.RetryOn, ",") != "reset" || !got.Retry.Idempotent {
+		t.Errorf("retry: got %+v", got.Retry)
+	}
+	if got.ReadTimeout != 5*time.Second || got.RateLimit != (RateLimitConfig{RequestsPerSecond: 20, Burst: 20}) {
+		t.Errorf("timeout and rate limit: got %+v", got)
+	}
+
+	// A hedge delay takes the place of the inherited per-try timeout
+	got = p.RouteTraffic(HTTPRoute{Hedge: HedgePolicy{Delay: 50 * time.Millisecond}})
+	if !got.Hedged() || got.Retry.PerTryTimeout != 0 {
+		t.Errorf("hedged: got %+v", got)
+	}
+	// Retrying without any retry_on gets the default
+	got = ProxyConfig{}.RouteTraffic(HTTPRoute{Retry: RouteRetryPolicy{Attempts: 2}})
+	if strings.Join(got.Retry.RetryOn, ",") != "5xx,reset,connect-failure" {
+		t.Errorf("retry_on default: got %v", got.Retry.RetryOn)
+	}
+}
+
+func TestValidateHeaderRules(t *testing.T) {
+	c := Config{Proxy: ProxyConfig{
+		Backends: []Backend{{Address: "a:1", Pool: "prod"}, {Address: "b:1", Pool: "staging"}},
+		HeaderRules: []HeaderRule{{
+			Name:  "debug",
+			Match: []HeaderMatch{{Header: "X-Debug", Exact: "1"}, {Header: "User-Agent", Regex: "^internal-"}},
+			Pool:  "staging",
+		}},
+	}}
+	if errs := ValidateHeaderRules(&c); len(errs) > 0 {
+		t.Errorf("valid rules: %v", errs)
+	}
+	if serving := c.Proxy.ServingBackends(c.Proxy.Backends); len(serving) != 1 || serving[0].Address != "a:1" {
+		t.Errorf("serving: got %v", serving)
+	}
+
+	for name, tc := range map[string]struct {
+		edit func(c *Config)
+		want string
+	}{
+		"both":    {func(c *Config) { c.Proxy.HeaderRules[0].Match[0].Regex = "1" }, "set one of exact or regex"},
+		"regex":   {func(c *Config) { c.Proxy.HeaderRules[0].Match[1].Regex = "(" }, "match[1].regex"},
+		"header":  {func(c *Config) { c.Proxy.HeaderRules[0].Match[0].Header = "X Debug" }, "must be a header name"},
+		"empty":   {func(c *Config) { c.Proxy.HeaderRules[0].Match = nil }, "needs at least one header"},
+		"pool":    {func(c *Config) { c.Proxy.HeaderRules[0].Pool = "qa" }, `no backend in proxy.backends has pool "qa"`},
+		"all":     {func(c *Config) { c.Proxy.Backends = c.Proxy.Backends[1:] }, "leaving none to serve"},
+		"split":   {func(c *Config) { c.Proxy.TrafficSplit = map[string]int{"prod": 90, "staging": 10} }, "can't also be in proxy.traffic_split"},
+		"mirror":  {func(c *Config) { c.Proxy.Mirror.Pool = "staging" }, "can't also be the proxy.mirror pool"},
+		"tcp xds": {func(c *Config) { c.XDS = XDSConfig{Enabled: true, ListenerMode: "tcp"} }, "xds.listener_mode http"},
+	} {
+		q := c
+		q.Proxy.HeaderRules = []HeaderRule{c.Proxy.HeaderRules[0]}
+		q.Proxy.HeaderRules[0].Match = append([]HeaderMatch(nil), c.Proxy.HeaderRules[0].Match...)
+		tc.edit(&q)
+		if errs := ValidateHeaderRules(&q); !strings.Contains(strings.Join(errs, "\n"), tc.want) {
+			t.Errorf("%s: got %v, want %q", name, errs, tc.want)
+		}
+	}
+}
+
+func TestValidateSNIRoutes(t *testing.T) {
+	c := Config{Proxy: ProxyConfig{
+		Backends: []Backend{{Address: "a:1", Pool: "web"}, {Address: "b:1", Pool: "api"}, {Address: "c:1", Pool: "admin"}},
+		SNIRoutes: []SNIRoute{
+			{ServerNames: []string{"api.example.com", "*.api.example.com"}, Pool: "api"},
+			{ServerNames: []string{"admin.example.com"}, Pool: "admin"},
+		},
+	}}
+	if errs := ValidateSNIRoutes(&c); len(errs) > 0 {
+		t.Errorf("valid routes: %v", errs)
+	}
+	if serving := c.Proxy.ServingBackends(c.Proxy.Backends); len(serving) != 1 || serving[0].Address != "a:1" {
+		t.Errorf("serving: got %v", serving)
+	}
+
+	for name, tc := range map[string]struct {
+		edit func(c *Config)
+		want string
+	}{
+		"upper":     {func(c *Config) { c.Proxy.SNIRoutes[0].ServerNames[0] = "API.example.com" }, "must be a lowercase host name"},
+		"wildcard":  {func(c *Config) { c.Proxy.SNIRoutes[0].ServerNames[0] = "api.*.com" }, "must be a lowercase host name"},
+		"duplicate": {func(c *Config) { c.Proxy.SNIRoutes[1].ServerNames[0] = "api.example.com" }, "in more than one route"},
+		"empty":     {func(c *Config) { c.Proxy.SNIRoutes[1].ServerNames = nil }, "needs at least one name"},
+		"pool":      {func(c *Config) { c.Proxy.SNIRoutes[1].Pool = "qa" }, `no backend in proxy.backends has pool "qa"`},
+		"all":       {func(c *Config) { c.Proxy.Backends = c.Proxy.Backends[1:] }, "leaving none to serve"},
+		"split":     {func(c *Config) { c.Proxy.TrafficSplit = map[string]int{"web": 90, "api": 10} }, "can't also be in proxy.traffic_split"},
+		"rules": {func(c *Config) {
+			c.Proxy.HeaderRules = []HeaderRule{{Name: "debug", Match: []HeaderMatch{{Header: "X-Debug", Exact: "1"}}, Pool: "web"}}
+		}, "can't be combined with proxy.header_rules"},
+		"hash key": {func(c *Config) {
+			c.Proxy.LoadBalancing = LoadBalancingConfig{Algorithm: AlgorithmConsistentHash, Hash: HashConfig{Key: "cookie"}}
+		}, "a cookie hash key"},
+		"http xds": {func(c *Config) { c.XDS = XDSConfig{Enabled: true, ListenerMode: "http"} }, "xds.listener_mode tcp"},
+	} {
+		q := c
+		q.Proxy.SNIRoutes = make([]SNIRoute, len(c.Proxy.SNIRoutes))
+		for i, r := range c.Proxy.SNIRoutes {
+			q.Proxy.SNIRoutes[i] = SNIRoute{ServerNames: append([]string(nil), r.ServerNames...), Pool: r.Pool}
+		}
+		tc.edit(&q)
+		if errs := ValidateSNIRoutes(&q); !strings.Contains(strings.Join(errs, "\n"), tc.want) {
+			t.Errorf("%s: got %v, want %q", name, errs, tc.want)
+		}
+	}
+}
+
+func TestValidateGeoRoutes(t *testing.T) {
+	c := Config{Proxy: ProxyConfig{
+		Backends: []Backend{{Address: "a:1", Pool: "us"}, {Address: "b:1", Pool: "eu"}, {Address: "c:1", Pool: "apac"}},
+		GeoIP:    GeoIPConfig{Database: "/var/lib/GeoIP/GeoLite2-Country.mmdb"},
+		GeoRoutes: []GeoRoute{
+			{Name: "eu", Continents: []string{"EU"}, Countries: []string{"GB", "CH"}, Pool: "eu"},
+			{Name: "apac", Continents: []string{"AS", "OC"}, Pool: "apac"},
+		},
+	}}
+	if errs := ValidateGeoRoutes(&c); len(errs) > 0 {
+		t.Errorf("valid routes: %v", errs)
+	}
+	if serving := c.Proxy.ServingBackends(c.Proxy.Backends); len(serving) != 1 || serving[0].Address != "a:1" {
+		t.Errorf("serving: got %v", serving)
+	}
+
+	for name, tc := range map[string]struct {
+		edit func(c *Config)
+		want string
+	}{
+		"database":  {func(c *Config) { c.Proxy.GeoIP.Database = "" }, "need proxy.geoip.database"},
+		"country":   {func(c *Config) { c.Proxy.GeoRoutes[0].Countries[0] = "gb" }, "must be an uppercase ISO 3166-1 alpha-2 code"},
+		"continent": {func(c *Config) { c.Proxy.GeoRoutes[1].Continents[0] = "APAC" }, "must be one of AF, AN, AS, EU, NA, OC or SA"},
+		"empty":     {func(c *Config) { c.Proxy.GeoRoutes[1].Continents = nil }, "needs countries or continents"},
+		"duplicate": {func(c *Config) { c.Proxy.GeoRoutes[1].Name = "eu" }, `duplicate name "eu"`},
+		"pool":      {func(c *Config) { c.Proxy.GeoRoutes[1].Pool = "qa" }, `no backend in proxy.backends has pool "qa"`},
+		"all":       {func(c *Config) { c.Proxy.Backends = c.Proxy.Backends[1:] }, "leaving none to serve"},
+		"mirror":    {func(c *Config) { c.Proxy.Mirror = MirrorConfig{Pool: "eu", Percent: 10} }, "can't also be the proxy.mirror pool"},
+		"sni": {func(c *Config) {
+			c.Proxy.SNIRoutes = []SNIRoute{{ServerNames: []string{"api.example.com"}, Pool: "us"}}
+		}, "can't be combined with proxy.sni_routes"},
+		"http xds": {func(c *Config) { c.XDS = XDSConfig{Enabled: true, ListenerMode: "http"} }, "xds.listener_mode tcp"},
+	} {
+		q := c
+		q.Proxy.GeoRoutes = make([]GeoRoute, len(c.Proxy.GeoRoutes))
+		for i, r := range c.Proxy.GeoRoutes {
+			q.Proxy.GeoRoutes[i] = GeoRoute{Name: r.Name, Countries: slices.Clone(r.Countries), Continents: slices.Clone(r.Continents), Pool: r.Pool}
+		}
+		tc.edit(&q)
+		if errs := ValidateGeoRoutes(&q); !strings.Contains(strings.Join(errs, "\n"), tc.want) {
+			t.Errorf("%s: got %v, want %q", name, errs, tc.want)
+		}
+	}
+}
+
+func TestLoad_GeoRoutesDatabase(t *testing.T) {
+	database := filepath.Join(t.TempDir(), "missing.mmdb")
+	_, err := Load(writeTempConfig(t, strings.Replace(configWithToken, "backends: []",
+		"backends:\n    - address: a:1\n    - address: b:1\n      pool: eu\n  geoip:\n    database: "+database+
+			"\n  geo_routes:\n    - name: eu\n      continents: [EU]\n      pool: eu", 1)))
+	if err == nil || !strings.Contains(err.Error(), "proxy.geoip.database") {
+		t.Errorf("missing database: got %v", err)
+	}
+}
+
+func TestDarkLaunchRule_Windows(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no zone data: %v", err)
+	}
+	r := DarkLaunchRule{Windows: []TimeWindow{
+		{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "09:00", End: "17:00"},
+		// Friday night into Saturday
+		{Days: []string{"fri"}, Start: "22:00", End: "02:00"},
+	}}
+	// Friday 16 October 2026
+	at := func(day, hour, minute int) time.Time { return time.Date(2026, 10, day, hour, minute, 0, 0, berlin) }
+	for _, tc := range []struct {
+		t      time.Time
+		active bool
+		next   time.Time
+	}{
+		{at(16, 8, 59), false, at(16, 9, 0)},
+		{at(16, 9, 0), true, at(16, 17, 0)},
+		{at(16, 17, 0), false, at(16, 22, 0)},
+		{at(17, 1, 30), true, at(17, 2, 0)},
+		{at(17, 2, 0), false, at(19, 9, 0)},
+		// Sunday, across the end of summer time on 25 October
+		{at(25, 12, 0), false, at(26, 9, 0)},
+	} {
+		if got := r.ActiveAt(tc.t); got != tc.active {
+			t.Errorf("ActiveAt(%s) = %v", tc.t, got)
+		}
+		if got := r.NextChange(tc.t); !got.Equal(tc.next) {
+			t.Errorf("NextChange(%s) = %s, want %s", tc.t, got, tc.next)
+		}
+	}
+	if always := (DarkLaunchRule{}); !always.ActiveAt(at(16, 3, 0)) || !always.NextChange(at(16, 3, 0)).IsZero() {
+		t.Error("a rule without windows should always be on")
+	}
+}
+
+func TestValidateDarkLaunches(t *testing.T) {
+	c := Config{Proxy: ProxyConfig{
+		Backends: []Backend{{Address: "a:1"}, {Address: "b:1", Pool: "beta"}},
+		DarkLaunches: []DarkLaunchRule{
+			{Name: "checkout", Pool: "beta", Percent: 5, Windows: []TimeWindow{{Days: []string{"mon"}, Start: "09:00", End: "17:00"}}},
+			{Name: "search", Pool: "beta", Percent: 10},
+		},
+	}}
+	if errs := ValidateDarkLaunches(&c); len(errs) > 0 {
+		t.Errorf("valid rules: %v", errs)
+	}
+	if serving := c.Proxy.ServingBackends(c.Proxy.Backends); len(serving) != 1 || serving[0].Address != "a:1" {
+		t.Errorf("serving: got %v", serving)
+	}
+
+	for name, tc := range map[string]struct {
+		edit func(c *Config)
+		want string
+	}{
+		"percent":   {func(c *Config) { c.Proxy.DarkLaunches[1].Percent = 0 }, "percent must be between 1 and 100"},
+		"total":     {func(c *Config) { c.Proxy.DarkLaunches[1].Percent = 96 }, "percents add up to 101"},
+		"duplicate": {func(c *Config) { c.Proxy.DarkLaunches[1].Name = "checkout" }, `duplicate name "checkout"`},
+		"pool":      {func(c *Config) { c.Proxy.DarkLaunches[1].Pool = "qa" }, `no backend in proxy.backends has pool "qa"`},
+		"day":       {func(c *Config) { c.Proxy.DarkLaunches[0].Windows[0].Days[0] = "monday" }, `"monday" must be one of mon`},
+		"clock":     {func(c *Config) { c.Proxy.DarkLaunches[0].Windows[0].End = "5pm" }, "must be a 24-hour HH:MM time"},
+		"empty":     {func(c *Config) { c.Proxy.DarkLaunches[0].Windows[0].End = "09:00" }, "start and end are both 09:00"},
+		"all":       {func(c *Config) { c.Proxy.Backends = c.Proxy.Backends[1:] }, "leaving none to serve"},
+		"split":     {func(c *Config) { c.Proxy.TrafficSplit = map[string]int{"": 90, "beta": 10} }, "can't also be in proxy.traffic_split"},
+		"xds":       {func(c *Config) { c.XDS = XDSConfig{Enabled: true, ListenerMode: "tcp"} }, "aren't supported with xds.enabled"},
+	} {
+		q := c
+		q.Proxy.DarkLaunches = make([]DarkLaunchRule, len(c.Proxy.DarkLaunches))
+		for i, r := range c.Proxy.DarkLaunches {
+			q.Proxy.DarkLaunches[i] = r
+			q.Proxy.DarkLaunches[i].Windows = nil
+			for _, w := range r.Windows {
+				q.Proxy.DarkLaunches[i].Windows = append(q.Proxy.DarkLaunches[i].Windows, TimeWindow{Days: slices.Clone(w.Days), Start: w.Start, End: w.End})
+			}
+		}
+		tc.edit(&q)
+		if errs := ValidateDarkLaunches(&q); !strings.Contains(strings.Join(errs, "\n"), tc.want) {
+			t.Errorf("%s: got %v, want %q", name, errs, tc.want)
+		}
+	}
+}
+
+func TestLoad_HTTPRouteRetryOnDefault(t *testing.T) {
+	cfg, err := Load(writeTempConfig(t, strings.Replace(configWithToken, "backends: []",
+		"backends: []\n  http_routes:\n    - name: api\n      prefix: /api/\n      retry:\n        attempts: 2", 1)))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := cfg.Proxy.HTTPRoutes[0].Retry.RetryOn; strings.Join(got, ",") != "5xx,reset,connect-failure" {
+		t.Errorf("retry_on: got %v", got)
+	}
+
+	// With a default retry policy, the route inherits its retry_on instead
+	cfg, err = Load(writeTempConfig(t, strings.Replace(configWithToken, "backends: []",
+		"backends: []\n  traffic:\n    retry:\n      attempts: 3\n      retry_on: [reset]\n  http_routes:\n    - name: api\n      prefix: /api/\n      retry:\n        attempts: 2", 1)))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := cfg.Proxy.RouteTraffic(cfg.Proxy.HTTPRoutes[0]).Retry; got.Attempts != 2 || strings.Join(got.RetryOn, ",") != "reset" {
+		t.Errorf("merged retry: got %+v", got)
+	}
+}
+
+func TestLoad_BlueGreenDefaults(t *testing.T) {
+	cfg, err := Load(writeTempConfig(t, strings.Replace(configWithToken, "backends: []",
+		"backends:\n    - address: a:1\n      pool: blue\n    - address: b:1\n      pool: green\n  blue_green:\n    pools: [blue, green]", 1)))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if bg := cfg.Proxy.BlueGreen; bg.Active != "blue" || bg.MinHealthyPercent != 100 || bg.Standby() != "green" {
+		t.Errorf("defaults: got %+v", bg)
+	}
+}
+
+func TestLoad_ConnectionLimit(t *testing.T) {
+	limit := "backends: []\n  traffic:\n    connection_limit:\n      max: 200\n      overflow: %s"
+	cfg, err := Load(writeTempConfig(t, strings.Replace(configWithToken, "backends: []", fmt.Sprintf(limit, "queue"), 1)))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if l := cfg.Proxy.Traffic.ConnectionLimit; !l.Queues() || l.QueueSize != 200 || l.QueueTimeout != DefaultQueueTimeout {
+		t.Errorf("queue defaults: got %+v", l)
+	}
+
+	cfg, err = Load(writeTempConfig(t, configWithToken))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if l := cfg.Proxy.Traffic.ConnectionLimit; l.Max != 0 || l.Overflow != OverflowReject || l.Queues() {
+		t.Errorf("defaults: got %+v", l)
+	}
+
+	_, err = Load(writeTempConfig(t, strings.Replace(configWithToken, "backends: []", fmt.Sprintf(limit, "wait"), 1)))
+	if err == nil || !strings.Contains(err.Error(), `connection_limit.overflow must be "reject" or "queue", got "wait"`) {
+		t.Errorf("bad overflow: got %v", err)
+	}
+}
+
+func TestLoad_UDPAffinity(t *testing.T) {
+	affinity := "load_balancing:\n    udp_affinity:\n      mode: %s"
+	cfg, err := Load(writeTempConfig(t, strings.Replace(configWithToken, "load_balancing: {}", fmt.Sprintf(affinity, "source_ip_port"), 1)))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if a := cfg.Proxy.LoadBalancing.UDPAffinity; !a.Enabled() || a.IdleTimeout != DefaultUDPIdleTimeout {
+		t.Errorf("defaults: got %+v", a)
+	}
+
+	_, err = Load(writeTempConfig(t, strings.Replace(configWithToken, "load_balancing: {}", fmt.Sprintf(affinity, "source_port"), 1)))
+	if err == nil || !strings.Contains(err.Error(), `udp_affinity.mode must be empty, source_ip_port or source_ip, got "source_port"`) {
+		t.Errorf("bad mode: got %v", err)
+	}
+
+	p := ProxyConfig{LoadBalancing: LoadBalancingConfig{
+		UDPAffinity: UDPAffinityConfig{Mode: UDPAffinitySourceIP, IdleTimeout: 48 * time.Hour},
+		Locality:    LocalityConfig{Policy: LocalityPreferLocal},
+	}}
+	got := strings.Join(validateUDPAffinity(p), "\n")
+	for _, want := range []string{"idle_timeout must be between 0 and 24h", "can't be used with locality prefer_local"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestLoad_StickyCookie(t *testing.T) {
+	cookie := "load_balancing:\n    sticky_cookie:\n      name: %s\n      ttl: 1h\n      http_only: true"
+	cfg, err := Load(writeTempConfig(t, strings.Replace(configWithToken, "load_balancing: {}", fmt.Sprintf(cookie, "aegis_backend"), 1)))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if sc := cfg.Proxy.LoadBalancing.StickyCookie; !sc.Enabled() || sc.Path != "/" || sc.TTL != time.Hour || !sc.HTTPOnly {
+		t.Errorf("defaults: got %+v", sc)
+	}
+
+	_, err = Load(writeTempConfig(t, strings.Replace(configWithToken, "load_balancing: {}", fmt.Sprintf(cookie, `"aegis backend"`), 1)))
+	if err == nil || !strings.Contains(err.Error(), `"aegis backend" is not a valid cookie name`) {
+		t.Errorf("bad name: got %v", err)
+	}
+
+	c := Config{
+		Proxy: ProxyConfig{
+			LoadBalancing: LoadBalancingConfig{
+				Algorithm:    AlgorithmConsistentHash,
+				Hash:         HashConfig{Key: HashKeyCookie, Name: "sid"},
+				StickyCookie: StickyCookieConfig{Name: "sid", TTL: 1500 * time.Millisecond, Path: "app"},
+			},
+			SNIRoutes: []SNIRoute{{ServerNames: []string{"a.example.com"}, Pool: "a"}},
+		},
+		XDS: XDSConfig{Enabled: true, ListenerMode: "tcp"},
+	}
+	got := strings.Join(validateStickyCookie(&c), "\n")
+	for _, want := range []string{"ttl must be whole seconds", "path must start with /", "needs xds.listener_mode http",
+		"can't be combined with proxy.sni_routes", `cookie "sid" is already the consistent_hash key`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestLoad_SlowStart(t *testing.T) {
+	slowStart := "load_balancing:\n    algorithm: %s\n    slow_start:\n      duration: 3s"
+	cfg, err := Load(writeTempConfig(t, strings.Replace(configWithToken, "load_balancing: {}", fmt.Sprintf(slowStart, AlgorithmWeightedRoundRobin), 1)))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	ss := cfg.Proxy.LoadBalancing.SlowStart
+	if ss.InitialPercent != DefaultSlowStartInitialPercent || ss.Interval != 3*time.Second {
+		t.Errorf("defaults: got %+v", ss)
+	}
+	for _, tc := range []struct {
+		target  int
+		elapsed time.Duration
+		want    int
+	}{
+		{100, 0, 10},
+		{100, 1500 * time.Millisecond, 55},
+		{100, 3 * time.Second, 100},
+		{5, 0, 1},
+		{0, 0, 0},
+	} {
+		if got := ss.Weight(tc.target, tc.elapsed); got != tc.want {
+			t.Errorf("Weight(%d, %s) = %d, want %d", tc.target, tc.elapsed, got, tc.want)
+		}
+	}
+
+	_, err = Load(writeTempConfig(t, strings.Replace(configWithToken, "load_balancing: {}", fmt.Sprintf(slowStart, AlgorithmRoundRobin), 1)))
+	if err == nil || !strings.Contains(err.Error(), "slow_start needs proxy.load_balancing.algorithm") {
+		t.Errorf("round robin: got %v", err)
+	}
+
+	p := ProxyConfig{LoadBalancing: LoadBalancingConfig{
+		Algorithm: AlgorithmWeighted,
+		SlowStart: SlowStartConfig{Duration: 2 * time.Hour, InitialPercent: 101, Interval: 3 * time.Hour},
+	}}
+	got := strings.Join(validateSlowStart(p), "\n")
+	for _, want := range []string{"duration must be at most 1h", "initial_percent must be between 1 and 100", "interval must be between 1s and the duration"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestValidateConnectionLimit(t *testing.T) {
+	c := Config{Proxy: ProxyConfig{Traffic: TrafficConfig{ConnectionLimit: ConnectionLimitConfig{
+		Max: 100, Overflow: OverflowQueue, QueueSize: 10, QueueTimeout: time.Second,
+	}}}}
+	if errs := validateConnectionLimit(&c); len(errs) > 0 {
+		t.Errorf("valid limit: %v", errs)
+	}
+
+	c.XDS = XDSConfig{Enabled: true, ListenerMode: "tcp"}
+	if errs := validateConnectionLimit(&c); !strings.Contains(strings.Join(errs, "\n"), "isn't supported by Envoy") {
+		t.Errorf("queue with xds: got %v", errs)
+	}
+	c.Proxy.Traffic.ConnectionLimit.Overflow = OverflowReject
+	if errs := validateConnectionLimit(&c); len(errs) > 0 {
+		t.Errorf("reject with xds: %v", errs)
+	}
+
+	c.Proxy.Traffic.ConnectionLimit.Max = -1
+	if errs := validateConnectionLimit(&c); !strings.Contains(strings.Join(errs, "\n"), "max must be >= 0") {
+		t.Errorf("negative max: got %v", errs)
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	for _, expr := range []string{"* * * * *", "*/15 0-6 1,15 * 1-5", "0 2 * * 7", "5/10 * * 1-12/2 *"} {
+		if _, err := ParseCron(expr); err != nil {
+			t.Errorf("%q: %v", expr, err)
+		}
+	}
+	for expr, want := range map[string]string{
+		"0 2 * *":     "must have 5 fields",
+		"60 * * * *":  "minute",
+		"0 24 * * *":  "hour",
+		"0 0 0 * *":   "day of month",
+		"0 0 * 13 *":  "month",
+		"0 0 * * 8":   "day of week",
+		"*/0 * * * *": "invalid step",
+		"5-1 * * * *": "invalid range",
+		"x * * * *":   "invalid value",
+	} {
+		if _, err := ParseCron(expr); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v, want %q", expr, err, want)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	at := func(s string) time.Time {
+		t.Helper()
+		tm, err := time.ParseInLocation("2006-01-02 15:04", s, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	for _, tc := range []struct{ expr, from, want string }{
+		// 2026-10-14 is a Wednesday
+		{"0 2 * * 0", "2026-10-14 12:00", "2026-10-18 02:00"},
+		{"0 2 * * 7", "2026-10-18 02:00", "2026-10-25 02:00"},
+		{"*/15 * * * *", "2026-10-14 12:07", "2026-10-14 12:15"},
+		{"0 22 * * 1-5", "2026-10-16 22:30", "2026-10-19 22:00"},
+		{"0 0 1,15 * *", "2026-10-14 12:00", "2026-10-15 00:00"},
+		// Either day field matches when neither is *
+		{"0 0 1 * 1", "2026-10-14 12:00", "2026-10-19 00:00"},
+		{"0 0 31 * *", "2026-11-01 00:00", "2026-12-31 00:00"},
+		// 02:30 doesn't exist on 2027-03-28 in Berlin
+		{"30 2 * * *", "2027-03-28 00:00", "2027-03-28 03:30"},
+	} {
+		c, err := ParseCron(tc.expr)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.expr, err)
+		}
+		if got := c.Next(at(tc.from)); !got.Equal(at(tc.want)) {
+			t.Errorf("%q after %s: got %s, want %s", tc.expr, tc.from, got, tc.want)
+		}
+	}
+
+	never, _ := ParseCron("0 0 30 2 *")
+	if got := never.Next(at("2026-10-14 12:00")); !got.IsZero() {
+		t.Errorf("30 February: got %s", got)
+	}
+}
+
+func TestOverlayApply(t *testing.T) {
+	p := ProxyConfig{
+		Backends:    []Backend{{Address: "a:1", Weight: 100}, {Address: "b:1", Weight: 50}},
+		UdpBackends: []Backend{{Address: "u:1", Weight: 10}},
+		Traffic:     TrafficConfig{RateLimit: RateLimitConfig{RequestsPerSecond: 1000, Burst: 100}},
+	}
+	o := Overlay{
+		RateLimit: &RateLimitConfig{RequestsPerSecond: 100, Burst: 10},
+		Weights:   map[string]int{"b:1": 0, "u:1": 20, "gone:1": 5},
+	}
+	applied, undo, missing := o.Apply(p)
+	if applied.Traffic.RateLimit.RequestsPerSecond != 100 || applied.Backends[1].Weight != 0 || applied.UdpBackends[0].Weight != 20 {
+		t.Errorf("applied: got %+v", applied)
+	}
+	if p.Backends[1].Weight != 50 || p.UdpBackends[0].Weight != 10 {
+		t.Errorf("Apply changed its argument: %+v", p)
+	}
+	if len(missing) != 1 || missing[0] != "gone:1" {
+		t.Errorf("missing: got %v", missing)
+	}
+
+	reverted, _, _ := undo.Apply(applied)
+	if reverted.Traffic.RateLimit != p.Traffic.RateLimit || reverted.Backends[1].Weight != 50 || reverted.UdpBackends[0].Weight != 10 {
+		t.Errorf("reverted: got %+v", reverted)
+	}
+}
+
+func TestLoad_Scheduler(t *testing.T) {
+	scheduler := `
+scheduler:
+  timezone: %s
+  schedules:
+    - name: night
+      cron: "0 22 * * *"
+      duration: 8h
+      overlay:
+        rate_limit:
+          requests_per_second: 100
+    - name: %s
+      cron: "%s"
+      overlay:
+        weights:
+          %s: 0
+`
+	backends := strings.Replace(configWithToken, "backends: []", "backends:\n    - address: a:1", 1)
+	cfg, err := Load(writeTempConfig(t, backends+fmt.Sprintf(scheduler, "UTC", "drain", "0 2 * * 0", "a:1")))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if s := cfg.Scheduler; len(s.Schedules) != 2 || s.Schedules[0].Duration != 8*time.Hour || s.Location() != time.UTC {
+		t.Errorf("scheduler: got %+v", s)
+	}
+
+	_, err = Load(writeTempConfig(t, backends+fmt.Sprintf(scheduler, "Mars/Olympus", "night", "0 2 * *", "b:1")))
+	for _, want := range []string{
+		`unknown time zone "Mars/Olympus"`,
+		`duplicate name "night"`,
+		"scheduler.schedules[1].cron",
+		`"b:1" is not in proxy.backends`,
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got %v, want %q", err, want)
+		}
+	}
+}
+
+func TestLoad_HA(t *testing.T) {
+	cfg, err := Load(writeTempConfig(t, strings.Replace(configWithToken, "admin:\n", "admin:\n  readiness:\n    checks: [leader]\n", 1)+
+		"ha:\n  enabled: true\n  backend: kubernetes\n  identity: cp-1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := cfg.HA; h.LeaseDuration != DefaultLeaseDuration || h.RenewDeadline != DefaultRenewDeadline || h.RetryPeriod != DefaultRetryPeriod ||
+		h.Lease != "aegis-control-plane" || h.Key != "" {
+		t.Errorf("kubernetes defaults: got %+v", h)
+	}
+	cfg, err = Load(writeTempConfig(t, configWithToken+"ha:\n  enabled: true\n  backend: etcd\n  endpoints: [\"http://etcd-1:2379\"]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := cfg.HA; h.Key != "/aegis/leader" || h.Lease != "" || h.Identity == "" {
+		t.Errorf("etcd defaults: got %+v", h)
+	}
+
+	_, err = Load(writeTempConfig(t, configWithToken+`ha:
+  enabled: true
+  backend: etcd
+  endpoints: ["etcd-1:2379"]
+  key: aegis
+  lease: aegis
+  cert_file: client.pem
+  lease_duration: 5s
+  renew_deadline: 10s
+`))
+	for _, want := range []string{
+		"ha.endpoints[0] must be an http:// or https:// URL",
+		"ha.cert_file and key_file must be set together",
+		"ha.key must start with /",
+		"ha.namespace and lease are for backend kubernetes",
+		"ha needs retry_period < renew_deadline < lease_duration, got 2s, 10s and 5s",
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got %v, want %q", err, want)
+		}
+	}
+	_, err = Load(writeTempConfig(t, configWithToken+"ha:\n  enabled: true\n  backend: kubernetes\n  username: aegis\n"))
+	if want := "ha.endpoints, username, password, ca_file, cert_file, key_file and key are for backend etcd"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got %v, want %q", err, want)
+	}
+	_, err = Load(writeTempConfig(t, configWithToken+"ha:\n  enabled: true\n  backend: consul\n  retry_period: 10ms\n"))
+	for _, want := range []string{`ha.backend must be "kubernetes" or "etcd", got "consul"`, "ha.retry_period must be at least 100ms"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got %v, want %q", err, want)
+		}
+	}
+	// Off, the rest is ignored
+	if _, err := Load(writeTempConfig(t, configWithToken+"ha:\n  backend: consul\n")); err != nil {
+		t.Errorf("disabled: %v", err)
+	}
+}
+
+func TestLoad_Store(t *testing.T) {
+	cfg, err := Load(writeTempConfig(t, configWithToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := cfg.Store; st.Backend != StoreMemory || st.Timeout != DefaultStoreTimeout {
+		t.Errorf("defaults: got %+v", st)
+	}
+	cfg, err = Load(writeTempConfig(t, configWithToken+"store:\n  backend: etcd\n  endpoints: [\"http://etcd-1:2379\"]\n"))
+	if err != nil || cfg.Store.Prefix != "/aegis/state/" {
+		t.Errorf("etcd: got %+v, %v", cfg.Store, err)
+	}
+	t.Setenv("AEGIS_STORE_PASSWORD", "from-env")
+	cfg, err = Load(writeTempConfig(t, configWithToken+"store:\n  backend: redis\n  address: redis:6379\n"))
+	if err != nil || cfg.Store.Key != "aegis:state" || cfg.Store.Password != "from-env" {
+		t.Errorf("redis: got %+v, %v", cfg.Store, err)
+	}
+	if _, err := Load(writeTempConfig(t, configWithToken+"store:\n  backend: file\n  path: /var/lib/aegis/state.json\n")); err != nil {
+		t.Errorf("file: %v", err)
+	}
+
+	for body, want := range map[string]string{
+		"backend: file":                                            "store.path is required with backend file",
+		"backend: etcd\n  prefix: aegis":                           "store.endpoints are required with backend etcd",
+		"backend: redis\n  address: redis":                         `store.address must be host:port, got "redis"`,
+		"backend: memory\n  address: redis:6379":                   "store.address, db and key are for backend redis",
+		"backend: memory\n  username: aegis":                       "store.username and password are for backends etcd and redis",
+		"backend: redis\n  address: redis:6379\n  ca_file: ca.pem": "store.endpoints, ca_file, cert_file, key_file and prefix are for backend etcd",
+		"backend: etcd\n  endpoints: [\"https://etcd-1:2379\"]\n  prefix: /a/\n  cert_file: c.pem": "store.cert_file and key_file must be set together",
+		"backend: etcd\n  endpoints: [\"https://etcd-1:2379\"]\n  prefix: /a/\n  password: x":      "store.password requires username",
+		"backend: redis\n  address: redis:6379\n  path: x":                                         "store.path is for backend file",
+		"backend: bbolt": `store.backend must be "memory", "file", "etcd" or "redis", got "bbolt"`,
+	} {
+		_, err := Load(writeTempConfig(t, configWithToken+"store:\n  "+body+"\n"))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v, want %q", body, err, want)
+		}
+	}
+}
+
+func TestLoad_ProxyTLS(t *testing.T) {
+	acme := "load_balancing: {}\n  tls:\n    acme:\n      email: ops@example.com\n      accept_tos: true\n" +
+		"      certificates:\n        - domains: [example.com, www.example.com]\n"
+	cfg, err := Load(writeTempConfig(t, strings.Replace(configWithToken, "load_balancing: {}", acme, 1)))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	a := cfg.Proxy.TLS.ACME
+	if !cfg.Proxy.TLS.Enabled() || a.DirectoryURL != LetsEncryptDirectory || a.Challenge != ACMEChallengeHTTP01 ||
+		a.HTTPAddress != ":80" || a.RenewBefore != DefaultACMERenewBefore || a.Certificates[0].Name != "example.com" {
+		t.Errorf("defaults: got %+v", a)
+	}
+	dns := strings.Replace(acme, "accept_tos: true\n", "accept_tos: true\n      challenge: dns-01\n      dns:\n        command: [/usr/local/bin/dns-hook]\n", 1)
+	cfg, err = Load(writeTempConfig(t, strings.Replace(configWithToken, "load_balancing: {}", dns, 1)))
+	if err != nil || cfg.Proxy.TLS.ACME.DNS.PropagationTimeout != DefaultACMEPropagationTimeout || cfg.Proxy.TLS.ACME.HTTPAddress != "" {
+		t.Errorf("dns-01: got %+v, %v", cfg.Proxy.TLS.ACME, err)
+	}
+
+	files := "load_balancing: {}\n  tls:\n    certificates:\n      - cert_file: /etc/aegis/tls/site.crt\n        key_file: /etc/aegis/tls/site.key\n"
+	cfg, err = Load(writeTempConfig(t, strings.Replace(configWithToken, "load_balancing: {}", files, 1)))
+	if err != nil || !cfg.Proxy.TLS.Enabled() || cfg.Proxy.TLS.Certificates[0].Name != "site" || cfg.Proxy.TLS.ACME.Challenge != "" {
+		t.Errorf("files: got %+v, %v", cfg.Proxy.TLS, err)
+	}
+
+	valid := ACMEConfig{
+		DirectoryURL: LetsEncryptDirectory, AcceptTOS: true, Challenge: ACMEChallengeHTTP01, HTTPAddress: ":80",
+		RenewBefore: DefaultACMERenewBefore, Certificates: []ACMECertificate{{Name: "example.com", Domains: []string{"example.com"}}},
+	}
+	for _, tc := range []struct {
+		name string
+		edit func(*Config)
+		want string
+	}{
+		{"xds", func(c *Config) { c.XDS.Enabled = true }, "proxy.tls isn't supported with xds.enabled"},
+		{"sni routes", func(c *Config) { c.Proxy.SNIRoutes = []SNIRoute{{ServerNames: []string{"a.example.com"}}} }, "can't be combined with proxy.sni_routes"},
+		{"sticky cookie", func(c *Config) { c.Proxy.LoadBalancing.StickyCookie.Name = "aegis" }, "can't be combined with proxy.load_balancing.sticky_cookie"},
+		{"http directory", func(c *Config) { c.Proxy.TLS.ACME.DirectoryURL = "http://ca.example.com/directory" }, "directory_url must be an https:// URL"},
+		{"no tos", func(c *Config) { c.Proxy.TLS.ACME.AcceptTOS = false }, "accept_tos must be set"},
+		{"email", func(c *Config) { c.Proxy.TLS.ACME.Email = "ops" }, `"ops" is not an email address`},
+		{"challenge", func(c *Config) { c.Proxy.TLS.ACME.Challenge = "tls-alpn-01" }, `challenge must be "http-01" or "dns-01"`},
+		{"no dns command", func(c *Config) { c.Proxy.TLS.ACME.Challenge = ACMEChallengeDNS01 }, "dns.command is required with challenge dns-01"},
+		{"wildcard", func(c *Config) { c.Proxy.TLS.ACME.Certificates[0].Domains = []string{"*.example.com"} }, "needs challenge dns-01"},
+		{"bad domain", func(c *Config) { c.Proxy.TLS.ACME.Certificates[0].Domains = []string{"Example.com"} }, "must be a lowercase host name"},
+		{"duplicate name", func(c *Config) {
+			c.Proxy.TLS.ACME.Certificates = append(c.Proxy.TLS.ACME.Certificates, ACMECertificate{Name: "example.com", Domains: []string{"www.example.com"}})
+		}, `name "example.com" is used more than once`},
+		{"no key file", func(c *Config) {
+			c.Proxy.TLS.Certificates = []CertificateFiles{{Name: "site", CertFile: "site.crt"}}
+		}, "proxy.tls.certificates[0].cert_file and key_file are required"},
+		{"name used by acme", func(c *Config) {
+			c.Proxy.TLS.Certificates = []CertificateFiles{{Name: "example.com", CertFile: "a.crt", KeyFile: "a.key"}}
+		}, `name "example.com" is used by proxy.tls.certificates too`},
+	} {
+		c := &Config{Proxy: ProxyConfig{TLS: ProxyTLSConfig{ACME: valid}}}
+		c.Proxy.TLS.ACME.Certificates = slices.Clone(valid.Certificates)
+		tc.edit(c)
+		if got := strings.Join(validateProxyTLS(c), "\n"); !strings.Contains(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+	if errs := validateProxyTLS(&Config{Proxy: ProxyConfig{TLS: ProxyTLSConfig{ACME: valid}}}); len(errs) > 0 {
+		t.Errorf("valid: got %v", errs)
+	}
+}
+
+func TestSaveBackends_RewritesOnlyBackends(t *testing.T) {
+	path := writeTempConfig(t, `# top comment
+proxy:
+  listen:
+    tcp: "0.0.0.0:8080"
+    udp: "0.0.0.0:8081"
+  # TCP backends
+  backends:
+    - address: "localhost:3000"
+  load_balancing:
+    algorithm: least_connections # keep me
+admin:
+  api_address: "0.0.0.0:9090"
+  metrics_address: "0.0.0.0:9091"
+grpc:
+  control_plane_address: "localhost:50051"
+`)
+
+	err := SaveBackends(path, []Backend{
+		{Address: "localhost:3000", Weight: 100, HealthCheck: HealthCheckConfig{Interval: 5 * time.Second, Timeout: 2 * time.Second}},
+		{Address: "db4.internal:5432", Weight: 25, HealthCheck: HealthCheckConfig{Interval: 10 * time.Second, Timeout: time.Second, Scheme: "https"}},
+	})
+	if err != nil {
+		t.Fatalf("SaveBackends: %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	for _, want := range []string{"# top comment", "# TCP backends", "# keep me"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("saved file lost %q:\n%s", want, data)
+		}
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load after save: %v", err)
+	}
+	if cfg.Proxy.LoadBalancing.Algorithm != "least_connections" {
+		t.Errorf("algorithm: got %q, want least_connections", cfg.Proxy.LoadBalancing.Algorithm)
+	}
+	if len(cfg.Proxy.Backends) != 2 {
+		t.Fatalf("backends: got %d, want 2", len(cfg.Proxy.Backends))
+	}
+	b := cfg.Proxy.Backends[1]
+	if b.Address != "db4.internal:5432" || b.Weight != 25 || b.HealthCheck.Interval != 10*time.Second || b.HealthCheck.Scheme != "https" {
+		t.Errorf("saved backend: got %+v", b)
+	}
+}
+
+func TestRedacted_HidesCredentials(t *testing.T) {
+	cfg := &Config{
+		Admin: AdminConfig{
+			APIToken: "secret-token",
+			APIKeys:  []APIKeyConfig{{Name: "ci", Key: "secret-key", Role: "operator"}},
+		},
+		Events: EventsConfig{Webhooks: []WebhookConfig{
+			{URL: "https://hooks.slack.com/services/T000/B000/secret"},
+			{URL: "https://alerts.example.com"},
+		}},
+		Telemetry: TelemetryConfig{
+			OTLP:        OTLPConfig{Headers: map[string]string{"x-api-key": "secret"}},
+			RemoteWrite: RemoteWriteConfig{Headers: map[string]string{"Authorization": "Bearer secret"}},
+			InfluxDB:    InfluxDBConfig{Token: "secret"},
+		},
+		Store: StoreConfig{Backend: StoreRedis, EtcdConfig: EtcdConfig{Password: "secret"}},
+		HA:    HAConfig{Backend: HABackendEtcd, EtcdConfig: EtcdConfig{Username: "aegis", Password: "secret"}},
+	}
+	version := cfg.Version()
+
+	r := cfg.Redacted()
+	if r.Admin.APIToken != redacted || r.Admin.APIKeys[0].Key != redacted || r.Admin.APIKeys[0].Name != "ci" {
+		t.Errorf("admin credentials: got %+v", r.Admin)
+	}
+	if got := r.Events.Webhooks[0].URL; got != "https://hooks.slack.com/"+redacted {
+		t.Errorf("webhook with path: got %q", got)
+	}
+	if got := r.Events.Webhooks[1].URL; got != "https://alerts.example.com" {
+		t.Errorf("webhook without path: got %q", got)
+	}
+	if got := r.Telemetry.OTLP.Headers["x-api-key"]; got != redacted {
+		t.Errorf("otlp header: got %q", got)
+	}
+	if got := r.Telemetry.RemoteWrite.Headers["Authorization"]; got != redacted {
+		t.Errorf("remote write header: got %q", got)
+	}
+	if got := r.Telemetry.InfluxDB.Token; got != redacted {
+		t.Errorf("influxdb token: got %q", got)
+	}
+	if got := r.Store.Password; got != redacted {
+		t.Errorf("store password: got %q", got)
+	}
+	if got := r.HA.Password; got != redacted {
+		t.Errorf("ha password: got %q", got)
+	}
+
+	if cfg.Admin.APIToken != "secret-token" || cfg.Admin.APIKeys[0].Key != "secret-key" || cfg.Telemetry.OTLP.Headers["x-api-key"] != "secret" {
+		t.Error("Redacted modified the original config")
+	}
+	if cfg.Version() != version {
+		t.Error("Version changed without a config change")
+	}
+	cfg.Admin.APIKeys[0].Role = "admin"
+	if cfg.Version() == version {
+		t.Error("Version unchanged after a config change")
+	}
+}
+
+func TestParseWithSecrets_RestoresRedactedValues(t *testing.T) {
+	current, err := Parse([]byte(strings.Replace(minimalConfig, "admin:\n", "admin:\n  api_keys:\n    - name: ci\n      key: real-key\n", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := strings.Replace(minimalConfig, "admin:\n", "admin:\n  api_keys:\n    - name: ci\n      key: \"[redacted]\"\n", 1)
+	cfg, err := ParseWithSecrets([]byte(doc), current)
+	if err != nil {
+		t.Fatalf("ParseWithSecrets: %v", err)
+	}
+	if cfg.Admin.APIKeys[0].Key != "real-key" {
+		t.Errorf("key: got %q, want real-key", cfg.Admin.APIKeys[0].Key)
+	}
+
+	renamed := strings.Replace(doc, "name: ci", "name: deploy", 1)
+	if _, err := ParseWithSecrets([]byte(renamed), current); err == nil {
+		t.Error("expected an error for a redacted key with no running counterpart")
+	}
+
+	otlp := "telemetry:\n  otlp:\n    endpoint: \"http://otel:4318\"\n    headers:\n      x-api-key: %s\n"
+	current, err = Parse([]byte(minimalConfig + fmt.Sprintf(otlp, "real-header")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = ParseWithSecrets([]byte(minimalConfig+fmt.Sprintf(otlp, `"[redacted]"`)), current)
+	if err != nil {
+		t.Fatalf("ParseWithSecrets: %v", err)
+	}
+	if got := cfg.Telemetry.OTLP.Headers["x-api-key"]; got != "real-header" {
+		t.Errorf("otlp header: got %q, want real-header", got)
+	}
+}
+
+func TestLoad_Discovery(t *testing.T) {
+	cfg, err := Load(writeTempConfig(t, strings.Replace(configWithToken, "backends: []",
+		"backends: []\n  discovery:\n    provider: dns_srv\n    service: _api._tcp.example.com", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cfg.Proxy.Discovery; d.Interval != DefaultDiscoveryInterval || d.HealthCheck.Interval != 5*time.Second || d.HealthCheck.Scheme != "http" ||
+		d.Merge != MergeUnion || d.HoldEmpty != 2*DefaultDiscoveryInterval {
+		t.Errorf("defaults: got %+v", d)
+	}
+	if _, err := Load(writeTempConfig(t, strings.Replace(configWithToken, "backends: []",
+		"backends: []\n  discovery:\n    provider: dns\n    address: api.internal:8080", 1))); err != nil {
+		t.Errorf("dns: %v", err)
+	}
+	cfg, err = Load(writeTempConfig(t, strings.Replace(configWithToken, "backends: []",
+		"backends: []\n  discovery:\n    provider: docker", 1)))
+	if err != nil || cfg.Proxy.Discovery.DockerHost != DefaultDockerHost {
+		t.Errorf("docker: %v", err)
+	}
+	t.Setenv("NOMAD_ADDR", "")
+	cfg, err = Load(writeTempConfig(t, strings.Replace(configWithToken, "backends: []",
+		"backends: []\n  discovery:\n    provider: nomad\n    service: api", 1)))
+	if err != nil || cfg.Proxy.Discovery.NomadAddress != DefaultNomadAddress {
+		t.Errorf("nomad: %v", err)
+	}
+	if _, err := Load(writeTempConfig(t, strings.Replace(configWithToken, "backends: []",
+		"backends: []\n  discovery:\n    provider: zookeeper\n    servers: [\"zk-1:2181\", \"zk-2:2181\"]\n    path: /services/api", 1))); err != nil {
+		t.Errorf("zookeeper: %v", err)
+	}
+	RegisterDiscoveryProvider("test", func(d DiscoveryConfig) error {
+		if d.Options["cluster"] == "" {
+			return errors.New("options.cluster is required")
+		}
+		return nil
+	})
+	if _, err := Load(writeTempConfig(t, strings.Replace(configWithToken, "backends: []",
+		"backends: []\n  discovery:\n    provider: test\n    options: {cluster: prod}", 1))); err != nil {
+		t.Errorf("registered: %v", err)
+	}
+	_, err = Load(writeTempConfig(t, strings.Replace(configWithToken, "backends: []",
+		"backends: []\n  discovery:\n    provider: test", 1)))
+	if err == nil || !strings.Contains(err.Error(), "proxy.discovery (provider test): options.cluster is required") {
+		t.Errorf("registered, invalid: %v", err)
+	}
+	t.Setenv("AWS_REGION", "eu-west-1")
+	cfg, err = Load(writeTempConfig(t, strings.Replace(configWithToken, "backends: []",
+		"backends: []\n  discovery:\n    provider: aws\n    target_groups: [\"arn:aws:elasticloadbalancing:eu-west-1:123456789012:targetgroup/api/6d0ecf831eec9f09\"]", 1)))
+	if err != nil || cfg.Proxy.Discovery.Region != "eu-west-1" {
+		t.Errorf("aws: %v", err)
+	}
+	cfg, err = Load(writeTempConfig(t, strings.Replace(configWithToken, "backends: []",
+		"backends: []\n  discovery:\n    provider: cloudmap\n    namespace: internal.example.com\n    service: api", 1)))
+	if err != nil || cfg.Proxy.Discovery.Region != "eu-west-1" || cfg.Proxy.Discovery.Target() != "internal.example.com/api" {
+		t.Errorf("cloudmap: %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		discovery, want string
+	}{
+		"provider":       {"provider: consul\n    service: api", `provider must be "dns_srv", "dns", "etcd", "docker", "aws", "nomad", "zookeeper", "cloudmap" or a registered provider, got "consul"`},
+		"dns options":    {"provider: dns\n    address: api:80\n    options: {x: y}", "options are for registered providers; dns doesn't take any"},
+		"zk servers":     {"provider: zookeeper\n    path: /services/api", "servers are required with provider zookeeper"},
+		"zk server":      {"provider: zookeeper\n    servers: [zk-1]\n    path: /services/api", "servers[0] must be host:port"},
+		"zk path":        {"provider: zookeeper\n    servers: [\"zk-1:2181\"]\n    path: services/api/", "path must be an absolute znode path"},
+		"dns path":       {"provider: dns\n    address: api:80\n    path: /services/api", "servers and path are for provider zookeeper"},
+		"nomad service":  {"provider: nomad", "proxy.discovery.service is required with provider nomad"},
+		"nomad_address":  {"provider: nomad\n    service: api\n    nomad_address: nomad:4646", "nomad_address must be an http:// or https:// URL"},
+		"etcd namespace": {"provider: etcd\n    endpoints: [\"http://etcd:2379\"]\n    prefix: /a/\n    namespace: prod", "namespace is for providers nomad and cloudmap"},
+		"aws nothing":    {"provider: aws\n    region: us-east-1", "needs tags, target_groups or both"},
+		"aws port":       {"provider: aws\n    region: us-east-1\n    tags: {service: api}", "port must be between 1 and 65535"},
+		"aws arn":        {"provider: aws\n    region: us-east-1\n    target_groups: [api]", "target_groups[0] must be a target group ARN"},
+		"aws often":      {"provider: aws\n    region: us-east-1\n    tags: {service: api}\n    port: 80\n    interval: 5s", "interval must be at least 10s"},
+		"dns region":     {"provider: dns\n    address: api:80\n    region: us-east-1", "region and port are for providers aws and cloudmap"},
+		"cloudmap tags":  {"provider: cloudmap\n    namespace: internal\n    service: api\n    tags: {service: api}", "tags and target_groups are for provider aws"},
+		"cloudmap names": {"provider: cloudmap\n    service: api", "namespace and service are required with provider cloudmap"},
+		"cloudmap port":  {"provider: cloudmap\n    namespace: internal\n    service: api\n    port: 70000", "port must be between 1 and 65535 when set"},
+		"health_status":  {"provider: cloudmap\n    namespace: internal\n    service: api\n    health_status: unhealthy", `health_status must be "healthy", "all" or "healthy_or_else_all", got "unhealthy"`},
+		"srv attributes": {"provider: dns_srv\n    service: api\n    attributes: {ECS_CLUSTER_NAME: prod}", "health_status and attributes are for provider cloudmap"},
+		"docker_host":    {"provider: docker\n    docker_host: /var/run/docker.sock", "docker_host must be unix:///path or tcp://host:port"},
+		"srv docker":     {"provider: dns_srv\n    service: api\n    docker_host: tcp://docker:2375", "docker_host is for provider docker"},
+		"endpoints":      {"provider: etcd\n    prefix: /services/api/", "endpoints are required"},
+		"endpoint":       {"provider: etcd\n    prefix: /a/\n    endpoints: [etcd:2379]", "endpoints[0] must be an http:// or https:// URL"},
+		"prefix":         {"provider: etcd\n    endpoints: [\"http://etcd:2379\"]", "proxy.discovery.prefix is required"},
+		"dns prefix":     {"provider: dns\n    address: api:80\n    prefix: /a/", "endpoints, username, password, ca_file, cert_file, key_file and prefix are for provider etcd"},
+		"service":        {"provider: dns_srv", "proxy.discovery.service is required"},
+		"srv address":    {"provider: dns_srv\n    service: api\n    address: api:80", "address is for provider dns"},
+		"address":        {"provider: dns", "proxy.discovery.address is required"},
+		"no port":        {"provider: dns\n    address: api.internal", "address must be host:port"},
+		"resolver":       {"provider: dns_srv\n    service: api\n    resolver: 10.0.0.2", "resolver must be host:port"},
+		"interval":       {"provider: dns_srv\n    service: api\n    interval: 100ms", "interval must be at least 1s"},
+		"merge":          {"provider: dns_srv\n    service: api\n    merge: override", `merge must be "union", "discovery" or "fallback", got "override"`},
+		"hold_empty":     {"provider: dns_srv\n    service: api\n    hold_empty: -1s", "hold_empty must not be negative"},
+		"max_removal":    {"provider: dns_srv\n    service: api\n    max_removal_percent: 150", "max_removal_percent must be between 0 and 100"},
+		"min_backends":   {"provider: dns_srv\n    service: api\n    min_backends: -1", "min_backends must not be negative"},
+		"push interval":  {"provider: dns_srv\n    service: api\n    min_push_interval: -5s", "min_push_interval must not be negative"},
+	} {
+		_, err := Load(writeTempConfig(t, strings.Replace(configWithToken, "backends: []",
+			"backends: []\n  discovery:\n    "+tc.discovery, 1)))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+}
+
+func TestMergeDiscovered(t *testing.T) {
+	backends := []Backend{{Address: "a:1", Weight: 100}, {Address: "old:1", Weight: 100, Discovered: true}}
+	merged := MergeDiscovered(backends, []Backend{{Address: "a:1", Weight: 5}, {Address: "new:1", Weight: 10}}, MergeUnion)
+	want := []Backend{{Address: "a:1", Weight: 100}, {Address: "new:1", Weight: 10, Discovered: true}}
+	if !slices.Equal(merged, want) || !backends[1].Discovered {
+		t.Errorf("got %+v", merged)
+	}
+	if static := StaticBackends(merged); len(static) != 1 || static[0].Address != "a:1" {
+		t.Errorf("static: got %+v", static)
+	}
+}
+
+func TestMergeDiscovered_Policies(t *testing.T) {
+	backends := []Backend{{Address: "a:1"}, {Address: "b:1"}, {Address: "f:1", FromFile: true}}
+	serving := func(merged []Backend) []string {
+		var addresses []string
+		for _, b := range (ProxyConfig{}).ServingBackends(merged) {
+			addresses = append(addresses, b.Address)
+		}
+		return addresses
+	}
+	found := []Backend{{Address: "a:1", Weight: 5}, {Address: "new:1"}}
+	for _, tc := range []struct {
+		policy          string
+		found, emptied  []string
+		wantFoundBefore int
+	}{
+		{MergeUnion, []string{"a:1", "b:1", "f:1", "new:1"}, []string{"a:1", "b:1", "f:1"}, 1},
+		// a:1 is found too, so it serves as configured
+		{MergeDiscovery, []string{"a:1", "f:1", "new:1"}, []string{"f:1"}, 2},
+		{MergeFallback, []string{"a:1", "f:1", "new:1"}, []string{"a:1", "b:1", "f:1"}, 2},
+	} {
+		merged := MergeDiscovered(backends, found, tc.policy)
+		if got := serving(merged); !slices.Equal(got, tc.found) {
+			t.Errorf("%s: serving %v", tc.policy, got)
+		}
+		if static := StaticBackends(merged); !slices.Equal(static, backends[:2]) {
+			t.Errorf("%s: static %+v", tc.policy, static)
+		}
+		// What was found survives a config push that re-merges it
+		foundBefore := FoundBackends(merged, tc.policy)
+		if len(foundBefore) != tc.wantFoundBefore {
+			t.Errorf("%s: found before %+v", tc.policy, foundBefore)
+		}
+		if remerged := MergeDiscovered(backends, foundBefore, tc.policy); !slices.Equal(serving(remerged), tc.found) {
+			t.Errorf("%s: re-merged serving %v", tc.policy, serving(remerged))
+		}
+		if got := serving(MergeDiscovered(merged, nil, tc.policy)); !slices.Equal(got, tc.emptied) {
+			t.Errorf("%s: nothing found, serving %v", tc.policy, got)
+		}
+	}
+}
+
+func TestLoad_BackendsFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "backends.json")
+	os.WriteFile(file, []byte(`[{"address": "10.0.0.1:8080", "pool": "blue", "health_check": {"path": "/health"}}, {"address": "localhost:3000"}]`), 0o644)
+	cfg, err := Load(writeTempConfig(t, strings.Replace(configWithToken, "backends: []",
+		"backends:\n    - address: localhost:3000\n      weight: 5\n  backends_file: "+file, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Backend{
+		{Address: "localhost:3000", Weight: 5, HealthCheck: HealthCheckConfig{Interval: 5 * time.Second, Timeout: 2 * time.Second, Scheme: "http"}},
+		{Address: "10.0.0.1:8080", Weight: 100, Pool: "blue", FromFile: true,
+			HealthCheck: HealthCheckConfig{Interval: 5 * time.Second, Timeout: 2 * time.Second, Path: "/health", Scheme: "http"}},
+	}
+	if !slices.Equal(cfg.Proxy.Backends, want) {
+		t.Errorf("got %+v", cfg.Proxy.Backends)
+	}
+	if static := StaticBackends(cfg.Proxy.Backends); len(static) != 1 {
+		t.Errorf("static: got %+v", static)
+	}
+
+	os.WriteFile(file, nil, 0o644)
+	if backends, err := ReadBackendsFile(file); err != nil || len(backends) != 0 {
+		t.Errorf("empty: %+v, %v", backends, err)
+	}
+	for content, want := range map[string]string{
+		"- address: a:1\n  wieght: 2":     "field wieght not found",
+		"- address: a:1\n- address: a:1":  `duplicate backend address "a:1"`,
+		"- address: a:1\n  priority: 200": "proxy.backends_file[0] (a:1): priority must be between",
+		"backends:\n  - address: a:1":     "cannot unmarshal",
+	} {
+		os.WriteFile(file, []byte(content), 0o644)
+		if _, err := ReadBackendsFile(file); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v", content, err)
+		}
+	}
+	if _, err := Load(writeTempConfig(t, strings.Replace(configWithToken, "backends: []",
+		"backends: []\n  backends_file: "+filepath.Join(dir, "missing.yaml"), 1))); err == nil {
+		t.Error("expected an error for a missing backends file")
+	}
+}
+
+func TestMergeFileBackends(t *testing.T) {
+	backends := []Backend{{Address: "a:1"}, {Address: "b:1", Discovered: true}, {Address: "c:1", Discovered: true}, {Address: "old:1", FromFile: true}}
+	merged := MergeFileBackends(backends, []Backend{{Address: "a:1", Weight: 5}, {Address: "b:1", Weight: 10, FromFile: true}})
+	want := []Backend{{Address: "a:1"}, {Address: "c:1", Discovered: true}, {Address: "b:1", Weight: 10, FromFile: true}}
+	if !slices.Equal(merged, want) {
+		t.Errorf("got %+v", merged)
+	}
+	// Discovery leaves the file's backends alone
+	if merged := MergeDiscovered(want, []Backend{{Address: "b:1"}}, MergeUnion); !slices.Equal(merged, []Backend{{Address: "a:1"}, {Address: "b:1", Weight: 10, FromFile: true}}) {
+		t.Errorf("discovered: got %+v", merged)
+	}
+}
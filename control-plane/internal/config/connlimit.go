@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Connection limit overflow policies.
+const (
+	// OverflowReject closes connections over the limit as they arrive.
+	OverflowReject = "reject"
+	// OverflowQueue holds connections over the limit until a slot comes
+	// free, and closes them if none does within the queue timeout.
+	OverflowQueue = "queue"
+)
+
+// DefaultQueueTimeout is how long a queued connection waits for a slot when
+// connection_limit.queue_timeout isn't set.
+const DefaultQueueTimeout = 5 * time.Second
+
+// ConnectionLimitConfig caps the TCP connections a data plane proxies at
+// once, to keep a flood from exhausting the proxy host's file descriptors
+// and memory rather than to protect the backends. The cap is per data
+// plane, across all backends. Envoy can only reject, so xDS clients get
+// the limit on the TCP listener and a queueing config is refused.
+type ConnectionLimitConfig struct {
+	// Max is the most connections proxied at once; 0 (the default) is no
+	// limit.
+	Max int `yaml:"max,omitempty"`
+	// Overflow is OverflowReject (the default) or OverflowQueue.
+	Overflow string `yaml:"overflow,omitempty"`
+	// QueueSize is the most connections queued at once, any more being
+	// closed; it defaults to Max.
+	QueueSize int `yaml:"queue_size,omitempty"`
+	// QueueTimeout defaults to DefaultQueueTimeout.
+	QueueTimeout time.Duration `yaml:"queue_timeout,omitempty"`
+}
+
+// Queues reports whether connections over the limit wait for a slot.
+func (l ConnectionLimitConfig) Queues() bool {
+	return l.Max > 0 && l.Overflow == OverflowQueue
+}
+
+func validateConnectionLimit(c *Config) []string {
+	l := c.Proxy.Traffic.ConnectionLimit
+	var errs []string
+	if l.Max < 0 {
+		errs = append(errs, "proxy.traffic.connection_limit.max must be >= 0")
+	}
+	if l.Overflow != OverflowReject && l.Overflow != OverflowQueue {
+		errs = append(errs, fmt.Sprintf("proxy.traffic.connection_limit.overflow must be %q or %q, got %q", OverflowReject, OverflowQueue, l.Overflow))
+	}
+	if l.QueueSize < 0 {
+		errs = append(errs, "proxy.traffic.connection_limit.queue_size must be >= 0")
+	}
+	if l.QueueTimeout < 0 {
+		errs = append(errs, "proxy.traffic.connection_limit.queue_timeout must be >= 0")
+	}
+	if l.Queues() && c.XDS.Enabled {
+		errs = append(errs, "proxy.traffic.connection_limit.overflow queue isn't supported by Envoy: use reject with xds.enabled")
+	}
+	return errs
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a parsed five-field cron expression: minute, hour, day
+// of month, month and day of week. Each field is *, a number, a range a-b,
+// either of those with a /step, or a comma-separated list of them. Days of
+// the week run from 0 (Sunday) to 6, and 7 is Sunday too. As in cron, a day
+// matches when it matches either day field, unless one of them starts
+// with *.
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+// cronFields are each field's name and bounds, in expression order.
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// cronHorizonDays bounds the search in Next, so an expression that can
+// never match, like 30 February, ends it.
+const cronHorizonDays = 5 * 366
+
+func ParseCron(expr string) (CronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return CronSchedule{}, fmt.Errorf("cron expression %q must have 5 fields (minute hour day-of-month month day-of-week), got %d", expr, len(fields))
+	}
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return CronSchedule{}, fmt.Errorf("cron expression %q: %s: %w", expr, cronFields[i].name, err)
+		}
+		sets[i] = set
+	}
+	// Sunday is both 0 and 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return CronSchedule{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		domAny: strings.HasPrefix(fields[2], "*"),
+		dowAny: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepText)
+			}
+			step = n
+		}
+		lo, hi := min, max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			a, b, _ := strings.Cut(rng, "-")
+			var errA, errB error
+			lo, errA = strconv.Atoi(a)
+			hi, errB = strconv.Atoi(b)
+			if errA != nil || errB != nil || lo > hi {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		default:
+			n, err := strconv.Atoi(rng)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", rng)
+			}
+			lo, hi = n, n
+			if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max {
+			return 0, fmt.Errorf("%q is outside %d-%d", rng, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// Next returns the first minute after t the schedule matches, in t's
+// location, or the zero time if there's none within five years. A time
+// skipped by a daylight saving change is matched at the wall clock time it
+// turns into, and one repeated by it only once.
+func (c CronSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	for limit := day.AddDate(0, 0, cronHorizonDays); day.Before(limit); day = day.AddDate(0, 0, 1) {
+		if c.month&(1<<uint(day.Month())) == 0 || !c.dayMatches(day) {
+			continue
+		}
+		for hour := 0; hour < 24; hour++ {
+			if c.hour&(1<<uint(hour)) == 0 {
+				continue
+			}
+			for minute := 0; minute < 60; minute++ {
+				if c.minute&(1<<uint(minute)) == 0 {
+					continue
+				}
+				// time.Date moves a skipped wall clock time forward
+				if next := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, loc); next.After(t) {
+					return next
+				}
+			}
+		}
+	}
+	return time.Time{}
+}
+
+func (c CronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	}
+	return dom || dow
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// DarkLaunchRule sends Percent of the TCP connections no header rule, SNI
+// route or geo route takes to an experimental pool, while one of its
+// time windows is open, to launch a feature to a slice of real traffic and
+// widen it without a redeploy. A rule without windows is always on. The
+// pool only gets the connections its rules send it, so it's idle outside
+// their windows.
+type DarkLaunchRule struct {
+	Name string `yaml:"name"`
+	// Pool is where the rule's share goes, from the backends' pool
+	// labels.
+	Pool string `yaml:"pool"`
+	// Percent of connections go to the pool, 1 to 100; the rules' shares
+	// together can't be more than 100.
+	Percent int          `yaml:"percent"`
+	Windows []TimeWindow `yaml:"windows,omitempty"`
+}
+
+// TimeWindow is a time of day range on some days of the week, read in
+// scheduler.timezone. An End before Start runs past midnight into the
+// next day, which the window still counts as its start day's.
+type TimeWindow struct {
+	// Days are mon to sun; every day when empty.
+	Days []string `yaml:"days,omitempty"`
+	// Start and End are 24-hour HH:MM times.
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseClock reads an HH:MM time of day as minutes past midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil || len(s) != 5 {
+		return 0, fmt.Errorf("%q must be a 24-hour HH:MM time", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// occurrence returns when w opens and closes if it opens on day, the
+// midnight starting some day in the schedule's zone, and false if it
+// doesn't open that day. It's only called on a validated window.
+func (w TimeWindow) occurrence(day time.Time) (start, end time.Time, ok bool) {
+	if len(w.Days) > 0 && !slices.ContainsFunc(w.Days, func(d string) bool { return weekdays[d] == day.Weekday() }) {
+		return time.Time{}, time.Time{}, false
+	}
+	from, _ := parseClock(w.Start)
+	to, _ := parseClock(w.End)
+	if to <= from {
+		to += 24 * 60
+	}
+	y, m, d := day.Date()
+	// Built from the date, not added to it, to land on the wall clock
+	// time across DST changes
+	start = time.Date(y, m, d, from/60, from%60, 0, 0, day.Location())
+	end = time.Date(y, m, d, to/60, to%60, 0, 0, day.Location())
+	return start, end, true
+}
+
+// midnight is the start of t's day in t's zone, offset days later.
+func midnight(t time.Time, days int) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d+days, 0, 0, 0, 0, t.Location())
+}
+
+// ActiveAt reports whether r is on at t, given in the zone its windows
+// are read in.
+func (r DarkLaunchRule) ActiveAt(t time.Time) bool {
+	if len(r.Windows) == 0 {
+		return true
+	}
+	for _, w := range r.Windows {
+		// A window that opened yesterday may run past midnight
+		for _, days := range []int{-1, 0} {
+			if start, end, ok := w.occurrence(midnight(t, days)); ok && !t.Before(start) && t.Before(end) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NextChange returns the first time after t, given in the zone r's
+// windows are read in, that one of them opens or closes; zero for a rule
+// without windows. Overlapping windows can give a time at which r stays
+// on.
+func (r DarkLaunchRule) NextChange(t time.Time) time.Time {
+	var next time.Time
+	for _, w := range r.Windows {
+		for days := -1; days <= 7; days++ {
+			start, end, ok := w.occurrence(midnight(t, days))
+			if !ok {
+				continue
+			}
+			for _, edge := range []time.Time{start, end} {
+				if edge.After(t) && (next.IsZero() || edge.Before(next)) {
+					next = edge
+				}
+			}
+		}
+	}
+	return next
+}
+
+// DarkLaunchPools returns the pools dark launch rules send to, in the
+// order of the first rule to each.
+func (p ProxyConfig) DarkLaunchPools() []string {
+	var pools []string
+	for _, r := range p.DarkLaunches {
+		if r.Pool != "" && !slices.Contains(pools, r.Pool) {
+			pools = append(pools, r.Pool)
+		}
+	}
+	return pools
+}
+
+// ValidateDarkLaunches checks c.Proxy.DarkLaunches against its TCP
+// backends: each rule has a share, windows that parse and a pool with a
+// backend of its own that nothing else splits, switches or mirrors to.
+// The control plane turns rules on and off by pushing to aegis-data,
+// which Envoy isn't, so they can't be used with xDS.
+func ValidateDarkLaunches(c *Config) []string {
+	p := c.Proxy
+	if len(p.DarkLaunches) == 0 {
+		return nil
+	}
+	var errs []string
+	if c.XDS.Enabled {
+		errs = append(errs, "proxy.dark_launches aren't supported with xds.enabled")
+	}
+	names := make(map[string]bool, len(p.DarkLaunches))
+	total := 0
+	for i, r := range p.DarkLaunches {
+		field := fmt.Sprintf("proxy.dark_launches[%d]", i)
+		if r.Name == "" {
+			errs = append(errs, field+".name is required")
+		} else if names[r.Name] {
+			errs = append(errs, fmt.Sprintf("proxy.dark_launches: duplicate name %q", r.Name))
+		}
+		names[r.Name] = true
+		if r.Percent < 1 || r.Percent > 100 {
+			errs = append(errs, fmt.Sprintf("%s.percent must be between 1 and 100, got %d", field, r.Percent))
+		}
+		total += r.Percent
+		switch {
+		case r.Pool == "":
+			errs = append(errs, field+".pool is required")
+		case len(PoolBackends(p.Backends, r.Pool)) == 0:
+			errs = append(errs, fmt.Sprintf("%s: no backend in proxy.backends has pool %q", field, r.Pool))
+		}
+		for j, w := range r.Windows {
+			f := fmt.Sprintf("%s.windows[%d]", field, j)
+			for _, d := range w.Days {
+				if _, ok := weekdays[d]; !ok {
+					errs = append(errs, fmt.Sprintf("%s.days: %q must be one of mon, tue, wed, thu, fri, sat or sun", f, d))
+				}
+			}
+			start, err := parseClock(w.Start)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s.start: %v", f, err))
+			}
+			end, err2 := parseClock(w.End)
+			if err2 != nil {
+				errs = append(errs, fmt.Sprintf("%s.end: %v", f, err2))
+			}
+			if err == nil && err2 == nil && start == end {
+				errs = append(errs, fmt.Sprintf("%s: start and end are both %s; leave windows out for a rule that's always on", f, w.Start))
+			}
+		}
+	}
+	if total > 100 {
+		errs = append(errs, fmt.Sprintf("proxy.dark_launches: percents add up to %d, more than 100", total))
+	}
+
+	pools := p.DarkLaunchPools()
+	if !slices.ContainsFunc(p.Backends, func(b Backend) bool { return !slices.Contains(pools, b.Pool) }) {
+		errs = append(errs, "proxy.dark_launches: every backend is in a dark launch pool, leaving none to serve the rest")
+	}
+	for _, pool := range pools {
+		if _, ok := p.TrafficSplit[pool]; ok {
+			errs = append(errs, fmt.Sprintf("proxy.dark_launches: pool %q can't also be in proxy.traffic_split", pool))
+		}
+		if slices.Contains(p.BlueGreen.Pools, pool) {
+			errs = append(errs, fmt.Sprintf("proxy.dark_launches: pool %q can't also be a proxy.blue_green pool", pool))
+		}
+		if p.Mirror.Pool == pool {
+			errs = append(errs, fmt.Sprintf("proxy.dark_launches: pool %q can't also be the proxy.mirror pool", pool))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,459 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Discovery providers.
+const (
+	// DiscoveryDNSSRV looks the backends up as the targets of an SRV
+	// record.
+	DiscoveryDNSSRV = "dns_srv"
+	// DiscoveryDNS resolves a hostname to a backend for each of its A and
+	// AAAA records, e.g. an autoscaling group behind round-robin DNS.
+	DiscoveryDNS = "dns"
+	// DiscoveryEtcd reads the backends from the JSON values under an etcd
+	// key prefix, watching it for changes.
+	DiscoveryEtcd = "etcd"
+	// DiscoveryDocker registers the published ports of the local Docker
+	// daemon's containers labelled aegis.backend=true, watching its
+	// events.
+	DiscoveryDocker = "docker"
+	// DiscoveryAWS lists EC2 instances by their tags or the target groups
+	// they're registered in.
+	DiscoveryAWS = "aws"
+	// DiscoveryNomad reads the backends from Nomad's service catalog,
+	// watching the service with blocking queries.
+	DiscoveryNomad = "nomad"
+	// DiscoveryZooKeeper registers the ephemeral children of a znode,
+	// watching them.
+	DiscoveryZooKeeper = "zookeeper"
+	// DiscoveryCloudMap registers the instances of an AWS Cloud Map
+	// service, e.g. an ECS service's tasks.
+	DiscoveryCloudMap = "cloudmap"
+)
+
+// Cloud Map health statuses, which of a service's instances cloudmap
+// registers.
+const (
+	// CloudMapHealthy registers the healthy instances only.
+	CloudMapHealthy = "healthy"
+	// CloudMapAll registers every instance, healthy or not.
+	CloudMapAll = "all"
+	// CloudMapHealthyOrElseAll registers the healthy instances, or every
+	// one while none is.
+	CloudMapHealthyOrElseAll = "healthy_or_else_all"
+)
+
+// MinAWSDiscoveryInterval keeps aws discovery's EC2 and ELB calls well
+// inside their API rate limits.
+const MinAWSDiscoveryInterval = 10 * time.Second
+
+// DefaultDockerHost is the daemon docker discovery asks when
+// proxy.discovery.docker_host isn't set.
+const DefaultDockerHost = "unix:///var/run/docker.sock"
+
+// DefaultNomadAddress is the Nomad agent nomad discovery asks when neither
+// proxy.discovery.nomad_address nor NOMAD_ADDR is set.
+const DefaultNomadAddress = "http://127.0.0.1:4646"
+
+// Merge policies, how proxy.discovery's backends and proxy.backends make
+// up the pool.
+const (
+	// MergeUnion serves both.
+	MergeUnion = "union"
+	// MergeDiscovery serves what discovery finds in place of
+	// proxy.backends, which only serve the addresses it found too.
+	MergeDiscovery = "discovery"
+	// MergeFallback serves proxy.backends only while discovery finds
+	// nothing, and what it finds otherwise.
+	MergeFallback = "fallback"
+)
+
+// DefaultDiscoveryInterval is how often the backends are looked up when
+// proxy.discovery.interval isn't set.
+const DefaultDiscoveryInterval = 30 * time.Second
+
+// DiscoveryConfig finds TCP backends in a service registry and keeps them in
+// the running config next to proxy.backends: every interval the control
+// plane looks them up again and pushes the ones that came and went. A
+// failed lookup leaves the backends found before in place.
+type DiscoveryConfig struct {
+	// Provider is dns_srv, dns, etcd, docker, aws, nomad, zookeeper,
+	// cloudmap or the name of one registered with
+	// RegisterDiscoveryProvider; discovery is off when empty.
+	Provider string `yaml:"provider,omitempty"`
+	// Service is the SRV record dns_srv looks up, e.g.
+	// _api._tcp.example.com, or the name of the service nomad or
+	// cloudmap reads.
+	Service string `yaml:"service,omitempty"`
+	// Address is the host:port dns resolves the host of, each address
+	// found becoming a backend on port.
+	Address string `yaml:"address,omitempty"`
+	// EtcdConfig is the cluster etcd reads from.
+	EtcdConfig `yaml:",inline"`
+	// Prefix is the etcd key prefix with a value per instance, e.g.
+	// /services/api/.
+	Prefix string `yaml:"prefix,omitempty"`
+	// DockerHost is the Docker daemon docker watches, unix:///path or
+	// tcp://host:port like DOCKER_HOST.
+	DockerHost string `yaml:"docker_host,omitempty"`
+	// Region is the AWS region aws and cloudmap ask in; AWS_REGION or
+	// AWS_DEFAULT_REGION when empty.
+	Region string `yaml:"region,omitempty"`
+	// Tags select the running EC2 instances aws lists, by tag key and
+	// value, an instance needing every one; an empty value matches any.
+	Tags map[string]string `yaml:"tags,omitempty"`
+	// Port is what the instances Tags select listen on, or the Cloud Map
+	// instances registered without AWS_INSTANCE_PORT.
+	Port int `yaml:"port,omitempty"`
+	// TargetGroups are the ARNs of ELBv2 target groups whose targets aws
+	// also lists, on their registered ports.
+	TargetGroups []string `yaml:"target_groups,omitempty"`
+	// NomadAddress is the URL of the Nomad agent nomad asks; NOMAD_ADDR
+	// when empty.
+	NomadAddress string `yaml:"nomad_address,omitempty"`
+	// Namespace is the Nomad namespace the service is in; NOMAD_NAMESPACE,
+	// or Nomad's default when empty. For cloudmap it's the name of the
+	// Cloud Map namespace, e.g. internal.example.com.
+	Namespace string `yaml:"namespace,omitempty"`
+	// HealthStatus is which of the Cloud Map service's instances cloudmap
+	// registers, healthy when empty.
+	HealthStatus string `yaml:"health_status,omitempty"`
+	// Attributes select the Cloud Map instances cloudmap registers, by
+	// attribute and value, an instance needing every one, e.g.
+	// ECS_CLUSTER_NAME.
+	Attributes map[string]string `yaml:"attributes,omitempty"`
+	// Servers are the host:port of the ZooKeeper ensemble's members
+	// zookeeper reads from, tried in order.
+	Servers []string `yaml:"servers,omitempty"`
+	// Path is the znode whose children zookeeper registers, e.g.
+	// /services/api.
+	Path string `yaml:"path,omitempty"`
+	// Options configure a registered provider, which says what it takes.
+	Options map[string]string `yaml:"options,omitempty"`
+	// Resolver is the DNS server asked, as host:port; the system's when
+	// empty.
+	Resolver string        `yaml:"resolver,omitempty"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// HealthCheck is given to every backend found, with the same defaults
+	// as a configured backend's.
+	HealthCheck HealthCheckConfig `yaml:"health_check,omitempty"`
+	// Merge is the merge policy, union when empty. Whatever it is, an
+	// address both have keeps its configured settings.
+	Merge string `yaml:"merge,omitempty"`
+	// HoldEmpty is how long discovery has to keep finding no backends
+	// before the ones it found before are dropped, so a registry that
+	// flaps doesn't empty the pool; two intervals when not set.
+	HoldEmpty time.Duration `yaml:"hold_empty,omitempty"`
+	// MaxRemovalPercent and MinBackends bound how far a set discovery
+	// finds may shrink the one pushed before it: one dropping more than
+	// MaxRemovalPercent of its backends, or leaving fewer than
+	// MinBackends, is held back until a set within bounds replaces it or
+	// it's confirmed through the API. 0 is no bound.
+	MaxRemovalPercent int `yaml:"max_removal_percent,omitempty"`
+	MinBackends       int `yaml:"min_backends,omitempty"`
+	// MinPushInterval rate-limits the pushes of what discovery finds; the
+	// sets found in between are pushed together, as the latest, once it's
+	// up.
+	MinPushInterval time.Duration `yaml:"min_push_interval,omitempty"`
+}
+
+// registeredProviders are the discovery providers registered on top of
+// the built-in ones, by name, with what checks their config.
+var (
+	registeredMu        sync.RWMutex
+	registeredProviders = map[string]func(DiscoveryConfig) error{}
+)
+
+// RegisterDiscoveryProvider makes name a valid proxy.discovery.provider,
+// its config checked by validate. The discovery package's Register calls
+// it; it's meant for init functions, before any config is loaded.
+func RegisterDiscoveryProvider(name string, validate func(DiscoveryConfig) error) {
+	registeredMu.Lock()
+	defer registeredMu.Unlock()
+	registeredProviders[name] = validate
+}
+
+func registeredProvider(name string) (func(DiscoveryConfig) error, bool) {
+	registeredMu.RLock()
+	defer registeredMu.RUnlock()
+	validate, ok := registeredProviders[name]
+	return validate, ok
+}
+
+// Enabled reports whether backends are discovered.
+func (d DiscoveryConfig) Enabled() bool {
+	return d.Provider != ""
+}
+
+// Equal reports whether d and o are the same config.
+func (d DiscoveryConfig) Equal(o DiscoveryConfig) bool {
+	return d.EtcdConfig.equal(o.EtcdConfig) &&
+		d.Provider == o.Provider && d.Service == o.Service && d.Address == o.Address && d.Prefix == o.Prefix &&
+		d.DockerHost == o.DockerHost && d.Region == o.Region && maps.Equal(d.Tags, o.Tags) && d.Port == o.Port &&
+		slices.Equal(d.TargetGroups, o.TargetGroups) && d.NomadAddress == o.NomadAddress && d.Namespace == o.Namespace &&
+		d.HealthStatus == o.HealthStatus && maps.Equal(d.Attributes, o.Attributes) &&
+		slices.Equal(d.Servers, o.Servers) && d.Path == o.Path && maps.Equal(d.Options, o.Options) && d.Resolver == o.Resolver && d.Interval == o.Interval && d.HealthCheck == o.HealthCheck &&
+		d.Merge == o.Merge && d.HoldEmpty == o.HoldEmpty && d.MaxRemovalPercent == o.MaxRemovalPercent && d.MinBackends == o.MinBackends &&
+		d.MinPushInterval == o.MinPushInterval
+}
+
+// Target is what's looked up: the SRV record or Nomad service, the address,
+// the key prefix or znode, the Docker daemon, the AWS region or the Cloud
+// Map namespace and service.
+func (d DiscoveryConfig) Target() string {
+	switch d.Provider {
+	case DiscoveryDNS:
+		return d.Address
+	case DiscoveryEtcd:
+		return d.Prefix
+	case DiscoveryDocker:
+		return d.DockerHost
+	case DiscoveryAWS:
+		return d.Region
+	case DiscoveryZooKeeper:
+		return d.Path
+	case DiscoveryCloudMap:
+		return d.Namespace + "/" + d.Service
+	}
+	return d.Service
+}
+
+// StaticBackends returns the backends that weren't discovered or read
+// from proxy.backends_file, the ones the config file has, standing by or
+// not.
+func StaticBackends(backends []Backend) []Backend {
+	static := slices.DeleteFunc(slices.Clone(backends), func(b Backend) bool { return b.Discovered || b.FromFile })
+	for i := range static {
+		static[i].Standby = false
+	}
+	return static
+}
+
+// MergeDiscovered returns backends with the discovered ones among them
+// replaced by found, marked discovered, and the configured ones set to
+// stand by as policy says. A backend found that backends already has,
+// configured or from the backends file, is left as it is. Neither slice
+// is modified.
+func MergeDiscovered(backends, found []Backend, policy string) []Backend {
+	merged := slices.DeleteFunc(slices.Clone(backends), func(b Backend) bool { return b.Discovered })
+	for _, b := range found {
+		if !slices.ContainsFunc(merged, func(s Backend) bool { return s.Address == b.Address }) {
+			b.Discovered = true
+			merged = append(merged, b)
+		}
+	}
+	for i, b := range merged {
+		if b.Discovered || b.FromFile {
+			continue
+		}
+		wasFound := slices.ContainsFunc(found, func(f Backend) bool { return f.Address == b.Address })
+		switch policy {
+		case MergeDiscovery:
+			merged[i].Standby = !wasFound
+		case MergeFallback:
+			merged[i].Standby = len(found) > 0 && !wasFound
+		default:
+			merged[i].Standby = false
+		}
+	}
+	return merged
+}
+
+// FoundBackends returns what discovery found, as MergeDiscovered merged
+// it into backends under policy: the discovered backends, and the
+// configured ones it found too, as far as their standing by tells.
+func FoundBackends(backends []Backend, policy string) []Backend {
+	configured := func(b Backend) bool { return !b.Discovered && !b.FromFile }
+	// Under fallback a configured backend is only known to be found while
+	// something else was, and when none stands by it makes no difference
+	withConfigured := policy == MergeDiscovery || policy == MergeFallback &&
+		slices.ContainsFunc(backends, func(b Backend) bool { return b.Discovered || configured(b) && b.Standby })
+	var found []Backend
+	for _, b := range backends {
+		if b.Discovered || withConfigured && configured(b) && !b.Standby {
+			found = append(found, b)
+		}
+	}
+	return found
+}
+
+func validateDiscovery(d DiscoveryConfig) []string {
+	if !d.Enabled() {
+		return nil
+	}
+	var errs []string
+	switch d.Provider {
+	case DiscoveryDNSSRV:
+		if d.Service == "" {
+			errs = append(errs, "proxy.discovery.service is required with provider dns_srv")
+		}
+		if d.Address != "" {
+			errs = append(errs, "proxy.discovery.address is for provider dns; dns_srv takes the ports from the SRV record")
+		}
+	case DiscoveryDNS:
+		host, port, err := net.SplitHostPort(d.Address)
+		switch {
+		case d.Address == "":
+			errs = append(errs, "proxy.discovery.address is required with provider dns")
+		case err != nil || host == "" || port == "":
+			errs = append(errs, fmt.Sprintf("proxy.discovery.address must be host:port, got %q", d.Address))
+		}
+		if d.Service != "" {
+			errs = append(errs, "proxy.discovery.service is for provider dns_srv")
+		}
+	case DiscoveryEtcd:
+		errs = append(errs, validateEtcd("proxy.discovery", "provider etcd", d.EtcdConfig)...)
+		if d.Prefix == "" {
+			errs = append(errs, "proxy.discovery.prefix is required with provider etcd")
+		}
+		if d.Service != "" || d.Address != "" || d.Resolver != "" {
+			errs = append(errs, "proxy.discovery.service, address and resolver aren't used by provider etcd")
+		}
+	case DiscoveryDocker:
+		if u, err := url.Parse(d.DockerHost); err != nil ||
+			!(u.Scheme == "unix" && u.Path != "" || u.Scheme == "tcp" && u.Port() != "") {
+			errs = append(errs, fmt.Sprintf("proxy.discovery.docker_host must be unix:///path or tcp://host:port, got %q", d.DockerHost))
+		}
+		if d.Service != "" || d.Address != "" || d.Resolver != "" {
+			errs = append(errs, "proxy.discovery.service, address and resolver aren't used by provider docker")
+		}
+	case DiscoveryAWS:
+		if d.Region == "" {
+			errs = append(errs, "proxy.discovery.region is required with provider aws, or set AWS_REGION")
+		}
+		if len(d.Tags) == 0 && len(d.TargetGroups) == 0 {
+			errs = append(errs, "proxy.discovery needs tags, target_groups or both with provider aws")
+		}
+		if len(d.Tags) > 0 && (d.Port < 1 || d.Port > 65535) {
+			errs = append(errs, fmt.Sprintf("proxy.discovery.port must be between 1 and 65535 with tags, got %d", d.Port))
+		}
+		for i, arn := range d.TargetGroups {
+			if !strings.HasPrefix(arn, "arn:") || !strings.Contains(arn, ":targetgroup/") {
+				errs = append(errs, fmt.Sprintf("proxy.discovery.target_groups[%d] must be a target group ARN, got %q", i, arn))
+			}
+		}
+		if d.Interval < MinAWSDiscoveryInterval {
+			errs = append(errs, fmt.Sprintf("proxy.discovery.interval must be at least %s with provider aws, got %s", MinAWSDiscoveryInterval, d.Interval))
+		}
+		if d.Service != "" || d.Address != "" || d.Resolver != "" {
+			errs = append(errs, "proxy.discovery.service, address and resolver aren't used by provider aws")
+		}
+	case DiscoveryNomad:
+		if d.Service == "" {
+			errs = append(errs, "proxy.discovery.service is required with provider nomad")
+		}
+		if u, err := url.Parse(d.NomadAddress); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("proxy.discovery.nomad_address must be an http:// or https:// URL, got %q", d.NomadAddress))
+		}
+		if d.Address != "" || d.Resolver != "" {
+			errs = append(errs, "proxy.discovery.address and resolver aren't used by provider nomad")
+		}
+	case DiscoveryZooKeeper:
+		if len(d.Servers) == 0 {
+			errs = append(errs, "proxy.discovery.servers are required with provider zookeeper")
+		}
+		for i, server := range d.Servers {
+			if host, port, err := net.SplitHostPort(server); err != nil || host == "" || port == "" {
+				errs = append(errs, fmt.Sprintf("proxy.discovery.servers[%d] must be host:port, got %q", i, server))
+			}
+		}
+		if !strings.HasPrefix(d.Path, "/") || len(d.Path) > 1 && strings.HasSuffix(d.Path, "/") {
+			errs = append(errs, fmt.Sprintf("proxy.discovery.path must be an absolute znode path, got %q", d.Path))
+		}
+		if d.Service != "" || d.Address != "" || d.Resolver != "" {
+			errs = append(errs, "proxy.discovery.service, address and resolver aren't used by provider zookeeper")
+		}
+	case DiscoveryCloudMap:
+		if d.Namespace == "" || d.Service == "" {
+			errs = append(errs, "proxy.discovery.namespace and service are required with provider cloudmap")
+		}
+		if d.Region == "" {
+			errs = append(errs, "proxy.discovery.region is required with provider cloudmap, or set AWS_REGION")
+		}
+		if d.Port < 0 || d.Port > 65535 {
+			errs = append(errs, fmt.Sprintf("proxy.discovery.port must be between 1 and 65535 when set, got %d", d.Port))
+		}
+		switch d.HealthStatus {
+		case "", CloudMapHealthy, CloudMapAll, CloudMapHealthyOrElseAll:
+		default:
+			errs = append(errs, fmt.Sprintf("proxy.discovery.health_status must be %q, %q or %q, got %q",
+				CloudMapHealthy, CloudMapAll, CloudMapHealthyOrElseAll, d.HealthStatus))
+		}
+		if d.Address != "" || d.Resolver != "" {
+			errs = append(errs, "proxy.discovery.address and resolver aren't used by provider cloudmap")
+		}
+	default:
+		validate, ok := registeredProvider(d.Provider)
+		if !ok {
+			errs = append(errs, fmt.Sprintf("proxy.discovery.provider must be %q, %q, %q, %q, %q, %q, %q, %q or a registered provider, got %q",
+				DiscoveryDNSSRV, DiscoveryDNS, DiscoveryEtcd, DiscoveryDocker, DiscoveryAWS, DiscoveryNomad, DiscoveryZooKeeper, DiscoveryCloudMap, d.Provider))
+		} else if err := validate(d); err != nil {
+			errs = append(errs, fmt.Sprintf("proxy.discovery (provider %s): %v", d.Provider, err))
+		}
+	}
+	if _, registered := registeredProvider(d.Provider); !registered && len(d.Options) > 0 {
+		errs = append(errs, fmt.Sprintf("proxy.discovery.options are for registered providers; %s doesn't take any", d.Provider))
+	}
+	switch d.Merge {
+	case "", MergeUnion, MergeDiscovery, MergeFallback:
+	default:
+		errs = append(errs, fmt.Sprintf("proxy.discovery.merge must be %q, %q or %q, got %q", MergeUnion, MergeDiscovery, MergeFallback, d.Merge))
+	}
+	if d.HoldEmpty < 0 {
+		errs = append(errs, fmt.Sprintf("proxy.discovery.hold_empty must not be negative, got %s", d.HoldEmpty))
+	}
+	if d.MaxRemovalPercent < 0 || d.MaxRemovalPercent > 100 {
+		errs = append(errs, fmt.Sprintf("proxy.discovery.max_removal_percent must be between 0 and 100, got %d", d.MaxRemovalPercent))
+	}
+	if d.MinBackends < 0 {
+		errs = append(errs, fmt.Sprintf("proxy.discovery.min_backends must not be negative, got %d", d.MinBackends))
+	}
+	if d.MinPushInterval < 0 {
+		errs = append(errs, fmt.Sprintf("proxy.discovery.min_push_interval must not be negative, got %s", d.MinPushInterval))
+	}
+	if d.Provider != DiscoveryAWS && (len(d.Tags) > 0 || len(d.TargetGroups) > 0) {
+		errs = append(errs, "proxy.discovery.tags and target_groups are for provider aws")
+	}
+	if d.Provider != DiscoveryAWS && d.Provider != DiscoveryCloudMap && (d.Region != "" || d.Port != 0) {
+		errs = append(errs, "proxy.discovery.region and port are for providers aws and cloudmap")
+	}
+	if d.Provider != DiscoveryEtcd && (d.EtcdConfig.set() || d.Username != "" || d.Password != "" || d.Prefix != "") {
+		errs = append(errs, "proxy.discovery.endpoints, username, password, ca_file, cert_file, key_file and prefix are for provider etcd")
+	}
+	if d.Provider != DiscoveryDocker && d.DockerHost != "" {
+		errs = append(errs, "proxy.discovery.docker_host is for provider docker")
+	}
+	if d.Provider != DiscoveryNomad && d.NomadAddress != "" {
+		errs = append(errs, "proxy.discovery.nomad_address is for provider nomad")
+	}
+	if d.Provider != DiscoveryNomad && d.Provider != DiscoveryCloudMap && d.Namespace != "" {
+		errs = append(errs, "proxy.discovery.namespace is for providers nomad and cloudmap")
+	}
+	if d.Provider != DiscoveryCloudMap && (d.HealthStatus != "" || len(d.Attributes) > 0) {
+		errs = append(errs, "proxy.discovery.health_status and attributes are for provider cloudmap")
+	}
+	if d.Provider != DiscoveryZooKeeper && (len(d.Servers) > 0 || d.Path != "") {
+		errs = append(errs, "proxy.discovery.servers and path are for provider zookeeper")
+	}
+	if d.Resolver != "" {
+		if _, _, err := net.SplitHostPort(d.Resolver); err != nil {
+			errs = append(errs, fmt.Sprintf("proxy.discovery.resolver must be host:port, got %q", d.Resolver))
+		}
+	}
+	if d.Interval < time.Second {
+		errs = append(errs, fmt.Sprintf("proxy.discovery.interval must be at least 1s, got %s", d.Interval))
+	}
+	if s := d.HealthCheck.Scheme; s != "http" && s != "https" {
+		errs = append(errs, fmt.Sprintf("proxy.discovery.health_check.scheme must be \"http\" or \"https\", got %q", s))
+	}
+	return errs
+}
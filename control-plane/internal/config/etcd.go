@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+)
+
+// EtcdConfig is how the control plane reaches an etcd v3 cluster, through
+// the JSON gateway every member serves on its client port. The state
+// store, leader election and etcd discovery each take one, inline.
+type EtcdConfig struct {
+	// Endpoints are the http:// or https:// URLs of the cluster's members,
+	// tried in order.
+	Endpoints []string `yaml:"endpoints,omitempty"`
+	// Username and Password authenticate with a cluster that has auth
+	// enabled.
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// CAFile verifies https endpoints served with a private CA; the
+	// system roots when empty. CertFile and KeyFile are the client
+	// certificate presented to a cluster that requires one.
+	CAFile   string `yaml:"ca_file,omitempty"`
+	CertFile string `yaml:"cert_file,omitempty"`
+	KeyFile  string `yaml:"key_file,omitempty"`
+}
+
+// set reports whether any of e's endpoints or TLS files are set; not its
+// credentials, which the state store shares with redis.
+func (e EtcdConfig) set() bool {
+	return len(e.Endpoints) > 0 || e.CAFile != "" || e.CertFile != "" || e.KeyFile != ""
+}
+
+func (e EtcdConfig) equal(o EtcdConfig) bool {
+	return slices.Equal(e.Endpoints, o.Endpoints) && e.Username == o.Username && e.Password == o.Password &&
+		e.CAFile == o.CAFile && e.CertFile == o.CertFile && e.KeyFile == o.KeyFile
+}
+
+// validateEtcd checks the etcd settings of field, e.g. store, used with
+// what, e.g. backend etcd.
+func validateEtcd(field, what string, e EtcdConfig) []string {
+	var errs []string
+	if len(e.Endpoints) == 0 {
+		errs = append(errs, fmt.Sprintf("%s.endpoints are required with %s", field, what))
+	}
+	for i, ep := range e.Endpoints {
+		if u, err := url.Parse(ep); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("%s.endpoints[%d] must be an http:// or https:// URL, got %q", field, i, ep))
+		}
+	}
+	if e.Password != "" && e.Username == "" {
+		errs = append(errs, fmt.Sprintf("%s.password requires username", field))
+	}
+	if (e.CertFile == "") != (e.KeyFile == "") {
+		errs = append(errs, fmt.Sprintf("%s.cert_file and key_file must be set together", field))
+	}
+	return errs
+}
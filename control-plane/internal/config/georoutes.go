@@ -0,0 +1,158 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"github.com/lazzerex/aegis/control-plane/internal/geoip"
+)
+
+// GeoIPConfig is the database geo routes place clients with.
+type GeoIPConfig struct {
+	// Database is the path of a MaxMind DB (.mmdb) file with countries,
+	// such as GeoLite2-Country or GeoIP2-City. It's read again on every
+	// reload, so an updated file is picked up with one.
+	Database string `yaml:"database,omitempty"`
+}
+
+// GeoRoute sends TCP connections from clients the GeoIP database places
+// in one of Countries or Continents to a backend pool of their own, for
+// data residency or to keep clients near their backends. Routes are tried
+// in order and the first to place a client wins; clients no route places,
+// and addresses the database doesn't have, are balanced over the serving
+// backends as usual.
+type GeoRoute struct {
+	Name string `yaml:"name"`
+	// Countries are ISO 3166-1 alpha-2 codes, e.g. DE.
+	Countries []string `yaml:"countries,omitempty"`
+	// Continents are AF, AN, AS, EU, NA, OC or SA.
+	Continents []string `yaml:"continents,omitempty"`
+	// Pool is where the clients' connections go, from the backends' pool
+	// labels.
+	Pool string `yaml:"pool"`
+	// Networks are the client networks the database places in the route
+	// and no route before it, filled in from proxy.geoip when the config
+	// is loaded.
+	Networks []netip.Prefix `yaml:"-"`
+}
+
+// Places reports whether loc is in one of r's countries or continents.
+func (r GeoRoute) Places(loc geoip.Location) bool {
+	return (loc.Country != "" && slices.Contains(r.Countries, loc.Country)) ||
+		(loc.Continent != "" && slices.Contains(r.Continents, loc.Continent))
+}
+
+// GeoRoutePools returns the pools geo routes send to, in the order of the
+// first route to each.
+func (p ProxyConfig) GeoRoutePools() []string {
+	var pools []string
+	for _, r := range p.GeoRoutes {
+		if r.Pool != "" && !slices.Contains(pools, r.Pool) {
+			pools = append(pools, r.Pool)
+		}
+	}
+	return pools
+}
+
+var continents = []string{"AF", "AN", "AS", "EU", "NA", "OC", "SA"}
+
+// ValidateGeoRoutes checks c.Proxy.GeoRoutes against its TCP backends:
+// each route places clients by valid codes and has a pool with a backend
+// of its own that nothing else splits, switches, mirrors or routes to.
+// Routing by the client's address is kept apart from routing by what it
+// sends, and under xDS needs TCP listeners.
+func ValidateGeoRoutes(c *Config) []string {
+	p := c.Proxy
+	if len(p.GeoRoutes) == 0 {
+		return nil
+	}
+	var errs []string
+	if p.GeoIP.Database == "" {
+		errs = append(errs, "proxy.geo_routes need proxy.geoip.database")
+	}
+	if c.XDS.Enabled && c.XDS.ListenerMode == "http" {
+		errs = append(errs, "proxy.geo_routes need xds.listener_mode tcp")
+	}
+	if len(p.HeaderRules) > 0 {
+		errs = append(errs, "proxy.geo_routes can't be combined with proxy.header_rules")
+	}
+	if len(p.SNIRoutes) > 0 {
+		errs = append(errs, "proxy.geo_routes can't be combined with proxy.sni_routes")
+	}
+
+	names := make(map[string]bool, len(p.GeoRoutes))
+	for i, r := range p.GeoRoutes {
+		field := fmt.Sprintf("proxy.geo_routes[%d]", i)
+		if r.Name == "" {
+			errs = append(errs, field+".name is required")
+		} else if names[r.Name] {
+			errs = append(errs, fmt.Sprintf("proxy.geo_routes: duplicate name %q", r.Name))
+		}
+		names[r.Name] = true
+		if len(r.Countries) == 0 && len(r.Continents) == 0 {
+			errs = append(errs, field+" needs countries or continents")
+		}
+		for _, code := range r.Countries {
+			if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+				errs = append(errs, fmt.Sprintf("%s.countries: %q must be an uppercase ISO 3166-1 alpha-2 code", field, code))
+			}
+		}
+		for _, code := range r.Continents {
+			if !slices.Contains(continents, code) {
+				errs = append(errs, fmt.Sprintf("%s.continents: %q must be one of AF, AN, AS, EU, NA, OC or SA", field, code))
+			}
+		}
+		switch {
+		case r.Pool == "":
+			errs = append(errs, field+".pool is required")
+		case len(PoolBackends(p.Backends, r.Pool)) == 0:
+			errs = append(errs, fmt.Sprintf("%s: no backend in proxy.backends has pool %q", field, r.Pool))
+		}
+	}
+
+	// Route pools are out of the default rotation, like SNI route pools
+	pools := p.GeoRoutePools()
+	if !slices.ContainsFunc(p.Backends, func(b Backend) bool { return !slices.Contains(pools, b.Pool) }) {
+		errs = append(errs, "proxy.geo_routes: every backend is in a geo route's pool, leaving none to serve other clients")
+	}
+	for _, pool := range pools {
+		if _, ok := p.TrafficSplit[pool]; ok {
+			errs = append(errs, fmt.Sprintf("proxy.geo_routes: pool %q can't also be in proxy.traffic_split", pool))
+		}
+		if slices.Contains(p.BlueGreen.Pools, pool) {
+			errs = append(errs, fmt.Sprintf("proxy.geo_routes: pool %q can't also be a proxy.blue_green pool", pool))
+		}
+		if p.Mirror.Pool == pool {
+			errs = append(errs, fmt.Sprintf("proxy.geo_routes: pool %q can't also be the proxy.mirror pool", pool))
+		}
+	}
+	return errs
+}
+
+// resolveGeoRoutes fills in the networks of p's geo routes from its GeoIP
+// database. A route the database places no client in is refused, as the
+// likely result of a wrong code.
+func resolveGeoRoutes(p *ProxyConfig) error {
+	if len(p.GeoRoutes) == 0 {
+		return nil
+	}
+	db, err := geoip.Open(p.GeoIP.Database)
+	if err != nil {
+		return fmt.Errorf("proxy.geoip.database: %w", err)
+	}
+	networks, err := db.Partition(len(p.GeoRoutes), func(loc geoip.Location) int {
+		return slices.IndexFunc(p.GeoRoutes, func(r GeoRoute) bool { return r.Places(loc) })
+	})
+	if err != nil {
+		return fmt.Errorf("proxy.geoip.database: %s: %w", p.GeoIP.Database, err)
+	}
+	for i := range p.GeoRoutes {
+		if len(networks[i]) == 0 {
+			return fmt.Errorf("proxy.geo_routes[%d]: %s has no networks in any of its countries and continents",
+				i, p.GeoIP.Database)
+		}
+		p.GeoRoutes[i].Networks = networks[i]
+	}
+	return nil
+}
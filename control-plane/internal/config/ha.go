@@ -0,0 +1,91 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Leader election backends.
+const (
+	// HABackendKubernetes holds the lease in a coordination.k8s.io Lease
+	// object, with the pod's service account.
+	HABackendKubernetes = "kubernetes"
+	// HABackendEtcd holds the lease in an etcd key.
+	HABackendEtcd = "etcd"
+)
+
+// Leader election defaults, those of Kubernetes' own controllers: a
+// leader that dies is replaced within LeaseDuration plus RetryPeriod.
+const (
+	DefaultLeaseDuration = 15 * time.Second
+	DefaultRenewDeadline = 10 * time.Second
+	DefaultRetryPeriod   = 2 * time.Second
+)
+
+// HAConfig runs the control plane as one of several replicas, which elect
+// a leader through a lease. Only the leader pushes config to the data
+// plane, runs health checks and the controllers that change the running
+// config; the others serve the read-only admin API, refusing changes, and
+// one takes over once the leader stops renewing the lease. Changing it
+// needs a restart.
+type HAConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Backend is kubernetes or etcd.
+	Backend string `yaml:"backend,omitempty"`
+	// Identity names this replica in the lease, and in the errors
+	// followers answer changes with; the hostname, a pod's name, when
+	// empty. Every replica needs its own.
+	Identity string `yaml:"identity,omitempty"`
+	// LeaseDuration is how long followers wait after the lease was last
+	// renewed before taking it, RenewDeadline how long the leader keeps
+	// trying to renew it before stepping down, and RetryPeriod how often
+	// either tries.
+	LeaseDuration time.Duration `yaml:"lease_duration,omitempty"`
+	RenewDeadline time.Duration `yaml:"renew_deadline,omitempty"`
+	RetryPeriod   time.Duration `yaml:"retry_period,omitempty"`
+	// Namespace and Lease name the Lease object kubernetes holds; the
+	// pod's namespace and aegis-control-plane when empty.
+	Namespace string `yaml:"namespace,omitempty"`
+	Lease     string `yaml:"lease,omitempty"`
+	// EtcdConfig is the cluster etcd holds the lease in, under Key,
+	// /aegis/leader when empty.
+	EtcdConfig `yaml:",inline"`
+	Key        string `yaml:"key,omitempty"`
+}
+
+func validateHA(h HAConfig) []string {
+	if !h.Enabled {
+		return nil
+	}
+	var errs []string
+	switch h.Backend {
+	case HABackendKubernetes:
+		if h.EtcdConfig.set() || h.Username != "" || h.Password != "" || h.Key != "" {
+			errs = append(errs, "ha.endpoints, username, password, ca_file, cert_file, key_file and key are for backend etcd")
+		}
+	case HABackendEtcd:
+		errs = append(errs, validateEtcd("ha", "backend etcd", h.EtcdConfig)...)
+		if !strings.HasPrefix(h.Key, "/") {
+			errs = append(errs, fmt.Sprintf("ha.key must start with /, got %q", h.Key))
+		}
+		if h.Namespace != "" || h.Lease != "" {
+			errs = append(errs, "ha.namespace and lease are for backend kubernetes")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("ha.backend must be %q or %q, got %q", HABackendKubernetes, HABackendEtcd, h.Backend))
+	}
+	if h.Identity == "" {
+		errs = append(errs, "ha.identity is required when the hostname can't be read")
+	}
+	if h.RetryPeriod < 100*time.Millisecond {
+		errs = append(errs, fmt.Sprintf("ha.retry_period must be at least 100ms, got %s", h.RetryPeriod))
+	}
+	// The leader has to step down before followers may take over, with a
+	// retry to spare
+	if h.RenewDeadline <= h.RetryPeriod || h.LeaseDuration <= h.RenewDeadline {
+		errs = append(errs, fmt.Sprintf("ha needs retry_period < renew_deadline < lease_duration, got %s, %s and %s",
+			h.RetryPeriod, h.RenewDeadline, h.LeaseDuration))
+	}
+	return errs
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// HeaderRule sends the TCP connections whose HTTP request carries matching
+// headers to a backend pool of their own, e.g. internal test traffic with
+// X-Debug: 1 to a staging pool. Rules are tried in order and the first
+// whose matches all hold wins; a connection no rule matches is balanced
+// over the serving backends as usual. A rule's pool only gets the traffic
+// its rules send it.
+type HeaderRule struct {
+	Name  string        `yaml:"name"`
+	Match []HeaderMatch `yaml:"match"`
+	// Pool is where matching connections go, from the backends' pool
+	// labels.
+	Pool string `yaml:"pool"`
+}
+
+// HeaderMatch holds when the request has the header with exactly the value
+// Exact, or with a value the regular expression Regex matches in full.
+// Header names are case-insensitive, values aren't.
+type HeaderMatch struct {
+	Header string `yaml:"header"`
+	Exact  string `yaml:"exact,omitempty"`
+	Regex  string `yaml:"regex,omitempty"`
+}
+
+// HeaderRulePools returns the pools header rules route to, in the order
+// of the first rule to each.
+func (p ProxyConfig) HeaderRulePools() []string {
+	var pools []string
+	for _, r := range p.HeaderRules {
+		if r.Pool != "" && !slices.Contains(pools, r.Pool) {
+			pools = append(pools, r.Pool)
+		}
+	}
+	return pools
+}
+
+// PoolBackends returns the TCP backends in pool.
+func PoolBackends(backends []Backend, pool string) []Backend {
+	var members []Backend
+	for _, b := range backends {
+		if b.Pool == pool {
+			members = append(members, b)
+		}
+	}
+	return members
+}
+
+// ValidateHeaderRules checks c.Proxy.HeaderRules against its TCP backends:
+// each rule has matches that compile and a pool with a backend of its own
+// that nothing else splits, switches or mirrors to. Under xDS they need
+// HTTP listeners.
+func ValidateHeaderRules(c *Config) []string {
+	p := c.Proxy
+	var errs []string
+	if len(p.HeaderRules) > 0 && c.XDS.Enabled && c.XDS.ListenerMode != "http" {
+		errs = append(errs, "proxy.header_rules need xds.listener_mode http")
+	}
+	names := make(map[string]bool, len(p.HeaderRules))
+	for i, r := range p.HeaderRules {
+		field := fmt.Sprintf("proxy.header_rules[%d]", i)
+		if r.Name == "" {
+			errs = append(errs, field+".name is required")
+		} else if names[r.Name] {
+			errs = append(errs, fmt.Sprintf("proxy.header_rules: duplicate name %q", r.Name))
+		}
+		names[r.Name] = true
+		if len(r.Match) == 0 {
+			errs = append(errs, field+".match needs at least one header")
+		}
+		for j, m := range r.Match {
+			f := fmt.Sprintf("%s.match[%d]", field, j)
+			if strings.TrimSpace(m.Header) == "" || strings.ContainsAny(m.Header, ": \t\r\n") {
+				errs = append(errs, fmt.Sprintf("%s.header must be a header name, got %q", f, m.Header))
+			}
+			switch {
+			case (m.Exact == "") == (m.Regex == ""):
+				errs = append(errs, f+": set one of exact or regex")
+			case m.Regex != "":
+				if _, err := regexp.Compile(m.Regex); err != nil {
+					errs = append(errs, fmt.Sprintf("%s.regex: %v", f, err))
+				}
+			}
+		}
+		switch {
+		case r.Pool == "":
+			errs = append(errs, field+".pool is required")
+		case len(PoolBackends(p.Backends, r.Pool)) == 0:
+			errs = append(errs, fmt.Sprintf("%s: no backend in proxy.backends has pool %q", field, r.Pool))
+		}
+	}
+
+	// Rule pools are out of the default rotation, so they can't also take
+	// a share of it, or be all there is
+	pools := p.HeaderRulePools()
+	if len(pools) > 0 && !slices.ContainsFunc(p.Backends, func(b Backend) bool { return !slices.Contains(pools, b.Pool) }) {
+		errs = append(errs, "proxy.header_rules: every backend is in a header rule's pool, leaving none to serve other traffic")
+	}
+	for _, pool := range pools {
+		if _, ok := p.TrafficSplit[pool]; ok {
+			errs = append(errs, fmt.Sprintf("proxy.header_rules: pool %q can't also be in proxy.traffic_split", pool))
+		}
+		for _, bg := range p.BlueGreen.Pools {
+			if bg == pool {
+				errs = append(errs, fmt.Sprintf("proxy.header_rules: pool %q can't also be a proxy.blue_green pool", pool))
+			}
+		}
+		if p.Mirror.Pool == pool {
+			errs = append(errs, fmt.Sprintf("proxy.header_rules: pool %q can't also be the proxy.mirror pool", pool))
+		}
+	}
+	return errs
+}
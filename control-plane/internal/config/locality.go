@@ -0,0 +1,66 @@
+package config
+
+import "fmt"
+
+// LocalityPreferLocal is the locality policy that keeps traffic in the
+// data plane's own zone.
+const LocalityPreferLocal = "prefer_local"
+
+// LocalityConfig steers each data plane's connections to the backends in
+// its own zone, spilling over to the rest of its region and only then
+// further, to cut cross-zone transfer costs. It applies within the active
+// failover tier, before the algorithm picks a backend.
+type LocalityConfig struct {
+	// Policy is empty (off, the default) or prefer_local.
+	Policy string `yaml:"policy,omitempty"`
+	// Zone and Region are where the data plane runs. A data plane started
+	// with AEGIS_ZONE or AEGIS_REGION set uses those instead, so one config
+	// serves data planes in several zones. With no zone, the region is
+	// what's local.
+	Zone   string `yaml:"zone,omitempty"`
+	Region string `yaml:"region,omitempty"`
+	// SpilloverPercent of connections leave the local zone even while it's
+	// healthy, keeping the other zones warm. Default 0.
+	SpilloverPercent int `yaml:"spillover_percent,omitempty"`
+	// MinHealthyPercent: while a smaller share of the local zone's
+	// backends is healthy, its share of connections shrinks in proportion
+	// and the rest spills over, so a half-failed zone isn't overloaded.
+	// 0 (the default) only spills once none is healthy.
+	MinHealthyPercent int `yaml:"min_healthy_percent,omitempty"`
+}
+
+// HasLocalities reports whether any backend has a zone or region.
+func HasLocalities(backends []Backend) bool {
+	for _, b := range backends {
+		if b.Zone != "" || b.Region != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func validateLocality(p ProxyConfig) []string {
+	l := p.LoadBalancing.Locality
+	var errs []string
+	switch l.Policy {
+	case "":
+	case LocalityPreferLocal:
+		// Like Envoy's zone aware routing, it can't be squared with a hash
+		// that has to send a key to the same backend from every zone
+		if p.LoadBalancing.Algorithm == AlgorithmConsistentHash {
+			errs = append(errs, "proxy.load_balancing.locality: prefer_local can't be used with consistent_hash")
+		}
+		if !HasLocalities(p.Backends) && !HasLocalities(p.UdpBackends) {
+			errs = append(errs, "proxy.load_balancing.locality: prefer_local needs backends with a zone or region")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("proxy.load_balancing.locality.policy must be empty or prefer_local, got %q", l.Policy))
+	}
+	if l.SpilloverPercent < 0 || l.SpilloverPercent > 100 {
+		errs = append(errs, fmt.Sprintf("proxy.load_balancing.locality.spillover_percent must be between 0 and 100, got %d", l.SpilloverPercent))
+	}
+	if l.MinHealthyPercent < 0 || l.MinHealthyPercent > 100 {
+		errs = append(errs, fmt.Sprintf("proxy.load_balancing.locality.min_healthy_percent must be between 0 and 100, got %d", l.MinHealthyPercent))
+	}
+	return errs
+}
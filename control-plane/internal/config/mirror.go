@@ -0,0 +1,63 @@
+package config
+
+import "fmt"
+
+// MirrorConfig copies a share of TCP connections to a shadow backend pool,
+// to try a new backend version on real traffic: the shadow gets what the
+// client sends, its responses are thrown away, and it failing or falling
+// behind never affects the client. The pool's backends are health checked
+// like any other but don't serve. Envoy's TCP proxy can't mirror, so xDS
+// clients only see the pool left out.
+type MirrorConfig struct {
+	// Pool is the shadow pool, from the backends' pool labels; empty
+	// (the default) turns mirroring off.
+	Pool string `yaml:"pool,omitempty"`
+	// Percent of connections are mirrored, 0 to 100; 0 keeps the pool
+	// out of rotation without copying anything to it.
+	Percent int `yaml:"percent,omitempty"`
+}
+
+// MirrorBackends returns the shadow pool's TCP backends, or nil with
+// mirroring off.
+func (p ProxyConfig) MirrorBackends() []Backend {
+	if p.Mirror.Pool == "" {
+		return nil
+	}
+	var shadow []Backend
+	for _, b := range p.Backends {
+		if b.Pool == p.Mirror.Pool {
+			shadow = append(shadow, b)
+		}
+	}
+	return shadow
+}
+
+// ValidateMirror checks p.Mirror against p's TCP backends: the shadow pool
+// has a backend, isn't also split or switched between, and isn't all
+// there is. It's exported for the mirror and backend APIs.
+func ValidateMirror(p ProxyConfig) []string {
+	m := p.Mirror
+	if m.Pool == "" {
+		return nil
+	}
+	var errs []string
+	if m.Percent < 0 || m.Percent > 100 {
+		errs = append(errs, fmt.Sprintf("proxy.mirror.percent must be between 0 and 100, got %d", m.Percent))
+	}
+	shadow := len(p.MirrorBackends())
+	switch {
+	case shadow == 0:
+		errs = append(errs, fmt.Sprintf("proxy.mirror: no backend in proxy.backends has pool %q", m.Pool))
+	case shadow == len(p.Backends):
+		errs = append(errs, fmt.Sprintf("proxy.mirror: every backend is in the shadow pool %q, leaving none to serve", m.Pool))
+	}
+	if _, ok := p.TrafficSplit[m.Pool]; ok {
+		errs = append(errs, fmt.Sprintf("proxy.mirror: pool %q can't also be in proxy.traffic_split", m.Pool))
+	}
+	for _, pool := range p.BlueGreen.Pools {
+		if pool == m.Pool {
+			errs = append(errs, fmt.Sprintf("proxy.mirror: pool %q can't also be a proxy.blue_green pool", m.Pool))
+		}
+	}
+	return errs
+}
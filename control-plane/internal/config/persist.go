@@ -0,0 +1,104 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SaveBackends replaces proxy.backends in filename with backends. The file
+// is edited as a YAML node tree rather than re-marshalled from Config, so
+// everything else in it — comments, key order, env-provided secrets that
+// were never written there — is left as it was.
+func SaveBackends(filename string, backends []Backend) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse config: %w", err)
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return errors.New("config file is not a YAML mapping")
+	}
+
+	var list yaml.Node
+	if err := list.Encode(backends); err != nil {
+		return fmt.Errorf("failed to encode backends: %w", err)
+	}
+	proxy := mappingValue(doc.Content[0], "proxy")
+	if proxy == nil {
+		proxy = &yaml.Node{Kind: yaml.MappingNode}
+		setMappingValue(doc.Content[0], "proxy", proxy)
+	}
+	setMappingValue(proxy, "backends", &list)
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+	enc.Close()
+
+	return writeFileAtomic(filename, buf.Bytes())
+}
+
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func setMappingValue(m *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			// Keep comments attached to the old value's position
+			value.HeadComment = m.Content[i+1].HeadComment
+			value.LineComment = m.Content[i+1].LineComment
+			m.Content[i+1] = value
+			return
+		}
+	}
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+}
+
+// writeFileAtomic replaces filename via a rename so a crash mid-write
+// can't leave a truncated config behind for the next start.
+func writeFileAtomic(filename string, data []byte) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return fmt.Errorf("failed to stat config file: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp config file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return fmt.Errorf("failed to replace config file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package config
+
+import "strconv"
+
+// MaxPriority is the highest backend priority accepted; failover rarely
+// needs more than a few tiers.
+const MaxPriority = 127
+
+// Tier is a failover tier: the backends sharing a priority and whether
+// they're backups. Every primary tier comes before every backup tier.
+type Tier struct {
+	Backup   bool
+	Priority int
+}
+
+// Less reports whether t takes traffic before u.
+func (t Tier) Less(u Tier) bool {
+	if t.Backup != u.Backup {
+		return u.Backup
+	}
+	return t.Priority < u.Priority
+}
+
+// String is "0", "1", ... for primary tiers and "backup", "backup:1", ...
+// for backups, as in priority_failover events.
+func (t Tier) String() string {
+	if !t.Backup {
+		return strconv.Itoa(t.Priority)
+	}
+	if t.Priority == 0 {
+		return "backup"
+	}
+	return "backup:" + strconv.Itoa(t.Priority)
+}
+
+func tierOf(b Backend) Tier {
+	return Tier{Backup: b.Backup, Priority: b.Priority}
+}
+
+// ActiveTier returns the tier the data plane sends traffic to, taking
+// backends missing from healthState as healthy, the way
+// ReloadBackendsWithHealth does: the lowest priority with a healthy
+// primary, or once every primary is down or drained (weight 0), the lowest
+// with a healthy backup. Drained primaries only serve when there's no
+// backup to take over. ok is false when no backend is healthy.
+func ActiveTier(backends []Backend, healthState map[string]bool) (tier Tier, ok bool) {
+	var primary, backup Tier
+	var havePrimary, haveBackup, primaryServing bool
+	for _, b := range backends {
+		if healthy, known := healthState[b.Address]; known && !healthy {
+			continue
+		}
+		t := tierOf(b)
+		switch {
+		case !b.Backup:
+			if !havePrimary || t.Less(primary) {
+				primary, havePrimary = t, true
+			}
+			primaryServing = primaryServing || b.Weight > 0
+		case !haveBackup || t.Less(backup):
+			backup, haveBackup = t, true
+		}
+	}
+	switch {
+	case havePrimary && (primaryServing || !haveBackup):
+		return primary, true
+	case haveBackup:
+		return backup, true
+	}
+	return Tier{}, false
+}
+
+// HasPriorities reports whether any backend is outside the default tier.
+func HasPriorities(backends []Backend) bool {
+	for _, b := range backends {
+		if b.Priority != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// HasBackups reports whether any backend is a backup.
+func HasBackups(backends []Backend) bool {
+	for _, b := range backends {
+		if b.Backup {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateBackups checks that backends, when there are any, aren't all
+// backups: with no primary to fall back from they'd just be primaries.
+// It's exported for the backend API.
+func ValidateBackups(field string, backends []Backend) []string {
+	for _, b := range backends {
+		if !b.Backup {
+			return nil
+		}
+	}
+	if len(backends) == 0 {
+		return nil
+	}
+	return []string{field + ": at least one backend must not be a backup"}
+}
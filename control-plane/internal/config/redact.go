@@ -0,0 +1,144 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "[redacted]"
+
+// Redacted returns a copy of c with credentials replaced, safe to return
+// over the admin API. Webhook URLs keep only scheme and host, since
+// services like Slack put the secret in the path, and OTLP and
+// remote-write header values are hidden since they usually carry an API
+// key, as are the InfluxDB token and the etcd and state store passwords.
+func (c *Config) Redacted() *Config {
+	out := *c
+	if out.Admin.APIToken != "" {
+		out.Admin.APIToken = redacted
+	}
+	out.Admin.APIKeys = make([]APIKeyConfig, len(c.Admin.APIKeys))
+	for i, k := range c.Admin.APIKeys {
+		k.Key = redacted
+		out.Admin.APIKeys[i] = k
+	}
+	out.Events.Webhooks = make([]WebhookConfig, len(c.Events.Webhooks))
+	for i, w := range c.Events.Webhooks {
+		w.URL = redactURL(w.URL)
+		out.Events.Webhooks[i] = w
+	}
+	out.Telemetry.OTLP.Headers = redactHeaders(c.Telemetry.OTLP.Headers)
+	out.Telemetry.RemoteWrite.Headers = redactHeaders(c.Telemetry.RemoteWrite.Headers)
+	if out.Telemetry.InfluxDB.Token != "" {
+		out.Telemetry.InfluxDB.Token = redacted
+	}
+	for _, p := range []*string{&out.Store.Password, &out.HA.Password, &out.Proxy.Discovery.Password} {
+		if *p != "" {
+			*p = redacted
+		}
+	}
+	return &out
+}
+
+// unredact replaces placeholders left by Redacted with the values they
+// stand for in current. A placeholder with nothing to stand for (a key
+// renamed, a webhook moved) is an error rather than a literal value.
+func (c *Config) unredact(current *Config) error {
+	if c.Admin.APIToken == redacted {
+		c.Admin.APIToken = current.Admin.APIToken
+	}
+	if c.Telemetry.InfluxDB.Token == redacted {
+		c.Telemetry.InfluxDB.Token = current.Telemetry.InfluxDB.Token
+	}
+	if c.Store.Password == redacted {
+		c.Store.Password = current.Store.Password
+	}
+	if c.HA.Password == redacted {
+		c.HA.Password = current.HA.Password
+	}
+	if c.Proxy.Discovery.Password == redacted {
+		c.Proxy.Discovery.Password = current.Proxy.Discovery.Password
+	}
+	for i, k := range c.Admin.APIKeys {
+		if k.Key != redacted {
+			continue
+		}
+		found := false
+		for _, cur := range current.Admin.APIKeys {
+			if cur.Name == k.Name {
+				c.Admin.APIKeys[i].Key = cur.Key
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("admin.api_keys[%d] (%s): key is redacted but no running key has that name", i, k.Name)
+		}
+	}
+	for i, w := range c.Events.Webhooks {
+		if !strings.Contains(w.URL, redacted) {
+			continue
+		}
+		if i >= len(current.Events.Webhooks) || redactURL(current.Events.Webhooks[i].URL) != w.URL {
+			return fmt.Errorf("events.webhooks[%d]: url is redacted but doesn't match the running webhook at that position", i)
+		}
+		c.Events.Webhooks[i].URL = current.Events.Webhooks[i].URL
+	}
+	if err := unredactHeaders("telemetry.otlp", c.Telemetry.OTLP.Headers, current.Telemetry.OTLP.Headers); err != nil {
+		return err
+	}
+	return unredactHeaders("telemetry.remote_write", c.Telemetry.RemoteWrite.Headers, current.Telemetry.RemoteWrite.Headers)
+}
+
+func redactHeaders(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	out := make(map[string]string, len(headers))
+	for name := range headers {
+		out[name] = redacted
+	}
+	return out
+}
+
+// unredactHeaders restores redacted header values in headers, in place,
+// from the running config's.
+func unredactHeaders(field string, headers, current map[string]string) error {
+	for name, v := range headers {
+		if v != redacted {
+			continue
+		}
+		cur, ok := current[name]
+		if !ok {
+			return fmt.Errorf("%s.headers[%s]: value is redacted but the running config has no such header", field, name)
+		}
+		headers[name] = cur
+	}
+	return nil
+}
+
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return redacted
+	}
+	if u.User == nil && (u.Path == "" || u.Path == "/") && u.RawQuery == "" {
+		return raw
+	}
+	return u.Scheme + "://" + u.Host + "/" + redacted
+}
+
+// Version identifies c's contents: a truncated SHA-256 of its YAML
+// encoding, so two configs with the same settings have the same version
+// however they were loaded.
+func (c *Config) Version() string {
+	// Config holds no types yaml.v3 can't encode
+	data, _ := yaml.Marshal(c)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,215 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultRetryOn are the conditions a route with retries retries on when
+// retry.retry_on isn't set, in Envoy's retry_on names.
+var DefaultRetryOn = []string{"5xx", "reset", "connect-failure"}
+
+// HTTPRoute gives the requests under a path prefix their own retry,
+// hedging, timeout and rate-limit settings, overriding proxy.traffic's;
+// RouteTraffic merges the two. Routes only mean something to an HTTP
+// proxy: Envoy with xds.listener_mode http, where each becomes a route
+// ahead of the catch-all one. aegis-data proxies at L4 and refuses a
+// config with routes.
+type HTTPRoute struct {
+	Name string `yaml:"name"`
+	// Prefix is the path prefix matched, e.g. /api/; the longest matching
+	// prefix wins.
+	Prefix    string           `yaml:"prefix"`
+	Retry     RouteRetryPolicy `yaml:"retry,omitempty"`
+	Hedge     HedgePolicy      `yaml:"hedge,omitempty"`
+	Timeout   RouteTimeout     `yaml:"timeout,omitempty"`
+	RateLimit RateLimitConfig  `yaml:"rate_limit,omitempty"`
+}
+
+// RouteRetryPolicy is a route's retry policy, or as proxy.traffic.retry
+// the one every HTTP request gets unless its route overrides it.
+type RouteRetryPolicy struct {
+	// Attempts is the most times a request is sent, the first included;
+	// 0 or 1 never retries. Hedged requests count towards it.
+	Attempts      int           `yaml:"attempts,omitempty"`
+	PerTryTimeout time.Duration `yaml:"per_try_timeout,omitempty"`
+	// RetryOn is DefaultRetryOn when unset.
+	RetryOn []string `yaml:"retry_on,omitempty"`
+	// Idempotent marks the route's requests safe to send more than once,
+	// i.e. one reaching two backends does no harm. Hedging requires it.
+	Idempotent bool `yaml:"idempotent,omitempty"`
+}
+
+// HedgePolicy sends a request on to a second backend when the first hasn't
+// answered within Delay, without cancelling it, and takes whichever
+// response comes first: it trades extra backend load for tail latency.
+type HedgePolicy struct {
+	// Delay is the latency threshold; 0 turns hedging off. It's the
+	// route's per-try timeout as far as Envoy is concerned.
+	Delay time.Duration `yaml:"delay,omitempty"`
+}
+
+// RouteTimeout bounds a route's requests.
+type RouteTimeout struct {
+	// Request bounds the whole request, retries and hedges included; 0
+	// leaves Envoy's default of 15s.
+	Request time.Duration `yaml:"request,omitempty"`
+	// Read is how long a request may go without data either way; 0 takes
+	// proxy.traffic.timeout.read.
+	Read time.Duration `yaml:"read,omitempty"`
+}
+
+// Hedged reports whether the route hedges its requests.
+func (r HTTPRoute) Hedged() bool {
+	return r.Hedge.Delay > 0
+}
+
+// RouteTraffic is what a route's requests get once its settings are
+// merged over proxy.traffic.
+type RouteTraffic struct {
+	Retry          RouteRetryPolicy
+	Hedge          HedgePolicy
+	RequestTimeout time.Duration
+	ReadTimeout    time.Duration
+	// RateLimit limits the route's requests per second on each proxy; a
+	// zero RequestsPerSecond is no limit. It's on top of
+	// proxy.traffic.rate_limit, which limits connections.
+	RateLimit RateLimitConfig
+}
+
+// Hedged reports whether the route hedges its requests.
+func (t RouteTraffic) Hedged() bool {
+	return t.Hedge.Delay > 0
+}
+
+// RouteTraffic merges r's settings over proxy.traffic's, field by field: a
+// retry attempts, per_try_timeout, retry_on or timeout.read r sets wins,
+// and one it leaves unset comes from proxy.traffic. Retries are idempotent
+// if either says so. A hedged route doesn't inherit a per_try_timeout, its
+// hedge delay taking that place. The catch-all route is RouteTraffic of the
+// zero HTTPRoute.
+func (p ProxyConfig) RouteTraffic(r HTTPRoute) RouteTraffic {
+	global := p.Traffic.Retry
+	t := RouteTraffic{
+		Retry:          r.Retry,
+		Hedge:          r.Hedge,
+		RequestTimeout: r.Timeout.Request,
+		ReadTimeout:    r.Timeout.Read,
+	}
+	if t.Retry.Attempts == 0 {
+		t.Retry.Attempts = global.Attempts
+	}
+	if t.Retry.PerTryTimeout == 0 && !r.Hedged() {
+		t.Retry.PerTryTimeout = global.PerTryTimeout
+	}
+	if len(t.Retry.RetryOn) == 0 {
+		t.Retry.RetryOn = global.RetryOn
+	}
+	if t.Retry.Attempts > 1 && len(t.Retry.RetryOn) == 0 {
+		t.Retry.RetryOn = DefaultRetryOn
+	}
+	t.Retry.Idempotent = t.Retry.Idempotent || global.Idempotent
+	if t.ReadTimeout == 0 {
+		t.ReadTimeout = p.Traffic.Timeout.Read
+	}
+	if rl := r.RateLimit; rl.RequestsPerSecond > 0 {
+		t.RateLimit = RateLimitConfig{RequestsPerSecond: rl.RequestsPerSecond, Burst: max(rl.Burst, rl.RequestsPerSecond)}
+	}
+	return t
+}
+
+// HasRouteRateLimits reports whether any route limits its requests.
+func (p ProxyConfig) HasRouteRateLimits() bool {
+	for _, r := range p.HTTPRoutes {
+		if r.RateLimit.RequestsPerSecond > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func validateHTTPRoutes(c *Config) []string {
+	var errs []string
+	routes := c.Proxy.HTTPRoutes
+	if len(routes) > 0 && c.XDS.Enabled && c.XDS.ListenerMode != "http" {
+		errs = append(errs, "proxy.http_routes need xds.listener_mode http")
+	}
+	errs = append(errs, validateRetryPolicy("proxy.traffic.retry", c.Proxy.Traffic.Retry)...)
+	if c.Proxy.Traffic.Retry.Attempts > 1 && c.XDS.Enabled && c.XDS.ListenerMode != "http" {
+		errs = append(errs, "proxy.traffic.retry needs xds.listener_mode http")
+	}
+	names := make(map[string]bool, len(routes))
+	prefixes := make(map[string]bool, len(routes))
+	for i, r := range routes {
+		field := fmt.Sprintf("proxy.http_routes[%d]", i)
+		if r.Name == "" {
+			errs = append(errs, field+".name is required")
+		} else if names[r.Name] {
+			errs = append(errs, fmt.Sprintf("proxy.http_routes: duplicate name %q", r.Name))
+		}
+		names[r.Name] = true
+		if !strings.HasPrefix(r.Prefix, "/") {
+			errs = append(errs, fmt.Sprintf("%s.prefix must start with /, got %q", field, r.Prefix))
+		} else if prefixes[r.Prefix] {
+			errs = append(errs, fmt.Sprintf("proxy.http_routes: duplicate prefix %q", r.Prefix))
+		}
+		prefixes[r.Prefix] = true
+		errs = append(errs, validateRetryPolicy(field+".retry", r.Retry)...)
+		if r.Hedge.Delay < 0 {
+			errs = append(errs, field+".hedge.delay must be >= 0")
+		}
+		if r.Timeout.Request < 0 {
+			errs = append(errs, field+".timeout.request must be >= 0")
+		}
+		if r.Timeout.Read < 0 {
+			errs = append(errs, field+".timeout.read must be >= 0")
+		}
+		if r.RateLimit.RequestsPerSecond < 0 {
+			errs = append(errs, field+".rate_limit.requests_per_second must be >= 0")
+		}
+		if r.RateLimit.Burst < 0 {
+			errs = append(errs, field+".rate_limit.burst must be >= 0")
+		} else if r.RateLimit.Burst > 0 && r.RateLimit.RequestsPerSecond == 0 {
+			errs = append(errs, field+".rate_limit.burst needs requests_per_second")
+		}
+
+		// What's left is checked after merging, so a conflict with an
+		// inherited setting is caught too
+		t := c.Proxy.RouteTraffic(r)
+		if d := t.RequestTimeout; d > 0 {
+			if t.Retry.Attempts > 1 && t.Retry.PerTryTimeout >= d {
+				errs = append(errs, fmt.Sprintf("%s: retry.per_try_timeout %s must be shorter than timeout.request %s", field, t.Retry.PerTryTimeout, d))
+			}
+			if t.Hedged() && t.Hedge.Delay >= d {
+				errs = append(errs, fmt.Sprintf("%s: hedge.delay %s must be shorter than timeout.request %s", field, t.Hedge.Delay, d))
+			}
+		}
+		if !t.Hedged() {
+			continue
+		}
+		// A hedged request reaches two backends, so only routes whose
+		// retries were marked safe can hedge
+		if !t.Retry.Idempotent {
+			errs = append(errs, field+".hedge needs retry.idempotent: a hedged request is sent to more than one backend")
+		}
+		if t.Retry.Attempts < 2 {
+			errs = append(errs, fmt.Sprintf("%s.hedge needs retry.attempts of at least 2, got %d", field, t.Retry.Attempts))
+		}
+		if r.Retry.PerTryTimeout > 0 {
+			errs = append(errs, field+": set hedge.delay or retry.per_try_timeout, not both")
+		}
+	}
+	return errs
+}
+
+func validateRetryPolicy(field string, r RouteRetryPolicy) []string {
+	var errs []string
+	if r.Attempts < 0 {
+		errs = append(errs, field+".attempts must be >= 0")
+	}
+	if r.PerTryTimeout < 0 {
+		errs = append(errs, field+".per_try_timeout must be >= 0")
+	}
+	return errs
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// SchedulerConfig lists the traffic policies applied on a schedule, such
+// as a lower rate limit overnight or a backend drained for its weekly
+// maintenance window.
+type SchedulerConfig struct {
+	// Timezone is the IANA zone the cron expressions are read in, e.g.
+	// Europe/Berlin; the control plane's local time by default.
+	Timezone  string           `yaml:"timezone,omitempty"`
+	Schedules []ScheduleConfig `yaml:"schedules,omitempty"`
+}
+
+// Location returns the zone named by Timezone, or time.Local. It's only
+// called on a validated config.
+func (s SchedulerConfig) Location() *time.Location {
+	if s.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// ScheduleConfig applies Overlay to the running config at every time Cron
+// matches. With a Duration the overlay is undone again that long after,
+// putting back what it replaced; without one it stays until something
+// else changes those settings or the config is reloaded. A control plane
+// starting, or reloading, inside a schedule's window applies it right
+// away. Like the other runtime changes an overlay is never written to the
+// config file.
+type ScheduleConfig struct {
+	Name     string        `yaml:"name"`
+	Cron     string        `yaml:"cron"`
+	Duration time.Duration `yaml:"duration,omitempty"`
+	Overlay  Overlay       `yaml:"overlay"`
+}
+
+// Overlay is the settings a schedule changes. Two schedules in effect at
+// once shouldn't change the same setting: each puts back the value it
+// found.
+type Overlay struct {
+	// RateLimit replaces proxy.traffic.rate_limit.
+	RateLimit *RateLimitConfig `yaml:"rate_limit,omitempty"`
+	// Weights sets backend weights by address, TCP or UDP; 0 drains a
+	// backend out of weighted rotation.
+	Weights map[string]int `yaml:"weights,omitempty"`
+}
+
+func (o Overlay) IsEmpty() bool {
+	return o.RateLimit == nil && len(o.Weights) == 0
+}
+
+// Apply returns p with o applied, and the overlay that puts back what it
+// replaced. Addresses in Weights that aren't backends of p, e.g. removed
+// through the API since, are skipped and returned in missing.
+func (o Overlay) Apply(p ProxyConfig) (applied ProxyConfig, undo Overlay, missing []string) {
+	applied = p
+	if o.RateLimit != nil {
+		previous := p.Traffic.RateLimit
+		undo.RateLimit = &previous
+		applied.Traffic.RateLimit = *o.RateLimit
+	}
+	if len(o.Weights) == 0 {
+		return applied, undo, nil
+	}
+	undo.Weights = make(map[string]int, len(o.Weights))
+	applied.Backends = append([]Backend(nil), p.Backends...)
+	applied.UdpBackends = append([]Backend(nil), p.UdpBackends...)
+	for _, address := range sortedPools(o.Weights) {
+		found := false
+		for _, backends := range [][]Backend{applied.Backends, applied.UdpBackends} {
+			for i := range backends {
+				if backends[i].Address == address {
+					undo.Weights[address] = backends[i].Weight
+					backends[i].Weight = o.Weights[address]
+					found = true
+				}
+			}
+		}
+		if !found {
+			missing = append(missing, address)
+		}
+	}
+	return applied, undo, missing
+}
+
+func validateScheduler(s SchedulerConfig, p ProxyConfig) []string {
+	var errs []string
+	if s.Timezone != "" {
+		if _, err := time.LoadLocation(s.Timezone); err != nil {
+			errs = append(errs, fmt.Sprintf("scheduler.timezone: unknown time zone %q", s.Timezone))
+		}
+	}
+	addresses := make(map[string]bool)
+	for _, a := range p.BackendAddresses() {
+		addresses[a] = true
+	}
+	seen := make(map[string]bool, len(s.Schedules))
+	for i, sc := range s.Schedules {
+		if sc.Name == "" {
+			errs = append(errs, fmt.Sprintf("scheduler.schedules[%d].name is required", i))
+		} else if seen[sc.Name] {
+			errs = append(errs, fmt.Sprintf("scheduler.schedules: duplicate name %q", sc.Name))
+		}
+		seen[sc.Name] = true
+		if _, err := ParseCron(sc.Cron); err != nil {
+			errs = append(errs, fmt.Sprintf("scheduler.schedules[%d].cron: %v", i, err))
+		}
+		if sc.Duration < 0 {
+			errs = append(errs, fmt.Sprintf("scheduler.schedules[%d].duration must be >= 0", i))
+		}
+		if sc.Overlay.IsEmpty() {
+			errs = append(errs, fmt.Sprintf("scheduler.schedules[%d].overlay must set rate_limit or weights", i))
+		}
+		if rl := sc.Overlay.RateLimit; rl != nil && (rl.RequestsPerSecond < 0 || rl.Burst < 0) {
+			errs = append(errs, fmt.Sprintf("scheduler.schedules[%d].overlay.rate_limit values must be >= 0", i))
+		}
+		for _, address := range sortedPools(sc.Overlay.Weights) {
+			if !addresses[address] {
+				errs = append(errs, fmt.Sprintf("scheduler.schedules[%d].overlay.weights: %q is not in proxy.backends or proxy.udp_backends", i, address))
+			}
+			if sc.Overlay.Weights[address] < 0 {
+				errs = append(errs, fmt.Sprintf("scheduler.schedules[%d].overlay.weights.%s must be >= 0", i, address))
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Slow start defaults, used when initial_percent and interval aren't set.
+const (
+	DefaultSlowStartInitialPercent = 10
+	DefaultSlowStartInterval       = 5 * time.Second
+)
+
+// SlowStartConfig protects cold instances: a TCP backend added by a config
+// reload or the backend API starts at InitialPercent of its weight, and
+// the control plane raises the weight every Interval until it's full
+// Duration later. Backends in the config the control plane starts with
+// are at full weight from the start.
+type SlowStartConfig struct {
+	// Duration is how long the ramp takes; 0 turns slow start off.
+	Duration time.Duration `yaml:"duration,omitempty"`
+	// InitialPercent is the share of its weight a backend starts at,
+	// DefaultSlowStartInitialPercent by default.
+	InitialPercent int `yaml:"initial_percent,omitempty"`
+	// Interval is the time between weight updates, DefaultSlowStartInterval
+	// or Duration by default, whichever is shorter.
+	Interval time.Duration `yaml:"interval,omitempty"`
+}
+
+// Enabled reports whether added backends are ramped up.
+func (s SlowStartConfig) Enabled() bool {
+	return s.Duration > 0
+}
+
+// Weight is what a backend added elapsed ago gets of its weight target:
+// InitialPercent of it to begin with, growing in a straight line to all of
+// it once Duration has passed, and never less than 1.
+func (s SlowStartConfig) Weight(target int, elapsed time.Duration) int {
+	if target <= 0 || !s.Enabled() || elapsed >= s.Duration {
+		return target
+	}
+	percent := float64(s.InitialPercent) + float64(100-s.InitialPercent)*float64(max(elapsed, 0))/float64(s.Duration)
+	return max(int(float64(target)*percent/100), 1)
+}
+
+func validateSlowStart(p ProxyConfig) []string {
+	s := p.LoadBalancing.SlowStart
+	if !s.Enabled() {
+		if s.Duration < 0 {
+			return []string{"proxy.load_balancing.slow_start.duration must be >= 0"}
+		}
+		return nil
+	}
+	var errs []string
+	if s.Duration > time.Hour {
+		errs = append(errs, fmt.Sprintf("proxy.load_balancing.slow_start.duration must be at most 1h, got %s", s.Duration))
+	}
+	if s.InitialPercent < 1 || s.InitialPercent > 100 {
+		errs = append(errs, fmt.Sprintf("proxy.load_balancing.slow_start.initial_percent must be between 1 and 100, got %d", s.InitialPercent))
+	}
+	if s.Interval < time.Second || s.Interval > s.Duration {
+		errs = append(errs, fmt.Sprintf("proxy.load_balancing.slow_start.interval must be between 1s and the duration, got %s", s.Interval))
+	}
+	// The ramp is carried by the weights alone
+	if a := p.LoadBalancing.Algorithm; a != AlgorithmWeightedRoundRobin && a != AlgorithmWeighted {
+		errs = append(errs, fmt.Sprintf("proxy.load_balancing.slow_start needs proxy.load_balancing.algorithm \"weighted_round_robin\", got %q", a))
+	}
+	return errs
+}
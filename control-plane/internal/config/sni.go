@@ -0,0 +1,136 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// SNIRoute sends TLS connections whose ClientHello names one of
+// ServerNames to a backend pool of their own, without terminating TLS, so
+// one listener can front several TLS services. A name is a host, matched
+// exactly, or *.domain, matching any host under domain; the most specific
+// name wins, an exact one over any wildcard and a longer wildcard over a
+// shorter. Connections without SNI or naming no route are balanced over
+// the serving backends as usual.
+type SNIRoute struct {
+	ServerNames []string `yaml:"server_names"`
+	// Pool is where matching connections go, from the backends' pool
+	// labels.
+	Pool string `yaml:"pool"`
+}
+
+// SNIRoutePools returns the pools SNI routes send to, in the order of the
+// first route to each.
+func (p ProxyConfig) SNIRoutePools() []string {
+	var pools []string
+	for _, r := range p.SNIRoutes {
+		if r.Pool != "" && !slices.Contains(pools, r.Pool) {
+			pools = append(pools, r.Pool)
+		}
+	}
+	return pools
+}
+
+// RoutedPools returns the pools header rules, SNI routes, geo routes and
+// dark launches send to; they only get the traffic routed to them.
+func (p ProxyConfig) RoutedPools() []string {
+	pools := p.HeaderRulePools()
+	for _, pool := range slices.Concat(p.SNIRoutePools(), p.GeoRoutePools(), p.DarkLaunchPools()) {
+		if !slices.Contains(pools, pool) {
+			pools = append(pools, pool)
+		}
+	}
+	return pools
+}
+
+// ValidateSNIRoutes checks c.Proxy.SNIRoutes against its TCP backends: each
+// route has server names no other route has and a pool with a backend of
+// its own that nothing else splits, switches, mirrors or routes to. The
+// listener has to be passed TLS as is, so the routes can't be combined
+// with anything that reads the HTTP request.
+func ValidateSNIRoutes(c *Config) []string {
+	p := c.Proxy
+	if len(p.SNIRoutes) == 0 {
+		return nil
+	}
+	var errs []string
+	if c.XDS.Enabled && c.XDS.ListenerMode == "http" {
+		errs = append(errs, "proxy.sni_routes need xds.listener_mode tcp")
+	}
+	if len(p.HeaderRules) > 0 {
+		errs = append(errs, "proxy.sni_routes can't be combined with proxy.header_rules")
+	}
+	if k := p.LoadBalancing.Hash.Key; p.LoadBalancing.Algorithm == AlgorithmConsistentHash && (k == HashKeyHeader || k == HashKeyCookie) {
+		errs = append(errs, fmt.Sprintf("proxy.sni_routes can't be combined with a %s hash key", k))
+	}
+
+	seen := make(map[string]bool)
+	for i, r := range p.SNIRoutes {
+		field := fmt.Sprintf("proxy.sni_routes[%d]", i)
+		if len(r.ServerNames) == 0 {
+			errs = append(errs, field+".server_names needs at least one name")
+		}
+		for _, name := range r.ServerNames {
+			if !validServerName(name) {
+				errs = append(errs, fmt.Sprintf("%s.server_names: %q must be a lowercase host name or *.domain", field, name))
+				continue
+			}
+			if seen[name] {
+				errs = append(errs, fmt.Sprintf("proxy.sni_routes: server name %q is in more than one route", name))
+			}
+			seen[name] = true
+		}
+		switch {
+		case r.Pool == "":
+			errs = append(errs, field+".pool is required")
+		case len(PoolBackends(p.Backends, r.Pool)) == 0:
+			errs = append(errs, fmt.Sprintf("%s: no backend in proxy.backends has pool %q", field, r.Pool))
+		}
+	}
+
+	// Route pools are out of the default rotation, like header rule pools
+	pools := p.SNIRoutePools()
+	if !slices.ContainsFunc(p.Backends, func(b Backend) bool { return !slices.Contains(pools, b.Pool) }) {
+		errs = append(errs, "proxy.sni_routes: every backend is in an SNI route's pool, leaving none to serve other traffic")
+	}
+	for _, pool := range pools {
+		if _, ok := p.TrafficSplit[pool]; ok {
+			errs = append(errs, fmt.Sprintf("proxy.sni_routes: pool %q can't also be in proxy.traffic_split", pool))
+		}
+		if slices.Contains(p.BlueGreen.Pools, pool) {
+			errs = append(errs, fmt.Sprintf("proxy.sni_routes: pool %q can't also be a proxy.blue_green pool", pool))
+		}
+		if p.Mirror.Pool == pool {
+			errs = append(errs, fmt.Sprintf("proxy.sni_routes: pool %q can't also be the proxy.mirror pool", pool))
+		}
+	}
+	return errs
+}
+
+// validServerName reports whether name is a lowercase DNS name, optionally
+// with a leading "*." label, the forms Envoy's server_names accepts.
+func validServerName(name string) bool {
+	name = strings.TrimPrefix(name, "*.")
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ValidateRouting checks c's header rules, SNI routes, geo routes and dark
+// launches, for the APIs that change their pools or what else those pools
+// are used for.
+func ValidateRouting(c *Config) []string {
+	return slices.Concat(ValidateHeaderRules(c), ValidateSNIRoutes(c), ValidateGeoRoutes(c), ValidateDarkLaunches(c))
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+// splitScale is what the percentages of a traffic split are scaled to
+// before being spread over each pool's backends, so small backends in a
+// small pool don't round down to nothing.
+const splitScale = 100
+
+// ValidateTrafficSplit checks p.TrafficSplit against p's TCP backends: the
+// percentages total 100, every pool has a backend, and the load balancer
+// honours weights. It's exported for PUT /traffic-split.
+func ValidateTrafficSplit(p ProxyConfig) []string {
+	if len(p.TrafficSplit) == 0 {
+		return nil
+	}
+	var errs []string
+	if len(p.BlueGreen.Pools) > 0 {
+		errs = append(errs, "proxy.traffic_split can't be combined with proxy.blue_green")
+	}
+	members := make(map[string]int)
+	for _, b := range p.Backends {
+		members[b.Pool]++
+	}
+	total := 0
+	for _, pool := range sortedPools(p.TrafficSplit) {
+		pct := p.TrafficSplit[pool]
+		switch {
+		case pool == "":
+			errs = append(errs, "proxy.traffic_split: pool name must not be empty")
+		case pct < 0 || pct > 100:
+			errs = append(errs, fmt.Sprintf("proxy.traffic_split.%s must be between 0 and 100", pool))
+		case members[pool] == 0:
+			errs = append(errs, fmt.Sprintf("proxy.traffic_split.%s: no backend in proxy.backends has pool %q", pool, pool))
+		}
+		total += pct
+	}
+	if total != 100 {
+		errs = append(errs, fmt.Sprintf("proxy.traffic_split percentages must total 100, got %d", total))
+	}
+	if a := p.LoadBalancing.Algorithm; a != "weighted_round_robin" && a != "weighted" {
+		errs = append(errs, fmt.Sprintf("proxy.traffic_split needs proxy.load_balancing.algorithm \"weighted_round_robin\", got %q", a))
+	}
+	return errs
+}
+
+// SplitWeights returns backends with the weights the data plane should
+// use under split: each pool gets its percentage of the total, shared
+// between its backends in proportion to their configured weights.
+// Backends in no pool of the split get weight 0. With an empty split the
+// backends are returned unchanged. Weights are kept as small as possible,
+// since the data plane's weighted round robin sends each backend its
+// share in one run of consecutive connections.
+func SplitWeights(backends []Backend, split map[string]int) []Backend {
+	if len(split) == 0 {
+		return backends
+	}
+	poolWeight := make(map[string]int)
+	for _, b := range backends {
+		if b.Weight > 0 {
+			poolWeight[b.Pool] += b.Weight
+		}
+	}
+
+	out := make([]Backend, len(backends))
+	divisor := 0
+	for i, b := range backends {
+		pct, total := split[b.Pool], poolWeight[b.Pool]
+		weight := 0
+		if pct > 0 && b.Weight > 0 {
+			weight = max(b.Weight*pct*splitScale/total, 1)
+			divisor = gcd(divisor, weight)
+		}
+		b.Weight = weight
+		out[i] = b
+	}
+	if divisor > 1 {
+		for i := range out {
+			out[i].Weight /= divisor
+		}
+	}
+	return out
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+func sortedPools(split map[string]int) []string {
+	pools := make([]string, 0, len(split))
+	for pool := range split {
+		pools = append(pools, pool)
+	}
+	sort.Strings(pools)
+	return pools
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// StickyCookieConfig keeps HTTP clients on one backend with a cookie the
+// proxy manages, so apps needing server stickiness get it without client
+// changes. A request carrying the cookie goes to the backend it names
+// while that backend is healthy; one without it, or naming a backend
+// that's gone, is balanced as usual and the response sets the cookie to
+// the backend picked. The value is opaque to clients.
+type StickyCookieConfig struct {
+	// Name is the cookie's; empty turns sticky cookies off.
+	Name string `yaml:"name,omitempty"`
+	// TTL is the cookie's Max-Age; 0 makes it a session cookie, dropped
+	// when the browser closes.
+	TTL time.Duration `yaml:"ttl,omitempty"`
+	// Path defaults to /.
+	Path     string `yaml:"path,omitempty"`
+	Secure   bool   `yaml:"secure,omitempty"`
+	HTTPOnly bool   `yaml:"http_only,omitempty"`
+}
+
+// Enabled reports whether the proxy sets and follows a sticky cookie.
+func (s StickyCookieConfig) Enabled() bool {
+	return s.Name != ""
+}
+
+func validateStickyCookie(c *Config) []string {
+	s := c.Proxy.LoadBalancing.StickyCookie
+	if !s.Enabled() {
+		return nil
+	}
+	var errs []string
+	if !validCookieName(s.Name) {
+		errs = append(errs, fmt.Sprintf("proxy.load_balancing.sticky_cookie.name: %q is not a valid cookie name", s.Name))
+	}
+	if s.TTL < 0 {
+		errs = append(errs, "proxy.load_balancing.sticky_cookie.ttl must be >= 0")
+	} else if s.TTL%time.Second != 0 {
+		errs = append(errs, fmt.Sprintf("proxy.load_balancing.sticky_cookie.ttl must be whole seconds, got %s", s.TTL))
+	}
+	if !strings.HasPrefix(s.Path, "/") || strings.ContainsFunc(s.Path, func(r rune) bool { return r == ';' || r < 0x20 || r == 0x7f }) {
+		errs = append(errs, fmt.Sprintf("proxy.load_balancing.sticky_cookie.path must start with / and have no ; or control characters, got %q", s.Path))
+	}
+	// The proxy has to read requests and write responses to manage it
+	if c.XDS.Enabled && c.XDS.ListenerMode != "http" {
+		errs = append(errs, "proxy.load_balancing.sticky_cookie needs xds.listener_mode http")
+	}
+	if len(c.Proxy.SNIRoutes) > 0 {
+		errs = append(errs, "proxy.load_balancing.sticky_cookie can't be combined with proxy.sni_routes, whose TLS is passed through unread")
+	}
+	if h := c.Proxy.LoadBalancing.Hash; c.Proxy.LoadBalancing.Algorithm == AlgorithmConsistentHash && h.Key == HashKeyCookie && h.Name == s.Name {
+		errs = append(errs, fmt.Sprintf("proxy.load_balancing.sticky_cookie: cookie %q is already the consistent_hash key", s.Name))
+	}
+	return errs
+}
+
+// validCookieName reports whether name is an RFC 6265 token: printable
+// ASCII without separators.
+func validCookieName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r <= 0x20 || r >= 0x7f || strings.ContainsRune(`()<>@,;:\"/[]?={}`, r) {
+			return false
+		}
+	}
+	return true
+}
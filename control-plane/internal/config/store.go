@@ -0,0 +1,89 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// State store backends.
+const (
+	// StoreMemory keeps state in the process, lost when it exits.
+	StoreMemory = "memory"
+	// StoreFile keeps state in a file, for one control plane on one host.
+	StoreFile = "file"
+	// StoreEtcd and StoreRedis keep state where every replica of an HA
+	// control plane sees it.
+	StoreEtcd  = "etcd"
+	StoreRedis = "redis"
+)
+
+// DefaultStoreTimeout bounds each call to the state store.
+const DefaultStoreTimeout = 5 * time.Second
+
+// StoreConfig is where the control plane keeps the runtime state it
+// doesn't write to the config file: the config version last applied, the
+// canary rollout and the audit log, so they survive a restart and, with
+// etcd or redis, are shared by HA replicas. Changing it needs a restart.
+type StoreConfig struct {
+	// Backend is memory, the default, file, etcd or redis.
+	Backend string        `yaml:"backend,omitempty"`
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// Path is the file file keeps state in, rewritten on every change.
+	Path string `yaml:"path,omitempty"`
+	// EtcdConfig is the cluster etcd keeps state in, in keys under
+	// Prefix, /aegis/state/ when empty. Its Username and Password are
+	// redis's too.
+	EtcdConfig `yaml:",inline"`
+	Prefix     string `yaml:"prefix,omitempty"`
+	// Address is the host:port of the Redis server redis keeps state in,
+	// as fields of the hash Key, aegis:state when empty, in database DB.
+	// Password, or AEGIS_STORE_PASSWORD with either backend, authenticates
+	// as Username, the default user when empty.
+	Address string `yaml:"address,omitempty"`
+	DB      int    `yaml:"db,omitempty"`
+	Key     string `yaml:"key,omitempty"`
+}
+
+func validateStore(s StoreConfig) []string {
+	var errs []string
+	if s.Timeout < 0 {
+		errs = append(errs, "store.timeout must be >= 0")
+	}
+	if s.Backend != StoreFile && s.Path != "" {
+		errs = append(errs, "store.path is for backend file")
+	}
+	if s.Backend != StoreEtcd && (s.EtcdConfig.set() || s.Prefix != "") {
+		errs = append(errs, "store.endpoints, ca_file, cert_file, key_file and prefix are for backend etcd")
+	}
+	if s.Backend != StoreRedis && (s.Address != "" || s.DB != 0 || s.Key != "") {
+		errs = append(errs, "store.address, db and key are for backend redis")
+	}
+	if s.Backend != StoreEtcd && s.Backend != StoreRedis && (s.Username != "" || s.Password != "") {
+		errs = append(errs, "store.username and password are for backends etcd and redis")
+	}
+	switch s.Backend {
+	case StoreMemory:
+	case StoreFile:
+		if s.Path == "" {
+			errs = append(errs, "store.path is required with backend file")
+		}
+	case StoreEtcd:
+		errs = append(errs, validateEtcd("store", "backend etcd", s.EtcdConfig)...)
+		if !strings.HasPrefix(s.Prefix, "/") {
+			errs = append(errs, fmt.Sprintf("store.prefix must start with /, got %q", s.Prefix))
+		}
+	case StoreRedis:
+		if _, _, err := net.SplitHostPort(s.Address); err != nil {
+			errs = append(errs, fmt.Sprintf("store.address must be host:port, got %q", s.Address))
+		}
+		if s.DB < 0 {
+			errs = append(errs, "store.db must be >= 0")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("store.backend must be %q, %q, %q or %q, got %q",
+			StoreMemory, StoreFile, StoreEtcd, StoreRedis, s.Backend))
+	}
+	return errs
+}
@@ -0,0 +1,222 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ACME challenge types.
+const (
+	// ACMEChallengeHTTP01 proves control of a domain by serving a token
+	// over plain HTTP on port 80 of the domain.
+	ACMEChallengeHTTP01 = "http-01"
+	// ACMEChallengeDNS01 proves it with a TXT record, the only way to
+	// get a wildcard certificate.
+	ACMEChallengeDNS01 = "dns-01"
+)
+
+// ACME defaults.
+const (
+	// LetsEncryptDirectory is the directory of Let's Encrypt's production
+	// CA. Its staging one, for trying a setup out without its rate limits,
+	// is https://acme-staging-v02.api.letsencrypt.org/directory.
+	LetsEncryptDirectory = "https://acme-v02.api.letsencrypt.org/directory"
+	// DefaultACMERenewBefore renews a 90-day certificate with a third of
+	// its life left, as Let's Encrypt advises.
+	DefaultACMERenewBefore = 30 * 24 * time.Hour
+	// DefaultACMEPropagationTimeout is how long a dns-01 TXT record gets
+	// to show up in DNS.
+	DefaultACMEPropagationTimeout = 2 * time.Minute
+)
+
+// ProxyTLSConfig has the data plane terminate TLS on the TCP listener.
+// Its certificates are pushed to the data plane as secrets, apart from
+// the config, and picked by the server name in the ClientHello; a client
+// naming none of them gets the first: that of Certificates, then of
+// Directory, then of ACME. Backends get the decrypted connection.
+type ProxyTLSConfig struct {
+	// Certificates are the operator's own, read again whenever their
+	// files change.
+	Certificates []CertificateFiles `yaml:"certificates,omitempty"`
+	// Directory holds more of them, each a <name>.crt chain with its
+	// <name>.key, for certificates coming and going without a config
+	// push.
+	Directory string `yaml:"directory,omitempty"`
+	// ACME obtains and renews certificates from an ACME CA.
+	ACME ACMEConfig `yaml:"acme,omitempty"`
+}
+
+// Enabled reports whether the data plane terminates TLS.
+func (t ProxyTLSConfig) Enabled() bool {
+	return len(t.Certificates) > 0 || t.Directory != "" || t.ACME.Enabled()
+}
+
+// CertificateFiles is a PEM certificate chain, the leaf first, and the
+// leaf's PEM private key. Rotation tools replace both; a chain and key
+// that aren't a pair, as between the two being written, leave the
+// certificate read before in use.
+type CertificateFiles struct {
+	// Name identifies it to the data plane; the chain's file name without
+	// its extension when empty.
+	Name     string `yaml:"name,omitempty"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// ACMEConfig obtains certificates from Let's Encrypt, or another CA
+// speaking ACME (RFC 8555), and renews them before they expire. The
+// account key and certificates are kept in the state store, so a
+// restarted or newly elected control plane reuses them instead of running
+// into the CA's rate limits. Only the leader talks to the CA.
+type ACMEConfig struct {
+	// DirectoryURL is the CA's; Let's Encrypt's production one when empty.
+	DirectoryURL string `yaml:"directory_url,omitempty"`
+	// Email is where the CA sends expiry and incident notices.
+	Email string `yaml:"email,omitempty"`
+	// AcceptTOS agrees to the CA's terms of service, which it requires.
+	AcceptTOS bool `yaml:"accept_tos,omitempty"`
+	// Certificates are the certificates to obtain.
+	Certificates []ACMECertificate `yaml:"certificates,omitempty"`
+	// Challenge is http-01, the default, or dns-01.
+	Challenge string `yaml:"challenge,omitempty"`
+	// HTTPAddress is where the control plane answers http-01 challenges,
+	// :80 when empty. Port 80 of every domain has to reach it.
+	HTTPAddress string `yaml:"http_address,omitempty"`
+	// DNS publishes dns-01 TXT records.
+	DNS ACMEDNSConfig `yaml:"dns,omitempty"`
+	// RenewBefore is how long before expiry a certificate is renewed.
+	RenewBefore time.Duration `yaml:"renew_before,omitempty"`
+}
+
+// ACMECertificate is one certificate, valid for every one of Domains.
+type ACMECertificate struct {
+	// Name identifies it in the state store and to the data plane; the
+	// first domain when empty.
+	Name    string   `yaml:"name,omitempty"`
+	Domains []string `yaml:"domains"`
+}
+
+// ACMEDNSConfig publishes the TXT records of dns-01 challenges through a
+// command, as lego's exec provider does, so any DNS provider can be
+// scripted: it's run with "present" or "cleanup", the record's FQDN
+// (_acme-challenge.example.com.) and its value appended to Command.
+type ACMEDNSConfig struct {
+	Command []string `yaml:"command,omitempty"`
+	// PropagationTimeout is how long the record gets to be seen by the
+	// resolver before the CA is asked to check it.
+	PropagationTimeout time.Duration `yaml:"propagation_timeout,omitempty"`
+}
+
+// Enabled reports whether any certificate is to be obtained.
+func (a ACMEConfig) Enabled() bool {
+	return len(a.Certificates) > 0
+}
+
+func validateProxyTLS(c *Config) []string {
+	t := c.Proxy.TLS
+	if !t.Enabled() {
+		return nil
+	}
+	var errs []string
+	if c.XDS.Enabled {
+		errs = append(errs, "proxy.tls isn't supported with xds.enabled: Envoy gets its certificates its own way")
+	}
+	if len(c.Proxy.SNIRoutes) > 0 {
+		errs = append(errs, "proxy.tls can't be combined with proxy.sni_routes, whose TLS is passed through unread")
+	}
+	// These read the request head off the socket, where it's still encrypted
+	lb := c.Proxy.LoadBalancing
+	if len(c.Proxy.HeaderRules) > 0 {
+		errs = append(errs, "proxy.tls can't be combined with proxy.header_rules")
+	}
+	if lb.StickyCookie.Enabled() {
+		errs = append(errs, "proxy.tls can't be combined with proxy.load_balancing.sticky_cookie")
+	}
+	if lb.Algorithm == AlgorithmConsistentHash && (lb.Hash.Key == HashKeyHeader || lb.Hash.Key == HashKeyCookie) {
+		errs = append(errs, "proxy.tls can't be combined with a header or cookie proxy.load_balancing.hash.key")
+	}
+
+	names := make(map[string]bool)
+	for i, files := range t.Certificates {
+		field := fmt.Sprintf("proxy.tls.certificates[%d]", i)
+		if files.CertFile == "" || files.KeyFile == "" {
+			errs = append(errs, field+".cert_file and key_file are required")
+		}
+		if strings.ContainsAny(files.Name, "/ ") {
+			errs = append(errs, fmt.Sprintf("%s.name must have no / or spaces, got %q", field, files.Name))
+		}
+		if names[files.Name] {
+			errs = append(errs, fmt.Sprintf("proxy.tls.certificates: name %q is used more than once", files.Name))
+		}
+		names[files.Name] = true
+	}
+	if !t.ACME.Enabled() {
+		return errs
+	}
+	for _, cert := range t.ACME.Certificates {
+		if names[cert.Name] {
+			errs = append(errs, fmt.Sprintf("proxy.tls.acme.certificates: name %q is used by proxy.tls.certificates too", cert.Name))
+		}
+	}
+	return append(errs, validateACME(t.ACME)...)
+}
+
+func validateACME(a ACMEConfig) []string {
+	var errs []string
+	if u, err := url.Parse(a.DirectoryURL); err != nil || u.Scheme != "https" || u.Host == "" {
+		errs = append(errs, fmt.Sprintf("proxy.tls.acme.directory_url must be an https:// URL, got %q", a.DirectoryURL))
+	}
+	if !a.AcceptTOS {
+		errs = append(errs, "proxy.tls.acme.accept_tos must be set: the CA requires agreeing to its terms of service")
+	}
+	if a.Email != "" && !strings.Contains(a.Email, "@") {
+		errs = append(errs, fmt.Sprintf("proxy.tls.acme.email: %q is not an email address", a.Email))
+	}
+	switch a.Challenge {
+	case ACMEChallengeHTTP01:
+		if _, _, err := net.SplitHostPort(a.HTTPAddress); err != nil {
+			errs = append(errs, fmt.Sprintf("proxy.tls.acme.http_address must be host:port, got %q", a.HTTPAddress))
+		}
+		if len(a.DNS.Command) > 0 {
+			errs = append(errs, "proxy.tls.acme.dns is for challenge dns-01")
+		}
+	case ACMEChallengeDNS01:
+		if len(a.DNS.Command) == 0 {
+			errs = append(errs, "proxy.tls.acme.dns.command is required with challenge dns-01")
+		}
+		if a.DNS.PropagationTimeout < 0 {
+			errs = append(errs, "proxy.tls.acme.dns.propagation_timeout must be >= 0")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("proxy.tls.acme.challenge must be %q or %q, got %q", ACMEChallengeHTTP01, ACMEChallengeDNS01, a.Challenge))
+	}
+	if a.RenewBefore <= 0 {
+		errs = append(errs, "proxy.tls.acme.renew_before must be > 0")
+	}
+
+	names := make(map[string]bool)
+	for i, cert := range a.Certificates {
+		field := fmt.Sprintf("proxy.tls.acme.certificates[%d]", i)
+		if len(cert.Domains) == 0 {
+			errs = append(errs, field+".domains needs at least one domain")
+		}
+		for _, d := range cert.Domains {
+			if !validServerName(d) {
+				errs = append(errs, fmt.Sprintf("%s.domains: %q must be a lowercase host name or *.domain", field, d))
+			} else if strings.HasPrefix(d, "*.") && a.Challenge != ACMEChallengeDNS01 {
+				errs = append(errs, fmt.Sprintf("%s.domains: the wildcard %q needs challenge dns-01", field, d))
+			}
+		}
+		if cert.Name != "" && strings.ContainsAny(cert.Name, "/ ") {
+			errs = append(errs, fmt.Sprintf("%s.name must have no / or spaces, got %q", field, cert.Name))
+		}
+		if names[cert.Name] {
+			errs = append(errs, fmt.Sprintf("proxy.tls.acme.certificates: name %q is used more than once", cert.Name))
+		}
+		names[cert.Name] = true
+	}
+	return errs
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// UDP affinity modes: what a UDP client is hashed by to pick its backend.
+const (
+	// UDPAffinitySourceIPPort keeps each client socket on one backend,
+	// for protocols like DTLS whose state lives per socket.
+	UDPAffinitySourceIPPort = "source_ip_port"
+	// UDPAffinitySourceIP keeps every socket of a client host on one
+	// backend, e.g. a game client that opens a socket per channel.
+	UDPAffinitySourceIP = "source_ip"
+)
+
+// DefaultUDPIdleTimeout is how long a UDP session lasts without a packet
+// when udp_affinity.idle_timeout isn't set; it's also the data plane's
+// session timeout without affinity.
+const DefaultUDPIdleTimeout = 60 * time.Second
+
+// UDPAffinityConfig sticks UDP clients to a backend, whatever the
+// algorithm. A client's first packet picks its backend by hashing Mode's
+// key over the UDP backends, and later ones follow until the session has
+// been idle for IdleTimeout; a client back after that hashes to the same
+// backend as long as the backends haven't changed.
+type UDPAffinityConfig struct {
+	// Mode is empty (off, the default), UDPAffinitySourceIPPort or
+	// UDPAffinitySourceIP.
+	Mode string `yaml:"mode,omitempty"`
+	// IdleTimeout defaults to DefaultUDPIdleTimeout.
+	IdleTimeout time.Duration `yaml:"idle_timeout,omitempty"`
+}
+
+// Enabled reports whether UDP clients are hashed onto backends.
+func (a UDPAffinityConfig) Enabled() bool {
+	return a.Mode != ""
+}
+
+func validateUDPAffinity(p ProxyConfig) []string {
+	a := p.LoadBalancing.UDPAffinity
+	var errs []string
+	switch a.Mode {
+	case "", UDPAffinitySourceIPPort, UDPAffinitySourceIP:
+	default:
+		errs = append(errs, fmt.Sprintf("proxy.load_balancing.udp_affinity.mode must be empty, %s or %s, got %q",
+			UDPAffinitySourceIPPort, UDPAffinitySourceIP, a.Mode))
+	}
+	if a.IdleTimeout < 0 || a.IdleTimeout > 24*time.Hour {
+		errs = append(errs, fmt.Sprintf("proxy.load_balancing.udp_affinity.idle_timeout must be between 0 and 24h, got %s", a.IdleTimeout))
+	}
+	// Hashing sends a client to the same backend from every zone
+	if a.Enabled() && p.LoadBalancing.Locality.Policy == LocalityPreferLocal {
+		errs = append(errs, "proxy.load_balancing.udp_affinity can't be used with locality prefer_local")
+	}
+	return errs
+}
@@ -0,0 +1,134 @@
+// Package darklaunch turns dark launch rules on and off as their time
+// windows open and close.
+package darklaunch
+
+import (
+	"context"
+	"maps"
+	"strconv"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/events"
+	"go.uber.org/zap"
+)
+
+// retryRefresh is how long after a failed push it's tried again, so a
+// closed window doesn't stay open on the data plane.
+const retryRefresh = 30 * time.Second
+
+// Applier holds the running rules and pushes them. The API server is one,
+// so the pushes are serialized with API changes.
+type Applier interface {
+	// DarkLaunches returns the running rules and the zone their windows
+	// are read in.
+	DarkLaunches() ([]config.DarkLaunchRule, *time.Location)
+	// RefreshDarkLaunches pushes the running backends again, with the
+	// rules that are on now.
+	RefreshDarkLaunches() error
+}
+
+// Controller pushes the running config's dark launches whenever one of
+// their windows opens or closes. Config pushes between those carry the
+// rules on at the time of the push themselves; the controller is only
+// told about them to work out the next change.
+type Controller struct {
+	applier Applier
+	logger  *zap.Logger
+	feed    *events.Feed
+	changed chan struct{}
+
+	// on is what the data plane was last given, by rule name
+	on map[string]bool
+}
+
+// New returns a controller for applier's rules. Nothing is pushed until
+// Run.
+func New(applier Applier, logger *zap.Logger) *Controller {
+	return &Controller{
+		applier: applier,
+		logger:  logger,
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// SetEventFeed publishes dark_launch_started and dark_launch_ended events
+// to feed.
+func (c *Controller) SetEventFeed(feed *events.Feed) {
+	c.feed = feed
+}
+
+// Changed tells the controller the running config was replaced and pushed.
+// It doesn't block, so it's safe to call with the API's apply lock held.
+func (c *Controller) Changed() {
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Run pushes the rules as their windows open and close until ctx is done.
+func (c *Controller) Run(ctx context.Context) {
+	next := c.sync(time.Now(), false)
+	for {
+		timer := time.NewTimer(time.Hour)
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-c.changed:
+			timer.Stop()
+			next = c.sync(time.Now(), false)
+		case <-timer.C:
+			next = c.sync(time.Now(), true)
+		}
+	}
+}
+
+// sync works out which rules are on at now and when that next changes.
+// With push, rules that turned on or off since the data plane was last
+// given them are pushed; without, the data plane is taken to have them
+// already, as after a config push.
+func (c *Controller) sync(now time.Time, push bool) time.Time {
+	rules, loc := c.applier.DarkLaunches()
+	now = now.In(loc)
+	on := make(map[string]bool, len(rules))
+	var next time.Time
+	for _, r := range rules {
+		on[r.Name] = r.ActiveAt(now)
+		if t := r.NextChange(now); !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	if !push || maps.Equal(on, c.on) {
+		c.on = on
+		return next
+	}
+
+	if err := c.applier.RefreshDarkLaunches(); err != nil {
+		c.logger.Error("Failed to push dark launch windows", zap.Error(err))
+		if retry := now.Add(retryRefresh); next.IsZero() || retry.Before(next) {
+			next = retry
+		}
+		return next
+	}
+	for _, r := range rules {
+		if on[r.Name] == c.on[r.Name] {
+			continue
+		}
+		attrs := map[string]string{"rule": r.Name, "pool": r.Pool, "percent": strconv.Itoa(r.Percent)}
+		if on[r.Name] {
+			c.feed.Publish(events.TypeDarkLaunchStarted,
+				"Dark launch "+r.Name+" on: "+strconv.Itoa(r.Percent)+"% of connections to pool "+r.Pool, attrs)
+			c.logger.Info("Dark launch window opened", zap.String("rule", r.Name), zap.String("pool", r.Pool))
+		} else {
+			c.feed.Publish(events.TypeDarkLaunchEnded, "Dark launch "+r.Name+" off", attrs)
+			c.logger.Info("Dark launch window closed", zap.String("rule", r.Name), zap.String("pool", r.Pool))
+		}
+	}
+	c.on = on
+	return next
+}
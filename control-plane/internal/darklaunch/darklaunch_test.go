@@ -0,0 +1,99 @@
+package darklaunch
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/events"
+	"go.uber.org/zap"
+)
+
+// fakeApplier is a running config whose pushes are counted.
+type fakeApplier struct {
+	rules  []config.DarkLaunchRule
+	pushes int
+	fail   bool
+}
+
+func (f *fakeApplier) DarkLaunches() ([]config.DarkLaunchRule, *time.Location) {
+	return f.rules, time.UTC
+}
+
+func (f *fakeApplier) RefreshDarkLaunches() error {
+	if f.fail {
+		return errors.New("data plane unavailable")
+	}
+	f.pushes++
+	return nil
+}
+
+var (
+	rule = config.DarkLaunchRule{Name: "checkout", Pool: "beta", Percent: 5,
+		Windows: []config.TimeWindow{{Start: "09:00", End: "17:00"}}}
+	// 08:00 UTC on Wednesday 14 October 2026
+	t0 = time.Date(2026, 10, 14, 8, 0, 0, 0, time.UTC)
+)
+
+func TestController_PushesAtWindowEdges(t *testing.T) {
+	applier := &fakeApplier{rules: []config.DarkLaunchRule{rule, {Name: "search", Pool: "beta", Percent: 10}}}
+	c := New(applier, zap.NewNop())
+	feed := events.NewFeed()
+	c.SetEventFeed(feed)
+	sub := feed.Subscribe(0)
+
+	// The data plane was given the rules by the config push at startup
+	if next := c.sync(t0, false); !next.Equal(t0.Add(time.Hour)) || applier.pushes != 0 {
+		t.Fatalf("start: next %s, %d pushes", next, applier.pushes)
+	}
+	// Woken with nothing changed, e.g. late, it doesn't push
+	if c.sync(t0.Add(30*time.Minute), true); applier.pushes != 0 {
+		t.Errorf("unchanged: %d pushes", applier.pushes)
+	}
+
+	if next := c.sync(t0.Add(time.Hour), true); !next.Equal(t0.Add(9*time.Hour)) || applier.pushes != 1 {
+		t.Errorf("opened: next %s, %d pushes", next, applier.pushes)
+	}
+	if e := <-sub.Events; e.Type != events.TypeDarkLaunchStarted || e.Attributes["rule"] != "checkout" || e.Attributes["percent"] != "5" {
+		t.Errorf("got event %+v", e)
+	}
+	if next := c.sync(t0.Add(9*time.Hour), true); !next.Equal(t0.Add(25*time.Hour)) || applier.pushes != 2 {
+		t.Errorf("closed: next %s, %d pushes", next, applier.pushes)
+	}
+	if e := <-sub.Events; e.Type != events.TypeDarkLaunchEnded || e.Message != "Dark launch checkout off" {
+		t.Errorf("got event %+v", e)
+	}
+}
+
+func TestController_RetriesFailedPush(t *testing.T) {
+	applier := &fakeApplier{rules: []config.DarkLaunchRule{rule}}
+	c := New(applier, zap.NewNop())
+	c.sync(t0, false)
+
+	applier.fail = true
+	opened := t0.Add(time.Hour)
+	if next := c.sync(opened, true); !next.Equal(opened.Add(retryRefresh)) {
+		t.Errorf("retry: got %s", next)
+	}
+	applier.fail = false
+	if c.sync(opened.Add(retryRefresh), true); applier.pushes != 1 {
+		t.Errorf("retried: %d pushes", applier.pushes)
+	}
+}
+
+func TestController_ChangedConfig(t *testing.T) {
+	applier := &fakeApplier{}
+	c := New(applier, zap.NewNop())
+	c.sync(t0.Add(2*time.Hour), false)
+
+	// A rule added by a config push inside its window went out with that
+	// push, so only the window's end is pushed
+	applier.rules = []config.DarkLaunchRule{rule}
+	if next := c.sync(t0.Add(2*time.Hour), false); !next.Equal(t0.Add(9*time.Hour)) || applier.pushes != 0 {
+		t.Errorf("changed: next %s, %d pushes", next, applier.pushes)
+	}
+	if c.sync(t0.Add(9*time.Hour), true); applier.pushes != 1 {
+		t.Errorf("closed: %d pushes", applier.pushes)
+	}
+}
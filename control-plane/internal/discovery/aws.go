@@ -0,0 +1,203 @@
+package discovery
+
+import (
+	"cmp"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+)
+
+// awsInstancesPerCall is how many instance IDs of target group targets are
+// described per DescribeInstances call.
+const awsInstancesPerCall = 200
+
+// AWSProvider finds a service's backends among the running EC2 instances
+// with the configured tags, on the configured port, and the targets of
+// ELBv2 target groups, on their registered ports. Backends are the
+// instances' private IPs, placed in their availability zones for
+// locality-aware balancing. Each lookup is as few calls as the pages
+// allow, and a throttled call backs off and is retried.
+type AWSProvider struct {
+	awsAPI
+	tags         map[string]string
+	port         int
+	targetGroups []string
+	health       config.HealthCheckConfig
+
+	// ec2URL and elbURL are the API endpoints, overridden by tests
+	ec2URL, elbURL string
+}
+
+// NewAWSProvider returns a provider listing cfg's instances and targets.
+func NewAWSProvider(cfg config.DiscoveryConfig) *AWSProvider {
+	return &AWSProvider{
+		awsAPI:       newAWSAPI(cfg.Region),
+		tags:         cfg.Tags,
+		port:         cfg.Port,
+		targetGroups: cfg.TargetGroups,
+		health:       cfg.HealthCheck,
+		ec2URL:       "https://ec2." + cfg.Region + ".amazonaws.com/",
+		elbURL:       "https://elasticloadbalancing." + cfg.Region + ".amazonaws.com/",
+	}
+}
+
+type ec2Instance struct {
+	ID        string `xml:"instanceId"`
+	PrivateIP string `xml:"privateIpAddress"`
+	Zone      string `xml:"placement>availabilityZone"`
+}
+
+type describeInstancesResponse struct {
+	Reservations []struct {
+		Instances []ec2Instance `xml:"instancesSet>item"`
+	} `xml:"reservationSet>item"`
+	NextToken string `xml:"nextToken"`
+}
+
+type describeTargetHealthResponse struct {
+	Targets []struct {
+		ID    string `xml:"Target>Id"`
+		Port  int    `xml:"Target>Port"`
+		Zone  string `xml:"Target>AvailabilityZone"`
+		State string `xml:"TargetHealth>State"`
+	} `xml:"DescribeTargetHealthResult>TargetHealthDescriptions>member"`
+}
+
+func (p *AWSProvider) Discover(ctx context.Context) ([]config.Backend, error) {
+	var backends []config.Backend
+	add := func(ip string, port int, zone string) {
+		address := net.JoinHostPort(ip, strconv.Itoa(port))
+		if ip == "" || slices.ContainsFunc(backends, func(b config.Backend) bool { return b.Address == address }) {
+			return
+		}
+		backends = append(backends, config.Backend{
+			Address: address, Weight: 100, Zone: zone, Region: p.region, HealthCheck: p.health,
+		})
+	}
+
+	if len(p.tags) > 0 {
+		params := url.Values{"MaxResults": {"1000"}}
+		n := 1
+		for _, key := range slices.Sorted(maps.Keys(p.tags)) {
+			f := "Filter." + strconv.Itoa(n)
+			if value := p.tags[key]; value == "" {
+				params.Set(f+".Name", "tag-key")
+				params.Set(f+".Value.1", key)
+			} else {
+				params.Set(f+".Name", "tag:"+key)
+				params.Set(f+".Value.1", value)
+			}
+			n++
+		}
+		instances, err := p.describeInstances(ctx, params, n)
+		if err != nil {
+			return nil, err
+		}
+		for _, inst := range instances {
+			add(inst.PrivateIP, p.port, inst.Zone)
+		}
+	}
+
+	// Instance targets are described for their IPs afterwards, all at once
+	ports := make(map[string][]int)
+	for _, arn := range p.targetGroups {
+		var resp describeTargetHealthResponse
+		params := url.Values{"Action": {"DescribeTargetHealth"}, "Version": {"2015-12-01"}, "TargetGroupArn": {arn}}
+		if err := p.call(ctx, p.elbURL, "elasticloadbalancing", params, &resp); err != nil {
+			return nil, err
+		}
+		for _, t := range resp.Targets {
+			// Draining targets are on their way out of the group
+			if t.State == "draining" {
+				continue
+			}
+			if strings.HasPrefix(t.ID, "i-") {
+				ports[t.ID] = append(ports[t.ID], t.Port)
+			} else if net.ParseIP(t.ID) != nil {
+				add(t.ID, t.Port, t.Zone)
+			}
+		}
+	}
+	ids := slices.Sorted(maps.Keys(ports))
+	for chunk := range slices.Chunk(ids, awsInstancesPerCall) {
+		params := url.Values{}
+		for i, id := range chunk {
+			params.Set("InstanceId."+strconv.Itoa(i+1), id)
+		}
+		instances, err := p.describeInstances(ctx, params, 1)
+		if err != nil {
+			return nil, err
+		}
+		for _, inst := range instances {
+			for _, port := range ports[inst.ID] {
+				add(inst.PrivateIP, port, inst.Zone)
+			}
+		}
+	}
+
+	slices.SortFunc(backends, func(a, b config.Backend) int { return cmp.Compare(a.Address, b.Address) })
+	return backends, nil
+}
+
+// describeInstances returns the running instances params select, every
+// page of them. filter is the number of the next free Filter.N.
+func (p *AWSProvider) describeInstances(ctx context.Context, params url.Values, filter int) ([]ec2Instance, error) {
+	params.Set("Action", "DescribeInstances")
+	params.Set("Version", "2016-11-15")
+	f := "Filter." + strconv.Itoa(filter)
+	params.Set(f+".Name", "instance-state-name")
+	params.Set(f+".Value.1", "running")
+	var instances []ec2Instance
+	for {
+		var resp describeInstancesResponse
+		if err := p.call(ctx, p.ec2URL, "ec2", params, &resp); err != nil {
+			return nil, err
+		}
+		for _, r := range resp.Reservations {
+			instances = append(instances, r.Instances...)
+		}
+		if resp.NextToken == "" {
+			return instances, nil
+		}
+		params.Set("NextToken", resp.NextToken)
+	}
+}
+
+// awsError is the error of an EC2 (Response>Errors>Error) or ELB
+// (ErrorResponse>Error) Query API call.
+type awsError struct {
+	Code    string `xml:"Errors>Error>Code"`
+	Message string `xml:"Errors>Error>Message"`
+	ELB     struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	} `xml:"Error"`
+}
+
+// call makes a signed Query API call to service at endpoint and decodes
+// its XML response into out.
+func (p *AWSProvider) call(ctx context.Context, endpoint, service string, params url.Values, out any) error {
+	action := params.Get("Action")
+	header := http.Header{"Content-Type": {"application/x-www-form-urlencoded; charset=utf-8"}}
+	data, err := p.send(ctx, action, endpoint, service, header, []byte(params.Encode()), func(data []byte) (string, string) {
+		var apiErr awsError
+		xml.Unmarshal(data, &apiErr)
+		return cmp.Or(apiErr.Code, apiErr.ELB.Code), cmp.Or(apiErr.Message, apiErr.ELB.Message)
+	})
+	if err != nil {
+		return err
+	}
+	if err := xml.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("%s: %w", action, err)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+)
+
+func TestSignV4(t *testing.T) {
+	// The example request of the Signature Version 4 documentation
+	req, _ := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	creds := awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signV4(req, nil, creds, "iam", "us-east-1", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date, " +
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("got %s", got)
+	}
+}
+
+const instancesPage = `<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+<reservationSet>%s</reservationSet><nextToken>%s</nextToken></DescribeInstancesResponse>`
+
+func reservation(id, ip, zone string) string {
+	return fmt.Sprintf(`<item><instancesSet><item><instanceId>%s</instanceId><privateIpAddress>%s</privateIpAddress>
+<placement><availabilityZone>%s</availabilityZone></placement></item></instancesSet></item>`, id, ip, zone)
+}
+
+func TestAWSProvider_Discover(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	throttle := true
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /ec2/", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "/us-east-1/ec2/aws4_request") {
+			t.Errorf("authorization %q", r.Header.Get("Authorization"))
+		}
+		r.ParseForm()
+		if r.Form.Get("Action") != "DescribeInstances" {
+			t.Errorf("action %s", r.Form.Get("Action"))
+		}
+		if throttle {
+			throttle = false
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `<Response><Errors><Error><Code>RequestLimitExceeded</Code><Message>Request limit exceeded.</Message></Error></Errors></Response>`)
+			return
+		}
+		switch {
+		case r.Form.Get("InstanceId.1") == "i-3":
+			fmt.Fprintf(w, instancesPage, reservation("i-3", "10.0.1.3", "us-east-1b"), "")
+		case r.Form.Get("Filter.1.Name") != "tag:app" || r.Form.Get("Filter.1.Value.1") != "api" ||
+			r.Form.Get("Filter.2.Name") != "tag-key" || r.Form.Get("Filter.2.Value.1") != "prod" ||
+			r.Form.Get("Filter.3.Name") != "instance-state-name":
+			t.Errorf("filters %v", r.Form)
+		case r.Form.Get("NextToken") == "":
+			fmt.Fprintf(w, instancesPage, reservation("i-1", "10.0.0.1", "us-east-1a"), "page2")
+		default:
+			fmt.Fprintf(w, instancesPage, reservation("i-2", "10.0.0.2", "us-east-1a")+reservation("i-9", "", ""), "")
+		}
+	})
+	mux.HandleFunc("POST /elb/", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("TargetGroupArn") != "arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/api/abc" {
+			t.Errorf("target group %s", r.Form.Get("TargetGroupArn"))
+		}
+		fmt.Fprint(w, `<DescribeTargetHealthResponse><DescribeTargetHealthResult><TargetHealthDescriptions>
+<member><Target><Id>i-3</Id><Port>9090</Port></Target><TargetHealth><State>healthy</State></TargetHealth></member>
+<member><Target><Id>i-4</Id><Port>9090</Port></Target><TargetHealth><State>draining</State></TargetHealth></member>
+<member><Target><Id>10.0.2.5</Id><Port>9090</Port><AvailabilityZone>us-east-1c</AvailabilityZone></Target>
+<TargetHealth><State>healthy</State></TargetHealth></member>
+</TargetHealthDescriptions></DescribeTargetHealthResult></DescribeTargetHealthResponse>`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	p := NewAWSProvider(config.DiscoveryConfig{
+		Provider: config.DiscoveryAWS, Region: "us-east-1", Tags: map[string]string{"app": "api", "prod": ""}, Port: 8080,
+		TargetGroups: []string{"arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/api/abc"}, HealthCheck: health,
+	})
+	p.ec2URL, p.elbURL, p.backoff = srv.URL+"/ec2/", srv.URL+"/elb/", time.Millisecond
+	backends, err := p.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []config.Backend{
+		{Address: "10.0.0.1:8080", Weight: 100, Zone: "us-east-1a", Region: "us-east-1", HealthCheck: health},
+		{Address: "10.0.0.2:8080", Weight: 100, Zone: "us-east-1a", Region: "us-east-1", HealthCheck: health},
+		{Address: "10.0.1.3:9090", Weight: 100, Zone: "us-east-1b", Region: "us-east-1", HealthCheck: health},
+		{Address: "10.0.2.5:9090", Weight: 100, Zone: "us-east-1c", Region: "us-east-1", HealthCheck: health},
+	}
+	if !slices.Equal(backends, want) {
+		t.Errorf("got %+v", backends)
+	}
+}
+
+func TestAWSProvider_GivesUpOnErrors(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadRequest)
+		if r.URL.Path == "/elb/" {
+			fmt.Fprint(w, `<ErrorResponse><Error><Code>Throttling</Code><Message>Rate exceeded</Message></Error></ErrorResponse>`)
+			return
+		}
+		fmt.Fprint(w, `<Response><Errors><Error><Code>UnauthorizedOperation</Code><Message>denied</Message></Error></Errors></Response>`)
+	}))
+	defer srv.Close()
+
+	p := NewAWSProvider(config.DiscoveryConfig{Provider: config.DiscoveryAWS, Region: "us-east-1", Tags: map[string]string{"app": "api"}, Port: 8080})
+	p.ec2URL, p.elbURL, p.backoff = srv.URL+"/ec2/", srv.URL+"/elb/", time.Millisecond
+	if _, err := p.Discover(context.Background()); err == nil || !strings.Contains(err.Error(), "UnauthorizedOperation") || calls != 1 {
+		t.Errorf("denied: %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	p.tags, p.targetGroups = nil, []string{"arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/api/abc"}
+	if _, err := p.Discover(context.Background()); err == nil || !strings.Contains(err.Error(), "Throttling") || calls != awsAttempts {
+		t.Errorf("throttled: %v after %d calls", err, calls)
+	}
+}
+
+func TestCredentialChain_InstanceProfile(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "")
+	fetches := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /latest/api/token", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "token")
+	})
+	mux.HandleFunc("GET /latest/meta-data/iam/security-credentials/{role...}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Aws-Ec2-Metadata-Token") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.PathValue("role") == "" {
+			fmt.Fprint(w, "aegis\n")
+			return
+		}
+		fetches++
+		fmt.Fprintf(w, `{"Code":"Success","AccessKeyId":"ASIA","SecretAccessKey":"secret","Token":"session","Expiration":%q}`,
+			time.Now().Add(time.Hour).Format(time.RFC3339))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := newCredentialChain()
+	c.imdsURL = srv.URL
+	for range 2 {
+		creds, err := c.get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if creds.AccessKeyID != "ASIA" || creds.SessionToken != "session" {
+			t.Errorf("got %+v", creds)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("fetched %d times, want the credentials kept", fetches)
+	}
+}
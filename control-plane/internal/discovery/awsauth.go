@@ -0,0 +1,289 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// awsCredentials sign AWS API requests.
+type awsCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"Token"`
+	// Expiration is zero for credentials that don't expire
+	Expiration time.Time `json:"Expiration"`
+}
+
+// Where temporary credentials are fetched from: the ECS task role
+// endpoint and the EC2 instance metadata service.
+const (
+	ecsCredentialsURL = "http://169.254.170.2"
+	imdsURL           = "http://169.254.169.254"
+)
+
+// credentialChain finds credentials the way the AWS SDKs do, short of the
+// shared config files: the AWS_ACCESS_KEY_ID environment variables, then
+// the ECS task role, then the EC2 instance profile. Temporary ones are kept
+// until five minutes before they expire.
+type credentialChain struct {
+	client *http.Client
+	// ecsURL and imdsURL are overridden by tests
+	ecsURL, imdsURL string
+
+	mu     sync.Mutex
+	cached awsCredentials
+}
+
+func newCredentialChain() *credentialChain {
+	return &credentialChain{client: &http.Client{Timeout: 5 * time.Second}, ecsURL: ecsCredentialsURL, imdsURL: imdsURL}
+}
+
+func (c *credentialChain) get(ctx context.Context) (awsCredentials, error) {
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return awsCredentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cached.AccessKeyID != "" && time.Until(c.cached.Expiration) > 5*time.Minute {
+		return c.cached, nil
+	}
+	var creds awsCredentials
+	var err error
+	source := "EC2 instance profile"
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		source = "ECS task role"
+		creds, err = c.fetch(ctx, http.MethodGet, c.ecsURL+uri, nil)
+	} else {
+		creds, err = c.instanceProfile(ctx)
+	}
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("no AWS credentials in the environment, and none from the %s: %w", source, err)
+	}
+	c.cached = creds
+	return creds, nil
+}
+
+// instanceProfile fetches the instance role's credentials from IMDSv2.
+func (c *credentialChain) instanceProfile(ctx context.Context) (awsCredentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.imdsURL+"/latest/api/token", nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	token, err := c.read(req)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	header := http.Header{"X-Aws-Ec2-Metadata-Token": {string(token)}}
+
+	const path = "/latest/meta-data/iam/security-credentials/"
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, c.imdsURL+path, nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	req.Header = header
+	role, err := c.read(req)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	name, _, _ := strings.Cut(strings.TrimSpace(string(role)), "\n")
+	if name == "" {
+		return awsCredentials{}, errors.New("the instance has no IAM role")
+	}
+	return c.fetch(ctx, http.MethodGet, c.imdsURL+path+name, header)
+}
+
+func (c *credentialChain) fetch(ctx context.Context, method, target string, header http.Header) (awsCredentials, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	if header != nil {
+		req.Header = header
+	}
+	body, err := c.read(req)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	var creds awsCredentials
+	if err := json.Unmarshal(body, &creds); err != nil {
+		return awsCredentials{}, err
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return awsCredentials{}, errors.New("credentials response has no keys")
+	}
+	return creds, nil
+}
+
+func (c *credentialChain) read(req *http.Request) ([]byte, error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	return body, nil
+}
+
+// signV4 signs req, with body, for service in region with AWS Signature
+// Version 4, as of now.
+func signV4(req *http.Request, body []byte, creds awsCredentials, service, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+	names := slices.Sorted(maps.Keys(headers))
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	bodyHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, canonicalQuery(req.URL.Query()), canonicalHeaders.String(), signedHeaders, hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// canonicalQuery is q sorted and escaped as Signature Version 4 wants.
+func canonicalQuery(q url.Values) string {
+	var pairs []string
+	for name, values := range q {
+		for _, v := range values {
+			pairs = append(pairs, awsEscape(name)+"="+awsEscape(v))
+		}
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// awsEscape percent-encodes s, leaving only the unreserved characters.
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsAttempts is how many times a throttled AWS call is made before the
+// lookup fails and the backends found before are kept.
+const awsAttempts = 4
+
+// awsAPI makes signed calls to AWS APIs in a region.
+type awsAPI struct {
+	region string
+	client *http.Client
+	creds  *credentialChain
+	// backoff is the wait before a throttled call's first retry, doubled
+	// for each one after
+	backoff time.Duration
+}
+
+func newAWSAPI(region string) awsAPI {
+	return awsAPI{region: region, client: &http.Client{}, creds: newCredentialChain(), backoff: 500 * time.Millisecond}
+}
+
+// throttled reports whether code says the account's request rate is over
+// the API's limit.
+func throttled(code string) bool {
+	switch code {
+	case "RequestLimitExceeded", "Throttling", "ThrottlingException", "TooManyRequestsException":
+		return true
+	}
+	return false
+}
+
+// send POSTs body, with header, to service at endpoint, signed, and
+// returns the body of its response, backing off and trying again while
+// it's throttled. apiError reads the code and message of an error
+// response; action names the call in errors.
+func (a *awsAPI) send(ctx context.Context, action, endpoint, service string, header http.Header, body []byte,
+	apiError func(data []byte) (code, message string)) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		creds, err := a.creds.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		maps.Copy(req.Header, header)
+		signV4(req, body, creds, service, a.region, time.Now())
+		resp, err := a.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", action, err)
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", action, err)
+		}
+		if resp.StatusCode == http.StatusOK {
+			return data, nil
+		}
+
+		code, msg := apiError(data)
+		retry := throttled(code) || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable
+		if !retry || attempt == awsAttempts {
+			return nil, fmt.Errorf("%s: %s %s: %s", action, resp.Status, code, msg)
+		}
+		// Full jitter, so instances behind the same limit spread out
+		wait := a.backoff << (attempt - 1)
+		wait = wait/2 + rand.N(wait/2+1)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%s: throttled: %w", action, ctx.Err())
+		case <-time.After(wait):
+		}
+	}
+}
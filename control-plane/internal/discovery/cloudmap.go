@@ -0,0 +1,126 @@
+package discovery
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+)
+
+// Cloud Map instance attributes, as ECS service discovery registers them.
+const (
+	cloudMapIPv4 = "AWS_INSTANCE_IPV4"
+	cloudMapIPv6 = "AWS_INSTANCE_IPV6"
+	cloudMapPort = "AWS_INSTANCE_PORT"
+	cloudMapZone = "AVAILABILITY_ZONE"
+)
+
+// CloudMapProvider finds a service's backends among the instances
+// registered in an AWS Cloud Map service, as ECS registers a service's
+// tasks, with DiscoverInstances: the instances Cloud Map or Route 53
+// health checks say are healthy, by default, and that have the attributes
+// asked for. An instance is its IPv4 address, else its IPv6 one, on its
+// registered port, else the configured one, placed in its availability
+// zone for locality-aware balancing.
+type CloudMapProvider struct {
+	awsAPI
+	namespace, service string
+	// healthStatus is DiscoverInstances' HealthStatus
+	healthStatus string
+	attributes   map[string]string
+	port         int
+	health       config.HealthCheckConfig
+
+	// url is the API endpoint, overridden by tests
+	url string
+}
+
+// NewCloudMapProvider returns a provider discovering cfg.Service's
+// instances in the cfg.Namespace namespace.
+func NewCloudMapProvider(cfg config.DiscoveryConfig) *CloudMapProvider {
+	return &CloudMapProvider{
+		awsAPI:       newAWSAPI(cfg.Region),
+		namespace:    cfg.Namespace,
+		service:      cfg.Service,
+		healthStatus: strings.ToUpper(cmp.Or(cfg.HealthStatus, config.CloudMapHealthy)),
+		attributes:   cfg.Attributes,
+		port:         cfg.Port,
+		health:       cfg.HealthCheck,
+		url:          "https://data-servicediscovery." + cfg.Region + ".amazonaws.com/",
+	}
+}
+
+type discoverInstancesRequest struct {
+	NamespaceName   string            `json:"NamespaceName"`
+	ServiceName     string            `json:"ServiceName"`
+	HealthStatus    string            `json:"HealthStatus"`
+	MaxResults      int               `json:"MaxResults"`
+	QueryParameters map[string]string `json:"QueryParameters,omitempty"`
+}
+
+type discoverInstancesResponse struct {
+	Instances []struct {
+		InstanceID string            `json:"InstanceId"`
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Instances"`
+}
+
+func (p *CloudMapProvider) Discover(ctx context.Context) ([]config.Backend, error) {
+	body, _ := json.Marshal(discoverInstancesRequest{
+		NamespaceName:   p.namespace,
+		ServiceName:     p.service,
+		HealthStatus:    p.healthStatus,
+		MaxResults:      1000,
+		QueryParameters: p.attributes,
+	})
+	header := http.Header{
+		"Content-Type": {"application/x-amz-json-1.1"},
+		"X-Amz-Target": {"Route53AutoNaming_v20170314.DiscoverInstances"},
+	}
+	data, err := p.send(ctx, "DiscoverInstances", p.url, "servicediscovery", header, body, func(data []byte) (string, string) {
+		var apiErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+			Upper   string `json:"Message"`
+		}
+		json.Unmarshal(data, &apiErr)
+		// __type may be prefixed with the service's namespace
+		_, code, _ := strings.Cut(apiErr.Type, "#")
+		return cmp.Or(code, apiErr.Type), cmp.Or(apiErr.Message, apiErr.Upper)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var resp discoverInstancesResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("DiscoverInstances: %w", err)
+	}
+
+	var backends []config.Backend
+	for _, inst := range resp.Instances {
+		ip := cmp.Or(inst.Attributes[cloudMapIPv4], inst.Attributes[cloudMapIPv6])
+		port := p.port
+		if registered, err := strconv.Atoi(inst.Attributes[cloudMapPort]); err == nil {
+			port = registered
+		}
+		if net.ParseIP(ip) == nil || port < 1 || port > 65535 {
+			continue
+		}
+		address := net.JoinHostPort(ip, strconv.Itoa(port))
+		if slices.ContainsFunc(backends, func(b config.Backend) bool { return b.Address == address }) {
+			continue
+		}
+		backends = append(backends, config.Backend{
+			Address: address, Weight: 100, Zone: inst.Attributes[cloudMapZone], Region: p.region, HealthCheck: p.health,
+		})
+	}
+	slices.SortFunc(backends, func(a, b config.Backend) int { return cmp.Compare(a.Address, b.Address) })
+	return backends, nil
+}
@@ -0,0 +1,85 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+)
+
+func TestCloudMapProvider_Discover(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	throttle := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "/us-east-1/servicediscovery/aws4_request") {
+			t.Errorf("authorization %q", r.Header.Get("Authorization"))
+		}
+		if r.Header.Get("X-Amz-Target") != "Route53AutoNaming_v20170314.DiscoverInstances" ||
+			r.Header.Get("Content-Type") != "application/x-amz-json-1.1" {
+			t.Errorf("headers %v", r.Header)
+		}
+		var req discoverInstancesRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.NamespaceName != "internal.example.com" || req.ServiceName != "api" || req.HealthStatus != "HEALTHY_OR_ELSE_ALL" ||
+			!maps.Equal(req.QueryParameters, map[string]string{"ECS_CLUSTER_NAME": "prod"}) {
+			t.Errorf("request %+v", req)
+		}
+		if throttle {
+			throttle = false
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type":"com.amazonaws.servicediscovery#RequestLimitExceeded","message":"Rate exceeded"}`)
+			return
+		}
+		fmt.Fprint(w, `{"Instances":[
+{"InstanceId":"b","Attributes":{"AWS_INSTANCE_IPV4":"10.0.0.2","AWS_INSTANCE_PORT":"8080","AVAILABILITY_ZONE":"us-east-1b"}},
+{"InstanceId":"a","Attributes":{"AWS_INSTANCE_IPV4":"10.0.0.1","AWS_INSTANCE_PORT":"8080","AVAILABILITY_ZONE":"us-east-1a"}},
+{"InstanceId":"c","Attributes":{"AWS_INSTANCE_IPV6":"fd00::3","AVAILABILITY_ZONE":"us-east-1c"}},
+{"InstanceId":"d","Attributes":{"AWS_INSTANCE_CNAME":"api.example.com"}}]}`)
+	}))
+	defer srv.Close()
+
+	p := NewCloudMapProvider(config.DiscoveryConfig{
+		Provider: config.DiscoveryCloudMap, Region: "us-east-1", Namespace: "internal.example.com", Service: "api", Port: 9090,
+		HealthStatus: config.CloudMapHealthyOrElseAll, Attributes: map[string]string{"ECS_CLUSTER_NAME": "prod"}, HealthCheck: health,
+	})
+	p.url, p.backoff = srv.URL, time.Millisecond
+	backends, err := p.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []config.Backend{
+		{Address: "10.0.0.1:8080", Weight: 100, Zone: "us-east-1a", Region: "us-east-1", HealthCheck: health},
+		{Address: "10.0.0.2:8080", Weight: 100, Zone: "us-east-1b", Region: "us-east-1", HealthCheck: health},
+		{Address: "[fd00::3]:9090", Weight: 100, Zone: "us-east-1c", Region: "us-east-1", HealthCheck: health},
+	}
+	if !slices.Equal(backends, want) {
+		t.Errorf("got %+v", backends)
+	}
+}
+
+func TestCloudMapProvider_Errors(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"__type":"NamespaceNotFound","Message":"No namespace found with name internal.example.com"}`)
+	}))
+	defer srv.Close()
+
+	p := NewCloudMapProvider(config.DiscoveryConfig{Provider: config.DiscoveryCloudMap, Region: "us-east-1", Namespace: "internal.example.com", Service: "api"})
+	p.url, p.backoff = srv.URL, time.Millisecond
+	if _, err := p.Discover(context.Background()); err == nil || !strings.Contains(err.Error(), "NamespaceNotFound: No namespace found") || calls != 1 {
+		t.Errorf("got %v after %d calls", err, calls)
+	}
+}
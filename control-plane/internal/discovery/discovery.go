@@ -0,0 +1,335 @@
+// Package discovery keeps TCP backends found in a service registry in the
+// running config, pushing the ones that came and went each time the
+// running discovery config's provider sends a new set.
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"go.uber.org/zap"
+)
+
+// retryInterval is how long a failed push, or a provider that couldn't
+// start or stopped on its own, waits before it's tried again.
+const retryInterval = 5 * time.Second
+
+// Provider streams the backends of a service.
+type Provider interface {
+	// Run starts the provider and returns the channel it sends the
+	// service's backends on, sorted by address, with the config's health
+	// check: the whole set, first as it is now and then each time it
+	// changes. An error means it couldn't start. It stops, closing the
+	// channel, when ctx is done.
+	Run(ctx context.Context) (<-chan []config.Backend, error)
+}
+
+// newResolver returns a resolver asking server, host:port, or the system's
+// when server is empty.
+func newResolver(server string) *net.Resolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// Applier holds the running config and pushes backend changes. The API
+// server is one, so the pushes are serialized with API changes.
+type Applier interface {
+	// Discovery returns the running discovery config.
+	Discovery() config.DiscoveryConfig
+	// SetDiscoveredBackends replaces the discovered TCP backends with
+	// found and pushes them, if that changes anything.
+	SetDiscoveredBackends(found []config.Backend) error
+}
+
+// ErrNothingHeld is returned by Confirm when no set is held back.
+var ErrNothingHeld = errors.New("no discovered backend set is held back")
+
+// Shrink is a set a provider sent that's held back for shrinking the one
+// pushed before it beyond the config's MaxRemovalPercent or MinBackends.
+type Shrink struct {
+	// Backends is how many backends the set has, and Previous how many
+	// the one pushed before it had.
+	Backends, Previous int
+	// Removed are the addresses it drops.
+	Removed []string
+	// Since is when the first of the sets held back in a row came.
+	Since time.Time
+
+	// id tells the sets held back apart, so a confirmation is only of
+	// the one it was for
+	id uint64
+}
+
+// confirmation is a Confirm for the loop.
+type confirmation struct {
+	id     uint64
+	caller string
+}
+
+// Controller runs the provider of the running discovery config, pushing
+// every set it sends, and replaces it when the config changes. A provider
+// that fails to reach its registry sends nothing, leaving the backends
+// found before in place rather than emptying the pool on an outage, and
+// an empty set is only pushed once it's been the latest for the config's
+// HoldEmpty. One shrinking the pool too far waits for a Confirm, and
+// pushes are at least MinPushInterval apart.
+type Controller struct {
+	applier     Applier
+	logger      *zap.Logger
+	changed     chan struct{}
+	confirm     chan confirmation
+	newProvider func(config.DiscoveryConfig, *zap.Logger) (Provider, error)
+	retry       time.Duration
+
+	// cfg is the discovery config the running provider was made from
+	cfg config.DiscoveryConfig
+	// updates is what the provider sends on, nil while none is running
+	updates <-chan []config.Backend
+	stop    context.CancelFunc
+	// pending is the last set sent, and dirty says it's yet to be pushed
+	pending []config.Backend
+	dirty   bool
+	// confirmed lets pending through however far it shrinks the pool
+	confirmed bool
+	// retryAt is when to try again what couldn't be done, zero for never
+	retryAt time.Time
+	// last is the set pushed last, at lastPush, and emptySince when an
+	// empty set replacing it was first held back
+	last       []config.Backend
+	lastPush   time.Time
+	emptySince time.Time
+
+	mu     sync.Mutex
+	held   *Shrink
+	heldID uint64
+}
+
+// New returns a controller for applier's discovery config. No provider
+// is started until Run.
+func New(applier Applier, logger *zap.Logger) *Controller {
+	return &Controller{
+		applier:     applier,
+		logger:      logger,
+		changed:     make(chan struct{}, 1),
+		confirm:     make(chan confirmation, 1),
+		newProvider: NewProvider,
+		retry:       retryInterval,
+	}
+}
+
+// Changed tells the controller the running config was replaced, so a
+// changed discovery config gets its provider straight away. It doesn't
+// block, so it's safe to call with the API's apply lock held.
+func (c *Controller) Changed() {
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Held returns the set held back for shrinking the pool too far, if one
+// is.
+func (c *Controller) Held() (Shrink, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.held == nil {
+		return Shrink{}, false
+	}
+	return *c.held, true
+}
+
+// Confirm lets the set held back through, returning it; it's pushed in
+// the background, unless a set replaces it first. caller is logged.
+func (c *Controller) Confirm(caller string) (Shrink, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.held == nil {
+		return Shrink{}, ErrNothingHeld
+	}
+	select {
+	case c.confirm <- confirmation{id: c.held.id, caller: caller}:
+	default:
+	}
+	return *c.held, nil
+}
+
+// Run runs the configured provider until ctx is done.
+func (c *Controller) Run(ctx context.Context) {
+	defer c.stopProvider()
+	c.reconfigure(ctx)
+	for {
+		var retry <-chan time.Time
+		if !c.retryAt.IsZero() {
+			retry = time.After(time.Until(c.retryAt))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.changed:
+			c.reconfigure(ctx)
+		case found, ok := <-c.updates:
+			if !ok {
+				c.logger.Warn("Discovery provider stopped; restarting it",
+					zap.String("provider", c.cfg.Provider),
+					zap.String("target", c.cfg.Target()))
+				c.stopProvider()
+				c.retryAt = time.Now().Add(c.retry)
+				continue
+			}
+			c.pending, c.dirty, c.confirmed = found, true, false
+			c.push()
+		case conf := <-c.confirm:
+			c.mu.Lock()
+			current := c.held != nil && c.held.id == conf.id
+			c.mu.Unlock()
+			if current && c.dirty {
+				c.logger.Info("Shrinking discovered backends confirmed",
+					zap.String("caller", conf.caller),
+					zap.Int("backends", len(c.pending)),
+					zap.Int("previous", len(c.last)))
+				c.confirmed = true
+				c.push()
+			}
+		case <-retry:
+			c.retryAt = time.Time{}
+			if c.updates == nil {
+				c.reconfigure(ctx)
+			}
+			if c.dirty {
+				c.push()
+			}
+		}
+	}
+}
+
+// reconfigure starts the running discovery config's provider, stopping
+// the one before, unless it's running already.
+func (c *Controller) reconfigure(ctx context.Context) {
+	cfg := c.applier.Discovery()
+	if c.updates != nil && cfg.Equal(c.cfg) {
+		return
+	}
+	c.stopProvider()
+	c.cfg = cfg
+	if !cfg.Enabled() {
+		c.last = nil
+		return
+	}
+	provider, err := c.newProvider(cfg, c.logger)
+	if err == nil {
+		var runCtx context.Context
+		runCtx, c.stop = context.WithCancel(ctx)
+		c.updates, err = provider.Run(runCtx)
+	}
+	if err != nil {
+		c.stopProvider()
+		c.retryAt = time.Now().Add(c.retry)
+		c.logger.Error("Failed to start discovery provider",
+			zap.String("provider", cfg.Provider),
+			zap.String("target", cfg.Target()),
+			zap.Error(err))
+	}
+}
+
+func (c *Controller) stopProvider() {
+	if c.stop != nil {
+		c.stop()
+	}
+	c.stop, c.updates, c.pending, c.dirty, c.confirmed = nil, nil, nil, false, false
+	c.retryAt, c.emptySince = time.Time{}, time.Time{}
+	c.setHeld(nil)
+}
+
+// push pushes the pending set, unless it's to wait: leaving it pending to
+// be tried again at retryAt if that fails, it's an empty one still held
+// back or it's too soon after the last push, or to be confirmed if it
+// shrinks the pool too far.
+func (c *Controller) push() {
+	now := time.Now()
+	c.retryAt = time.Time{}
+	if len(c.pending) > 0 || len(c.last) == 0 {
+		c.emptySince = time.Time{}
+	} else {
+		if c.emptySince.IsZero() {
+			c.emptySince = now
+			c.logger.Warn("Discovery found no backends; holding the ones found before",
+				zap.String("provider", c.cfg.Provider),
+				zap.String("target", c.cfg.Target()),
+				zap.Int("backends", len(c.last)),
+				zap.Duration("hold", c.cfg.HoldEmpty))
+		}
+		if until := c.emptySince.Add(c.cfg.HoldEmpty); now.Before(until) {
+			c.retryAt = until
+			return
+		}
+	}
+	if removed, ok := c.shrinksTooFar(); ok && !c.confirmed {
+		c.setHeld(&Shrink{Backends: len(c.pending), Previous: len(c.last), Removed: removed, Since: now})
+		return
+	}
+	c.setHeld(nil)
+	if next := c.lastPush.Add(c.cfg.MinPushInterval); now.Before(next) {
+		c.retryAt = next
+		return
+	}
+	if err := c.applier.SetDiscoveredBackends(c.pending); err != nil {
+		c.logger.Error("Failed to push discovered backends", zap.Int("backends", len(c.pending)), zap.Error(err))
+		c.retryAt = now.Add(c.retry)
+		return
+	}
+	c.dirty, c.confirmed, c.last, c.lastPush, c.emptySince = false, false, c.pending, now, time.Time{}
+}
+
+// shrinksTooFar returns the addresses of the backends pushed last that
+// the pending set drops, and whether that's more than the config allows.
+func (c *Controller) shrinksTooFar() ([]string, bool) {
+	var removed []string
+	for _, b := range c.last {
+		if !slices.ContainsFunc(c.pending, func(p config.Backend) bool { return p.Address == b.Address }) {
+			removed = append(removed, b.Address)
+		}
+	}
+	if len(removed) == 0 {
+		return nil, false
+	}
+	tooMany := c.cfg.MaxRemovalPercent > 0 && len(removed)*100 > c.cfg.MaxRemovalPercent*len(c.last)
+	tooFew := len(c.pending) < c.cfg.MinBackends && len(c.pending) < len(c.last)
+	return removed, tooMany || tooFew
+}
+
+// setHeld records shrink as the set held back, nil for none, logging it
+// once.
+func (c *Controller) setHeld(shrink *Shrink) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if shrink == nil {
+		c.held = nil
+		return
+	}
+	if c.held != nil {
+		shrink.Since = c.held.Since
+	} else {
+		c.logger.Warn("Discovered backends would shrink the pool too far; holding them until confirmed",
+			zap.String("provider", c.cfg.Provider),
+			zap.String("target", c.cfg.Target()),
+			zap.Int("backends", shrink.Backends),
+			zap.Int("previous", shrink.Previous),
+			zap.Strings("removed", shrink.Removed))
+	}
+	c.heldID++
+	shrink.id = c.heldID
+	c.held = shrink
+}
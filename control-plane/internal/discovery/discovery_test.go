@@ -0,0 +1,421 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"go.uber.org/zap"
+)
+
+var health = config.HealthCheckConfig{Scheme: "http"}
+
+func TestSRVProvider_Discover(t *testing.T) {
+	p := &SRVProvider{service: "_api._tcp.example.com", health: health,
+		lookup: func(_ context.Context, name string) ([]*net.SRV, error) {
+			if name != "_api._tcp.example.com" {
+				t.Errorf("looked up %s", name)
+			}
+			return []*net.SRV{
+				{Target: "b.example.com.", Port: 8080, Priority: 10, Weight: 20},
+				{Target: "a.example.com.", Port: 8080, Priority: 0, Weight: 0},
+				{Target: "b.example.com.", Port: 8080, Priority: 20, Weight: 5},
+				{Target: ".", Port: 0},
+				{Target: "c.example.com.", Port: 9090, Priority: 300, Weight: 1},
+			}, nil
+		}}
+	backends, err := p.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []config.Backend{
+		{Address: "a.example.com:8080", Weight: 100, HealthCheck: health},
+		{Address: "b.example.com:8080", Weight: 20, Priority: 10, HealthCheck: health},
+		{Address: "c.example.com:9090", Weight: 1, Priority: config.MaxPriority, HealthCheck: health},
+	}
+	if !slices.Equal(backends, want) {
+		t.Errorf("got %+v", backends)
+	}
+
+	p.lookup = func(context.Context, string) ([]*net.SRV, error) { return nil, errors.New("no such host") }
+	if _, err := p.Discover(context.Background()); err == nil {
+		t.Error("expected the lookup error")
+	}
+}
+
+func TestDNSProvider_Discover(t *testing.T) {
+	p := NewDNSProvider(config.DiscoveryConfig{Provider: config.DiscoveryDNS, Address: "api.internal:8080", HealthCheck: health})
+	p.lookup = func(_ context.Context, host string) ([]netip.Addr, error) {
+		if host != "api.internal" {
+			t.Errorf("resolved %s", host)
+		}
+		return []netip.Addr{
+			netip.MustParseAddr("10.0.0.2"),
+			netip.MustParseAddr("2001:db8::1"),
+			netip.MustParseAddr("::ffff:10.0.0.1"),
+			netip.MustParseAddr("10.0.0.2"),
+		}, nil
+	}
+	backends, err := p.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []config.Backend{
+		{Address: "10.0.0.1:8080", Weight: 100, HealthCheck: health},
+		{Address: "10.0.0.2:8080", Weight: 100, HealthCheck: health},
+		{Address: "[2001:db8::1]:8080", Weight: 100, HealthCheck: health},
+	}
+	if !slices.Equal(backends, want) {
+		t.Errorf("got %+v", backends)
+	}
+}
+
+type fakeApplier struct {
+	mu     sync.Mutex
+	cfg    config.DiscoveryConfig
+	pushed chan []config.Backend
+	fail   bool
+}
+
+func (f *fakeApplier) Discovery() config.DiscoveryConfig {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.cfg
+}
+
+func (f *fakeApplier) SetDiscoveredBackends(found []config.Backend) error {
+	f.mu.Lock()
+	fail := f.fail
+	f.fail = false
+	f.mu.Unlock()
+	if fail {
+		return errors.New("data plane unavailable")
+	}
+	f.pushed <- found
+	return nil
+}
+
+// fakeProvider sends what the test puts on updates.
+type fakeProvider struct {
+	updates chan []config.Backend
+}
+
+func (f *fakeProvider) Run(ctx context.Context) (<-chan []config.Backend, error) {
+	out := make(chan []config.Backend)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case found := <-f.updates:
+				select {
+				case out <- found:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+func receive[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatalf("nothing for %s", what)
+	}
+	var zero T
+	return zero
+}
+
+func TestController_PushesChanges(t *testing.T) {
+	applier := &fakeApplier{cfg: config.DiscoveryConfig{Provider: config.DiscoveryDNSSRV, Service: "_api._tcp.example.com",
+		Interval: config.DefaultDiscoveryInterval}, pushed: make(chan []config.Backend, 10)}
+	c := New(applier, zap.NewNop())
+	c.retry = 10 * time.Millisecond
+	made := make(chan config.DiscoveryConfig, 10)
+	provider := &fakeProvider{updates: make(chan []config.Backend)}
+	c.newProvider = func(cfg config.DiscoveryConfig, _ *zap.Logger) (Provider, error) {
+		made <- cfg
+		return provider, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	receive(t, made, "the first provider")
+	provider.updates <- []config.Backend{{Address: "a:80", Weight: 100}}
+	if got := receive(t, applier.pushed, "the first set"); len(got) != 1 {
+		t.Errorf("first set: %+v", got)
+	}
+
+	// A failed push is tried again
+	applier.mu.Lock()
+	applier.fail = true
+	applier.mu.Unlock()
+	provider.updates <- []config.Backend{{Address: "a:80", Weight: 100}, {Address: "b:80", Weight: 100}}
+	if got := receive(t, applier.pushed, "the retried push"); len(got) != 2 {
+		t.Errorf("retried: %+v", got)
+	}
+
+	// An unchanged config keeps its provider, a changed one gets its own
+	c.Changed()
+	applier.mu.Lock()
+	applier.cfg.Service = "_api._tcp.example.org"
+	applier.mu.Unlock()
+	c.Changed()
+	if cfg := receive(t, made, "the changed config"); cfg.Service != "_api._tcp.example.org" {
+		t.Errorf("provider made from %+v", cfg)
+	}
+	select {
+	case cfg := <-made:
+		t.Errorf("extra provider for %+v", cfg)
+	default:
+	}
+}
+
+func TestController_HoldsEmpty(t *testing.T) {
+	applier := &fakeApplier{cfg: config.DiscoveryConfig{Provider: config.DiscoveryDNSSRV, Service: "_api._tcp.example.com",
+		Interval: time.Second, HoldEmpty: 200 * time.Millisecond}, pushed: make(chan []config.Backend, 10)}
+	c := New(applier, zap.NewNop())
+	c.retry = 10 * time.Millisecond
+	provider := &fakeProvider{updates: make(chan []config.Backend)}
+	c.newProvider = func(config.DiscoveryConfig, *zap.Logger) (Provider, error) { return provider, nil }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	provider.updates <- []config.Backend{{Address: "a:80", Weight: 100}}
+	receive(t, applier.pushed, "the first set")
+
+	// A registry that flaps back before the hold is up never empties the pool
+	provider.updates <- nil
+	provider.updates <- []config.Backend{{Address: "a:80", Weight: 100}, {Address: "b:80", Weight: 100}}
+	if got := receive(t, applier.pushed, "the set after the flap"); len(got) != 2 {
+		t.Errorf("after the flap: %+v", got)
+	}
+
+	start := time.Now()
+	provider.updates <- nil
+	if got := receive(t, applier.pushed, "the empty set"); len(got) != 0 {
+		t.Errorf("empty: %+v", got)
+	}
+	if held := time.Since(start); held < applier.cfg.HoldEmpty {
+		t.Errorf("empty set pushed after %s", held)
+	}
+}
+
+func backendsAt(addresses ...string) []config.Backend {
+	var backends []config.Backend
+	for _, address := range addresses {
+		backends = append(backends, config.Backend{Address: address, Weight: 100})
+	}
+	return backends
+}
+
+func TestController_HoldsShrinkUntilConfirmed(t *testing.T) {
+	applier := &fakeApplier{cfg: config.DiscoveryConfig{Provider: config.DiscoveryDNSSRV, Service: "_api._tcp.example.com",
+		Interval: time.Second, MaxRemovalPercent: 50}, pushed: make(chan []config.Backend, 10)}
+	c := New(applier, zap.NewNop())
+	provider := &fakeProvider{updates: make(chan []config.Backend)}
+	c.newProvider = func(config.DiscoveryConfig, *zap.Logger) (Provider, error) { return provider, nil }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	if _, err := c.Confirm("test"); !errors.Is(err, ErrNothingHeld) {
+		t.Errorf("nothing held: got %v", err)
+	}
+	provider.updates <- backendsAt("a:80", "b:80", "c:80", "d:80")
+	receive(t, applier.pushed, "the first set")
+
+	// Three of four going at once is held back, one is let through
+	provider.updates <- backendsAt("a:80")
+	provider.updates <- backendsAt("a:80", "b:80", "c:80")
+	if got := receive(t, applier.pushed, "the set within bounds"); len(got) != 3 {
+		t.Errorf("within bounds: %+v", got)
+	}
+	if _, held := c.Held(); held {
+		t.Error("a set within bounds is still held")
+	}
+
+	provider.updates <- backendsAt("a:80")
+	var shrink Shrink
+	held := false
+	for deadline := time.Now().Add(5 * time.Second); !held && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		shrink, held = c.Held()
+	}
+	if !held || shrink.Backends != 1 || shrink.Previous != 3 || !slices.Equal(shrink.Removed, []string{"b:80", "c:80"}) {
+		t.Fatalf("held: %+v, %v", shrink, held)
+	}
+	select {
+	case got := <-applier.pushed:
+		t.Fatalf("pushed %+v before it was confirmed", got)
+	default:
+	}
+	if _, err := c.Confirm("test"); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, applier.pushed, "the confirmed set"); len(got) != 1 {
+		t.Errorf("confirmed: %+v", got)
+	}
+}
+
+func TestController_RateLimitsPushes(t *testing.T) {
+	applier := &fakeApplier{cfg: config.DiscoveryConfig{Provider: config.DiscoveryDNSSRV, Service: "_api._tcp.example.com",
+		Interval: time.Second, MinPushInterval: 200 * time.Millisecond}, pushed: make(chan []config.Backend, 10)}
+	c := New(applier, zap.NewNop())
+	provider := &fakeProvider{updates: make(chan []config.Backend)}
+	c.newProvider = func(config.DiscoveryConfig, *zap.Logger) (Provider, error) { return provider, nil }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	provider.updates <- backendsAt("a:80")
+	receive(t, applier.pushed, "the first set")
+	start := time.Now()
+	provider.updates <- backendsAt("a:80", "b:80")
+	provider.updates <- backendsAt("a:80", "b:80", "c:80")
+	if got := receive(t, applier.pushed, "the latest set"); len(got) != 3 {
+		t.Errorf("latest: %+v", got)
+	}
+	if since := time.Since(start); since < 150*time.Millisecond {
+		t.Errorf("pushed again after %s", since)
+	}
+}
+
+func TestController_RetriesFailedProvider(t *testing.T) {
+	applier := &fakeApplier{cfg: config.DiscoveryConfig{Provider: "broken", Interval: time.Second},
+		pushed: make(chan []config.Backend, 10)}
+	c := New(applier, zap.NewNop())
+	c.retry = 10 * time.Millisecond
+	attempts := make(chan struct{}, 10)
+	c.newProvider = func(cfg config.DiscoveryConfig, _ *zap.Logger) (Provider, error) {
+		attempts <- struct{}{}
+		if len(attempts) < 2 {
+			return nil, errors.New("registry unreachable")
+		}
+		return NewStaticProvider([]config.Backend{{Address: "a:80", Weight: 100}}), nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	if got := receive(t, applier.pushed, "the restarted provider"); len(got) != 1 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+// fakeDiscoverer answers lookups with whatever it's set to.
+type fakeDiscoverer struct {
+	mu       sync.Mutex
+	backends []config.Backend
+	err      error
+}
+
+func (f *fakeDiscoverer) Discover(context.Context) ([]config.Backend, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.backends, f.err
+}
+
+func (f *fakeDiscoverer) set(backends []config.Backend, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.backends, f.err = backends, err
+}
+
+func TestPoll(t *testing.T) {
+	d := &fakeDiscoverer{backends: []config.Backend{{Address: "a:80", Weight: 100}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	updates, err := Poll(d, config.DiscoveryConfig{Interval: 10 * time.Millisecond}, zap.NewNop()).Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, updates, "the first lookup"); len(got) != 1 {
+		t.Errorf("first lookup: %+v", got)
+	}
+
+	// Failed and unchanged lookups send nothing
+	d.set(nil, errors.New("timeout"))
+	time.Sleep(50 * time.Millisecond)
+	d.set([]config.Backend{{Address: "a:80", Weight: 100}}, nil)
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case got := <-updates:
+		t.Errorf("sent %+v", got)
+	default:
+	}
+
+	d.set([]config.Backend{{Address: "a:80", Weight: 100}, {Address: "b:80", Weight: 100}}, nil)
+	if got := receive(t, updates, "the changed lookup"); len(got) != 2 {
+		t.Errorf("changed lookup: %+v", got)
+	}
+	cancel()
+	for range updates {
+	}
+}
+
+func TestNewProvider_Static(t *testing.T) {
+	cfg := config.DiscoveryConfig{Provider: DiscoveryStatic, Options: map[string]string{"backends": "10.0.0.2:80, 10.0.0.1:80,10.0.0.2:80"},
+		HealthCheck: health}
+	p, err := NewProvider(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	updates, err := p.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []config.Backend{
+		{Address: "10.0.0.1:80", Weight: 100, HealthCheck: health},
+		{Address: "10.0.0.2:80", Weight: 100, HealthCheck: health},
+	}
+	if got := receive(t, updates, "the static set"); !slices.Equal(got, want) {
+		t.Errorf("got %+v", got)
+	}
+	cancel()
+	if _, ok := <-updates; ok {
+		t.Error("expected the channel closed")
+	}
+
+	cfg.Options["backends"] = "10.0.0.1"
+	if _, err := NewProvider(cfg, zap.NewNop()); err == nil {
+		t.Error("expected an error for an address without a port")
+	}
+	if _, err := NewProvider(config.DiscoveryConfig{Provider: "missing"}, zap.NewNop()); err == nil {
+		t.Error("expected an error for an unknown provider")
+	}
+}
+
+func TestRegister_Validates(t *testing.T) {
+	_, err := config.Parse([]byte(`
+proxy:
+  listen_address: "0.0.0.0:8080"
+  discovery:
+    provider: static
+    options:
+      backends: "10.0.0.1"
+`))
+	if err == nil || !strings.Contains(err.Error(), "isn't host:port") {
+		t.Errorf("got %v", err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a taken name to panic")
+		}
+	}()
+	Register(config.DiscoveryAWS, nil)
+}
@@ -0,0 +1,54 @@
+package discovery
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+)
+
+// DNSProvider finds a service's backends by resolving a hostname, each of
+// its A and AAAA records becoming a backend of the default weight, as for
+// an autoscaling group behind round-robin DNS.
+type DNSProvider struct {
+	host, port string
+	health     config.HealthCheckConfig
+	lookup     func(ctx context.Context, host string) ([]netip.Addr, error)
+}
+
+// NewDNSProvider returns a provider resolving the host of cfg.Address,
+// asking cfg.Resolver if set.
+func NewDNSProvider(cfg config.DiscoveryConfig) *DNSProvider {
+	resolver := newResolver(cfg.Resolver)
+	host, port, _ := net.SplitHostPort(cfg.Address)
+	return &DNSProvider{
+		host:   host,
+		port:   port,
+		health: cfg.HealthCheck,
+		lookup: func(ctx context.Context, host string) ([]netip.Addr, error) {
+			return resolver.LookupNetIP(ctx, "ip", host)
+		},
+	}
+}
+
+func (p *DNSProvider) Discover(ctx context.Context) ([]config.Backend, error) {
+	addrs, err := p.lookup(ctx, p.host)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", p.host, err)
+	}
+	backends := make([]config.Backend, 0, len(addrs))
+	for _, addr := range addrs {
+		// An IPv4 address can come back mapped into IPv6
+		address := net.JoinHostPort(addr.Unmap().String(), p.port)
+		if slices.ContainsFunc(backends, func(b config.Backend) bool { return b.Address == address }) {
+			continue
+		}
+		backends = append(backends, config.Backend{Address: address, Weight: 100, HealthCheck: p.health})
+	}
+	slices.SortFunc(backends, func(a, b config.Backend) int { return cmp.Compare(a.Address, b.Address) })
+	return backends, nil
+}
@@ -0,0 +1,67 @@
+package discovery
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+)
+
+// SRVProvider finds a service's backends as the targets of its SRV
+// record. A target's SRV priority becomes its failover priority and its
+// SRV weight its weight, a weight of 0 counting as an unset one.
+type SRVProvider struct {
+	service string
+	health  config.HealthCheckConfig
+	lookup  func(ctx context.Context, name string) ([]*net.SRV, error)
+}
+
+// NewSRVProvider returns a provider looking up cfg.Service, asking
+// cfg.Resolver if set.
+func NewSRVProvider(cfg config.DiscoveryConfig) *SRVProvider {
+	resolver := newResolver(cfg.Resolver)
+	return &SRVProvider{
+		service: cfg.Service,
+		health:  cfg.HealthCheck,
+		lookup: func(ctx context.Context, name string) ([]*net.SRV, error) {
+			_, records, err := resolver.LookupSRV(ctx, "", "", name)
+			return records, err
+		},
+	}
+}
+
+func (p *SRVProvider) Discover(ctx context.Context) ([]config.Backend, error) {
+	records, err := p.lookup(ctx, p.service)
+	if err != nil {
+		return nil, fmt.Errorf("looking up SRV record %s: %w", p.service, err)
+	}
+	backends := make([]config.Backend, 0, len(records))
+	for _, r := range records {
+		// A target of "." says the service isn't offered at all
+		host := strings.TrimSuffix(r.Target, ".")
+		if host == "" {
+			continue
+		}
+		address := net.JoinHostPort(host, strconv.Itoa(int(r.Port)))
+		if slices.ContainsFunc(backends, func(b config.Backend) bool { return b.Address == address }) {
+			continue
+		}
+		weight := int(r.Weight)
+		if weight == 0 {
+			weight = 100
+		}
+		backends = append(backends, config.Backend{
+			Address:     address,
+			Weight:      weight,
+			Priority:    min(int(r.Priority), config.MaxPriority),
+			HealthCheck: p.health,
+		})
+	}
+	slices.SortFunc(backends, func(a, b config.Backend) int { return cmp.Compare(a.Address, b.Address) })
+	return backends, nil
+}
@@ -0,0 +1,182 @@
+package discovery
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+)
+
+// Container labels docker discovery reads.
+const (
+	// LabelBackend set to true makes a container's published ports
+	// backends.
+	LabelBackend = "aegis.backend"
+	// LabelPort picks the one container port to register when it
+	// publishes several.
+	LabelPort = "aegis.port"
+	// LabelWeight is the backends' weight, 100 when unset.
+	LabelWeight = "aegis.weight"
+)
+
+// DockerProvider finds a service's backends among the running containers
+// of a Docker daemon labelled aegis.backend=true, one for each published
+// TCP port. It talks to the Engine API directly, and watches the
+// daemon's container events so a container starting or stopping is
+// looked up straight away.
+type DockerProvider struct {
+	client *http.Client
+	// base is the URL the API paths go under
+	base string
+	// publicHost stands in for a port published on every interface: the
+	// loopback address for a local daemon, or a remote daemon's host
+	publicHost string
+	health     config.HealthCheckConfig
+	// retry is how long a broken event stream waits before it's opened
+	// again
+	retry time.Duration
+}
+
+// NewDockerProvider returns a provider asking the daemon at
+// cfg.DockerHost.
+func NewDockerProvider(cfg config.DiscoveryConfig) *DockerProvider {
+	p := &DockerProvider{
+		client:     &http.Client{},
+		base:       "http://docker",
+		publicHost: "127.0.0.1",
+		health:     cfg.HealthCheck,
+		retry:      min(cfg.Interval, 5*time.Second),
+	}
+	u, _ := url.Parse(cfg.DockerHost)
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		p.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+	case "tcp":
+		p.base = "http://" + u.Host
+		p.publicHost = u.Hostname()
+	}
+	return p
+}
+
+// get sends a GET for path with the label filter and more, a JSON object
+// of Engine API filters.
+func (p *DockerProvider) get(ctx context.Context, path string, filters map[string][]string) (*http.Response, error) {
+	filters["label"] = []string{LabelBackend + "=true"}
+	data, err := json.Marshal(filters)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.base+path+"?filters="+url.QueryEscape(string(data)), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+func (p *DockerProvider) Discover(ctx context.Context) ([]config.Backend, error) {
+	resp, err := p.get(ctx, "/containers/json", map[string][]string{"status": {"running"}})
+	if err != nil {
+		return nil, fmt.Errorf("listing Docker containers: %w", err)
+	}
+	defer resp.Body.Close()
+	var containers []struct {
+		Labels map[string]string
+		Ports  []struct {
+			IP          string
+			PrivatePort int
+			PublicPort  int
+			Type        string
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+		return nil, fmt.Errorf("listing Docker containers: %w", err)
+	}
+
+	var backends []config.Backend
+	for _, c := range containers {
+		weight, err := strconv.Atoi(c.Labels[LabelWeight])
+		if err != nil || weight < 0 {
+			weight = 100
+		}
+		port, _ := strconv.Atoi(c.Labels[LabelPort])
+		for _, binding := range c.Ports {
+			if binding.Type != "tcp" || binding.PublicPort == 0 || (port != 0 && binding.PrivatePort != port) {
+				continue
+			}
+			host := binding.IP
+			if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+				host = p.publicHost
+			}
+			// A port published on every interface is listed for IPv4 and
+			// IPv6 both
+			address := net.JoinHostPort(host, strconv.Itoa(binding.PublicPort))
+			if slices.ContainsFunc(backends, func(b config.Backend) bool { return b.Address == address }) {
+				continue
+			}
+			backends = append(backends, config.Backend{Address: address, Weight: weight, HealthCheck: p.health})
+		}
+	}
+	slices.SortFunc(backends, func(a, b config.Backend) int { return cmp.Compare(a.Address, b.Address) })
+	return backends, nil
+}
+
+// Watch calls changed whenever a labelled container starts, stops or
+// goes, and once each time the event stream is opened, to catch what
+// happened while it wasn't. A broken stream is opened again until ctx is
+// done.
+func (p *DockerProvider) Watch(ctx context.Context, changed func()) {
+	for {
+		p.watch(ctx, changed)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.retry):
+		}
+	}
+}
+
+// watch streams the daemon's events until the stream breaks.
+func (p *DockerProvider) watch(ctx context.Context, changed func()) {
+	resp, err := p.get(ctx, "/events", map[string][]string{
+		"type":  {"container"},
+		"event": {"start", "die", "stop", "kill", "pause", "unpause", "destroy"},
+	})
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	changed()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var event json.RawMessage
+		if dec.Decode(&event) != nil {
+			return
+		}
+		changed()
+	}
+}
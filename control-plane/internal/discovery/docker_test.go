@@ -0,0 +1,99 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+)
+
+// fakeDocker serves the container list and event stream of the Engine
+// API.
+func fakeDocker(t *testing.T, events chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if filters := r.URL.Query().Get("filters"); !strings.Contains(filters, `"label":["aegis.backend=true"]`) {
+			t.Errorf("%s filters %s", r.URL.Path, filters)
+		}
+		switch r.URL.Path {
+		case "/containers/json":
+			w.Write([]byte(`[
+				{"Labels":{"aegis.backend":"true","aegis.weight":"50"},"Ports":[
+					{"IP":"0.0.0.0","PrivatePort":80,"PublicPort":32768,"Type":"tcp"},
+					{"IP":"::","PrivatePort":80,"PublicPort":32768,"Type":"tcp"},
+					{"IP":"0.0.0.0","PrivatePort":53,"PublicPort":5353,"Type":"udp"}]},
+				{"Labels":{"aegis.backend":"true","aegis.port":"8080"},"Ports":[
+					{"IP":"10.0.0.5","PrivatePort":8080,"PublicPort":8080,"Type":"tcp"},
+					{"IP":"10.0.0.5","PrivatePort":9090,"PublicPort":9090,"Type":"tcp"},
+					{"PrivatePort":7000,"Type":"tcp"}]}]`))
+		case "/events":
+			w.(http.Flusher).Flush()
+			enc := json.NewEncoder(w)
+			for range events {
+				enc.Encode(map[string]string{"Type": "container", "Action": "start"})
+				w.(http.Flusher).Flush()
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func TestDockerProvider_Discover(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("no unix sockets: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(fakeDocker(t, nil))
+	srv.Listener = l
+	srv.Start()
+	defer srv.Close()
+
+	p := NewDockerProvider(config.DiscoveryConfig{Provider: config.DiscoveryDocker, DockerHost: "unix://" + socket, HealthCheck: health})
+	backends, err := p.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []config.Backend{
+		{Address: "10.0.0.5:8080", Weight: 100, HealthCheck: health},
+		{Address: "127.0.0.1:32768", Weight: 50, HealthCheck: health},
+	}
+	if !slices.Equal(backends, want) {
+		t.Errorf("got %+v", backends)
+	}
+}
+
+func TestDockerProvider_Watch(t *testing.T) {
+	events := make(chan struct{})
+	srv := httptest.NewServer(fakeDocker(t, events))
+	defer srv.Close()
+	defer close(events)
+
+	p := NewDockerProvider(config.DiscoveryConfig{DockerHost: "tcp://" + srv.Listener.Addr().String(), Interval: time.Second})
+	if p.publicHost != "127.0.0.1" {
+		t.Errorf("public host %s", p.publicHost)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 10)
+	go p.Watch(ctx, func() { changed <- struct{}{} })
+
+	wait := func(what string) {
+		select {
+		case <-changed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no change for %s", what)
+		}
+	}
+	wait("the stream opening")
+	events <- struct{}{}
+	wait("a container starting")
+}
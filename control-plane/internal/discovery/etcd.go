@@ -0,0 +1,130 @@
+package discovery
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/etcd"
+)
+
+// EtcdProvider finds a service's backends in the values under an etcd key
+// prefix, one JSON object per instance:
+//
+//	{"address": "10.0.0.1:8080", "weight": 100, "priority": 0, "zone": "us-east-1a", "region": "us-east-1"}
+//
+// Only address is required. It talks to etcd through the etcd package's
+// gateway client, and watches the prefix so a change is looked up
+// straight away.
+type EtcdProvider struct {
+	client *etcd.Client
+	prefix string
+	health config.HealthCheckConfig
+	// retry is how long a broken watch waits before it's opened again
+	retry time.Duration
+}
+
+// etcdInstance is the value of a key under the prefix.
+type etcdInstance struct {
+	Address  string `json:"address"`
+	Weight   int    `json:"weight"`
+	Priority int    `json:"priority"`
+	Zone     string `json:"zone"`
+	Region   string `json:"region"`
+}
+
+// NewEtcdProvider returns a provider reading cfg.Prefix from the etcd
+// cluster cfg names.
+func NewEtcdProvider(cfg config.DiscoveryConfig) (*EtcdProvider, error) {
+	client, err := etcd.New(cfg.EtcdConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &EtcdProvider{
+		client: client,
+		prefix: cfg.Prefix,
+		health: cfg.HealthCheck,
+		retry:  min(cfg.Interval, 5*time.Second),
+	}, nil
+}
+
+func (p *EtcdProvider) Discover(ctx context.Context) ([]config.Backend, error) {
+	kvs, err := p.client.List(ctx, p.prefix)
+	if err != nil {
+		return nil, fmt.Errorf("reading etcd prefix %s: %w", p.prefix, err)
+	}
+
+	backends := make([]config.Backend, 0, len(kvs))
+	for _, kv := range kvs {
+		// A value that isn't an instance, such as a directory marker, is
+		// skipped rather than failing the lookup
+		var inst etcdInstance
+		if json.Unmarshal(kv.Value, &inst) != nil {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(inst.Address); err != nil {
+			continue
+		}
+		if slices.ContainsFunc(backends, func(b config.Backend) bool { return b.Address == inst.Address }) {
+			continue
+		}
+		if inst.Weight <= 0 {
+			inst.Weight = 100
+		}
+		backends = append(backends, config.Backend{
+			Address:     inst.Address,
+			Weight:      inst.Weight,
+			Priority:    min(max(inst.Priority, 0), config.MaxPriority),
+			Zone:        inst.Zone,
+			Region:      inst.Region,
+			HealthCheck: p.health,
+		})
+	}
+	slices.SortFunc(backends, func(a, b config.Backend) int { return cmp.Compare(a.Address, b.Address) })
+	return backends, nil
+}
+
+// Watch calls changed whenever a key under the prefix is put or deleted,
+// and once each time the watch is opened, to catch what happened while it
+// wasn't. A broken watch is opened again until ctx is done.
+func (p *EtcdProvider) Watch(ctx context.Context, changed func()) {
+	for {
+		p.watch(ctx, changed)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.retry):
+		}
+	}
+}
+
+// watch streams one watch until it breaks.
+func (p *EtcdProvider) watch(ctx context.Context, changed func()) {
+	resp, err := p.client.Stream(ctx, "/v3/watch", map[string]any{"create_request": etcd.PrefixRange(p.prefix)})
+	if err != nil {
+		return
+	}
+	defer resp.Close()
+	dec := json.NewDecoder(resp)
+	for {
+		var msg struct {
+			Result struct {
+				Created  bool              `json:"created"`
+				Canceled bool              `json:"canceled"`
+				Events   []json.RawMessage `json:"events"`
+			} `json:"result"`
+			Error json.RawMessage `json:"error"`
+		}
+		if dec.Decode(&msg) != nil || msg.Error != nil || msg.Result.Canceled {
+			return
+		}
+		if msg.Result.Created || len(msg.Result.Events) > 0 {
+			changed()
+		}
+	}
+}
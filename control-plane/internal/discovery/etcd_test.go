@@ -0,0 +1,111 @@
+package discovery
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+)
+
+// fakeEtcd serves the range and watch calls of etcd's JSON gateway for
+// keys under /services/api/.
+func fakeEtcd(t *testing.T, values []string, events chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]json.RawMessage
+		json.NewDecoder(r.Body).Decode(&req)
+		switch r.URL.Path {
+		case "/v3/kv/range":
+			if got := string(req["key"]); got != `"`+base64.StdEncoding.EncodeToString([]byte("/services/api/"))+`"` {
+				t.Errorf("range key %s", got)
+			}
+			kvs := make([]map[string][]byte, len(values))
+			for i, v := range values {
+				kvs[i] = map[string][]byte{"key": []byte(fmt.Sprintf("/services/api/%d", i)), "value": []byte(v)}
+			}
+			json.NewEncoder(w).Encode(map[string]any{"kvs": kvs})
+		case "/v3/watch":
+			enc := json.NewEncoder(w)
+			enc.Encode(map[string]any{"result": map[string]any{"created": true}})
+			w.(http.Flusher).Flush()
+			for range events {
+				enc.Encode(map[string]any{"result": map[string]any{"events": []any{map[string]any{"type": "PUT"}}}})
+				w.(http.Flusher).Flush()
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestEtcdProvider_Discover(t *testing.T) {
+	srv := fakeEtcd(t, []string{
+		`{"address":"10.0.0.2:8080","weight":50,"priority":1,"zone":"us-east-1a"}`,
+		`{"address":"10.0.0.1:8080"}`,
+		`not an instance`,
+		`{"address":"10.0.0.3"}`,
+	}, nil)
+	defer srv.Close()
+
+	// The first endpoint is down, so the second answers
+	p, err := NewEtcdProvider(config.DiscoveryConfig{Provider: config.DiscoveryEtcd,
+		EtcdConfig: config.EtcdConfig{Endpoints: []string{"http://127.0.0.1:1", srv.URL}},
+		Prefix:     "/services/api/", Interval: time.Second, HealthCheck: health})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backends, err := p.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []config.Backend{
+		{Address: "10.0.0.1:8080", Weight: 100, HealthCheck: health},
+		{Address: "10.0.0.2:8080", Weight: 50, Priority: 1, Zone: "us-east-1a", HealthCheck: health},
+	}
+	if !slices.Equal(backends, want) {
+		t.Errorf("got %+v", backends)
+	}
+
+	down, err := NewEtcdProvider(config.DiscoveryConfig{EtcdConfig: config.EtcdConfig{Endpoints: []string{"http://127.0.0.1:1"}},
+		Prefix: "/services/api/", Interval: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := down.Discover(context.Background()); err == nil {
+		t.Error("expected an error with no endpoint up")
+	}
+}
+
+func TestEtcdProvider_Watch(t *testing.T) {
+	events := make(chan struct{})
+	srv := fakeEtcd(t, nil, events)
+	defer srv.Close()
+	defer close(events)
+
+	p, err := NewEtcdProvider(config.DiscoveryConfig{EtcdConfig: config.EtcdConfig{Endpoints: []string{srv.URL}},
+		Prefix: "/services/api/", Interval: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 10)
+	go p.Watch(ctx, func() { changed <- struct{}{} })
+
+	wait := func(what string) {
+		select {
+		case <-changed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no change for %s", what)
+		}
+	}
+	wait("the watch opening")
+	events <- struct{}{}
+	wait("a put")
+}
@@ -0,0 +1,150 @@
+package discovery
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+)
+
+// nomadWait is how long a blocking query is held open when nothing
+// changes; Nomad caps it at ten minutes.
+const nomadWait = 5 * time.Minute
+
+// NomadProvider finds a service's backends in the registrations of
+// Nomad's native service catalog, one for each allocation registering it.
+// It watches the service with blocking queries, so an allocation starting
+// or stopping is looked up straight away.
+type NomadProvider struct {
+	address   string
+	service   string
+	namespace string
+	// token is NOMAD_TOKEN, the ACL token sent with every query
+	token  string
+	health config.HealthCheckConfig
+	client *http.Client
+	// retry is how long a failed blocking query waits before it's tried
+	// again
+	retry time.Duration
+	wait  time.Duration
+}
+
+// nomadRegistration is one allocation's registration of the service.
+type nomadRegistration struct {
+	Address    string
+	Port       int
+	Datacenter string
+}
+
+// NewNomadProvider returns a provider reading cfg.Service from the agent
+// at cfg.NomadAddress.
+func NewNomadProvider(cfg config.DiscoveryConfig) *NomadProvider {
+	return &NomadProvider{
+		address:   strings.TrimSuffix(cfg.NomadAddress, "/"),
+		service:   cfg.Service,
+		namespace: cfg.Namespace,
+		token:     os.Getenv("NOMAD_TOKEN"),
+		health:    cfg.HealthCheck,
+		client:    &http.Client{},
+		retry:     min(cfg.Interval, 5*time.Second),
+		wait:      nomadWait,
+	}
+}
+
+// get reads the service's registrations, blocking until the catalog's
+// index passes index when it isn't zero, and returns them with the index
+// they're as of.
+func (p *NomadProvider) get(ctx context.Context, index uint64) ([]nomadRegistration, uint64, error) {
+	q := url.Values{}
+	if p.namespace != "" {
+		q.Set("namespace", p.namespace)
+	}
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", strconv.Itoa(int(p.wait.Seconds()))+"s")
+	}
+	target := p.address + "/v1/service/" + url.PathEscape(p.service)
+	if len(q) > 0 {
+		target += "?" + q.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if p.token != "" {
+		req.Header.Set("X-Nomad-Token", p.token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, 0, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var regs []nomadRegistration
+	if err := json.NewDecoder(resp.Body).Decode(&regs); err != nil {
+		return nil, 0, err
+	}
+	next, _ := strconv.ParseUint(resp.Header.Get("X-Nomad-Index"), 10, 64)
+	return regs, next, nil
+}
+
+func (p *NomadProvider) Discover(ctx context.Context) ([]config.Backend, error) {
+	regs, _, err := p.get(ctx, 0)
+	if err != nil {
+		return nil, fmt.Errorf("reading Nomad service %s: %w", p.service, err)
+	}
+	var backends []config.Backend
+	for _, r := range regs {
+		if r.Address == "" || r.Port == 0 {
+			continue
+		}
+		address := net.JoinHostPort(r.Address, strconv.Itoa(r.Port))
+		if slices.ContainsFunc(backends, func(b config.Backend) bool { return b.Address == address }) {
+			continue
+		}
+		backends = append(backends, config.Backend{Address: address, Weight: 100, Zone: r.Datacenter, HealthCheck: p.health})
+	}
+	slices.SortFunc(backends, func(a, b config.Backend) int { return cmp.Compare(a.Address, b.Address) })
+	return backends, nil
+}
+
+// Watch calls changed whenever a blocking query on the service comes back
+// at a new index, and once when the first one succeeds, to catch what
+// happened before. A failed query is tried again until ctx is done.
+func (p *NomadProvider) Watch(ctx context.Context, changed func()) {
+	var index uint64
+	for {
+		_, next, err := p.get(ctx, index)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(p.retry):
+			}
+			continue
+		}
+		if index == 0 || next != index {
+			changed()
+		}
+		// An index going backwards means the catalog was restored, and
+		// one of 0 would make every query return straight away
+		if next < index || next == 0 {
+			next = 1
+		}
+		index = next
+	}
+}
@@ -0,0 +1,90 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+)
+
+// fakeNomad serves /v1/service/api, holding a blocking query until the
+// next index arrives on updates.
+func fakeNomad(t *testing.T, updates chan uint64) *httptest.Server {
+	index := uint64(10)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/service/api" || r.URL.Query().Get("namespace") != "prod" || r.Header.Get("X-Nomad-Token") != "secret" {
+			t.Errorf("query %s %v", r.URL, r.Header)
+		}
+		if r.URL.Query().Get("index") != "" {
+			select {
+			case index = <-updates:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.Header().Set("X-Nomad-Index", strconv.FormatUint(index, 10))
+		fmt.Fprint(w, `[
+			{"ServiceName":"api","Address":"10.0.0.2","Port":25123,"Datacenter":"dc1"},
+			{"ServiceName":"api","Address":"10.0.0.1","Port":8080,"Datacenter":"dc2"},
+			{"ServiceName":"api","Address":"10.0.0.2","Port":25123,"Datacenter":"dc1"},
+			{"ServiceName":"api","Address":"","Port":0}
+		]`)
+	}))
+}
+
+func TestNomadProvider_Discover(t *testing.T) {
+	t.Setenv("NOMAD_TOKEN", "secret")
+	srv := fakeNomad(t, nil)
+	defer srv.Close()
+
+	p := NewNomadProvider(config.DiscoveryConfig{Provider: config.DiscoveryNomad, Service: "api", NomadAddress: srv.URL + "/",
+		Namespace: "prod", Interval: time.Second, HealthCheck: health})
+	backends, err := p.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []config.Backend{
+		{Address: "10.0.0.1:8080", Weight: 100, Zone: "dc2", HealthCheck: health},
+		{Address: "10.0.0.2:25123", Weight: 100, Zone: "dc1", HealthCheck: health},
+	}
+	if !slices.Equal(backends, want) {
+		t.Errorf("got %+v", backends)
+	}
+}
+
+func TestNomadProvider_Watch(t *testing.T) {
+	t.Setenv("NOMAD_TOKEN", "secret")
+	updates := make(chan uint64)
+	srv := fakeNomad(t, updates)
+	defer srv.Close()
+
+	p := NewNomadProvider(config.DiscoveryConfig{Service: "api", NomadAddress: srv.URL, Namespace: "prod", Interval: time.Second})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 10)
+	go p.Watch(ctx, func() { changed <- struct{}{} })
+
+	wait := func(what string) {
+		select {
+		case <-changed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no change for %s", what)
+		}
+	}
+	wait("the first query")
+	// A query timing out at the same index isn't a change
+	updates <- 10
+	updates <- 11
+	wait("a new index")
+	select {
+	case <-changed:
+		t.Error("changed at the same index")
+	default:
+	}
+}
@@ -0,0 +1,95 @@
+package discovery
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"go.uber.org/zap"
+)
+
+// lookupTimeout bounds a single lookup, so a registry that doesn't answer
+// can't hold up the next one.
+const lookupTimeout = 10 * time.Second
+
+// Discoverer looks the backends of a service up on demand; Poll makes one
+// a Provider.
+type Discoverer interface {
+	// Discover returns the backends the service has now, sorted by
+	// address, with the config's health check.
+	Discover(ctx context.Context) ([]config.Backend, error)
+}
+
+// Watcher is a Discoverer that can tell when its backends may have
+// changed, so they're looked up straight away rather than at the next
+// interval.
+type Watcher interface {
+	// Watch calls changed on every change until ctx is done.
+	Watch(ctx context.Context, changed func())
+}
+
+// poller is the Provider Poll returns.
+type poller struct {
+	discoverer Discoverer
+	cfg        config.DiscoveryConfig
+	logger     *zap.Logger
+}
+
+// Poll returns a provider looking d up every cfg.Interval, and whenever
+// it says its backends changed if it's a Watcher, sending the ones found
+// when they differ from the last sent. A lookup that fails is logged, and
+// sends nothing.
+func Poll(d Discoverer, cfg config.DiscoveryConfig, logger *zap.Logger) Provider {
+	return &poller{discoverer: d, cfg: cfg, logger: logger}
+}
+
+func (p *poller) Run(ctx context.Context) (<-chan []config.Backend, error) {
+	updates := make(chan []config.Backend)
+	wake := make(chan struct{}, 1)
+	if w, ok := p.discoverer.(Watcher); ok {
+		go w.Watch(ctx, func() {
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		})
+	}
+	go func() {
+		defer close(updates)
+		var last []config.Backend
+		sent := false
+		for {
+			lookupCtx, cancel := context.WithTimeout(ctx, min(p.cfg.Interval, lookupTimeout))
+			found, err := p.discoverer.Discover(lookupCtx)
+			cancel()
+			switch {
+			case ctx.Err() != nil:
+				return
+			case err != nil:
+				p.logger.Warn("Backend discovery failed, keeping the backends found before",
+					zap.String("provider", p.cfg.Provider),
+					zap.String("target", p.cfg.Target()),
+					zap.Error(err))
+			case !sent || !slices.Equal(found, last):
+				select {
+				case updates <- found:
+					last, sent = found, true
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			timer := time.NewTimer(p.cfg.Interval)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-wake:
+				timer.Stop()
+			case <-timer.C:
+			}
+		}
+	}()
+	return updates, nil
+}
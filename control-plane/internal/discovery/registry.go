@@ -0,0 +1,76 @@
+package discovery
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"go.uber.org/zap"
+)
+
+// Factory makes the provider for a discovery config naming it. It only
+// builds the provider, connecting to nothing until Run, since it's also
+// how a config is checked when it's loaded; an error rejects the config.
+type Factory func(cfg config.DiscoveryConfig, logger *zap.Logger) (Provider, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]Factory{
+		config.DiscoveryDNSSRV:    polled(NewSRVProvider),
+		config.DiscoveryDNS:       polled(NewDNSProvider),
+		config.DiscoveryEtcd:      polledOrError(NewEtcdProvider),
+		config.DiscoveryDocker:    polled(NewDockerProvider),
+		config.DiscoveryAWS:       polled(NewAWSProvider),
+		config.DiscoveryNomad:     polled(NewNomadProvider),
+		config.DiscoveryZooKeeper: polled(NewZooKeeperProvider),
+		config.DiscoveryCloudMap:  polled(NewCloudMapProvider),
+	}
+)
+
+// polled is the factory of a built-in provider looking its backends up
+// on demand.
+func polled[D Discoverer](newDiscoverer func(config.DiscoveryConfig) D) Factory {
+	return func(cfg config.DiscoveryConfig, logger *zap.Logger) (Provider, error) {
+		return Poll(newDiscoverer(cfg), cfg, logger), nil
+	}
+}
+
+// polledOrError is polled for a provider whose constructor can fail,
+// such as on TLS files it can't read.
+func polledOrError[D Discoverer](newDiscoverer func(config.DiscoveryConfig) (D, error)) Factory {
+	return func(cfg config.DiscoveryConfig, logger *zap.Logger) (Provider, error) {
+		d, err := newDiscoverer(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return Poll(d, cfg, logger), nil
+	}
+}
+
+// Register makes name a proxy.discovery.provider, made by factory, so a
+// provider can be added without changing this package; its settings go
+// in proxy.discovery.options. It's meant for init functions, and panics
+// if name is taken.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if _, ok := factories[name]; ok || name == "" {
+		panic(fmt.Sprintf("discovery: provider %q registered twice", name))
+	}
+	factories[name] = factory
+	config.RegisterDiscoveryProvider(name, func(cfg config.DiscoveryConfig) error {
+		_, err := factory(cfg, zap.NewNop())
+		return err
+	})
+}
+
+// NewProvider returns the provider cfg names. cfg is validated.
+func NewProvider(cfg config.DiscoveryConfig, logger *zap.Logger) (Provider, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[cfg.Provider]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no discovery provider %q", cfg.Provider)
+	}
+	return factory(cfg, logger)
+}
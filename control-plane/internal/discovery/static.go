@@ -0,0 +1,61 @@
+package discovery
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"go.uber.org/zap"
+)
+
+// DiscoveryStatic is the provider serving a fixed list of addresses, given
+// as options.backends, comma-separated host:port.
+const DiscoveryStatic = "static"
+
+func init() {
+	Register(DiscoveryStatic, func(cfg config.DiscoveryConfig, _ *zap.Logger) (Provider, error) {
+		var backends []config.Backend
+		for _, address := range strings.Split(cfg.Options["backends"], ",") {
+			address = strings.TrimSpace(address)
+			if address == "" {
+				continue
+			}
+			if host, port, err := net.SplitHostPort(address); err != nil || host == "" || port == "" {
+				return nil, fmt.Errorf("options.backends: %q isn't host:port", address)
+			}
+			backends = append(backends, config.Backend{Address: address, Weight: 100, HealthCheck: cfg.HealthCheck})
+		}
+		if len(backends) == 0 {
+			return nil, errors.New("options.backends is required, comma-separated host:port")
+		}
+		return NewStaticProvider(backends), nil
+	})
+}
+
+// StaticProvider sends the same backends once and never changes them,
+// for backends that are known up front but should come and go with the
+// discovery config rather than the config's own list. It's also the
+// smallest Provider there is, for one of your own to start from.
+type StaticProvider struct {
+	backends []config.Backend
+}
+
+// NewStaticProvider returns a provider serving backends.
+func NewStaticProvider(backends []config.Backend) *StaticProvider {
+	backends = slices.Clone(backends)
+	slices.SortFunc(backends, func(a, b config.Backend) int { return cmp.Compare(a.Address, b.Address) })
+	backends = slices.CompactFunc(backends, func(a, b config.Backend) bool { return a.Address == b.Address })
+	return &StaticProvider{backends: backends}
+}
+
+func (p *StaticProvider) Run(ctx context.Context) (<-chan []config.Backend, error) {
+	updates := make(chan []config.Backend, 1)
+	updates <- p.backends
+	context.AfterFunc(ctx, func() { close(updates) })
+	return updates, nil
+}
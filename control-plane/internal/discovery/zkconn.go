@@ -0,0 +1,355 @@
+package discovery
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ZooKeeper opcodes, special xids, error codes and watch event types, as
+// the jute protocol has them.
+const (
+	zkOpExists      = 3
+	zkOpGetData     = 4
+	zkOpGetChildren = 8
+	zkOpPing        = 11
+	zkOpClose       = -11
+
+	zkXidWatch = -1
+	zkXidPing  = -2
+
+	zkErrNoNode = -101
+
+	zkEventNodeCreated         = 1
+	zkEventNodeDeleted         = 2
+	zkEventNodeChildrenChanged = 4
+)
+
+// zkMaxFrame bounds a response, well above ZooKeeper's own 1 MiB
+// jute.maxbuffer default.
+const zkMaxFrame = 16 << 20
+
+// zkError is a ZooKeeper reply's error code.
+type zkError int32
+
+func (e zkError) Error() string {
+	switch e {
+	case zkErrNoNode:
+		return "zookeeper: node does not exist"
+	case -102:
+		return "zookeeper: not authenticated"
+	case -112:
+		return "zookeeper: session expired"
+	}
+	return fmt.Sprintf("zookeeper: error %d", int32(e))
+}
+
+// zkConn is one session with a ZooKeeper server. Requests are made from a
+// single goroutine, and watch events, which arrive between replies, are
+// handed to onEvent as they're read; only keepAlive runs alongside.
+type zkConn struct {
+	conn net.Conn
+	// timeout is the session timeout the server agreed to: a session
+	// quiet for longer is expired, and a server quiet for longer is gone
+	timeout time.Duration
+	xid     int32
+	onEvent func(typ int32, path string)
+	stop    func() bool
+	// writeMu keeps keepAlive's pings from interleaving with requests
+	writeMu sync.Mutex
+}
+
+// dialZK opens a session on the first of servers that answers. The
+// connection is closed when ctx is done.
+func dialZK(ctx context.Context, servers []string, timeout time.Duration) (*zkConn, error) {
+	var errs []error
+	for _, server := range servers {
+		c, err := connectZK(ctx, server, timeout)
+		if err == nil {
+			return c, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", server, err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+func connectZK(ctx context.Context, server string, timeout time.Duration) (*zkConn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	c := &zkConn{conn: conn, timeout: timeout, onEvent: func(int32, string) {}}
+	c.stop = context.AfterFunc(ctx, func() { conn.Close() })
+
+	// ConnectRequest: protocol version, last zxid seen, session timeout,
+	// session ID and password, all zero for a new session
+	var w zkWriter
+	w.int32(0)
+	w.int64(0)
+	w.int32(int32(timeout.Milliseconds()))
+	w.int64(0)
+	w.bytes(make([]byte, 16))
+	if err := c.write(w); err != nil {
+		c.close()
+		return nil, err
+	}
+	r, err := c.read()
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+	r.int32()
+	negotiated := r.int32()
+	if r.err != nil || negotiated <= 0 {
+		c.close()
+		return nil, errors.New("zookeeper: session refused")
+	}
+	c.timeout = time.Duration(negotiated) * time.Millisecond
+	return c, nil
+}
+
+// close ends the session, so the server drops it straight away rather
+// than at its timeout.
+func (c *zkConn) close() {
+	var w zkWriter
+	w.int32(c.nextXid())
+	w.int32(zkOpClose)
+	c.write(w)
+	c.stop()
+	c.conn.Close()
+}
+
+func (c *zkConn) nextXid() int32 {
+	c.xid++
+	return c.xid
+}
+
+func (c *zkConn) write(w zkWriter) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(w.buf)))
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	_, err := c.conn.Write(append(frame, w.buf...))
+	return err
+}
+
+// read reads a frame. The server answers pings, so one that sends nothing
+// for the session timeout is gone.
+func (c *zkConn) read() (*zkReader, error) {
+	c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	var size [4]byte
+	if _, err := io.ReadFull(c.conn, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > zkMaxFrame {
+		return nil, fmt.Errorf("zookeeper: %d byte response", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(c.conn, buf); err != nil {
+		return nil, err
+	}
+	return &zkReader{buf: buf}, nil
+}
+
+// next reads a reply, dropping ping replies. It returns the reply's xid,
+// and the body after its header or the error the header has; a watch
+// event is handed to onEvent and returned as xid zkXidWatch, no body.
+func (c *zkConn) next() (int32, *zkReader, error) {
+	for {
+		r, err := c.read()
+		if err != nil {
+			return 0, nil, err
+		}
+		xid := r.int32()
+		r.int64()
+		code := r.int32()
+		if r.err != nil {
+			return 0, nil, r.err
+		}
+		switch xid {
+		case zkXidWatch:
+			typ := r.int32()
+			r.int32()
+			path := r.string()
+			if r.err != nil {
+				return 0, nil, r.err
+			}
+			c.onEvent(typ, path)
+			return zkXidWatch, nil, nil
+		case zkXidPing:
+			continue
+		}
+		if code != 0 {
+			return xid, nil, zkError(code)
+		}
+		return xid, r, nil
+	}
+}
+
+// call sends a request and waits for its reply.
+func (c *zkConn) call(op int32, body func(*zkWriter)) (*zkReader, error) {
+	xid := c.nextXid()
+	var w zkWriter
+	w.int32(xid)
+	w.int32(op)
+	body(&w)
+	if err := c.write(w); err != nil {
+		return nil, err
+	}
+	for {
+		// Events, and a reply to an earlier, abandoned request, are
+		// skipped
+		got, r, err := c.next()
+		if got == xid || (err != nil && got == 0) {
+			return r, err
+		}
+	}
+}
+
+// keepAlive pings the server at a third of the session timeout until ctx
+// is done or a ping can't be sent, so a session waiting on a watch isn't
+// expired.
+func (c *zkConn) keepAlive(ctx context.Context) {
+	ticker := time.NewTicker(c.timeout / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var w zkWriter
+		w.int32(zkXidPing)
+		w.int32(zkOpPing)
+		if c.write(w) != nil {
+			return
+		}
+	}
+}
+
+// children returns the names of path's children, setting a watch for
+// their change with watch.
+func (c *zkConn) children(path string, watch bool) ([]string, error) {
+	r, err := c.call(zkOpGetChildren, func(w *zkWriter) {
+		w.string(path)
+		w.bool(watch)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Each name takes at least its four byte length
+	n := r.int32()
+	if n < 0 || int(n) > len(r.buf)/4 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	names := make([]string, n)
+	for i := range names {
+		names[i] = r.string()
+	}
+	return names, r.err
+}
+
+// data returns path's data.
+func (c *zkConn) data(path string) ([]byte, error) {
+	r, err := c.call(zkOpGetData, func(w *zkWriter) {
+		w.string(path)
+		w.bool(false)
+	})
+	if err != nil {
+		return nil, err
+	}
+	data := r.bytes()
+	return data, r.err
+}
+
+// exists sets a watch for path being created, reporting whether it
+// already is.
+func (c *zkConn) exists(path string) (bool, error) {
+	_, err := c.call(zkOpExists, func(w *zkWriter) {
+		w.string(path)
+		w.bool(true)
+	})
+	if errors.Is(err, zkError(zkErrNoNode)) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// zkWriter encodes jute records.
+type zkWriter struct {
+	buf []byte
+}
+
+func (w *zkWriter) int32(v int32) { w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(v)) }
+func (w *zkWriter) int64(v int64) { w.buf = binary.BigEndian.AppendUint64(w.buf, uint64(v)) }
+
+func (w *zkWriter) bytes(b []byte) {
+	w.int32(int32(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *zkWriter) string(s string) { w.bytes([]byte(s)) }
+
+func (w *zkWriter) bool(v bool) {
+	if v {
+		w.buf = append(w.buf, 1)
+	} else {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+// zkReader decodes jute records; the first short read sticks as err.
+type zkReader struct {
+	buf []byte
+	err error
+}
+
+func (r *zkReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.buf) {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *zkReader) int32() int32 {
+	b := r.take(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (r *zkReader) int64() int64 {
+	b := r.take(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+// bytes reads a buffer; a length of -1 is no data.
+func (r *zkReader) bytes() []byte {
+	n := r.int32()
+	if n == -1 {
+		return nil
+	}
+	return r.take(int(n))
+}
+
+func (r *zkReader) string() string { return string(r.bytes()) }
@@ -0,0 +1,188 @@
+package discovery
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+)
+
+// zkSessionTimeout is the session timeout asked for; the server clamps it
+// to between 2 and 20 of its ticks.
+const zkSessionTimeout = 10 * time.Second
+
+// ZooKeeperProvider finds a service's backends in the children of a znode,
+// the ephemeral nodes live instances register and that go with their
+// sessions. A child's data is a Curator service instance, a Finagle
+// serverset member, or host:port, else the child's name is host:port. It
+// speaks ZooKeeper's protocol directly, and watches the children so an
+// instance joining or leaving is looked up straight away.
+type ZooKeeperProvider struct {
+	servers []string
+	path    string
+	health  config.HealthCheckConfig
+	// retry is how long a lost watch session waits before it's opened
+	// again
+	retry time.Duration
+}
+
+// NewZooKeeperProvider returns a provider reading cfg.Path's children from
+// cfg.Servers.
+func NewZooKeeperProvider(cfg config.DiscoveryConfig) *ZooKeeperProvider {
+	return &ZooKeeperProvider{
+		servers: cfg.Servers,
+		path:    cfg.Path,
+		health:  cfg.HealthCheck,
+		retry:   min(cfg.Interval, 5*time.Second),
+	}
+}
+
+// zkInstance is a child's data as Curator's ServiceDiscovery or a Finagle
+// serverset writes it.
+type zkInstance struct {
+	// Curator
+	Address string `json:"address"`
+	Port    *int   `json:"port"`
+	SSLPort *int   `json:"sslPort"`
+	// Finagle
+	ServiceEndpoint *struct {
+		Host string `json:"host"`
+		Port int    `json:"port"`
+	} `json:"serviceEndpoint"`
+	Status string `json:"status"`
+}
+
+// instanceAddress returns the host:port of the instance child name, with
+// data, stands for.
+func instanceAddress(name string, data []byte) (string, bool) {
+	data = bytes.TrimSpace(data)
+	var inst zkInstance
+	if len(data) > 0 && data[0] == '{' {
+		if json.Unmarshal(data, &inst) != nil {
+			return "", false
+		}
+		switch {
+		case inst.ServiceEndpoint != nil:
+			if inst.Status != "" && inst.Status != "ALIVE" {
+				return "", false
+			}
+			return net.JoinHostPort(inst.ServiceEndpoint.Host, strconv.Itoa(inst.ServiceEndpoint.Port)), inst.ServiceEndpoint.Host != ""
+		case inst.Address != "":
+			port := cmp.Or(inst.Port, inst.SSLPort)
+			if port == nil {
+				return "", false
+			}
+			return net.JoinHostPort(inst.Address, strconv.Itoa(*port)), true
+		}
+		return "", false
+	}
+	for _, candidate := range []string{string(data), name} {
+		if host, port, err := net.SplitHostPort(candidate); err == nil && host != "" && port != "" {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+func (p *ZooKeeperProvider) Discover(ctx context.Context) ([]config.Backend, error) {
+	c, err := dialZK(ctx, p.servers, zkSessionTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to ZooKeeper: %w", err)
+	}
+	defer c.close()
+
+	// A missing path, such as a Curator container node removed with its
+	// last child, is no instances
+	names, err := c.children(p.path, false)
+	if errors.Is(err, zkError(zkErrNoNode)) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listing ZooKeeper path %s: %w", p.path, err)
+	}
+	var backends []config.Backend
+	for _, name := range names {
+		data, err := c.data(strings.TrimSuffix(p.path, "/") + "/" + name)
+		if errors.Is(err, zkError(zkErrNoNode)) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading ZooKeeper node %s: %w", name, err)
+		}
+		address, ok := instanceAddress(name, data)
+		if !ok || slices.ContainsFunc(backends, func(b config.Backend) bool { return b.Address == address }) {
+			continue
+		}
+		backends = append(backends, config.Backend{Address: address, Weight: 100, HealthCheck: p.health})
+	}
+	slices.SortFunc(backends, func(a, b config.Backend) int { return cmp.Compare(a.Address, b.Address) })
+	return backends, nil
+}
+
+// Watch calls changed whenever the path's children change, or the path is
+// created or deleted, and once each time a session is opened, to catch
+// what happened while there wasn't one. A lost session is opened again
+// until ctx is done.
+func (p *ZooKeeperProvider) Watch(ctx context.Context, changed func()) {
+	for {
+		p.watch(ctx, changed)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.retry):
+		}
+	}
+}
+
+// watch holds one session, setting the watch again after each event,
+// until the session is lost.
+func (p *ZooKeeperProvider) watch(ctx context.Context, changed func()) {
+	c, err := dialZK(ctx, p.servers, zkSessionTimeout)
+	if err != nil {
+		return
+	}
+	defer c.close()
+	sessionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go c.keepAlive(sessionCtx)
+
+	fired := true
+	c.onEvent = func(typ int32, _ string) {
+		switch typ {
+		case zkEventNodeChildrenChanged, zkEventNodeCreated, zkEventNodeDeleted:
+			fired = true
+		}
+	}
+	for {
+		if fired {
+			fired = false
+			changed()
+			// A watch fires once; a path that isn't there yet is watched
+			// for its creation instead
+			_, err := c.children(p.path, true)
+			if errors.Is(err, zkError(zkErrNoNode)) {
+				// Created since, it's listed again
+				var created bool
+				created, err = c.exists(p.path)
+				fired = fired || created
+			}
+			if err != nil {
+				return
+			}
+			continue
+		}
+		// Nothing but events and ping replies comes between requests
+		if _, _, err := c.next(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+)
+
+// fakeZK is a ZooKeeper server holding one level of znodes under their
+// parents, enough for the requests zkConn makes.
+type fakeZK struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	children map[string][]string
+	data     map[string][]byte
+	// watchers are the connections with a watch on a path's children
+	watchers map[string][]*zkConn
+}
+
+func newFakeZK(t *testing.T) *fakeZK {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := &fakeZK{ln: ln, children: map[string][]string{}, data: map[string][]byte{}, watchers: map[string][]*zkConn{}}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go z.serve(&zkConn{conn: conn, timeout: 5 * time.Second})
+		}
+	}()
+	return z
+}
+
+// set puts children under parent, each child's data its host:port, and
+// fires the watches on parent.
+func (z *fakeZK) set(parent string, children map[string]string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.children[parent] = nil
+	for name, data := range children {
+		z.children[parent] = append(z.children[parent], name)
+		z.data[parent+"/"+name] = []byte(data)
+	}
+	for _, c := range z.watchers[parent] {
+		var w zkWriter
+		w.int32(zkXidWatch)
+		w.int64(0)
+		w.int32(0)
+		w.int32(zkEventNodeChildrenChanged)
+		w.int32(3)
+		w.string(parent)
+		c.write(w)
+	}
+	delete(z.watchers, parent)
+}
+
+func (z *fakeZK) serve(c *zkConn) {
+	defer c.conn.Close()
+	if _, err := c.read(); err != nil {
+		return
+	}
+	var w zkWriter
+	w.int32(0)
+	w.int32(4000)
+	w.int64(1)
+	w.bytes(make([]byte, 16))
+	c.write(w)
+	for {
+		r, err := c.read()
+		if err != nil {
+			return
+		}
+		xid, op := r.int32(), r.int32()
+		path := r.string()
+		watch := r.take(1)
+		var reply zkWriter
+		code := int32(0)
+		z.mu.Lock()
+		children, exists := z.children[path]
+		switch op {
+		case zkOpGetChildren:
+			if !exists {
+				code = zkErrNoNode
+				break
+			}
+			if watch[0] == 1 {
+				z.watchers[path] = append(z.watchers[path], c)
+			}
+			reply.int32(int32(len(children)))
+			for _, name := range children {
+				reply.string(name)
+			}
+		case zkOpGetData:
+			data, ok := z.data[path]
+			if !ok {
+				code = zkErrNoNode
+				break
+			}
+			reply.bytes(data)
+			reply.buf = append(reply.buf, make([]byte, 68)...)
+		case zkOpExists:
+			if !exists {
+				code = zkErrNoNode
+			}
+		case zkOpClose:
+			z.mu.Unlock()
+			return
+		}
+		z.mu.Unlock()
+		var w zkWriter
+		w.int32(xid)
+		w.int64(1)
+		w.int32(code)
+		w.buf = append(w.buf, reply.buf...)
+		c.write(w)
+	}
+}
+
+func TestInstanceAddress(t *testing.T) {
+	for _, tc := range []struct {
+		name, data, want string
+	}{
+		{"f6c5e3a0", `{"name":"api","id":"f6c5e3a0","address":"10.0.0.1","port":8080,"sslPort":null,"serviceType":"DYNAMIC"}`, "10.0.0.1:8080"},
+		{"f6c5e3a1", `{"name":"api","address":"10.0.0.2","port":null,"sslPort":8443}`, "10.0.0.2:8443"},
+		{"member_0000000001", `{"serviceEndpoint":{"host":"10.0.0.3","port":9090},"additionalEndpoints":{},"status":"ALIVE"}`, "10.0.0.3:9090"},
+		{"member_0000000002", `{"serviceEndpoint":{"host":"10.0.0.4","port":9090},"status":"STOPPING"}`, ""},
+		{"instance", "10.0.0.5:80\n", "10.0.0.5:80"},
+		{"10.0.0.6:80", "", "10.0.0.6:80"},
+		{"lock", "", ""},
+	} {
+		got, ok := instanceAddress(tc.name, []byte(tc.data))
+		if ok != (tc.want != "") || got != tc.want {
+			t.Errorf("%s: got %q, %v, want %q", tc.name, got, ok, tc.want)
+		}
+	}
+}
+
+func TestZooKeeperProvider_Discover(t *testing.T) {
+	z := newFakeZK(t)
+	z.set("/services/api", map[string]string{
+		"b": `{"address":"10.0.0.2","port":8080}`,
+		"a": "10.0.0.1:8080",
+		"c": "not an instance",
+	})
+
+	// The first server is down, so the second answers
+	p := NewZooKeeperProvider(config.DiscoveryConfig{Provider: config.DiscoveryZooKeeper, Servers: []string{"127.0.0.1:1", z.ln.Addr().String()},
+		Path: "/services/api", Interval: time.Second, HealthCheck: health})
+	backends, err := p.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []config.Backend{
+		{Address: "10.0.0.1:8080", Weight: 100, HealthCheck: health},
+		{Address: "10.0.0.2:8080", Weight: 100, HealthCheck: health},
+	}
+	if !slices.Equal(backends, want) {
+		t.Errorf("got %+v", backends)
+	}
+
+	p.path = "/services/gone"
+	if backends, err := p.Discover(context.Background()); err != nil || len(backends) != 0 {
+		t.Errorf("missing path: %+v, %v", backends, err)
+	}
+	p.servers = []string{"127.0.0.1:1"}
+	if _, err := p.Discover(context.Background()); err == nil {
+		t.Error("expected an error with no server up")
+	}
+}
+
+func TestZooKeeperProvider_Watch(t *testing.T) {
+	z := newFakeZK(t)
+	z.set("/services/api", nil)
+	p := NewZooKeeperProvider(config.DiscoveryConfig{Servers: []string{z.ln.Addr().String()}, Path: "/services/api", Interval: time.Second})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 10)
+	go p.Watch(ctx, func() { changed <- struct{}{} })
+
+	wait := func(what string) {
+		select {
+		case <-changed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no change for %s", what)
+		}
+	}
+	wait("the session opening")
+	// The watch is set once the session's first listing is answered
+	for i := 0; ; i++ {
+		z.mu.Lock()
+		watched := len(z.watchers["/services/api"]) > 0
+		z.mu.Unlock()
+		if watched {
+			break
+		}
+		if i == 100 {
+			t.Fatal("no watch set")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	z.set("/services/api", map[string]string{"a": "10.0.0.1:8080"})
+	wait("a child joining")
+}
@@ -0,0 +1,238 @@
+// Package etcd talks to an etcd v3 cluster through the JSON gateway every
+// member serves on its client port, so the control plane needs no etcd
+// client library. The state store, leader election and the etcd discovery
+// provider share it.
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+)
+
+// Client sends gateway calls to the first of a cluster's members that
+// answers, authenticating first when it has a username.
+type Client struct {
+	endpoints []string
+	username  string
+	password  string
+	http      *http.Client
+
+	mu sync.Mutex
+	// token is what the last authentication returned, sent with every
+	// call until a member refuses it
+	token string
+}
+
+// KV is a key-value pair as the gateway returns it, its bytes base64.
+type KV struct {
+	Key         []byte `json:"key"`
+	Value       []byte `json:"value"`
+	ModRevision string `json:"mod_revision"`
+}
+
+// New returns a client for the cluster cfg names, reading its TLS files
+// now.
+func New(cfg config.EtcdConfig) (*Client, error) {
+	c := &Client{endpoints: cfg.Endpoints, username: cfg.Username, password: cfg.Password, http: &http.Client{}}
+	if cfg.CAFile == "" && cfg.CertFile == "" {
+		return c, nil
+	}
+	tlsCfg := &tls.Config{}
+	if cfg.CAFile != "" {
+		ca, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading the etcd CA: %w", err)
+		}
+		tlsCfg.RootCAs = x509.NewCertPool()
+		if !tlsCfg.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates in the etcd CA %s", cfg.CAFile)
+		}
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading the etcd client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+	c.http.Transport = transport
+	return c, nil
+}
+
+// Encode is s as the gateway takes bytes, base64.
+func Encode(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+// RangeEnd is the end of the key range covering every key with prefix, as
+// etcd's clientv3.GetPrefixRangeEnd works it out.
+func RangeEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	// Every byte is 0xff: the range runs to the end of the keyspace
+	return "\x00"
+}
+
+// PrefixRange is the body of /v3/kv/range, or a watch's create_request,
+// covering every key with prefix.
+func PrefixRange(prefix string) map[string]string {
+	return map[string]string{"key": Encode(prefix), "range_end": Encode(RangeEnd(prefix))}
+}
+
+// Get returns the pair under key, or nil if there's none.
+func (c *Client) Get(ctx context.Context, key string) (*KV, error) {
+	var result struct {
+		Kvs []KV `json:"kvs"`
+	}
+	if err := c.Post(ctx, "/v3/kv/range", map[string]string{"key": Encode(key)}, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Kvs) == 0 {
+		return nil, nil
+	}
+	return &result.Kvs[0], nil
+}
+
+// List returns the pairs under every key with prefix.
+func (c *Client) List(ctx context.Context, prefix string) ([]KV, error) {
+	var result struct {
+		Kvs []KV `json:"kvs"`
+	}
+	if err := c.Post(ctx, "/v3/kv/range", PrefixRange(prefix), &result); err != nil {
+		return nil, err
+	}
+	return result.Kvs, nil
+}
+
+// Post sends body to path, decoding the response into out.
+func (c *Client) Post(ctx context.Context, path string, body, out any) error {
+	resp, err := c.Stream(ctx, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+	return json.NewDecoder(resp).Decode(out)
+}
+
+// Stream sends body to path, returning the response to read as it comes,
+// as a watch's is. The caller closes it.
+func (c *Client) Stream(ctx context.Context, path string, body any) (io.ReadCloser, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, endpoint := range c.endpoints {
+		endpoint = strings.TrimSuffix(endpoint, "/")
+		resp, err := c.send(ctx, endpoint, path, data)
+		if err == nil {
+			return resp.Body, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", endpoint, err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// send posts data to path on endpoint, authenticating again once if the
+// token is refused: simple tokens are the member's that issued them, and
+// expire.
+func (c *Client) send(ctx context.Context, endpoint, path string, data []byte) (*http.Response, error) {
+	for retried := false; ; retried = true {
+		token, err := c.authToken(ctx, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.do(ctx, endpoint+path, token, data)
+		if err == nil || retried || c.username == "" || !errors.Is(err, errUnauthenticated) {
+			return resp, err
+		}
+		c.mu.Lock()
+		if c.token == token {
+			c.token = ""
+		}
+		c.mu.Unlock()
+	}
+}
+
+// authToken returns the token to send, authenticating with endpoint if
+// there's a username and no token yet.
+func (c *Client) authToken(ctx context.Context, endpoint string) (string, error) {
+	if c.username == "" {
+		return "", nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" {
+		return c.token, nil
+	}
+	data, err := json.Marshal(map[string]string{"name": c.username, "password": c.password})
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.do(ctx, endpoint+"/v3/auth/authenticate", "", data)
+	if err != nil {
+		return "", fmt.Errorf("authenticating as %s: %w", c.username, err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("authenticating as %s: %w", c.username, err)
+	}
+	c.token = result.Token
+	return c.token, nil
+}
+
+// errUnauthenticated is a member refusing a call's token, or its lack of
+// one.
+var errUnauthenticated = errors.New("unauthenticated")
+
+// do posts data to url, returning the response if it's a 200.
+func (c *Client) do(ctx context.Context, url, token string, data []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err := fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+		if resp.StatusCode == http.StatusUnauthorized {
+			err = fmt.Errorf("%w: %w", errUnauthenticated, err)
+		}
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,101 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+)
+
+func TestRangeEnd(t *testing.T) {
+	for prefix, want := range map[string]string{"/services/api/": "/services/api0", "a\xff": "b", "\xff": "\x00"} {
+		if got := RangeEnd(prefix); got != want {
+			t.Errorf("RangeEnd(%q) = %q, want %q", prefix, got, want)
+		}
+	}
+}
+
+// fakeMember serves /v3/kv/range with one pair to callers with the token
+// it issued last for aegis:secret, as every member issuing its own simple
+// tokens does.
+func fakeMember(t *testing.T, name string, auths *atomic.Int32) *httptest.Server {
+	var issued atomic.Value
+	issued.Store("")
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v3/auth/authenticate":
+			var req struct{ Name, Password string }
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.Name != "aegis" || req.Password != "secret" {
+				http.Error(w, `{"error":"etcdserver: authentication failed, invalid user ID or password"}`, http.StatusBadRequest)
+				return
+			}
+			token := fmt.Sprintf("%s.%d", name, auths.Add(1))
+			issued.Store(token)
+			json.NewEncoder(w).Encode(map[string]string{"token": token})
+		case "/v3/kv/range":
+			if r.Header.Get("Authorization") != issued.Load() {
+				http.Error(w, `{"error":"etcdserver: invalid auth token"}`, http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{"kvs": []KV{{Key: []byte("/a"), Value: []byte(name), ModRevision: "7"}}})
+		default:
+			t.Errorf("%s %s", r.Method, r.URL.Path)
+		}
+	}))
+}
+
+func TestClient_FailsOverAndAuthenticatesAgain(t *testing.T) {
+	var auths atomic.Int32
+	first, second := fakeMember(t, "first", &auths), fakeMember(t, "second", &auths)
+	defer second.Close()
+	c, err := New(config.EtcdConfig{Endpoints: []string{first.URL, second.URL + "/"}, Username: "aegis", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	kv, err := c.Get(ctx, "/a")
+	if err != nil || string(kv.Value) != "first" || kv.ModRevision != "7" {
+		t.Fatalf("get: %+v, %v", kv, err)
+	}
+	// The member that issued the token goes away; the next one refuses
+	// it and issues its own
+	first.Close()
+	kv, err = c.Get(ctx, "/a")
+	if err != nil || string(kv.Value) != "second" {
+		t.Fatalf("get after failover: %+v, %v", kv, err)
+	}
+	if got := auths.Load(); got != 2 {
+		t.Errorf("got %d authentications, want 2", got)
+	}
+
+	c.password = "wrong"
+	c.token = ""
+	if _, err := c.Get(ctx, "/a"); err == nil || !strings.Contains(err.Error(), "authenticating as aegis") {
+		t.Errorf("wrong password: got %v", err)
+	}
+}
+
+func TestNew_TLSFiles(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := New(config.EtcdConfig{CAFile: filepath.Join(dir, "missing.pem")}); err == nil {
+		t.Error("expected an error for a missing CA file")
+	}
+	notPEM := filepath.Join(dir, "ca.pem")
+	os.WriteFile(notPEM, []byte("not a certificate"), 0o600)
+	if _, err := New(config.EtcdConfig{CAFile: notPEM}); err == nil || !strings.Contains(err.Error(), "no certificates") {
+		t.Errorf("CA file without certificates: got %v", err)
+	}
+	if _, err := New(config.EtcdConfig{CertFile: notPEM, KeyFile: notPEM}); err == nil {
+		t.Error("expected an error for a client certificate that isn't one")
+	}
+}
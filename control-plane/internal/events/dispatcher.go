@@ -0,0 +1,114 @@
+package events
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// webhookQueue is the number of events buffered per webhook. Webhook
+// delivery is best effort: a receiver that can't keep up loses events
+// (counted in aegis_event_webhook_dropped_total) rather than stalling the
+// stream that also feeds metrics and logs.
+const webhookQueue = 256
+
+var (
+	eventsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aegis_dataplane_events_total",
+		Help: "Structured events received from the data plane",
+	}, []string{"type", "backend"})
+	webhookDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aegis_event_webhook_dropped_total",
+		Help: "Events not delivered to a webhook because its queue was full",
+	}, []string{"url"})
+	webhookErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aegis_event_webhook_errors_total",
+		Help: "Failed webhook deliveries",
+	}, []string{"url"})
+)
+
+// TypeName renders an event type the way it appears in config, metric
+// labels and webhook payloads: CIRCUIT_OPENED becomes "circuit_opened".
+func TypeName(t pb.EventType) string {
+	return strings.ToLower(t.String())
+}
+
+// Dispatcher turns events streamed from the data plane into metrics, log
+// lines and webhook notifications.
+type Dispatcher struct {
+	logger   *zap.Logger
+	webhooks []*webhook
+	wg       sync.WaitGroup
+}
+
+// NewDispatcher starts one delivery goroutine per configured webhook.
+func NewDispatcher(cfg config.EventsConfig, logger *zap.Logger) *Dispatcher {
+	d := &Dispatcher{logger: logger}
+	for _, wc := range cfg.Webhooks {
+		wh := newWebhook(wc)
+		d.webhooks = append(d.webhooks, wh)
+		d.wg.Add(1)
+		go d.deliver(wh)
+	}
+	return d
+}
+
+// Handle records ev and queues it for every webhook subscribed to its type.
+func (d *Dispatcher) Handle(ev *pb.ProxyEvent) {
+	name := TypeName(ev.Type)
+	eventsReceived.WithLabelValues(name, ev.Backend).Inc()
+
+	fields := []zap.Field{
+		zap.String("type", name),
+		zap.String("backend", ev.Backend),
+		zap.Time("timestamp", time.UnixMilli(ev.Timestamp)),
+	}
+	for k, v := range ev.Attributes {
+		fields = append(fields, zap.String(k, v))
+	}
+	switch ev.Type {
+	case pb.EventType_CIRCUIT_OPENED, pb.EventType_RATE_LIMIT_EXCEEDED, pb.EventType_CONNECTION_RESET:
+		d.logger.Warn("Data plane event: "+ev.Message, fields...)
+	default:
+		d.logger.Info("Data plane event: "+ev.Message, fields...)
+	}
+
+	for _, wh := range d.webhooks {
+		if !wh.wants(name) {
+			continue
+		}
+		select {
+		case wh.queue <- ev:
+		default:
+			webhookDropped.WithLabelValues(wh.url).Inc()
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(wh *webhook) {
+	defer d.wg.Done()
+	for ev := range wh.queue {
+		if err := wh.post(ev); err != nil {
+			webhookErrors.WithLabelValues(wh.url).Inc()
+			d.logger.Warn("Event webhook delivery failed",
+				zap.String("url", wh.url),
+				zap.String("type", TypeName(ev.Type)),
+				zap.Error(err))
+		}
+	}
+}
+
+// Close waits for queued webhook deliveries to finish. Handle must not be
+// called after Close.
+func (d *Dispatcher) Close() {
+	for _, wh := range d.webhooks {
+		close(wh.queue)
+	}
+	d.wg.Wait()
+}
@@ -0,0 +1,77 @@
+package events
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+func TestDispatcher_CountsAndPostsFilteredEvents(t *testing.T) {
+	var mu sync.Mutex
+	var got []payload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p payload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("decode webhook body: %v", err)
+		}
+		mu.Lock()
+		got = append(got, p)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	d := NewDispatcher(config.EventsConfig{Webhooks: []config.WebhookConfig{
+		{URL: srv.URL, Types: []string{"circuit_opened"}, Timeout: time.Second},
+	}}, zap.NewNop())
+
+	d.Handle(&pb.ProxyEvent{
+		Timestamp:  1700000000000,
+		Type:       pb.EventType_CIRCUIT_OPENED,
+		Backend:    "dispatcher-test:1",
+		Message:    "circuit breaker Closed -> Open",
+		Attributes: map[string]string{"from": "Closed", "to": "Open"},
+	})
+	d.Handle(&pb.ProxyEvent{Type: pb.EventType_RATE_LIMIT_EXCEEDED, Attributes: map[string]string{"count": "12"}})
+	d.Close()
+
+	if n := testutil.ToFloat64(eventsReceived.WithLabelValues("circuit_opened", "dispatcher-test:1")); n != 1 {
+		t.Errorf("circuit_opened counter: got %v, want 1", n)
+	}
+	if n := testutil.ToFloat64(eventsReceived.WithLabelValues("rate_limit_exceeded", "")); n < 1 {
+		t.Errorf("rate_limit_exceeded counter: got %v, want >= 1", n)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 1 {
+		t.Fatalf("webhook deliveries: got %d, want 1 (rate limit event filtered out)", len(got))
+	}
+	if got[0].Type != "circuit_opened" || got[0].Backend != "dispatcher-test:1" || got[0].Attributes["to"] != "Open" {
+		t.Errorf("webhook payload: got %+v", got[0])
+	}
+}
+
+func TestDispatcher_CountsFailedDeliveries(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	d := NewDispatcher(config.EventsConfig{Webhooks: []config.WebhookConfig{
+		{URL: srv.URL, Timeout: time.Second},
+	}}, zap.NewNop())
+	d.Handle(&pb.ProxyEvent{Type: pb.EventType_CONNECTION_RESET, Backend: "dispatcher-test:2"})
+	d.Close()
+
+	if n := testutil.ToFloat64(webhookErrors.WithLabelValues(srv.URL)); n != 1 {
+		t.Errorf("webhook errors: got %v, want 1", n)
+	}
+}
@@ -0,0 +1,143 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// feedHistory is how many control plane events the feed keeps for clients
+// resuming after a disconnect.
+const feedHistory = 1000
+
+// subscriberQueue is how far a subscriber may fall behind before it's cut
+// off; it resumes from history by reconnecting with the last ID it saw.
+const subscriberQueue = 64
+
+// Control plane event types, as they appear on GET /events.
+const (
+	TypeBackendHealthChanged  = "backend_health_changed"
+	TypePriorityFailover      = "priority_failover"
+	TypeConfigApplied         = "config_applied"
+	TypeBackendsChanged       = "backends_changed"
+	TypeRateLimitChanged      = "rate_limit_changed"
+	TypeTrafficSplitChanged   = "traffic_split_changed"
+	TypePoolSwitched          = "pool_switched"
+	TypeMirrorChanged         = "mirror_changed"
+	TypeHeaderRulesChanged    = "header_rules_changed"
+	TypeDarkLaunchesChanged   = "dark_launches_changed"
+	TypeDarkLaunchStarted     = "dark_launch_started"
+	TypeDarkLaunchEnded       = "dark_launch_ended"
+	TypeScheduleApplied       = "schedule_applied"
+	TypeScheduleReverted      = "schedule_reverted"
+	TypeCanaryStarted         = "canary_started"
+	TypeCanaryPromoted        = "canary_promoted"
+	TypeCanaryCompleted       = "canary_completed"
+	TypeCanaryRolledBack      = "canary_rolled_back"
+	TypeSlowStartStarted      = "slow_start_started"
+	TypeSlowStartCompleted    = "slow_start_completed"
+	TypeDrainStarted          = "drain_started"
+	TypeDrainFinished         = "drain_finished"
+	TypeDataPlaneConnected    = "data_plane_connected"
+	TypeDataPlaneDisconnected = "data_plane_disconnected"
+)
+
+// Event is something that happened in the control plane. IDs increase by
+// one per event and restart from 1 when the control plane does.
+type Event struct {
+	ID         uint64            `json:"id"`
+	Type       string            `json:"type"`
+	Time       time.Time         `json:"time"`
+	Message    string            `json:"message"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Feed fans control plane events out to API subscribers and keeps a short
+// history so they can resume where they left off. A nil *Feed discards
+// everything published to it, so components can publish unconditionally.
+type Feed struct {
+	mu      sync.Mutex
+	lastID  uint64
+	history []Event
+	subs    map[chan Event]struct{}
+}
+
+func NewFeed() *Feed {
+	return &Feed{subs: make(map[chan Event]struct{})}
+}
+
+// Publish records an event and hands it to every subscriber.
+func (f *Feed) Publish(typ, message string, attrs map[string]string) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.lastID++
+	ev := Event{ID: f.lastID, Type: typ, Time: time.Now().UTC(), Message: message, Attributes: attrs}
+	if len(f.history) == feedHistory {
+		f.history = append(f.history[:0], f.history[1:]...)
+	}
+	f.history = append(f.history, ev)
+
+	for ch := range f.subs {
+		select {
+		case ch <- ev:
+		default:
+			delete(f.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscription is a subscriber's view of the feed.
+type Subscription struct {
+	// Backlog holds the events after the requested ID still in history.
+	Backlog []Event
+	// Missed is set when some events after the requested ID are no longer
+	// in history, or the ID is from before a restart.
+	Missed bool
+	// Events delivers everything published after Backlog. It's closed if
+	// the subscriber falls too far behind.
+	Events <-chan Event
+
+	feed *Feed
+	ch   chan Event
+}
+
+// Subscribe starts a subscription after event afterID; 0 means only new
+// events.
+func (f *Feed) Subscribe(afterID uint64) *Subscription {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ch := make(chan Event, subscriberQueue)
+	f.subs[ch] = struct{}{}
+	sub := &Subscription{Events: ch, feed: f, ch: ch}
+	if afterID == 0 {
+		return sub
+	}
+	if afterID > f.lastID {
+		sub.Missed = true
+		sub.Backlog = append([]Event(nil), f.history...)
+		return sub
+	}
+	for i, ev := range f.history {
+		if ev.ID > afterID {
+			sub.Missed = i == 0 && ev.ID > afterID+1
+			sub.Backlog = append([]Event(nil), f.history[i:]...)
+			break
+		}
+	}
+	return sub
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+	if _, ok := s.feed.subs[s.ch]; ok {
+		delete(s.feed.subs, s.ch)
+		close(s.ch)
+	}
+}
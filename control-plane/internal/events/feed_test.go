@@ -0,0 +1,58 @@
+package events
+
+import "testing"
+
+func TestFeed_ResumesFromHistory(t *testing.T) {
+	f := NewFeed()
+	for i := 0; i < 3; i++ {
+		f.Publish(TypeConfigApplied, "applied", nil)
+	}
+
+	sub := f.Subscribe(1)
+	defer sub.Close()
+	if sub.Missed || len(sub.Backlog) != 2 || sub.Backlog[0].ID != 2 {
+		t.Fatalf("resume after 1: missed %v, backlog %+v", sub.Missed, sub.Backlog)
+	}
+
+	f.Publish(TypeDrainStarted, "drain", map[string]string{"operation": "op-1"})
+	if ev := <-sub.Events; ev.ID != 4 || ev.Type != TypeDrainStarted || ev.Attributes["operation"] != "op-1" {
+		t.Errorf("live event: got %+v", ev)
+	}
+
+	if s := f.Subscribe(99); !s.Missed || len(s.Backlog) != 4 {
+		t.Errorf("ID from before a restart: missed %v, %d backlog events", s.Missed, len(s.Backlog))
+	}
+}
+
+func TestFeed_ReportsEventsDroppedFromHistory(t *testing.T) {
+	f := NewFeed()
+	for i := 0; i < feedHistory+10; i++ {
+		f.Publish(TypeConfigApplied, "applied", nil)
+	}
+	sub := f.Subscribe(5)
+	defer sub.Close()
+	if !sub.Missed || len(sub.Backlog) != feedHistory || sub.Backlog[0].ID != 11 {
+		t.Errorf("got missed %v, %d events starting at %d", sub.Missed, len(sub.Backlog), sub.Backlog[0].ID)
+	}
+}
+
+func TestFeed_CutsOffSlowSubscriber(t *testing.T) {
+	f := NewFeed()
+	sub := f.Subscribe(0)
+	for i := 0; i < subscriberQueue+1; i++ {
+		f.Publish(TypeConfigApplied, "applied", nil)
+	}
+	n := 0
+	for range sub.Events {
+		n++
+	}
+	if n != subscriberQueue {
+		t.Errorf("got %d events before the channel closed, want %d", n, subscriberQueue)
+	}
+	sub.Close() // already cut off; must not panic
+}
+
+func TestFeed_NilDiscards(t *testing.T) {
+	var f *Feed
+	f.Publish(TypeConfigApplied, "applied", nil)
+}
@@ -0,0 +1,71 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+)
+
+// payload is the JSON body POSTed to webhooks.
+type payload struct {
+	Timestamp  time.Time         `json:"timestamp"`
+	Type       string            `json:"type"`
+	Backend    string            `json:"backend,omitempty"`
+	Message    string            `json:"message"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+type webhook struct {
+	url    string
+	types  map[string]bool
+	client *http.Client
+	queue  chan *pb.ProxyEvent
+}
+
+func newWebhook(cfg config.WebhookConfig) *webhook {
+	var types map[string]bool
+	if len(cfg.Types) > 0 {
+		types = make(map[string]bool, len(cfg.Types))
+		for _, t := range cfg.Types {
+			types[t] = true
+		}
+	}
+	return &webhook{
+		url:    cfg.URL,
+		types:  types,
+		client: &http.Client{Timeout: cfg.Timeout},
+		queue:  make(chan *pb.ProxyEvent, webhookQueue),
+	}
+}
+
+func (w *webhook) wants(typeName string) bool {
+	return w.types == nil || w.types[typeName]
+}
+
+func (w *webhook) post(ev *pb.ProxyEvent) error {
+	body, err := json.Marshal(payload{
+		Timestamp:  time.UnixMilli(ev.Timestamp).UTC(),
+		Type:       TypeName(ev.Type),
+		Backend:    ev.Backend,
+		Message:    ev.Message,
+		Attributes: ev.Attributes,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to post event: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,470 @@
+// Package geoip reads MaxMind DB files (.mmdb), the format of the GeoLite2
+// and GeoIP2 Country and City databases, to place client networks in
+// countries and continents.
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/netip"
+	"os"
+)
+
+// metadataMarker starts the metadata section at the end of the file.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// dataSeparator is the run of zero bytes between the search tree and the
+// data section.
+const dataSeparator = 16
+
+// maxDepth bounds the nesting of decoded values, so a corrupt file can't
+// recurse without end.
+const maxDepth = 32
+
+// Data section types
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// DB is a MaxMind DB loaded into memory.
+type DB struct {
+	// Type is the database_type in the file's metadata, e.g.
+	// GeoLite2-Country.
+	Type string
+	// BuildEpoch is when the database was built, in Unix seconds.
+	BuildEpoch uint64
+
+	tree       []byte
+	data       []byte
+	nodeCount  uint32
+	recordSize int
+	ipVersion  int
+	// ipv4Start is the node IPv4 addresses start at in an IPv6 tree,
+	// after 96 zero bits; the root in an IPv4 one
+	ipv4Start uint32
+}
+
+// Location is where the database places a network.
+type Location struct {
+	// Country is an ISO 3166-1 alpha-2 code, e.g. DE; the registered
+	// country's for networks without a country of their own.
+	Country string
+	// Continent is a two-letter code: AF, AN, AS, EU, NA, OC or SA.
+	Continent string
+}
+
+// Open reads the database at path.
+func Open(path string) (*DB, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return New(b)
+}
+
+// New parses a database read into b, which it keeps.
+func New(b []byte) (*DB, error) {
+	marker := bytes.LastIndex(b, metadataMarker)
+	if marker < 0 {
+		return nil, errors.New("not a MaxMind DB: no metadata")
+	}
+	meta, _, err := decoder{buf: b[marker+len(metadataMarker):]}.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("bad metadata: %w", err)
+	}
+	m, ok := meta.(map[string]any)
+	if !ok {
+		return nil, errors.New("bad metadata: not a map")
+	}
+
+	db := &DB{}
+	db.Type, _ = m["database_type"].(string)
+	db.BuildEpoch, _ = m["build_epoch"].(uint64)
+	nodeCount, _ := m["node_count"].(uint64)
+	recordSize, _ := m["record_size"].(uint64)
+	ipVersion, _ := m["ip_version"].(uint64)
+	if major, _ := m["binary_format_major_version"].(uint64); major != 2 {
+		return nil, fmt.Errorf("unsupported binary format version %d", major)
+	}
+	if recordSize != 24 && recordSize != 28 && recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", recordSize)
+	}
+	if ipVersion != 4 && ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", ipVersion)
+	}
+	treeSize := nodeCount * recordSize / 4
+	if nodeCount == 0 || nodeCount > math.MaxUint32 || treeSize+dataSeparator > uint64(marker) {
+		return nil, fmt.Errorf("search tree of %d nodes doesn't fit the file", nodeCount)
+	}
+	db.nodeCount, db.recordSize, db.ipVersion = uint32(nodeCount), int(recordSize), int(ipVersion)
+	db.tree = b[:treeSize]
+	db.data = b[treeSize+dataSeparator : marker]
+
+	if db.ipVersion == 6 {
+		node := uint32(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node, _ = db.children(node)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+// Lookup returns the location of ip, and false when the database doesn't
+// have it. IPv4-mapped IPv6 addresses are looked up as IPv4.
+func (db *DB) Lookup(ip netip.Addr) (Location, bool, error) {
+	ip = ip.Unmap()
+	if ip.Is6() && db.ipVersion == 4 {
+		return Location{}, false, nil
+	}
+	record, addr := uint32(0), ip.As16()
+	start := 0
+	if ip.Is4() {
+		record, start = db.ipv4Start, 96
+	}
+	for i := start; i < 128 && record < db.nodeCount; i++ {
+		left, right := db.children(record)
+		if addr[i/8]&(0x80>>(i%8)) == 0 {
+			record = left
+		} else {
+			record = right
+		}
+	}
+	if record <= db.nodeCount {
+		return Location{}, false, nil
+	}
+	loc, err := db.location(record)
+	return loc, err == nil, err
+}
+
+// Partition splits the networks the database has between classes 0 to
+// n-1 by what class returns for their location, -1 for none: the result
+// has each class's networks, in address order and merged into as few
+// prefixes as the tree allows. IPv4 networks in an IPv6 database are
+// returned as IPv4 once, whichever of the tree's IPv4 aliases (::/96,
+// ::ffff:0:0/96, 2002::/16) they're under.
+func (db *DB) Partition(n int, class func(Location) int) ([][]netip.Prefix, error) {
+	w := &walker{db: db, class: class, cache: make(map[uint32]int), out: make([][]netip.Prefix, n)}
+	if err := w.walkRoot(db.ipv4Start, 32); err != nil {
+		return nil, err
+	}
+	if db.ipVersion == 6 {
+		w.skip = true
+		if err := w.walkRoot(0, 128); err != nil {
+			return nil, err
+		}
+	}
+	return w.out, nil
+}
+
+// children returns the left (0 bit) and right (1 bit) records of node.
+func (db *DB) children(node uint32) (uint32, uint32) {
+	switch db.recordSize {
+	case 24:
+		b := db.tree[node*6:]
+		return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2]), uint32(b[3])<<16 | uint32(b[4])<<8 | uint32(b[5])
+	case 28:
+		b := db.tree[node*7:]
+		left := uint32(b[3]&0xf0)<<20 | uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+		right := uint32(b[3]&0x0f)<<24 | uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6])
+		return left, right
+	default:
+		b := db.tree[node*8:]
+		return binary.BigEndian.Uint32(b), binary.BigEndian.Uint32(b[4:])
+	}
+}
+
+// location decodes the data record a search tree record points to.
+func (db *DB) location(record uint32) (Location, error) {
+	offset := record - db.nodeCount - dataSeparator
+	v, _, err := decoder{buf: db.data}.decode(offset, 0)
+	if err != nil {
+		return Location{}, fmt.Errorf("bad data record at %d: %w", offset, err)
+	}
+	m, _ := v.(map[string]any)
+	code := func(key, field string) string {
+		sub, _ := m[key].(map[string]any)
+		s, _ := sub[field].(string)
+		return s
+	}
+	loc := Location{Country: code("country", "iso_code"), Continent: code("continent", "code")}
+	if loc.Country == "" {
+		loc.Country = code("registered_country", "iso_code")
+	}
+	return loc, nil
+}
+
+// mixed is the class of a subtree whose networks aren't all in one.
+const mixed = -2
+
+type walker struct {
+	db    *DB
+	class func(Location) int
+	// cache holds the class of each data record seen, which many
+	// networks share
+	cache map[uint32]int
+	out   [][]netip.Prefix
+	// skip leaves out the IPv4 subtree, walked on its own
+	skip bool
+	bits int
+}
+
+func (w *walker) walkRoot(record uint32, bits int) error {
+	w.bits = bits
+	var addr [16]byte
+	c, err := w.subtree(record, addr, 0)
+	if err != nil {
+		return err
+	}
+	w.emit(c, addr, 0)
+	return nil
+}
+
+// subtree returns the class of every address under record, which covers
+// the depth-bit prefix of addr, or mixed once it has emitted the parts
+// that are in one class.
+func (w *walker) subtree(record uint32, addr [16]byte, depth int) (int, error) {
+	switch {
+	case record == w.db.nodeCount:
+		return -1, nil
+	case record > w.db.nodeCount:
+		return w.classOf(record)
+	case w.skip && record == w.db.ipv4Start:
+		return -1, nil
+	case depth >= w.bits:
+		return 0, errors.New("search tree is deeper than an address")
+	}
+	left, right := w.db.children(record)
+	hi := addr
+	hi[depth/8] |= 0x80 >> (depth % 8)
+	lc, err := w.subtree(left, addr, depth+1)
+	if err != nil {
+		return 0, err
+	}
+	rc, err := w.subtree(right, hi, depth+1)
+	if err != nil {
+		return 0, err
+	}
+	if lc == rc && lc != mixed {
+		return lc, nil
+	}
+	w.emit(lc, addr, depth+1)
+	w.emit(rc, hi, depth+1)
+	return mixed, nil
+}
+
+func (w *walker) classOf(record uint32) (int, error) {
+	if c, ok := w.cache[record]; ok {
+		return c, nil
+	}
+	loc, err := w.db.location(record)
+	if err != nil {
+		return 0, err
+	}
+	c := w.class(loc)
+	if c < -1 || c >= len(w.out) {
+		c = -1
+	}
+	w.cache[record] = c
+	return c, nil
+}
+
+func (w *walker) emit(class int, addr [16]byte, bits int) {
+	if class < 0 {
+		return
+	}
+	var ip netip.Addr
+	if w.bits == 32 {
+		ip = netip.AddrFrom4([4]byte(addr[:4]))
+	} else {
+		ip = netip.AddrFrom16(addr)
+	}
+	w.out[class] = append(w.out[class], netip.PrefixFrom(ip, bits))
+}
+
+// decoder reads values from a data section, or from the metadata, whose
+// pointers are relative to buf.
+type decoder struct {
+	buf []byte
+}
+
+// decode returns the value at offset and the offset after it.
+func (d decoder) decode(offset uint32, depth int) (any, uint32, error) {
+	if depth > maxDepth {
+		return nil, 0, errors.New("values nested too deep")
+	}
+	ctrl, offset, err := d.byte(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	typ := int(ctrl >> 5)
+	if typ == typePointer {
+		target, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		v, _, err := d.decode(target, depth+1)
+		return v, next, err
+	}
+	if typ == typeExtended {
+		var ext byte
+		if ext, offset, err = d.byte(offset); err != nil {
+			return nil, 0, err
+		}
+		typ = 7 + int(ext)
+	}
+	size, offset, err := d.size(ctrl, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]any, min(size, 64))
+		for range size {
+			var k, v any
+			if k, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errors.New("map key isn't a string")
+			}
+			if v, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]any, 0, min(size, 64))
+		for range size {
+			var v any
+			if v, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	b, next, err := d.bytes(offset, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch typ {
+	case typeString:
+		return string(b), next, nil
+	case typeBytes:
+		return bytes.Clone(b), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("double of %d bytes", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("float of %d bytes", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > map[int]int{typeUint16: 2, typeUint32: 4, typeUint64: 8}[typ] {
+			return nil, 0, fmt.Errorf("unsigned integer of %d bytes", size)
+		}
+		var u uint64
+		for _, c := range b {
+			u = u<<8 | uint64(c)
+		}
+		return u, next, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("int32 of %d bytes", size)
+		}
+		var u uint32
+		for _, c := range b {
+			u = u<<8 | uint32(c)
+		}
+		if size == 4 {
+			return int64(int32(u)), next, nil
+		}
+		return int64(u), next, nil
+	case typeUint128:
+		if size > 16 {
+			return nil, 0, fmt.Errorf("uint128 of %d bytes", size)
+		}
+		return new(big.Int).SetBytes(b), next, nil
+	}
+	return nil, 0, fmt.Errorf("unexpected data type %d", typ)
+}
+
+func (d decoder) byte(offset uint32) (byte, uint32, error) {
+	if int(offset) >= len(d.buf) {
+		return 0, 0, errors.New("unexpected end of data")
+	}
+	return d.buf[offset], offset + 1, nil
+}
+
+func (d decoder) bytes(offset uint32, n int) ([]byte, uint32, error) {
+	end := uint64(offset) + uint64(n)
+	if end > uint64(len(d.buf)) {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+	return d.buf[offset:end], uint32(end), nil
+}
+
+// size reads the payload size the control byte's low five bits give,
+// with the bytes that extend it.
+func (d decoder) size(ctrl byte, offset uint32) (int, uint32, error) {
+	size := int(ctrl & 0x1f)
+	if size < 29 {
+		return size, offset, nil
+	}
+	n := size - 28
+	b, next, err := d.bytes(offset, n)
+	if err != nil {
+		return 0, 0, err
+	}
+	var ext int
+	for _, c := range b {
+		ext = ext<<8 | int(c)
+	}
+	return []int{0, 29, 285, 65821}[n] + ext, next, nil
+}
+
+// pointer reads a pointer's target, an offset into buf.
+func (d decoder) pointer(ctrl byte, offset uint32) (uint32, uint32, error) {
+	n := int(ctrl>>3&0x3) + 1
+	b, next, err := d.bytes(offset, n)
+	if err != nil {
+		return 0, 0, err
+	}
+	var p uint32
+	if n < 4 {
+		p = uint32(ctrl & 0x7)
+	}
+	for _, c := range b {
+		p = p<<8 | uint32(c)
+	}
+	return p + []uint32{0, 0, 2048, 526336, 0}[n], next, nil
+}
@@ -0,0 +1,235 @@
+package geoip
+
+import (
+	"bytes"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Encoders for the data section types the test databases use
+func encString(s string) []byte { return append([]byte{typeString<<5 | byte(len(s))}, s...) }
+
+func encUint(typ int, v uint64, size int) []byte {
+	b := make([]byte, 0, size+2)
+	if typ <= typeMap {
+		b = append(b, byte(typ<<5|size))
+	} else {
+		b = append(b, byte(size), byte(typ-7))
+	}
+	for i := size - 1; i >= 0; i-- {
+		b = append(b, byte(v>>(8*i)))
+	}
+	return b
+}
+
+func encMap(pairs ...[]byte) []byte {
+	return append([]byte{typeMap<<5 | byte(len(pairs)/2)}, bytes.Join(pairs, nil)...)
+}
+
+// encPointer is a two-byte pointer to offset, which must be under 2048.
+func encPointer(offset int) []byte {
+	return []byte{typePointer<<5 | byte(offset>>8), byte(offset)}
+}
+
+// record is a data record placing a network in country and continent.
+func record(country, continent string) []byte {
+	return encMap(
+		encString("continent"), encMap(encString("code"), encString(continent)),
+		encString("country"), encMap(encString("iso_code"), encString(country)),
+	)
+}
+
+// trie builds a search tree, node 0 its root. A record is a node index,
+// or with data set a data section offset; its zero value is empty.
+type trie struct {
+	nodes [][2]rec
+}
+
+type rec struct {
+	n    int
+	data bool
+	set  bool
+}
+
+// insert points the record bits deep along addr's path at r, adding the
+// nodes above it.
+func (t *trie) insert(addr [16]byte, bits int, r rec) {
+	if len(t.nodes) == 0 {
+		t.nodes = append(t.nodes, [2]rec{})
+	}
+	node := 0
+	for i := 0; i < bits; i++ {
+		bit := int(addr[i/8] >> (7 - i%8) & 1)
+		if i == bits-1 {
+			t.nodes[node][bit] = r
+			return
+		}
+		next := t.nodes[node][bit]
+		if !next.set || next.data {
+			t.nodes = append(t.nodes, [2]rec{})
+			next = rec{n: len(t.nodes) - 1, set: true}
+			t.nodes[node][bit] = next
+		}
+		node = next.n
+	}
+}
+
+// build writes a database over the tree and data section, with dbType in
+// its metadata.
+func (t *trie) build(recordSize int, ipVersion uint64, data []byte, dbType string) []byte {
+	count := uint32(len(t.nodes))
+	value := func(r rec) uint32 {
+		switch {
+		case !r.set:
+			return count
+		case r.data:
+			return count + dataSeparator + uint32(r.n)
+		}
+		return uint32(r.n)
+	}
+	var out []byte
+	for _, n := range t.nodes {
+		l, r := value(n[0]), value(n[1])
+		switch recordSize {
+		case 24:
+			out = append(out, byte(l>>16), byte(l>>8), byte(l), byte(r>>16), byte(r>>8), byte(r))
+		case 28:
+			out = append(out, byte(l>>16), byte(l>>8), byte(l), byte(l>>20&0xf0|r>>24&0x0f), byte(r>>16), byte(r>>8), byte(r))
+		}
+	}
+	out = append(out, make([]byte, dataSeparator)...)
+	out = append(out, data...)
+	out = append(out, metadataMarker...)
+	return append(out, encMap(
+		encString("binary_format_major_version"), encUint(typeUint16, 2, 1),
+		encString("build_epoch"), encUint(typeUint64, 1760000000, 4),
+		encString("database_type"), encString(dbType),
+		encString("ip_version"), encUint(typeUint16, ipVersion, 1),
+		encString("node_count"), encUint(typeUint32, uint64(count), 2),
+		encString("record_size"), encUint(typeUint16, uint64(recordSize), 1),
+	)...)
+}
+
+// treePath is where prefix is in an IPv6 search tree: an IPv4 one under
+// ::/96.
+func treePath(prefix string) ([16]byte, int) {
+	p := netip.MustParsePrefix(prefix)
+	if p.Addr().Is4() {
+		var addr [16]byte
+		v4 := p.Addr().As4()
+		copy(addr[12:], v4[:])
+		return addr, 96 + p.Bits()
+	}
+	return p.Addr().As16(), p.Bits()
+}
+
+// testDB is an IPv6 database with IPv4 under ::/96, aliased from
+// ::ffff:0:0/96 as MaxMind's are.
+func testDB(t *testing.T, recordSize int) *DB {
+	t.Helper()
+	de := record("DE", "EU")
+	us := record("US", "NA")
+	// The continent of France's record points at Germany's
+	fr := encMap(
+		encString("continent"), encPointer(1+len(encString("continent"))),
+		encString("country"), encMap(encString("iso_code"), encString("FR")),
+	)
+	data := slices.Concat(de, us, fr)
+	offDE, offUS, offFR := 0, len(de), len(de)+len(us)
+
+	var tr trie
+	for _, n := range []struct {
+		prefix string
+		off    int
+	}{
+		{"1.2.3.0/24", offDE}, {"1.2.4.0/24", offDE}, {"1.2.5.0/24", offDE},
+		{"8.8.8.0/24", offUS}, {"2001:db8::/32", offFR},
+	} {
+		addr, bits := treePath(n.prefix)
+		tr.insert(addr, bits, rec{n: n.off, data: true, set: true})
+	}
+	// The IPv4 subtree starts at the node 96 zero bits down
+	node := 0
+	for range 96 {
+		node = tr.nodes[node][0].n
+	}
+	mapped := netip.MustParseAddr("::ffff:0:0").As16()
+	tr.insert(mapped, 96, rec{n: node, set: true})
+	if len(data) >= 2048 {
+		t.Fatal("test data too long for its pointer")
+	}
+
+	db, err := New(tr.build(recordSize, 6, data, "Test-Country"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return db
+}
+
+func TestLookup(t *testing.T) {
+	for _, size := range []int{24, 28} {
+		db := testDB(t, size)
+		if db.Type != "Test-Country" || db.BuildEpoch != 1760000000 {
+			t.Errorf("metadata: got %q, %d", db.Type, db.BuildEpoch)
+		}
+		for _, tc := range []struct {
+			ip   string
+			want Location
+			ok   bool
+		}{
+			{"1.2.3.4", Location{"DE", "EU"}, true},
+			{"::ffff:1.2.5.200", Location{"DE", "EU"}, true},
+			{"8.8.8.8", Location{"US", "NA"}, true},
+			{"2001:db8::1", Location{"FR", "EU"}, true},
+			{"9.9.9.9", Location{}, false},
+			{"2001:db9::1", Location{}, false},
+		} {
+			got, ok, err := db.Lookup(netip.MustParseAddr(tc.ip))
+			if err != nil || ok != tc.ok || got != tc.want {
+				t.Errorf("record size %d: Lookup(%s) = %+v, %v, %v, want %+v, %v", size, tc.ip, got, ok, err, tc.want, tc.ok)
+			}
+		}
+	}
+}
+
+func TestPartition(t *testing.T) {
+	db := testDB(t, 24)
+	// Europe first, then the rest of the world
+	got, err := db.Partition(2, func(l Location) int {
+		if l.Continent == "EU" {
+			return 0
+		}
+		return 1
+	})
+	if err != nil {
+		t.Fatalf("Partition: %v", err)
+	}
+	str := func(ps []netip.Prefix) string {
+		s := make([]string, len(ps))
+		for i, p := range ps {
+			s[i] = p.String()
+		}
+		return strings.Join(s, " ")
+	}
+	// Sibling networks in one class merge, and the ::ffff:0:0/96 alias
+	// doesn't repeat them
+	if s := str(got[0]); s != "1.2.3.0/24 1.2.4.0/23 2001:db8::/32" {
+		t.Errorf("EU: got %s", s)
+	}
+	if s := str(got[1]); s != "8.8.8.0/24" {
+		t.Errorf("rest: got %s", s)
+	}
+}
+
+func TestNew_Rejects(t *testing.T) {
+	if _, err := New([]byte("not a database")); err == nil || !strings.Contains(err.Error(), "no metadata") {
+		t.Errorf("no metadata: got %v", err)
+	}
+	var tr trie
+	tr.insert([16]byte{}, 1, rec{})
+	if _, err := New(tr.build(24, 5, nil, "x")); err == nil || !strings.Contains(err.Error(), "unsupported IP version") {
+		t.Errorf("IP version: got %v", err)
+	}
+}
@@ -10,15 +10,19 @@ import (
 	"time"
 
 	"sync"
+	"sync/atomic"
 
+	"github.com/lazzerex/aegis/control-plane/internal/accesslog"
+	"github.com/lazzerex/aegis/control-plane/internal/certs"
 	"github.com/lazzerex/aegis/control-plane/internal/config"
-	"github.com/lazzerex/aegis/control-plane/internal/metrics"
+	"github.com/lazzerex/aegis/control-plane/internal/events"
 	pb "github.com/lazzerex/aegis/control-plane/proto"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
@@ -29,6 +33,25 @@ type Client struct {
 
 	cfgMu   sync.Mutex
 	lastCfg *config.Config
+	prevCfg *config.Config // live before lastCfg, for RollbackConfig
+	pending *pendingConfig
+
+	peerMu sync.Mutex
+	peer   *DataPlaneInfo
+
+	lastPush atomic.Int64 // unix nanos of the last applied config, 0 if none
+	metrics  atomic.Pointer[MetricsStream]
+
+	// feed receives data plane connect/disconnect events from
+	// WatchReconnect.
+	feed *events.Feed
+
+	// secrets are the certificates last given to UpdateSecrets, pushed
+	// again on reconnect; nil until then. secretStream is nil until a
+	// data plane with one is pushed to, and after a failed exchange.
+	secretsMu    sync.Mutex
+	secrets      []certs.Certificate
+	secretStream *secretStream
 }
 
 func NewClient(grpcCfg config.GRPCConfig, logger *zap.Logger) (*Client, error) {
@@ -37,7 +60,11 @@ func NewClient(grpcCfg config.GRPCConfig, logger *zap.Logger) (*Client, error) {
 		return nil, err
 	}
 
-	conn, err := grpc.NewClient(grpcCfg.ControlPlaneAddress, grpc.WithTransportCredentials(creds))
+	conn, err := grpc.NewClient(grpcCfg.ControlPlaneAddress,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithChainUnaryInterceptor(metricsInterceptor(), retryInterceptor(grpcCfg.Retry, logger)),
+		grpc.WithDefaultCallOptions(callOptions(grpcCfg)...),
+	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to data plane: %w", err)
 	}
@@ -49,6 +76,22 @@ func NewClient(grpcCfg config.GRPCConfig, logger *zap.Logger) (*Client, error) {
 	}, nil
 }
 
+// callOptions applies grpc.compression and the message size limits to every
+// RPC on the connection. Zero sizes leave gRPC's defaults in place.
+func callOptions(grpcCfg config.GRPCConfig) []grpc.CallOption {
+	var opts []grpc.CallOption
+	if grpcCfg.Compression == "gzip" {
+		opts = append(opts, grpc.UseCompressor(gzip.Name))
+	}
+	if grpcCfg.MaxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxCallSendMsgSize(grpcCfg.MaxSendMsgSize))
+	}
+	if grpcCfg.MaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxCallRecvMsgSize(grpcCfg.MaxRecvMsgSize))
+	}
+	return opts
+}
+
 func buildTransportCredentials(grpcCfg config.GRPCConfig, logger *zap.Logger) (credentials.TransportCredentials, error) {
 	if grpcCfg.TLSSkipVerify {
 		if grpcCfg.TLSCACert != "" {
@@ -83,18 +126,39 @@ func (c *Client) Close() error {
 	return c.conn.Close()
 }
 
+// UpdateConfig applies cfg to the data plane through the two-phase
+// Prepare/Commit path, so a config the data plane can't honour is rejected
+// before any traffic sees it.
 func (c *Client) UpdateConfig(cfg *config.Config) error {
-	// Convert config to protobuf
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return ApplyConfig(ctx, cfg, []ConfigStager{c})
+}
+
+// toProtoConfig converts cfg to the wire format shared by UpdateConfig and
+// PrepareConfig.
+func toProtoConfig(cfg *config.Config) *pb.ProxyConfig {
+	backends := cfg.Proxy.ServingBackends(cfg.Proxy.Backends)
 	pbConfig := &pb.ProxyConfig{
 		Listen: &pb.ListenConfig{
 			TcpAddress: cfg.Proxy.Listen.TCP,
 			UdpAddress: cfg.Proxy.Listen.UDP,
 		},
-		Backends:    make([]*pb.Backend, len(cfg.Proxy.Backends)),
+		Backends:    make([]*pb.Backend, len(backends)),
 		UdpBackends: make([]*pb.Backend, len(cfg.Proxy.UdpBackends)),
 		LoadBalancing: &pb.LoadBalancingConfig{
 			Algorithm:       cfg.Proxy.LoadBalancing.Algorithm,
 			SessionAffinity: cfg.Proxy.LoadBalancing.SessionAffinity,
+			Hash: &pb.HashConfig{
+				Key:          cfg.Proxy.LoadBalancing.Hash.Key,
+				Name:         cfg.Proxy.LoadBalancing.Hash.Name,
+				VirtualNodes: int32(cfg.Proxy.LoadBalancing.Hash.VirtualNodes),
+				Method:       cfg.Proxy.LoadBalancing.Hash.Method,
+				TableSize:    int32(cfg.Proxy.LoadBalancing.Hash.TableSize),
+			},
+			Locality:     toProtoLocality(cfg.Proxy.LoadBalancing.Locality),
+			UdpAffinity:  toProtoUDPAffinity(cfg.Proxy.LoadBalancing.UDPAffinity),
+			StickyCookie: toProtoStickyCookie(cfg.Proxy.LoadBalancing.StickyCookie),
 		},
 		Traffic: &pb.TrafficConfig{
 			RateLimit: &pb.RateLimitConfig{
@@ -106,45 +170,228 @@ func (c *Client) UpdateConfig(cfg *config.Config) error {
 				IdleSeconds:    int32(cfg.Proxy.Traffic.Timeout.Idle.Seconds()),
 				ReadSeconds:    int32(cfg.Proxy.Traffic.Timeout.Read.Seconds()),
 			},
+			ConnectionLimit: &pb.ConnectionLimitConfig{
+				Max:            int32(cfg.Proxy.Traffic.ConnectionLimit.Max),
+				Overflow:       cfg.Proxy.Traffic.ConnectionLimit.Overflow,
+				QueueTimeoutMs: int32(cfg.Proxy.Traffic.ConnectionLimit.QueueTimeout.Milliseconds()),
+				QueueSize:      int32(cfg.Proxy.Traffic.ConnectionLimit.QueueSize),
+			},
+			Retry: toProtoRetryPolicy(cfg.Proxy.Traffic.Retry),
 		},
 		CircuitBreaker: &pb.CircuitBreakerConfig{
 			ErrorThreshold: int32(cfg.Proxy.CircuitBreaker.ErrorThreshold),
 			TimeoutSeconds: int32(cfg.Proxy.CircuitBreaker.Timeout.Seconds()),
 		},
+		Mirror:       toProtoMirror(cfg.Proxy.Mirror, cfg.Proxy.Backends, nil),
+		HttpRoutes:   toProtoHTTPRoutes(cfg.Proxy),
+		HeaderRules:  toProtoHeaderRules(cfg.Proxy.HeaderRules, cfg.Proxy.Backends, nil),
+		SniRoutes:    toProtoSNIRoutes(cfg.Proxy.SNIRoutes, cfg.Proxy.Backends, nil),
+		GeoRoutes:    toProtoGeoRoutes(cfg.Proxy.GeoRoutes, cfg.Proxy.Backends, nil),
+		DarkLaunches: toProtoDarkLaunches(cfg.Proxy.DarkLaunches, cfg.Proxy.Backends, nil, time.Now().In(cfg.Scheduler.Location())),
 	}
 
-	// Convert backends
-	for i, backend := range cfg.Proxy.Backends {
-		pbConfig.Backends[i] = &pb.Backend{
-			Address: backend.Address,
-			Weight:  int32(backend.Weight),
-			Healthy: true, // Initially all are healthy
-			HealthCheck: &pb.HealthCheckConfig{
-				IntervalSeconds: int32(backend.HealthCheck.Interval.Seconds()),
-				TimeoutSeconds:  int32(backend.HealthCheck.Timeout.Seconds()),
-				Path:            backend.HealthCheck.Path,
-			},
-		}
+	// Convert backends, with the weights of any traffic split and without
+	// the standby blue/green pool or the shadow pool
+	for i, backend := range backends {
+		pbConfig.Backends[i] = toProtoBackend(backend, true) // Initially all are healthy
 	}
 
 	// Convert UDP backends
 	for i, backend := range cfg.Proxy.UdpBackends {
-		pbConfig.UdpBackends[i] = &pb.Backend{
-			Address: backend.Address,
-			Weight:  int32(backend.Weight),
-			Healthy: true, // Initially all are healthy
-			HealthCheck: &pb.HealthCheckConfig{
-				IntervalSeconds: int32(backend.HealthCheck.Interval.Seconds()),
-				TimeoutSeconds:  int32(backend.HealthCheck.Timeout.Seconds()),
-				Path:            backend.HealthCheck.Path,
-			},
+		pbConfig.UdpBackends[i] = toProtoBackend(backend, true)
+	}
+
+	if cfg.Proxy.TLS.Enabled() {
+		pbConfig.Tls = &pb.TlsTermination{Enabled: true}
+	}
+
+	return pbConfig
+}
+
+func toProtoBackend(b config.Backend, healthy bool) *pb.Backend {
+	return &pb.Backend{
+		Address:  b.Address,
+		Weight:   int32(b.Weight),
+		Healthy:  healthy,
+		Priority: int32(b.Priority),
+		Backup:   b.Backup,
+		Zone:     b.Zone,
+		Region:   b.Region,
+		HealthCheck: &pb.HealthCheckConfig{
+			IntervalSeconds: int32(b.HealthCheck.Interval.Seconds()),
+			TimeoutSeconds:  int32(b.HealthCheck.Timeout.Seconds()),
+			Path:            b.HealthCheck.Path,
+		},
+	}
+}
+
+// toProtoLocality returns nil while the locality policy is off, leaving
+// the data plane to ignore zones.
+func toProtoLocality(l config.LocalityConfig) *pb.LocalityConfig {
+	if l.Policy == "" {
+		return nil
+	}
+	return &pb.LocalityConfig{
+		Policy:            l.Policy,
+		Zone:              l.Zone,
+		Region:            l.Region,
+		SpilloverPercent:  int32(l.SpilloverPercent),
+		MinHealthyPercent: int32(l.MinHealthyPercent),
+	}
+}
+
+func toProtoUDPAffinity(a config.UDPAffinityConfig) *pb.UdpAffinityConfig {
+	if !a.Enabled() {
+		return nil
+	}
+	return &pb.UdpAffinityConfig{Mode: a.Mode, IdleTimeoutMs: int32(a.IdleTimeout.Milliseconds())}
+}
+
+func toProtoStickyCookie(s config.StickyCookieConfig) *pb.StickyCookieConfig {
+	if !s.Enabled() {
+		return nil
+	}
+	return &pb.StickyCookieConfig{
+		Name:       s.Name,
+		TtlSeconds: int32(s.TTL.Seconds()),
+		Path:       s.Path,
+		Secure:     s.Secure,
+		HttpOnly:   s.HTTPOnly,
+	}
+}
+
+// toProtoMirror returns nil while mirroring is off. The shadow backends
+// are the mirror pool's in backends, less those healthState has as down.
+func toProtoMirror(m config.MirrorConfig, backends []config.Backend, healthState map[string]bool) *pb.MirrorConfig {
+	if m.Pool == "" {
+		return nil
+	}
+	mirror := &pb.MirrorConfig{Percent: int32(m.Percent)}
+	for _, b := range backends {
+		if healthy, known := healthState[b.Address]; b.Pool == m.Pool && (healthy || !known) {
+			mirror.Backends = append(mirror.Backends, b.Address)
+		}
+	}
+	return mirror
+}
+
+func toProtoHTTPRoutes(p config.ProxyConfig) []*pb.HttpRoute {
+	if len(p.HTTPRoutes) == 0 {
+		return nil
+	}
+	out := make([]*pb.HttpRoute, len(p.HTTPRoutes))
+	for i, r := range p.HTTPRoutes {
+		t := p.RouteTraffic(r)
+		out[i] = &pb.HttpRoute{
+			Name:             r.Name,
+			Prefix:           r.Prefix,
+			Retry:            toProtoRetryPolicy(t.Retry),
+			RequestTimeoutMs: int32(t.RequestTimeout.Milliseconds()),
+			ReadTimeoutMs:    int32(t.ReadTimeout.Milliseconds()),
 		}
+		if t.Hedged() {
+			out[i].Hedge = &pb.HedgePolicy{DelayMs: int32(t.Hedge.Delay.Milliseconds())}
+		}
+		if rl := t.RateLimit; rl.RequestsPerSecond > 0 {
+			out[i].RateLimit = &pb.RateLimitConfig{RequestsPerSecond: int32(rl.RequestsPerSecond), Burst: int32(rl.Burst)}
+		}
+	}
+	return out
+}
+
+// toProtoRetryPolicy returns nil for a policy that doesn't retry.
+func toProtoRetryPolicy(r config.RouteRetryPolicy) *pb.RetryPolicy {
+	if r.Attempts < 2 {
+		return nil
 	}
+	return &pb.RetryPolicy{
+		Attempts:        int32(r.Attempts),
+		PerTryTimeoutMs: int32(r.PerTryTimeout.Milliseconds()),
+		RetryOn:         r.RetryOn,
+		Idempotent:      r.Idempotent,
+	}
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// toProtoHeaderRules gives each rule its pool's backends in backends,
+// healthy unless healthState has them down.
+func toProtoHeaderRules(rules []config.HeaderRule, backends []config.Backend, healthState map[string]bool) []*pb.HeaderRule {
+	if len(rules) == 0 {
+		return nil
+	}
+	out := make([]*pb.HeaderRule, len(rules))
+	for i, r := range rules {
+		out[i] = &pb.HeaderRule{Name: r.Name}
+		for _, m := range r.Match {
+			out[i].Matches = append(out[i].Matches, &pb.HeaderMatch{Header: m.Header, Exact: m.Exact, Regex: m.Regex})
+		}
+		for _, b := range config.PoolBackends(backends, r.Pool) {
+			healthy, known := healthState[b.Address]
+			out[i].Backends = append(out[i].Backends, toProtoBackend(b, healthy || !known))
+		}
+	}
+	return out
+}
+
+// toProtoSNIRoutes gives each route its pool's backends in backends,
+// healthy unless healthState has them down.
+func toProtoSNIRoutes(routes []config.SNIRoute, backends []config.Backend, healthState map[string]bool) []*pb.SniRoute {
+	if len(routes) == 0 {
+		return nil
+	}
+	out := make([]*pb.SniRoute, len(routes))
+	for i, r := range routes {
+		out[i] = &pb.SniRoute{ServerNames: r.ServerNames}
+		for _, b := range config.PoolBackends(backends, r.Pool) {
+			healthy, known := healthState[b.Address]
+			out[i].Backends = append(out[i].Backends, toProtoBackend(b, healthy || !known))
+		}
+	}
+	return out
+}
+
+// toProtoGeoRoutes gives each route its resolved networks and its pool's
+// backends in backends, healthy unless healthState has them down.
+func toProtoGeoRoutes(routes []config.GeoRoute, backends []config.Backend, healthState map[string]bool) []*pb.GeoRoute {
+	if len(routes) == 0 {
+		return nil
+	}
+	out := make([]*pb.GeoRoute, len(routes))
+	for i, r := range routes {
+		out[i] = &pb.GeoRoute{Name: r.Name, Networks: make([]string, len(r.Networks))}
+		for j, n := range r.Networks {
+			out[i].Networks[j] = n.String()
+		}
+		for _, b := range config.PoolBackends(backends, r.Pool) {
+			healthy, known := healthState[b.Address]
+			out[i].Backends = append(out[i].Backends, toProtoBackend(b, healthy || !known))
+		}
+	}
+	return out
+}
 
-	resp, err := c.client.UpdateConfig(ctx, pbConfig)
+// toProtoDarkLaunches gives each rule on at now its pool's backends in
+// backends, healthy unless healthState has them down. Rules whose windows
+// are closed are left out.
+func toProtoDarkLaunches(rules []config.DarkLaunchRule, backends []config.Backend, healthState map[string]bool, now time.Time) []*pb.DarkLaunch {
+	var out []*pb.DarkLaunch
+	for _, r := range rules {
+		if !r.ActiveAt(now) {
+			continue
+		}
+		dl := &pb.DarkLaunch{Name: r.Name, Percent: int32(r.Percent)}
+		for _, b := range config.PoolBackends(backends, r.Pool) {
+			healthy, known := healthState[b.Address]
+			dl.Backends = append(dl.Backends, toProtoBackend(b, healthy || !known))
+		}
+		out = append(out, dl)
+	}
+	return out
+}
+
+// pushConfig sends cfg with the single-shot UpdateConfig RPC, used for data
+// planes without two-phase support and for rollbacks.
+func (c *Client) pushConfig(ctx context.Context, cfg *config.Config) error {
+	resp, err := c.client.UpdateConfig(ctx, toProtoConfig(cfg))
 	if err != nil {
 		return fmt.Errorf("failed to update config: %w", err)
 	}
@@ -152,12 +399,6 @@ func (c *Client) UpdateConfig(cfg *config.Config) error {
 	if !resp.Success {
 		return fmt.Errorf("config update failed: %s", resp.Message)
 	}
-
-	c.cfgMu.Lock()
-	c.lastCfg = cfg
-	c.cfgMu.Unlock()
-
-	c.logger.Info("Configuration updated successfully", zap.String("message", resp.Message))
 	return nil
 }
 
@@ -165,7 +406,29 @@ func (c *Client) ReloadBackends(backends []config.Backend) error {
 	return c.ReloadBackendsWithHealth(backends, nil)
 }
 
+// ReloadBackendsWithHealth replaces the data plane's TCP backends,
+// weighted by the traffic split of the last pushed config and without its
+// standby blue/green pool. The healthy backends of its mirror pool become
+// the shadow backends, and its header rules, SNI routes, geo routes and
+// dark launches on now get their pools' backends.
 func (c *Client) ReloadBackendsWithHealth(backends []config.Backend, healthState map[string]bool) error {
+	var mirror *pb.MirrorConfig
+	var rules []*pb.HeaderRule
+	var sniRoutes []*pb.SniRoute
+	var geoRoutes []*pb.GeoRoute
+	var darkLaunches []*pb.DarkLaunch
+	c.cfgMu.Lock()
+	if c.lastCfg != nil {
+		mirror = toProtoMirror(c.lastCfg.Proxy.Mirror, backends, healthState)
+		rules = toProtoHeaderRules(c.lastCfg.Proxy.HeaderRules, backends, healthState)
+		sniRoutes = toProtoSNIRoutes(c.lastCfg.Proxy.SNIRoutes, backends, healthState)
+		geoRoutes = toProtoGeoRoutes(c.lastCfg.Proxy.GeoRoutes, backends, healthState)
+		darkLaunches = toProtoDarkLaunches(c.lastCfg.Proxy.DarkLaunches, backends, healthState,
+			time.Now().In(c.lastCfg.Scheduler.Location()))
+		backends = c.lastCfg.Proxy.ServingBackends(backends)
+	}
+	c.cfgMu.Unlock()
+
 	pbBackends := make([]*pb.Backend, len(backends))
 	for i, backend := range backends {
 		healthy := true
@@ -175,22 +438,20 @@ func (c *Client) ReloadBackendsWithHealth(backends []config.Backend, healthState
 			}
 		}
 
-		pbBackends[i] = &pb.Backend{
-			Address: backend.Address,
-			Weight:  int32(backend.Weight),
-			Healthy: healthy,
-			HealthCheck: &pb.HealthCheckConfig{
-				IntervalSeconds: int32(backend.HealthCheck.Interval.Seconds()),
-				TimeoutSeconds:  int32(backend.HealthCheck.Timeout.Seconds()),
-				Path:            backend.HealthCheck.Path,
-			},
-		}
+		pbBackends[i] = toProtoBackend(backend, healthy)
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	resp, err := c.client.ReloadBackends(ctx, &pb.BackendList{Backends: pbBackends})
+	resp, err := c.client.ReloadBackends(ctx, &pb.BackendList{
+		Backends:     pbBackends,
+		Mirror:       mirror,
+		HeaderRules:  rules,
+		SniRoutes:    sniRoutes,
+		GeoRoutes:    geoRoutes,
+		DarkLaunches: darkLaunches,
+	})
 	if err != nil {
 		return fmt.Errorf("failed to reload backends: %w", err)
 	}
@@ -217,7 +478,14 @@ func (c *Client) DrainConnections(ctx context.Context, timeoutSeconds int) error
 	return nil
 }
 
-// WatchReconnect re-pushes the last known-good config on reconnect.
+// SetEventFeed publishes connectivity changes to feed; call before
+// WatchReconnect.
+func (c *Client) SetEventFeed(feed *events.Feed) {
+	c.feed = feed
+}
+
+// WatchReconnect re-pushes the secrets and last known-good config on
+// reconnect.
 // grpc.NewClient drops an idle conn to Idle instead of auto-retrying (gRFC
 // A62), so Connect() must be called explicitly — checked every loop, not
 // just after a change, in case the conn is already Idle when this starts.
@@ -233,7 +501,16 @@ func (c *Client) WatchReconnect() {
 				return
 			}
 			state = c.conn.GetState()
+			if wasReady && state != connectivity.Ready {
+				c.feed.Publish(events.TypeDataPlaneDisconnected, "Lost connection to the data plane",
+					map[string]string{"state": state.String()})
+			}
 			if state == connectivity.Ready && !wasReady {
+				c.feed.Publish(events.TypeDataPlaneConnected, "Connected to the data plane", nil)
+				c.resetHandshake()
+				// Before the config, so a listener terminating TLS has
+				// its certificates from the start
+				c.repushSecrets()
 				c.cfgMu.Lock()
 				cfg := c.lastCfg
 				c.cfgMu.Unlock()
@@ -249,28 +526,81 @@ func (c *Client) WatchReconnect() {
 	}()
 }
 
-func (c *Client) StreamMetrics(collector *metrics.Collector) {
+// StreamAccessLogs forwards the data plane's access log stream until ctx is
+// cancelled, reconnecting on stream errors like the metrics stream. The returned
+// channel closes once the goroutine has exited, after which it's safe to
+// close the forwarder.
+func (c *Client) StreamAccessLogs(ctx context.Context, forwarder *accesslog.Forwarder) <-chan struct{} {
+	done := make(chan struct{})
 	go func() {
-		for {
-			stream, err := c.client.StreamMetrics(context.Background(), &emptypb.Empty{})
+		defer close(done)
+		for ctx.Err() == nil {
+			stream, err := c.client.StreamAccessLogs(ctx, &emptypb.Empty{})
+			if err != nil {
+				c.logger.Error("Failed to start access log stream, retrying in 5s", zap.Error(err))
+				sleepCtx(ctx, 5*time.Second)
+				continue
+			}
+			for {
+				rec, err := stream.Recv()
+				if err == io.EOF {
+					c.logger.Info("Access log stream closed, reconnecting")
+					break
+				}
+				if err != nil {
+					if ctx.Err() == nil {
+						c.logger.Error("Access log stream error, reconnecting in 5s", zap.Error(err))
+						sleepCtx(ctx, 5*time.Second)
+					}
+					break
+				}
+				forwarder.Forward(rec)
+			}
+		}
+	}()
+	return done
+}
+
+// StreamEvents consumes the data plane's structured event stream and hands
+// each event to dispatcher until ctx is cancelled, reconnecting on errors
+// the same way the metrics stream does. The returned channel is closed once the
+// stream goroutine has exited, so the caller can close dispatcher safely.
+func (c *Client) StreamEvents(ctx context.Context, dispatcher *events.Dispatcher) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ctx.Err() == nil {
+			stream, err := c.client.StreamEvents(ctx, &emptypb.Empty{})
 			if err != nil {
-				c.logger.Error("Failed to start metrics stream, retrying in 5s", zap.Error(err))
-				time.Sleep(5 * time.Second)
+				c.logger.Error("Failed to start event stream, retrying in 5s", zap.Error(err))
+				sleepCtx(ctx, 5*time.Second)
 				continue
 			}
 			for {
-				metricsData, err := stream.Recv()
+				ev, err := stream.Recv()
 				if err == io.EOF {
-					c.logger.Info("Metrics stream closed, reconnecting")
+					c.logger.Info("Event stream closed, reconnecting")
 					break
 				}
 				if err != nil {
-					c.logger.Error("Metrics stream error, reconnecting in 5s", zap.Error(err))
-					time.Sleep(5 * time.Second)
+					if ctx.Err() == nil {
+						c.logger.Error("Event stream error, reconnecting in 5s", zap.Error(err))
+						sleepCtx(ctx, 5*time.Second)
+					}
 					break
 				}
-				collector.UpdateFromProto(metricsData)
+				dispatcher.Handle(ev)
 			}
 		}
 	}()
+	return done
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
 }
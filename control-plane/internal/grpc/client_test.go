@@ -2,19 +2,30 @@ package grpc
 
 import (
 	"context"
+	"errors"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/lazzerex/aegis/control-plane/internal/accesslog"
 	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/events"
 	"github.com/lazzerex/aegis/control-plane/internal/metrics"
 	pb "github.com/lazzerex/aegis/control-plane/proto"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
@@ -25,8 +36,43 @@ type fakeServer struct {
 	updateConfigCalls atomic.Int64
 	failUpdateConfig  atomic.Bool
 
+	failPrepare atomic.Bool
+	commitCalls atomic.Int64
+	abortCalls  atomic.Int64
+
+	rateLimitCalls atomic.Int64
+	lastRateLimit  atomic.Pointer[pb.RateLimitConfig]
+
+	lastListConnections atomic.Pointer[pb.ListConnectionsRequest]
+
+	secretsCalls atomic.Int64
+	lastSecrets  atomic.Pointer[pb.SecretSet]
+
+	// secretStreams counts StreamSecrets calls, whose updates are kept in
+	// secretUpdates; a certificate chain of "bad" is rejected.
+	secretStreams atomic.Int64
+	secretMu      sync.Mutex
+	secretUpdates []*pb.SecretUpdate
+
+	// helloFeatures, when non-nil, makes Hello succeed advertising them;
+	// otherwise Hello is unimplemented like on a pre-handshake data plane.
+	helloFeatures []string
+	helloCalls    atomic.Int64
+
 	streamOpens    atomic.Int64
+	getStatsCalls  atomic.Int64
 	streamBehavior func(stream grpc.ServerStreamingServer[pb.MetricsData]) error
+
+	accessLogBehavior func(stream grpc.ServerStreamingServer[pb.AccessLogRecord]) error
+	eventBehavior     func(stream grpc.ServerStreamingServer[pb.ProxyEvent]) error
+}
+
+func (f *fakeServer) Hello(_ context.Context, _ *pb.HelloRequest) (*pb.HelloResponse, error) {
+	f.helloCalls.Add(1)
+	if f.helloFeatures == nil {
+		return nil, status.Error(codes.Unimplemented, "method Hello not implemented")
+	}
+	return &pb.HelloResponse{Version: "9.9.9", Features: f.helloFeatures}, nil
 }
 
 func (f *fakeServer) UpdateConfig(_ context.Context, _ *pb.ProxyConfig) (*pb.ConfigAck, error) {
@@ -37,6 +83,76 @@ func (f *fakeServer) UpdateConfig(_ context.Context, _ *pb.ProxyConfig) (*pb.Con
 	return &pb.ConfigAck{Success: true, Message: "ok"}, nil
 }
 
+func (f *fakeServer) PrepareConfig(_ context.Context, _ *pb.ProxyConfig) (*pb.PrepareResponse, error) {
+	if f.failPrepare.Load() {
+		return &pb.PrepareResponse{Success: false, Message: "invalid backend"}, nil
+	}
+	return &pb.PrepareResponse{Success: true, Token: "t1"}, nil
+}
+
+func (f *fakeServer) CommitConfig(_ context.Context, req *pb.ConfigToken) (*pb.ConfigAck, error) {
+	f.commitCalls.Add(1)
+	if req.Token != "t1" {
+		return nil, status.Error(codes.FailedPrecondition, "unknown token")
+	}
+	return &pb.ConfigAck{Success: true}, nil
+}
+
+func (f *fakeServer) AbortConfig(_ context.Context, _ *pb.ConfigToken) (*pb.ConfigAck, error) {
+	f.abortCalls.Add(1)
+	return &pb.ConfigAck{Success: true}, nil
+}
+
+func (f *fakeServer) UpdateRateLimit(_ context.Context, req *pb.RateLimitConfig) (*pb.ConfigAck, error) {
+	f.rateLimitCalls.Add(1)
+	f.lastRateLimit.Store(req)
+	return &pb.ConfigAck{Success: true}, nil
+}
+
+func (f *fakeServer) UpdateSecrets(_ context.Context, req *pb.SecretSet) (*pb.ConfigAck, error) {
+	f.secretsCalls.Add(1)
+	f.lastSecrets.Store(req)
+	return &pb.ConfigAck{Success: true}, nil
+}
+
+func (f *fakeServer) StreamSecrets(stream grpc.BidiStreamingServer[pb.SecretUpdate, pb.SecretAck]) error {
+	f.secretStreams.Add(1)
+	for {
+		update, err := stream.Recv()
+		if err != nil {
+			return nil
+		}
+		f.secretMu.Lock()
+		f.secretUpdates = append(f.secretUpdates, update)
+		f.secretMu.Unlock()
+		ack := &pb.SecretAck{Nonce: update.Nonce}
+		for _, secret := range update.Secrets {
+			st := &pb.SecretStatus{Name: secret.Name, Version: secret.Version, Accepted: true}
+			if string(secret.GetTlsCertificate().GetCertificateChain()) == "bad" {
+				st.Accepted, st.Error = false, "invalid chain"
+			}
+			ack.Secrets = append(ack.Secrets, st)
+		}
+		if err := stream.Send(ack); err != nil {
+			return err
+		}
+	}
+}
+
+func (f *fakeServer) ListConnections(_ context.Context, req *pb.ListConnectionsRequest) (*pb.ConnectionList, error) {
+	f.lastListConnections.Store(req)
+	return &pb.ConnectionList{Connections: []*pb.Connection{
+		{Id: 7, Client: "10.0.0.7:41000", Backend: "db1.internal:5432", AgeMs: 1500},
+	}}, nil
+}
+
+func (f *fakeServer) CloseConnection(_ context.Context, req *pb.CloseConnectionRequest) (*pb.CloseConnectionResponse, error) {
+	if req.Id != 7 {
+		return nil, status.Errorf(codes.NotFound, "no active connection %d", req.Id)
+	}
+	return &pb.CloseConnectionResponse{Connection: &pb.Connection{Id: 7, Client: "10.0.0.7:41000", Backend: "db1.internal:5432"}}, nil
+}
+
 func (f *fakeServer) StreamMetrics(_ *emptypb.Empty, stream grpc.ServerStreamingServer[pb.MetricsData]) error {
 	f.streamOpens.Add(1)
 	if f.streamBehavior != nil {
@@ -45,6 +161,38 @@ func (f *fakeServer) StreamMetrics(_ *emptypb.Empty, stream grpc.ServerStreaming
 	return nil
 }
 
+func (f *fakeServer) GetStats(_ context.Context, _ *emptypb.Empty) (*pb.MetricsData, error) {
+	n := f.getStatsCalls.Add(1)
+	return &pb.MetricsData{ActiveConnections: n}, nil
+}
+
+func (f *fakeServer) ExecuteCommand(_ context.Context, req *pb.CommandRequest) (*pb.CommandResponse, error) {
+	switch req.Name {
+	case "dump_connections":
+		return &pb.CommandResponse{Message: "1 active connections", Output: `[{"id":1}]`}, nil
+	case "broken_output":
+		return &pb.CommandResponse{Output: "{not json"}, nil
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown command %q", req.Name)
+	}
+}
+
+func (f *fakeServer) StreamAccessLogs(_ *emptypb.Empty, stream grpc.ServerStreamingServer[pb.AccessLogRecord]) error {
+	if f.accessLogBehavior != nil {
+		return f.accessLogBehavior(stream)
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+func (f *fakeServer) StreamEvents(_ *emptypb.Empty, stream grpc.ServerStreamingServer[pb.ProxyEvent]) error {
+	if f.eventBehavior != nil {
+		return f.eventBehavior(stream)
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
 type dialerSwitch struct {
 	mu  sync.Mutex
 	lis *bufconn.Listener
@@ -63,7 +211,7 @@ func (d *dialerSwitch) set(lis *bufconn.Listener) {
 	d.mu.Unlock()
 }
 
-func newFakeConn(t *testing.T, srv pb.ProxyControlServer, ds *dialerSwitch) (*Client, *grpc.Server, *bufconn.Listener) {
+func newFakeConn(t *testing.T, srv pb.ProxyControlServer, ds *dialerSwitch, opts ...grpc.DialOption) (*Client, *grpc.Server, *bufconn.Listener) {
 	t.Helper()
 
 	lis := bufconn.Listen(1024 * 1024)
@@ -81,7 +229,7 @@ func newFakeConn(t *testing.T, srv pb.ProxyControlServer, ds *dialerSwitch) (*Cl
 		dial = ds.dial
 	}
 
-	conn, err := grpc.NewClient("passthrough:///bufnet",
+	opts = append([]grpc.DialOption{
 		grpc.WithContextDialer(dial),
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 		grpc.WithConnectParams(grpc.ConnectParams{
@@ -91,7 +239,8 @@ func newFakeConn(t *testing.T, srv pb.ProxyControlServer, ds *dialerSwitch) (*Cl
 				MaxDelay:   100 * time.Millisecond,
 			},
 		}),
-	)
+	}, opts...)
+	conn, err := grpc.NewClient("passthrough:///bufnet", opts...)
 	if err != nil {
 		t.Fatalf("grpc.NewClient: %v", err)
 	}
@@ -125,6 +274,184 @@ func testConfig() *config.Config {
 	}
 }
 
+func TestToProtoConfig_AppliesTrafficSplit(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxy.Backends = []config.Backend{
+		{Address: "localhost:3000", Weight: 100, Pool: "stable"},
+		{Address: "localhost:3001", Weight: 100, Pool: "canary"},
+	}
+	cfg.Proxy.TrafficSplit = map[string]int{"stable": 75, "canary": 25}
+
+	pbCfg := toProtoConfig(cfg)
+	if w0, w1 := pbCfg.Backends[0].Weight, pbCfg.Backends[1].Weight; w0 != 3 || w1 != 1 {
+		t.Errorf("weights: got %d and %d, want 3 and 1", w0, w1)
+	}
+	if cfg.Proxy.Backends[0].Weight != 100 {
+		t.Error("configured weight was modified")
+	}
+}
+
+func TestToProtoConfig_SendsShadowPoolAsMirror(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxy.Backends = []config.Backend{
+		{Address: "localhost:3000", Weight: 100},
+		{Address: "localhost:3001", Weight: 100, Pool: "shadow"},
+		{Address: "localhost:3002", Weight: 100, Pool: "shadow"},
+	}
+	cfg.Proxy.Mirror = config.MirrorConfig{Pool: "shadow", Percent: 10}
+
+	pbCfg := toProtoConfig(cfg)
+	if len(pbCfg.Backends) != 1 || pbCfg.Backends[0].Address != "localhost:3000" {
+		t.Errorf("serving backends: got %v", pbCfg.Backends)
+	}
+	if m := pbCfg.Mirror; m.GetPercent() != 10 || len(m.GetBackends()) != 2 {
+		t.Errorf("mirror: got %v", m)
+	}
+
+	// A reload only mirrors to the shadows not known to be down
+	m := toProtoMirror(cfg.Proxy.Mirror, cfg.Proxy.Backends, map[string]bool{"localhost:3001": false})
+	if len(m.Backends) != 1 || m.Backends[0] != "localhost:3002" {
+		t.Errorf("healthy shadows: got %v", m.Backends)
+	}
+	if toProtoMirror(config.MirrorConfig{}, cfg.Proxy.Backends, nil) != nil {
+		t.Error("mirror set while off")
+	}
+}
+
+func TestToProtoConfig_HTTPRoutes(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxy.HTTPRoutes = []config.HTTPRoute{
+		{Name: "static", Prefix: "/static/"},
+		{Name: "search", Prefix: "/search",
+			Retry: config.RouteRetryPolicy{Attempts: 2, RetryOn: []string{"5xx"}, Idempotent: true},
+			Hedge: config.HedgePolicy{Delay: 75 * time.Millisecond}},
+	}
+
+	routes := toProtoConfig(cfg).HttpRoutes
+	if len(routes) != 2 || routes[0].Retry != nil || routes[0].Hedge != nil {
+		t.Fatalf("routes: got %v", routes)
+	}
+	if r := routes[1]; r.Retry.GetAttempts() != 2 || !r.Retry.GetIdempotent() || r.Hedge.GetDelayMs() != 75 {
+		t.Errorf("hedged route: got %v", r)
+	}
+}
+
+func TestToProtoConfig_HeaderRules(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxy.Backends = []config.Backend{
+		{Address: "localhost:3000", Weight: 100},
+		{Address: "localhost:3001", Weight: 100, Pool: "staging"},
+	}
+	cfg.Proxy.HeaderRules = []config.HeaderRule{{
+		Name:  "debug",
+		Match: []config.HeaderMatch{{Header: "X-Debug", Exact: "1"}, {Header: "User-Agent", Regex: "^internal-.*"}},
+		Pool:  "staging",
+	}}
+
+	pbCfg := toProtoConfig(cfg)
+	if len(pbCfg.Backends) != 1 || pbCfg.Backends[0].Address != "localhost:3000" {
+		t.Errorf("serving backends: got %v", pbCfg.Backends)
+	}
+	if len(pbCfg.HeaderRules) != 1 {
+		t.Fatalf("rules: got %v", pbCfg.HeaderRules)
+	}
+	r := pbCfg.HeaderRules[0]
+	if r.Name != "debug" || len(r.Matches) != 2 || r.Matches[1].Regex != "^internal-.*" || len(r.Backends) != 1 || !r.Backends[0].Healthy {
+		t.Errorf("rule: got %v", r)
+	}
+
+	// A reload carries the rule backends' health
+	rules := toProtoHeaderRules(cfg.Proxy.HeaderRules, cfg.Proxy.Backends, map[string]bool{"localhost:3001": false})
+	if b := rules[0].Backends[0]; b.Address != "localhost:3001" || b.Healthy {
+		t.Errorf("rule backend after reload: got %v", b)
+	}
+}
+
+func TestToProtoConfig_SNIRoutes(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxy.Backends = []config.Backend{
+		{Address: "localhost:3000", Weight: 100},
+		{Address: "localhost:3001", Weight: 100, Pool: "api"},
+	}
+	cfg.Proxy.SNIRoutes = []config.SNIRoute{{ServerNames: []string{"api.example.com", "*.api.example.com"}, Pool: "api"}}
+
+	pbCfg := toProtoConfig(cfg)
+	if len(pbCfg.Backends) != 1 || pbCfg.Backends[0].Address != "localhost:3000" {
+		t.Errorf("serving backends: got %v", pbCfg.Backends)
+	}
+	if len(pbCfg.SniRoutes) != 1 || len(pbCfg.SniRoutes[0].ServerNames) != 2 || pbCfg.SniRoutes[0].Backends[0].Address != "localhost:3001" {
+		t.Fatalf("routes: got %v", pbCfg.SniRoutes)
+	}
+	if got := strings.Join(requiredFeatures(cfg), ","); !strings.Contains(got, "sni_routes") {
+		t.Errorf("features: got %s, want sni_routes", got)
+	}
+}
+
+func TestToProtoConfig_GeoRoutes(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxy.Backends = []config.Backend{
+		{Address: "localhost:3000", Weight: 100},
+		{Address: "localhost:3001", Weight: 100, Pool: "eu"},
+	}
+	cfg.Proxy.GeoRoutes = []config.GeoRoute{{
+		Name: "eu", Continents: []string{"EU"}, Pool: "eu",
+		Networks: []netip.Prefix{netip.MustParsePrefix("1.2.3.0/24"), netip.MustParsePrefix("2001:db8::/32")},
+	}}
+
+	pbCfg := toProtoConfig(cfg)
+	if len(pbCfg.Backends) != 1 || pbCfg.Backends[0].Address != "localhost:3000" {
+		t.Errorf("serving backends: got %v", pbCfg.Backends)
+	}
+	if len(pbCfg.GeoRoutes) != 1 || strings.Join(pbCfg.GeoRoutes[0].Networks, " ") != "1.2.3.0/24 2001:db8::/32" ||
+		pbCfg.GeoRoutes[0].Backends[0].Address != "localhost:3001" {
+		t.Fatalf("routes: got %v", pbCfg.GeoRoutes)
+	}
+	if got := strings.Join(requiredFeatures(cfg), ","); !strings.Contains(got, "geo_routes") {
+		t.Errorf("features: got %s, want geo_routes", got)
+	}
+}
+
+func TestToProtoDarkLaunches(t *testing.T) {
+	backends := []config.Backend{
+		{Address: "localhost:3000", Weight: 100},
+		{Address: "localhost:3001", Weight: 100, Pool: "beta"},
+	}
+	rules := []config.DarkLaunchRule{
+		{Name: "checkout", Pool: "beta", Percent: 5, Windows: []config.TimeWindow{{Start: "09:00", End: "17:00"}}},
+		{Name: "search", Pool: "beta", Percent: 10},
+	}
+	evening := time.Date(2026, 10, 14, 20, 0, 0, 0, time.UTC)
+	got := toProtoDarkLaunches(rules, backends, map[string]bool{"localhost:3001": false}, evening)
+	if len(got) != 1 || got[0].Name != "search" || got[0].Percent != 10 || len(got[0].Backends) != 1 || got[0].Backends[0].Healthy {
+		t.Fatalf("evening: got %v", got)
+	}
+	if got := toProtoDarkLaunches(rules, backends, nil, evening.Add(-8*time.Hour)); len(got) != 2 {
+		t.Errorf("noon: got %v", got)
+	}
+
+	cfg := testConfig()
+	cfg.Proxy.Backends = backends
+	cfg.Proxy.DarkLaunches = rules
+	if pbCfg := toProtoConfig(cfg); len(pbCfg.Backends) != 1 || pbCfg.Backends[0].Address != "localhost:3000" {
+		t.Errorf("serving backends: got %v", pbCfg.Backends)
+	}
+	if got := strings.Join(requiredFeatures(cfg), ","); !strings.Contains(got, "dark_launches") {
+		t.Errorf("features: got %s, want dark_launches", got)
+	}
+}
+
+func TestToProtoConfig_ConnectionLimit(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxy.Traffic.ConnectionLimit = config.ConnectionLimitConfig{
+		Max: 500, Overflow: config.OverflowQueue, QueueSize: 50, QueueTimeout: 2 * time.Second,
+	}
+
+	l := toProtoConfig(cfg).Traffic.ConnectionLimit
+	if l.GetMax() != 500 || l.GetOverflow() != "queue" || l.GetQueueSize() != 50 || l.GetQueueTimeoutMs() != 2000 {
+		t.Errorf("connection limit: got %v", l)
+	}
+}
+
 func TestUpdateConfig_StoresLastCfgOnSuccess(t *testing.T) {
 	srv := &fakeServer{}
 	c, _, _ := newFakeConn(t, srv, nil)
@@ -161,6 +488,21 @@ func TestUpdateConfig_LeavesLastCfgUnsetOnRejection(t *testing.T) {
 	}
 }
 
+func TestCallOptions_GzipAndSizeLimits(t *testing.T) {
+	gz, _, _ := newFakeConn(t, &fakeServer{}, nil,
+		grpc.WithDefaultCallOptions(callOptions(config.GRPCConfig{Compression: "gzip"})...))
+	if err := gz.UpdateConfig(testConfig()); err != nil {
+		t.Errorf("UpdateConfig over gzip: %v", err)
+	}
+
+	tiny, _, _ := newFakeConn(t, &fakeServer{}, nil,
+		grpc.WithDefaultCallOptions(callOptions(config.GRPCConfig{MaxSendMsgSize: 8})...))
+	err := tiny.UpdateConfig(testConfig())
+	if status.Code(errors.Unwrap(err)) != codes.ResourceExhausted {
+		t.Errorf("UpdateConfig over the send limit: got %v, want ResourceExhausted", err)
+	}
+}
+
 func TestWatchReconnect_RepushesConfigAfterReconnect(t *testing.T) {
 	ds := &dialerSwitch{}
 	srv1 := &fakeServer{}
@@ -226,7 +568,8 @@ func TestStreamMetrics_ReconnectsOnStreamError(t *testing.T) {
 	}
 	c, _, _ := newFakeConn(t, srv, nil)
 
-	c.StreamMetrics(newTestCollector())
+	ms := c.StreamMetrics(context.Background(), newTestCollector())
+	defer ms.Stop()
 
 	select {
 	case <-done:
@@ -238,3 +581,88 @@ func TestStreamMetrics_ReconnectsOnStreamError(t *testing.T) {
 		t.Errorf("expected at least 2 StreamMetrics calls (initial + reconnect), got %d", got)
 	}
 }
+
+func TestStreamAccessLogs_ForwardsRecordsAndStopsOnCancel(t *testing.T) {
+	srv := &fakeServer{
+		accessLogBehavior: func(stream grpc.ServerStreamingServer[pb.AccessLogRecord]) error {
+			_ = stream.Send(&pb.AccessLogRecord{Backend: "localhost:3000", BytesSent: 7})
+			<-stream.Context().Done()
+			return nil
+		},
+	}
+	c, _, _ := newFakeConn(t, srv, nil)
+
+	path := filepath.Join(t.TempDir(), "access.log")
+	forwarder, err := accesslog.NewForwarder(config.AccessLogConfig{
+		BufferSize: 10,
+		Overflow:   "block",
+		Sinks:      []config.AccessLogSink{{Type: "file", Path: path}},
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewForwarder: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := c.StreamAccessLogs(ctx, forwarder)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := os.ReadFile(path)
+		if strings.Contains(string(data), `"backend":"localhost:3000"`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			cancel()
+			t.Fatal("access log record never reached the sink")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("StreamAccessLogs did not exit after context cancellation")
+	}
+	if err := forwarder.Close(); err != nil {
+		t.Errorf("forwarder.Close: %v", err)
+	}
+}
+
+func TestStreamEvents_DispatchesEventsAndStopsOnCancel(t *testing.T) {
+	srv := &fakeServer{
+		eventBehavior: func(stream grpc.ServerStreamingServer[pb.ProxyEvent]) error {
+			_ = stream.Send(&pb.ProxyEvent{Type: pb.EventType_CIRCUIT_OPENED, Backend: "localhost:3000"})
+			<-stream.Context().Done()
+			return nil
+		},
+	}
+	c, _, _ := newFakeConn(t, srv, nil)
+
+	delivered := make(chan struct{}, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- struct{}{}
+	}))
+	defer hook.Close()
+	dispatcher := events.NewDispatcher(config.EventsConfig{
+		Webhooks: []config.WebhookConfig{{URL: hook.URL, Timeout: time.Second}},
+	}, zap.NewNop())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := c.StreamEvents(ctx, dispatcher)
+
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		cancel()
+		t.Fatal("event never reached the webhook")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("StreamEvents did not exit after context cancellation")
+	}
+	dispatcher.Close()
+}
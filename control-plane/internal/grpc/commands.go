@@ -0,0 +1,37 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+)
+
+// CommandResult is the data plane's reply to an operator command.
+type CommandResult struct {
+	Message string `json:"message"`
+	// Output is command-specific JSON (dump_connections returns the
+	// connection table); omitted when the command produces none.
+	Output json.RawMessage `json:"output,omitempty"`
+}
+
+// ExecuteCommand runs an operator command (flush_stats, dump_connections,
+// debug_logging, pause_traffic, resume_traffic, circuit_breakers,
+// reset_circuit) on the data plane. Unknown commands and bad arguments come
+// back as codes.InvalidArgument.
+func (c *Client) ExecuteCommand(ctx context.Context, name string, args map[string]string) (*CommandResult, error) {
+	resp, err := c.client.ExecuteCommand(ctx, &pb.CommandRequest{Name: name, Args: args})
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute command %q: %w", name, err)
+	}
+
+	result := &CommandResult{Message: resp.Message}
+	if resp.Output != "" {
+		if !json.Valid([]byte(resp.Output)) {
+			return nil, fmt.Errorf("command %q returned invalid JSON output", name)
+		}
+		result.Output = json.RawMessage(resp.Output)
+	}
+	return result, nil
+}
@@ -0,0 +1,31 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestExecuteCommand(t *testing.T) {
+	c, _, _ := newFakeConn(t, &fakeServer{}, nil)
+	ctx := context.Background()
+
+	res, err := c.ExecuteCommand(ctx, "dump_connections", nil)
+	if err != nil {
+		t.Fatalf("dump_connections: %v", err)
+	}
+	if res.Message != "1 active connections" || string(res.Output) != `[{"id":1}]` {
+		t.Errorf("result: got %+v", res)
+	}
+
+	if _, err := c.ExecuteCommand(ctx, "broken_output", nil); err == nil {
+		t.Error("expected error for non-JSON output")
+	}
+
+	_, err = c.ExecuteCommand(ctx, "nope", nil)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("unknown command: got %v, want InvalidArgument through the wrapped error", err)
+	}
+}
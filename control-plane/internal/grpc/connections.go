@@ -0,0 +1,125 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"slices"
+	"time"
+
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// connectionsFeature is advertised in Hello by data planes that implement
+// ListConnections and CloseConnection.
+const connectionsFeature = "connections"
+
+// Connection is one active TCP connection through the data plane.
+type Connection struct {
+	ID     uint64 `json:"id"`
+	Client string `json:"client"`
+	// Backend is empty until the data plane has picked one.
+	Backend string        `json:"backend"`
+	Age     time.Duration `json:"age"`
+}
+
+// ConnectionFilter narrows ListConnections; empty fields match everything.
+type ConnectionFilter struct {
+	Backend  string
+	ClientIP netip.Addr
+}
+
+func (f ConnectionFilter) match(c Connection) bool {
+	if f.Backend != "" && c.Backend != f.Backend {
+		return false
+	}
+	if f.ClientIP.IsValid() {
+		addr, err := netip.ParseAddrPort(c.Client)
+		if err != nil || addr.Addr().Unmap() != f.ClientIP.Unmap() {
+			return false
+		}
+	}
+	return true
+}
+
+// ListConnections returns the data plane's active connections, oldest
+// first. Data planes without ListConnections are asked for their whole
+// table with the dump_connections command and filtered here.
+func (c *Client) ListConnections(ctx context.Context, filter ConnectionFilter) ([]Connection, error) {
+	if !c.supportsConnections() {
+		return c.dumpConnections(ctx, filter)
+	}
+
+	req := &pb.ListConnectionsRequest{Backend: filter.Backend}
+	if filter.ClientIP.IsValid() {
+		req.ClientIp = filter.ClientIP.String()
+	}
+	resp, err := c.client.ListConnections(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list connections: %w", err)
+	}
+	conns := make([]Connection, 0, len(resp.Connections))
+	for _, pc := range resp.Connections {
+		conns = append(conns, connectionFromProto(pc))
+	}
+	return conns, nil
+}
+
+func (c *Client) dumpConnections(ctx context.Context, filter ConnectionFilter) ([]Connection, error) {
+	result, err := c.ExecuteCommand(ctx, "dump_connections", nil)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ID      uint64 `json:"id"`
+		Client  string `json:"client"`
+		Backend string `json:"backend"`
+		AgeMs   int64  `json:"age_ms"`
+	}
+	if err := json.Unmarshal(result.Output, &rows); err != nil {
+		return nil, fmt.Errorf("invalid dump_connections output: %w", err)
+	}
+	conns := []Connection{}
+	for _, r := range rows {
+		conn := Connection{ID: r.ID, Client: r.Client, Backend: r.Backend, Age: time.Duration(r.AgeMs) * time.Millisecond}
+		if filter.match(conn) {
+			conns = append(conns, conn)
+		}
+	}
+	return conns, nil
+}
+
+// CloseConnection forcibly closes an active connection and returns it as
+// it was. Unknown IDs come back as codes.NotFound; data planes without
+// CloseConnection as codes.Unimplemented.
+func (c *Client) CloseConnection(ctx context.Context, id uint64) (*Connection, error) {
+	if !c.supportsConnections() {
+		return nil, status.Error(codes.Unimplemented, "the data plane does not support closing connections")
+	}
+	resp, err := c.client.CloseConnection(ctx, &pb.CloseConnectionRequest{Id: id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to close connection %d: %w", id, err)
+	}
+	conn := Connection{ID: id}
+	if resp.Connection != nil {
+		conn = connectionFromProto(resp.Connection)
+	}
+	return &conn, nil
+}
+
+func (c *Client) supportsConnections() bool {
+	peer := c.DataPlaneInfo()
+	return peer != nil && !peer.Legacy && slices.Contains(peer.Features, connectionsFeature)
+}
+
+func connectionFromProto(pc *pb.Connection) Connection {
+	return Connection{
+		ID:      pc.Id,
+		Client:  pc.Client,
+		Backend: pc.Backend,
+		Age:     time.Duration(pc.AgeMs) * time.Millisecond,
+	}
+}
@@ -0,0 +1,73 @@
+package grpc
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestListConnections_UsesRPCWhenAdvertised(t *testing.T) {
+	srv := &fakeServer{helloFeatures: []string{"lb:round_robin", connectionsFeature}}
+	c, _, _ := newFakeConn(t, srv, nil)
+	if err := c.UpdateConfig(testConfig()); err != nil {
+		t.Fatalf("UpdateConfig: %v", err)
+	}
+	ctx := context.Background()
+
+	conns, err := c.ListConnections(ctx, ConnectionFilter{Backend: "db1.internal:5432", ClientIP: netip.MustParseAddr("10.0.0.7")})
+	if err != nil {
+		t.Fatalf("ListConnections: %v", err)
+	}
+	if len(conns) != 1 || conns[0].ID != 7 || conns[0].Age != 1500*time.Millisecond {
+		t.Errorf("connections: got %+v", conns)
+	}
+	if req := srv.lastListConnections.Load(); req == nil || req.Backend != "db1.internal:5432" || req.ClientIp != "10.0.0.7" {
+		t.Errorf("filter not sent to the data plane: %v", req)
+	}
+
+	closed, err := c.CloseConnection(ctx, 7)
+	if err != nil || closed.Client != "10.0.0.7:41000" {
+		t.Errorf("CloseConnection: got %+v, %v", closed, err)
+	}
+	if _, err := c.CloseConnection(ctx, 8); status.Code(err) != codes.NotFound {
+		t.Errorf("unknown connection: got %v, want NotFound", err)
+	}
+}
+
+func TestListConnections_FallsBackToDumpConnections(t *testing.T) {
+	srv := &fakeServer{helloFeatures: []string{"lb:round_robin"}}
+	c, _, _ := newFakeConn(t, srv, nil)
+	if err := c.UpdateConfig(testConfig()); err != nil {
+		t.Fatalf("UpdateConfig: %v", err)
+	}
+	ctx := context.Background()
+
+	conns, err := c.ListConnections(ctx, ConnectionFilter{})
+	if err != nil || len(conns) != 1 || conns[0].ID != 1 {
+		t.Fatalf("fallback listing: got %+v, %v", conns, err)
+	}
+	if srv.lastListConnections.Load() != nil {
+		t.Error("ListConnections RPC called on a data plane that doesn't advertise it")
+	}
+	if conns, _ := c.ListConnections(ctx, ConnectionFilter{Backend: "elsewhere:1"}); len(conns) != 0 {
+		t.Errorf("fallback filter: got %+v", conns)
+	}
+	if _, err := c.CloseConnection(ctx, 1); status.Code(err) != codes.Unimplemented {
+		t.Errorf("close without support: got %v, want Unimplemented", err)
+	}
+}
+
+func TestConnectionFilter_MatchesClientIP(t *testing.T) {
+	f := ConnectionFilter{ClientIP: netip.MustParseAddr("::1")}
+	if !f.match(Connection{Client: "[::1]:5000"}) || f.match(Connection{Client: "127.0.0.1:5000"}) {
+		t.Error("IPv6 client filter")
+	}
+	f = ConnectionFilter{ClientIP: netip.MustParseAddr("10.0.0.7")}
+	if f.match(Connection{Client: "10.0.0.70:41000"}) || f.match(Connection{Client: "garbage"}) {
+		t.Error("client filter matched the wrong address")
+	}
+}
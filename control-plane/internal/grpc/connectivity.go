@@ -0,0 +1,34 @@
+package grpc
+
+import "time"
+
+// Connectivity describes whether the control plane is actually in contact
+// with the data plane, as reported by GET /health.
+type Connectivity struct {
+	// State is the gRPC connection state: IDLE, CONNECTING, READY,
+	// TRANSIENT_FAILURE or SHUTDOWN.
+	State string `json:"state"`
+	// LastConfigPush is when a config was last applied successfully.
+	LastConfigPush *time.Time `json:"last_config_push,omitempty"`
+	// LastMetrics is when the last metrics snapshot arrived, by stream or
+	// poll.
+	LastMetrics *time.Time `json:"last_metrics,omitempty"`
+}
+
+// Connectivity snapshots the connection state and the time of the last
+// successful config push and metrics snapshot. Times are nil until the
+// first one happens.
+func (c *Client) Connectivity() Connectivity {
+	info := Connectivity{State: c.conn.GetState().String()}
+	if ns := c.lastPush.Load(); ns != 0 {
+		t := time.Unix(0, ns).UTC()
+		info.LastConfigPush = &t
+	}
+	if s := c.metrics.Load(); s != nil {
+		if t := s.LastReceived(); !t.IsZero() {
+			t = t.UTC()
+			info.LastMetrics = &t
+		}
+	}
+	return info
+}
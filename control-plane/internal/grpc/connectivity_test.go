@@ -0,0 +1,39 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestConnectivity_TracksPushesAndMetrics(t *testing.T) {
+	c, _, _ := newFakeConn(t, &fakeServer{}, nil)
+
+	info := c.Connectivity()
+	if info.LastConfigPush != nil || info.LastMetrics != nil {
+		t.Fatalf("fresh client reports activity: %+v", info)
+	}
+
+	if err := c.UpdateConfig(testConfig()); err != nil {
+		t.Fatalf("UpdateConfig: %v", err)
+	}
+	collector := &recordingUpdater{}
+	s := c.PollMetrics(context.Background(), 10*time.Millisecond, collector)
+	defer s.Stop()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for c.Connectivity().LastMetrics == nil && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	info = c.Connectivity()
+	if info.State != "READY" {
+		t.Errorf("state: got %q, want READY", info.State)
+	}
+	if info.LastConfigPush == nil {
+		t.Error("last_config_push not set after a successful UpdateConfig")
+	}
+	if info.LastMetrics == nil {
+		t.Error("last_metrics not set after a polled snapshot")
+	}
+}
@@ -0,0 +1,207 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/version"
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// controlPlaneFeatures is every feature requiredFeatures can ask for; it's
+// sent in Hello so the data plane can log what it's missing.
+var controlPlaneFeatures = []string{
+	"lb:round_robin",
+	"lb:weighted_round_robin",
+	"lb:weighted",
+	"lb:least_connections",
+	"lb:consistent_hash",
+	"lb:random",
+	"lb:latency_ewma",
+	"hash_key:header",
+	"hash_key:cookie",
+	"hash_method:maglev",
+	"backend_priority",
+	"backend_backup",
+	"locality:prefer_local",
+	"mirror",
+	"http_routes",
+	"header_rules",
+	"sni_routes",
+	"geo_routes",
+	"dark_launches",
+	"session_affinity",
+	"udp_affinity",
+	"sticky_cookie",
+	"udp",
+	"read_timeout",
+	"connection_limit",
+	"tls_termination",
+}
+
+// DataPlaneInfo is the result of the Hello handshake, as shown in /status.
+type DataPlaneInfo struct {
+	Version  string   `json:"version"`
+	Features []string `json:"features"`
+	// Legacy means the data plane predates Hello. Its features are unknown,
+	// so config pushes to it aren't checked.
+	Legacy bool `json:"legacy,omitempty"`
+	// Unsupported lists the features the last rejected config needed that
+	// the data plane lacks; empty once a config is accepted.
+	Unsupported []string `json:"unsupported,omitempty"`
+}
+
+// requiredFeatures lists the data plane features cfg depends on.
+func requiredFeatures(cfg *config.Config) []string {
+	var features []string
+	if alg := cfg.Proxy.LoadBalancing.Algorithm; alg != "" {
+		features = append(features, "lb:"+alg)
+	}
+	if lb := cfg.Proxy.LoadBalancing; lb.Algorithm == config.AlgorithmConsistentHash {
+		if lb.Hash.Key != "" && lb.Hash.Key != config.HashKeySourceIP {
+			features = append(features, "hash_key:"+lb.Hash.Key)
+		}
+		if lb.Hash.Method == config.HashMethodMaglev {
+			features = append(features, "hash_method:maglev")
+		}
+	}
+	if config.HasPriorities(cfg.Proxy.Backends) || config.HasPriorities(cfg.Proxy.UdpBackends) {
+		features = append(features, "backend_priority")
+	}
+	if config.HasBackups(cfg.Proxy.Backends) || config.HasBackups(cfg.Proxy.UdpBackends) {
+		features = append(features, "backend_backup")
+	}
+	if p := cfg.Proxy.LoadBalancing.Locality.Policy; p != "" {
+		features = append(features, "locality:"+p)
+	}
+	if cfg.Proxy.Mirror.Pool != "" {
+		features = append(features, "mirror")
+	}
+	// A retry policy is a route setting, whether a route's or the default
+	if len(cfg.Proxy.HTTPRoutes) > 0 || cfg.Proxy.Traffic.Retry.Attempts > 1 {
+		features = append(features, "http_routes")
+	}
+	if len(cfg.Proxy.HeaderRules) > 0 {
+		features = append(features, "header_rules")
+	}
+	if len(cfg.Proxy.SNIRoutes) > 0 {
+		features = append(features, "sni_routes")
+	}
+	if len(cfg.Proxy.GeoRoutes) > 0 {
+		features = append(features, "geo_routes")
+	}
+	if len(cfg.Proxy.DarkLaunches) > 0 {
+		features = append(features, "dark_launches")
+	}
+	if cfg.Proxy.LoadBalancing.SessionAffinity {
+		features = append(features, "session_affinity")
+	}
+	if cfg.Proxy.LoadBalancing.UDPAffinity.Enabled() {
+		features = append(features, "udp_affinity")
+	}
+	if cfg.Proxy.LoadBalancing.StickyCookie.Enabled() {
+		features = append(features, "sticky_cookie")
+	}
+	if cfg.Proxy.Listen.UDP != "" || len(cfg.Proxy.UdpBackends) > 0 {
+		features = append(features, "udp")
+	}
+	if cfg.Proxy.Traffic.Timeout.Read > 0 {
+		features = append(features, "read_timeout")
+	}
+	if cfg.Proxy.Traffic.ConnectionLimit.Max > 0 {
+		features = append(features, "connection_limit")
+	}
+	if cfg.Proxy.TLS.Enabled() {
+		features = append(features, "tls_termination")
+	}
+	return features
+}
+
+// hello performs the handshake and caches the result until the next
+// reconnect. A data plane without the RPC is recorded as legacy rather
+// than treated as an error, so old data planes keep working.
+func (c *Client) hello(ctx context.Context) (*DataPlaneInfo, error) {
+	c.peerMu.Lock()
+	defer c.peerMu.Unlock()
+	if c.peer != nil {
+		return c.peer, nil
+	}
+
+	resp, err := c.client.Hello(ctx, &pb.HelloRequest{
+		Version:  version.Version,
+		Features: controlPlaneFeatures,
+	})
+	if status.Code(err) == codes.Unimplemented {
+		c.logger.Warn("Data plane does not support the Hello handshake; skipping feature checks")
+		c.peer = &DataPlaneInfo{Legacy: true}
+		return c.peer, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to handshake with data plane: %w", err)
+	}
+
+	features := slices.Clone(resp.Features)
+	sort.Strings(features)
+	c.peer = &DataPlaneInfo{Version: resp.Version, Features: features}
+	c.logger.Info("Data plane handshake complete",
+		zap.String("data_plane_version", resp.Version),
+		zap.Strings("features", features))
+	return c.peer, nil
+}
+
+// checkFeatures returns an error naming every feature cfg needs that the
+// data plane didn't advertise, and records them for DataPlaneInfo.
+func (c *Client) checkFeatures(cfg *config.Config) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	peer, err := c.hello(ctx)
+	if err != nil {
+		return err
+	}
+	if peer.Legacy {
+		return nil
+	}
+
+	var missing []string
+	for _, f := range requiredFeatures(cfg) {
+		if !slices.Contains(peer.Features, f) {
+			missing = append(missing, f)
+		}
+	}
+
+	c.peerMu.Lock()
+	peer.Unsupported = missing
+	c.peerMu.Unlock()
+
+	if len(missing) > 0 {
+		return fmt.Errorf("data plane %s does not support: %s", peer.Version, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// DataPlaneInfo reports the last handshake, or nil if none has happened.
+func (c *Client) DataPlaneInfo() *DataPlaneInfo {
+	c.peerMu.Lock()
+	defer c.peerMu.Unlock()
+	if c.peer == nil {
+		return nil
+	}
+	info := *c.peer
+	return &info
+}
+
+// resetHandshake forces the next config push to handshake again; called on
+// reconnect because the data plane may have been upgraded in between.
+func (c *Client) resetHandshake() {
+	c.peerMu.Lock()
+	c.peer = nil
+	c.peerMu.Unlock()
+}
@@ -0,0 +1,171 @@
+package grpc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+)
+
+func TestUpdateConfig_RejectsUnsupportedFeatures(t *testing.T) {
+	srv := &fakeServer{helloFeatures: []string{"lb:round_robin"}}
+	c, _, _ := newFakeConn(t, srv, nil)
+
+	cfg := testConfig()
+	cfg.Proxy.LoadBalancing.Algorithm = "consistent_hash"
+	cfg.Proxy.LoadBalancing.SessionAffinity = true
+
+	err := c.UpdateConfig(cfg)
+	if err == nil {
+		t.Fatal("expected UpdateConfig to refuse a config the data plane can't honour")
+	}
+	if !strings.Contains(err.Error(), "lb:consistent_hash, session_affinity") {
+		t.Errorf("error should name the missing features, got: %v", err)
+	}
+	if srv.updateConfigCalls.Load() != 0 {
+		t.Error("config was pushed despite the feature mismatch")
+	}
+
+	info := c.DataPlaneInfo()
+	if info == nil || info.Version != "9.9.9" || len(info.Unsupported) != 2 {
+		t.Errorf("DataPlaneInfo: got %+v", info)
+	}
+
+	cfg.Proxy.LoadBalancing.Algorithm = "round_robin"
+	cfg.Proxy.LoadBalancing.SessionAffinity = false
+	if err := c.UpdateConfig(cfg); err != nil {
+		t.Fatalf("supported config rejected: %v", err)
+	}
+	if got := c.DataPlaneInfo().Unsupported; len(got) != 0 {
+		t.Errorf("Unsupported should clear after an accepted push, got %v", got)
+	}
+	if n := srv.helloCalls.Load(); n != 1 {
+		t.Errorf("Hello calls: got %d, want 1 (cached until reconnect)", n)
+	}
+}
+
+func TestUpdateConfig_LegacyDataPlaneSkipsFeatureCheck(t *testing.T) {
+	srv := &fakeServer{}
+	c, _, _ := newFakeConn(t, srv, nil)
+
+	cfg := testConfig()
+	cfg.Proxy.LoadBalancing.Algorithm = "consistent_hash"
+	if err := c.UpdateConfig(cfg); err != nil {
+		t.Fatalf("UpdateConfig against a data plane without Hello: %v", err)
+	}
+	if info := c.DataPlaneInfo(); info == nil || !info.Legacy {
+		t.Errorf("DataPlaneInfo: got %+v, want legacy", info)
+	}
+}
+
+func TestRequiredFeatures_HashKey(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxy.LoadBalancing.Algorithm = "consistent_hash"
+	cfg.Proxy.LoadBalancing.Hash.Key = "cookie"
+	if got := strings.Join(requiredFeatures(cfg), ","); !strings.Contains(got, "hash_key:cookie") {
+		t.Errorf("features: got %s, want hash_key:cookie", got)
+	}
+
+	cfg.Proxy.LoadBalancing.Hash.Method = "maglev"
+	if got := strings.Join(requiredFeatures(cfg), ","); !strings.Contains(got, "hash_method:maglev") {
+		t.Errorf("features: got %s, want hash_method:maglev", got)
+	}
+
+	// Only consistent_hash reads the key and method
+	cfg.Proxy.LoadBalancing.Algorithm = "round_robin"
+	if got := strings.Join(requiredFeatures(cfg), ","); strings.Contains(got, "hash_key") {
+		t.Errorf("features: got %s", got)
+	}
+}
+
+func TestRequiredFeatures_BackendPriority(t *testing.T) {
+	cfg := testConfig()
+	if got := strings.Join(requiredFeatures(cfg), ","); strings.Contains(got, "backend_priority") {
+		t.Errorf("features without priorities: got %s", got)
+	}
+	cfg.Proxy.Backends = append(cfg.Proxy.Backends, config.Backend{Address: "standby:3000", Priority: 1})
+	if got := strings.Join(requiredFeatures(cfg), ","); !strings.Contains(got, "backend_priority") {
+		t.Errorf("features: got %s, want backend_priority", got)
+	}
+}
+
+func TestRequiredFeatures_Mirror(t *testing.T) {
+	cfg := testConfig()
+	if got := strings.Join(requiredFeatures(cfg), ","); strings.Contains(got, "mirror") {
+		t.Errorf("features without a mirror: got %s", got)
+	}
+	cfg.Proxy.Mirror = config.MirrorConfig{Pool: "shadow"}
+	if got := strings.Join(requiredFeatures(cfg), ","); !strings.Contains(got, "mirror") {
+		t.Errorf("features: got %s, want mirror", got)
+	}
+}
+
+func TestRequiredFeatures_HTTPRoutes(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxy.HTTPRoutes = []config.HTTPRoute{{Name: "api", Prefix: "/api/"}}
+	if got := strings.Join(requiredFeatures(cfg), ","); !strings.Contains(got, "http_routes") {
+		t.Errorf("features: got %s, want http_routes", got)
+	}
+
+	// A default retry policy needs an HTTP proxy as much as a route does
+	cfg = testConfig()
+	cfg.Proxy.Traffic.Retry = config.RouteRetryPolicy{Attempts: 3}
+	if got := strings.Join(requiredFeatures(cfg), ","); !strings.Contains(got, "http_routes") {
+		t.Errorf("features with traffic.retry: got %s, want http_routes", got)
+	}
+}
+
+func TestRequiredFeatures_HeaderRules(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxy.HeaderRules = []config.HeaderRule{{Name: "debug", Pool: "staging"}}
+	if got := strings.Join(requiredFeatures(cfg), ","); !strings.Contains(got, "header_rules") {
+		t.Errorf("features: got %s, want header_rules", got)
+	}
+}
+
+func TestRequiredFeatures_UDPAffinity(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxy.LoadBalancing.UDPAffinity = config.UDPAffinityConfig{Mode: config.UDPAffinitySourceIP, IdleTimeout: 5 * time.Minute}
+	if got := strings.Join(requiredFeatures(cfg), ","); !strings.Contains(got, "udp_affinity") {
+		t.Errorf("features: got %s, want udp_affinity", got)
+	}
+	if a := toProtoConfig(cfg).LoadBalancing.UdpAffinity; a.GetMode() != "source_ip" || a.GetIdleTimeoutMs() != 300000 {
+		t.Errorf("udp affinity: got %v", a)
+	}
+}
+
+func TestRequiredFeatures_StickyCookie(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxy.LoadBalancing.StickyCookie = config.StickyCookieConfig{Name: "aegis_backend", TTL: time.Hour, Path: "/", HTTPOnly: true}
+	if got := strings.Join(requiredFeatures(cfg), ","); !strings.Contains(got, "sticky_cookie") {
+		t.Errorf("features: got %s, want sticky_cookie", got)
+	}
+	if sc := toProtoConfig(cfg).LoadBalancing.StickyCookie; sc.GetName() != "aegis_backend" || sc.GetTtlSeconds() != 3600 || !sc.GetHttpOnly() || sc.GetSecure() {
+		t.Errorf("sticky cookie: got %v", sc)
+	}
+}
+
+func TestRequiredFeatures_ConnectionLimit(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxy.Traffic.ConnectionLimit.Overflow = config.OverflowReject
+	if got := strings.Join(requiredFeatures(cfg), ","); strings.Contains(got, "connection_limit") {
+		t.Errorf("features without a limit: got %s", got)
+	}
+	cfg.Proxy.Traffic.ConnectionLimit.Max = 1000
+	if got := strings.Join(requiredFeatures(cfg), ","); !strings.Contains(got, "connection_limit") {
+		t.Errorf("features: got %s, want connection_limit", got)
+	}
+}
+
+func TestRequiredFeatures_Locality(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxy.Backends = append(cfg.Proxy.Backends, config.Backend{Address: "b:3000", Zone: "us-east-1a"})
+	if got := strings.Join(requiredFeatures(cfg), ","); strings.Contains(got, "locality:") {
+		t.Errorf("zones alone need nothing: got %s", got)
+	}
+	cfg.Proxy.LoadBalancing.Locality.Policy = config.LocalityPreferLocal
+	if got := strings.Join(requiredFeatures(cfg), ","); !strings.Contains(got, "locality:prefer_local") {
+		t.Errorf("features: got %s, want locality:prefer_local", got)
+	}
+}
@@ -0,0 +1,40 @@
+package grpc
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	rpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "aegis_dataplane_rpc_duration_seconds",
+		Help:    "Duration of unary RPCs to the data plane, including retries",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})
+	rpcErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aegis_dataplane_rpc_errors_total",
+		Help: "Unary RPCs to the data plane that failed, by gRPC status code",
+	}, []string{"method", "code"})
+)
+
+// metricsInterceptor times every unary call and counts its failures. It
+// sits outside retryInterceptor, so a call that succeeds on a retry is one
+// slow success rather than a failure and a success.
+func metricsInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		name := path.Base(method)
+		rpcDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+		if err != nil {
+			rpcErrors.WithLabelValues(name, status.Code(err).String()).Inc()
+		}
+		return err
+	}
+}
@@ -0,0 +1,40 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMetricsInterceptor_CountsDurationsAndErrors(t *testing.T) {
+	intercept := metricsInterceptor()
+	method := pb.ProxyControl_ReloadBackends_FullMethodName
+	fail := true
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		if fail {
+			return status.Error(codes.Unavailable, "down")
+		}
+		return nil
+	}
+
+	errorsBefore := testutil.ToFloat64(rpcErrors.WithLabelValues("ReloadBackends", "Unavailable"))
+	if err := intercept(context.Background(), method, nil, nil, nil, invoker); status.Code(err) != codes.Unavailable {
+		t.Fatalf("error not passed through: %v", err)
+	}
+	fail = false
+	if err := intercept(context.Background(), method, nil, nil, nil, invoker); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := testutil.ToFloat64(rpcErrors.WithLabelValues("ReloadBackends", "Unavailable")) - errorsBefore; got != 1 {
+		t.Errorf("errors: got %v, want 1", got)
+	}
+	if n := testutil.CollectAndCount(rpcDuration, "aegis_dataplane_rpc_duration_seconds"); n == 0 {
+		t.Error("no duration series recorded")
+	}
+}
@@ -0,0 +1,200 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// metricsBuffer bounds how many snapshots can queue between the stream
+// reader and the collector. Snapshots carry cumulative totals, so when the
+// collector falls behind the oldest queued one is discarded — the next
+// snapshot supersedes it without losing any counts.
+const metricsBuffer = 16
+
+// metricsRetryDelay matches the reconnect delay of the other streams.
+const metricsRetryDelay = 5 * time.Second
+
+var (
+	metricsStreamReconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "aegis_metrics_stream_reconnects_total",
+		Help: "Times the control plane re-opened the data plane metrics stream",
+	})
+	metricsStreamErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "aegis_metrics_stream_errors_total",
+		Help: "Failures opening or reading the data plane metrics stream, or polling GetStats",
+	})
+	metricsStreamDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "aegis_metrics_stream_dropped_total",
+		Help: "Metrics snapshots discarded because the collector fell behind",
+	})
+)
+
+// metricsUpdater is satisfied by *metrics.Collector.
+type metricsUpdater interface {
+	UpdateFromProto(data *pb.MetricsData)
+}
+
+// MetricsStream owns the flow of metrics from the data plane — either the
+// StreamMetrics RPC or GetStats polling. It reconnects whenever the stream
+// fails, hands snapshots to the collector through a bounded buffer so a
+// slow collector never stalls the gRPC reader, and runs until its context
+// is cancelled or Stop is called.
+type MetricsStream struct {
+	client    *Client
+	collector metricsUpdater
+	buf       chan *pb.MetricsData
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	lastReceived atomic.Int64 // unix nanos, 0 until the first snapshot
+}
+
+// StreamMetrics starts streaming metrics from the data plane into
+// collector. Cancelling ctx has the same effect as calling Stop.
+func (c *Client) StreamMetrics(ctx context.Context, collector metricsUpdater) *MetricsStream {
+	s, ctx := c.newMetricsStream(ctx, collector)
+	go s.receive(ctx)
+	return s
+}
+
+// PollMetrics is the fallback for networks whose middleboxes kill
+// long-lived streams: it calls the unary GetStats RPC every interval
+// instead. Cancelling ctx has the same effect as calling Stop.
+func (c *Client) PollMetrics(ctx context.Context, interval time.Duration, collector metricsUpdater) *MetricsStream {
+	s, ctx := c.newMetricsStream(ctx, collector)
+	go s.poll(ctx, interval)
+	return s
+}
+
+// newMetricsStream starts the apply goroutine; the caller starts the
+// producer, which must close s.buf and call s.wg.Done when it returns.
+func (c *Client) newMetricsStream(ctx context.Context, collector metricsUpdater) (*MetricsStream, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &MetricsStream{
+		client:    c,
+		collector: collector,
+		buf:       make(chan *pb.MetricsData, metricsBuffer),
+		cancel:    cancel,
+	}
+	s.wg.Add(2)
+	go s.apply()
+	c.metrics.Store(s)
+	return s, ctx
+}
+
+// Stop closes the stream and waits for both goroutines to exit. Snapshots
+// still in the buffer are applied first. Safe to call more than once.
+func (s *MetricsStream) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// LastReceived reports when the most recent snapshot arrived, or the zero
+// time if none has yet. A stale value means the stream is down or
+// reconnecting.
+func (s *MetricsStream) LastReceived() time.Time {
+	ns := s.lastReceived.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+func (s *MetricsStream) receive(ctx context.Context) {
+	defer s.wg.Done()
+	defer close(s.buf)
+
+	logger := s.client.logger
+	for first := true; ctx.Err() == nil; first = false {
+		if !first {
+			metricsStreamReconnects.Inc()
+		}
+		stream, err := s.client.client.StreamMetrics(ctx, &emptypb.Empty{})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			metricsStreamErrors.Inc()
+			logger.Error("Failed to start metrics stream, retrying in 5s", zap.Error(err))
+			sleepCtx(ctx, metricsRetryDelay)
+			continue
+		}
+		for {
+			data, err := stream.Recv()
+			if err == io.EOF {
+				logger.Info("Metrics stream closed, reconnecting")
+				break
+			}
+			if err != nil {
+				if ctx.Err() == nil {
+					metricsStreamErrors.Inc()
+					logger.Error("Metrics stream error, reconnecting in 5s", zap.Error(err))
+					sleepCtx(ctx, metricsRetryDelay)
+				}
+				break
+			}
+			s.lastReceived.Store(time.Now().UnixNano())
+			s.enqueue(data)
+		}
+	}
+}
+
+func (s *MetricsStream) poll(ctx context.Context, interval time.Duration) {
+	defer s.wg.Done()
+	defer close(s.buf)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		data, err := s.client.client.GetStats(ctx, &emptypb.Empty{})
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			metricsStreamErrors.Inc()
+			s.client.logger.Warn("Failed to poll data plane stats", zap.Error(err))
+		default:
+			s.lastReceived.Store(time.Now().UnixNano())
+			s.enqueue(data)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// enqueue never blocks: with the buffer full it evicts the oldest snapshot.
+// receive is the only sender, so the retry after eviction always succeeds.
+func (s *MetricsStream) enqueue(data *pb.MetricsData) {
+	select {
+	case s.buf <- data:
+		return
+	default:
+	}
+	select {
+	case <-s.buf:
+		metricsStreamDropped.Inc()
+	default:
+	}
+	s.buf <- data
+}
+
+func (s *MetricsStream) apply() {
+	defer s.wg.Done()
+	for data := range s.buf {
+		s.collector.UpdateFromProto(data)
+	}
+}
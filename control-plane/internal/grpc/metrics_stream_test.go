@@ -0,0 +1,130 @@
+package grpc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+)
+
+// blockingUpdater holds every UpdateFromProto call until release is closed.
+type blockingUpdater struct {
+	release chan struct{}
+
+	mu   sync.Mutex
+	seen []int64
+}
+
+func (b *blockingUpdater) UpdateFromProto(data *pb.MetricsData) {
+	<-b.release
+	b.mu.Lock()
+	b.seen = append(b.seen, data.ActiveConnections)
+	b.mu.Unlock()
+}
+
+func TestMetricsStream_SlowCollectorKeepsNewestSnapshots(t *testing.T) {
+	const sent = metricsBuffer * 3
+	srv := &fakeServer{
+		streamBehavior: func(stream grpc.ServerStreamingServer[pb.MetricsData]) error {
+			for i := 1; i <= sent; i++ {
+				_ = stream.Send(&pb.MetricsData{ActiveConnections: int64(i)})
+			}
+			<-stream.Context().Done()
+			return nil
+		},
+	}
+	c, _, _ := newFakeConn(t, srv, nil)
+
+	droppedBefore := testutil.ToFloat64(metricsStreamDropped)
+	updater := &blockingUpdater{release: make(chan struct{})}
+	ms := c.StreamMetrics(context.Background(), updater)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(metricsStreamDropped)-droppedBefore < sent-metricsBuffer-1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("reader stalled behind a blocked collector (dropped=%v)", testutil.ToFloat64(metricsStreamDropped)-droppedBefore)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if ms.LastReceived().IsZero() {
+		t.Error("LastReceived not set after snapshots arrived")
+	}
+
+	close(updater.release)
+	ms.Stop()
+
+	updater.mu.Lock()
+	defer updater.mu.Unlock()
+	if n := len(updater.seen); n == 0 || updater.seen[n-1] != sent {
+		t.Errorf("collector should end on the newest snapshot %d, saw %v", sent, updater.seen)
+	}
+}
+
+func TestMetricsStream_StopsWhenContextCancelled(t *testing.T) {
+	c, _, _ := newFakeConn(t, &fakeServer{
+		streamBehavior: func(stream grpc.ServerStreamingServer[pb.MetricsData]) error {
+			<-stream.Context().Done()
+			return nil
+		},
+	}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ms := c.StreamMetrics(ctx, newTestCollector())
+	cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		ms.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("metrics stream did not stop after its context was cancelled")
+	}
+}
+
+type recordingUpdater struct {
+	mu   sync.Mutex
+	seen []int64
+}
+
+func (r *recordingUpdater) UpdateFromProto(data *pb.MetricsData) {
+	r.mu.Lock()
+	r.seen = append(r.seen, data.ActiveConnections)
+	r.mu.Unlock()
+}
+
+func (r *recordingUpdater) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.seen)
+}
+
+func TestPollMetrics_CallsGetStatsEachInterval(t *testing.T) {
+	srv := &fakeServer{}
+	c, _, _ := newFakeConn(t, srv, nil)
+
+	updater := &recordingUpdater{}
+	ms := c.PollMetrics(context.Background(), 10*time.Millisecond, updater)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for updater.count() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("collector got %d polled snapshots, want >= 3", updater.count())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	ms.Stop()
+
+	if srv.streamOpens.Load() != 0 {
+		t.Error("poll mode must not open StreamMetrics")
+	}
+	if ms.LastReceived().IsZero() {
+		t.Error("LastReceived not set in poll mode")
+	}
+}
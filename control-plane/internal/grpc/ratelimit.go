@@ -0,0 +1,65 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+	"go.uber.org/zap"
+)
+
+// rateLimitFeature is advertised in Hello by data planes that implement
+// UpdateRateLimit.
+const rateLimitFeature = "update_rate_limit"
+
+// UpdateRateLimit changes the global rate limit on the data plane. Data
+// planes with UpdateRateLimit swap the limiter in place; older ones get the
+// last pushed config again with the new limit, which also rebuilds their
+// load balancers. Either way the limit is kept for re-pushes on reconnect.
+func (c *Client) UpdateRateLimit(ctx context.Context, limit config.RateLimitConfig) error {
+	c.cfgMu.Lock()
+	last := c.lastCfg
+	c.cfgMu.Unlock()
+	if last == nil {
+		return errors.New("no config has been pushed to the data plane yet")
+	}
+	next := *last
+	next.Proxy.Traffic.RateLimit = limit
+
+	if !c.supportsRateLimitUpdate() {
+		return ApplyConfig(ctx, &next, []ConfigStager{c})
+	}
+
+	resp, err := c.client.UpdateRateLimit(ctx, &pb.RateLimitConfig{
+		RequestsPerSecond: int32(limit.RequestsPerSecond),
+		Burst:             int32(limit.Burst),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update rate limit: %w", err)
+	}
+	if !resp.Success {
+		return fmt.Errorf("rate limit update failed: %s", resp.Message)
+	}
+
+	c.cfgMu.Lock()
+	// A full push that landed meanwhile already carries its own limit
+	if c.lastCfg == last {
+		c.lastCfg = &next
+	}
+	c.cfgMu.Unlock()
+	c.lastPush.Store(time.Now().UnixNano())
+
+	c.logger.Info("Rate limit updated",
+		zap.Int("requests_per_second", limit.RequestsPerSecond),
+		zap.Int("burst", limit.Burst))
+	return nil
+}
+
+func (c *Client) supportsRateLimitUpdate() bool {
+	peer := c.DataPlaneInfo()
+	return peer != nil && !peer.Legacy && slices.Contains(peer.Features, rateLimitFeature)
+}
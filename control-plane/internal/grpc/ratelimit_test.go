@@ -0,0 +1,59 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+)
+
+func TestUpdateRateLimit_UsesDeltaWhenAdvertised(t *testing.T) {
+	srv := &fakeServer{helloFeatures: []string{"lb:round_robin", rateLimitFeature}}
+	c, _, _ := newFakeConn(t, srv, nil)
+	ctx := context.Background()
+
+	if err := c.UpdateRateLimit(ctx, config.RateLimitConfig{RequestsPerSecond: 50, Burst: 10}); err == nil {
+		t.Fatal("expected error before any config was pushed")
+	}
+	if err := c.UpdateConfig(testConfig()); err != nil {
+		t.Fatalf("UpdateConfig: %v", err)
+	}
+	pushes := srv.updateConfigCalls.Load()
+
+	if err := c.UpdateRateLimit(ctx, config.RateLimitConfig{RequestsPerSecond: 50, Burst: 10}); err != nil {
+		t.Fatalf("UpdateRateLimit: %v", err)
+	}
+	if got := srv.lastRateLimit.Load(); got == nil || got.RequestsPerSecond != 50 || got.Burst != 10 {
+		t.Errorf("UpdateRateLimit RPC: got %v", got)
+	}
+	if n := srv.updateConfigCalls.Load(); n != pushes {
+		t.Errorf("full config pushed despite UpdateRateLimit support")
+	}
+
+	c.cfgMu.Lock()
+	defer c.cfgMu.Unlock()
+	if c.lastCfg.Proxy.Traffic.RateLimit.RequestsPerSecond != 50 {
+		t.Error("reconnect config doesn't carry the new rate limit")
+	}
+}
+
+func TestUpdateRateLimit_FallsBackToFullPush(t *testing.T) {
+	srv := &fakeServer{helloFeatures: []string{"lb:round_robin"}}
+	c, _, _ := newFakeConn(t, srv, nil)
+
+	if err := c.UpdateConfig(testConfig()); err != nil {
+		t.Fatalf("UpdateConfig: %v", err)
+	}
+	if err := c.UpdateRateLimit(context.Background(), config.RateLimitConfig{RequestsPerSecond: 5, Burst: 5}); err != nil {
+		t.Fatalf("UpdateRateLimit: %v", err)
+	}
+	if srv.rateLimitCalls.Load() != 0 || srv.updateConfigCalls.Load() != 2 {
+		t.Errorf("got %d UpdateRateLimit and %d UpdateConfig calls, want 0 and 2",
+			srv.rateLimitCalls.Load(), srv.updateConfigCalls.Load())
+	}
+	c.cfgMu.Lock()
+	defer c.cfgMu.Unlock()
+	if c.lastCfg.Proxy.Traffic.RateLimit.Burst != 5 {
+		t.Error("lastCfg not updated by the fallback push")
+	}
+}
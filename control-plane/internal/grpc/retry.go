@@ -0,0 +1,119 @@
+package grpc
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// idempotentMethods lists the RPCs that are safe to resend: Hello has no
+// side effects, UpdateConfig, ReloadBackends, PrepareConfig and
+// UpdateSecrets replace state wholesale, and the data plane treats a repeated Commit or Abort of the
+// same token as a no-op, so applying any of them twice is the same as once.
+// DrainConnections is deliberately absent — a retried drain restarts the
+// timeout and double-reports drained counts.
+var idempotentMethods = map[string]bool{
+	pb.ProxyControl_Hello_FullMethodName:          true,
+	pb.ProxyControl_UpdateConfig_FullMethodName:   true,
+	pb.ProxyControl_ReloadBackends_FullMethodName: true,
+	pb.ProxyControl_PrepareConfig_FullMethodName:  true,
+	pb.ProxyControl_CommitConfig_FullMethodName:   true,
+	pb.ProxyControl_AbortConfig_FullMethodName:    true,
+	pb.ProxyControl_UpdateSecrets_FullMethodName:  true,
+}
+
+// retryableCodes are the transport-level failures worth retrying. Anything
+// the data plane returned deliberately (InvalidArgument, FailedPrecondition,
+// ...) will fail the same way again.
+var retryableCodes = map[codes.Code]bool{
+	codes.Unavailable: true,
+	codes.Aborted:     true,
+}
+
+// retryBudget is the gRFC A6 retry throttling token bucket.
+type retryBudget struct {
+	mu        sync.Mutex
+	tokens    float64
+	maxTokens float64
+	ratio     float64
+}
+
+func newRetryBudget(maxTokens, ratio float64) *retryBudget {
+	return &retryBudget{tokens: maxTokens, maxTokens: maxTokens, ratio: ratio}
+}
+
+func (b *retryBudget) onSuccess() {
+	b.mu.Lock()
+	b.tokens = min(b.tokens+b.ratio, b.maxTokens)
+	b.mu.Unlock()
+}
+
+// onFailure spends a token and reports whether a retry is still allowed.
+func (b *retryBudget) onFailure() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = max(b.tokens-1, 0)
+	return b.tokens > b.maxTokens/2
+}
+
+// retryDelay returns a full-jitter delay for the given retry (0-based):
+// uniformly random in [0, min(max, initial*2^attempt)).
+func retryDelay(cfg config.RetryConfig, attempt int) time.Duration {
+	d := cfg.InitialBackoff << attempt
+	if d <= 0 || d > cfg.MaxBackoff {
+		d = cfg.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return rand.N(d)
+}
+
+// retryInterceptor retries idempotent unary calls on transient failures,
+// within the caller's context deadline and the shared retry budget.
+func retryInterceptor(cfg config.RetryConfig, logger *zap.Logger) grpc.UnaryClientInterceptor {
+	budget := newRetryBudget(cfg.BudgetMaxTokens, cfg.BudgetTokenRatio)
+
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if !idempotentMethods[method] || cfg.MaxAttempts <= 1 {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		for attempt := 0; ; attempt++ {
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			if err == nil {
+				budget.onSuccess()
+				return nil
+			}
+			if !retryableCodes[status.Code(err)] {
+				return err
+			}
+			if !budget.onFailure() || attempt+1 >= cfg.MaxAttempts {
+				return err
+			}
+
+			delay := retryDelay(cfg, attempt)
+			logger.Warn("Retrying data plane RPC",
+				zap.String("method", method),
+				zap.Int("attempt", attempt+1),
+				zap.Duration("backoff", delay),
+				zap.Error(err))
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type flakyServer struct {
+	pb.UnimplementedProxyControlServer
+
+	failuresLeft atomic.Int64
+	failCode     codes.Code
+	updateCalls  atomic.Int64
+	drainCalls   atomic.Int64
+}
+
+func (f *flakyServer) UpdateConfig(_ context.Context, _ *pb.ProxyConfig) (*pb.ConfigAck, error) {
+	f.updateCalls.Add(1)
+	if f.failuresLeft.Add(-1) >= 0 {
+		return nil, status.Error(f.failCode, "injected failure")
+	}
+	return &pb.ConfigAck{Success: true}, nil
+}
+
+func (f *flakyServer) DrainConnections(_ context.Context, _ *pb.DrainRequest) (*pb.DrainResponse, error) {
+	f.drainCalls.Add(1)
+	if f.failuresLeft.Add(-1) >= 0 {
+		return nil, status.Error(f.failCode, "injected failure")
+	}
+	return &pb.DrainResponse{Success: true}, nil
+}
+
+func testRetryConfig() config.RetryConfig {
+	return config.RetryConfig{
+		MaxAttempts:      3,
+		InitialBackoff:   time.Millisecond,
+		MaxBackoff:       5 * time.Millisecond,
+		BudgetMaxTokens:  10,
+		BudgetTokenRatio: 0.1,
+	}
+}
+
+func newRetryConn(t *testing.T, srv pb.ProxyControlServer, cfg config.RetryConfig) pb.ProxyControlClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1024 * 1024)
+	grpcSrv := grpc.NewServer()
+	pb.RegisterProxyControlServer(grpcSrv, srv)
+	go func() { _ = grpcSrv.Serve(lis) }()
+	t.Cleanup(grpcSrv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(retryInterceptor(cfg, zap.NewNop())),
+	)
+	if err != nil {
+		t.Fatalf("grpc.NewClient: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return pb.NewProxyControlClient(conn)
+}
+
+func TestRetryInterceptor_RetriesTransientFailure(t *testing.T) {
+	srv := &flakyServer{failCode: codes.Unavailable}
+	srv.failuresLeft.Store(2)
+	client := newRetryConn(t, srv, testRetryConfig())
+
+	if _, err := client.UpdateConfig(context.Background(), &pb.ProxyConfig{}); err != nil {
+		t.Fatalf("UpdateConfig: expected success after retries, got %v", err)
+	}
+	if got := srv.updateCalls.Load(); got != 3 {
+		t.Errorf("UpdateConfig calls: got %d, want 3", got)
+	}
+}
+
+func TestRetryInterceptor_StopsAtMaxAttempts(t *testing.T) {
+	srv := &flakyServer{failCode: codes.Unavailable}
+	srv.failuresLeft.Store(5)
+	client := newRetryConn(t, srv, testRetryConfig())
+
+	if _, err := client.UpdateConfig(context.Background(), &pb.ProxyConfig{}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable after exhausting attempts, got %v", err)
+	}
+	if got := srv.updateCalls.Load(); got != 3 {
+		t.Errorf("UpdateConfig calls: got %d, want 3", got)
+	}
+}
+
+func TestRetryInterceptor_DoesNotRetryNonIdempotent(t *testing.T) {
+	srv := &flakyServer{failCode: codes.Unavailable}
+	srv.failuresLeft.Store(1)
+	client := newRetryConn(t, srv, testRetryConfig())
+
+	if _, err := client.DrainConnections(context.Background(), &pb.DrainRequest{}); err == nil {
+		t.Fatal("expected DrainConnections failure to surface without retry")
+	}
+	if got := srv.drainCalls.Load(); got != 1 {
+		t.Errorf("DrainConnections calls: got %d, want 1", got)
+	}
+}
+
+func TestRetryInterceptor_DoesNotRetryPermanentError(t *testing.T) {
+	srv := &flakyServer{failCode: codes.InvalidArgument}
+	srv.failuresLeft.Store(1)
+	client := newRetryConn(t, srv, testRetryConfig())
+
+	if _, err := client.UpdateConfig(context.Background(), &pb.ProxyConfig{}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+	if got := srv.updateCalls.Load(); got != 1 {
+		t.Errorf("UpdateConfig calls: got %d, want 1", got)
+	}
+}
+
+func TestRetryBudget_ExhaustsUnderSustainedFailure(t *testing.T) {
+	b := newRetryBudget(4, 1)
+
+	if !b.onFailure() {
+		t.Fatal("first failure should leave budget for a retry (3 > 2)")
+	}
+	if b.onFailure() {
+		t.Fatal("second failure should exhaust the budget (2 <= 2)")
+	}
+
+	b.onSuccess()
+	b.onSuccess()
+	if !b.onFailure() {
+		t.Error("budget should recover after successes refund tokens")
+	}
+}
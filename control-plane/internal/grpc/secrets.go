@@ -0,0 +1,224 @@
+package grpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/certs"
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+const (
+	// secretsFeature is advertised by data planes with UpdateSecrets.
+	secretsFeature = "secrets"
+	// secretStreamFeature is advertised by data planes with
+	// StreamSecrets, which is used instead when they have it.
+	secretStreamFeature = "secret_stream"
+)
+
+// secretStream is an open StreamSecrets call, and what the data plane
+// has accepted on it.
+type secretStream struct {
+	stream grpc.BidiStreamingClient[pb.SecretUpdate, pb.SecretAck]
+	cancel context.CancelFunc
+	nonce  uint64
+	// held is the version of each secret accepted, by name, and
+	// defaultCert the default certificate last sent
+	held        map[string]string
+	defaultCert string
+}
+
+// UpdateSecrets replaces the certificates the data plane terminates TLS
+// with, the first being the default, and keeps them to push again when
+// the data plane reconnects, even if this push fails. Over a data plane's
+// secret stream only the certificates that changed are sent; one it
+// rejects is sent again with the next push, while its previous version
+// stays in use.
+func (c *Client) UpdateSecrets(ctx context.Context, certificates []certs.Certificate) error {
+	c.secretsMu.Lock()
+	defer c.secretsMu.Unlock()
+	c.secrets = slices.Clone(certificates)
+	return c.pushSecrets(ctx, c.secrets)
+}
+
+// pushSecrets sends certificates over the secret stream, or UpdateSecrets
+// for a data plane without one. The caller holds secretsMu.
+func (c *Client) pushSecrets(ctx context.Context, certificates []certs.Certificate) error {
+	peer, err := c.hello(ctx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !peer.Legacy && slices.Contains(peer.Features, secretStreamFeature):
+		return c.streamSecrets(ctx, certificates)
+	case !peer.Legacy && slices.Contains(peer.Features, secretsFeature):
+	default:
+		return fmt.Errorf("data plane %s does not support: %s", peer.Version, secretsFeature)
+	}
+
+	set := &pb.SecretSet{TlsCertificates: make([]*pb.TlsCertificate, len(certificates))}
+	for i, cert := range certificates {
+		set.TlsCertificates[i] = toProtoCertificate(cert)
+	}
+	resp, err := c.client.UpdateSecrets(ctx, set)
+	if err != nil {
+		return fmt.Errorf("failed to update secrets: %w", err)
+	}
+	if !resp.Success {
+		return fmt.Errorf("secrets update failed: %s", resp.Message)
+	}
+	return nil
+}
+
+// streamSecrets sends the data plane the certificates it doesn't hold at
+// their current version, and removes the ones it holds that are gone,
+// opening the stream with all of them if there's none. A stream that
+// fails is closed, to be opened again by the next push.
+func (c *Client) streamSecrets(ctx context.Context, certificates []certs.Certificate) error {
+	s := c.secretStream
+	full := s == nil
+	if full {
+		streamCtx, cancel := context.WithCancel(context.Background())
+		stream, err := c.client.StreamSecrets(streamCtx)
+		if err != nil {
+			cancel()
+			return fmt.Errorf("failed to open secret stream: %w", err)
+		}
+		s = &secretStream{stream: stream, cancel: cancel, held: make(map[string]string)}
+		c.secretStream = s
+	}
+
+	update := &pb.SecretUpdate{Full: full}
+	if len(certificates) > 0 {
+		update.DefaultCertificate = certificates[0].Name
+	}
+	names := make(map[string]bool, len(certificates))
+	for _, cert := range certificates {
+		names[cert.Name] = true
+		secret := &pb.Secret{
+			Name:    cert.Name,
+			Version: certificateVersion(cert),
+			Kind:    &pb.Secret_TlsCertificate{TlsCertificate: toProtoCertificate(cert)},
+		}
+		if full || s.held[cert.Name] != secret.Version {
+			update.Secrets = append(update.Secrets, secret)
+		}
+	}
+	for name := range s.held {
+		if !names[name] {
+			update.Removed = append(update.Removed, name)
+		}
+	}
+	slices.Sort(update.Removed)
+	if !full && len(update.Secrets) == 0 && len(update.Removed) == 0 && update.DefaultCertificate == s.defaultCert {
+		return nil
+	}
+
+	s.nonce++
+	update.Nonce = s.nonce
+	ack, err := s.exchange(ctx, update)
+	if err != nil {
+		c.closeSecretStream()
+		return fmt.Errorf("failed to stream secrets: %w", err)
+	}
+	for _, name := range update.Removed {
+		delete(s.held, name)
+	}
+	s.defaultCert = update.DefaultCertificate
+	var rejected []string
+	for _, st := range ack.Secrets {
+		if st.Accepted {
+			s.held[st.Name] = st.Version
+		} else {
+			rejected = append(rejected, fmt.Sprintf("%s: %s", st.Name, st.Error))
+		}
+	}
+	if len(rejected) > 0 {
+		return fmt.Errorf("data plane rejected secrets: %s", strings.Join(rejected, "; "))
+	}
+	c.logger.Debug("Streamed secrets", zap.Bool("full", full), zap.Int("sent", len(update.Secrets)),
+		zap.Int("removed", len(update.Removed)))
+	return nil
+}
+
+// exchange sends update and waits for its ack until ctx is done.
+func (s *secretStream) exchange(ctx context.Context, update *pb.SecretUpdate) (*pb.SecretAck, error) {
+	if err := s.stream.Send(update); err != nil {
+		return nil, err
+	}
+	type result struct {
+		ack *pb.SecretAck
+		err error
+	}
+	received := make(chan result, 1)
+	go func() {
+		ack, err := s.stream.Recv()
+		received <- result{ack, err}
+	}()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-received:
+		if r.err != nil {
+			return nil, r.err
+		}
+		if r.ack.Nonce != update.Nonce {
+			return nil, fmt.Errorf("ack for update %d, want %d", r.ack.Nonce, update.Nonce)
+		}
+		return r.ack, nil
+	}
+}
+
+// closeSecretStream ends the secret stream, if there's one. The caller
+// holds secretsMu.
+func (c *Client) closeSecretStream() {
+	if c.secretStream != nil {
+		c.secretStream.cancel()
+		c.secretStream = nil
+	}
+}
+
+// repushSecrets sends the certificates last given to UpdateSecrets, if
+// there were any, to a data plane that reconnected, on a new stream: the
+// old one went with the connection.
+func (c *Client) repushSecrets() {
+	c.secretsMu.Lock()
+	defer c.secretsMu.Unlock()
+	c.closeSecretStream()
+	if c.secrets == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c.logger.Info("Re-pushing secrets to the reconnected data plane", zap.Int("certificates", len(c.secrets)))
+	if err := c.pushSecrets(ctx, c.secrets); err != nil {
+		c.logger.Error("Failed to re-push secrets after reconnect", zap.Error(err))
+	}
+}
+
+func toProtoCertificate(cert certs.Certificate) *pb.TlsCertificate {
+	return &pb.TlsCertificate{
+		Name:             cert.Name,
+		CertificateChain: cert.Chain,
+		PrivateKey:       cert.Key,
+		ServerNames:      cert.ServerNames,
+	}
+}
+
+// certificateVersion is a digest of cert's chain and key, so a renewed or
+// rotated certificate gets a new version and an unchanged one keeps its
+// own across control plane restarts.
+func certificateVersion(cert certs.Certificate) string {
+	h := sha256.New()
+	h.Write(cert.Chain)
+	h.Write([]byte{0})
+	h.Write(cert.Key)
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
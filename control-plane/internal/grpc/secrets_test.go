@@ -0,0 +1,123 @@
+package grpc
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/lazzerex/aegis/control-plane/internal/certs"
+)
+
+func TestUpdateSecrets_SendsCertificates(t *testing.T) {
+	srv := &fakeServer{helloFeatures: []string{secretsFeature}}
+	c, _, _ := newFakeConn(t, srv, nil)
+
+	cert := certs.Certificate{Name: "example.com", Chain: []byte("chain"), Key: []byte("key"), ServerNames: []string{"example.com", "www.example.com"}}
+	if err := c.UpdateSecrets(context.Background(), []certs.Certificate{cert}); err != nil {
+		t.Fatalf("UpdateSecrets: %v", err)
+	}
+	got := srv.lastSecrets.Load()
+	if got == nil || len(got.TlsCertificates) != 1 {
+		t.Fatalf("got %v, want one certificate", got)
+	}
+	if tc := got.TlsCertificates[0]; tc.Name != "example.com" || string(tc.CertificateChain) != "chain" ||
+		string(tc.PrivateKey) != "key" || len(tc.ServerNames) != 2 {
+		t.Errorf("got %v", tc)
+	}
+}
+
+func TestUpdateSecrets_KeepsCertificatesForUnsupportingDataPlane(t *testing.T) {
+	srv := &fakeServer{helloFeatures: []string{"lb:round_robin"}}
+	c, _, _ := newFakeConn(t, srv, nil)
+
+	if err := c.UpdateSecrets(context.Background(), []certs.Certificate{{Name: "example.com"}}); err == nil {
+		t.Fatal("expected an error from a data plane without secrets")
+	}
+	if srv.secretsCalls.Load() != 0 {
+		t.Error("UpdateSecrets RPC called despite the missing feature")
+	}
+	c.secretsMu.Lock()
+	defer c.secretsMu.Unlock()
+	if len(c.secrets) != 1 {
+		t.Error("certificates not kept for the next reconnect")
+	}
+}
+
+// updates returns the secret updates srv received, as the names sent and
+// removed.
+func (f *fakeServer) updates() (sent, removed [][]string) {
+	f.secretMu.Lock()
+	defer f.secretMu.Unlock()
+	for _, u := range f.secretUpdates {
+		var names []string
+		for _, secret := range u.Secrets {
+			names = append(names, secret.Name)
+		}
+		sent, removed = append(sent, names), append(removed, u.Removed)
+	}
+	return sent, removed
+}
+
+func TestUpdateSecrets_StreamsChangedSecretsOnly(t *testing.T) {
+	srv := &fakeServer{helloFeatures: []string{secretsFeature, secretStreamFeature}}
+	c, _, _ := newFakeConn(t, srv, nil)
+	ctx := context.Background()
+	site := certs.Certificate{Name: "site", Chain: []byte("chain"), Key: []byte("key"), ServerNames: []string{"example.com"}}
+	api := certs.Certificate{Name: "api", Chain: []byte("api chain"), Key: []byte("api key"), ServerNames: []string{"api.example.com"}}
+
+	if err := c.UpdateSecrets(ctx, []certs.Certificate{site, api}); err != nil {
+		t.Fatalf("first push: %v", err)
+	}
+	if err := c.UpdateSecrets(ctx, []certs.Certificate{site, api}); err != nil {
+		t.Fatalf("unchanged push: %v", err)
+	}
+	site.Chain = []byte("renewed chain")
+	if err := c.UpdateSecrets(ctx, []certs.Certificate{site}); err != nil {
+		t.Fatalf("renewed push: %v", err)
+	}
+
+	sent, removed := srv.updates()
+	if len(sent) != 2 || !slices.Equal(sent[0], []string{"site", "api"}) || !slices.Equal(sent[1], []string{"site"}) ||
+		!slices.Equal(removed[1], []string{"api"}) {
+		t.Fatalf("got sent %v, removed %v", sent, removed)
+	}
+	srv.secretMu.Lock()
+	first, second := srv.secretUpdates[0], srv.secretUpdates[1]
+	srv.secretMu.Unlock()
+	if !first.Full || second.Full || first.DefaultCertificate != "site" || second.Nonce != first.Nonce+1 {
+		t.Errorf("got updates %v and %v", first, second)
+	}
+	if first.Secrets[0].Version == second.Secrets[0].Version {
+		t.Error("renewed certificate kept its version")
+	}
+	if srv.secretsCalls.Load() != 0 || srv.secretStreams.Load() != 1 {
+		t.Errorf("got %d UpdateSecrets calls and %d streams, want the one stream", srv.secretsCalls.Load(), srv.secretStreams.Load())
+	}
+}
+
+func TestUpdateSecrets_ResendsRejectedSecret(t *testing.T) {
+	srv := &fakeServer{helloFeatures: []string{secretStreamFeature}}
+	c, _, _ := newFakeConn(t, srv, nil)
+	ctx := context.Background()
+	bad := certs.Certificate{Name: "site", Chain: []byte("bad"), Key: []byte("key")}
+
+	err := c.UpdateSecrets(ctx, []certs.Certificate{bad})
+	if err == nil || !strings.Contains(err.Error(), "site: invalid chain") {
+		t.Fatalf("got %v, want the rejection", err)
+	}
+	if err := c.UpdateSecrets(ctx, []certs.Certificate{bad}); err == nil {
+		t.Fatal("rejected secret not sent again")
+	}
+
+	// A reconnected data plane gets everything on a new stream
+	c.repushSecrets()
+	if srv.secretStreams.Load() != 2 {
+		t.Errorf("got %d streams, want 2", srv.secretStreams.Load())
+	}
+	srv.secretMu.Lock()
+	defer srv.secretMu.Unlock()
+	if n := len(srv.secretUpdates); n != 3 || !srv.secretUpdates[2].Full {
+		t.Errorf("got %d updates, the last %v", n, srv.secretUpdates[n-1])
+	}
+}
@@ -0,0 +1,208 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+	"go.uber.org/zap"
+)
+
+// twoPhaseFeature is advertised in Hello by data planes that implement
+// PrepareConfig/CommitConfig/AbortConfig.
+const twoPhaseFeature = "config:two_phase"
+
+// ConfigStager is one data plane taking part in ApplyConfig.
+type ConfigStager interface {
+	// PrepareConfig validates and stages cfg without applying it.
+	PrepareConfig(ctx context.Context, cfg *config.Config) (token string, err error)
+	// CommitConfig makes the config staged under token live.
+	CommitConfig(ctx context.Context, token string) error
+	// AbortConfig discards the config staged under token.
+	AbortConfig(ctx context.Context, token string) error
+	// RollbackConfig restores the config that was live before the last
+	// commit.
+	RollbackConfig(ctx context.Context) error
+}
+
+// ApplyConfig pushes cfg to every data plane or to none of them. All planes
+// must accept cfg in the prepare phase before any commits; if one rejects
+// it the others' staged copies are aborted. If a commit fails part way,
+// planes that already committed are rolled back to their previous config
+// and the rest are aborted.
+func ApplyConfig(ctx context.Context, cfg *config.Config, planes []ConfigStager) error {
+	tokens := make([]string, len(planes))
+	for i, p := range planes {
+		token, err := p.PrepareConfig(ctx, cfg)
+		if err != nil {
+			if abortErr := abortAll(ctx, planes[:i], tokens[:i]); abortErr != nil {
+				return errors.Join(err, abortErr)
+			}
+			return err
+		}
+		tokens[i] = token
+	}
+
+	for i, p := range planes {
+		if err := p.CommitConfig(ctx, tokens[i]); err != nil {
+			errs := []error{err}
+			if abortErr := abortAll(ctx, planes[i+1:], tokens[i+1:]); abortErr != nil {
+				errs = append(errs, abortErr)
+			}
+			for _, done := range planes[:i] {
+				if rbErr := done.RollbackConfig(ctx); rbErr != nil {
+					errs = append(errs, fmt.Errorf("rollback failed: %w", rbErr))
+				}
+			}
+			if len(errs) == 1 {
+				return err
+			}
+			return errors.Join(errs...)
+		}
+	}
+	return nil
+}
+
+func abortAll(ctx context.Context, planes []ConfigStager, tokens []string) error {
+	var errs []error
+	for i, p := range planes {
+		if err := p.AbortConfig(ctx, tokens[i]); err != nil {
+			errs = append(errs, fmt.Errorf("abort failed: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// pendingConfig is the config between PrepareConfig and Commit/Abort. For a
+// data plane without two-phase support it's only held here, and the commit
+// falls back to a plain UpdateConfig push.
+type pendingConfig struct {
+	token  string
+	cfg    *config.Config
+	legacy bool
+}
+
+// legacyToken stands in for the data plane's token when staging locally.
+const legacyToken = "local"
+
+func (c *Client) supportsTwoPhase() bool {
+	peer := c.DataPlaneInfo()
+	return peer != nil && !peer.Legacy && slices.Contains(peer.Features, twoPhaseFeature)
+}
+
+// PrepareConfig implements ConfigStager.
+func (c *Client) PrepareConfig(ctx context.Context, cfg *config.Config) (string, error) {
+	if err := c.checkFeatures(cfg); err != nil {
+		return "", err
+	}
+
+	pending := &pendingConfig{token: legacyToken, cfg: cfg, legacy: !c.supportsTwoPhase()}
+	if !pending.legacy {
+		resp, err := c.client.PrepareConfig(ctx, toProtoConfig(cfg))
+		if err != nil {
+			return "", fmt.Errorf("failed to prepare config: %w", err)
+		}
+		if !resp.Success {
+			return "", fmt.Errorf("data plane rejected config: %s", resp.Message)
+		}
+		pending.token = resp.Token
+	}
+
+	c.cfgMu.Lock()
+	c.pending = pending
+	c.cfgMu.Unlock()
+	return pending.token, nil
+}
+
+// takePending removes and returns the pending config if it matches token.
+func (c *Client) takePending(token string) *pendingConfig {
+	c.cfgMu.Lock()
+	defer c.cfgMu.Unlock()
+	p := c.pending
+	if p == nil || p.token != token {
+		return nil
+	}
+	c.pending = nil
+	return p
+}
+
+// CommitConfig implements ConfigStager.
+func (c *Client) CommitConfig(ctx context.Context, token string) error {
+	p := c.takePending(token)
+	if p == nil {
+		return fmt.Errorf("no config prepared with token %q", token)
+	}
+
+	if p.legacy {
+		if err := c.pushConfig(ctx, p.cfg); err != nil {
+			return err
+		}
+	} else {
+		resp, err := c.client.CommitConfig(ctx, &pb.ConfigToken{Token: token})
+		if err != nil {
+			return fmt.Errorf("failed to commit config: %w", err)
+		}
+		if !resp.Success {
+			return fmt.Errorf("config commit failed: %s", resp.Message)
+		}
+	}
+
+	c.cfgMu.Lock()
+	c.prevCfg = c.lastCfg
+	c.lastCfg = p.cfg
+	c.cfgMu.Unlock()
+	c.lastPush.Store(time.Now().UnixNano())
+
+	c.logger.Info("Configuration updated successfully", zap.Bool("two_phase", !p.legacy))
+	return nil
+}
+
+// AbortConfig implements ConfigStager.
+func (c *Client) AbortConfig(ctx context.Context, token string) error {
+	p := c.takePending(token)
+	if p == nil || p.legacy {
+		return nil
+	}
+	if _, err := c.client.AbortConfig(ctx, &pb.ConfigToken{Token: token}); err != nil {
+		return fmt.Errorf("failed to abort config: %w", err)
+	}
+	return nil
+}
+
+// RollbackConfig implements ConfigStager by re-pushing the previous config.
+func (c *Client) RollbackConfig(ctx context.Context) error {
+	c.cfgMu.Lock()
+	prev := c.prevCfg
+	c.cfgMu.Unlock()
+	if prev == nil {
+		return errors.New("no previous config to roll back to")
+	}
+
+	if err := c.pushConfig(ctx, prev); err != nil {
+		return err
+	}
+
+	c.cfgMu.Lock()
+	c.lastCfg = prev
+	c.prevCfg = nil
+	c.cfgMu.Unlock()
+	c.lastPush.Store(time.Now().UnixNano())
+
+	c.logger.Warn("Configuration rolled back to previous version")
+	return nil
+}
+
+// ValidateConfig is a dry run: the data plane validates and stages cfg,
+// which is then discarded. Data planes without two-phase support can only
+// be checked for missing features.
+func (c *Client) ValidateConfig(ctx context.Context, cfg *config.Config) error {
+	token, err := c.PrepareConfig(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	return c.AbortConfig(ctx, token)
+}
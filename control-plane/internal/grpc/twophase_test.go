@@ -0,0 +1,139 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+)
+
+// fakeStager records the calls ApplyConfig makes to one data plane.
+type fakeStager struct {
+	prepareErr error
+	commitErr  error
+	calls      []string
+}
+
+func (f *fakeStager) PrepareConfig(_ context.Context, _ *config.Config) (string, error) {
+	f.calls = append(f.calls, "prepare")
+	return "tok", f.prepareErr
+}
+
+func (f *fakeStager) CommitConfig(_ context.Context, _ string) error {
+	f.calls = append(f.calls, "commit")
+	return f.commitErr
+}
+
+func (f *fakeStager) AbortConfig(_ context.Context, _ string) error {
+	f.calls = append(f.calls, "abort")
+	return nil
+}
+
+func (f *fakeStager) RollbackConfig(_ context.Context) error {
+	f.calls = append(f.calls, "rollback")
+	return nil
+}
+
+func callsEqual(got []string, want ...string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestApplyConfig_AbortsWhenAnyPlaneRejects(t *testing.T) {
+	a, b, c := &fakeStager{}, &fakeStager{prepareErr: errors.New("invalid")}, &fakeStager{}
+
+	if err := ApplyConfig(context.Background(), testConfig(), []ConfigStager{a, b, c}); err == nil {
+		t.Fatal("expected error when a data plane rejects the config")
+	}
+	if !callsEqual(a.calls, "prepare", "abort") {
+		t.Errorf("accepting plane: got %v, want prepare then abort", a.calls)
+	}
+	if !callsEqual(b.calls, "prepare") {
+		t.Errorf("rejecting plane: got %v", b.calls)
+	}
+	if len(c.calls) != 0 {
+		t.Errorf("planes after the rejection must not be touched, got %v", c.calls)
+	}
+}
+
+func TestApplyConfig_RollsBackOnCommitFailure(t *testing.T) {
+	a, b, c := &fakeStager{}, &fakeStager{commitErr: errors.New("gone")}, &fakeStager{}
+
+	if err := ApplyConfig(context.Background(), testConfig(), []ConfigStager{a, b, c}); err == nil {
+		t.Fatal("expected error when a commit fails")
+	}
+	if !callsEqual(a.calls, "prepare", "commit", "rollback") {
+		t.Errorf("committed plane: got %v, want rollback", a.calls)
+	}
+	if !callsEqual(c.calls, "prepare", "abort") {
+		t.Errorf("uncommitted plane: got %v, want abort", c.calls)
+	}
+}
+
+func TestUpdateConfig_UsesTwoPhaseWhenAdvertised(t *testing.T) {
+	srv := &fakeServer{helloFeatures: []string{"lb:round_robin", twoPhaseFeature}}
+	c, _, _ := newFakeConn(t, srv, nil)
+
+	if err := c.UpdateConfig(testConfig()); err != nil {
+		t.Fatalf("UpdateConfig: %v", err)
+	}
+	if n := srv.commitCalls.Load(); n != 1 {
+		t.Errorf("CommitConfig calls: got %d, want 1", n)
+	}
+	if n := srv.updateConfigCalls.Load(); n != 0 {
+		t.Errorf("UpdateConfig RPC used despite two-phase support (%d calls)", n)
+	}
+
+	srv.failPrepare.Store(true)
+	if err := c.UpdateConfig(testConfig()); err == nil {
+		t.Fatal("expected error when the data plane rejects the staged config")
+	}
+	if n := srv.commitCalls.Load(); n != 1 {
+		t.Errorf("rejected config was committed")
+	}
+}
+
+func TestRollbackConfig_RepushesPreviousConfig(t *testing.T) {
+	srv := &fakeServer{}
+	c, _, _ := newFakeConn(t, srv, nil)
+
+	first, second := testConfig(), testConfig()
+	if err := c.UpdateConfig(first); err != nil {
+		t.Fatalf("UpdateConfig: %v", err)
+	}
+	if err := c.UpdateConfig(second); err != nil {
+		t.Fatalf("UpdateConfig: %v", err)
+	}
+	if err := c.RollbackConfig(context.Background()); err != nil {
+		t.Fatalf("RollbackConfig: %v", err)
+	}
+
+	c.cfgMu.Lock()
+	defer c.cfgMu.Unlock()
+	if c.lastCfg != first {
+		t.Error("lastCfg not restored to the config live before the last commit")
+	}
+	if n := srv.updateConfigCalls.Load(); n != 3 {
+		t.Errorf("UpdateConfig RPC calls: got %d, want 3 (legacy data plane)", n)
+	}
+}
+
+func TestValidateConfig_AbortsStagedConfig(t *testing.T) {
+	srv := &fakeServer{helloFeatures: []string{"lb:round_robin", twoPhaseFeature}}
+	c, _, _ := newFakeConn(t, srv, nil)
+
+	if err := c.ValidateConfig(context.Background(), testConfig()); err != nil {
+		t.Fatalf("ValidateConfig: %v", err)
+	}
+	if srv.abortCalls.Load() != 1 || srv.commitCalls.Load() != 0 {
+		t.Errorf("dry run: got %d aborts, %d commits; want 1, 0", srv.abortCalls.Load(), srv.commitCalls.Load())
+	}
+}
@@ -5,13 +5,62 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/events"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"go.uber.org/zap"
 )
 
+// historySize is how many health transitions are kept per backend.
+const historySize = 20
+
+var (
+	probeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "aegis_health_check_duration_seconds",
+		Help:    "Duration of health check probes, by backend and protocol",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"backend", "protocol"})
+	probeFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aegis_health_check_failures_total",
+		Help: "Failed health check probes, by backend and protocol",
+	}, []string{"backend", "protocol"})
+)
+
+// observeProbe records one probe's duration and, if it failed, a failure.
+func observeProbe(address, protocol string, start time.Time, healthy bool) {
+	probeDuration.WithLabelValues(address, protocol).Observe(time.Since(start).Seconds())
+	if !healthy {
+		probeFailures.WithLabelValues(address, protocol).Inc()
+	}
+}
+
+// forgetProbes drops a removed backend's series so they don't linger.
+func forgetProbes(address string) {
+	probeDuration.DeletePartialMatch(prometheus.Labels{"backend": address})
+	probeFailures.DeletePartialMatch(prometheus.Labels{"backend": address})
+}
+
+// History is a backend's recent health record, for GET /backends/{address}.
+type History struct {
+	// LastChecked is zero until the first check completes.
+	LastChecked time.Time
+	// ConsecutiveFailures counts failed checks since the last success.
+	ConsecutiveFailures int
+	// Transitions holds the most recent state changes, oldest first.
+	// Backends start out healthy, so the first one is usually to unhealthy.
+	Transitions []Transition
+}
+
+type Transition struct {
+	Time    time.Time
+	Healthy bool
+}
+
 type backendReloader interface {
 	ReloadBackendsWithHealth(backends []config.Backend, healthState map[string]bool) error
 }
@@ -23,19 +72,36 @@ type Checker struct {
 	stopChan    chan struct{}
 	wg          sync.WaitGroup
 	healthState map[string]bool
-	mu          sync.RWMutex
+	// history survives reloads for backends that are still configured.
+	history map[string]*History
+	mu      sync.RWMutex
+
+	// reloadPending wakes coalesceReloads; its buffer of one is what
+	// folds many transitions into a single push. Nil (as in tests built
+	// without NewChecker) means every transition pushes synchronously.
+	reloadPending chan struct{}
+
+	// feed receives a backend_health_changed event per transition.
+	feed *events.Feed
 }
 
 func NewChecker(cfg *config.Config, client backendReloader, logger *zap.Logger) *Checker {
 	return &Checker{
-		config:      cfg,
-		grpcClient:  client,
-		logger:      logger,
-		stopChan:    make(chan struct{}),
-		healthState: make(map[string]bool),
+		config:        cfg,
+		grpcClient:    client,
+		logger:        logger,
+		stopChan:      make(chan struct{}),
+		healthState:   make(map[string]bool),
+		history:       make(map[string]*History),
+		reloadPending: make(chan struct{}, 1),
 	}
 }
 
+// SetEventFeed publishes health transitions to feed; call before Start.
+func (c *Checker) SetEventFeed(feed *events.Feed) {
+	c.feed = feed
+}
+
 func (c *Checker) Start() {
 	c.logger.Info("Starting health checker")
 
@@ -49,16 +115,38 @@ func (c *Checker) Start() {
 		c.wg.Add(1)
 		go c.checkUDPBackend(backend)
 	}
+	c.startCoalescer()
 }
 
 func (c *Checker) Reload(cfg *config.Config) {
 	close(c.stopChan)
 	c.wg.Wait()
 
+	// A push still waiting out its window carries the old backend list;
+	// the caller pushes the new config itself, so drop it.
+	select {
+	case <-c.reloadPending:
+	default:
+	}
+
 	c.mu.Lock()
 	c.stopChan = make(chan struct{})
 	c.config = cfg
 	c.healthState = make(map[string]bool)
+	kept := make(map[string]*History)
+	for _, backends := range [][]config.Backend{cfg.Proxy.Backends, cfg.Proxy.UdpBackends} {
+		for _, backend := range backends {
+			if h, ok := c.history[backend.Address]; ok {
+				kept[backend.Address] = h
+			}
+		}
+	}
+	for address := range c.history {
+		if _, ok := kept[address]; !ok {
+			forgetProbes(address)
+		}
+	}
+	c.history = kept
 	c.mu.Unlock()
 
 	for _, backend := range cfg.Proxy.Backends {
@@ -71,6 +159,43 @@ func (c *Checker) Reload(cfg *config.Config) {
 		c.wg.Add(1)
 		go c.checkUDPBackend(backend)
 	}
+	c.startCoalescer()
+}
+
+func (c *Checker) startCoalescer() {
+	if c.reloadPending == nil {
+		return
+	}
+	c.wg.Add(1)
+	go c.coalesceReloads(c.stopChan, c.config.Proxy.ReloadDebounce)
+}
+
+// coalesceReloads pushes backend health at most once per window. The window
+// starts at the first transition and isn't extended by later ones, so a
+// backend that never stops flapping still gets its state pushed.
+func (c *Checker) coalesceReloads(stop <-chan struct{}, window time.Duration) {
+	defer c.wg.Done()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-c.reloadPending:
+		}
+
+		timer := time.NewTimer(window)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		// Transitions during the window will be in this push's snapshot.
+		select {
+		case <-c.reloadPending:
+		default:
+		}
+		c.pushBackends()
+	}
 }
 
 func (c *Checker) Stop() {
@@ -102,7 +227,9 @@ func (c *Checker) checkBackend(backend config.Backend) {
 		case <-c.stopChan:
 			return
 		case <-ticker.C:
+			start := time.Now()
 			healthy := c.performHealthCheck(client, backend)
+			observeProbe(backend.Address, "http", start, healthy)
 			c.updateHealthState(backend.Address, healthy)
 		}
 	}
@@ -164,7 +291,9 @@ func (c *Checker) checkUDPBackend(backend config.Backend) {
 		case <-c.stopChan:
 			return
 		case <-ticker.C:
+			start := time.Now()
 			healthy := c.performUDPProbe(backend)
+			observeProbe(backend.Address, "udp", start, healthy)
 			c.updateHealthState(backend.Address, healthy)
 		}
 	}
@@ -202,28 +331,112 @@ func (c *Checker) performUDPProbe(backend config.Backend) bool {
 func (c *Checker) updateHealthState(address string, healthy bool) {
 	c.mu.Lock()
 	previousState := c.healthState[address]
+	backends := c.backendsOfLocked(address)
+	fromTier, hadTier := config.ActiveTier(backends, c.healthState)
 	c.healthState[address] = healthy
+	toTier, hasTier := config.ActiveTier(backends, c.healthState)
+	c.recordCheck(address, healthy, previousState != healthy)
 	c.mu.Unlock()
 
 	if previousState != healthy {
 		c.logger.Info("Backend health state changed",
 			zap.String("backend", address),
 			zap.Bool("healthy", healthy))
-
-		c.mu.RLock()
-		backends := make([]config.Backend, 0, len(c.config.Proxy.Backends))
-		for _, backend := range c.config.Proxy.Backends {
-			backends = append(backends, backend)
+		state := "unhealthy"
+		if healthy {
+			state = "healthy"
+		}
+		c.feed.Publish(events.TypeBackendHealthChanged, "Backend "+address+" is "+state,
+			map[string]string{"backend": address, "healthy": strconv.FormatBool(healthy)})
+
+		// Moving traffic to another tier can't wait out the debounce: when
+		// the active tier went down, everything is failing until the push
+		if hadTier && hasTier && fromTier != toTier {
+			c.logger.Warn("Backend priority failover",
+				zap.Stringer("from", fromTier),
+				zap.Stringer("to", toTier),
+				zap.String("backend", address))
+			c.feed.Publish(events.TypePriorityFailover, fmt.Sprintf("Traffic moved from tier %s to %s", fromTier, toTier),
+				map[string]string{"from": fromTier.String(), "to": toTier.String(), "backend": address})
+			c.pushBackends()
+			return
 		}
-		healthState := make(map[string]bool, len(c.healthState))
-		for k, v := range c.healthState {
-			healthState[k] = v
+		if c.reloadPending == nil {
+			c.pushBackends()
+			return
 		}
-		c.mu.RUnlock()
+		select {
+		case c.reloadPending <- struct{}{}:
+		default: // a push is already scheduled and will include this change
+		}
+	}
+}
+
+// backendsOfLocked returns the backend list, TCP or UDP, that address is
+// in, with the weights the data plane was given; the caller holds c.mu.
+func (c *Checker) backendsOfLocked(address string) []config.Backend {
+	for _, b := range c.config.Proxy.UdpBackends {
+		if b.Address == address {
+			return c.config.Proxy.UdpBackends
+		}
+	}
+	return c.config.Proxy.ServingBackends(c.config.Proxy.Backends)
+}
 
-		if err := c.grpcClient.ReloadBackendsWithHealth(backends, healthState); err != nil {
-			c.logger.Error("Failed to reload backends", zap.Error(err))
+// recordCheck updates address's history; the caller holds c.mu.
+func (c *Checker) recordCheck(address string, healthy, changed bool) {
+	if c.history == nil {
+		c.history = make(map[string]*History)
+	}
+	h := c.history[address]
+	if h == nil {
+		h = &History{}
+		c.history[address] = h
+	}
+	now := time.Now()
+	h.LastChecked = now
+	if healthy {
+		h.ConsecutiveFailures = 0
+	} else {
+		h.ConsecutiveFailures++
+	}
+	if changed {
+		if len(h.Transitions) == historySize {
+			h.Transitions = append(h.Transitions[:0], h.Transitions[1:]...)
 		}
+		h.Transitions = append(h.Transitions, Transition{Time: now, Healthy: healthy})
+	}
+}
+
+// HealthHistory returns a copy of address's history; false if it has
+// never been checked.
+func (c *Checker) HealthHistory(address string) (History, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	h, ok := c.history[address]
+	if !ok {
+		return History{}, false
+	}
+	out := *h
+	out.Transitions = append([]Transition(nil), h.Transitions...)
+	return out, true
+}
+
+// pushBackends sends the current backend list and health to the data plane.
+func (c *Checker) pushBackends() {
+	c.mu.RLock()
+	backends := make([]config.Backend, 0, len(c.config.Proxy.Backends))
+	for _, backend := range c.config.Proxy.Backends {
+		backends = append(backends, backend)
+	}
+	healthState := make(map[string]bool, len(c.healthState))
+	for k, v := range c.healthState {
+		healthState[k] = v
+	}
+	c.mu.RUnlock()
+
+	if err := c.grpcClient.ReloadBackendsWithHealth(backends, healthState); err != nil {
+		c.logger.Error("Failed to reload backends", zap.Error(err))
 	}
 }
 
@@ -10,6 +10,8 @@ import (
 	"time"
 
 	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/events"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"go.uber.org/zap"
 )
 
@@ -89,6 +91,36 @@ func TestUpdateHealthState_CallsReloaderOnChange(t *testing.T) {
 	}
 }
 
+func TestHealthHistory_RecordsChecksAndTransitions(t *testing.T) {
+	c := newTestChecker(&mockReloader{})
+	if _, ok := c.HealthHistory("localhost:3000"); ok {
+		t.Fatal("history before the first check")
+	}
+
+	c.updateHealthState("localhost:3000", true)
+	c.updateHealthState("localhost:3000", false)
+	c.updateHealthState("localhost:3000", false)
+	h, ok := c.HealthHistory("localhost:3000")
+	if !ok || h.LastChecked.IsZero() || h.ConsecutiveFailures != 2 {
+		t.Fatalf("after two failures: got %+v", h)
+	}
+	if len(h.Transitions) != 1 || h.Transitions[0].Healthy {
+		t.Errorf("transitions: got %+v, want one to unhealthy", h.Transitions)
+	}
+
+	for i := 0; i < historySize; i++ {
+		c.updateHealthState("localhost:3000", i%2 == 0)
+	}
+	h, _ = c.HealthHistory("localhost:3000")
+	if len(h.Transitions) != historySize || h.ConsecutiveFailures != 1 {
+		t.Errorf("history not capped at %d: got %d transitions, %d failures", historySize, len(h.Transitions), h.ConsecutiveFailures)
+	}
+	h.Transitions[0].Healthy = !h.Transitions[0].Healthy
+	if again, _ := c.HealthHistory("localhost:3000"); again.Transitions[0].Healthy == h.Transitions[0].Healthy {
+		t.Error("HealthHistory returned shared transitions")
+	}
+}
+
 func TestPerformHealthCheck_HTTPScheme(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -226,3 +258,159 @@ func TestReload_ResetsHealthState(t *testing.T) {
 		t.Error("new backend missing from health state after Reload")
 	}
 }
+
+func TestUpdateHealthState_CoalescesTransitionsWithinWindow(t *testing.T) {
+	mock := &mockReloader{}
+	cfg := &config.Config{Proxy: config.ProxyConfig{ReloadDebounce: 50 * time.Millisecond}}
+	for _, addr := range []string{"a:1", "b:1", "c:1"} {
+		cfg.Proxy.Backends = append(cfg.Proxy.Backends, config.Backend{Address: addr})
+	}
+	c := NewChecker(cfg, mock, zap.NewNop())
+	for _, b := range cfg.Proxy.Backends {
+		c.healthState[b.Address] = true
+	}
+	c.startCoalescer()
+	defer c.Stop()
+
+	for _, b := range cfg.Proxy.Backends {
+		c.updateHealthState(b.Address, false)
+	}
+	c.updateHealthState("a:1", true)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for mock.callCount.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("coalesced reload never pushed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := mock.callCount.Load(); got != 1 {
+		t.Errorf("ReloadBackendsWithHealth called %d times, want 1 for 4 transitions in one window", got)
+	}
+}
+
+func TestReload_DropsPendingCoalescedPush(t *testing.T) {
+	mock := &mockReloader{}
+	cfg := &config.Config{Proxy: config.ProxyConfig{
+		ReloadDebounce: time.Hour,
+		Backends:       []config.Backend{{Address: "a:1"}},
+	}}
+	c := NewChecker(cfg, mock, zap.NewNop())
+	c.healthState["a:1"] = true
+	c.startCoalescer()
+
+	c.updateHealthState("a:1", false)
+	c.Reload(&config.Config{Proxy: config.ProxyConfig{ReloadDebounce: time.Millisecond}})
+	defer c.Stop()
+
+	time.Sleep(50 * time.Millisecond)
+	if got := mock.callCount.Load(); got != 0 {
+		t.Errorf("stale pending push went out after Reload (%d calls)", got)
+	}
+}
+
+func TestUpdateHealthState_PublishesTransitions(t *testing.T) {
+	c := newTestChecker(&mockReloader{})
+	feed := events.NewFeed()
+	c.SetEventFeed(feed)
+	sub := feed.Subscribe(0)
+	defer sub.Close()
+
+	c.updateHealthState("localhost:3000", true)
+	c.updateHealthState("localhost:3000", false)
+	ev := <-sub.Events
+	if ev.Type != events.TypeBackendHealthChanged || ev.Attributes["backend"] != "localhost:3000" || ev.Attributes["healthy"] != "false" {
+		t.Errorf("got %+v", ev)
+	}
+	select {
+	case ev := <-sub.Events:
+		t.Errorf("unchanged state published %+v", ev)
+	default:
+	}
+}
+
+func TestUpdateHealthState_PriorityFailoverPushesAtOnce(t *testing.T) {
+	mock := &mockReloader{}
+	cfg := &config.Config{Proxy: config.ProxyConfig{
+		ReloadDebounce: time.Hour,
+		Backends:       []config.Backend{{Address: "a:1"}, {Address: "b:1"}, {Address: "standby:1", Priority: 1}},
+	}}
+	c := NewChecker(cfg, mock, zap.NewNop())
+	for _, b := range cfg.Proxy.Backends {
+		c.healthState[b.Address] = true
+	}
+	feed := events.NewFeed()
+	c.SetEventFeed(feed)
+	sub := feed.Subscribe(0)
+	defer sub.Close()
+	c.startCoalescer()
+	defer c.Stop()
+
+	// b:1 still serves priority 0, so this waits for the debounce
+	c.updateHealthState("a:1", false)
+	if got := mock.callCount.Load(); got != 0 {
+		t.Fatalf("pushed %d times before the tier went down", got)
+	}
+	c.updateHealthState("b:1", false)
+	if got := mock.callCount.Load(); got != 1 {
+		t.Errorf("failover pushed %d times, want 1 straight away", got)
+	}
+
+	var failover *events.Event
+	for i := 0; i < 3; i++ {
+		if ev := <-sub.Events; ev.Type == events.TypePriorityFailover {
+			failover = &ev
+		}
+	}
+	if failover == nil || failover.Attributes["from"] != "0" || failover.Attributes["to"] != "1" {
+		t.Errorf("failover event: got %+v", failover)
+	}
+}
+
+func TestUpdateHealthState_BackupTakesOverFromEveryPriority(t *testing.T) {
+	mock := &mockReloader{}
+	cfg := &config.Config{Proxy: config.ProxyConfig{Backends: []config.Backend{
+		{Address: "a:1", Weight: 100},
+		{Address: "b:1", Weight: 100, Priority: 2},
+		{Address: "spare:1", Weight: 100, Backup: true},
+	}}}
+	c := NewChecker(cfg, mock, zap.NewNop())
+	c.reloadPending = nil
+	for _, b := range cfg.Proxy.Backends {
+		c.healthState[b.Address] = true
+	}
+	feed := events.NewFeed()
+	c.SetEventFeed(feed)
+	sub := feed.Subscribe(0)
+	defer sub.Close()
+
+	// A backup going down while primaries serve is no failover
+	c.updateHealthState("spare:1", false)
+	c.updateHealthState("spare:1", true)
+	c.updateHealthState("a:1", false)
+	c.updateHealthState("b:1", false)
+
+	var tos []string
+	for len(sub.Events) > 0 {
+		if ev := <-sub.Events; ev.Type == events.TypePriorityFailover {
+			tos = append(tos, ev.Attributes["to"])
+		}
+	}
+	if strings.Join(tos, ",") != "2,backup" {
+		t.Errorf("failovers to: got %v, want [2 backup]", tos)
+	}
+}
+
+func TestObserveProbe_CountsFailuresUntilForgotten(t *testing.T) {
+	observeProbe("probe-test:1", "http", time.Now(), true)
+	observeProbe("probe-test:1", "http", time.Now(), false)
+	if got := testutil.ToFloat64(probeFailures.WithLabelValues("probe-test:1", "http")); got != 1 {
+		t.Errorf("failures: got %v, want 1", got)
+	}
+
+	forgetProbes("probe-test:1")
+	if got := testutil.ToFloat64(probeFailures.WithLabelValues("probe-test:1", "http")); got != 0 {
+		t.Errorf("failures after forget: got %v, want 0", got)
+	}
+}
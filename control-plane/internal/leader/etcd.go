@@ -0,0 +1,74 @@
+package leader
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/etcd"
+)
+
+// etcdLock keeps the record in an etcd key as a Lease's spec, its
+// mod_revision the version, writing it in transactions comparing that,
+// through the etcd package's gateway client.
+type etcdLock struct {
+	client *etcd.Client
+	key    string
+}
+
+func newEtcdLock(cfg config.HAConfig) (*etcdLock, error) {
+	client, err := etcd.New(cfg.EtcdConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &etcdLock{client: client, key: cfg.Key}, nil
+}
+
+func (l *etcdLock) get(ctx context.Context) (*Record, string, error) {
+	kv, err := l.client.Get(ctx, l.key)
+	if err != nil || kv == nil {
+		return nil, "", err
+	}
+	var spec leaseSpec
+	if err := json.Unmarshal(kv.Value, &spec); err != nil {
+		return nil, "", fmt.Errorf("the leader lease isn't one: %w", err)
+	}
+	return spec.record(), kv.ModRevision, nil
+}
+
+func (l *etcdLock) create(ctx context.Context, r Record) (string, error) {
+	return l.put(ctx, r, map[string]string{"key": etcd.Encode(l.key), "target": "CREATE", "create_revision": "0"})
+}
+
+func (l *etcdLock) update(ctx context.Context, r Record, version string) (string, error) {
+	return l.put(ctx, r, map[string]string{"key": etcd.Encode(l.key), "target": "MOD", "mod_revision": version})
+}
+
+// put writes r if compare holds, returning the revision it's written at.
+func (l *etcdLock) put(ctx context.Context, r Record, compare map[string]string) (string, error) {
+	value, err := json.Marshal(toSpec(r))
+	if err != nil {
+		return "", err
+	}
+	txn := map[string]any{
+		"compare": []any{compare},
+		"success": []any{map[string]any{"request_put": map[string]string{
+			"key": etcd.Encode(l.key), "value": base64.StdEncoding.EncodeToString(value),
+		}}},
+	}
+	var result struct {
+		Header struct {
+			Revision string `json:"revision"`
+		} `json:"header"`
+		Succeeded bool `json:"succeeded"`
+	}
+	if err := l.client.Post(ctx, "/v3/kv/txn", txn, &result); err != nil {
+		return "", err
+	}
+	if !result.Succeeded {
+		return "", errConflict
+	}
+	return result.Header.Revision, nil
+}
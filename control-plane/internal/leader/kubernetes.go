@@ -0,0 +1,224 @@
+package leader
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+)
+
+// serviceAccountDir is where Kubernetes mounts a pod's service account.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// microTime is a Lease's time format, to the microsecond.
+type microTime time.Time
+
+func (t microTime) MarshalJSON() ([]byte, error) {
+	if time.Time(t).IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(time.Time(t).UTC().Format("2006-01-02T15:04:05.000000Z07:00"))
+}
+
+func (t *microTime) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil || s == "" {
+		*t = microTime{}
+		return nil
+	}
+	parsed, err := time.Parse(time.RFC3339, s)
+	*t = microTime(parsed)
+	return err
+}
+
+// leaseSpec is a Lease's spec, which the etcd lock stores as its value
+// too.
+type leaseSpec struct {
+	HolderIdentity       string     `json:"holderIdentity"`
+	LeaseDurationSeconds int        `json:"leaseDurationSeconds"`
+	AcquireTime          *microTime `json:"acquireTime,omitempty"`
+	RenewTime            *microTime `json:"renewTime,omitempty"`
+	LeaseTransitions     int        `json:"leaseTransitions"`
+}
+
+// toSpec rounds the duration up to whole seconds, lest followers take a
+// sub-second lease as expired.
+func toSpec(r Record) leaseSpec {
+	acquire, renew := microTime(r.AcquireTime), microTime(r.RenewTime)
+	return leaseSpec{
+		HolderIdentity:       r.HolderIdentity,
+		LeaseDurationSeconds: int((r.LeaseDuration + time.Second - 1) / time.Second),
+		AcquireTime:          &acquire,
+		RenewTime:            &renew,
+		LeaseTransitions:     r.LeaderTransitions,
+	}
+}
+
+func (s leaseSpec) record() *Record {
+	r := &Record{
+		HolderIdentity:    s.HolderIdentity,
+		LeaseDuration:     time.Duration(s.LeaseDurationSeconds) * time.Second,
+		LeaderTransitions: s.LeaseTransitions,
+	}
+	if s.AcquireTime != nil {
+		r.AcquireTime = time.Time(*s.AcquireTime)
+	}
+	if s.RenewTime != nil {
+		r.RenewTime = time.Time(*s.RenewTime)
+	}
+	return r
+}
+
+type lease struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+	} `json:"metadata"`
+	Spec leaseSpec `json:"spec"`
+}
+
+// kubernetesLock keeps the record in a coordination.k8s.io/v1 Lease, its
+// resourceVersion the version, talking to the API server with the pod's
+// service account like client-go's in-cluster config.
+type kubernetesLock struct {
+	// leases is the URL of the namespace's leases
+	leases    string
+	namespace string
+	name      string
+	client    *http.Client
+	// tokenFile is re-read on every call, bound tokens being rotated
+	tokenFile string
+}
+
+func newKubernetesLock(cfg config.HAConfig) (*kubernetesLock, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("ha backend kubernetes needs to run in a pod: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT aren't set")
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("reading the service account's CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificates in the service account's ca.crt")
+	}
+	namespace := cfg.Namespace
+	if namespace == "" {
+		data, err := os.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("reading the pod's namespace, or set ha.namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+	l := newLeaseLock("https://"+net.JoinHostPort(host, port), namespace, cfg.Lease, serviceAccountDir+"/token")
+	l.client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	return l, nil
+}
+
+func newLeaseLock(server, namespace, name, tokenFile string) *kubernetesLock {
+	return &kubernetesLock{
+		leases:    server + "/apis/coordination.k8s.io/v1/namespaces/" + namespace + "/leases",
+		namespace: namespace,
+		name:      name,
+		client:    &http.Client{},
+		tokenFile: tokenFile,
+	}
+}
+
+func (l *kubernetesLock) get(ctx context.Context) (*Record, string, error) {
+	var current lease
+	status, err := l.do(ctx, http.MethodGet, l.leases+"/"+l.name, nil, &current)
+	if status == http.StatusNotFound {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return current.Spec.record(), current.Metadata.ResourceVersion, nil
+}
+
+func (l *kubernetesLock) create(ctx context.Context, r Record) (string, error) {
+	return l.write(ctx, http.MethodPost, l.leases, r, "")
+}
+
+func (l *kubernetesLock) update(ctx context.Context, r Record, version string) (string, error) {
+	return l.write(ctx, http.MethodPut, l.leases+"/"+l.name, r, version)
+}
+
+// write sends the Lease holding r, conditional on version when it's an
+// update; the API server answers 409 Conflict when it's stale.
+func (l *kubernetesLock) write(ctx context.Context, method, url string, r Record, version string) (string, error) {
+	next := lease{APIVersion: "coordination.k8s.io/v1", Kind: "Lease", Spec: toSpec(r)}
+	next.Metadata.Name, next.Metadata.Namespace, next.Metadata.ResourceVersion = l.name, l.namespace, version
+	var written lease
+	status, err := l.do(ctx, method, url, next, &written)
+	if status == http.StatusConflict {
+		return "", errConflict
+	}
+	if err != nil {
+		return "", err
+	}
+	return written.Metadata.ResourceVersion, nil
+}
+
+// do sends body, if any, as JSON and decodes the response into out,
+// returning the status too so callers can tell a missing or conflicting
+// Lease from a failure.
+func (l *kubernetesLock) do(ctx context.Context, method, url string, body, out any) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if l.tokenFile != "" {
+		token, err := os.ReadFile(l.tokenFile)
+		if err != nil {
+			return 0, fmt.Errorf("reading the service account token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		var status struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(data, &status)
+		return resp.StatusCode, fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, cmp.Or(status.Message, string(bytes.TrimSpace(data))))
+	}
+	return resp.StatusCode, json.Unmarshal(data, out)
+}
@@ -0,0 +1,288 @@
+// Package leader elects one of several control plane replicas the leader,
+// through a lease each of them tries to take and the leader keeps
+// renewing, stored in a Kubernetes Lease object or an etcd key.
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var leading = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "aegis_ha_leader",
+	Help: "1 while this control plane replica is the leader",
+})
+
+// ErrLost is returned by Run when the leader couldn't renew the lease
+// within the renew deadline, so another replica may be taking over, or
+// already has.
+var ErrLost = errors.New("leadership lost: the lease couldn't be renewed in time")
+
+// errConflict is returned by a lock whose record changed since it was
+// read, another replica having written it first.
+var errConflict = errors.New("the lease changed since it was read")
+
+// Record is the lease as stored.
+type Record struct {
+	// HolderIdentity is the leader's identity, empty once it released the
+	// lease.
+	HolderIdentity string
+	LeaseDuration  time.Duration
+	// AcquireTime is when the holder took the lease and RenewTime when
+	// it last renewed it, by its clock.
+	AcquireTime, RenewTime time.Time
+	// LeaderTransitions counts the times the lease changed hands.
+	LeaderTransitions int
+}
+
+// lock stores the lease record. A version is whatever tells its writes
+// apart.
+type lock interface {
+	// get returns the record and its version, nil if there's none yet.
+	get(ctx context.Context) (*Record, string, error)
+	// create stores the first record, returning its version, or
+	// errConflict if there's one already.
+	create(ctx context.Context, r Record) (string, error)
+	// update replaces the record at version, returning the new one, or
+	// errConflict if it changed since.
+	update(ctx context.Context, r Record, version string) (string, error)
+}
+
+// Elector campaigns for the lease on this replica's behalf. Expiry is
+// judged by the local clock, from when the record was last seen to change
+// rather than the times in it, so replicas' clocks needn't agree.
+type Elector struct {
+	lock     lock
+	identity string
+	// leaseDuration, renewDeadline and retryPeriod are the config's
+	leaseDuration, renewDeadline, retryPeriod time.Duration
+	logger                                    *zap.Logger
+	elected                                   chan struct{}
+
+	// observed is the record last read, with its version, and observedAt
+	// when it was first seen
+	observed        *Record
+	observedVersion string
+	observedAt      time.Time
+
+	mu      sync.Mutex
+	leader  string
+	leading bool
+}
+
+// New returns an elector for the lease cfg names. Nothing is read or
+// written until Run.
+func New(cfg config.HAConfig, logger *zap.Logger) (*Elector, error) {
+	var l lock
+	switch cfg.Backend {
+	case config.HABackendKubernetes:
+		k, err := newKubernetesLock(cfg)
+		if err != nil {
+			return nil, err
+		}
+		l = k
+	case config.HABackendEtcd:
+		e, err := newEtcdLock(cfg)
+		if err != nil {
+			return nil, err
+		}
+		l = e
+	default:
+		return nil, fmt.Errorf("no leader election backend %q", cfg.Backend)
+	}
+	return newElector(l, cfg, logger), nil
+}
+
+func newElector(l lock, cfg config.HAConfig, logger *zap.Logger) *Elector {
+	return &Elector{
+		lock:          l,
+		identity:      cfg.Identity,
+		leaseDuration: cfg.LeaseDuration,
+		renewDeadline: cfg.RenewDeadline,
+		retryPeriod:   cfg.RetryPeriod,
+		logger:        logger,
+		elected:       make(chan struct{}),
+	}
+}
+
+// Identity returns this replica's identity.
+func (e *Elector) Identity() string {
+	return e.identity
+}
+
+// Leader returns the identity of the leader as last seen, empty while
+// none is known, and whether it's this replica.
+func (e *Elector) Leader() (string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader, e.leading
+}
+
+// Elected returns a channel closed once this replica is the leader.
+func (e *Elector) Elected() <-chan struct{} {
+	return e.elected
+}
+
+// Run tries to take the lease every retry period until it has it, then
+// renews it as often. It returns ErrLost if a renewal deadline passes
+// without one going through, or another replica took the lease, and nil
+// once ctx is done, releasing the lease if it holds it so a follower takes
+// over without waiting it out.
+func (e *Elector) Run(ctx context.Context) error {
+	for !e.try(ctx, e.retryPeriod) {
+		if !e.sleep(ctx) {
+			return nil
+		}
+	}
+	e.setLeader(e.identity, true)
+	close(e.elected)
+
+	renewed := time.Now()
+	for {
+		if !e.sleep(ctx) {
+			e.release()
+			return nil
+		}
+		deadline := renewed.Add(e.renewDeadline)
+		tryCtx, cancel := context.WithDeadline(ctx, deadline)
+		ok := e.try(tryCtx, time.Until(deadline))
+		cancel()
+		switch {
+		case ok:
+			renewed = time.Now()
+		case ctx.Err() != nil:
+			e.release()
+			return nil
+		case !time.Now().Before(deadline):
+			e.setLeader("", false)
+			return ErrLost
+		}
+		// Another replica took the lease, renewals having stalled too long
+		if _, self := e.Leader(); !self {
+			return ErrLost
+		}
+	}
+}
+
+// sleep waits out a retry period, less a little jitter so replicas
+// started together don't keep colliding, reporting false if ctx is done
+// first.
+func (e *Elector) sleep(ctx context.Context) bool {
+	wait := e.retryPeriod - rand.N(e.retryPeriod/10+1)
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(wait):
+		return true
+	}
+}
+
+// try takes or renews the lease, reporting whether this replica holds it
+// now. Each call to the lock is bounded by timeout.
+func (e *Elector) try(ctx context.Context, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	now := time.Now()
+	next := Record{HolderIdentity: e.identity, LeaseDuration: e.leaseDuration, AcquireTime: now, RenewTime: now}
+
+	current, version, err := e.lock.get(ctx)
+	if err != nil {
+		e.logger.Warn("Failed to read the leader lease", zap.Error(err))
+		return false
+	}
+	if current == nil {
+		version, err := e.lock.create(ctx, next)
+		if err != nil {
+			e.logFailure("create", err)
+			return false
+		}
+		e.observe(next, version, now)
+		return true
+	}
+	if version != e.observedVersion {
+		e.observe(*current, version, now)
+	}
+	if current.HolderIdentity != "" && current.HolderIdentity != e.identity {
+		if e.observedAt.Add(current.LeaseDuration).After(now) {
+			e.setLeader(current.HolderIdentity, false)
+			return false
+		}
+		e.logger.Info("Leader lease expired; taking it over", zap.String("leader", current.HolderIdentity))
+	}
+
+	if current.HolderIdentity == e.identity {
+		next.AcquireTime, next.LeaderTransitions = current.AcquireTime, current.LeaderTransitions
+	} else {
+		next.LeaderTransitions = current.LeaderTransitions + 1
+	}
+	version, err = e.lock.update(ctx, next, version)
+	if err != nil {
+		e.logFailure("update", err)
+		return false
+	}
+	e.observe(next, version, now)
+	return true
+}
+
+func (e *Elector) observe(r Record, version string, at time.Time) {
+	e.observed, e.observedVersion, e.observedAt = &r, version, at
+}
+
+// logFailure logs a failed write; losing the race for it is no failure,
+// the election working as it should.
+func (e *Elector) logFailure(op string, err error) {
+	if errors.Is(err, errConflict) {
+		e.logger.Debug("Lost the race for the leader lease", zap.String("op", op))
+		return
+	}
+	e.logger.Warn("Failed to write the leader lease", zap.String("op", op), zap.Error(err))
+}
+
+// release gives the lease up, if this replica holds it.
+func (e *Elector) release() {
+	if _, ok := e.Leader(); !ok || e.observed == nil {
+		return
+	}
+	e.setLeader("", false)
+	ctx, cancel := context.WithTimeout(context.Background(), e.retryPeriod)
+	defer cancel()
+	r := *e.observed
+	r.HolderIdentity, r.LeaseDuration, r.RenewTime = "", time.Second, time.Now()
+	if _, err := e.lock.update(ctx, r, e.observedVersion); err != nil {
+		e.logger.Warn("Failed to release the leader lease; followers take over once it expires", zap.Error(err))
+		return
+	}
+	e.logger.Info("Released the leader lease")
+}
+
+// setLeader records who leads, logging a change.
+func (e *Elector) setLeader(identity string, self bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if identity == e.leader && self == e.leading {
+		return
+	}
+	switch {
+	case self:
+		e.logger.Info("Elected leader", zap.String("identity", e.identity))
+	case e.leading:
+		e.logger.Warn("No longer the leader", zap.String("identity", e.identity))
+	case identity != "":
+		e.logger.Info("Following the leader", zap.String("leader", identity))
+	}
+	e.leader, e.leading = identity, self
+	if self {
+		leading.Set(1)
+	} else {
+		leading.Set(0)
+	}
+}
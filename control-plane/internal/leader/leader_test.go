@@ -0,0 +1,288 @@
+package leader
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"go.uber.org/zap"
+)
+
+// memLock is a lock in memory, shared by the electors under test.
+type memLock struct {
+	mu      sync.Mutex
+	record  *Record
+	version int
+	// fail makes every call fail
+	fail bool
+}
+
+func (l *memLock) get(ctx context.Context) (*Record, string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.fail {
+		return nil, "", errors.New("unreachable")
+	}
+	if l.record == nil {
+		return nil, "", nil
+	}
+	r := *l.record
+	return &r, strconv.Itoa(l.version), nil
+}
+
+func (l *memLock) create(ctx context.Context, r Record) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.record != nil {
+		return "", errConflict
+	}
+	l.record = &r
+	l.version++
+	return strconv.Itoa(l.version), nil
+}
+
+func (l *memLock) update(ctx context.Context, r Record, version string) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.fail {
+		return "", errors.New("unreachable")
+	}
+	if version != strconv.Itoa(l.version) {
+		return "", errConflict
+	}
+	l.record = &r
+	l.version++
+	return strconv.Itoa(l.version), nil
+}
+
+func (l *memLock) setFail(fail bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.fail = fail
+}
+
+func haConfig(identity string) config.HAConfig {
+	return config.HAConfig{
+		Enabled: true, Identity: identity,
+		LeaseDuration: 300 * time.Millisecond, RenewDeadline: 200 * time.Millisecond, RetryPeriod: 20 * time.Millisecond,
+	}
+}
+
+func waitElected(t *testing.T, e *Elector) {
+	t.Helper()
+	select {
+	case <-e.Elected():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s wasn't elected", e.Identity())
+	}
+}
+
+func TestElector_FailsOver(t *testing.T) {
+	l := &memLock{}
+	a, b := newElector(l, haConfig("a"), zap.NewNop()), newElector(l, haConfig("b"), zap.NewNop())
+	ctxA, stopA := context.WithCancel(context.Background())
+	doneA := make(chan error, 1)
+	go func() { doneA <- a.Run(ctxA) }()
+	waitElected(t, a)
+
+	ctxB, stopB := context.WithCancel(context.Background())
+	defer stopB()
+	go b.Run(ctxB)
+	time.Sleep(100 * time.Millisecond)
+	if leader, leading := b.Leader(); leader != "a" || leading {
+		t.Fatalf("b: leader %q, leading %v", leader, leading)
+	}
+
+	// Stopping releases the lease, so b takes over well inside the lease
+	stopA()
+	if err := <-doneA; err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	waitElected(t, b)
+	if since := time.Since(start); since > 200*time.Millisecond {
+		t.Errorf("took over after %s", since)
+	}
+	if r, _, _ := l.get(context.Background()); r.HolderIdentity != "b" || r.LeaderTransitions != 1 {
+		t.Errorf("record %+v", r)
+	}
+}
+
+func TestElector_TakesExpiredLease(t *testing.T) {
+	// A leader that died without releasing
+	l := &memLock{record: &Record{HolderIdentity: "a", LeaseDuration: 300 * time.Millisecond}, version: 1}
+	b := newElector(l, haConfig("b"), zap.NewNop())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	start := time.Now()
+	go b.Run(ctx)
+	waitElected(t, b)
+	if since := time.Since(start); since < 300*time.Millisecond {
+		t.Errorf("took the lease over after %s, before it expired", since)
+	}
+}
+
+func TestElector_StepsDownWhenRenewalsFail(t *testing.T) {
+	l := &memLock{}
+	a := newElector(l, haConfig("a"), zap.NewNop())
+	done := make(chan error, 1)
+	go func() { done <- a.Run(context.Background()) }()
+	waitElected(t, a)
+
+	l.setFail(true)
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrLost) {
+			t.Errorf("got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("still leading")
+	}
+	if _, leading := a.Leader(); leading {
+		t.Error("leading after losing the lease")
+	}
+}
+
+// fakeAPIServer serves one Lease the way the API server does, bumping
+// its resourceVersion on every write and refusing stale ones.
+func fakeAPIServer(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	var current *lease
+	version := 0
+	const path = "/apis/coordination.k8s.io/v1/namespaces/prod/leases"
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("authorization %q", r.Header.Get("Authorization"))
+		}
+		var next lease
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == path+"/aegis":
+			if current == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(current)
+			return
+		case r.Method == http.MethodPost && r.URL.Path == path:
+			json.NewDecoder(r.Body).Decode(&next)
+			if current != nil {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPut && r.URL.Path == path+"/aegis":
+			json.NewDecoder(r.Body).Decode(&next)
+			if current == nil || next.Metadata.ResourceVersion != current.Metadata.ResourceVersion {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+		default:
+			t.Errorf("%s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		version++
+		next.Metadata.ResourceVersion = strconv.Itoa(version)
+		current = &next
+		json.NewEncoder(w).Encode(current)
+	}))
+}
+
+// fakeEtcd serves one key over the JSON gateway's range and txn.
+func fakeEtcd(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	var value []byte
+	created, modified, revision := 0, 0, 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/v3/kv/range":
+			var kvs []map[string]string
+			if created > 0 {
+				kvs = append(kvs, map[string]string{"value": base64.StdEncoding.EncodeToString(value), "mod_revision": strconv.Itoa(modified)})
+			}
+			json.NewEncoder(w).Encode(map[string]any{"kvs": kvs})
+		case "/v3/kv/txn":
+			var txn struct {
+				Compare []map[string]string `json:"compare"`
+				Success []struct {
+					RequestPut struct {
+						Value []byte `json:"value"`
+					} `json:"request_put"`
+				} `json:"success"`
+			}
+			json.NewDecoder(r.Body).Decode(&txn)
+			c := txn.Compare[0]
+			ok := c["target"] == "CREATE" && created == 0 || c["target"] == "MOD" && c["mod_revision"] == strconv.Itoa(modified)
+			if ok {
+				revision++
+				if created == 0 {
+					created = revision
+				}
+				value, modified = txn.Success[0].RequestPut.Value, revision
+			}
+			json.NewEncoder(w).Encode(map[string]any{"header": map[string]string{"revision": strconv.Itoa(revision)}, "succeeded": ok})
+		default:
+			t.Errorf("%s %s", r.Method, r.URL.Path)
+		}
+	}))
+}
+
+func TestLocks(t *testing.T) {
+	token := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(token, []byte("token\n"), 0o600)
+	k8s, etcd := fakeAPIServer(t), fakeEtcd(t)
+	defer k8s.Close()
+	defer etcd.Close()
+	etcdLock, err := newEtcdLock(config.HAConfig{EtcdConfig: config.EtcdConfig{Endpoints: []string{"http://127.0.0.1:1", etcd.URL}}, Key: "/aegis/leader"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, l := range map[string]lock{
+		"kubernetes": newLeaseLock(k8s.URL, "prod", "aegis", token),
+		"etcd":       etcdLock,
+	} {
+		ctx := context.Background()
+		if r, _, err := l.get(ctx); r != nil || err != nil {
+			t.Fatalf("%s: empty lock: %+v, %v", name, r, err)
+		}
+		now := time.Now().Truncate(time.Microsecond)
+		first := Record{HolderIdentity: "a", LeaseDuration: 15 * time.Second, AcquireTime: now, RenewTime: now}
+		v1, err := l.create(ctx, first)
+		if err != nil {
+			t.Fatalf("%s: create: %v", name, err)
+		}
+		if _, err := l.create(ctx, first); !errors.Is(err, errConflict) {
+			t.Errorf("%s: second create: %v", name, err)
+		}
+		r, version, err := l.get(ctx)
+		if err != nil || version != v1 || r.HolderIdentity != "a" || r.LeaseDuration != 15*time.Second || !r.RenewTime.Equal(now) {
+			t.Fatalf("%s: get: %+v at %q, %v", name, r, version, err)
+		}
+		next := first
+		next.HolderIdentity, next.LeaderTransitions = "b", 1
+		if _, err := l.update(ctx, next, v1); err != nil {
+			t.Fatalf("%s: update: %v", name, err)
+		}
+		if _, err := l.update(ctx, next, v1); !errors.Is(err, errConflict) {
+			t.Errorf("%s: stale update: %v", name, err)
+		}
+		if r, _, _ := l.get(ctx); r.HolderIdentity != "b" || r.LeaderTransitions != 1 {
+			t.Errorf("%s: after update: %+v", name, r)
+		}
+	}
+}
@@ -1,40 +1,125 @@
 package metrics
 
 import (
+	"hash/fnv"
+	"math"
+	"strconv"
 	"sync"
+	"time"
 
+	"github.com/lazzerex/aegis/control-plane/internal/config"
 	pb "github.com/lazzerex/aegis/control-plane/proto"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+// backendShards is how many ways the per-backend dashboard state is
+// split; every data plane reports the same backends, so one lock for all
+// of them would serialize the streams.
+const backendShards = 32
+
 type Collector struct {
+	// mu guards defaultInstance and backends. UpdateFromProto holds it for
+	// reading, so streams from different data planes update concurrently;
+	// SetBackends takes it for writing to prune every instance at once.
 	mu sync.RWMutex
 
-	// Prometheus metrics
-	activeConnections  prometheus.Gauge
-	totalConnections   prometheus.Counter
-	bytesSent          prometheus.Counter
-	bytesReceived      prometheus.Counter
-	avgLatency         prometheus.Gauge
-	p99Latency         prometheus.Gauge
+	// Prometheus metrics, all labelled with the reporting data plane's
+	// instance
+	activeConnections  *prometheus.GaugeVec
+	totalConnections   *prometheus.CounterVec
+	bytesSent          *prometheus.CounterVec
+	bytesReceived      *prometheus.CounterVec
+	avgLatency         *prometheus.GaugeVec
+	p99Latency         *prometheus.GaugeVec
 	backendConnections *prometheus.GaugeVec
 	backendRequests    *prometheus.CounterVec
 	backendFailures    *prometheus.CounterVec
 	backendLatency     *prometheus.GaugeVec
+	backendQuantiles   *prometheus.GaugeVec
+	histograms         *dataPlaneHistograms
+	slos               *sloTracker
+	top                *topTalkers
+
+	// Traffic shaping
+	rateLimitAllowed  *prometheus.CounterVec
+	rateLimitRejected *prometheus.CounterVec
+	rateLimitTokens   *prometheus.GaugeVec
+	rateLimitCapacity *prometheus.GaugeVec
+	circuitState      *prometheus.GaugeVec
+	circuitTrips      *prometheus.CounterVec
+	circuitHalfOpens  *prometheus.CounterVec
+	ejectedBackends   *prometheus.GaugeVec
+
+	// Connection limit
+	connLimitRejected *prometheus.CounterVec
+	connQueueTimeouts *prometheus.CounterVec
+	connectionsQueued *prometheus.GaugeVec
+	connectionLimit   *prometheus.GaugeVec
+
+	// latencyWindow is how far back proxy_backend_latency_ms looks.
+	latencyWindow time.Duration
+	// defaultInstance labels snapshots from data planes that don't report
+	// an instance ID.
+	defaultInstance string
+	// Last reported totals per instance, to avoid double-counting streamed
+	// totals
+	lastMu sync.Mutex
+	last   map[string]*instanceTotals
+	// backends is the configured backend set, nil until SetBackends. The
+	// data plane keeps reporting a removed backend's counters, so reports
+	// for anything outside it are ignored.
+	backends map[string]bool
 
-	// Track last reported values to avoid double-counting streamed totals
-	lastTotalConnections float64
-	lastBytesSent        float64
-	lastBytesReceived    float64
-	lastBackendRequests  map[string]float64
-	lastBackendFailures  map[string]float64
+	// Most recently reported stats and circuit breaker state per backend,
+	// for the read-only dashboard — not Prometheus metrics, just a
+	// snapshot — sharded by address.
+	shards [backendShards]backendShard
 
-	// Most recently reported circuit breaker state per backend, for the
-	// read-only dashboard — not a Prometheus metric, just a snapshot.
-	backendCircuitState map[string]string
+	// Live snapshot subscribers, for the admin API's metrics stream
+	subMu  sync.Mutex
+	subs   map[chan *pb.MetricsData]struct{}
+	latest *pb.MetricsData
+}
+
+// instanceTotals are the cumulative values one data plane last reported.
+// mu serializes that instance's updates.
+type instanceTotals struct {
+	mu                sync.Mutex
+	totalConnections  float64
+	bytesSent         float64
+	bytesReceived     float64
+	rateLimitAllowed  float64
+	rateLimitDenied   float64
+	circuitOpens      float64
+	circuitHalfOpens  float64
+	connLimitRejected float64
+	connQueueTimeouts float64
+	backends          map[string]*backendSeries
+}
 
-	backendStats map[string]BackendStat
+// backendSeries holds one instance's last totals for a backend and its
+// series, looked up once so later snapshots skip hashing the labels.
+type backendSeries struct {
+	requests    float64
+	failures    float64
+	bytes       float64
+	connections prometheus.Gauge
+	latency     prometheus.Gauge
+	requestsC   prometheus.Counter
+	failuresC   prometheus.Counter
+	// circuit is created on the first known state
+	circuit prometheus.Gauge
+	// quantiles are latencyQuantiles' gauges, created on the first
+	// connect in the window
+	quantiles     []prometheus.Gauge
+	latencyWindow latencyWindow
+}
+
+type backendShard struct {
+	mu           sync.Mutex
+	stats        map[string]BackendStat
+	circuitState map[string]string
 }
 
 type BackendStat struct {
@@ -45,143 +130,460 @@ type BackendStat struct {
 }
 
 func NewCollector() *Collector {
-	return &Collector{
-		activeConnections: promauto.NewGauge(prometheus.GaugeOpts{
+	c := &Collector{
+		activeConnections: promauto.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "proxy_active_connections",
 			Help: "Current number of active connections",
-		}),
-		totalConnections: promauto.NewCounter(prometheus.CounterOpts{
+		}, []string{"instance"}),
+		totalConnections: promauto.NewCounterVec(prometheus.CounterOpts{
 			Name: "proxy_total_connections",
 			Help: "Total number of connections handled",
-		}),
-		bytesSent: promauto.NewCounter(prometheus.CounterOpts{
+		}, []string{"instance"}),
+		bytesSent: promauto.NewCounterVec(prometheus.CounterOpts{
 			Name: "proxy_bytes_sent_total",
 			Help: "Total bytes sent to backends",
-		}),
-		bytesReceived: promauto.NewCounter(prometheus.CounterOpts{
+		}, []string{"instance"}),
+		bytesReceived: promauto.NewCounterVec(prometheus.CounterOpts{
 			Name: "proxy_bytes_received_total",
 			Help: "Total bytes received from backends",
-		}),
-		avgLatency: promauto.NewGauge(prometheus.GaugeOpts{
+		}, []string{"instance"}),
+		avgLatency: promauto.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "proxy_latency_avg_ms",
 			Help: "Average latency in milliseconds",
-		}),
-		p99Latency: promauto.NewGauge(prometheus.GaugeOpts{
+		}, []string{"instance"}),
+		p99Latency: promauto.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "proxy_latency_p99_ms",
 			Help: "P99 latency in milliseconds",
-		}),
+		}, []string{"instance"}),
 		backendConnections: promauto.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "proxy_backend_connections",
 				Help: "Active connections per backend",
 			},
-			[]string{"backend"},
+			[]string{"instance", "backend"},
 		),
 		backendRequests: promauto.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "proxy_backend_requests_total",
 				Help: "Total requests per backend",
 			},
-			[]string{"backend"},
+			[]string{"instance", "backend"},
 		),
 		backendFailures: promauto.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "proxy_backend_failures_total",
 				Help: "Total failures per backend",
 			},
-			[]string{"backend"},
+			[]string{"instance", "backend"},
 		),
 		backendLatency: promauto.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "proxy_backend_latency_avg_ms",
 				Help: "Average latency per backend in milliseconds",
 			},
-			[]string{"backend"},
+			[]string{"instance", "backend"},
+		),
+		backendQuantiles: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "proxy_backend_latency_ms",
+				Help: "Backend connect latency quantiles over metrics.latency_window in milliseconds",
+			},
+			[]string{"instance", "backend", "quantile"},
 		),
 
-		lastBackendRequests: make(map[string]float64),
-		lastBackendFailures: make(map[string]float64),
-		backendCircuitState: make(map[string]string),
-		backendStats:        make(map[string]BackendStat),
+		rateLimitAllowed: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "proxy_rate_limit_allowed_total",
+			Help: "Total requests admitted by the rate limiter",
+		}, []string{"instance"}),
+		rateLimitRejected: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "proxy_rate_limit_rejected_total",
+			Help: "Total requests rejected by the rate limiter",
+		}, []string{"instance"}),
+		rateLimitTokens: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "proxy_rate_limit_tokens",
+			Help: "Tokens currently available in the global rate limit bucket",
+		}, []string{"instance"}),
+		rateLimitCapacity: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "proxy_rate_limit_capacity",
+			Help: "Capacity of the global rate limit bucket",
+		}, []string{"instance"}),
+		circuitState: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "proxy_circuit_breaker_state",
+				Help: "Circuit breaker state per backend (0=closed, 1=half-open, 2=open)",
+			},
+			[]string{"instance", "backend"},
+		),
+		circuitTrips: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "proxy_circuit_breaker_trips_total",
+			Help: "Total times a circuit breaker opened",
+		}, []string{"instance"}),
+		circuitHalfOpens: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "proxy_circuit_breaker_half_opens_total",
+			Help: "Total times a circuit breaker moved to half-open",
+		}, []string{"instance"}),
+		ejectedBackends: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "proxy_ejected_backends",
+			Help: "Number of backends whose circuit breaker is open",
+		}, []string{"instance"}),
+		connLimitRejected: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "proxy_connection_limit_rejected_total",
+			Help: "Total TCP connections closed for being over the connection limit with no room to queue",
+		}, []string{"instance"}),
+		connQueueTimeouts: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "proxy_connection_queue_timeouts_total",
+			Help: "Total TCP connections closed after queueing for the whole queue timeout",
+		}, []string{"instance"}),
+		connectionsQueued: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "proxy_connections_queued",
+			Help: "TCP connections waiting for a slot under the connection limit",
+		}, []string{"instance"}),
+		connectionLimit: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "proxy_connection_limit",
+			Help: "Most TCP connections proxied at once, 0 for no limit",
+		}, []string{"instance"}),
+
+		histograms: newDataPlaneHistograms(),
+		slos:       newSLOTracker(),
+		top:        newTopTalkers(5 * time.Minute),
+
+		latencyWindow: 5 * time.Minute,
+
+		last: make(map[string]*instanceTotals),
+		subs: make(map[chan *pb.MetricsData]struct{}),
+	}
+	for i := range c.shards {
+		c.shards[i].stats = make(map[string]BackendStat)
+		c.shards[i].circuitState = make(map[string]string)
 	}
+	prometheus.MustRegister(c.histograms)
+	return c
 }
 
-func (c *Collector) UpdateFromProto(data *pb.MetricsData) {
+// SetDefaultInstance sets the instance label for snapshots that carry no
+// instance ID, from data planes that predate it.
+func (c *Collector) SetDefaultInstance(instance string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.defaultInstance = instance
+}
 
-	// Update global metrics
-	c.activeConnections.Set(float64(data.ActiveConnections))
+// SetSLOs sets the objectives whose burn rates are exported, discarding
+// the history of any set before.
+func (c *Collector) SetSLOs(slos []config.SLOConfig) {
+	c.slos.set(slos, time.Now())
+}
 
-	// Convert cumulative counts to increments before adding to counters
-	if delta := float64(data.TotalConnections) - c.lastTotalConnections; delta > 0 {
-		c.totalConnections.Add(delta)
-		c.lastTotalConnections = float64(data.TotalConnections)
-	}
+// SetLatencyWindow sets how far back the per-backend latency quantiles
+// look. Histories already kept are trimmed or grow into it.
+func (c *Collector) SetLatencyWindow(window time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.latencyWindow = window
+}
 
-	if delta := float64(data.BytesSent) - c.lastBytesSent; delta > 0 {
-		c.bytesSent.Add(delta)
-		c.lastBytesSent = float64(data.BytesSent)
-	}
+// SetTopWindow sets how far back Top ranks backends and clients.
+func (c *Collector) SetTopWindow(window time.Duration) {
+	c.top.setWindow(window)
+}
+
+// Top ranks backends and clients by traffic and error rate over the top
+// window, n per list. Clients are only counted when the access log stream
+// feeds TopClientsSink.
+func (c *Collector) Top(n int) Top {
+	return c.top.top(time.Now(), n)
+}
 
-	if delta := float64(data.BytesReceived) - c.lastBytesReceived; delta > 0 {
-		c.bytesReceived.Add(delta)
-		c.lastBytesReceived = float64(data.BytesReceived)
+// TopClientsSink returns an access log sink counting every record towards
+// the client rankings of Top.
+func (c *Collector) TopClientsSink() *TopClientsSink {
+	return &TopClientsSink{top: c.top}
+}
+
+func (c *Collector) UpdateFromProto(data *pb.MetricsData) {
+	c.publish(data)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	instance := data.InstanceId
+	if instance == "" {
+		instance = c.defaultInstance
 	}
-	c.avgLatency.Set(data.AvgLatencyMs)
-	c.p99Latency.Set(data.P99LatencyMs)
+	last := c.instanceTotals(instance)
+	last.mu.Lock()
+	defer last.mu.Unlock()
+
+	// Update global metrics
+	c.activeConnections.WithLabelValues(instance).Set(float64(data.ActiveConnections))
+
+	// Convert cumulative counts to increments before adding to counters
+	c.totalConnections.WithLabelValues(instance).Add(counterDelta(&last.totalConnections, data.TotalConnections))
+	c.bytesSent.WithLabelValues(instance).Add(counterDelta(&last.bytesSent, data.BytesSent))
+	c.bytesReceived.WithLabelValues(instance).Add(counterDelta(&last.bytesReceived, data.BytesReceived))
+	c.avgLatency.WithLabelValues(instance).Set(data.AvgLatencyMs)
+	c.p99Latency.WithLabelValues(instance).Set(data.P99LatencyMs)
+	c.histograms.update(instance, data, c.configuredLocked)
+	c.slos.observe(time.Now(), instance, data, c.configuredLocked)
+
+	c.rateLimitAllowed.WithLabelValues(instance).Add(counterDelta(&last.rateLimitAllowed, data.RateLimitAllowed))
+	c.rateLimitRejected.WithLabelValues(instance).Add(counterDelta(&last.rateLimitDenied, data.RateLimitDenied))
+	c.rateLimitTokens.WithLabelValues(instance).Set(float64(data.RateLimitTokens))
+	c.rateLimitCapacity.WithLabelValues(instance).Set(float64(data.RateLimitCapacity))
+	c.circuitTrips.WithLabelValues(instance).Add(counterDelta(&last.circuitOpens, data.CircuitBreakerOpens))
+	c.circuitHalfOpens.WithLabelValues(instance).Add(counterDelta(&last.circuitHalfOpens, data.CircuitBreakerHalfOpens))
+	c.ejectedBackends.WithLabelValues(instance).Set(float64(data.EjectedBackends))
+	c.connLimitRejected.WithLabelValues(instance).Add(counterDelta(&last.connLimitRejected, data.ConnectionLimitRejected))
+	c.connQueueTimeouts.WithLabelValues(instance).Add(counterDelta(&last.connQueueTimeouts, data.ConnectionQueueTimeouts))
+	c.connectionsQueued.WithLabelValues(instance).Set(float64(data.ConnectionsQueued))
+	c.connectionLimit.WithLabelValues(instance).Set(float64(data.ConnectionLimit))
 
 	// Update backend metrics
+	now := time.Now()
+	var top []TopEntry
 	for _, backend := range data.BackendMetrics {
 		addr := backend.Address
-
-		c.backendConnections.WithLabelValues(addr).Set(float64(backend.ActiveConnections))
-		c.backendLatency.WithLabelValues(addr).Set(backend.AvgLatencyMs)
-
-		if delta := float64(backend.TotalRequests) - c.lastBackendRequests[addr]; delta > 0 {
-			c.backendRequests.WithLabelValues(addr).Add(delta)
-			c.lastBackendRequests[addr] = float64(backend.TotalRequests)
+		if !c.configuredLocked(addr) {
+			continue
 		}
 
-		if delta := float64(backend.FailedRequests) - c.lastBackendFailures[addr]; delta > 0 {
-			c.backendFailures.WithLabelValues(addr).Add(delta)
-			c.lastBackendFailures[addr] = float64(backend.FailedRequests)
+		series := last.backends[addr]
+		// A backend's first snapshot is only the baseline for its traffic
+		// deltas, like the SLOs'
+		baseline := series == nil
+		if series == nil {
+			series = &backendSeries{
+				connections: c.backendConnections.WithLabelValues(instance, addr),
+				latency:     c.backendLatency.WithLabelValues(instance, addr),
+				requestsC:   c.backendRequests.WithLabelValues(instance, addr),
+				failuresC:   c.backendFailures.WithLabelValues(instance, addr),
+			}
+			last.backends[addr] = series
+		}
+		series.connections.Set(float64(backend.ActiveConnections))
+		series.latency.Set(backend.AvgLatencyMs)
+		requests := counterDelta(&series.requests, backend.TotalRequests)
+		failures := counterDelta(&series.failures, backend.FailedRequests)
+		bytes := counterDelta(&series.bytes, int64(backend.RequestBytes.GetSum()+backend.ResponseBytes.GetSum()))
+		series.requestsC.Add(requests)
+		series.failuresC.Add(failures)
+		if !baseline {
+			top = append(top, TopEntry{Key: addr, Requests: int64(requests + failures), Errors: int64(failures), Bytes: int64(bytes)})
+		}
+		c.updateQuantilesLocked(instance, addr, series, backend.ConnectLatency, now)
+		if v, ok := circuitStateValue(backend.CircuitState); ok {
+			if series.circuit == nil {
+				series.circuit = c.circuitState.WithLabelValues(instance, addr)
+			}
+			series.circuit.Set(v)
 		}
 
+		shard := c.shardFor(addr)
+		shard.mu.Lock()
 		if backend.CircuitState != "" {
-			c.backendCircuitState[addr] = backend.CircuitState
+			shard.circuitState[addr] = backend.CircuitState
 		}
-
-		c.backendStats[addr] = BackendStat{
+		shard.stats[addr] = BackendStat{
 			ActiveConnections: backend.ActiveConnections,
 			TotalRequests:     backend.TotalRequests,
 			FailedRequests:    backend.FailedRequests,
 			AvgLatencyMs:      backend.AvgLatencyMs,
 		}
+		shard.mu.Unlock()
+	}
+	c.top.addBackends(now, top)
+}
+
+// updateQuantilesLocked sets addr's latency quantiles from the connects
+// in the window. A backend with none in it exports NaN, as a summary
+// would.
+func (c *Collector) updateQuantilesLocked(instance, addr string, series *backendSeries, hist *pb.LatencyHistogram, now time.Time) {
+	counts, count, ok := series.latencyWindow.observe(now, hist, c.latencyWindow)
+	if series.quantiles == nil {
+		if !ok {
+			return
+		}
+		for _, q := range latencyQuantiles {
+			series.quantiles = append(series.quantiles,
+				c.backendQuantiles.WithLabelValues(instance, addr, strconv.FormatFloat(q, 'g', -1, 64)))
+		}
+	}
+	for i, q := range latencyQuantiles {
+		v := math.NaN()
+		if ok {
+			v = HistogramQuantile(q, hist.BoundsMs, counts, count)
+		}
+		series.quantiles[i].Set(v)
+	}
+}
+
+// instanceTotals returns instance's last reported totals, creating them on
+// its first snapshot.
+func (c *Collector) instanceTotals(instance string) *instanceTotals {
+	c.lastMu.Lock()
+	defer c.lastMu.Unlock()
+	last := c.last[instance]
+	if last == nil {
+		last = &instanceTotals{backends: make(map[string]*backendSeries)}
+		c.last[instance] = last
+	}
+	return last
+}
+
+func (c *Collector) shardFor(addr string) *backendShard {
+	h := fnv.New32a()
+	h.Write([]byte(addr))
+	return &c.shards[h.Sum32()%backendShards]
+}
+
+// SetBackends makes addresses the configured backend set, deleting the
+// series of every backend no longer in it so removed backends don't
+// accumulate label values forever.
+func (c *Collector) SetBackends(addresses []string) {
+	next := make(map[string]bool, len(addresses))
+	for _, addr := range addresses {
+		next[addr] = true
+	}
+
+	// Holding mu for writing waits out every update in progress
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastMu.Lock()
+	for instance, last := range c.last {
+		for addr := range last.backends {
+			if !next[addr] {
+				c.forgetBackendLocked(instance, last, addr)
+			}
+		}
+	}
+	c.lastMu.Unlock()
+	for i := range c.shards {
+		shard := &c.shards[i]
+		shard.mu.Lock()
+		for addr := range shard.stats {
+			if !next[addr] {
+				delete(shard.stats, addr)
+				delete(shard.circuitState, addr)
+			}
+		}
+		shard.mu.Unlock()
+	}
+	c.histograms.retain(next)
+	c.backends = next
+}
+
+func (c *Collector) configuredLocked(addr string) bool {
+	return c.backends == nil || c.backends[addr]
+}
+
+func (c *Collector) forgetBackendLocked(instance string, last *instanceTotals, addr string) {
+	c.backendConnections.DeleteLabelValues(instance, addr)
+	c.backendRequests.DeleteLabelValues(instance, addr)
+	c.backendFailures.DeleteLabelValues(instance, addr)
+	c.backendLatency.DeleteLabelValues(instance, addr)
+	c.circuitState.DeleteLabelValues(instance, addr)
+	c.backendQuantiles.DeletePartialMatch(prometheus.Labels{"instance": instance, "backend": addr})
+	delete(last.backends, addr)
+}
+
+// circuitStateValue maps a reported circuit state to the
+// proxy_circuit_breaker_state value. "unknown" (a backend with no breaker
+// yet) has none.
+func circuitStateValue(state string) (float64, bool) {
+	switch state {
+	case "Closed":
+		return 0, true
+	case "HalfOpen":
+		return 1, true
+	case "Open":
+		return 2, true
 	}
+	return 0, false
+}
+
+// counterDelta converts a cumulative total from the data plane into the
+// increment since *last, and records it as the new last value. A total
+// below the last one means the data plane restarted and began counting
+// from zero, so everything it has counted since is new. (A restart that
+// has already overtaken the old total by the next snapshot looks like
+// ordinary growth and undercounts; snapshots are seconds apart.)
+func counterDelta(last *float64, total int64) float64 {
+	value := float64(total)
+	delta := value - *last
+	if delta < 0 {
+		delta = value
+	}
+	*last = value
+	return delta
 }
 
 // BackendCircuitStates returns the most recently reported circuit breaker
 // state per backend address (e.g. "Closed", "Open", "HalfOpen"). Backends
 // not yet reported (no metrics received) are simply absent from the map.
 func (c *Collector) BackendCircuitStates() map[string]string {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-
-	states := make(map[string]string, len(c.backendCircuitState))
-	for k, v := range c.backendCircuitState {
-		states[k] = v
+	states := make(map[string]string)
+	for i := range c.shards {
+		shard := &c.shards[i]
+		shard.mu.Lock()
+		for k, v := range shard.circuitState {
+			states[k] = v
+		}
+		shard.mu.Unlock()
 	}
 	return states
 }
 
 func (c *Collector) BackendStats() map[string]BackendStat {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-
-	stats := make(map[string]BackendStat, len(c.backendStats))
-	for k, v := range c.backendStats {
-		stats[k] = v
+	stats := make(map[string]BackendStat)
+	for i := range c.shards {
+		shard := &c.shards[i]
+		shard.mu.Lock()
+		for k, v := range shard.stats {
+			stats[k] = v
+		}
+		shard.mu.Unlock()
 	}
 	return stats
 }
+
+// Subscribe returns a channel receiving each snapshot passed to
+// UpdateFromProto, starting with the most recent one if any, and a func to
+// unsubscribe. A subscriber that falls behind only misses intermediate
+// snapshots: the channel always holds the newest.
+func (c *Collector) Subscribe() (<-chan *pb.MetricsData, func()) {
+	ch := make(chan *pb.MetricsData, 1)
+	c.subMu.Lock()
+	if c.latest != nil {
+		ch <- c.latest
+	}
+	c.subs[ch] = struct{}{}
+	c.subMu.Unlock()
+
+	return ch, func() {
+		c.subMu.Lock()
+		delete(c.subs, ch)
+		c.subMu.Unlock()
+	}
+}
+
+// Latest returns the most recent snapshot passed to UpdateFromProto, or
+// nil before the first one.
+func (c *Collector) Latest() *pb.MetricsData {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	return c.latest
+}
+
+func (c *Collector) publish(data *pb.MetricsData) {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	c.latest = data
+	for ch := range c.subs {
+		// Replace an unread snapshot; totals are cumulative so nothing is lost
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- data
+	}
+}
@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+)
+
+const benchBackends = 5000
+
+func benchSnapshot(instance string, backends int) *pb.MetricsData {
+	data := &pb.MetricsData{InstanceId: instance, TotalConnections: 1}
+	for i := 0; i < backends; i++ {
+		data.BackendMetrics = append(data.BackendMetrics, &pb.BackendMetrics{
+			Address:       fmt.Sprintf("collector-bench-%d:8080", i),
+			TotalRequests: 1,
+			CircuitState:  "Closed",
+		})
+	}
+	return data
+}
+
+func BenchmarkUpdateFromProto(b *testing.B) {
+	c := sharedTestCollector(b)
+	data := benchSnapshot("collector-bench-serial", benchBackends)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data.TotalConnections++
+		c.UpdateFromProto(data)
+	}
+}
+
+// Each goroutine streams as its own data plane, reporting the same
+// backends, like a fleet behind one control plane.
+func BenchmarkUpdateFromProto_Parallel(b *testing.B) {
+	c := sharedTestCollector(b)
+	var n atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		data := benchSnapshot(fmt.Sprintf("collector-bench-%d", n.Add(1)), benchBackends)
+		for p.Next() {
+			data.TotalConnections++
+			c.UpdateFromProto(data)
+		}
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	pb "github.com/lazzerex/aegis/control-plane/proto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 var (
@@ -12,7 +13,7 @@ var (
 	testCollectorInst *Collector
 )
 
-func sharedTestCollector(t *testing.T) *Collector {
+func sharedTestCollector(t testing.TB) *Collector {
 	t.Helper()
 	testCollectorOnce.Do(func() {
 		testCollectorInst = NewCollector()
@@ -92,3 +93,195 @@ func TestUpdateFromProto_StatsOverwriteOnSubsequentCalls(t *testing.T) {
 		t.Errorf("circuit state: got %q, want Open (latest snapshot)", states["collector-test-b:3000"])
 	}
 }
+
+func TestUpdateFromProto_LabelsAndCountsPerInstance(t *testing.T) {
+	c := sharedTestCollector(t)
+	c.SetDefaultInstance("collector-test-default:50051")
+	defer c.SetDefaultInstance("")
+
+	backend := []*pb.BackendMetrics{{Address: "collector-test-c:3000", TotalRequests: 7}}
+	c.UpdateFromProto(&pb.MetricsData{InstanceId: "collector-test-dp-1", TotalConnections: 10, BackendMetrics: backend})
+	// A second data plane with lower totals must not be read as no progress
+	c.UpdateFromProto(&pb.MetricsData{InstanceId: "collector-test-dp-2", TotalConnections: 4, BackendMetrics: backend})
+	c.UpdateFromProto(&pb.MetricsData{TotalConnections: 1})
+
+	for instance, want := range map[string]float64{
+		"collector-test-dp-1":          10,
+		"collector-test-dp-2":          4,
+		"collector-test-default:50051": 1,
+	} {
+		if got := testutil.ToFloat64(c.totalConnections.WithLabelValues(instance)); got != want {
+			t.Errorf("proxy_total_connections{instance=%q}: got %v, want %v", instance, got, want)
+		}
+	}
+	for _, instance := range []string{"collector-test-dp-1", "collector-test-dp-2"} {
+		if got := testutil.ToFloat64(c.backendRequests.WithLabelValues(instance, "collector-test-c:3000")); got != 7 {
+			t.Errorf("proxy_backend_requests_total{instance=%q}: got %v, want 7", instance, got)
+		}
+	}
+}
+
+func TestUpdateFromProto_CounterResetCountsNewTotal(t *testing.T) {
+	c := sharedTestCollector(t)
+	const instance = "collector-test-reset"
+	snapshot := func(total, requests, failures int64) *pb.MetricsData {
+		return &pb.MetricsData{InstanceId: instance, TotalConnections: total, BytesSent: total * 100,
+			BackendMetrics: []*pb.BackendMetrics{{Address: "collector-test-d:3000", TotalRequests: requests, FailedRequests: failures}}}
+	}
+
+	c.UpdateFromProto(snapshot(100, 50, 5))
+	// The data plane restarts: totals start again from zero, except
+	// failures, which haven't reset for this series
+	c.UpdateFromProto(snapshot(3, 2, 7))
+	c.UpdateFromProto(snapshot(10, 4, 8))
+
+	for name, tc := range map[string]struct{ got, want float64 }{
+		"proxy_total_connections":      {testutil.ToFloat64(c.totalConnections.WithLabelValues(instance)), 110},
+		"proxy_bytes_sent_total":       {testutil.ToFloat64(c.bytesSent.WithLabelValues(instance)), 11000},
+		"proxy_backend_requests_total": {testutil.ToFloat64(c.backendRequests.WithLabelValues(instance, "collector-test-d:3000")), 54},
+		"proxy_backend_failures_total": {testutil.ToFloat64(c.backendFailures.WithLabelValues(instance, "collector-test-d:3000")), 8},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s: got %v, want %v", name, tc.got, tc.want)
+		}
+	}
+}
+
+func TestUpdateFromProto_TrafficShaping(t *testing.T) {
+	c := sharedTestCollector(t)
+	const instance = "collector-test-shaping"
+	c.UpdateFromProto(&pb.MetricsData{InstanceId: instance, RateLimitAllowed: 90, RateLimitDenied: 10,
+		RateLimitTokens: 40, RateLimitCapacity: 100, CircuitBreakerOpens: 1})
+	c.UpdateFromProto(&pb.MetricsData{InstanceId: instance, RateLimitAllowed: 95, RateLimitDenied: 30,
+		RateLimitTokens: 0, RateLimitCapacity: 100, CircuitBreakerOpens: 2, CircuitBreakerHalfOpens: 1,
+		EjectedBackends: 1, BackendMetrics: []*pb.BackendMetrics{
+			{Address: "collector-test-f:3000", CircuitState: "Closed"},
+			{Address: "collector-test-f:3001", CircuitState: "HalfOpen"},
+			{Address: "collector-test-f:3002", CircuitState: "Open"},
+			{Address: "collector-test-f:3003", CircuitState: "unknown"},
+		}})
+
+	for name, tc := range map[string]struct{ got, want float64 }{
+		"proxy_rate_limit_allowed_total":         {testutil.ToFloat64(c.rateLimitAllowed.WithLabelValues(instance)), 95},
+		"proxy_rate_limit_rejected_total":        {testutil.ToFloat64(c.rateLimitRejected.WithLabelValues(instance)), 30},
+		"proxy_rate_limit_tokens":                {testutil.ToFloat64(c.rateLimitTokens.WithLabelValues(instance)), 0},
+		"proxy_rate_limit_capacity":              {testutil.ToFloat64(c.rateLimitCapacity.WithLabelValues(instance)), 100},
+		"proxy_circuit_breaker_trips_total":      {testutil.ToFloat64(c.circuitTrips.WithLabelValues(instance)), 2},
+		"proxy_circuit_breaker_half_opens_total": {testutil.ToFloat64(c.circuitHalfOpens.WithLabelValues(instance)), 1},
+		"proxy_ejected_backends":                 {testutil.ToFloat64(c.ejectedBackends.WithLabelValues(instance)), 1},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s: got %v, want %v", name, tc.got, tc.want)
+		}
+	}
+	for addr, want := range map[string]float64{"collector-test-f:3000": 0, "collector-test-f:3001": 1, "collector-test-f:3002": 2} {
+		if got := testutil.ToFloat64(c.circuitState.WithLabelValues(instance, addr)); got != want {
+			t.Errorf("proxy_circuit_breaker_state{backend=%q}: got %v, want %v", addr, got, want)
+		}
+	}
+	if c.circuitState.DeleteLabelValues(instance, "collector-test-f:3003") {
+		t.Error("a backend with no breaker got a circuit state series")
+	}
+}
+
+func TestUpdateFromProto_ConnectionLimit(t *testing.T) {
+	c := sharedTestCollector(t)
+	const instance = "collector-test-connlimit"
+	c.UpdateFromProto(&pb.MetricsData{InstanceId: instance, ConnectionLimitRejected: 4, ConnectionLimit: 100})
+	c.UpdateFromProto(&pb.MetricsData{InstanceId: instance, ConnectionLimitRejected: 7, ConnectionQueueTimeouts: 2,
+		ConnectionsQueued: 12, ConnectionLimit: 100})
+
+	for name, tc := range map[string]struct{ got, want float64 }{
+		"proxy_connection_limit_rejected_total": {testutil.ToFloat64(c.connLimitRejected.WithLabelValues(instance)), 7},
+		"proxy_connection_queue_timeouts_total": {testutil.ToFloat64(c.connQueueTimeouts.WithLabelValues(instance)), 2},
+		"proxy_connections_queued":              {testutil.ToFloat64(c.connectionsQueued.WithLabelValues(instance)), 12},
+		"proxy_connection_limit":                {testutil.ToFloat64(c.connectionLimit.WithLabelValues(instance)), 100},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s: got %v, want %v", name, tc.got, tc.want)
+		}
+	}
+}
+
+func TestSetBackends_DeletesRemovedBackendSeries(t *testing.T) {
+	c := sharedTestCollector(t)
+	defer func() {
+		c.mu.Lock()
+		c.backends = nil
+		c.mu.Unlock()
+	}()
+	const instance = "collector-test-prune"
+	snapshot := &pb.MetricsData{InstanceId: instance, BackendMetrics: []*pb.BackendMetrics{
+		{Address: "collector-test-e:3000", TotalRequests: 5, CircuitState: "Closed"},
+		{Address: "collector-test-e:3001", TotalRequests: 9, CircuitState: "Open"},
+	}}
+	c.UpdateFromProto(snapshot)
+
+	c.SetBackends([]string{"collector-test-e:3000"})
+	// The data plane still reports the removed backend
+	c.UpdateFromProto(snapshot)
+
+	if got := testutil.ToFloat64(c.backendRequests.WithLabelValues(instance, "collector-test-e:3000")); got != 5 {
+		t.Errorf("kept backend requests: got %v, want 5", got)
+	}
+	if c.backendRequests.DeleteLabelValues(instance, "collector-test-e:3001") ||
+		c.circuitState.DeleteLabelValues(instance, "collector-test-e:3001") {
+		t.Error("removed backend's series still present")
+	}
+	if _, ok := c.BackendStats()["collector-test-e:3001"]; ok {
+		t.Error("removed backend still in BackendStats")
+	}
+	if _, ok := c.BackendCircuitStates()["collector-test-e:3001"]; ok {
+		t.Error("removed backend still in BackendCircuitStates")
+	}
+}
+
+func TestSubscribe_KeepsNewestSnapshot(t *testing.T) {
+	c := sharedTestCollector(t)
+
+	c.UpdateFromProto(&pb.MetricsData{Timestamp: 1})
+	if got := c.Latest(); got == nil || got.Timestamp != 1 {
+		t.Errorf("Latest: got %v", got)
+	}
+	ch, unsubscribe := c.Subscribe()
+	if got := <-ch; got.Timestamp != 1 {
+		t.Errorf("initial snapshot: got timestamp %d, want 1", got.Timestamp)
+	}
+
+	c.UpdateFromProto(&pb.MetricsData{Timestamp: 2})
+	c.UpdateFromProto(&pb.MetricsData{Timestamp: 3})
+	if got := <-ch; got.Timestamp != 3 {
+		t.Errorf("slow subscriber: got timestamp %d, want the newest (3)", got.Timestamp)
+	}
+
+	unsubscribe()
+	c.UpdateFromProto(&pb.MetricsData{Timestamp: 4})
+	select {
+	case got := <-ch:
+		t.Errorf("received timestamp %d after unsubscribing", got.Timestamp)
+	default:
+	}
+}
+
+func TestUpdateFromProto_ConcurrentInstances(t *testing.T) {
+	c := sharedTestCollector(t)
+	var wg sync.WaitGroup
+	for _, instance := range []string{"collector-test-conc-1", "collector-test-conc-2", "collector-test-conc-3"} {
+		wg.Add(1)
+		go func(instance string) {
+			defer wg.Done()
+			for i := int64(1); i <= 50; i++ {
+				c.UpdateFromProto(&pb.MetricsData{InstanceId: instance, BackendMetrics: []*pb.BackendMetrics{
+					{Address: "collector-test-conc:1", TotalRequests: i},
+				}})
+			}
+		}(instance)
+	}
+	wg.Wait()
+
+	for _, instance := range []string{"collector-test-conc-1", "collector-test-conc-2", "collector-test-conc-3"} {
+		if got := testutil.ToFloat64(c.backendRequests.WithLabelValues(instance, "collector-test-conc:1")); got != 50 {
+			t.Errorf("%s requests: got %v, want 50", instance, got)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// fleetMax lists the per-instance gauges that don't add up across data
+// planes; the fleet view reports the worst instance instead.
+var fleetMax = map[string]bool{
+	"proxy_latency_avg_ms":         true,
+	"proxy_latency_p99_ms":         true,
+	"proxy_backend_latency_avg_ms": true,
+	"proxy_backend_latency_ms":     true,
+	"proxy_circuit_breaker_state":  true,
+}
+
+// fleetGatherer rolls up every per-data-plane family another gatherer
+// reports into one series per remaining label set, dropping the instance
+// label: proxy_backend_requests_total{backend} is the fleet's requests to
+// that backend. Counters, histograms and most gauges are summed. Families
+// without an instance label aren't per data plane and are left out.
+type fleetGatherer struct {
+	gatherer prometheus.Gatherer
+}
+
+// NewFleetGatherer returns the fleet-level view of gatherer served at
+// /metrics/fleet, for alerting rules that want the sum rather than one
+// series per instance.
+func NewFleetGatherer(gatherer prometheus.Gatherer) prometheus.Gatherer {
+	return &fleetGatherer{gatherer: gatherer}
+}
+
+func (g *fleetGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.gatherer.Gather()
+	var out []*dto.MetricFamily
+	for _, mf := range families {
+		if rolled := rollUpFamily(mf); rolled != nil {
+			out = append(out, rolled)
+		}
+	}
+	return out, err
+}
+
+// rollUpFamily aggregates mf's series over the instance label, or returns
+// nil if none of them has one.
+func rollUpFamily(mf *dto.MetricFamily) *dto.MetricFamily {
+	worst := fleetMax[mf.GetName()]
+	groups := make(map[string]*dto.Metric)
+	var keys []string
+	for _, m := range mf.Metric {
+		labels, ok := withoutInstance(m.Label)
+		if !ok {
+			continue
+		}
+		key := labelKey(labels)
+		agg, seen := groups[key]
+		if !seen {
+			groups[key] = newFleetMetric(mf.GetType(), labels, m)
+			keys = append(keys, key)
+			continue
+		}
+		mergeFleetMetric(mf.GetType(), agg, m, worst)
+	}
+	if len(groups) == 0 {
+		return nil
+	}
+
+	sort.Strings(keys)
+	rolled := &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type}
+	for _, key := range keys {
+		rolled.Metric = append(rolled.Metric, groups[key])
+	}
+	return rolled
+}
+
+func withoutInstance(labels []*dto.LabelPair) ([]*dto.LabelPair, bool) {
+	out := make([]*dto.LabelPair, 0, len(labels))
+	found := false
+	for _, l := range labels {
+		if l.GetName() == "instance" {
+			found = true
+			continue
+		}
+		out = append(out, l)
+	}
+	return out, found
+}
+
+func labelKey(labels []*dto.LabelPair) string {
+	var b strings.Builder
+	for _, l := range labels {
+		b.WriteString(l.GetName())
+		b.WriteByte(0)
+		b.WriteString(l.GetValue())
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// newFleetMetric starts an aggregate from m's values under labels. It's a
+// copy so merging never changes what the underlying gatherer returned.
+// Exemplars and timestamps belong to one instance's sample and are dropped.
+func newFleetMetric(typ dto.MetricType, labels []*dto.LabelPair, m *dto.Metric) *dto.Metric {
+	agg := &dto.Metric{Label: labels}
+	switch typ {
+	case dto.MetricType_COUNTER:
+		agg.Counter = &dto.Counter{Value: proto.Float64(m.GetCounter().GetValue())}
+	case dto.MetricType_GAUGE:
+		agg.Gauge = &dto.Gauge{Value: proto.Float64(m.GetGauge().GetValue())}
+	case dto.MetricType_UNTYPED:
+		agg.Untyped = &dto.Untyped{Value: proto.Float64(m.GetUntyped().GetValue())}
+	case dto.MetricType_HISTOGRAM:
+		h := m.GetHistogram()
+		agg.Histogram = &dto.Histogram{
+			SampleCount: proto.Uint64(h.GetSampleCount()),
+			SampleSum:   proto.Float64(h.GetSampleSum()),
+		}
+		for _, b := range h.Bucket {
+			agg.Histogram.Bucket = append(agg.Histogram.Bucket, &dto.Bucket{
+				UpperBound:      proto.Float64(b.GetUpperBound()),
+				CumulativeCount: proto.Uint64(b.GetCumulativeCount()),
+			})
+		}
+	case dto.MetricType_SUMMARY:
+		// Quantiles can't be combined; keep the count and sum
+		s := m.GetSummary()
+		agg.Summary = &dto.Summary{
+			SampleCount: proto.Uint64(s.GetSampleCount()),
+			SampleSum:   proto.Float64(s.GetSampleSum()),
+		}
+	}
+	return agg
+}
+
+func mergeFleetMetric(typ dto.MetricType, agg, m *dto.Metric, worst bool) {
+	switch typ {
+	case dto.MetricType_COUNTER:
+		*agg.Counter.Value += m.GetCounter().GetValue()
+	case dto.MetricType_GAUGE:
+		v := m.GetGauge().GetValue()
+		if !worst {
+			*agg.Gauge.Value += v
+		} else if v > *agg.Gauge.Value {
+			*agg.Gauge.Value = v
+		}
+	case dto.MetricType_UNTYPED:
+		*agg.Untyped.Value += m.GetUntyped().GetValue()
+	case dto.MetricType_HISTOGRAM:
+		h := m.GetHistogram()
+		*agg.Histogram.SampleCount += h.GetSampleCount()
+		*agg.Histogram.SampleSum += h.GetSampleSum()
+		mergeBuckets(agg.Histogram, h.Bucket)
+	case dto.MetricType_SUMMARY:
+		s := m.GetSummary()
+		*agg.Summary.SampleCount += s.GetSampleCount()
+		*agg.Summary.SampleSum += s.GetSampleSum()
+	}
+}
+
+// mergeBuckets adds buckets into agg's. Instances normally share bounds;
+// when one reports a bound the aggregate lacks, the bucket is added with
+// the aggregate's count at or below that bound so counts stay cumulative.
+func mergeBuckets(agg *dto.Histogram, buckets []*dto.Bucket) {
+	existing := append([]*dto.Bucket(nil), agg.Bucket...)
+	byBound := make(map[float64]*dto.Bucket, len(agg.Bucket))
+	for _, b := range agg.Bucket {
+		byBound[b.GetUpperBound()] = b
+	}
+	for _, b := range buckets {
+		if _, ok := byBound[b.GetUpperBound()]; ok {
+			continue
+		}
+		nb := &dto.Bucket{
+			UpperBound:      proto.Float64(b.GetUpperBound()),
+			CumulativeCount: proto.Uint64(cumulativeAt(existing, b.GetUpperBound())),
+		}
+		agg.Bucket = append(agg.Bucket, nb)
+		byBound[b.GetUpperBound()] = nb
+	}
+	sort.Slice(agg.Bucket, func(i, j int) bool { return agg.Bucket[i].GetUpperBound() < agg.Bucket[j].GetUpperBound() })
+
+	for _, ab := range agg.Bucket {
+		*ab.CumulativeCount += cumulativeAt(buckets, ab.GetUpperBound())
+	}
+}
+
+// cumulativeAt is the count at or below bound in sorted cumulative buckets,
+// taken from the largest bucket not above it.
+func cumulativeAt(buckets []*dto.Bucket, bound float64) uint64 {
+	var count uint64
+	for _, b := range buckets {
+		if b.GetUpperBound() > bound {
+			break
+		}
+		count = b.GetCumulativeCount()
+	}
+	return count
+}
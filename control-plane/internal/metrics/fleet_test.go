@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestFleetGatherer_RollsUpInstances(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "proxy_backend_requests_total", Help: "Requests"}, []string{"instance", "backend"})
+	p99 := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "proxy_latency_p99_ms", Help: "P99"}, []string{"instance"})
+	latency := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "proxy_connect_duration_seconds", Help: "Latency", Buckets: []float64{0.1, 1}}, []string{"instance"})
+	reloads := prometheus.NewCounter(prometheus.CounterOpts{Name: "aegis_config_reloads_total", Help: "Reloads"})
+	reg.MustRegister(requests, p99, latency, reloads)
+
+	requests.WithLabelValues("dp-1", "a:1").Add(3)
+	requests.WithLabelValues("dp-2", "a:1").Add(4)
+	requests.WithLabelValues("dp-2", "b:1").Add(1)
+	p99.WithLabelValues("dp-1").Set(12)
+	p99.WithLabelValues("dp-2").Set(40)
+	latency.WithLabelValues("dp-1").Observe(0.05)
+	latency.WithLabelValues("dp-2").Observe(0.5)
+	latency.WithLabelValues("dp-2").Observe(5)
+	reloads.Inc()
+
+	want := `
+# HELP proxy_backend_requests_total Requests
+# TYPE proxy_backend_requests_total counter
+proxy_backend_requests_total{backend="a:1"} 7
+proxy_backend_requests_total{backend="b:1"} 1
+# HELP proxy_connect_duration_seconds Latency
+# TYPE proxy_connect_duration_seconds histogram
+proxy_connect_duration_seconds_bucket{le="0.1"} 1
+proxy_connect_duration_seconds_bucket{le="1"} 2
+proxy_connect_duration_seconds_bucket{le="+Inf"} 3
+proxy_connect_duration_seconds_sum 5.55
+proxy_connect_duration_seconds_count 3
+# HELP proxy_latency_p99_ms P99
+# TYPE proxy_latency_p99_ms gauge
+proxy_latency_p99_ms 40
+`
+	if err := testutil.GatherAndCompare(NewFleetGatherer(reg), strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+	// The rollup works on copies
+	if got := testutil.ToFloat64(requests.WithLabelValues("dp-1", "a:1")); got != 3 {
+		t.Errorf("underlying counter changed: %v", got)
+	}
+}
+
+func TestMergeBuckets_DifferentBoundsStayCumulative(t *testing.T) {
+	bucket := func(bound float64, count uint64) *dto.Bucket {
+		return &dto.Bucket{UpperBound: proto.Float64(bound), CumulativeCount: proto.Uint64(count)}
+	}
+	agg := &dto.Histogram{Bucket: []*dto.Bucket{bucket(1, 2), bucket(10, 5)}}
+	mergeBuckets(agg, []*dto.Bucket{bucket(5, 1), bucket(10, 3)})
+
+	var got []string
+	for _, b := range agg.Bucket {
+		got = append(got, fmt.Sprintf("%v:%d", b.GetUpperBound(), b.GetCumulativeCount()))
+	}
+	// le=5 gets 2 from the aggregate's le=1 and 1 from the new le=5
+	if want := "1:2 5:3 10:8"; strings.Join(got, " ") != want {
+		t.Errorf("buckets: got %v, want %s", got, want)
+	}
+}
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"path"
+	"sort"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// labelledGatherer applies metrics.namespace and metrics.const_labels to
+// everything another gatherer reports. Most metrics are registered by
+// package variables before the config is read, so this is done as they're
+// gathered rather than when they're registered.
+type labelledGatherer struct {
+	gatherer prometheus.Gatherer
+	prefix   string
+	labels   []*dto.LabelPair
+}
+
+// NewGatherer wraps gatherer with cfg's include and exclude filters,
+// namespace and constant labels, or returns it as is when none is set. A
+// series that already has one of the constant labels keeps its own value.
+func NewGatherer(cfg config.MetricsConfig, gatherer prometheus.Gatherer) prometheus.Gatherer {
+	if len(cfg.Include) > 0 || len(cfg.Exclude) > 0 {
+		gatherer = FilterGatherer(gatherer, cfg.Include, cfg.Exclude)
+	}
+	if cfg.Namespace == "" && len(cfg.ConstLabels) == 0 {
+		return gatherer
+	}
+	g := &labelledGatherer{gatherer: gatherer}
+	if cfg.Namespace != "" {
+		g.prefix = cfg.Namespace + "_"
+	}
+	for name, value := range cfg.ConstLabels {
+		g.labels = append(g.labels, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+	}
+	return g
+}
+
+func (g *labelledGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.gatherer.Gather()
+	for _, mf := range families {
+		if g.prefix != "" {
+			mf.Name = proto.String(g.prefix + mf.GetName())
+		}
+		if len(g.labels) == 0 {
+			continue
+		}
+		for _, m := range mf.Metric {
+			m.Label = g.withConstLabels(m.Label)
+		}
+	}
+	return families, err
+}
+
+func (g *labelledGatherer) withConstLabels(labels []*dto.LabelPair) []*dto.LabelPair {
+	have := make(map[string]bool, len(labels))
+	for _, l := range labels {
+		have[l.GetName()] = true
+	}
+	for _, l := range g.labels {
+		if !have[l.GetName()] {
+			labels = append(labels, l)
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+	return labels
+}
+
+// filteredGatherer drops the families whose names aren't selected by
+// include and exclude.
+type filteredGatherer struct {
+	gatherer prometheus.Gatherer
+	include  []string
+	exclude  []string
+}
+
+// FilterGatherer keeps only the families gatherer reports whose name
+// matches one of the include globs (every family if include is empty) and
+// none of the exclude globs. Globs use path.Match syntax; a malformed one
+// matches nothing.
+func FilterGatherer(gatherer prometheus.Gatherer, include, exclude []string) prometheus.Gatherer {
+	return &filteredGatherer{gatherer: gatherer, include: include, exclude: exclude}
+}
+
+func (g *filteredGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.gatherer.Gather()
+	kept := families[:0]
+	for _, mf := range families {
+		name := mf.GetName()
+		if (len(g.include) == 0 || matchesAny(g.include, name)) && !matchesAny(g.exclude, name) {
+			kept = append(kept, mf)
+		}
+	}
+	return kept, err
+}
+
+func matchesAny(globs []string, name string) bool {
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNewGatherer_AppliesNamespaceAndConstLabels(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_requests_total", Help: "Requests"}, []string{"backend", "region"})
+	reg.MustRegister(requests)
+	requests.WithLabelValues("a:1", "eu-west-1").Add(2)
+
+	g := NewGatherer(config.MetricsConfig{
+		Namespace:   "edge",
+		ConstLabels: map[string]string{"cluster": "prod", "region": "us-east-1"},
+	}, reg)
+
+	// The series' own region wins over the constant one
+	want := `
+# HELP edge_test_requests_total Requests
+# TYPE edge_test_requests_total counter
+edge_test_requests_total{backend="a:1",cluster="prod",region="eu-west-1"} 2
+`
+	if err := testutil.GatherAndCompare(g, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+
+	if NewGatherer(config.MetricsConfig{}, reg) != prometheus.Gatherer(reg) {
+		t.Error("gatherer wrapped with nothing to apply")
+	}
+}
+
+func TestNewGatherer_IncludeAndExclude(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	for _, name := range []string{"proxy_active_connections", "proxy_backend_connections", "proxy_backend_requests_total", "aegis_config_reloads_total"} {
+		g := prometheus.NewGauge(prometheus.GaugeOpts{Name: name, Help: name})
+		reg.MustRegister(g)
+		g.Set(1)
+	}
+
+	g := NewGatherer(config.MetricsConfig{
+		Namespace: "edge",
+		Include:   []string{"proxy_*"},
+		Exclude:   []string{"proxy_backend_*"},
+	}, reg)
+	families, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 1 || families[0].GetName() != "edge_proxy_active_connections" {
+		t.Errorf("got %v, want only edge_proxy_active_connections", families)
+	}
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/graphite"
+	"go.uber.org/zap"
+)
+
+// NewGraphiteBridge pushes everything gatherer reports to a Graphite
+// plaintext listener, one path per series as client_golang's bridge lays
+// them out: prefix.metric_name.label.value... (or tagged with cfg.Tags),
+// with histograms as their _bucket, _sum and _count series. Call Run on
+// the result.
+func NewGraphiteBridge(cfg config.GraphiteConfig, gatherer prometheus.Gatherer, logger *zap.Logger) (*graphite.Bridge, error) {
+	return graphite.NewBridge(&graphite.Config{
+		URL:           net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		Prefix:        cfg.Prefix,
+		UseTags:       cfg.Tags,
+		Interval:      cfg.Interval,
+		Timeout:       cfg.Timeout,
+		Gatherer:      gatherer,
+		Logger:        graphiteLogger{logger},
+		ErrorHandling: graphite.ContinueOnError,
+	})
+}
+
+// graphiteLogger logs the bridge's failed pushes as warnings.
+type graphiteLogger struct {
+	logger *zap.Logger
+}
+
+func (l graphiteLogger) Println(v ...interface{}) {
+	l.logger.Warn("Graphite metrics push failed", zap.String("error", strings.TrimSpace(fmt.Sprintln(v...))))
+}
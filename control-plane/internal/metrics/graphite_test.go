@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+func TestGraphiteBridge_PushesPlaintext(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_requests_total"}, []string{"backend"})
+	reg.MustRegister(requests)
+	requests.WithLabelValues("a:1").Add(3)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b, _ := io.ReadAll(conn)
+		received <- string(b)
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	b, err := NewGraphiteBridge(config.GraphiteConfig{
+		Host: addr.IP.String(), Port: addr.Port, Prefix: "aegis.prod", Interval: time.Minute, Timeout: time.Second,
+	}, reg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewGraphiteBridge: %v", err)
+	}
+	if err := b.Push(); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+
+	select {
+	case got := <-received:
+		fields := strings.Fields(got)
+		if len(fields) != 3 || fields[0] != "aegis.prod.test_requests_total.backend.a:1" || fields[1] != "3" {
+			t.Errorf("got %q", got)
+		}
+		if _, err := strconv.ParseInt(fields[len(fields)-1], 10, 64); err != nil {
+			t.Errorf("timestamp: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("nothing received")
+	}
+}
@@ -0,0 +1,170 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dataPlaneHistograms exposes the data planes' cumulative histograms
+// (connect latency, connection lifetime and per-connection byte counts) as
+// native Prometheus histograms. The data plane does the bucketing, so each
+// scrape just re-emits each instance's latest snapshot.
+type dataPlaneHistograms struct {
+	global        *prometheus.Desc
+	backend       *prometheus.Desc
+	duration      *prometheus.Desc
+	requestBytes  *prometheus.Desc
+	responseBytes *prometheus.Desc
+
+	mu        sync.Mutex
+	instances map[string]*instanceHistograms
+}
+
+type instanceHistograms struct {
+	latest   *pb.LatencyHistogram
+	backends map[string]*pb.BackendMetrics
+}
+
+func newDataPlaneHistograms() *dataPlaneHistograms {
+	backendLabels := []string{"instance", "backend"}
+	return &dataPlaneHistograms{
+		global: prometheus.NewDesc("proxy_connect_duration_seconds",
+			"Backend connect latency", []string{"instance"}, nil),
+		backend: prometheus.NewDesc("proxy_backend_connect_duration_seconds",
+			"Backend connect latency per backend", backendLabels, nil),
+		duration: prometheus.NewDesc("proxy_backend_connection_duration_seconds",
+			"Lifetime of closed connections per backend", backendLabels, nil),
+		requestBytes: prometheus.NewDesc("proxy_backend_request_bytes",
+			"Bytes sent to the backend per closed connection", backendLabels, nil),
+		responseBytes: prometheus.NewDesc("proxy_backend_response_bytes",
+			"Bytes received from the backend per closed connection", backendLabels, nil),
+		instances: make(map[string]*instanceHistograms),
+	}
+}
+
+// update replaces instance's histograms with those in data, keeping only
+// the backends configured reports.
+func (h *dataPlaneHistograms) update(instance string, data *pb.MetricsData, configured func(string) bool) {
+	backends := make(map[string]*pb.BackendMetrics, len(data.BackendMetrics))
+	for _, b := range data.BackendMetrics {
+		if configured(b.Address) {
+			backends[b.Address] = b
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.instances[instance] = &instanceHistograms{latest: data.ConnectLatency, backends: backends}
+}
+
+// retain drops every backend histogram not in backends until the next
+// update.
+func (h *dataPlaneHistograms) retain(backends map[string]bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, ih := range h.instances {
+		for addr := range ih.backends {
+			if !backends[addr] {
+				delete(ih.backends, addr)
+			}
+		}
+	}
+}
+
+func (h *dataPlaneHistograms) Describe(ch chan<- *prometheus.Desc) {
+	ch <- h.global
+	ch <- h.backend
+	ch <- h.duration
+	ch <- h.requestBytes
+	ch <- h.responseBytes
+}
+
+func (h *dataPlaneHistograms) Collect(ch chan<- prometheus.Metric) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for instance, ih := range h.instances {
+		if m, ok := constLatencyHistogram(h.global, ih.latest, instance); ok {
+			ch <- m
+		}
+		for addr, b := range ih.backends {
+			if m, ok := constLatencyHistogram(h.backend, b.ConnectLatency, instance, addr); ok {
+				ch <- m
+			}
+			if m, ok := constHistogram(h.duration, b.ConnectionDuration, instance, addr); ok {
+				ch <- m
+			}
+			if m, ok := constHistogram(h.requestBytes, b.RequestBytes, instance, addr); ok {
+				ch <- m
+			}
+			if m, ok := constHistogram(h.responseBytes, b.ResponseBytes, instance, addr); ok {
+				ch <- m
+			}
+		}
+	}
+}
+
+// constLatencyHistogram converts a data plane latency histogram, in
+// milliseconds, to seconds.
+func constLatencyHistogram(desc *prometheus.Desc, h *pb.LatencyHistogram, labels ...string) (prometheus.Metric, bool) {
+	if h == nil {
+		return nil, false
+	}
+	return newConstHistogram(desc, h.BoundsMs, h.Counts, h.Count, h.SumMs, h.Exemplars, 1000, labels)
+}
+
+// constHistogram re-emits a data plane histogram already in the metric's
+// unit.
+func constHistogram(desc *prometheus.Desc, h *pb.Histogram, labels ...string) (prometheus.Metric, bool) {
+	if h == nil {
+		return nil, false
+	}
+	return newConstHistogram(desc, h.Bounds, h.Counts, h.Count, h.Sum, h.Exemplars, 1, labels)
+}
+
+// newConstHistogram divides bounds, sum and exemplar values by scale. A
+// malformed histogram (bounds and counts of different lengths) is skipped
+// rather than failing the whole scrape.
+func newConstHistogram(desc *prometheus.Desc, bounds []float64, counts []uint64, count uint64, sum float64,
+	exemplars []*pb.Exemplar, scale float64, labels []string) (prometheus.Metric, bool) {
+	if len(bounds) != len(counts) {
+		return nil, false
+	}
+	buckets := make(map[float64]uint64, len(bounds))
+	for i, bound := range bounds {
+		buckets[bound/scale] = counts[i]
+	}
+	m, err := prometheus.NewConstHistogram(desc, count, sum/scale, buckets, labels...)
+	if err != nil {
+		return nil, false
+	}
+	return withExemplars(m, exemplars, scale), true
+}
+
+// withExemplars attaches the exemplars that carry a trace ID to m, each
+// labelled trace_id, so a latency spike in Grafana links to an example
+// trace. They're only exposed to scrapers that negotiate OpenMetrics. An
+// exemplar set client_golang rejects (labels over 128 runes) is dropped
+// and the histogram kept.
+func withExemplars(m prometheus.Metric, exemplars []*pb.Exemplar, scale float64) prometheus.Metric {
+	var exs []prometheus.Exemplar
+	for _, e := range exemplars {
+		if e.TraceId == "" {
+			continue
+		}
+		exs = append(exs, prometheus.Exemplar{
+			Value:     e.Value / scale,
+			Labels:    prometheus.Labels{"trace_id": e.TraceId},
+			Timestamp: time.UnixMilli(e.Timestamp),
+		})
+	}
+	if len(exs) == 0 {
+		return m
+	}
+	withExs, err := prometheus.NewMetricWithExemplars(m, exs...)
+	if err != nil {
+		return m
+	}
+	return withExs
+}
@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func allBackends(string) bool { return true }
+
+func TestLatencyHistograms_ExposesSnapshotInSeconds(t *testing.T) {
+	h := newDataPlaneHistograms()
+	hist := &pb.LatencyHistogram{BoundsMs: []float64{1, 10}, Counts: []uint64{2, 5}, Count: 6, SumMs: 1500}
+	h.update("dp-1", &pb.MetricsData{
+		ConnectLatency: hist,
+		BackendMetrics: []*pb.BackendMetrics{
+			{Address: "a:1", ConnectLatency: hist},
+			{Address: "b:1"},
+			{Address: "c:1", ConnectLatency: &pb.LatencyHistogram{BoundsMs: []float64{1}}},
+		},
+	}, allBackends)
+
+	want := `
+# HELP proxy_backend_connect_duration_seconds Backend connect latency per backend
+# TYPE proxy_backend_connect_duration_seconds histogram
+proxy_backend_connect_duration_seconds_bucket{backend="a:1",instance="dp-1",le="0.001"} 2
+proxy_backend_connect_duration_seconds_bucket{backend="a:1",instance="dp-1",le="0.01"} 5
+proxy_backend_connect_duration_seconds_bucket{backend="a:1",instance="dp-1",le="+Inf"} 6
+proxy_backend_connect_duration_seconds_sum{backend="a:1",instance="dp-1"} 1.5
+proxy_backend_connect_duration_seconds_count{backend="a:1",instance="dp-1"} 6
+# HELP proxy_connect_duration_seconds Backend connect latency
+# TYPE proxy_connect_duration_seconds histogram
+proxy_connect_duration_seconds_bucket{instance="dp-1",le="0.001"} 2
+proxy_connect_duration_seconds_bucket{instance="dp-1",le="0.01"} 5
+proxy_connect_duration_seconds_bucket{instance="dp-1",le="+Inf"} 6
+proxy_connect_duration_seconds_sum{instance="dp-1"} 1.5
+proxy_connect_duration_seconds_count{instance="dp-1"} 6
+`
+	if err := testutil.CollectAndCompare(h, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+
+	// An older data plane sends no histograms
+	h.update("dp-1", &pb.MetricsData{}, allBackends)
+	if n := testutil.CollectAndCount(h); n != 0 {
+		t.Errorf("got %d metrics without histograms", n)
+	}
+}
+
+func TestDataPlaneHistograms_ExposesConnectionHistograms(t *testing.T) {
+	h := newDataPlaneHistograms()
+	h.update("dp-1", &pb.MetricsData{
+		BackendMetrics: []*pb.BackendMetrics{{
+			Address:            "a:1",
+			ConnectionDuration: &pb.Histogram{Bounds: []float64{1, 60}, Counts: []uint64{3, 4}, Count: 5, Sum: 700},
+			RequestBytes:       &pb.Histogram{Bounds: []float64{1024}, Counts: []uint64{5}, Count: 5, Sum: 2048},
+			ResponseBytes:      &pb.Histogram{Bounds: []float64{1024}, Counts: []uint64{1}, Count: 5, Sum: 9000},
+		}},
+	}, allBackends)
+
+	want := `
+# HELP proxy_backend_connection_duration_seconds Lifetime of closed connections per backend
+# TYPE proxy_backend_connection_duration_seconds histogram
+proxy_backend_connection_duration_seconds_bucket{backend="a:1",instance="dp-1",le="1"} 3
+proxy_backend_connection_duration_seconds_bucket{backend="a:1",instance="dp-1",le="60"} 4
+proxy_backend_connection_duration_seconds_bucket{backend="a:1",instance="dp-1",le="+Inf"} 5
+proxy_backend_connection_duration_seconds_sum{backend="a:1",instance="dp-1"} 700
+proxy_backend_connection_duration_seconds_count{backend="a:1",instance="dp-1"} 5
+# HELP proxy_backend_request_bytes Bytes sent to the backend per closed connection
+# TYPE proxy_backend_request_bytes histogram
+proxy_backend_request_bytes_bucket{backend="a:1",instance="dp-1",le="1024"} 5
+proxy_backend_request_bytes_bucket{backend="a:1",instance="dp-1",le="+Inf"} 5
+proxy_backend_request_bytes_sum{backend="a:1",instance="dp-1"} 2048
+proxy_backend_request_bytes_count{backend="a:1",instance="dp-1"} 5
+# HELP proxy_backend_response_bytes Bytes received from the backend per closed connection
+# TYPE proxy_backend_response_bytes histogram
+proxy_backend_response_bytes_bucket{backend="a:1",instance="dp-1",le="1024"} 1
+proxy_backend_response_bytes_bucket{backend="a:1",instance="dp-1",le="+Inf"} 5
+proxy_backend_response_bytes_sum{backend="a:1",instance="dp-1"} 9000
+proxy_backend_response_bytes_count{backend="a:1",instance="dp-1"} 5
+`
+	if err := testutil.CollectAndCompare(h, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDataPlaneHistograms_AttachesTraceExemplars(t *testing.T) {
+	h := newDataPlaneHistograms()
+	h.update("dp-1", &pb.MetricsData{ConnectLatency: &pb.LatencyHistogram{
+		BoundsMs: []float64{1, 10}, Counts: []uint64{1, 2}, Count: 2, SumMs: 5.5,
+		Exemplars: []*pb.Exemplar{
+			{Value: 4.5, TraceId: "4bf92f3577b34da6a3ce929d0e0e4736", Timestamp: 1700000000000},
+			{Value: 0.5}, // no trace: not an exemplar
+		},
+	}}, allBackends)
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(h)
+	families, err := reg.Gather()
+	if err != nil || len(families) != 1 {
+		t.Fatalf("gather: %v, %d families", err, len(families))
+	}
+	var got []string
+	for _, b := range families[0].Metric[0].Histogram.Bucket {
+		if e := b.Exemplar; e != nil {
+			got = append(got, fmt.Sprintf("le=%v %s=%s %v", b.GetUpperBound(), e.Label[0].GetName(), e.Label[0].GetValue(), e.GetValue()))
+		}
+	}
+	if want := "le=0.01 trace_id=4bf92f3577b34da6a3ce929d0e0e4736 0.0045"; len(got) != 1 || got[0] != want {
+		t.Errorf("exemplars: got %q, want [%q]", got, want)
+	}
+}
@@ -0,0 +1,188 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+// InfluxExporter writes everything a Prometheus gatherer reports to an
+// InfluxDB v2 bucket in line protocol. Series map the way Telegraf's
+// prometheus input (metric_version 1) maps them, so existing Telegraf
+// dashboards work: the metric name is the measurement, labels are tags,
+// and the value is a counter, gauge or value field; histograms and
+// summaries are one point with count, sum and a field per bucket bound or
+// quantile.
+type InfluxExporter struct {
+	url      string
+	token    string
+	interval time.Duration
+	gatherer prometheus.Gatherer
+	client   *http.Client
+	logger   *zap.Logger
+}
+
+func NewInfluxExporter(cfg config.InfluxDBConfig, gatherer prometheus.Gatherer, logger *zap.Logger) *InfluxExporter {
+	q := url.Values{"bucket": {cfg.Bucket}, "precision": {"ms"}}
+	if cfg.Org != "" {
+		q.Set("org", cfg.Org)
+	}
+	return &InfluxExporter{
+		url:      strings.TrimSuffix(cfg.URL, "/") + "/api/v2/write?" + q.Encode(),
+		token:    cfg.Token,
+		interval: cfg.Interval,
+		gatherer: gatherer,
+		client:   &http.Client{Timeout: cfg.Timeout},
+		logger:   logger,
+	}
+}
+
+// Run writes every interval until ctx is done. Failed writes are logged
+// and dropped; the next one carries the cumulative totals anyway.
+func (e *InfluxExporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.write(ctx); err != nil && ctx.Err() == nil {
+				e.logger.Warn("InfluxDB metrics write failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (e *InfluxExporter) write(ctx context.Context) error {
+	families, err := e.gatherer.Gather()
+	if err != nil && len(families) == 0 {
+		return fmt.Errorf("failed to gather metrics: %w", err)
+	}
+	body := lineProtocol(families, time.Now())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if e.token != "" {
+		req.Header.Set("Authorization", "Token "+e.token)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("influxdb returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// influxField is one field of a line protocol point.
+type influxField struct {
+	key   string
+	value float64
+}
+
+// lineProtocol renders families as one line per series, stamped with now
+// in milliseconds. NaN and infinite values, which line protocol can't
+// carry, are left out, as is a series left with no fields.
+func lineProtocol(families []*dto.MetricFamily, now time.Time) []byte {
+	ts := strconv.FormatInt(now.UnixMilli(), 10)
+	var buf bytes.Buffer
+	for _, mf := range families {
+		for _, m := range mf.Metric {
+			var fields []influxField
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				fields = []influxField{{"counter", m.GetCounter().GetValue()}}
+			case dto.MetricType_GAUGE:
+				fields = []influxField{{"gauge", m.GetGauge().GetValue()}}
+			case dto.MetricType_UNTYPED:
+				fields = []influxField{{"value", m.GetUntyped().GetValue()}}
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				fields = []influxField{{"count", float64(h.GetSampleCount())}, {"sum", h.GetSampleSum()}}
+				for _, b := range h.Bucket {
+					if !math.IsInf(b.GetUpperBound(), 1) {
+						fields = append(fields, influxField{formatFloat(b.GetUpperBound()), float64(b.GetCumulativeCount())})
+					}
+				}
+				fields = append(fields, influxField{"+Inf", float64(h.GetSampleCount())})
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				fields = []influxField{{"count", float64(s.GetSampleCount())}, {"sum", s.GetSampleSum()}}
+				for _, q := range s.Quantile {
+					fields = append(fields, influxField{formatFloat(q.GetQuantile()), q.GetValue()})
+				}
+			}
+			writeLine(&buf, mf.GetName(), m.Label, fields, ts)
+		}
+	}
+	return buf.Bytes()
+}
+
+func writeLine(buf *bytes.Buffer, measurement string, labels []*dto.LabelPair, fields []influxField, ts string) {
+	var fieldSet []string
+	for _, f := range fields {
+		if math.IsNaN(f.value) || math.IsInf(f.value, 0) {
+			continue
+		}
+		fieldSet = append(fieldSet, influxEscape(f.key, ",= ")+"="+formatFloat(f.value))
+	}
+	if len(fieldSet) == 0 {
+		return
+	}
+
+	buf.WriteString(influxEscape(measurement, ", "))
+	tags := append([]*dto.LabelPair(nil), labels...)
+	sort.Slice(tags, func(i, j int) bool { return tags[i].GetName() < tags[j].GetName() })
+	for _, l := range tags {
+		// Line protocol has no empty tag values
+		if l.GetValue() == "" {
+			continue
+		}
+		buf.WriteByte(',')
+		buf.WriteString(influxEscape(l.GetName(), ",= "))
+		buf.WriteByte('=')
+		buf.WriteString(influxEscape(l.GetValue(), ",= "))
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(strings.Join(fieldSet, ","))
+	buf.WriteByte(' ')
+	buf.WriteString(ts)
+	buf.WriteByte('\n')
+}
+
+// influxEscape backslash-escapes the characters in special. Newlines
+// can't be escaped, so they become spaces first.
+func influxEscape(s, special string) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	if !strings.ContainsAny(s, special) {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(special, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+func TestInfluxExporter_WritesLineProtocol(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_requests_total"}, []string{"backend", "zone"})
+	active := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_active"})
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_latency_seconds", Buckets: []float64{0.1}})
+	reg.MustRegister(requests, active, latency)
+	requests.WithLabelValues("a:1", "us east,1").Add(3)
+	requests.WithLabelValues("b:1", "").Add(1)
+	active.Set(2)
+	latency.Observe(0.05)
+	latency.Observe(2)
+
+	var body, auth, query string
+	influx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body, auth, query = string(b), r.Header.Get("Authorization"), r.URL.Path+"?"+r.URL.RawQuery
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer influx.Close()
+
+	e := NewInfluxExporter(config.InfluxDBConfig{
+		URL: influx.URL + "/", Org: "ops", Bucket: "aegis", Token: "secret", Timeout: time.Second,
+	}, reg, zap.NewNop())
+	if err := e.write(context.Background()); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if auth != "Token secret" || query != "/api/v2/write?bucket=aegis&org=ops&precision=ms" {
+		t.Errorf("request: auth %q, url %q", auth, query)
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		// Drop the timestamp
+		lines = append(lines, line[:strings.LastIndex(line, " ")])
+	}
+	want := []string{
+		`test_active gauge=2`,
+		`test_latency_seconds count=2,sum=2.05,0.1=1,+Inf=2`,
+		`test_requests_total,backend=a:1,zone=us\ east\,1 counter=3`,
+		`test_requests_total,backend=b:1 counter=1`,
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("lines:\ngot  %q\nwant %q", lines, want)
+	}
+}
+
+func TestInfluxExporter_ServerErrorReturned(t *testing.T) {
+	influx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"code":"not found","message":"bucket \"aegis\" not found"}`, http.StatusNotFound)
+	}))
+	defer influx.Close()
+
+	e := NewInfluxExporter(config.InfluxDBConfig{URL: influx.URL, Bucket: "aegis", Timeout: time.Second}, prometheus.NewRegistry(), zap.NewNop())
+	if err := e.write(context.Background()); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected the server's error, got %v", err)
+	}
+}
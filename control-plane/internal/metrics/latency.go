@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"math"
+	"slices"
+	"time"
+
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+)
+
+// latencySampleInterval is how often a backend's cumulative connect
+// latency histogram is kept for its window, as sloSampleInterval is for
+// the SLOs.
+const latencySampleInterval = 10 * time.Second
+
+// latencyQuantiles are the quantiles exported per backend as
+// proxy_backend_latency_ms.
+var latencyQuantiles = []float64{0.5, 0.9, 0.99}
+
+// latencyWindow turns one instance's cumulative connect latency histogram
+// for a backend into the histogram of the last window, by subtracting the
+// snapshot kept from the window's start.
+type latencyWindow struct {
+	samples []latencySample
+}
+
+type latencySample struct {
+	at     time.Time
+	bounds []float64
+	counts []uint64
+	count  uint64
+}
+
+// observe records hist and returns the cumulative counts and total of the
+// connects within window of now. ok is false while there's nothing to
+// report: no histogram, or no connects since the first snapshot.
+func (w *latencyWindow) observe(now time.Time, hist *pb.LatencyHistogram, window time.Duration) (counts []uint64, count uint64, ok bool) {
+	if hist == nil || len(hist.BoundsMs) != len(hist.Counts) || len(hist.BoundsMs) == 0 {
+		return nil, 0, false
+	}
+	if n := len(w.samples); n > 0 {
+		last := w.samples[n-1]
+		// A restarted data plane counts from zero again, and one with
+		// different buckets can't be subtracted from: start over
+		if hist.Count < last.count || !slices.Equal(hist.BoundsMs, last.bounds) || decreased(hist.Counts, last.counts) {
+			w.samples = nil
+		}
+	}
+	current := latencySample{at: now, bounds: hist.BoundsMs, counts: hist.Counts, count: hist.Count}
+	if len(w.samples) == 0 || now.Sub(w.samples[len(w.samples)-1].at) >= latencySampleInterval {
+		w.samples = append(w.samples, current)
+	}
+
+	// Keep the newest sample at or before the window's start as its
+	// baseline, or the oldest while there's less history than the window
+	cutoff := now.Add(-window)
+	drop := 0
+	for drop+1 < len(w.samples) && !w.samples[drop+1].at.After(cutoff) {
+		drop++
+	}
+	w.samples = w.samples[drop:]
+
+	base := w.samples[0]
+	if current.count == base.count {
+		return nil, 0, false
+	}
+	counts = make([]uint64, len(current.counts))
+	for i := range counts {
+		counts[i] = current.counts[i] - base.counts[i]
+	}
+	return counts, current.count - base.count, true
+}
+
+func decreased(counts, last []uint64) bool {
+	for i := range counts {
+		if counts[i] < last[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// HistogramQuantile estimates the q quantile of a cumulative histogram the
+// way PromQL's histogram_quantile does: linearly within the bucket the
+// rank falls in, taking the lowest bucket to start at zero. A rank above
+// every bound is reported as the highest bound.
+func HistogramQuantile(q float64, bounds []float64, counts []uint64, count uint64) float64 {
+	if count == 0 || len(bounds) == 0 {
+		return math.NaN()
+	}
+	rank := q * float64(count)
+	var lower, below float64
+	for i, bound := range bounds {
+		c := float64(counts[i])
+		if c >= rank {
+			if c == below {
+				return bound
+			}
+			return lower + (bound-lower)*(rank-below)/(c-below)
+		}
+		lower, below = bound, c
+	}
+	return bounds[len(bounds)-1]
+}
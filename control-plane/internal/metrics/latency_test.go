@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHistogramQuantile(t *testing.T) {
+	bounds := []float64{10, 100, 1000}
+	counts := []uint64{50, 90, 99}
+	for _, tc := range []struct {
+		q, want float64
+	}{
+		{0.5, 10},
+		// 90 of 100 at or under 100ms
+		{0.9, 100},
+		// Rank 95 is 5 of the 9 connects between 100ms and 1s
+		{0.95, 100 + 900*5.0/9},
+		// Above every bound
+		{0.999, 1000},
+		{0.25, 5},
+	} {
+		if got := HistogramQuantile(tc.q, bounds, counts, 100); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("q%v: got %v, want %v", tc.q, got, tc.want)
+		}
+	}
+	if got := HistogramQuantile(0.5, bounds, []uint64{0, 0, 0}, 0); !math.IsNaN(got) {
+		t.Errorf("empty histogram: got %v, want NaN", got)
+	}
+}
+
+func TestLatencyWindow_SubtractsWindowStart(t *testing.T) {
+	var w latencyWindow
+	t0 := time.Now()
+	hist := func(fast, slow uint64) *pb.LatencyHistogram {
+		return &pb.LatencyHistogram{BoundsMs: []float64{10, 100}, Counts: []uint64{fast, fast + slow}, Count: fast + slow}
+	}
+	if _, _, ok := w.observe(t0, hist(1000, 0), time.Minute); ok {
+		t.Error("first snapshot reported a window")
+	}
+	// One slow connect in the window; the thousand fast ones are older
+	w.observe(t0.Add(70*time.Second), hist(1000, 0), time.Minute)
+	counts, count, ok := w.observe(t0.Add(80*time.Second), hist(1000, 1), time.Minute)
+	if !ok || count != 1 || counts[0] != 0 || counts[1] != 1 {
+		t.Fatalf("window: got %v %d %v", counts, count, ok)
+	}
+
+	// A restart starts over instead of going negative
+	if _, _, ok := w.observe(t0.Add(90*time.Second), hist(5, 0), time.Minute); ok {
+		t.Error("restart reported a window")
+	}
+}
+
+func TestUpdateFromProto_BackendLatencyQuantiles(t *testing.T) {
+	c := sharedTestCollector(t)
+	const instance = "collector-test-quantiles"
+	snapshot := func(fast, slow uint64) *pb.MetricsData {
+		return &pb.MetricsData{InstanceId: instance, BackendMetrics: []*pb.BackendMetrics{{
+			Address:        "collector-test-q:1",
+			AvgLatencyMs:   3,
+			ConnectLatency: &pb.LatencyHistogram{BoundsMs: []float64{10, 100}, Counts: []uint64{fast, fast + slow}, Count: fast + slow},
+		}}}
+	}
+	c.UpdateFromProto(snapshot(10, 0))
+	c.UpdateFromProto(snapshot(20, 10))
+
+	// 10 fast and 10 slow connects since the baseline
+	if got := testutil.ToFloat64(c.backendQuantiles.WithLabelValues(instance, "collector-test-q:1", "0.5")); got != 10 {
+		t.Errorf("p50: got %v, want 10", got)
+	}
+	if got := testutil.ToFloat64(c.backendQuantiles.WithLabelValues(instance, "collector-test-q:1", "0.9")); math.Abs(got-82) > 1e-9 {
+		t.Errorf("p90: got %v, want 82", got)
+	}
+}
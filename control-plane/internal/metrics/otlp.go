@@ -0,0 +1,304 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+// otlpServiceName is the service.name resource attribute on exported
+// metrics.
+const otlpServiceName = "aegis-control-plane"
+
+// OTLPExporter pushes everything a Prometheus gatherer reports to an
+// OpenTelemetry collector as OTLP/HTTP JSON, so the same Collector data is
+// available to setups that don't scrape. Counters become cumulative
+// monotonic sums, gauges gauges, histograms explicit-bucket histograms
+// and summaries summaries.
+type OTLPExporter struct {
+	url      string
+	headers  map[string]string
+	interval time.Duration
+	gatherer prometheus.Gatherer
+	client   *http.Client
+	logger   *zap.Logger
+	// start is reported as every cumulative point's start time.
+	start time.Time
+}
+
+func NewOTLPExporter(cfg config.OTLPConfig, gatherer prometheus.Gatherer, logger *zap.Logger) *OTLPExporter {
+	return &OTLPExporter{
+		url:      otlpMetricsURL(cfg.Endpoint),
+		headers:  cfg.Headers,
+		interval: cfg.Interval,
+		gatherer: gatherer,
+		client:   &http.Client{Timeout: cfg.Timeout},
+		logger:   logger,
+		start:    time.Now(),
+	}
+}
+
+// otlpMetricsURL appends the OTLP/HTTP metrics path to a base endpoint,
+// as OTEL_EXPORTER_OTLP_ENDPOINT does.
+func otlpMetricsURL(endpoint string) string {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if strings.HasSuffix(endpoint, "/v1/metrics") {
+		return endpoint
+	}
+	return endpoint + "/v1/metrics"
+}
+
+// Run exports every interval until ctx is done. Failed exports are logged
+// and dropped; the next one carries the cumulative totals anyway.
+func (e *OTLPExporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.export(ctx); err != nil && ctx.Err() == nil {
+				e.logger.Warn("OTLP metrics export failed", zap.String("url", e.url), zap.Error(err))
+			}
+		}
+	}
+}
+
+func (e *OTLPExporter) export(ctx context.Context) error {
+	families, err := e.gatherer.Gather()
+	if err != nil && len(families) == 0 {
+		return fmt.Errorf("failed to gather metrics: %w", err)
+	}
+	body, err := json.Marshal(e.request(families, time.Now()))
+	if err != nil {
+		return fmt.Errorf("failed to encode metrics: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range e.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("collector returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// The types below are the subset of the OTLP JSON encoding the exporter
+// writes. 64-bit integers are strings, as the encoding requires.
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpAttribute struct {
+	Key   string        `json:"key"`
+	Value otlpAnyString `json:"value"`
+}
+
+type otlpAnyString struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpMetric struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Sum         *otlpSum       `json:"sum,omitempty"`
+	Gauge       *otlpGauge     `json:"gauge,omitempty"`
+	Histogram   *otlpHistogram `json:"histogram,omitempty"`
+	Summary     *otlpSummary   `json:"summary,omitempty"`
+}
+
+// otlpCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE.
+const otlpCumulative = 2
+
+type otlpSum struct {
+	DataPoints             []otlpNumberPoint `json:"dataPoints"`
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberPoint `json:"dataPoints"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []otlpHistogramPoint `json:"dataPoints"`
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+}
+
+type otlpSummary struct {
+	DataPoints []otlpSummaryPoint `json:"dataPoints"`
+}
+
+type otlpNumberPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsDouble          float64         `json:"asDouble"`
+}
+
+type otlpHistogramPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	Count             string          `json:"count"`
+	Sum               float64         `json:"sum"`
+	// BucketCounts has one more entry than ExplicitBounds, for +Inf, and
+	// unlike Prometheus buckets isn't cumulative.
+	BucketCounts   []string  `json:"bucketCounts"`
+	ExplicitBounds []float64 `json:"explicitBounds"`
+}
+
+type otlpSummaryPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	Count             string          `json:"count"`
+	Sum               float64         `json:"sum"`
+	QuantileValues    []otlpQuantile  `json:"quantileValues"`
+}
+
+type otlpQuantile struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+// request converts gathered metric families into one export request.
+// Families the OTLP model has no equivalent for are skipped.
+func (e *OTLPExporter) request(families []*dto.MetricFamily, now time.Time) otlpRequest {
+	start := strconv.FormatInt(e.start.UnixNano(), 10)
+	ts := strconv.FormatInt(now.UnixNano(), 10)
+
+	metrics := make([]otlpMetric, 0, len(families))
+	for _, mf := range families {
+		m := otlpMetric{Name: mf.GetName(), Description: mf.GetHelp()}
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			m.Sum = &otlpSum{AggregationTemporality: otlpCumulative, IsMonotonic: true}
+			for _, pm := range mf.Metric {
+				m.Sum.DataPoints = append(m.Sum.DataPoints, otlpNumberPoint{
+					Attributes: otlpLabels(pm.Label), StartTimeUnixNano: start, TimeUnixNano: ts,
+					AsDouble: pm.GetCounter().GetValue(),
+				})
+			}
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			m.Gauge = &otlpGauge{}
+			for _, pm := range mf.Metric {
+				v := pm.GetGauge().GetValue()
+				if mf.GetType() == dto.MetricType_UNTYPED {
+					v = pm.GetUntyped().GetValue()
+				}
+				m.Gauge.DataPoints = append(m.Gauge.DataPoints, otlpNumberPoint{
+					Attributes: otlpLabels(pm.Label), TimeUnixNano: ts, AsDouble: v,
+				})
+			}
+		case dto.MetricType_HISTOGRAM:
+			m.Histogram = &otlpHistogram{AggregationTemporality: otlpCumulative}
+			for _, pm := range mf.Metric {
+				m.Histogram.DataPoints = append(m.Histogram.DataPoints, otlpHistogramFrom(pm, start, ts))
+			}
+		case dto.MetricType_SUMMARY:
+			m.Summary = &otlpSummary{}
+			for _, pm := range mf.Metric {
+				s := pm.GetSummary()
+				p := otlpSummaryPoint{
+					Attributes: otlpLabels(pm.Label), StartTimeUnixNano: start, TimeUnixNano: ts,
+					Count: strconv.FormatUint(s.GetSampleCount(), 10), Sum: s.GetSampleSum(),
+					QuantileValues: []otlpQuantile{},
+				}
+				for _, q := range s.Quantile {
+					p.QuantileValues = append(p.QuantileValues, otlpQuantile{Quantile: q.GetQuantile(), Value: q.GetValue()})
+				}
+				m.Summary.DataPoints = append(m.Summary.DataPoints, p)
+			}
+		default:
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+
+	return otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: otlpResource{Attributes: []otlpAttribute{
+			{Key: "service.name", Value: otlpAnyString{otlpServiceName}},
+		}},
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope:   otlpScope{Name: "github.com/lazzerex/aegis/control-plane"},
+			Metrics: metrics,
+		}},
+	}}}
+}
+
+// otlpHistogramFrom turns a Prometheus histogram's cumulative buckets into
+// per-bucket counts, with everything past the last bound in the +Inf one.
+func otlpHistogramFrom(pm *dto.Metric, start, ts string) otlpHistogramPoint {
+	h := pm.GetHistogram()
+	p := otlpHistogramPoint{
+		Attributes: otlpLabels(pm.Label), StartTimeUnixNano: start, TimeUnixNano: ts,
+		Count: strconv.FormatUint(h.GetSampleCount(), 10), Sum: h.GetSampleSum(),
+		BucketCounts: []string{}, ExplicitBounds: []float64{},
+	}
+	var prev uint64
+	for _, b := range h.Bucket {
+		if math.IsInf(b.GetUpperBound(), 1) {
+			continue
+		}
+		p.ExplicitBounds = append(p.ExplicitBounds, b.GetUpperBound())
+		p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(b.GetCumulativeCount()-prev, 10))
+		prev = b.GetCumulativeCount()
+	}
+	p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(h.GetSampleCount()-prev, 10))
+	return p
+}
+
+func otlpLabels(labels []*dto.LabelPair) []otlpAttribute {
+	if len(labels) == 0 {
+		return nil
+	}
+	attrs := make([]otlpAttribute, len(labels))
+	for i, l := range labels {
+		attrs[i] = otlpAttribute{Key: l.GetName(), Value: otlpAnyString{l.GetValue()}}
+	}
+	return attrs
+}
@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+func TestOTLPExporter_PushesGatheredMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_requests_total", Help: "Requests"}, []string{"backend"})
+	active := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_active"})
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_latency_seconds", Buckets: []float64{0.1, 1}})
+	reg.MustRegister(requests, active, latency)
+	requests.WithLabelValues("a:1").Add(3)
+	active.Set(2)
+	for _, v := range []float64{0.05, 0.5, 0.7, 5} {
+		latency.Observe(v)
+	}
+
+	var got otlpRequest
+	var header, path string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header, path = r.Header.Get("X-Api-Key"), r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+	}))
+	defer collector.Close()
+
+	e := NewOTLPExporter(config.OTLPConfig{
+		Endpoint: collector.URL + "/",
+		Headers:  map[string]string{"x-api-key": "secret"},
+		Interval: time.Minute,
+		Timeout:  time.Second,
+	}, reg, zap.NewNop())
+	if err := e.export(context.Background()); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+
+	if path != "/v1/metrics" || header != "secret" {
+		t.Errorf("request: path %q, header %q", path, header)
+	}
+	if len(got.ResourceMetrics) != 1 || len(got.ResourceMetrics[0].ScopeMetrics) != 1 {
+		t.Fatalf("unexpected shape: %+v", got)
+	}
+	if attrs := got.ResourceMetrics[0].Resource.Attributes; len(attrs) != 1 || attrs[0].Value.StringValue != otlpServiceName {
+		t.Errorf("resource attributes: %+v", attrs)
+	}
+	byName := make(map[string]otlpMetric)
+	for _, m := range got.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		byName[m.Name] = m
+	}
+
+	sum := byName["test_requests_total"].Sum
+	if sum == nil || !sum.IsMonotonic || sum.AggregationTemporality != otlpCumulative || len(sum.DataPoints) != 1 {
+		t.Fatalf("counter: %+v", sum)
+	}
+	if p := sum.DataPoints[0]; p.AsDouble != 3 || len(p.Attributes) != 1 || p.Attributes[0].Key != "backend" || p.StartTimeUnixNano == "" {
+		t.Errorf("counter point: %+v", p)
+	}
+	if g := byName["test_active"].Gauge; g == nil || len(g.DataPoints) != 1 || g.DataPoints[0].AsDouble != 2 {
+		t.Errorf("gauge: %+v", g)
+	}
+
+	h := byName["test_latency_seconds"].Histogram
+	if h == nil || len(h.DataPoints) != 1 {
+		t.Fatalf("histogram: %+v", h)
+	}
+	p := h.DataPoints[0]
+	if p.Count != "4" || p.Sum != 6.25 {
+		t.Errorf("histogram count/sum: %s / %v", p.Count, p.Sum)
+	}
+	wantCounts := []string{"1", "2", "1"}
+	if len(p.ExplicitBounds) != 2 || len(p.BucketCounts) != len(wantCounts) {
+		t.Fatalf("histogram buckets: %v / %v", p.ExplicitBounds, p.BucketCounts)
+	}
+	for i, want := range wantCounts {
+		if p.BucketCounts[i] != want {
+			t.Errorf("bucket %d: got %s, want %s", i, p.BucketCounts[i], want)
+		}
+	}
+}
+
+func TestOTLPExporter_CollectorErrorReturned(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "quota exceeded", http.StatusTooManyRequests)
+	}))
+	defer collector.Close()
+
+	e := NewOTLPExporter(config.OTLPConfig{Endpoint: collector.URL + "/v1/metrics", Timeout: time.Second}, prometheus.NewRegistry(), zap.NewNop())
+	if err := e.export(context.Background()); err == nil {
+		t.Error("expected an error for a 429 from the collector")
+	}
+}
@@ -0,0 +1,191 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// RemoteWriter pushes everything a Prometheus gatherer reports to a
+// remote-write endpoint, for environments where nothing can scrape the
+// control plane. Each push is one Prometheus remote-write 1.0 request: a
+// snappy-compressed WriteRequest with one sample per series, stamped with
+// the time it was gathered.
+type RemoteWriter struct {
+	url      string
+	headers  map[string]string
+	interval time.Duration
+	gatherer prometheus.Gatherer
+	client   *http.Client
+	logger   *zap.Logger
+}
+
+func NewRemoteWriter(cfg config.RemoteWriteConfig, gatherer prometheus.Gatherer, logger *zap.Logger) *RemoteWriter {
+	return &RemoteWriter{
+		url:      cfg.URL,
+		headers:  cfg.Headers,
+		interval: cfg.Interval,
+		gatherer: gatherer,
+		client:   &http.Client{Timeout: cfg.Timeout},
+		logger:   logger,
+	}
+}
+
+// Run pushes every interval until ctx is done. Failed pushes are logged
+// and dropped: series are cumulative, so the next push catches up.
+func (w *RemoteWriter) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.push(ctx); err != nil && ctx.Err() == nil {
+				w.logger.Warn("Prometheus remote write failed", zap.String("url", w.url), zap.Error(err))
+			}
+		}
+	}
+}
+
+func (w *RemoteWriter) push(ctx context.Context) error {
+	families, err := w.gatherer.Gather()
+	if err != nil && len(families) == 0 {
+		return fmt.Errorf("failed to gather metrics: %w", err)
+	}
+	body := s2.EncodeSnappy(nil, encodeWriteRequest(remoteWriteSeries(families, time.Now())))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "aegis-control-plane")
+	for name, value := range w.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// rwSeries is one remote-write time series with its single sample.
+type rwSeries struct {
+	// labels includes __name__ and is sorted by name, as the protocol
+	// requires.
+	labels    [][2]string
+	value     float64
+	timestamp int64
+}
+
+// remoteWriteSeries flattens metric families into series the way the
+// text exposition format does: histograms become _bucket (with le),
+// _sum and _count series, summaries quantile, _sum and _count series.
+func remoteWriteSeries(families []*dto.MetricFamily, now time.Time) []rwSeries {
+	ts := now.UnixMilli()
+	var out []rwSeries
+	add := func(name string, labels []*dto.LabelPair, value float64, extra ...string) {
+		ls := make([][2]string, 0, len(labels)+2)
+		ls = append(ls, [2]string{"__name__", name})
+		for _, l := range labels {
+			ls = append(ls, [2]string{l.GetName(), l.GetValue()})
+		}
+		if len(extra) == 2 {
+			ls = append(ls, [2]string{extra[0], extra[1]})
+		}
+		sort.Slice(ls, func(i, j int) bool { return ls[i][0] < ls[j][0] })
+		out = append(out, rwSeries{labels: ls, value: value, timestamp: ts})
+	}
+
+	for _, mf := range families {
+		name := mf.GetName()
+		for _, m := range mf.Metric {
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m.Label, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, m.Label, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, m.Label, m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, b := range h.Bucket {
+					if !math.IsInf(b.GetUpperBound(), 1) {
+						add(name+"_bucket", m.Label, float64(b.GetCumulativeCount()), "le", formatFloat(b.GetUpperBound()))
+					}
+				}
+				add(name+"_bucket", m.Label, float64(h.GetSampleCount()), "le", "+Inf")
+				add(name+"_sum", m.Label, h.GetSampleSum())
+				add(name+"_count", m.Label, float64(h.GetSampleCount()))
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.Quantile {
+					add(name, m.Label, q.GetValue(), "quantile", formatFloat(q.GetQuantile()))
+				}
+				add(name+"_sum", m.Label, s.GetSampleSum())
+				add(name+"_count", m.Label, float64(s.GetSampleCount()))
+			}
+		}
+	}
+	return out
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// encodeWriteRequest marshals series as a prometheus.WriteRequest:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label        { string name = 1; string value = 2; }
+//	message Sample       { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []rwSeries) []byte {
+	var buf, ts, msg []byte
+	for _, s := range series {
+		ts = ts[:0]
+		for _, l := range s.labels {
+			msg = msg[:0]
+			msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+			msg = protowire.AppendString(msg, l[0])
+			msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+			msg = protowire.AppendString(msg, l[1])
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, msg)
+		}
+		msg = msg[:0]
+		msg = protowire.AppendTag(msg, 1, protowire.Fixed64Type)
+		msg = protowire.AppendFixed64(msg, math.Float64bits(s.value))
+		msg = protowire.AppendTag(msg, 2, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, uint64(s.timestamp))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, msg)
+
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, ts)
+	}
+	return buf
+}
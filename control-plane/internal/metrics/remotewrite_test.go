@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodeWriteRequest parses a WriteRequest into "name{l="v",...} value"
+// strings, labels in wire order.
+func decodeWriteRequest(t *testing.T, b []byte) []string {
+	t.Helper()
+	var out []string
+	for len(b) > 0 {
+		_, _, n := protowire.ConsumeTag(b)
+		ts, m := protowire.ConsumeBytes(b[n:])
+		if m < 0 {
+			t.Fatal("bad timeseries")
+		}
+		b = b[n+m:]
+
+		var name string
+		var labels []string
+		var value float64
+		for len(ts) > 0 {
+			num, _, n := protowire.ConsumeTag(ts)
+			msg, m := protowire.ConsumeBytes(ts[n:])
+			ts = ts[n+m:]
+			fields := map[protowire.Number][]byte{}
+			var fixed uint64
+			for len(msg) > 0 {
+				fnum, typ, n := protowire.ConsumeTag(msg)
+				msg = msg[n:]
+				switch typ {
+				case protowire.BytesType:
+					v, m := protowire.ConsumeBytes(msg)
+					fields[fnum], msg = v, msg[m:]
+				case protowire.Fixed64Type:
+					v, m := protowire.ConsumeFixed64(msg)
+					fixed, msg = v, msg[m:]
+				case protowire.VarintType:
+					_, m := protowire.ConsumeVarint(msg)
+					msg = msg[m:]
+				}
+			}
+			if num == 1 {
+				if string(fields[1]) == "__name__" {
+					name = string(fields[2])
+				} else {
+					labels = append(labels, string(fields[1])+`="`+string(fields[2])+`"`)
+				}
+			} else {
+				value = math.Float64frombits(fixed)
+			}
+		}
+		out = append(out, name+"{"+strings.Join(labels, ",")+"} "+formatFloat(value))
+	}
+	return out
+}
+
+func TestRemoteWriter_PushesSnappyWriteRequest(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_requests_total"}, []string{"backend"})
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_latency_seconds", Buckets: []float64{0.1}})
+	reg.MustRegister(requests, latency)
+	requests.WithLabelValues("a:1").Add(3)
+	latency.Observe(0.05)
+	latency.Observe(2)
+
+	var got []string
+	var headers http.Header
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		body, _ := io.ReadAll(r.Body)
+		raw, err := s2.Decode(nil, body)
+		if err != nil {
+			t.Errorf("snappy decode: %v", err)
+			return
+		}
+		got = decodeWriteRequest(t, raw)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer endpoint.Close()
+
+	rw := NewRemoteWriter(config.RemoteWriteConfig{
+		URL:      endpoint.URL + "/api/v1/push",
+		Headers:  map[string]string{"X-Scope-OrgID": "tenant-1"},
+		Interval: time.Minute,
+		Timeout:  time.Second,
+	}, reg, zap.NewNop())
+	if err := rw.push(context.Background()); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+
+	if headers.Get("Content-Encoding") != "snappy" || headers.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" ||
+		headers.Get("X-Scope-OrgID") != "tenant-1" {
+		t.Errorf("headers: got %v", headers)
+	}
+	want := []string{
+		`test_latency_seconds_bucket{le="0.1"} 1`,
+		`test_latency_seconds_bucket{le="+Inf"} 2`,
+		`test_latency_seconds_sum{} 2.05`,
+		`test_latency_seconds_count{} 2`,
+		`test_requests_total{backend="a:1"} 3`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("series:\ngot  %q\nwant %q", got, want)
+	}
+}
+
+func TestRemoteWriter_EndpointErrorReturned(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer endpoint.Close()
+
+	rw := NewRemoteWriter(config.RemoteWriteConfig{URL: endpoint.URL, Timeout: time.Second}, prometheus.NewRegistry(), zap.NewNop())
+	if err := rw.push(context.Background()); err == nil || !strings.Contains(err.Error(), "out of order") {
+		t.Errorf("expected the endpoint's error, got %v", err)
+	}
+}
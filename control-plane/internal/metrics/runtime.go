@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// ConfigureRuntime sets whether the default registry exposes the control
+// plane process's Go runtime and process metrics (metrics.runtime). The
+// registry starts with the basic Go and process collectors; they're
+// dropped when disabled, and when enabled the Go collector is swapped for
+// one that also reports GC, memory and scheduler runtime/metrics.
+func ConfigureRuntime(enabled bool) {
+	registerRuntime(prometheus.DefaultRegisterer, enabled)
+}
+
+func registerRuntime(reg prometheus.Registerer, enabled bool) {
+	reg.Unregister(collectors.NewGoCollector())
+	reg.Unregister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	if !enabled {
+		return
+	}
+	reg.MustRegister(
+		collectors.NewGoCollector(collectors.WithGoCollectorRuntimeMetrics(
+			collectors.MetricsGC, collectors.MetricsMemory, collectors.MetricsScheduler,
+		)),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
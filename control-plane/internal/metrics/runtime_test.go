@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+func gatheredNames(t *testing.T, reg *prometheus.Registry) []string {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	var names []string
+	for _, mf := range families {
+		names = append(names, mf.GetName())
+	}
+	return names
+}
+
+func TestRegisterRuntime(t *testing.T) {
+	// Set up as the default registry is
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	registerRuntime(reg, false)
+	if names := gatheredNames(t, reg); len(names) != 0 {
+		t.Errorf("disabled: still exposing %v", names)
+	}
+
+	registerRuntime(reg, true)
+	names := " " + strings.Join(gatheredNames(t, reg), " ") + " "
+	for _, want := range []string{"go_goroutines", "go_gc_duration_seconds", "go_sched_goroutines_goroutines", "process_open_fds"} {
+		if !strings.Contains(names, " "+want+" ") {
+			t.Errorf("enabled: missing %s", want)
+		}
+	}
+}
@@ -2,41 +2,83 @@ package metrics
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
 	"net/http"
-	"net/http/pprof"
+	"path"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type Server struct {
 	collector *Collector
+	gatherer  prometheus.Gatherer
+	fleet     prometheus.Gatherer
 	server    *http.Server
 }
 
-func NewServer(collector *Collector) *Server {
+// NewServer serves what gatherer reports at /metrics, normally the
+// default registry wrapped by NewGatherer, and what fleet reports at
+// /metrics/fleet.
+func NewServer(collector *Collector, gatherer, fleet prometheus.Gatherer) *Server {
 	return &Server{
 		collector: collector,
+		gatherer:  gatherer,
+		fleet:     fleet,
 	}
 }
 
-func (s *Server) Start(address string) error {
-	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.Handler())
-
-	mux.HandleFunc("/debug/pprof/", pprof.Index)
-	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
-	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
-	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
-	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
-
+// Start serves on address, over HTTPS when tlsConfig is non-nil.
+func (s *Server) Start(address string, tlsConfig *tls.Config) error {
 	s.server = &http.Server{
 		Addr:    address,
-		Handler: mux,
+		Handler: s.handler(),
 	}
 
+	if tlsConfig != nil {
+		s.server.TLSConfig = tlsConfig
+		return s.server.ListenAndServeTLS("", "")
+	}
 	return s.server.ListenAndServe()
 }
 
+// handler serves the metrics and nothing else: the port has no auth, so
+// pprof is only served by the admin API's /debug, behind
+// admin.debug_endpoints and admin auth.
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	// OpenMetrics, when a scraper asks for it, is what carries the
+	// histograms' exemplars
+	mux.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		metricsHandler(s.gatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})))
+	mux.Handle("/metrics/fleet", metricsHandler(s.fleet, promhttp.HandlerOpts{}))
+	return mux
+}
+
+// metricsHandler serves what gatherer reports, limited to the metric
+// names matching a collect[] glob when the request has any, e.g.
+// /metrics?collect[]=proxy_active_connections&collect[]=proxy_latency_*,
+// so a scraper that only wants the global series doesn't pay for every
+// per-backend one.
+func metricsHandler(gatherer prometheus.Gatherer, opts promhttp.HandlerOpts) http.Handler {
+	all := promhttp.HandlerFor(gatherer, opts)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		names := r.URL.Query()["collect[]"]
+		if len(names) == 0 {
+			all.ServeHTTP(w, r)
+			return
+		}
+		for _, name := range names {
+			if _, err := path.Match(name, ""); err != nil {
+				http.Error(w, fmt.Sprintf("invalid collect[] pattern %q", name), http.StatusBadRequest)
+				return
+			}
+		}
+		promhttp.HandlerFor(FilterGatherer(gatherer, names, nil), opts).ServeHTTP(w, r)
+	})
+}
+
 func (s *Server) Shutdown(ctx context.Context) error {
 	if s.server != nil {
 		return s.server.Shutdown(ctx)
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestMetricsHandler_CollectFilter(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	for _, name := range []string{"proxy_active_connections", "proxy_latency_p99_ms", "proxy_backend_connections"} {
+		g := prometheus.NewGauge(prometheus.GaugeOpts{Name: name, Help: name})
+		reg.MustRegister(g)
+	}
+	h := metricsHandler(reg, promhttp.HandlerOpts{})
+
+	get := func(query string) (int, string) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics"+query, nil))
+		body, _ := io.ReadAll(rec.Body)
+		return rec.Code, string(body)
+	}
+
+	_, body := get("?collect[]=proxy_active_connections&collect[]=proxy_latency_*")
+	if !strings.Contains(body, "proxy_active_connections ") || !strings.Contains(body, "proxy_latency_p99_ms ") ||
+		strings.Contains(body, "proxy_backend_connections") {
+		t.Errorf("filtered body:\n%s", body)
+	}
+	if _, body := get(""); !strings.Contains(body, "proxy_backend_connections ") {
+		t.Errorf("unfiltered body missing series:\n%s", body)
+	}
+	if code, _ := get("?collect[]=%5B"); code != http.StatusBadRequest {
+		t.Errorf("malformed pattern: got %d, want 400", code)
+	}
+}
+
+func TestServer_NoPprof(t *testing.T) {
+	s := NewServer(nil, prometheus.NewRegistry(), prometheus.NewRegistry())
+	h := s.handler()
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/cmdline", "/debug/pprof/heap"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: got %d, want 404", path, rec.Code)
+		}
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics/fleet", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("/metrics/fleet: got %d, want 200", rec.Code)
+	}
+}
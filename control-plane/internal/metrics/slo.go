@@ -0,0 +1,192 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+)
+
+// sloSampleInterval is how often an SLO's running totals are kept for
+// its windows; snapshots in between only update the current totals.
+const sloSampleInterval = 10 * time.Second
+
+// sloTracker computes the error budget burn rate of each configured SLO
+// over each of its windows from the streamed snapshots: the window's bad
+// fraction divided by the fraction the objective allows, so 1 spends the
+// budget exactly over the SLO period and 14.4 over 1h is the usual page.
+type sloTracker struct {
+	burnRate  *prometheus.GaugeVec
+	objective *prometheus.GaugeVec
+
+	mu   sync.Mutex
+	slos []*trackedSLO
+}
+
+type trackedSLO struct {
+	cfg config.SLOConfig
+	// Running totals over every instance since the SLO was set
+	total, bad float64
+	// last holds each instance's cumulative values as last reported
+	last    map[string]*sloTotals
+	samples []sloSample
+}
+
+type sloTotals struct {
+	total, bad float64
+}
+
+type sloSample struct {
+	at         time.Time
+	total, bad float64
+}
+
+func newSLOTracker() *sloTracker {
+	return &sloTracker{
+		burnRate: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "aegis_slo_burn_rate",
+			Help: "Error budget burn rate per SLO over each window (1 = spending exactly the budget)",
+		}, []string{"slo", "window"}),
+		objective: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "aegis_slo_objective",
+			Help: "Target good fraction per SLO",
+		}, []string{"slo"}),
+	}
+}
+
+// set replaces the tracked SLOs, starting each one's windows afresh.
+func (t *sloTracker) set(slos []config.SLOConfig, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.burnRate.Reset()
+	t.objective.Reset()
+	t.slos = make([]*trackedSLO, 0, len(slos))
+	for _, cfg := range slos {
+		t.slos = append(t.slos, &trackedSLO{
+			cfg:     cfg,
+			last:    make(map[string]*sloTotals),
+			samples: []sloSample{{at: now}},
+		})
+		t.objective.WithLabelValues(cfg.Name).Set(cfg.Objective)
+		for _, w := range cfg.Windows {
+			t.burnRate.WithLabelValues(cfg.Name, windowLabel(w)).Set(0)
+		}
+	}
+}
+
+// observe folds one instance's snapshot into every SLO and refreshes the
+// burn rates.
+func (t *sloTracker) observe(now time.Time, instance string, data *pb.MetricsData, configured func(string) bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, slo := range t.slos {
+		total, bad := slo.count(data, configured)
+		last := slo.last[instance]
+		if last == nil {
+			// The first snapshot's totals cover the data plane's whole
+			// lifetime, not any window: it only sets the baseline
+			slo.last[instance] = &sloTotals{total: total, bad: bad}
+		} else {
+			slo.total += counterDelta(&last.total, int64(total))
+			slo.bad += counterDelta(&last.bad, int64(bad))
+		}
+		slo.record(now)
+
+		for _, w := range slo.cfg.Windows {
+			t.burnRate.WithLabelValues(slo.cfg.Name, windowLabel(w)).Set(slo.burnRate(now, w))
+		}
+	}
+}
+
+// count returns the cumulative connections data reports for the SLO and
+// how many of them were bad. For an availability SLO that's every attempt
+// (successful connects plus the failures before one was made) and those
+// failures; a connection that breaks after connecting is a successful
+// connect, not counted again. A data plane that predates connect_failures
+// can't tell the two apart, so all its failures are bad, as they were
+// before. For a latency SLO it's every timed connect and those slower than
+// the threshold, read off the largest histogram bound at or under it.
+func (s *trackedSLO) count(data *pb.MetricsData, configured func(string) bool) (total, bad float64) {
+	if s.cfg.Latency > 0 {
+		hist := data.ConnectLatency
+		if s.cfg.Backend != "" {
+			hist = nil
+			for _, b := range data.BackendMetrics {
+				if b.Address == s.cfg.Backend {
+					hist = b.ConnectLatency
+				}
+			}
+		}
+		if hist == nil || len(hist.BoundsMs) != len(hist.Counts) {
+			return 0, 0
+		}
+		threshold := float64(s.cfg.Latency) / float64(time.Millisecond)
+		var good uint64
+		for i, bound := range hist.BoundsMs {
+			if bound <= threshold {
+				good = hist.Counts[i]
+			}
+		}
+		return float64(hist.Count), float64(hist.Count - good)
+	}
+
+	for _, b := range data.BackendMetrics {
+		if (s.cfg.Backend == "" && configured(b.Address)) || b.Address == s.cfg.Backend {
+			if b.ConnectFailures == nil {
+				total += float64(b.TotalRequests + b.FailedRequests)
+				bad += float64(b.FailedRequests)
+				continue
+			}
+			total += float64(b.TotalRequests + *b.ConnectFailures)
+			bad += float64(*b.ConnectFailures)
+		}
+	}
+	return total, bad
+}
+
+// record keeps the running totals as a sample every sloSampleInterval,
+// dropping samples the longest window no longer needs.
+func (s *trackedSLO) record(now time.Time) {
+	if now.Sub(s.samples[len(s.samples)-1].at) >= sloSampleInterval {
+		s.samples = append(s.samples, sloSample{at: now, total: s.total, bad: s.bad})
+	}
+	var longest time.Duration
+	for _, w := range s.cfg.Windows {
+		longest = max(longest, w)
+	}
+	// Keep the newest sample at or before the longest window's start as
+	// that window's baseline
+	cutoff := now.Add(-longest)
+	drop := 0
+	for drop+1 < len(s.samples) && !s.samples[drop+1].at.After(cutoff) {
+		drop++
+	}
+	s.samples = s.samples[drop:]
+}
+
+// burnRate compares the totals now with the newest sample at or before
+// the window's start, or the oldest sample while there's less history
+// than the window.
+func (s *trackedSLO) burnRate(now time.Time, window time.Duration) float64 {
+	base := s.samples[0]
+	start := now.Add(-window)
+	for _, sample := range s.samples {
+		if sample.at.After(start) {
+			break
+		}
+		base = sample
+	}
+	total := s.total - base.total
+	if total <= 0 {
+		return 0
+	}
+	return (s.bad - base.bad) / total / (1 - s.cfg.Objective)
+}
+
+func windowLabel(w time.Duration) string {
+	return model.Duration(w).String()
+}
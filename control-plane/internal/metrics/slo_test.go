@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSLOTracker_BurnRatePerWindow(t *testing.T) {
+	slos := sharedTestCollector(t).slos
+	t0 := time.Now()
+	slos.set([]config.SLOConfig{
+		{Name: "slo-test-availability", Objective: 0.99, Windows: []time.Duration{5 * time.Minute, time.Hour}},
+		{Name: "slo-test-latency", Objective: 0.9, Latency: 300 * time.Millisecond, Windows: []time.Duration{time.Hour}},
+	}, t0)
+	defer slos.set(nil, time.Now())
+
+	// A tenth of the connects break mid-stream, failed requests that
+	// aren't bad events on top of being successful connects
+	snapshot := func(requests, failures int64, fast, medium uint64) *pb.MetricsData {
+		return &pb.MetricsData{
+			BackendMetrics: []*pb.BackendMetrics{{Address: "slo-test-a:1", TotalRequests: requests,
+				FailedRequests: failures + requests/10, ConnectFailures: proto.Int64(failures)}},
+			ConnectLatency: &pb.LatencyHistogram{BoundsMs: []float64{100, 250, 500}, Counts: []uint64{fast, medium, medium}, Count: medium + 10},
+		}
+	}
+	// The first snapshot is only the baseline, however much it reports
+	slos.observe(t0, "dp-1", snapshot(100, 50, 10, 10), allBackends)
+	slos.observe(t0.Add(time.Minute), "dp-1", snapshot(200, 51, 50, 90), allBackends)
+	slos.observe(t0.Add(10*time.Minute), "dp-1", snapshot(300, 61, 100, 190), allBackends)
+
+	for _, tc := range []struct {
+		slo, window string
+		want        float64
+	}{
+		// Since the 1m sample: 10 bad of 110
+		{"slo-test-availability", "5m", 10.0 / 110 / 0.01},
+		// Less than an hour of history, so since the start: 11 of 211
+		{"slo-test-availability", "1h", 11.0 / 211 / 0.01},
+		// 180 connects, none slower than 250ms, the last bound under 300ms
+		{"slo-test-latency", "1h", 0},
+	} {
+		got := testutil.ToFloat64(slos.burnRate.WithLabelValues(tc.slo, tc.window))
+		if math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%s over %s: got %v, want %v", tc.slo, tc.window, got, tc.want)
+		}
+	}
+	if got := testutil.ToFloat64(slos.objective.WithLabelValues("slo-test-availability")); got != 0.99 {
+		t.Errorf("objective: got %v", got)
+	}
+}
+
+func TestSLOTracker_AvailabilityWithoutConnectFailures(t *testing.T) {
+	slos := sharedTestCollector(t).slos
+	t0 := time.Now()
+	slos.set([]config.SLOConfig{{Name: "slo-test-legacy", Objective: 0.99, Windows: []time.Duration{time.Hour}}}, t0)
+	defer slos.set(nil, time.Now())
+
+	// A data plane that predates connect_failures reports only failures
+	snapshot := func(requests, failures int64) *pb.MetricsData {
+		return &pb.MetricsData{BackendMetrics: []*pb.BackendMetrics{{Address: "slo-test-c:1",
+			TotalRequests: requests, FailedRequests: failures}}}
+	}
+	slos.observe(t0, "dp-1", snapshot(100, 5), allBackends)
+	slos.observe(t0.Add(time.Minute), "dp-1", snapshot(200, 15), allBackends)
+
+	// 10 bad of 110 attempts, not none
+	if got := testutil.ToFloat64(slos.burnRate.WithLabelValues("slo-test-legacy", "1h")); math.Abs(got-10.0/110/0.01) > 1e-9 {
+		t.Errorf("burn rate: got %v, want %v", got, 10.0/110/0.01)
+	}
+}
+
+func TestSLOTracker_LatencyCountsSlowConnects(t *testing.T) {
+	slos := sharedTestCollector(t).slos
+	t0 := time.Now()
+	slos.set([]config.SLOConfig{{Name: "slo-test-slow", Backend: "slo-test-b:1", Objective: 0.9, Latency: 250 * time.Millisecond,
+		Windows: []time.Duration{time.Hour}}}, t0)
+	defer slos.set(nil, time.Now())
+
+	snapshot := func(under, count uint64) *pb.MetricsData {
+		return &pb.MetricsData{BackendMetrics: []*pb.BackendMetrics{{
+			Address:        "slo-test-b:1",
+			ConnectLatency: &pb.LatencyHistogram{BoundsMs: []float64{250, 1000}, Counts: []uint64{under, count}, Count: count},
+		}}}
+	}
+	slos.observe(t0, "dp-1", snapshot(0, 0), allBackends)
+	slos.observe(t0.Add(time.Minute), "dp-1", snapshot(80, 100), allBackends)
+
+	// 20 of 100 over 250ms against a 10% budget
+	if got := testutil.ToFloat64(slos.burnRate.WithLabelValues("slo-test-slow", "1h")); math.Abs(got-2) > 1e-9 {
+		t.Errorf("burn rate: got %v, want 2", got)
+	}
+}
@@ -0,0 +1,223 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+)
+
+const (
+	// topSlots is how many time slots a top talkers window is split into;
+	// the window slides one slot at a time.
+	topSlots = 30
+	// maxTopClients bounds the clients counted per slot, so a scan from
+	// many addresses can't grow memory without limit. Further clients are
+	// only counted in UntrackedClients.
+	maxTopClients = 10000
+)
+
+// TopEntry is one backend's or client's traffic over the top talkers
+// window. Bytes are both directions.
+type TopEntry struct {
+	Key       string
+	Requests  int64
+	Errors    int64
+	Bytes     int64
+	ErrorRate float64
+}
+
+// Top ranks backends and clients over the last Window. The traffic lists
+// are ordered by bytes, then requests; the error rate lists only hold
+// entries with errors.
+type Top struct {
+	Window              time.Duration
+	BackendsByTraffic   []TopEntry
+	BackendsByErrorRate []TopEntry
+	ClientsByTraffic    []TopEntry
+	ClientsByErrorRate  []TopEntry
+	// UntrackedClients counts connections from clients beyond the
+	// per-slot limit, which appear in no list.
+	UntrackedClients int64
+}
+
+// topTalkers keeps per-slot traffic totals per backend, from the metrics
+// stream's deltas, and per client, from the access log stream, over a
+// sliding window.
+type topTalkers struct {
+	mu     sync.Mutex
+	window time.Duration
+	slots  [topSlots]topSlot
+}
+
+type topSlot struct {
+	// epoch numbers the slot since the Unix epoch; a slot holding an older
+	// epoch is stale and reset before reuse
+	epoch     int64
+	backends  map[string]*TopEntry
+	clients   map[string]*TopEntry
+	untracked int64
+}
+
+func newTopTalkers(window time.Duration) *topTalkers {
+	return &topTalkers{window: window}
+}
+
+// setWindow changes the window, dropping everything counted so far since
+// the slots no longer line up.
+func (t *topTalkers) setWindow(window time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if window == t.window {
+		return
+	}
+	t.window = window
+	t.slots = [topSlots]topSlot{}
+}
+
+func (t *topTalkers) epoch(now time.Time) int64 {
+	return now.UnixNano() / int64(t.window/topSlots)
+}
+
+// slotLocked returns the slot for now, emptied if it last held an older
+// epoch.
+func (t *topTalkers) slotLocked(now time.Time) *topSlot {
+	epoch := t.epoch(now)
+	slot := &t.slots[epoch%topSlots]
+	if slot.epoch != epoch || slot.backends == nil {
+		*slot = topSlot{
+			epoch:    epoch,
+			backends: make(map[string]*TopEntry),
+			clients:  make(map[string]*TopEntry),
+		}
+	}
+	return slot
+}
+
+// addBackends counts one snapshot's per-backend deltas.
+func (t *topTalkers) addBackends(now time.Time, deltas []TopEntry) {
+	if len(deltas) == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	slot := t.slotLocked(now)
+	for _, d := range deltas {
+		addTopEntry(slot.backends, d)
+	}
+}
+
+// addClient counts one finished connection or session from the access
+// log.
+func (t *topTalkers) addClient(now time.Time, rec *pb.AccessLogRecord) {
+	if rec.ClientIp == "" {
+		return
+	}
+	d := TopEntry{Key: rec.ClientIp, Requests: 1, Bytes: int64(rec.BytesSent + rec.BytesReceived)}
+	if rec.Error != "" {
+		d.Errors = 1
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	slot := t.slotLocked(now)
+	if _, ok := slot.clients[d.Key]; !ok && len(slot.clients) >= maxTopClients {
+		slot.untracked++
+		return
+	}
+	addTopEntry(slot.clients, d)
+}
+
+func addTopEntry(entries map[string]*TopEntry, d TopEntry) {
+	e := entries[d.Key]
+	if e == nil {
+		e = &TopEntry{Key: d.Key}
+		entries[d.Key] = e
+	}
+	e.Requests += d.Requests
+	e.Errors += d.Errors
+	e.Bytes += d.Bytes
+}
+
+// top sums the slots still inside the window and ranks them, keeping n
+// entries per list.
+func (t *topTalkers) top(now time.Time, n int) Top {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	current := t.epoch(now)
+	backends := make(map[string]*TopEntry)
+	clients := make(map[string]*TopEntry)
+	result := Top{Window: t.window}
+	for i := range t.slots {
+		slot := &t.slots[i]
+		if slot.backends == nil || slot.epoch <= current-topSlots || slot.epoch > current {
+			continue
+		}
+		for _, e := range slot.backends {
+			addTopEntry(backends, *e)
+		}
+		for _, e := range slot.clients {
+			addTopEntry(clients, *e)
+		}
+		result.UntrackedClients += slot.untracked
+	}
+	result.BackendsByTraffic, result.BackendsByErrorRate = rankTop(backends, n)
+	result.ClientsByTraffic, result.ClientsByErrorRate = rankTop(clients, n)
+	return result
+}
+
+func rankTop(entries map[string]*TopEntry, n int) (byTraffic, byErrorRate []TopEntry) {
+	all := make([]TopEntry, 0, len(entries))
+	for _, e := range entries {
+		if e.Requests > 0 {
+			e.ErrorRate = float64(e.Errors) / float64(e.Requests)
+		}
+		all = append(all, *e)
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		a, b := all[i], all[j]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		if a.Requests != b.Requests {
+			return a.Requests > b.Requests
+		}
+		return a.Key < b.Key
+	})
+	byTraffic = append([]TopEntry{}, all[:min(n, len(all))]...)
+
+	sort.Slice(all, func(i, j int) bool {
+		a, b := all[i], all[j]
+		if a.ErrorRate != b.ErrorRate {
+			return a.ErrorRate > b.ErrorRate
+		}
+		if a.Errors != b.Errors {
+			return a.Errors > b.Errors
+		}
+		return a.Key < b.Key
+	})
+	byErrorRate = []TopEntry{}
+	for _, e := range all {
+		if e.Errors == 0 || len(byErrorRate) == n {
+			break
+		}
+		byErrorRate = append(byErrorRate, e)
+	}
+	return byTraffic, byErrorRate
+}
+
+// TopClientsSink is an access log sink feeding the client rankings of
+// Collector.Top.
+type TopClientsSink struct {
+	top *topTalkers
+}
+
+func (s *TopClientsSink) Name() string { return "top_talkers" }
+
+func (s *TopClientsSink) Write(rec *pb.AccessLogRecord) error {
+	s.top.addClient(time.Now(), rec)
+	return nil
+}
+
+func (s *TopClientsSink) Close() error { return nil }
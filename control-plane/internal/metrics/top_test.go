@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/lazzerex/aegis/control-plane/proto"
+)
+
+func TestTopTalkers_RanksOverWindow(t *testing.T) {
+	top := newTopTalkers(time.Minute)
+	t0 := time.Unix(1700000000, 0)
+
+	top.addBackends(t0, []TopEntry{
+		{Key: "a:1", Requests: 100, Errors: 1, Bytes: 1000},
+		{Key: "b:1", Requests: 10, Errors: 5, Bytes: 5000},
+		{Key: "c:1", Requests: 50},
+	})
+	top.addBackends(t0.Add(30*time.Second), []TopEntry{{Key: "a:1", Requests: 100, Bytes: 9000}})
+	top.addClient(t0, &pb.AccessLogRecord{ClientIp: "10.0.0.1", BytesSent: 10, BytesReceived: 20})
+	top.addClient(t0, &pb.AccessLogRecord{ClientIp: "10.0.0.2", Error: "connection reset"})
+
+	got := top.top(t0.Add(45*time.Second), 2)
+	if keys := topKeys(got.BackendsByTraffic); keys != "a:1 b:1" {
+		t.Errorf("backends by traffic: got %s", keys)
+	}
+	if keys := topKeys(got.BackendsByErrorRate); keys != "b:1 a:1" {
+		t.Errorf("backends by error rate: got %s", keys)
+	}
+	if e := got.BackendsByTraffic[0]; e.Requests != 200 || e.Bytes != 10000 || e.ErrorRate != 0.005 {
+		t.Errorf("a:1 totals: got %+v", e)
+	}
+	if keys := topKeys(got.ClientsByTraffic); keys != "10.0.0.1 10.0.0.2" {
+		t.Errorf("clients by traffic: got %s", keys)
+	}
+	if keys := topKeys(got.ClientsByErrorRate); keys != "10.0.0.2" {
+		t.Errorf("clients by error rate: got %s", keys)
+	}
+
+	// A minute on, only the second snapshot is inside the window
+	got = top.top(t0.Add(70*time.Second), 10)
+	if keys := topKeys(got.BackendsByTraffic); keys != "a:1" || got.BackendsByTraffic[0].Requests != 100 {
+		t.Errorf("after sliding: got %+v", got.BackendsByTraffic)
+	}
+	if len(got.ClientsByTraffic) != 0 || len(got.BackendsByErrorRate) != 0 {
+		t.Errorf("expired entries still ranked: %+v", got)
+	}
+}
+
+func TestUpdateFromProto_TopSkipsBaseline(t *testing.T) {
+	c := sharedTestCollector(t)
+	snapshot := func(requests, failures int64) *pb.MetricsData {
+		return &pb.MetricsData{InstanceId: "collector-test-top", BackendMetrics: []*pb.BackendMetrics{
+			{Address: "collector-test-top:1", TotalRequests: requests, FailedRequests: failures},
+		}}
+	}
+	c.UpdateFromProto(snapshot(1000, 100))
+	c.UpdateFromProto(snapshot(1010, 105))
+
+	for _, e := range c.Top(1000).BackendsByTraffic {
+		if e.Key == "collector-test-top:1" {
+			if e.Requests != 15 || e.Errors != 5 {
+				t.Errorf("got %+v, want 15 requests and 5 errors since the first snapshot", e)
+			}
+			return
+		}
+	}
+	t.Error("backend not ranked")
+}
+
+func topKeys(entries []TopEntry) string {
+	keys := make([]string, len(entries))
+	for i, e := range entries {
+		keys[i] = e.Key
+	}
+	return strings.Join(keys, " ")
+}
@@ -0,0 +1,269 @@
+// Package schedule applies config overlays at the times their cron
+// expressions match, and undoes them again when their windows end.
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/events"
+	"go.uber.org/zap"
+)
+
+// retryRevert is how long after a failed undo it's tried again: a
+// schedule's window has ended, so its overlay mustn't just stay.
+const retryRevert = time.Minute
+
+// Applier makes an overlay part of the running config, returning the
+// overlay that undoes it and the new config version. The API server is
+// one, so scheduled changes are serialized with API changes and versioned
+// like them.
+type Applier interface {
+	ApplyOverlay(schedule string, overlay config.Overlay) (undo config.Overlay, version string, err error)
+}
+
+// Status is a schedule's state, for GET /schedules. Times are zero when
+// they haven't happened or won't.
+type Status struct {
+	Name     string
+	Cron     string
+	Duration time.Duration
+	// Active is set while the overlay is in effect; one without a
+	// duration stays active until the next reload.
+	Active      bool
+	NextApply   time.Time
+	LastApplied time.Time
+	// RevertAt is when an active overlay with a duration is undone.
+	RevertAt     time.Time
+	LastReverted time.Time
+	// LastError is the last apply or undo failure, cleared by the next
+	// success.
+	LastError string
+}
+
+// Scheduler runs the schedules of one scheduler config at a time. Each
+// application is a config push through the Applier; the overlays are
+// never written to the config file.
+type Scheduler struct {
+	applier Applier
+	logger  *zap.Logger
+	feed    *events.Feed
+	reload  chan config.SchedulerConfig
+
+	mu        sync.Mutex
+	loc       *time.Location
+	schedules []*schedule
+}
+
+type schedule struct {
+	Status
+	cron config.CronSchedule
+	cfg  config.ScheduleConfig
+	undo config.Overlay
+}
+
+// New returns a scheduler for cfg, which must have been validated. Nothing
+// is applied until Run.
+func New(cfg config.SchedulerConfig, applier Applier, logger *zap.Logger) *Scheduler {
+	s := &Scheduler{
+		applier: applier,
+		logger:  logger,
+		reload:  make(chan config.SchedulerConfig, 1),
+	}
+	s.set(cfg)
+	return s
+}
+
+// SetEventFeed publishes schedule_applied and schedule_reverted events to
+// feed.
+func (s *Scheduler) SetEventFeed(feed *events.Feed) {
+	s.feed = feed
+}
+
+// Reload replaces the schedules once the config has been reloaded. The
+// reloaded config has none of the old overlays, so nothing is undone;
+// schedules whose window is open are applied to it afresh. It doesn't
+// block, so it's safe to call with the API's apply lock held.
+func (s *Scheduler) Reload(cfg config.SchedulerConfig) {
+	// Only the latest config matters
+	select {
+	case <-s.reload:
+	default:
+	}
+	s.reload <- cfg
+}
+
+// Statuses reports every schedule in config order.
+func (s *Scheduler) Statuses() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make([]Status, len(s.schedules))
+	for i, sc := range s.schedules {
+		statuses[i] = sc.Status
+	}
+	return statuses
+}
+
+// Timezone is the zone the schedules are read in.
+func (s *Scheduler) Timezone() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loc.String()
+}
+
+// Run applies and undoes overlays until ctx is done, starting with those
+// whose window is already open.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.catchUp(time.Now())
+	for {
+		timer := time.NewTimer(time.Hour)
+		if next, ok := s.nextEvent(); ok {
+			timer.Reset(time.Until(next))
+		}
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case cfg := <-s.reload:
+			timer.Stop()
+			s.set(cfg)
+			s.catchUp(time.Now())
+		case <-timer.C:
+			s.fire(time.Now())
+		}
+	}
+}
+
+func (s *Scheduler) set(cfg config.SchedulerConfig) {
+	schedules := make([]*schedule, 0, len(cfg.Schedules))
+	for _, sc := range cfg.Schedules {
+		cron, err := config.ParseCron(sc.Cron)
+		if err != nil {
+			// Validate has already rejected it
+			continue
+		}
+		schedules = append(schedules, &schedule{
+			Status: Status{Name: sc.Name, Cron: sc.Cron, Duration: sc.Duration},
+			cron:   cron,
+			cfg:    sc,
+		})
+	}
+	s.mu.Lock()
+	s.loc = cfg.Location()
+	s.schedules = schedules
+	s.mu.Unlock()
+}
+
+// catchUp applies the schedules whose window holds now, as if they'd just
+// fired, and works out every schedule's next application.
+func (s *Scheduler) catchUp(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now = now.In(s.loc)
+	for _, sc := range s.schedules {
+		sc.NextApply = sc.cron.Next(now)
+		if sc.Duration <= 0 || sc.Active {
+			continue
+		}
+		// The last time it fired within one duration of now
+		var opened time.Time
+		for t := sc.cron.Next(now.Add(-sc.Duration)); !t.IsZero() && !t.After(now); t = sc.cron.Next(t) {
+			opened = t
+		}
+		if !opened.IsZero() {
+			s.applyLocked(sc, now, opened.Add(sc.Duration))
+		}
+	}
+}
+
+// fire undoes the overlays whose window ended by now, then applies the
+// schedules due.
+func (s *Scheduler) fire(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now = now.In(s.loc)
+	for _, sc := range s.schedules {
+		if sc.Active && !sc.RevertAt.IsZero() && !sc.RevertAt.After(now) {
+			s.revertLocked(sc, now)
+		}
+	}
+	for _, sc := range s.schedules {
+		if sc.NextApply.IsZero() || sc.NextApply.After(now) {
+			continue
+		}
+		var until time.Time
+		if sc.Duration > 0 {
+			until = sc.NextApply.Add(sc.Duration)
+		}
+		sc.NextApply = sc.cron.Next(now)
+		if sc.Active {
+			// Fired again inside its own window: keep the values it
+			// found the first time, and the window open for longer
+			sc.RevertAt = until
+			continue
+		}
+		s.applyLocked(sc, now, until)
+	}
+}
+
+// nextEvent is the earliest pending application or undo.
+func (s *Scheduler) nextEvent() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var next time.Time
+	for _, sc := range s.schedules {
+		for _, t := range []time.Time{sc.NextApply, sc.RevertAt} {
+			if !t.IsZero() && (next.IsZero() || t.Before(next)) {
+				next = t
+			}
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// applyLocked applies sc's overlay, to be undone at until unless that's
+// zero. The caller holds s.mu, which also keeps two of the scheduler's own
+// changes from interleaving.
+func (s *Scheduler) applyLocked(sc *schedule, now, until time.Time) {
+	undo, version, err := s.applier.ApplyOverlay(sc.Name, sc.cfg.Overlay)
+	if err != nil {
+		sc.LastError = "apply: " + err.Error()
+		s.logger.Error("Failed to apply scheduled overlay", zap.String("schedule", sc.Name), zap.Error(err))
+		return
+	}
+	sc.Active, sc.undo, sc.LastApplied, sc.RevertAt, sc.LastError = true, undo, now, until, ""
+
+	attrs := map[string]string{"schedule": sc.Name, "version": version}
+	message := "Schedule " + sc.Name + " applied"
+	if !until.IsZero() {
+		attrs["until"] = until.Format(time.RFC3339)
+		message += " until " + until.Format(time.RFC3339)
+	}
+	s.feed.Publish(events.TypeScheduleApplied, message, attrs)
+	s.logger.Info("Scheduled overlay applied",
+		zap.String("schedule", sc.Name),
+		zap.Time("until", until),
+		zap.String("version", version))
+}
+
+// revertLocked puts back what sc's overlay replaced; a failure is retried
+// after retryRevert. The caller holds s.mu.
+func (s *Scheduler) revertLocked(sc *schedule, now time.Time) {
+	_, version, err := s.applier.ApplyOverlay(sc.Name, sc.undo)
+	if err != nil {
+		sc.LastError = "revert: " + err.Error()
+		sc.RevertAt = now.Add(retryRevert)
+		s.logger.Error("Failed to undo scheduled overlay", zap.String("schedule", sc.Name), zap.Error(err))
+		return
+	}
+	sc.Active, sc.undo, sc.LastReverted, sc.RevertAt, sc.LastError = false, config.Overlay{}, now, time.Time{}, ""
+
+	s.feed.Publish(events.TypeScheduleReverted, fmt.Sprintf("Schedule %s reverted", sc.Name),
+		map[string]string{"schedule": sc.Name, "version": version})
+	s.logger.Info("Scheduled overlay reverted",
+		zap.String("schedule", sc.Name),
+		zap.String("version", version))
+}
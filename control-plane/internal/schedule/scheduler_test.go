@@ -0,0 +1,128 @@
+package schedule
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/events"
+	"go.uber.org/zap"
+)
+
+// fakeApplier applies overlays to a rate limit and records them.
+type fakeApplier struct {
+	rps     int
+	applied []string
+	fail    bool
+}
+
+func (f *fakeApplier) ApplyOverlay(schedule string, o config.Overlay) (config.Overlay, string, error) {
+	if f.fail {
+		return config.Overlay{}, "", errors.New("data plane unavailable")
+	}
+	undo := config.Overlay{RateLimit: &config.RateLimitConfig{RequestsPerSecond: f.rps}}
+	f.rps = o.RateLimit.RequestsPerSecond
+	f.applied = append(f.applied, schedule)
+	return undo, "v", nil
+}
+
+func nightly(duration time.Duration) config.SchedulerConfig {
+	return config.SchedulerConfig{
+		Timezone: "UTC",
+		Schedules: []config.ScheduleConfig{{
+			Name:     "night",
+			Cron:     "0 22 * * *",
+			Duration: duration,
+			Overlay:  config.Overlay{RateLimit: &config.RateLimitConfig{RequestsPerSecond: 100}},
+		}},
+	}
+}
+
+func at(hour, minute int) time.Time {
+	return time.Date(2026, 10, 14, hour, minute, 0, 0, time.UTC)
+}
+
+func TestScheduler_AppliesAndReverts(t *testing.T) {
+	applier := &fakeApplier{rps: 1000}
+	s := New(nightly(8*time.Hour), applier, zap.NewNop())
+	feed := events.NewFeed()
+	s.SetEventFeed(feed)
+	sub := feed.Subscribe(0)
+
+	s.catchUp(at(12, 0))
+	if st := s.Statuses()[0]; st.Active || !st.NextApply.Equal(at(22, 0)) || len(applier.applied) != 0 {
+		t.Fatalf("before the window: got %+v, applied %v", st, applier.applied)
+	}
+	if next, ok := s.nextEvent(); !ok || !next.Equal(at(22, 0)) {
+		t.Errorf("next event: got %s", next)
+	}
+
+	s.fire(at(22, 0))
+	st := s.Statuses()[0]
+	if !st.Active || applier.rps != 100 || !st.RevertAt.Equal(at(22, 0).Add(8*time.Hour)) || !st.NextApply.Equal(at(22, 0).AddDate(0, 0, 1)) {
+		t.Fatalf("applied: got %+v, rps %v", st, applier.rps)
+	}
+	if ev := <-sub.Events; ev.Type != events.TypeScheduleApplied || ev.Attributes["schedule"] != "night" || ev.Attributes["until"] == "" {
+		t.Errorf("applied event: got %+v", ev)
+	}
+
+	s.fire(at(22, 0).Add(8 * time.Hour))
+	if st := s.Statuses()[0]; st.Active || applier.rps != 1000 || st.LastReverted.IsZero() || !st.RevertAt.IsZero() {
+		t.Errorf("reverted: got %+v, rps %v", st, applier.rps)
+	}
+	if ev := <-sub.Events; ev.Type != events.TypeScheduleReverted {
+		t.Errorf("reverted event: got %+v", ev)
+	}
+}
+
+func TestScheduler_CatchUpInsideWindow(t *testing.T) {
+	applier := &fakeApplier{rps: 1000}
+	s := New(nightly(8*time.Hour), applier, zap.NewNop())
+
+	// Started at 03:00, inside the window opened at 22:00 the night before
+	s.catchUp(at(3, 0))
+	st := s.Statuses()[0]
+	if !st.Active || applier.rps != 100 || !st.RevertAt.Equal(at(6, 0)) || !st.NextApply.Equal(at(22, 0)) {
+		t.Errorf("caught up: got %+v, rps %v", st, applier.rps)
+	}
+
+	// A schedule without a duration has no window to catch up on
+	s = New(nightly(0), &fakeApplier{}, zap.NewNop())
+	s.catchUp(at(23, 0))
+	if st := s.Statuses()[0]; st.Active {
+		t.Errorf("no duration: got %+v", st)
+	}
+}
+
+func TestScheduler_RetriesFailedRevert(t *testing.T) {
+	applier := &fakeApplier{rps: 1000}
+	s := New(nightly(time.Hour), applier, zap.NewNop())
+	s.catchUp(at(12, 0))
+	s.fire(at(22, 0))
+
+	applier.fail = true
+	s.fire(at(23, 0))
+	st := s.Statuses()[0]
+	if !st.Active || st.LastError == "" || !st.RevertAt.Equal(at(23, 1)) {
+		t.Fatalf("failed revert: got %+v", st)
+	}
+
+	applier.fail = false
+	s.fire(at(23, 1))
+	if st := s.Statuses()[0]; st.Active || st.LastError != "" || applier.rps != 1000 {
+		t.Errorf("retried revert: got %+v, rps %v", st, applier.rps)
+	}
+}
+
+func TestScheduler_Reload(t *testing.T) {
+	s := New(nightly(time.Hour), &fakeApplier{}, zap.NewNop())
+	s.Reload(config.SchedulerConfig{})
+	// Only the latest reload is kept, and Reload never blocks
+	s.Reload(config.SchedulerConfig{Timezone: "UTC"})
+
+	s.set(<-s.reload)
+	if len(s.Statuses()) != 0 || s.Timezone() != "UTC" {
+		t.Errorf("reloaded: got %+v in %s", s.Statuses(), s.Timezone())
+	}
+}
@@ -0,0 +1,266 @@
+// Package slowstart ramps TCP backends added to a running config up to
+// their weight, so a cold instance isn't handed its full share of
+// connections the moment it joins. The weights are stepped by the control
+// plane, each step a backend reload like any other.
+package slowstart
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/events"
+	"go.uber.org/zap"
+)
+
+// Applier is what the controller steps weights through: the API server,
+// which owns the running config.
+type Applier interface {
+	// RampBackends pushes a copy of the running TCP backends with the
+	// weights weigh gives them, serialized with every other change, and
+	// adopts it once the data plane has accepted it. Nothing is pushed
+	// when weigh changes nothing.
+	RampBackends(weigh func([]config.Backend) []config.Backend) error
+}
+
+// Ramp is a backend on its way up to its weight, for GET /slow-start.
+type Ramp struct {
+	Address string
+	// Weight is the backend's weight in the running config, Target the
+	// one it's configured with.
+	Weight  int
+	Target  int
+	Started time.Time
+	Ends    time.Time
+}
+
+// Controller keeps the ramps under way and steps them every interval.
+// Ramps live in memory: the control plane starts with every backend at
+// its full weight.
+type Controller struct {
+	applier Applier
+	logger  *zap.Logger
+	feed    *events.Feed
+	wake    chan struct{}
+
+	mu sync.Mutex
+	// cfg is the slow start config of the last change begun
+	cfg   config.SlowStartConfig
+	ramps map[string]*ramp
+}
+
+type ramp struct {
+	Ramp
+	// next is when the ramp is stepped again
+	next time.Time
+}
+
+func New(applier Applier, logger *zap.Logger) *Controller {
+	return &Controller{
+		applier: applier,
+		logger:  logger,
+		wake:    make(chan struct{}, 1),
+		ramps:   make(map[string]*ramp),
+	}
+}
+
+// SetEventFeed publishes slow_start_* events to feed.
+func (c *Controller) SetEventFeed(feed *events.Feed) {
+	c.feed = feed
+}
+
+// Ramps reports the ramps under way, by address.
+func (c *Controller) Ramps() []Ramp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ramps := make([]Ramp, 0, len(c.ramps))
+	for _, r := range c.ramps {
+		ramps = append(ramps, r.Ramp)
+	}
+	slices.SortFunc(ramps, func(a, b Ramp) int { return cmp.Compare(a.Address, b.Address) })
+	return ramps
+}
+
+// Begin returns next, the TCP backends about to replace previous, with the
+// weights the data plane should get under cfg: a backend previous doesn't
+// have starts at cfg.InitialPercent of its weight, and one being ramped
+// keeps its ramped weight unless next sets another, which ends its ramp.
+// The caller pushes the result and, once it's accepted, calls commit; it
+// holds the lock that serializes changes across both, so a step can't
+// come between them. next isn't modified.
+func (c *Controller) Begin(previous, next []config.Backend, cfg config.SlowStartConfig) ([]config.Backend, func()) {
+	return c.begin(previous, next, cfg, time.Now())
+}
+
+// Targets returns backends with each one being ramped at its target, the
+// weight to write back to the config file.
+func (c *Controller) Targets(backends []config.Backend) []config.Backend {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := slices.Clone(backends)
+	for i, b := range out {
+		if r, ok := c.ramps[b.Address]; ok {
+			out[i].Weight = r.Target
+		}
+	}
+	return out
+}
+
+// Run steps the ramps until ctx is done.
+func (c *Controller) Run(ctx context.Context) {
+	for {
+		timer := time.NewTimer(time.Hour)
+		if next, ok := c.nextStep(); ok {
+			timer.Reset(time.Until(next))
+		}
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-c.wake:
+			timer.Stop()
+		case <-timer.C:
+			c.step(time.Now())
+		}
+	}
+}
+
+func (c *Controller) begin(previous, next []config.Backend, cfg config.SlowStartConfig, now time.Time) ([]config.Backend, func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	before := make(map[string]config.Backend, len(previous))
+	for _, b := range previous {
+		before[b.Address] = b
+	}
+	out := slices.Clone(next)
+	ramps := make(map[string]*ramp)
+	var started []*ramp
+	for i, b := range out {
+		prev, existed := before[b.Address]
+		r, ramping := c.ramps[b.Address]
+		switch {
+		case !existed:
+			if cfg.Weight(b.Weight, 0) == b.Weight {
+				continue
+			}
+			r = &ramp{Ramp: Ramp{Address: b.Address, Target: b.Weight, Started: now, Ends: now.Add(cfg.Duration)},
+				next: now.Add(cfg.Interval)}
+			out[i].Weight = cfg.Weight(b.Weight, 0)
+			r.Weight = out[i].Weight
+			started = append(started, r)
+		case !ramping:
+			continue
+		case b.Weight == prev.Weight:
+			// Left as it was by a change to something else
+		case b.Weight == r.Target:
+			// A reload giving the configured weight again
+			out[i].Weight = prev.Weight
+		default:
+			// Set by hand; that's the weight it's meant to have now
+			continue
+		}
+		if !cfg.Enabled() {
+			out[i].Weight = r.Target
+			continue
+		}
+		ramps[b.Address] = r
+	}
+
+	return out, func() {
+		c.mu.Lock()
+		c.cfg, c.ramps = cfg, ramps
+		c.mu.Unlock()
+		for _, r := range started {
+			c.feed.Publish(events.TypeSlowStartStarted,
+				fmt.Sprintf("Backend %s slow starting at weight %d of %d", r.Address, r.Weight, r.Target),
+				map[string]string{"address": r.Address, "weight": fmt.Sprint(r.Weight), "target": fmt.Sprint(r.Target)})
+			c.logger.Info("Backend slow start begun",
+				zap.String("address", r.Address),
+				zap.Int("weight", r.Weight),
+				zap.Int("target", r.Target),
+				zap.Duration("duration", cfg.Duration))
+		}
+		if len(started) > 0 {
+			c.signal()
+		}
+	}
+}
+
+// nextStep is when the next ramp is due a step.
+func (c *Controller) nextStep() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var next time.Time
+	for _, r := range c.ramps {
+		if next.IsZero() || r.next.Before(next) {
+			next = r.next
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// step raises every ramp that's due to the weight it should have by now,
+// and ends those at their target. A failed push is tried again an
+// interval later.
+func (c *Controller) step(now time.Time) {
+	var pushed map[string]int
+	err := c.applier.RampBackends(func(backends []config.Backend) []config.Backend {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		pushed = make(map[string]int)
+		for i, b := range backends {
+			r, ok := c.ramps[b.Address]
+			if !ok || now.Before(r.next) {
+				continue
+			}
+			backends[i].Weight = c.cfg.Weight(r.Target, now.Sub(r.Started))
+			pushed[b.Address] = backends[i].Weight
+		}
+		return backends
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var finished []*ramp
+	for address, weight := range pushed {
+		r, ok := c.ramps[address]
+		if !ok {
+			continue
+		}
+		// The last step lands on the end of the ramp
+		r.next = now.Add(c.cfg.Interval)
+		if now.Before(r.Ends) && r.Ends.Before(r.next) {
+			r.next = r.Ends
+		}
+		if err != nil {
+			continue
+		}
+		r.Weight = weight
+		if weight == r.Target {
+			delete(c.ramps, address)
+			finished = append(finished, r)
+		}
+	}
+	if err != nil {
+		c.logger.Error("Failed to push slow start weights", zap.Int("backends", len(pushed)), zap.Error(err))
+		return
+	}
+	for _, r := range finished {
+		c.feed.Publish(events.TypeSlowStartCompleted,
+			fmt.Sprintf("Backend %s slow start completed at weight %d", r.Address, r.Target),
+			map[string]string{"address": r.Address, "weight": fmt.Sprint(r.Target)})
+		c.logger.Info("Backend slow start completed", zap.String("address", r.Address), zap.Int("weight", r.Target))
+	}
+}
+
+func (c *Controller) signal() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,175 @@
+package slowstart
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/events"
+	"go.uber.org/zap"
+)
+
+// fakeApplier is a running backend list the controller steps.
+type fakeApplier struct {
+	backends []config.Backend
+	pushes   int
+	fail     bool
+}
+
+func (f *fakeApplier) RampBackends(weigh func([]config.Backend) []config.Backend) error {
+	updated := weigh(slices.Clone(f.backends))
+	if slices.Equal(updated, f.backends) {
+		return nil
+	}
+	if f.fail {
+		return errors.New("data plane unavailable")
+	}
+	f.pushes++
+	f.backends = updated
+	return nil
+}
+
+// change replaces the running backends with next the way the API server
+// does, through begin and commit.
+func (f *fakeApplier) change(c *Controller, next []config.Backend, now time.Time) {
+	next, commit := c.begin(f.backends, next, cfg, now)
+	f.backends = next
+	commit()
+}
+
+func (f *fakeApplier) weight(address string) int {
+	for _, b := range f.backends {
+		if b.Address == address {
+			return b.Weight
+		}
+	}
+	return -1
+}
+
+var (
+	cfg = config.SlowStartConfig{Duration: time.Minute, InitialPercent: 10, Interval: 20 * time.Second}
+	t0  = time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+)
+
+func backends(weights ...int) []config.Backend {
+	out := make([]config.Backend, len(weights))
+	for i, w := range weights {
+		out[i] = config.Backend{Address: string(rune('a'+i)) + ":3000", Weight: w}
+	}
+	return out
+}
+
+func TestController_RampsAddedBackend(t *testing.T) {
+	applier := &fakeApplier{backends: backends(100)}
+	c := New(applier, zap.NewNop())
+	feed := events.NewFeed()
+	c.SetEventFeed(feed)
+	sub := feed.Subscribe(0)
+
+	applier.change(c, backends(100, 200), t0)
+	if w := applier.weight("b:3000"); w != 20 {
+		t.Fatalf("added backend: got weight %d, want 20", w)
+	}
+	if w := applier.weight("a:3000"); w != 100 {
+		t.Errorf("existing backend: got weight %d, want 100", w)
+	}
+	if e := <-sub.Events; e.Type != events.TypeSlowStartStarted || e.Attributes["target"] != "200" {
+		t.Errorf("got event %+v", e)
+	}
+	if next, ok := c.nextStep(); !ok || !next.Equal(t0.Add(cfg.Interval)) {
+		t.Errorf("next step: got %s, %v", next, ok)
+	}
+
+	// Nothing is pushed before a step is due
+	c.step(t0.Add(10 * time.Second))
+	if applier.pushes != 0 {
+		t.Errorf("early step pushed %d times", applier.pushes)
+	}
+	for _, step := range []struct {
+		at   time.Duration
+		want int
+	}{{20 * time.Second, 80}, {40 * time.Second, 140}, {time.Minute, 200}} {
+		c.step(t0.Add(step.at))
+		if w := applier.weight("b:3000"); w != step.want {
+			t.Errorf("after %s: got weight %d, want %d", step.at, w, step.want)
+		}
+	}
+	if ramps := c.Ramps(); len(ramps) != 0 {
+		t.Errorf("finished ramp still listed: %+v", ramps)
+	}
+	if e := <-sub.Events; e.Type != events.TypeSlowStartCompleted || e.Attributes["weight"] != "200" {
+		t.Errorf("got event %+v", e)
+	}
+	if _, ok := c.nextStep(); ok {
+		t.Error("next step scheduled with no ramps")
+	}
+}
+
+func TestController_ChangesDuringRamp(t *testing.T) {
+	applier := &fakeApplier{backends: backends(100)}
+	c := New(applier, zap.NewNop())
+	applier.change(c, backends(100, 100, 100), t0)
+
+	// A reload giving the configured weights again keeps the ramps
+	applier.change(c, backends(50, 100, 100), t0.Add(time.Second))
+	if got := []int{applier.weight("a:3000"), applier.weight("b:3000"), applier.weight("c:3000")}; !slices.Equal(got, []int{50, 10, 10}) {
+		t.Errorf("after reload: got weights %v", got)
+	}
+	if got := c.Targets(applier.backends); got[1].Weight != 100 || got[2].Weight != 100 {
+		t.Errorf("targets: got %+v", got)
+	}
+
+	// A weight set by hand ends the ramp; a removed backend's ends too
+	next := backends(50, 30)
+	applier.change(c, next, t0.Add(2*time.Second))
+	if w := applier.weight("b:3000"); w != 30 {
+		t.Errorf("hand-set weight: got %d", w)
+	}
+	if ramps := c.Ramps(); len(ramps) != 0 {
+		t.Errorf("ramps left: %+v", ramps)
+	}
+
+	// Turning slow start off puts ramped backends at their weight
+	applier.change(c, backends(50, 30, 100), t0.Add(3*time.Second))
+	next, commit := c.begin(applier.backends, backends(50, 30, 100), config.SlowStartConfig{}, t0.Add(4*time.Second))
+	commit()
+	if next[2].Weight != 100 || len(c.Ramps()) != 0 {
+		t.Errorf("slow start off: got %+v, ramps %+v", next, c.Ramps())
+	}
+}
+
+func TestController_UncommittedChange(t *testing.T) {
+	applier := &fakeApplier{backends: backends(100)}
+	c := New(applier, zap.NewNop())
+	// The push failed, so commit is never called
+	c.begin(applier.backends, backends(100, 100), cfg, t0)
+	if ramps := c.Ramps(); len(ramps) != 0 {
+		t.Errorf("uncommitted ramps: %+v", ramps)
+	}
+}
+
+func TestController_RetriesFailedStep(t *testing.T) {
+	applier := &fakeApplier{backends: backends(100)}
+	c := New(applier, zap.NewNop())
+	applier.change(c, backends(100, 100), t0)
+
+	applier.fail = true
+	c.step(t0.Add(20 * time.Second))
+	if w := applier.weight("b:3000"); w != 10 {
+		t.Errorf("failed step: got weight %d", w)
+	}
+	if next, _ := c.nextStep(); !next.Equal(t0.Add(40 * time.Second)) {
+		t.Errorf("retry: got %s", next)
+	}
+	if ramps := c.Ramps(); len(ramps) != 1 || ramps[0].Weight != 10 {
+		t.Errorf("ramps: %+v", ramps)
+	}
+
+	applier.fail = false
+	c.step(t0.Add(40 * time.Second))
+	if w := applier.weight("b:3000"); w != 70 {
+		t.Errorf("retried step: got weight %d", w)
+	}
+}
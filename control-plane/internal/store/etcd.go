@@ -0,0 +1,63 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/etcd"
+)
+
+// Etcd keeps values in etcd keys under a prefix, through the etcd
+// package's gateway client.
+type Etcd struct {
+	client *etcd.Client
+	prefix string
+}
+
+// NewEtcd returns a store keeping values under cfg.Prefix on the etcd
+// cluster cfg names.
+func NewEtcd(cfg config.StoreConfig) (*Etcd, error) {
+	client, err := etcd.New(cfg.EtcdConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &Etcd{client: client, prefix: cfg.Prefix}, nil
+}
+
+func (e *Etcd) Get(ctx context.Context, key string) ([]byte, error) {
+	kv, err := e.client.Get(ctx, e.prefix+key)
+	if err != nil {
+		return nil, err
+	}
+	if kv == nil {
+		return nil, ErrNotFound
+	}
+	return kv.Value, nil
+}
+
+func (e *Etcd) Put(ctx context.Context, key string, value []byte) error {
+	body := map[string]string{"key": etcd.Encode(e.prefix + key), "value": base64.StdEncoding.EncodeToString(value)}
+	return e.client.Post(ctx, "/v3/kv/put", body, &struct{}{})
+}
+
+func (e *Etcd) Delete(ctx context.Context, key string) error {
+	return e.client.Post(ctx, "/v3/kv/deleterange", map[string]string{"key": etcd.Encode(e.prefix + key)}, &struct{}{})
+}
+
+func (e *Etcd) List(ctx context.Context, prefix string) (map[string][]byte, error) {
+	kvs, err := e.client.List(ctx, e.prefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string][]byte, len(kvs))
+	for _, kv := range kvs {
+		out[strings.TrimPrefix(string(kv.Key), e.prefix)] = kv.Value
+	}
+	return out, nil
+}
+
+func (e *Etcd) Close() error {
+	return nil
+}
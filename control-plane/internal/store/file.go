@@ -0,0 +1,123 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// File keeps values in memory and in a JSON file, rewritten atomically on
+// every change and read back when it's opened, so state survives a
+// restart of the one control plane that owns it. Values are base64 in the
+// file.
+type File struct {
+	path   string
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+// OpenFile reads the state in path, which needn't exist yet.
+func OpenFile(path string) (*File, error) {
+	f := &File{path: path, values: make(map[string][]byte)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading the state store: %w", err)
+	}
+	if err := json.Unmarshal(data, &f.values); err != nil {
+		return nil, fmt.Errorf("the state store %s isn't one: %w", path, err)
+	}
+	if f.values == nil {
+		f.values = make(map[string][]byte)
+	}
+	return f, nil
+}
+
+func (f *File) Get(ctx context.Context, key string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	value, ok := f.values[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return slices.Clone(value), nil
+}
+
+func (f *File) Put(ctx context.Context, key string, value []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	previous, had := f.values[key]
+	f.values[key] = slices.Clone(value)
+	if err := f.write(); err != nil {
+		if had {
+			f.values[key] = previous
+		} else {
+			delete(f.values, key)
+		}
+		return err
+	}
+	return nil
+}
+
+func (f *File) Delete(ctx context.Context, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	previous, had := f.values[key]
+	if !had {
+		return nil
+	}
+	delete(f.values, key)
+	if err := f.write(); err != nil {
+		f.values[key] = previous
+		return err
+	}
+	return nil
+}
+
+func (f *File) List(ctx context.Context, prefix string) (map[string][]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return matching(f.values, prefix), nil
+}
+
+func (f *File) Close() error {
+	return nil
+}
+
+// write replaces the file with the values, through a temporary file in
+// the same directory so a crash leaves the old one or the new one. The
+// caller holds f.mu.
+func (f *File) write() error {
+	data, err := json.Marshal(f.values)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), "."+filepath.Base(f.path)+".*")
+	if err != nil {
+		return fmt.Errorf("writing the state store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing the state store: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing the state store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing the state store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return fmt.Errorf("writing the state store: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Memory keeps values in the process, for a control plane that doesn't
+// need its state to outlive it.
+type Memory struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+func NewMemory() *Memory {
+	return &Memory{values: make(map[string][]byte)}
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.values[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return slices.Clone(value), nil
+}
+
+func (m *Memory) Put(ctx context.Context, key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[key] = slices.Clone(value)
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.values, key)
+	return nil
+}
+
+func (m *Memory) List(ctx context.Context, prefix string) (map[string][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return matching(m.values, prefix), nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+// matching copies the values under keys starting with prefix.
+func matching(values map[string][]byte, prefix string) map[string][]byte {
+	out := make(map[string][]byte)
+	for key := range maps.Keys(values) {
+		if strings.HasPrefix(key, prefix) {
+			out[key] = slices.Clone(values[key])
+		}
+	}
+	return out
+}
@@ -0,0 +1,218 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+)
+
+// Redis keeps values as the fields of one hash in a Redis database,
+// speaking RESP over a single connection that's dialed again after any
+// error.
+type Redis struct {
+	address  string
+	username string
+	password string
+	db       int
+	key      string
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// redisError is an error reply.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// NewRedis returns a store keeping values in the hash cfg.Key. Nothing is
+// dialed until the first call.
+func NewRedis(cfg config.StoreConfig) *Redis {
+	return &Redis{address: cfg.Address, username: cfg.Username, password: cfg.Password, db: cfg.DB, key: cfg.Key}
+}
+
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	reply, err := r.do(ctx, "HGET", r.key, key)
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, ErrNotFound
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("redis: HGET answered %T", reply)
+	}
+	return value, nil
+}
+
+func (r *Redis) Put(ctx context.Context, key string, value []byte) error {
+	_, err := r.do(ctx, "HSET", r.key, key, string(value))
+	return err
+}
+
+func (r *Redis) Delete(ctx context.Context, key string) error {
+	_, err := r.do(ctx, "HDEL", r.key, key)
+	return err
+}
+
+// List reads the whole hash, the state kept in it staying small.
+func (r *Redis) List(ctx context.Context, prefix string) (map[string][]byte, error) {
+	reply, err := r.do(ctx, "HGETALL", r.key)
+	if err != nil {
+		return nil, err
+	}
+	pairs, ok := reply.([]any)
+	if !ok || len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("redis: HGETALL answered %T", reply)
+	}
+	out := make(map[string][]byte)
+	for i := 0; i < len(pairs); i += 2 {
+		field, _ := pairs[i].([]byte)
+		value, _ := pairs[i+1].([]byte)
+		if strings.HasPrefix(string(field), prefix) {
+			out[string(field)] = value
+		}
+	}
+	return out, nil
+}
+
+func (r *Redis) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.Close()
+	r.conn = nil
+	return err
+}
+
+// do sends a command and reads its reply, dialing first if need be. Any
+// error but an error reply drops the connection, which may be left
+// mid-reply.
+func (r *Redis) do(ctx context.Context, args ...string) (any, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		if err := r.dial(ctx); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := r.roundTrip(ctx, args)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		r.conn.Close()
+		r.conn = nil
+	}
+	return reply, err
+}
+
+// dial connects, authenticates and selects the database. The caller holds
+// r.mu.
+func (r *Redis) dial(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", r.address)
+	if err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	r.conn, r.r = conn, bufio.NewReader(conn)
+	var setup [][]string
+	switch {
+	case r.password != "" && r.username != "":
+		setup = append(setup, []string{"AUTH", r.username, r.password})
+	case r.password != "":
+		setup = append(setup, []string{"AUTH", r.password})
+	}
+	if r.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(r.db)})
+	}
+	for _, args := range setup {
+		if _, err := r.roundTrip(ctx, args); err != nil {
+			conn.Close()
+			r.conn = nil
+			return fmt.Errorf("%w (%s)", err, args[0])
+		}
+	}
+	return nil
+}
+
+// roundTrip writes args as a RESP array of bulk strings and reads the
+// reply, within ctx's deadline. The caller holds r.mu.
+func (r *Redis) roundTrip(ctx context.Context, args []string) (any, error) {
+	deadline, _ := ctx.Deadline()
+	r.conn.SetDeadline(deadline)
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(r.conn, b.String()); err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	return readReply(r.r)
+}
+
+// readReply reads one RESP2 reply: a string for a simple string, []byte
+// for a bulk one, int64, []any for an array, nil for a null, and
+// redisError for an error.
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad array length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			// Not an error reply, even if it's one: the rest of the array
+			// is still to be read
+			if items[i], err = readReply(r); err != nil {
+				return nil, fmt.Errorf("redis: in an array: %s", strings.TrimPrefix(err.Error(), "redis: "))
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply %q", line)
+}
@@ -0,0 +1,141 @@
+// Package store keeps the control plane's runtime state, what it doesn't
+// write to the config file, as values under keys in a backend that can
+// outlive the process and be shared by HA replicas: memory, a file, etcd
+// or Redis.
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var storeErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "aegis_store_errors_total",
+	Help: "Failed state store calls per operation",
+}, []string{"op"})
+
+// ErrNotFound is returned by Get for a key with no value.
+var ErrNotFound = errors.New("not found in the state store")
+
+// Store holds values under keys. Keys are slash-separated paths, like
+// canary/rollout, each kind of state under its own first segment.
+type Store interface {
+	// Get returns the value under key, or ErrNotFound.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Put sets the value under key.
+	Put(ctx context.Context, key string, value []byte) error
+	// Delete removes key; one that isn't there is no error.
+	Delete(ctx context.Context, key string) error
+	// List returns the values under every key starting with prefix, by
+	// key.
+	List(ctx context.Context, prefix string) (map[string][]byte, error)
+	Close() error
+}
+
+// New opens the store cfg names, bounding each call by its timeout.
+func New(cfg config.StoreConfig) (Store, error) {
+	var s Store
+	switch cfg.Backend {
+	case config.StoreMemory, "":
+		s = NewMemory()
+	case config.StoreFile:
+		f, err := OpenFile(cfg.Path)
+		if err != nil {
+			return nil, err
+		}
+		s = f
+	case config.StoreEtcd:
+		e, err := NewEtcd(cfg)
+		if err != nil {
+			return nil, err
+		}
+		s = e
+	case config.StoreRedis:
+		s = NewRedis(cfg)
+	default:
+		return nil, fmt.Errorf("no state store backend %q", cfg.Backend)
+	}
+	return &timed{Store: s, timeout: cfg.Timeout}, nil
+}
+
+// timed bounds each call to a store by timeout and counts failures.
+type timed struct {
+	Store
+	timeout time.Duration
+}
+
+func (t *timed) bound(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, t.timeout)
+}
+
+func count(op string, err error) {
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		storeErrors.WithLabelValues(op).Inc()
+	}
+}
+
+func (t *timed) Get(ctx context.Context, key string) ([]byte, error) {
+	ctx, cancel := t.bound(ctx)
+	defer cancel()
+	value, err := t.Store.Get(ctx, key)
+	count("get", err)
+	return value, err
+}
+
+func (t *timed) Put(ctx context.Context, key string, value []byte) error {
+	ctx, cancel := t.bound(ctx)
+	defer cancel()
+	err := t.Store.Put(ctx, key, value)
+	count("put", err)
+	return err
+}
+
+func (t *timed) Delete(ctx context.Context, key string) error {
+	ctx, cancel := t.bound(ctx)
+	defer cancel()
+	err := t.Store.Delete(ctx, key)
+	count("delete", err)
+	return err
+}
+
+func (t *timed) List(ctx context.Context, prefix string) (map[string][]byte, error) {
+	ctx, cancel := t.bound(ctx)
+	defer cancel()
+	values, err := t.Store.List(ctx, prefix)
+	count("list", err)
+	return values, err
+}
+
+// GetJSON decodes the value under key into v, reporting false for none.
+func GetJSON(ctx context.Context, s Store, key string, v any) (bool, error) {
+	data, err := s.Get(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("%s in the state store: %w", key, err)
+	}
+	return true, nil
+}
+
+// PutJSON stores v encoded as JSON under key.
+func PutJSON(ctx context.Context, s Store, key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, key, data)
+}
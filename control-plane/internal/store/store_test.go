@@ -0,0 +1,220 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"github.com/lazzerex/aegis/control-plane/internal/etcd"
+)
+
+// fakeEtcd serves range, put and deleterange from a map, the way the JSON
+// gateway does.
+func fakeEtcd(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	kv := make(map[string][]byte)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var req struct {
+			Key      []byte `json:"key"`
+			RangeEnd []byte `json:"range_end"`
+			Value    []byte `json:"value"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		switch r.URL.Path {
+		case "/v3/kv/range":
+			var kvs []etcd.KV
+			for k, v := range kv {
+				if k == string(req.Key) || req.RangeEnd != nil && k >= string(req.Key) && k < string(req.RangeEnd) {
+					kvs = append(kvs, etcd.KV{Key: []byte(k), Value: v})
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]any{"kvs": kvs})
+		case "/v3/kv/put":
+			kv[string(req.Key)] = req.Value
+			w.Write([]byte("{}"))
+		case "/v3/kv/deleterange":
+			delete(kv, string(req.Key))
+			w.Write([]byte("{}"))
+		default:
+			t.Errorf("%s %s", r.Method, r.URL.Path)
+		}
+	}))
+}
+
+// fakeRedis answers the hash commands Redis uses from a map, requiring
+// AUTH with password when it's set.
+func fakeRedis(t *testing.T, password string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var mu sync.Mutex
+	hashes := make(map[string]map[string]string)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				authed := password == ""
+				for {
+					reply, err := readReply(r)
+					if err != nil {
+						return
+					}
+					var args []string
+					for _, a := range reply.([]any) {
+						args = append(args, string(a.([]byte)))
+					}
+					mu.Lock()
+					h := hashes[args[min(1, len(args)-1)]]
+					var out string
+					switch {
+					case args[0] == "AUTH" && args[len(args)-1] == password:
+						authed, out = true, "+OK\r\n"
+					case args[0] == "AUTH":
+						out = "-WRONGPASS invalid username-password pair\r\n"
+					case !authed:
+						out = "-NOAUTH Authentication required.\r\n"
+					case args[0] == "HSET":
+						if h == nil {
+							h = make(map[string]string)
+							hashes[args[1]] = h
+						}
+						h[args[2]] = args[3]
+						out = ":1\r\n"
+					case args[0] == "HGET":
+						if v, ok := h[args[2]]; ok {
+							out = fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+						} else {
+							out = "$-1\r\n"
+						}
+					case args[0] == "HDEL":
+						delete(h, args[2])
+						out = ":1\r\n"
+					case args[0] == "HGETALL":
+						out = fmt.Sprintf("*%d\r\n", 2*len(h))
+						for k, v := range h {
+							out += fmt.Sprintf("$%d\r\n%s\r\n$%d\r\n%s\r\n", len(k), k, len(v), v)
+						}
+					default:
+						out = "-ERR unknown command\r\n"
+					}
+					mu.Unlock()
+					conn.Write([]byte(out))
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestStores(t *testing.T) {
+	etcdServer := fakeEtcd(t)
+	defer etcdServer.Close()
+	for _, cfg := range []config.StoreConfig{
+		{Backend: config.StoreMemory},
+		{Backend: config.StoreFile, Path: filepath.Join(t.TempDir(), "state.json")},
+		{Backend: config.StoreEtcd, EtcdConfig: config.EtcdConfig{Endpoints: []string{"http://127.0.0.1:1", etcdServer.URL}}, Prefix: "/aegis/state/"},
+		{Backend: config.StoreRedis, Address: fakeRedis(t, "secret"), EtcdConfig: config.EtcdConfig{Password: "secret"}, Key: "aegis:state"},
+	} {
+		t.Run(cfg.Backend, func(t *testing.T) {
+			s, err := New(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			ctx := context.Background()
+			if _, err := s.Get(ctx, "config/applied"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("missing key: got %v", err)
+			}
+			for _, key := range []string{"audit/1", "audit/2", "auditor", "config/applied"} {
+				if err := s.Put(ctx, key, []byte(`{"key":"`+key+`"}`)); err != nil {
+					t.Fatalf("put %s: %v", key, err)
+				}
+			}
+			if v, err := s.Get(ctx, "config/applied"); err != nil || string(v) != `{"key":"config/applied"}` {
+				t.Errorf("get: got %s, %v", v, err)
+			}
+			if err := s.Delete(ctx, "audit/1"); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Delete(ctx, "audit/1"); err != nil {
+				t.Errorf("deleting a missing key: %v", err)
+			}
+			listed, err := s.List(ctx, "audit/")
+			var keys []string
+			for k := range listed {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			if err != nil || strings.Join(keys, ",") != "audit/2" || string(listed["audit/2"]) != `{"key":"audit/2"}` {
+				t.Errorf("list: got %q, %v", keys, err)
+			}
+
+			var applied struct{ Key string }
+			if ok, err := GetJSON(ctx, s, "config/applied", &applied); !ok || err != nil || applied.Key != "config/applied" {
+				t.Errorf("GetJSON: got %+v, %v, %v", applied, ok, err)
+			}
+			if ok, err := GetJSON(ctx, s, "canary/rollout", &applied); ok || err != nil {
+				t.Errorf("GetJSON, missing: got %v, %v", ok, err)
+			}
+		})
+	}
+}
+
+func TestFile_SurvivesReopening(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	f, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Put(context.Background(), "canary/rollout", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	f, err = OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := f.Get(context.Background(), "canary/rollout"); err != nil || string(v) != "{}" {
+		t.Errorf("after reopening: got %s, %v", v, err)
+	}
+
+	// A failed write leaves the value before in place
+	f.path = filepath.Join(t.TempDir(), "missing", "state.json")
+	if err := f.Put(context.Background(), "canary/rollout", []byte("[]")); err == nil {
+		t.Fatal("wrote to a missing directory")
+	}
+	if v, _ := f.Get(context.Background(), "canary/rollout"); string(v) != "{}" {
+		t.Errorf("after a failed write: got %s", v)
+	}
+}
+
+func TestRedis_Auth(t *testing.T) {
+	addr := fakeRedis(t, "secret")
+	r := NewRedis(config.StoreConfig{Address: addr, EtcdConfig: config.EtcdConfig{Password: "wrong"}, Key: "aegis:state"})
+	if _, err := r.Get(context.Background(), "config/applied"); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("wrong password: got %v", err)
+	}
+	r = NewRedis(config.StoreConfig{Address: addr, Key: "aegis:state"})
+	if _, err := r.Get(context.Background(), "config/applied"); err == nil || !strings.Contains(err.Error(), "NOAUTH") {
+		t.Errorf("no password: got %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+// Package version identifies the control plane build. Release builds stamp
+// the vars with
+// -ldflags "-X github.com/lazzerex/aegis/control-plane/internal/version.Version=...",
+// and likewise Commit and BuildDate; `make build-go` and the Docker image
+// do this from git. Unstamped builds fall back to the commit go build
+// embeds when built inside a checkout.
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+var (
+	Version = "dev"
+	Commit  string
+	// BuildDate is RFC 3339.
+	BuildDate string
+)
+
+// Info describes the running binary.
+type Info struct {
+	Version string `json:"version"`
+	// Commit is empty when the build wasn't stamped and has no VCS
+	// details, BuildDate when it wasn't stamped.
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	// Modified reports uncommitted changes in the tree it was built from,
+	// when known.
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// Get returns the build info, preferring a stamped commit over the
+// embedded one.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+	}
+	return info
+}
@@ -0,0 +1,19 @@
+package version
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestGet_PrefersStampedValues(t *testing.T) {
+	defer func(v, c, d string) { Version, Commit, BuildDate = v, c, d }(Version, Commit, BuildDate)
+	Version, Commit, BuildDate = "v1.4.0", "0123abcd", "2024-05-01T12:00:00Z"
+
+	info := Get()
+	if info.Version != "v1.4.0" || info.Commit != "0123abcd" || info.BuildDate != "2024-05-01T12:00:00Z" {
+		t.Errorf("stamped values: got %+v", info)
+	}
+	if info.GoVersion != runtime.Version() || info.Platform != runtime.GOOS+"/"+runtime.GOARCH {
+		t.Errorf("runtime details: got %+v", info)
+	}
+}
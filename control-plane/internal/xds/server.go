@@ -0,0 +1,150 @@
+package xds
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	clusterservice "github.com/envoyproxy/go-control-plane/envoy/service/cluster/v3"
+	discoveryservice "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	endpointservice "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
+	listenerservice "github.com/envoyproxy/go-control-plane/envoy/service/listener/v3"
+	routeservice "github.com/envoyproxy/go-control-plane/envoy/service/route/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	serverv3 "github.com/envoyproxy/go-control-plane/pkg/server/v3"
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// snapshotKey is the single cache key every node is served from. Aegis has
+// one logical proxy config (see ADR 0005), so all connected Envoys get the
+// same snapshot regardless of their node ID.
+const snapshotKey = "aegis"
+
+type constantHash struct{}
+
+func (constantHash) ID(*corev3.Node) string { return snapshotKey }
+
+// Server is an ADS server that stands in for the Rust data plane's gRPC
+// client: it implements the same UpdateConfig / ReloadBackendsWithHealth /
+// DrainConnections methods, so the admin API and health checker drive
+// Envoy exactly as they would drive aegis-data.
+type Server struct {
+	listenerMode string
+	logger       *zap.Logger
+	cache        cache.SnapshotCache
+	grpcServer   *grpc.Server
+
+	mu          sync.Mutex
+	version     uint64
+	cfg         *config.Config
+	healthState map[string]bool
+	drained     bool
+}
+
+func NewServer(xdsCfg config.XDSConfig, logger *zap.Logger) *Server {
+	return &Server{
+		listenerMode: xdsCfg.ListenerMode,
+		logger:       logger,
+		cache:        cache.NewSnapshotCache(true, constantHash{}, nil),
+	}
+}
+
+func (s *Server) Start(address string) error {
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to listen for xDS on %s: %w", address, err)
+	}
+
+	xdsServer := serverv3.NewServer(context.Background(), s.cache, nil)
+	s.mu.Lock()
+	s.grpcServer = grpc.NewServer()
+	grpcServer := s.grpcServer
+	s.mu.Unlock()
+
+	discoveryservice.RegisterAggregatedDiscoveryServiceServer(grpcServer, xdsServer)
+	clusterservice.RegisterClusterDiscoveryServiceServer(grpcServer, xdsServer)
+	endpointservice.RegisterEndpointDiscoveryServiceServer(grpcServer, xdsServer)
+	listenerservice.RegisterListenerDiscoveryServiceServer(grpcServer, xdsServer)
+	routeservice.RegisterRouteDiscoveryServiceServer(grpcServer, xdsServer)
+
+	return grpcServer.Serve(lis)
+}
+
+func (s *Server) Stop() {
+	s.mu.Lock()
+	grpcServer := s.grpcServer
+	s.mu.Unlock()
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+}
+
+func (s *Server) UpdateConfig(cfg *config.Config) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cfg = cfg
+	s.healthState = nil
+	s.drained = false
+	return s.publishLocked()
+}
+
+func (s *Server) ReloadBackendsWithHealth(backends []config.Backend, healthState map[string]bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cfg == nil {
+		return fmt.Errorf("backend reload failed: no configuration pushed yet")
+	}
+	// Copy rather than mutate: s.cfg is shared with the admin API.
+	cfg := *s.cfg
+	cfg.Proxy.Backends = backends
+	s.cfg = &cfg
+	s.healthState = healthState
+	return s.publishLocked()
+}
+
+// DrainConnections withdraws the listeners, which makes Envoy stop
+// accepting and close them after its own drain period. The timeout is
+// Envoy's to enforce (--drain-time-s), so it's only logged here.
+func (s *Server) DrainConnections(_ context.Context, timeoutSeconds int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cfg == nil {
+		return nil
+	}
+	s.drained = true
+	if err := s.publishLocked(); err != nil {
+		return fmt.Errorf("failed to drain connections: %w", err)
+	}
+	s.logger.Info("xDS listeners withdrawn for drain", zap.Int("timeout_seconds", timeoutSeconds))
+	return nil
+}
+
+func (s *Server) publishLocked() error {
+	resources, err := Translate(s.cfg, s.listenerMode, s.healthState, s.drained)
+	if err != nil {
+		return fmt.Errorf("failed to translate config to xDS: %w", err)
+	}
+
+	s.version++
+	snapshot, err := cache.NewSnapshot(strconv.FormatUint(s.version, 10), resources)
+	if err != nil {
+		return fmt.Errorf("failed to build xDS snapshot: %w", err)
+	}
+	if err := snapshot.Consistent(); err != nil {
+		return fmt.Errorf("inconsistent xDS snapshot: %w", err)
+	}
+	if err := s.cache.SetSnapshot(context.Background(), snapshotKey, snapshot); err != nil {
+		return fmt.Errorf("failed to set xDS snapshot: %w", err)
+	}
+
+	s.logger.Info("xDS snapshot published", zap.Uint64("version", s.version))
+	return nil
+}
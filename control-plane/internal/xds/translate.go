@@ -0,0 +1,727 @@
+package xds
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	httplocalrlv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/local_ratelimit/v3"
+	routerv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
+	statefulsessionv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/stateful_session/v3"
+	tlsinspectorv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/listener/tls_inspector/v3"
+	connlimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/connection_limit/v3"
+	hcmv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	localrlv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/local_ratelimit/v3"
+	tcpproxyv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	udpproxyv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/udp/udp_proxy/v3"
+	cookiesessionv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/http/stateful_session/cookie/v3"
+	previoushostsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/retry/host/previous_hosts/v3"
+	httpv3 "github.com/envoyproxy/go-control-plane/envoy/type/http/v3"
+	matcherv3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// Resource names. Fixed rather than derived from the config so a reload
+// updates resources in place instead of churning Envoy's cluster list.
+const (
+	TCPClusterName  = "aegis_tcp"
+	UDPClusterName  = "aegis_udp"
+	TCPListenerName = "aegis_tcp_listener"
+	UDPListenerName = "aegis_udp_listener"
+	RouteConfigName = "aegis_routes"
+	// PoolClusterPrefix names the cluster of each pool a header rule or
+	// SNI route sends to, e.g. aegis_pool_staging.
+	PoolClusterPrefix = "aegis_pool_"
+
+	connectionLimitFilter = "envoy.filters.network.connection_limit"
+	localRateLimitFilter  = "envoy.filters.network.local_ratelimit"
+	udpProxyFilter        = "envoy.filters.udp_listener.udp_proxy"
+	// previousHostsPredicate keeps retries and hedges off backends a
+	// request has already been sent to.
+	previousHostsPredicate = "envoy.retry_host_predicates.previous_hosts"
+	// httpLocalRateLimitFilter limits requests on the routes configuring
+	// it, and does nothing on the rest.
+	httpLocalRateLimitFilter = "envoy.filters.http.local_ratelimit"
+	// statefulSessionFilter sends a request carrying the sticky cookie to
+	// the backend it names, and sets it on responses to those without.
+	statefulSessionFilter = "envoy.filters.http.stateful_session"
+	cookieSessionState    = "envoy.http.stateful_session.cookie"
+)
+
+// Listener modes. "tcp" mirrors the Rust data plane (an L4 tcp_proxy);
+// "http" fronts the same cluster with an HTTP connection manager whose
+// routes are served over RDS.
+const (
+	ListenerModeTCP  = "tcp"
+	ListenerModeHTTP = "http"
+)
+
+// Translate converts the Aegis config model into xDS resources, keyed by
+// type URL as cache.NewSnapshot expects. healthState follows the same
+// convention as ReloadBackendsWithHealth: backends absent from the map are
+// treated as healthy. drained omits listeners entirely, which makes Envoy
+// drain and close them.
+func Translate(cfg *config.Config, listenerMode string, healthState map[string]bool, drained bool) (map[resource.Type][]types.Resource, error) {
+	p := cfg.Proxy
+	out := map[resource.Type][]types.Resource{
+		resource.ClusterType:  {buildCluster(TCPClusterName, p)},
+		resource.EndpointType: {buildLoadAssignment(TCPClusterName, p.ServingBackends(p.Backends), healthState)},
+	}
+
+	for _, pool := range p.RoutedPools() {
+		out[resource.ClusterType] = append(out[resource.ClusterType], buildCluster(PoolClusterPrefix+pool, p))
+		out[resource.EndpointType] = append(out[resource.EndpointType],
+			buildLoadAssignment(PoolClusterPrefix+pool, config.PoolBackends(p.Backends, pool), healthState))
+	}
+
+	if len(p.UdpBackends) > 0 {
+		out[resource.ClusterType] = append(out[resource.ClusterType], buildCluster(UDPClusterName, p))
+		out[resource.EndpointType] = append(out[resource.EndpointType], buildLoadAssignment(UDPClusterName, p.UdpBackends, healthState))
+	}
+
+	// Withdrawn listeners take the route config with them: snapshot
+	// consistency requires every route to be referenced by a listener.
+	if drained {
+		return out, nil
+	}
+
+	if listenerMode == ListenerModeHTTP {
+		routes, err := buildRouteConfig(p)
+		if err != nil {
+			return nil, err
+		}
+		out[resource.RouteType] = []types.Resource{routes}
+	}
+
+	tcpListener, err := buildTCPListener(p, listenerMode)
+	if err != nil {
+		return nil, err
+	}
+	out[resource.ListenerType] = []types.Resource{tcpListener}
+
+	if len(p.UdpBackends) > 0 && p.Listen.UDP != "" {
+		udpListener, err := buildUDPListener(p)
+		if err != nil {
+			return nil, err
+		}
+		out[resource.ListenerType] = append(out[resource.ListenerType], udpListener)
+	}
+
+	return out, nil
+}
+
+func buildCluster(name string, p config.ProxyConfig) *clusterv3.Cluster {
+	c := &clusterv3.Cluster{
+		Name:                 name,
+		ClusterDiscoveryType: &clusterv3.Cluster_Type{Type: clusterv3.Cluster_EDS},
+		EdsClusterConfig: &clusterv3.Cluster_EdsClusterConfig{
+			EdsConfig: &corev3.ConfigSource{
+				ResourceApiVersion:    corev3.ApiVersion_V3,
+				ConfigSourceSpecifier: &corev3.ConfigSource_Ads{Ads: &corev3.AggregatedConfigSource{}},
+			},
+		},
+		LbPolicy: lbPolicy(p.LoadBalancing),
+	}
+	if name == UDPClusterName && p.LoadBalancing.UDPAffinity.Mode == config.UDPAffinitySourceIP &&
+		c.LbPolicy != clusterv3.Cluster_RING_HASH && c.LbPolicy != clusterv3.Cluster_MAGLEV {
+		// The listener's source IP hash only takes with a hashing policy
+		c.LbPolicy = clusterv3.Cluster_RING_HASH
+	}
+	if c.LbPolicy == clusterv3.Cluster_RING_HASH && p.LoadBalancing.Hash.VirtualNodes > 0 {
+		// Envoy sizes the ring as a whole rather than per backend
+		backends := len(p.Backends)
+		if name == UDPClusterName {
+			backends = len(p.UdpBackends)
+		}
+		c.LbConfig = &clusterv3.Cluster_RingHashLbConfig_{RingHashLbConfig: &clusterv3.Cluster_RingHashLbConfig{
+			MinimumRingSize: wrapperspb.UInt64(uint64(p.LoadBalancing.Hash.VirtualNodes * max(backends, 1))),
+		}}
+	}
+	if c.LbPolicy == clusterv3.Cluster_MAGLEV && p.LoadBalancing.Hash.TableSize > 0 {
+		c.LbConfig = &clusterv3.Cluster_MaglevLbConfig_{MaglevLbConfig: &clusterv3.Cluster_MaglevLbConfig{
+			TableSize: wrapperspb.UInt64(uint64(p.LoadBalancing.Hash.TableSize)),
+		}}
+	}
+	if l := p.LoadBalancing.Locality; l.Policy == config.LocalityPreferLocal {
+		// Envoy routes by zone only with cluster_manager.local_cluster_name
+		// set in its bootstrap, and by default only for clusters of six or
+		// more hosts; Aegis backend lists are often smaller
+		c.CommonLbConfig = &clusterv3.Cluster_CommonLbConfig{
+			LocalityConfigSpecifier: &clusterv3.Cluster_CommonLbConfig_ZoneAwareLbConfig_{
+				ZoneAwareLbConfig: &clusterv3.Cluster_CommonLbConfig_ZoneAwareLbConfig{
+					RoutingEnabled: &typev3.Percent{Value: float64(100 - l.SpilloverPercent)},
+					MinClusterSize: wrapperspb.UInt64(1),
+				},
+			},
+		}
+	}
+	if d := p.Traffic.Timeout.Connect; d > 0 {
+		c.ConnectTimeout = durationpb.New(d)
+	}
+	if p.CircuitBreaker.ErrorThreshold > 0 {
+		// The Rust circuit breaker counts consecutive connect failures per
+		// backend; the closest Envoy equivalent is local-origin outlier
+		// ejection, with the breaker timeout as the ejection time.
+		c.OutlierDetection = &clusterv3.OutlierDetection{
+			SplitExternalLocalOriginErrors: true,
+			ConsecutiveLocalOriginFailure:  wrapperspb.UInt32(uint32(p.CircuitBreaker.ErrorThreshold)),
+			BaseEjectionTime:               durationpb.New(nonZero(p.CircuitBreaker.Timeout, 30*time.Second)),
+		}
+	}
+	return c
+}
+
+// lbPolicy maps Aegis algorithm names onto Envoy's. Weighted variants map to
+// ROUND_ROBIN because Envoy's round robin already honours endpoint weights.
+// Envoy has no latency-aware policy, so latency_ewma maps to LEAST_REQUEST,
+// the closest load-aware one. Session affinity needs a hash-based policy for
+// the source-IP hash policy on the listener to take effect.
+func lbPolicy(lb config.LoadBalancingConfig) clusterv3.Cluster_LbPolicy {
+	switch lb.Algorithm {
+	case config.AlgorithmLeastConnections, config.AlgorithmLatencyEWMA:
+		if lb.SessionAffinity {
+			return clusterv3.Cluster_RING_HASH
+		}
+		return clusterv3.Cluster_LEAST_REQUEST
+	case config.AlgorithmRandom:
+		if lb.SessionAffinity {
+			return clusterv3.Cluster_RING_HASH
+		}
+		return clusterv3.Cluster_RANDOM
+	case config.AlgorithmConsistentHash:
+		if lb.Hash.Method == config.HashMethodMaglev {
+			return clusterv3.Cluster_MAGLEV
+		}
+		return clusterv3.Cluster_RING_HASH
+	default:
+		if lb.SessionAffinity {
+			return clusterv3.Cluster_RING_HASH
+		}
+		return clusterv3.Cluster_ROUND_ROBIN
+	}
+}
+
+// buildLoadAssignment puts the backends of each tier and zone in their own
+// locality, carrying the zone and region for zone aware routing. Envoy
+// wants priorities numbered from 0 without gaps, so the tiers are
+// renumbered in order, backups after every primary.
+func buildLoadAssignment(cluster string, backends []config.Backend, healthState map[string]bool) *endpointv3.ClusterLoadAssignment {
+	type locality struct {
+		tier         config.Tier
+		region, zone string
+	}
+	groups := make(map[locality][]*endpointv3.LbEndpoint)
+	for _, b := range backends {
+		// Envoy rejects a zero load_balancing_weight; a weight-0 backend in
+		// Aegis means "configured but receives no traffic", so leave it out.
+		if b.Weight <= 0 {
+			continue
+		}
+		host, port, err := splitHostPort(b.Address)
+		if err != nil {
+			continue
+		}
+		status := corev3.HealthStatus_HEALTHY
+		if healthy, ok := healthState[b.Address]; ok && !healthy {
+			status = corev3.HealthStatus_UNHEALTHY
+		}
+		l := locality{config.Tier{Backup: b.Backup, Priority: b.Priority}, b.Region, b.Zone}
+		groups[l] = append(groups[l], &endpointv3.LbEndpoint{
+			HostIdentifier: &endpointv3.LbEndpoint_Endpoint{
+				Endpoint: &endpointv3.Endpoint{Address: socketAddress(host, port, corev3.SocketAddress_TCP)},
+			},
+			HealthStatus:        status,
+			LoadBalancingWeight: wrapperspb.UInt32(uint32(b.Weight)),
+		})
+	}
+	order := make([]locality, 0, len(groups))
+	for l := range groups {
+		order = append(order, l)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		a, b := order[i], order[j]
+		switch {
+		case a.tier != b.tier:
+			return a.tier.Less(b.tier)
+		case a.region != b.region:
+			return a.region < b.region
+		}
+		return a.zone < b.zone
+	})
+	var localities []*endpointv3.LocalityLbEndpoints
+	priority := -1
+	for i, l := range order {
+		if i == 0 || l.tier != order[i-1].tier {
+			priority++
+		}
+		lle := &endpointv3.LocalityLbEndpoints{LbEndpoints: groups[l], Priority: uint32(priority)}
+		if l.region != "" || l.zone != "" {
+			lle.Locality = &corev3.Locality{Region: l.region, Zone: l.zone}
+		}
+		localities = append(localities, lle)
+	}
+	if len(localities) == 0 {
+		localities = []*endpointv3.LocalityLbEndpoints{{LbEndpoints: []*endpointv3.LbEndpoint{}}}
+	}
+	return &endpointv3.ClusterLoadAssignment{
+		ClusterName: cluster,
+		Endpoints:   localities,
+	}
+}
+
+func buildTCPListener(p config.ProxyConfig, listenerMode string) (*listenerv3.Listener, error) {
+	host, port, err := splitHostPort(p.Listen.TCP)
+	if err != nil {
+		return nil, fmt.Errorf("proxy.listen.tcp: %w", err)
+	}
+
+	var filters []*listenerv3.Filter
+	// First, so connections over the limit are closed before they cost
+	// anything else; validation has refused overflow queue
+	if l := p.Traffic.ConnectionLimit; l.Max > 0 {
+		f, err := typedFilter(connectionLimitFilter, &connlimitv3.ConnectionLimit{
+			StatPrefix:     "aegis_connection_limit",
+			MaxConnections: wrapperspb.UInt64(uint64(l.Max)),
+		})
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	if rl := p.Traffic.RateLimit; rl.RequestsPerSecond > 0 {
+		f, err := typedFilter(localRateLimitFilter, &localrlv3.LocalRateLimit{
+			StatPrefix: "aegis_rate_limit",
+			TokenBucket: &typev3.TokenBucket{
+				MaxTokens:     uint32(max(rl.Burst, rl.RequestsPerSecond)),
+				TokensPerFill: wrapperspb.UInt32(uint32(rl.RequestsPerSecond)),
+				FillInterval:  durationpb.New(time.Second),
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+
+	var terminal *listenerv3.Filter
+	if listenerMode == ListenerModeHTTP {
+		terminal, err = buildHTTPConnectionManager(p)
+	} else {
+		terminal, err = buildTCPProxy(p, TCPClusterName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	l := &listenerv3.Listener{
+		Name:         TCPListenerName,
+		Address:      socketAddress(host, port, corev3.SocketAddress_TCP),
+		FilterChains: []*listenerv3.FilterChain{{Filters: append(slices.Clone(filters), terminal)}},
+	}
+	if listenerMode == ListenerModeHTTP {
+		return l, nil
+	}
+
+	// Geo routes match on the client's address instead, and their
+	// networks never overlap
+	if len(p.GeoRoutes) > 0 {
+		for _, r := range p.GeoRoutes {
+			tp, err := buildTCPProxy(p, PoolClusterPrefix+r.Pool)
+			if err != nil {
+				return nil, err
+			}
+			ranges := make([]*corev3.CidrRange, len(r.Networks))
+			for i, n := range r.Networks {
+				ranges[i] = &corev3.CidrRange{
+					AddressPrefix: n.Addr().String(),
+					PrefixLen:     wrapperspb.UInt32(uint32(n.Bits())),
+				}
+			}
+			l.FilterChains = append(l.FilterChains, &listenerv3.FilterChain{
+				FilterChainMatch: &listenerv3.FilterChainMatch{SourcePrefixRanges: ranges},
+				Filters:          append(slices.Clone(filters), tp),
+			})
+		}
+		return l, nil
+	}
+	if len(p.SNIRoutes) == 0 {
+		return l, nil
+	}
+
+	// Each SNI route gets a filter chain of its own; Envoy picks the one
+	// naming the ClientHello's server most specifically, and the first
+	// chain takes the rest. The limits above are kept per chain rather
+	// than across the listener.
+	inspector, err := anypb.New(&tlsinspectorv3.TlsInspector{})
+	if err != nil {
+		return nil, err
+	}
+	l.ListenerFilters = []*listenerv3.ListenerFilter{{
+		Name:       wellknown.TLSInspector,
+		ConfigType: &listenerv3.ListenerFilter_TypedConfig{TypedConfig: inspector},
+	}}
+	for _, r := range p.SNIRoutes {
+		tp, err := buildTCPProxy(p, PoolClusterPrefix+r.Pool)
+		if err != nil {
+			return nil, err
+		}
+		l.FilterChains = append(l.FilterChains, &listenerv3.FilterChain{
+			FilterChainMatch: &listenerv3.FilterChainMatch{ServerNames: r.ServerNames},
+			Filters:          append(slices.Clone(filters), tp),
+		})
+	}
+	return l, nil
+}
+
+func buildTCPProxy(p config.ProxyConfig, cluster string) (*listenerv3.Filter, error) {
+	tp := &tcpproxyv3.TcpProxy{
+		StatPrefix:       "aegis_tcp",
+		ClusterSpecifier: &tcpproxyv3.TcpProxy_Cluster{Cluster: cluster},
+	}
+	if d := p.Traffic.Timeout.Idle; d > 0 {
+		tp.IdleTimeout = durationpb.New(d)
+	}
+	if p.LoadBalancing.SessionAffinity || p.LoadBalancing.Algorithm == config.AlgorithmConsistentHash {
+		tp.HashPolicy = []*typev3.HashPolicy{{
+			PolicySpecifier: &typev3.HashPolicy_SourceIp_{SourceIp: &typev3.HashPolicy_SourceIp{}},
+		}}
+	}
+	return typedFilter(wellknown.TCPProxy, tp)
+}
+
+func buildHTTPConnectionManager(p config.ProxyConfig) (*listenerv3.Filter, error) {
+	router, err := anypb.New(&routerv3.Router{})
+	if err != nil {
+		return nil, err
+	}
+	hcm := &hcmv3.HttpConnectionManager{
+		StatPrefix: "aegis_http",
+		RouteSpecifier: &hcmv3.HttpConnectionManager_Rds{
+			Rds: &hcmv3.Rds{
+				RouteConfigName: RouteConfigName,
+				ConfigSource: &corev3.ConfigSource{
+					ResourceApiVersion:    corev3.ApiVersion_V3,
+					ConfigSourceSpecifier: &corev3.ConfigSource_Ads{Ads: &corev3.AggregatedConfigSource{}},
+				},
+			},
+		},
+		HttpFilters: []*hcmv3.HttpFilter{{
+			Name:       wellknown.Router,
+			ConfigType: &hcmv3.HttpFilter_TypedConfig{TypedConfig: router},
+		}},
+	}
+	if p.HasRouteRateLimits() {
+		limiter, err := anypb.New(&httplocalrlv3.LocalRateLimit{StatPrefix: "aegis_route_rate_limit"})
+		if err != nil {
+			return nil, err
+		}
+		hcm.HttpFilters = slices.Insert(hcm.HttpFilters, 0, &hcmv3.HttpFilter{
+			Name:       httpLocalRateLimitFilter,
+			ConfigType: &hcmv3.HttpFilter_TypedConfig{TypedConfig: limiter},
+		})
+	}
+	if sc := p.LoadBalancing.StickyCookie; sc.Enabled() {
+		session, err := buildStickySession(sc)
+		if err != nil {
+			return nil, err
+		}
+		// Last before the router, after any rate limit
+		hcm.HttpFilters = slices.Insert(hcm.HttpFilters, len(hcm.HttpFilters)-1, &hcmv3.HttpFilter{
+			Name:       statefulSessionFilter,
+			ConfigType: &hcmv3.HttpFilter_TypedConfig{TypedConfig: session},
+		})
+	}
+	if d := p.Traffic.Timeout.Idle; d > 0 {
+		hcm.CommonHttpProtocolOptions = &corev3.HttpProtocolOptions{IdleTimeout: durationpb.New(d)}
+	}
+	return typedFilter(wellknown.HTTPConnectionManager, hcm)
+}
+
+// buildStickySession keeps requests on the backend named by their sticky
+// cookie, falling back to the cluster's load balancing when it's missing,
+// unhealthy or gone; Envoy encodes the backend's address in the value.
+func buildStickySession(sc config.StickyCookieConfig) (*anypb.Any, error) {
+	cookie := &httpv3.Cookie{Name: sc.Name, Ttl: durationpb.New(sc.TTL), Path: sc.Path}
+	if sc.Secure {
+		cookie.Attributes = append(cookie.Attributes, &httpv3.CookieAttribute{Name: "Secure"})
+	}
+	if sc.HTTPOnly {
+		cookie.Attributes = append(cookie.Attributes, &httpv3.CookieAttribute{Name: "HttpOnly"})
+	}
+	state, err := anypb.New(&cookiesessionv3.CookieBasedSessionState{Cookie: cookie})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal sticky cookie: %w", err)
+	}
+	return anypb.New(&statefulsessionv3.StatefulSession{
+		SessionState: &corev3.TypedExtensionConfig{Name: cookieSessionState, TypedConfig: state},
+	})
+}
+
+// buildRouteConfig routes requests matching a header rule to its pool's
+// cluster, in rule order, and everything else to the TCP cluster:
+// proxy.http_routes with their retry, hedging, timeout and rate-limit
+// settings first, longest prefix first, then a catch-all. Unlike the TCP
+// proxy, an HTTP route can hash on the configured header or cookie.
+func buildRouteConfig(p config.ProxyConfig) (*routev3.RouteConfiguration, error) {
+	var hashPolicy []*routev3.RouteAction_HashPolicy
+	if lb := p.LoadBalancing; lb.SessionAffinity || lb.Algorithm == config.AlgorithmConsistentHash {
+		switch lb.Hash.Key {
+		case config.HashKeyHeader:
+			hashPolicy = append(hashPolicy, &routev3.RouteAction_HashPolicy{
+				PolicySpecifier: &routev3.RouteAction_HashPolicy_Header_{Header: &routev3.RouteAction_HashPolicy_Header{HeaderName: lb.Hash.Name}},
+				Terminal:        true,
+			})
+		case config.HashKeyCookie:
+			hashPolicy = append(hashPolicy, &routev3.RouteAction_HashPolicy{
+				PolicySpecifier: &routev3.RouteAction_HashPolicy_Cookie_{Cookie: &routev3.RouteAction_HashPolicy_Cookie{Name: lb.Hash.Name}},
+				Terminal:        true,
+			})
+		}
+		// Requests without the header or cookie, like the data plane, fall
+		// back to the source IP
+		hashPolicy = append(hashPolicy, &routev3.RouteAction_HashPolicy{
+			PolicySpecifier: &routev3.RouteAction_HashPolicy_ConnectionProperties_{
+				ConnectionProperties: &routev3.RouteAction_HashPolicy_ConnectionProperties{SourceIp: true},
+			},
+		})
+	}
+	route := func(name, prefix string, t config.RouteTraffic) (*routev3.Route, error) {
+		action := &routev3.RouteAction{
+			ClusterSpecifier: &routev3.RouteAction_Cluster{Cluster: TCPClusterName},
+			HashPolicy:       hashPolicy,
+		}
+		var err error
+		if action.RetryPolicy, err = buildRetryPolicy(t); err != nil {
+			return nil, err
+		}
+		if t.Hedged() {
+			action.HedgePolicy = &routev3.HedgePolicy{HedgeOnPerTryTimeout: true}
+		}
+		if t.RequestTimeout > 0 {
+			action.Timeout = durationpb.New(t.RequestTimeout)
+		}
+		if t.ReadTimeout > 0 {
+			action.IdleTimeout = durationpb.New(t.ReadTimeout)
+		}
+		r := &routev3.Route{
+			Name:   name,
+			Match:  &routev3.RouteMatch{PathSpecifier: &routev3.RouteMatch_Prefix{Prefix: prefix}},
+			Action: &routev3.Route_Route{Route: action},
+		}
+		if t.RateLimit.RequestsPerSecond > 0 {
+			limit, err := buildRouteRateLimit(t.RateLimit)
+			if err != nil {
+				return nil, err
+			}
+			r.TypedPerFilterConfig = map[string]*anypb.Any{httpLocalRateLimitFilter: limit}
+		}
+		return r, nil
+	}
+
+	// Envoy takes the first route that matches. Routes other than
+	// proxy.http_routes get proxy.traffic's settings.
+	defaults := p.RouteTraffic(config.HTTPRoute{})
+	httpRoutes := append([]config.HTTPRoute(nil), p.HTTPRoutes...)
+	sort.SliceStable(httpRoutes, func(i, j int) bool { return len(httpRoutes[i].Prefix) > len(httpRoutes[j].Prefix) })
+	var routes []*routev3.Route
+	for _, rule := range p.HeaderRules {
+		r, err := route(rule.Name, "/", defaults)
+		if err != nil {
+			return nil, err
+		}
+		r.GetRoute().ClusterSpecifier = &routev3.RouteAction_Cluster{Cluster: PoolClusterPrefix + rule.Pool}
+		for _, m := range rule.Match {
+			r.Match.Headers = append(r.Match.Headers, headerMatcher(m))
+		}
+		routes = append(routes, r)
+	}
+	catchAll := false
+	for _, hr := range httpRoutes {
+		r, err := route(hr.Name, hr.Prefix, p.RouteTraffic(hr))
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, r)
+		catchAll = catchAll || hr.Prefix == "/"
+	}
+	if !catchAll {
+		r, err := route("", "/", defaults)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, r)
+	}
+
+	return &routev3.RouteConfiguration{
+		Name: RouteConfigName,
+		VirtualHosts: []*routev3.VirtualHost{{
+			Name:    "aegis",
+			Domains: []string{"*"},
+			Routes:  routes,
+		}},
+	}, nil
+}
+
+// headerMatcher matches m's value in full, as the data plane does; Envoy
+// compares header names case-insensitively already.
+func headerMatcher(m config.HeaderMatch) *routev3.HeaderMatcher {
+	match := &matcherv3.StringMatcher{MatchPattern: &matcherv3.StringMatcher_Exact{Exact: m.Exact}}
+	if m.Regex != "" {
+		match.MatchPattern = &matcherv3.StringMatcher_SafeRegex{SafeRegex: &matcherv3.RegexMatcher{Regex: m.Regex}}
+	}
+	return &routev3.HeaderMatcher{
+		Name:                 m.Header,
+		HeaderMatchSpecifier: &routev3.HeaderMatcher_StringMatch{StringMatch: match},
+	}
+}
+
+// buildRetryPolicy returns nil for a route that doesn't retry. A hedged
+// route's delay is its per-try timeout, which Envoy then treats as the
+// point to send another request rather than to give up on the first.
+// Retries and hedges skip the backends already tried, so a hedge goes to
+// a second backend.
+func buildRetryPolicy(t config.RouteTraffic) (*routev3.RetryPolicy, error) {
+	if t.Retry.Attempts < 2 {
+		return nil, nil
+	}
+	previousHosts, err := anypb.New(&previoushostsv3.PreviousHostsPredicate{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal retry host predicate: %w", err)
+	}
+	policy := &routev3.RetryPolicy{
+		RetryOn:    strings.Join(t.Retry.RetryOn, ","),
+		NumRetries: wrapperspb.UInt32(uint32(t.Retry.Attempts - 1)),
+		RetryHostPredicate: []*routev3.RetryPolicy_RetryHostPredicate{{
+			Name:       previousHostsPredicate,
+			ConfigType: &routev3.RetryPolicy_RetryHostPredicate_TypedConfig{TypedConfig: previousHosts},
+		}},
+		HostSelectionRetryMaxAttempts: 3,
+	}
+	timeout := t.Retry.PerTryTimeout
+	if t.Hedged() {
+		timeout = t.Hedge.Delay
+	}
+	if timeout > 0 {
+		policy.PerTryTimeout = durationpb.New(timeout)
+	}
+	return policy, nil
+}
+
+// buildRouteRateLimit is a route's own token bucket for the HTTP local
+// rate limit filter, enforced on all of its requests; Envoy answers those
+// over the limit with 429.
+func buildRouteRateLimit(rl config.RateLimitConfig) (*anypb.Any, error) {
+	all := &corev3.RuntimeFractionalPercent{
+		DefaultValue: &typev3.FractionalPercent{Numerator: 100, Denominator: typev3.FractionalPercent_HUNDRED},
+	}
+	limit, err := anypb.New(&httplocalrlv3.LocalRateLimit{
+		StatPrefix: "aegis_route_rate_limit",
+		TokenBucket: &typev3.TokenBucket{
+			MaxTokens:     uint32(rl.Burst),
+			TokensPerFill: wrapperspb.UInt32(uint32(rl.RequestsPerSecond)),
+			FillInterval:  durationpb.New(time.Second),
+		},
+		FilterEnabled:  all,
+		FilterEnforced: all,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal route rate limit: %w", err)
+	}
+	return limit, nil
+}
+
+func buildUDPListener(p config.ProxyConfig) (*listenerv3.Listener, error) {
+	host, port, err := splitHostPort(p.Listen.UDP)
+	if err != nil {
+		return nil, fmt.Errorf("proxy.listen.udp: %w", err)
+	}
+	up := &udpproxyv3.UdpProxyConfig{
+		StatPrefix:     "aegis_udp",
+		RouteSpecifier: &udpproxyv3.UdpProxyConfig_Cluster{Cluster: UDPClusterName},
+	}
+	if d := p.Traffic.Timeout.Idle; d > 0 {
+		up.IdleTimeout = durationpb.New(d)
+	}
+	// Envoy already keeps a session per client socket on one backend;
+	// source_ip also hashes every socket of a host to the same one
+	if a := p.LoadBalancing.UDPAffinity; a.Enabled() {
+		up.IdleTimeout = durationpb.New(a.IdleTimeout)
+		if a.Mode == config.UDPAffinitySourceIP {
+			up.HashPolicies = []*udpproxyv3.UdpProxyConfig_HashPolicy{{
+				PolicySpecifier: &udpproxyv3.UdpProxyConfig_HashPolicy_SourceIp{SourceIp: true},
+			}}
+		}
+	}
+	typed, err := anypb.New(up)
+	if err != nil {
+		return nil, err
+	}
+	return &listenerv3.Listener{
+		Name:    UDPListenerName,
+		Address: socketAddress(host, port, corev3.SocketAddress_UDP),
+		ListenerFilters: []*listenerv3.ListenerFilter{{
+			Name:       udpProxyFilter,
+			ConfigType: &listenerv3.ListenerFilter_TypedConfig{TypedConfig: typed},
+		}},
+	}, nil
+}
+
+func typedFilter(name string, msg proto.Message) (*listenerv3.Filter, error) {
+	typed, err := anypb.New(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s config: %w", name, err)
+	}
+	return &listenerv3.Filter{
+		Name:       name,
+		ConfigType: &listenerv3.Filter_TypedConfig{TypedConfig: typed},
+	}, nil
+}
+
+func socketAddress(host string, port uint32, protocol corev3.SocketAddress_Protocol) *corev3.Address {
+	return &corev3.Address{
+		Address: &corev3.Address_SocketAddress{SocketAddress: &corev3.SocketAddress{
+			Protocol:      protocol,
+			Address:       host,
+			PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: port},
+		}},
+	}
+}
+
+func splitHostPort(addr string) (string, uint32, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	if host == "" {
+		host = "0.0.0.0"
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in %q", addr)
+	}
+	return host, uint32(port), nil
+}
+
+func nonZero(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
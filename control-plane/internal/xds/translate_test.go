@@ -0,0 +1,553 @@
+package xds
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	httplocalrlv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/local_ratelimit/v3"
+	statefulsessionv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/stateful_session/v3"
+	connlimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/connection_limit/v3"
+	hcmv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	tcpproxyv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	udpproxyv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/udp/udp_proxy/v3"
+	cookiesessionv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/http/stateful_session/cookie/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+	"github.com/lazzerex/aegis/control-plane/internal/config"
+	"go.uber.org/zap"
+)
+
+func testConfig() *config.Config {
+	return &config.Config{
+		Proxy: config.ProxyConfig{
+			Listen: config.ListenConfig{TCP: "0.0.0.0:8080", UDP: "0.0.0.0:8081"},
+			Backends: []config.Backend{
+				{Address: "10.0.0.1:3000", Weight: 100},
+				{Address: "10.0.0.2:3000", Weight: 50},
+				{Address: "10.0.0.3:3000", Weight: 0},
+			},
+			UdpBackends: []config.Backend{
+				{Address: "10.0.1.1:5000", Weight: 100},
+			},
+			LoadBalancing: config.LoadBalancingConfig{Algorithm: "least_connections"},
+			Traffic: config.TrafficConfig{
+				RateLimit: config.RateLimitConfig{RequestsPerSecond: 1000, Burst: 100},
+				Timeout:   config.TimeoutConfig{Connect: 5 * time.Second, Idle: 60 * time.Second},
+			},
+			CircuitBreaker: config.CircuitBreakerConfig{ErrorThreshold: 5, Timeout: 30 * time.Second},
+		},
+	}
+}
+
+func TestTranslate_ProducesConsistentSnapshot(t *testing.T) {
+	for _, mode := range []string{ListenerModeTCP, ListenerModeHTTP} {
+		resources, err := Translate(testConfig(), mode, nil, false)
+		if err != nil {
+			t.Fatalf("%s: Translate: %v", mode, err)
+		}
+		snap, err := cache.NewSnapshot("1", resources)
+		if err != nil {
+			t.Fatalf("%s: NewSnapshot: %v", mode, err)
+		}
+		if err := snap.Consistent(); err != nil {
+			t.Errorf("%s: snapshot inconsistent: %v", mode, err)
+		}
+		if got := len(resources[resource.ListenerType]); got != 2 {
+			t.Errorf("%s: listeners: got %d, want 2 (tcp + udp)", mode, got)
+		}
+		if _, ok := resources[resource.RouteType]; ok != (mode == ListenerModeHTTP) {
+			t.Errorf("%s: route config present = %v", mode, ok)
+		}
+	}
+}
+
+func TestTranslate_MapsWeightsHealthAndPolicy(t *testing.T) {
+	health := map[string]bool{"10.0.0.2:3000": false}
+	resources, err := Translate(testConfig(), ListenerModeTCP, health, false)
+	if err != nil {
+		t.Fatalf("Translate: %v", err)
+	}
+
+	cluster := resources[resource.ClusterType][0].(*clusterv3.Cluster)
+	if cluster.LbPolicy != clusterv3.Cluster_LEAST_REQUEST {
+		t.Errorf("lb policy: got %v, want LEAST_REQUEST", cluster.LbPolicy)
+	}
+	if cluster.OutlierDetection.GetConsecutiveLocalOriginFailure().GetValue() != 5 {
+		t.Error("circuit breaker threshold not mapped to outlier detection")
+	}
+
+	cla := resources[resource.EndpointType][0].(*endpointv3.ClusterLoadAssignment)
+	eps := cla.Endpoints[0].LbEndpoints
+	if len(eps) != 2 {
+		t.Fatalf("endpoints: got %d, want 2 (weight-0 backend omitted)", len(eps))
+	}
+	if eps[0].HealthStatus != corev3.HealthStatus_HEALTHY {
+		t.Errorf("10.0.0.1 health: got %v, want HEALTHY", eps[0].HealthStatus)
+	}
+	if eps[1].HealthStatus != corev3.HealthStatus_UNHEALTHY {
+		t.Errorf("10.0.0.2 health: got %v, want UNHEALTHY", eps[1].HealthStatus)
+	}
+	if eps[1].LoadBalancingWeight.GetValue() != 50 {
+		t.Errorf("10.0.0.2 weight: got %d, want 50", eps[1].LoadBalancingWeight.GetValue())
+	}
+}
+
+func TestTranslate_PrioritiesBecomeLocalities(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxy.Backends = []config.Backend{
+		{Address: "10.0.0.1:3000", Weight: 100},
+		{Address: "10.0.0.2:3000", Weight: 100, Priority: 5},
+		{Address: "10.0.0.4:3000", Weight: 100, Backup: true},
+		{Address: "10.0.0.3:3000", Weight: 100, Priority: 2},
+	}
+	resources, err := Translate(cfg, ListenerModeTCP, nil, false)
+	if err != nil {
+		t.Fatalf("Translate: %v", err)
+	}
+
+	// Envoy wants 0, 1, 2, 3: priorities 2 and 5 are renumbered, and the
+	// backup goes last
+	cla := resources[resource.EndpointType][0].(*endpointv3.ClusterLoadAssignment)
+	want := []string{"10.0.0.1", "10.0.0.3", "10.0.0.2", "10.0.0.4"}
+	if len(cla.Endpoints) != len(want) {
+		t.Fatalf("localities: got %d, want %d", len(cla.Endpoints), len(want))
+	}
+	for i, loc := range cla.Endpoints {
+		addr := loc.LbEndpoints[0].GetEndpoint().GetAddress().GetSocketAddress().GetAddress()
+		if loc.Priority != uint32(i) || addr != want[i] {
+			t.Errorf("locality %d: got priority %d with %s, want %s", i, loc.Priority, addr, want[i])
+		}
+	}
+}
+
+func TestTranslate_ZonesShareTheirTiersPriority(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxy.LoadBalancing.Locality = config.LocalityConfig{Policy: config.LocalityPreferLocal, SpilloverPercent: 10}
+	cfg.Proxy.Backends = []config.Backend{
+		{Address: "10.0.0.1:3000", Weight: 100, Region: "eu-west-1", Zone: "eu-west-1b"},
+		{Address: "10.0.0.2:3000", Weight: 100, Region: "eu-west-1", Zone: "eu-west-1a"},
+		{Address: "10.0.0.3:3000", Weight: 100, Region: "eu-west-1", Zone: "eu-west-1a", Priority: 1},
+	}
+	resources, err := Translate(cfg, ListenerModeTCP, nil, false)
+	if err != nil {
+		t.Fatalf("Translate: %v", err)
+	}
+
+	cla := resources[resource.EndpointType][0].(*endpointv3.ClusterLoadAssignment)
+	want := []struct {
+		zone     string
+		priority uint32
+	}{{"eu-west-1a", 0}, {"eu-west-1b", 0}, {"eu-west-1a", 1}}
+	if len(cla.Endpoints) != len(want) {
+		t.Fatalf("localities: got %d, want %d", len(cla.Endpoints), len(want))
+	}
+	for i, loc := range cla.Endpoints {
+		if loc.GetLocality().GetZone() != want[i].zone || loc.GetLocality().GetRegion() != "eu-west-1" || loc.Priority != want[i].priority {
+			t.Errorf("locality %d: got %v at priority %d, want %s at %d", i, loc.Locality, loc.Priority, want[i].zone, want[i].priority)
+		}
+	}
+	cluster := resources[resource.ClusterType][0].(*clusterv3.Cluster)
+	if got := cluster.GetCommonLbConfig().GetZoneAwareLbConfig().GetRoutingEnabled().GetValue(); got != 90 {
+		t.Errorf("zone aware routing_enabled: got %v, want 90", got)
+	}
+}
+
+func TestTranslate_DrainedOmitsListeners(t *testing.T) {
+	resources, err := Translate(testConfig(), ListenerModeHTTP, nil, true)
+	if err != nil {
+		t.Fatalf("Translate: %v", err)
+	}
+	if len(resources[resource.ListenerType]) != 0 || len(resources[resource.RouteType]) != 0 {
+		t.Error("drained snapshot should carry no listeners or routes")
+	}
+	snap, _ := cache.NewSnapshot("1", resources)
+	if err := snap.Consistent(); err != nil {
+		t.Errorf("drained snapshot inconsistent: %v", err)
+	}
+}
+
+func TestTranslate_ConnectionLimitFilterFirst(t *testing.T) {
+	cfg := testConfig()
+	l, err := buildTCPListener(cfg.Proxy, ListenerModeTCP)
+	if err != nil {
+		t.Fatalf("buildTCPListener: %v", err)
+	}
+	if f := l.FilterChains[0].Filters[0]; f.Name != localRateLimitFilter {
+		t.Errorf("first filter without a limit: got %s", f.Name)
+	}
+
+	cfg.Proxy.Traffic.ConnectionLimit = config.ConnectionLimitConfig{Max: 500, Overflow: config.OverflowReject}
+	l, err = buildTCPListener(cfg.Proxy, ListenerModeTCP)
+	if err != nil {
+		t.Fatalf("buildTCPListener: %v", err)
+	}
+	f := l.FilterChains[0].Filters[0]
+	var limit connlimitv3.ConnectionLimit
+	if f.Name != connectionLimitFilter || f.GetTypedConfig().UnmarshalTo(&limit) != nil {
+		t.Fatalf("first filter: got %s", f.Name)
+	}
+	if limit.MaxConnections.GetValue() != 500 || limit.Delay != nil {
+		t.Errorf("connection limit: got %v", &limit)
+	}
+}
+
+func TestServer_ReloadBumpsSnapshotVersion(t *testing.T) {
+	s := NewServer(config.XDSConfig{ListenerMode: ListenerModeTCP}, zap.NewNop())
+
+	if err := s.ReloadBackendsWithHealth(nil, nil); err == nil {
+		t.Fatal("expected error reloading backends before any config was pushed")
+	}
+	if err := s.UpdateConfig(testConfig()); err != nil {
+		t.Fatalf("UpdateConfig: %v", err)
+	}
+	if err := s.ReloadBackendsWithHealth([]config.Backend{{Address: "10.0.0.9:3000", Weight: 10}}, nil); err != nil {
+		t.Fatalf("ReloadBackendsWithHealth: %v", err)
+	}
+
+	snap, err := s.cache.GetSnapshot(snapshotKey)
+	if err != nil {
+		t.Fatalf("GetSnapshot: %v", err)
+	}
+	if v := snap.GetVersion(resource.EndpointType); v != "2" {
+		t.Errorf("snapshot version: got %q, want 2", v)
+	}
+	cla := snap.GetResources(resource.EndpointType)[TCPClusterName].(*endpointv3.ClusterLoadAssignment)
+	if n := len(cla.Endpoints[0].LbEndpoints); n != 1 {
+		t.Errorf("endpoints after reload: got %d, want 1", n)
+	}
+}
+
+func TestLbPolicy_MapsEveryAlgorithm(t *testing.T) {
+	for alg, want := range map[string]clusterv3.Cluster_LbPolicy{
+		config.AlgorithmRoundRobin:         clusterv3.Cluster_ROUND_ROBIN,
+		config.AlgorithmWeightedRoundRobin: clusterv3.Cluster_ROUND_ROBIN,
+		config.AlgorithmLeastConnections:   clusterv3.Cluster_LEAST_REQUEST,
+		config.AlgorithmConsistentHash:     clusterv3.Cluster_RING_HASH,
+		config.AlgorithmRandom:             clusterv3.Cluster_RANDOM,
+		config.AlgorithmLatencyEWMA:        clusterv3.Cluster_LEAST_REQUEST,
+	} {
+		if got := lbPolicy(config.LoadBalancingConfig{Algorithm: alg}); got != want {
+			t.Errorf("%s: got %v, want %v", alg, got, want)
+		}
+	}
+}
+
+func TestTranslate_ConsistentHashKeyAndRingSize(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxy.LoadBalancing = config.LoadBalancingConfig{
+		Algorithm: config.AlgorithmConsistentHash,
+		Hash:      config.HashConfig{Key: config.HashKeyCookie, Name: "session", VirtualNodes: 100},
+	}
+	resources, err := Translate(cfg, ListenerModeHTTP, nil, false)
+	if err != nil {
+		t.Fatalf("Translate: %v", err)
+	}
+
+	cluster := resources[resource.ClusterType][0].(*clusterv3.Cluster)
+	if got := cluster.GetRingHashLbConfig().GetMinimumRingSize().GetValue(); got != 300 {
+		t.Errorf("ring size: got %d, want 100 per backend", got)
+	}
+	route := resources[resource.RouteType][0].(*routev3.RouteConfiguration).VirtualHosts[0].Routes[0].GetRoute()
+	if len(route.HashPolicy) != 2 || route.HashPolicy[0].GetCookie().GetName() != "session" || !route.HashPolicy[0].Terminal ||
+		!route.HashPolicy[1].GetConnectionProperties().GetSourceIp() {
+		t.Errorf("hash policy: got %v", route.HashPolicy)
+	}
+}
+
+func TestTranslate_HTTPRoutesRetryAndHedge(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxy.HTTPRoutes = []config.HTTPRoute{
+		{Name: "api", Prefix: "/api/", Retry: config.RouteRetryPolicy{Attempts: 3, PerTryTimeout: time.Second, RetryOn: []string{"5xx"}}},
+		{Name: "search", Prefix: "/api/search/",
+			Retry: config.RouteRetryPolicy{Attempts: 2, RetryOn: config.DefaultRetryOn, Idempotent: true},
+			Hedge: config.HedgePolicy{Delay: 50 * time.Millisecond}},
+	}
+	resources, err := Translate(cfg, ListenerModeHTTP, nil, false)
+	if err != nil {
+		t.Fatalf("Translate: %v", err)
+	}
+	routes := resources[resource.RouteType][0].(*routev3.RouteConfiguration).VirtualHosts[0].Routes
+	if len(routes) != 3 || routes[0].Name != "search" || routes[1].Name != "api" || routes[2].GetMatch().GetPrefix() != "/" {
+		t.Fatalf("routes: got %v", routes)
+	}
+
+	search := routes[0].GetRoute()
+	if !search.GetHedgePolicy().GetHedgeOnPerTryTimeout() || search.RetryPolicy.GetNumRetries().GetValue() != 1 ||
+		search.RetryPolicy.GetPerTryTimeout().AsDuration() != 50*time.Millisecond || search.RetryPolicy.RetryOn != "5xx,reset,connect-failure" {
+		t.Errorf("hedged route: got %v", search)
+	}
+	if p := search.RetryPolicy.RetryHostPredicate; len(p) != 1 || p[0].Name != previousHostsPredicate {
+		t.Errorf("host predicate: got %v", p)
+	}
+	api := routes[1].GetRoute()
+	if api.HedgePolicy != nil || api.RetryPolicy.GetNumRetries().GetValue() != 2 || api.RetryPolicy.GetPerTryTimeout().AsDuration() != time.Second {
+		t.Errorf("retried route: got %v", api)
+	}
+	if routes[2].GetRoute().RetryPolicy != nil {
+		t.Errorf("catch-all retries: got %v", routes[2].GetRoute().RetryPolicy)
+	}
+}
+
+func TestTranslate_HTTPRouteOverrides(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxy.Traffic.Timeout.Read = 30 * time.Second
+	cfg.Proxy.Traffic.Retry = config.RouteRetryPolicy{Attempts: 2, PerTryTimeout: time.Second, RetryOn: []string{"5xx"}}
+	cfg.Proxy.HTTPRoutes = []config.HTTPRoute{{
+		Name:      "upload",
+		Prefix:    "/upload/",
+		Retry:     config.RouteRetryPolicy{Attempts: 1},
+		Timeout:   config.RouteTimeout{Request: time.Minute, Read: 5 * time.Second},
+		RateLimit: config.RateLimitConfig{RequestsPerSecond: 10},
+	}}
+	resources, err := Translate(cfg, ListenerModeHTTP, nil, false)
+	if err != nil {
+		t.Fatalf("Translate: %v", err)
+	}
+	routes := resources[resource.RouteType][0].(*routev3.RouteConfiguration).VirtualHosts[0].Routes
+	if len(routes) != 2 {
+		t.Fatalf("routes: got %v", routes)
+	}
+
+	upload := routes[0]
+	if a := upload.GetRoute(); a.RetryPolicy != nil || a.Timeout.AsDuration() != time.Minute || a.IdleTimeout.AsDuration() != 5*time.Second {
+		t.Errorf("overridden route: got %v", a)
+	}
+	var limit httplocalrlv3.LocalRateLimit
+	if err := upload.TypedPerFilterConfig[httpLocalRateLimitFilter].UnmarshalTo(&limit); err != nil {
+		t.Fatalf("route rate limit: %v", err)
+	}
+	if b := limit.TokenBucket; b.MaxTokens != 10 || b.TokensPerFill.GetValue() != 10 || limit.FilterEnforced.GetDefaultValue().GetNumerator() != 100 {
+		t.Errorf("route rate limit: got %v", &limit)
+	}
+	// The catch-all keeps proxy.traffic's settings, and no rate limit
+	catchAll := routes[1]
+	if a := catchAll.GetRoute(); a.RetryPolicy.GetNumRetries().GetValue() != 1 || a.RetryPolicy.RetryOn != "5xx" ||
+		a.RetryPolicy.GetPerTryTimeout().AsDuration() != time.Second || a.IdleTimeout.AsDuration() != 30*time.Second || a.Timeout != nil {
+		t.Errorf("catch-all: got %v", a)
+	}
+	if catchAll.TypedPerFilterConfig != nil {
+		t.Errorf("catch-all rate limit: got %v", catchAll.TypedPerFilterConfig)
+	}
+
+	// The limiter goes ahead of the router
+	var hcm hcmv3.HttpConnectionManager
+	l := resources[resource.ListenerType][0].(*listenerv3.Listener)
+	chain := l.FilterChains[0].Filters
+	if err := chain[len(chain)-1].GetTypedConfig().UnmarshalTo(&hcm); err != nil {
+		t.Fatalf("http connection manager: %v", err)
+	}
+	if f := hcm.HttpFilters; len(f) != 2 || f[0].Name != httpLocalRateLimitFilter || f[1].Name != wellknown.Router {
+		t.Errorf("http filters: got %v", f)
+	}
+}
+
+func TestTranslate_StickyCookie(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxy.LoadBalancing.StickyCookie = config.StickyCookieConfig{Name: "aegis_backend", TTL: time.Hour, Path: "/app", Secure: true}
+	resources, err := Translate(cfg, ListenerModeHTTP, nil, false)
+	if err != nil {
+		t.Fatalf("Translate: %v", err)
+	}
+	var hcm hcmv3.HttpConnectionManager
+	chain := resources[resource.ListenerType][0].(*listenerv3.Listener).FilterChains[0].Filters
+	if err := chain[len(chain)-1].GetTypedConfig().UnmarshalTo(&hcm); err != nil {
+		t.Fatalf("http connection manager: %v", err)
+	}
+	if f := hcm.HttpFilters; len(f) != 2 || f[0].Name != statefulSessionFilter || f[1].Name != wellknown.Router {
+		t.Fatalf("http filters: got %v", f)
+	}
+	var session statefulsessionv3.StatefulSession
+	if err := hcm.HttpFilters[0].GetTypedConfig().UnmarshalTo(&session); err != nil {
+		t.Fatalf("stateful session: %v", err)
+	}
+	var state cookiesessionv3.CookieBasedSessionState
+	if err := session.SessionState.GetTypedConfig().UnmarshalTo(&state); err != nil {
+		t.Fatalf("session state: %v", err)
+	}
+	c := state.Cookie
+	if c.Name != "aegis_backend" || c.Ttl.AsDuration() != time.Hour || c.Path != "/app" || len(c.Attributes) != 1 || c.Attributes[0].Name != "Secure" {
+		t.Errorf("cookie: got %v", c)
+	}
+}
+
+func TestTranslate_HeaderRules(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxy.Backends[1].Pool = "staging"
+	cfg.Proxy.HeaderRules = []config.HeaderRule{{
+		Name:  "debug",
+		Match: []config.HeaderMatch{{Header: "X-Debug", Exact: "1"}, {Header: "User-Agent", Regex: "internal-.*"}},
+		Pool:  "staging",
+	}}
+	cfg.Proxy.HTTPRoutes = []config.HTTPRoute{{Name: "api", Prefix: "/api/"}}
+	resources, err := Translate(cfg, ListenerModeHTTP, map[string]bool{"10.0.0.2:3000": false}, false)
+	if err != nil {
+		t.Fatalf("Translate: %v", err)
+	}
+	snap, err := cache.NewSnapshot("1", resources)
+	if err != nil {
+		t.Fatalf("NewSnapshot: %v", err)
+	}
+	if err := snap.Consistent(); err != nil {
+		t.Errorf("snapshot inconsistent: %v", err)
+	}
+
+	// The staging backend only serves the rule's cluster
+	var staging *endpointv3.ClusterLoadAssignment
+	for _, r := range resources[resource.EndpointType] {
+		cla := r.(*endpointv3.ClusterLoadAssignment)
+		for _, l := range cla.Endpoints {
+			for _, e := range l.LbEndpoints {
+				addr := e.GetEndpoint().GetAddress().GetSocketAddress()
+				if addr.GetAddress() == "10.0.0.2" && cla.ClusterName != PoolClusterPrefix+"staging" {
+					t.Errorf("staging backend in %s", cla.ClusterName)
+				}
+			}
+		}
+		if cla.ClusterName == PoolClusterPrefix+"staging" {
+			staging = cla
+		}
+	}
+	if staging == nil || len(staging.Endpoints[0].LbEndpoints) != 1 || staging.Endpoints[0].LbEndpoints[0].HealthStatus != corev3.HealthStatus_UNHEALTHY {
+		t.Fatalf("staging endpoints: got %v", staging)
+	}
+
+	routes := resources[resource.RouteType][0].(*routev3.RouteConfiguration).VirtualHosts[0].Routes
+	if len(routes) != 3 || routes[0].Name != "debug" || routes[1].Name != "api" {
+		t.Fatalf("routes: got %v", routes)
+	}
+	rule := routes[0]
+	if rule.GetRoute().GetCluster() != PoolClusterPrefix+"staging" || len(rule.Match.Headers) != 2 {
+		t.Fatalf("rule route: got %v", rule)
+	}
+	if h := rule.Match.Headers[0]; h.Name != "X-Debug" || h.GetStringMatch().GetExact() != "1" {
+		t.Errorf("exact match: got %v", h)
+	}
+	if h := rule.Match.Headers[1]; h.GetStringMatch().GetSafeRegex().GetRegex() != "internal-.*" {
+		t.Errorf("regex match: got %v", h)
+	}
+}
+
+func TestTranslate_SNIRoutes(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxy.Backends[1].Pool = "api"
+	cfg.Proxy.SNIRoutes = []config.SNIRoute{{ServerNames: []string{"api.example.com", "*.api.example.com"}, Pool: "api"}}
+	resources, err := Translate(cfg, ListenerModeTCP, nil, false)
+	if err != nil {
+		t.Fatalf("Translate: %v", err)
+	}
+	snap, err := cache.NewSnapshot("1", resources)
+	if err != nil {
+		t.Fatalf("NewSnapshot: %v", err)
+	}
+	if err := snap.Consistent(); err != nil {
+		t.Errorf("snapshot inconsistent: %v", err)
+	}
+
+	l := resources[resource.ListenerType][0].(*listenerv3.Listener)
+	if len(l.ListenerFilters) != 1 || l.ListenerFilters[0].Name != wellknown.TLSInspector {
+		t.Errorf("listener filters: got %v", l.ListenerFilters)
+	}
+	if len(l.FilterChains) != 2 || l.FilterChains[0].FilterChainMatch != nil {
+		t.Fatalf("filter chains: got %v", l.FilterChains)
+	}
+	chain := l.FilterChains[1]
+	if names := chain.FilterChainMatch.GetServerNames(); len(names) != 2 || names[1] != "*.api.example.com" {
+		t.Errorf("server names: got %v", names)
+	}
+	var tp tcpproxyv3.TcpProxy
+	if err := chain.Filters[len(chain.Filters)-1].GetTypedConfig().UnmarshalTo(&tp); err != nil || tp.GetCluster() != PoolClusterPrefix+"api" {
+		t.Errorf("route cluster: got %v, %v", tp.GetCluster(), err)
+	}
+	// The other chains keep their own copy of the shared filters
+	if n := len(l.FilterChains[0].Filters); n != len(chain.Filters) {
+		t.Errorf("default chain has %d filters, route chain %d", n, len(chain.Filters))
+	}
+}
+
+func TestTranslate_GeoRoutes(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxy.Backends[1].Pool = "eu"
+	cfg.Proxy.GeoRoutes = []config.GeoRoute{{
+		Name: "eu", Continents: []string{"EU"}, Pool: "eu",
+		Networks: []netip.Prefix{netip.MustParsePrefix("1.2.4.0/23"), netip.MustParsePrefix("2001:db8::/32")},
+	}}
+	resources, err := Translate(cfg, ListenerModeTCP, nil, false)
+	if err != nil {
+		t.Fatalf("Translate: %v", err)
+	}
+	snap, err := cache.NewSnapshot("1", resources)
+	if err != nil {
+		t.Fatalf("NewSnapshot: %v", err)
+	}
+	if err := snap.Consistent(); err != nil {
+		t.Errorf("snapshot inconsistent: %v", err)
+	}
+
+	l := resources[resource.ListenerType][0].(*listenerv3.Listener)
+	if len(l.ListenerFilters) != 0 {
+		t.Errorf("listener filters: got %v", l.ListenerFilters)
+	}
+	if len(l.FilterChains) != 2 || l.FilterChains[0].FilterChainMatch != nil {
+		t.Fatalf("filter chains: got %v", l.FilterChains)
+	}
+	chain := l.FilterChains[1]
+	ranges := chain.FilterChainMatch.GetSourcePrefixRanges()
+	if len(ranges) != 2 || ranges[0].AddressPrefix != "1.2.4.0" || ranges[0].PrefixLen.GetValue() != 23 ||
+		ranges[1].AddressPrefix != "2001:db8::" || ranges[1].PrefixLen.GetValue() != 32 {
+		t.Errorf("source ranges: got %v", ranges)
+	}
+	var tp tcpproxyv3.TcpProxy
+	if err := chain.Filters[len(chain.Filters)-1].GetTypedConfig().UnmarshalTo(&tp); err != nil || tp.GetCluster() != PoolClusterPrefix+"eu" {
+		t.Errorf("route cluster: got %v, %v", tp.GetCluster(), err)
+	}
+}
+
+func TestTranslate_UDPAffinity(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxy.LoadBalancing.UDPAffinity = config.UDPAffinityConfig{Mode: config.UDPAffinitySourceIP, IdleTimeout: 5 * time.Minute}
+	if c := buildCluster(UDPClusterName, cfg.Proxy); c.LbPolicy != clusterv3.Cluster_RING_HASH {
+		t.Errorf("udp cluster policy: got %s", c.LbPolicy)
+	}
+	if c := buildCluster(TCPClusterName, cfg.Proxy); c.LbPolicy != clusterv3.Cluster_LEAST_REQUEST {
+		t.Errorf("tcp cluster policy: got %s", c.LbPolicy)
+	}
+
+	l, err := buildUDPListener(cfg.Proxy)
+	if err != nil {
+		t.Fatalf("buildUDPListener: %v", err)
+	}
+	var up udpproxyv3.UdpProxyConfig
+	if err := l.ListenerFilters[0].GetTypedConfig().UnmarshalTo(&up); err != nil {
+		t.Fatalf("udp proxy config: %v", err)
+	}
+	if up.IdleTimeout.AsDuration() != 5*time.Minute || len(up.HashPolicies) != 1 || !up.HashPolicies[0].GetSourceIp() {
+		t.Errorf("udp proxy: got %v", &up)
+	}
+}
+
+func TestTranslate_MaglevTableSize(t *testing.T) {
+	cfg := testConfig()
+	cfg.Proxy.LoadBalancing = config.LoadBalancingConfig{
+		Algorithm: config.AlgorithmConsistentHash,
+		Hash:      config.HashConfig{Key: config.HashKeySourceIP, VirtualNodes: 160, Method: config.HashMethodMaglev, TableSize: 251},
+	}
+	resources, err := Translate(cfg, ListenerModeTCP, nil, false)
+	if err != nil {
+		t.Fatalf("Translate: %v", err)
+	}
+
+	cluster := resources[resource.ClusterType][0].(*clusterv3.Cluster)
+	if cluster.LbPolicy != clusterv3.Cluster_MAGLEV {
+		t.Errorf("lb policy: got %v, want MAGLEV", cluster.LbPolicy)
+	}
+	if got := cluster.GetMaglevLbConfig().GetTableSize().GetValue(); got != 251 {
+		t.Errorf("table size: got %d, want 251", got)
+	}
+}
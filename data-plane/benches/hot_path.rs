@@ -14,6 +14,10 @@ fn backends(n: usize) -> Vec<Backend> {
             address: format!("backend-{i}:8080"),
             weight: 100,
             healthy: true,
+            priority: 0,
+            backup: false,
+            zone: String::new(),
+            region: String::new(),
         })
         .collect()
 }
@@ -1,9 +1,17 @@
+use std::sync::OnceLock;
+
 use serde::Serialize;
+use tokio::sync::broadcast;
+
+/// Records buffered per StreamAccessLogs subscriber. A control plane that
+/// falls further behind than this skips the oldest records (reported as
+/// `Lagged`) rather than slowing down the proxy hot path.
+const STREAM_BUFFER: usize = 4096;
 
 /// One structured JSON line per finished connection/session — client IP,
 /// backend, bytes transferred, duration, and error (if any). Emitted at
 /// `target: "access_log"` so it's separable from general application logs.
-#[derive(Serialize)]
+#[derive(Serialize, Clone)]
 pub struct AccessLogEntry {
     pub protocol: &'static str,
     pub client_ip: String,
@@ -14,11 +22,23 @@ pub struct AccessLogEntry {
     pub error: Option<String>,
 }
 
+fn stream_sender() -> &'static broadcast::Sender<AccessLogEntry> {
+    static SENDER: OnceLock<broadcast::Sender<AccessLogEntry>> = OnceLock::new();
+    SENDER.get_or_init(|| broadcast::channel(STREAM_BUFFER).0)
+}
+
+/// Subscribe to every entry logged from now on, for the StreamAccessLogs RPC.
+pub fn subscribe() -> broadcast::Receiver<AccessLogEntry> {
+    stream_sender().subscribe()
+}
+
 impl AccessLogEntry {
     pub fn log(&self) {
         match serde_json::to_string(self) {
             Ok(json) => tracing::info!(target: "access_log", "{}", json),
             Err(e) => tracing::error!("failed to serialize access log entry: {}", e),
         }
+        // Err only means no control plane is currently subscribed.
+        let _ = stream_sender().send(self.clone());
     }
 }
@@ -4,6 +4,8 @@ use std::collections::HashMap;
 use std::time::{Duration, Instant, SystemTime, UNIX_EPOCH};
 use tracing::warn;
 
+use crate::events;
+
 /// Circuit breaker states
 #[derive(Debug, Clone, Copy, PartialEq, Serialize, Deserialize)]
 pub enum CircuitState {
@@ -18,6 +20,9 @@ pub struct CircuitBreaker {
     failure_count: u32,
     success_count: u32,
     last_failure_time: Option<Instant>,
+    /// Wall-clock time the breaker last tripped; kept through HalfOpen and
+    /// cleared when it closes. Reported to operators, not used for timing.
+    opened_at: Option<SystemTime>,
     error_threshold: u32,
     timeout: Duration,
     half_open_max_requests: u32,
@@ -30,6 +35,7 @@ impl CircuitBreaker {
             failure_count: 0,
             success_count: 0,
             last_failure_time: None,
+            opened_at: None,
             error_threshold,
             timeout,
             half_open_max_requests: 3,
@@ -121,11 +127,13 @@ impl CircuitBreaker {
         self.failure_count = 0;
         self.success_count = 0;
         self.last_failure_time = None;
+        self.opened_at = None;
     }
 
     fn transition_to_open(&mut self) {
         self.state = CircuitState::Open;
         self.last_failure_time = Some(Instant::now());
+        self.opened_at = Some(SystemTime::now());
     }
 
     fn transition_to_half_open(&mut self) {
@@ -141,6 +149,24 @@ struct PersistedBreaker {
     state: CircuitState,
     failure_count: u32,
     last_failure_epoch_ms: Option<u64>,
+    // absent in files written before trip times were tracked
+    #[serde(default)]
+    opened_at_epoch_ms: Option<u64>,
+}
+
+/// A breaker as reported by the circuit_breakers operator command.
+#[derive(Debug, Serialize)]
+pub struct BreakerStatus {
+    pub backend: String,
+    pub state: CircuitState,
+    pub failure_count: u32,
+    pub error_threshold: u32,
+    pub opened_at_ms: Option<u64>,
+    pub last_failure_ms: Option<u64>,
+}
+
+fn epoch_ms(t: SystemTime) -> u64 {
+    t.duration_since(UNIX_EPOCH).unwrap_or_default().as_millis() as u64
 }
 
 fn now_epoch_ms() -> u64 {
@@ -179,6 +205,9 @@ fn load_persisted(
                 failure_count: p.failure_count,
                 success_count: 0,
                 last_failure_time,
+                opened_at: p
+                    .opened_at_epoch_ms
+                    .map(|ms| UNIX_EPOCH + Duration::from_millis(ms)),
                 error_threshold,
                 timeout,
                 half_open_max_requests: 3,
@@ -202,6 +231,7 @@ fn persist(path: &str, breakers: &HashMap<String, CircuitBreaker>) {
                     state: b.state,
                     failure_count: b.failure_count,
                     last_failure_epoch_ms,
+                    opened_at_epoch_ms: b.opened_at.map(epoch_ms),
                 },
             )
         })
@@ -257,6 +287,7 @@ impl CircuitBreakerManager {
         let before = breaker.state();
         let allowed = breaker.allow_request();
         if breaker.state() != before {
+            events::circuit_transition(backend_addr, before, breaker.state());
             persist(&self.state_file, &breakers);
         }
         allowed
@@ -269,6 +300,7 @@ impl CircuitBreakerManager {
             let before = breaker.state();
             breaker.record_success();
             if breaker.state() != before {
+                events::circuit_transition(backend_addr, before, breaker.state());
                 persist(&self.state_file, &breakers);
             }
         }
@@ -284,6 +316,7 @@ impl CircuitBreakerManager {
         let before = breaker.state();
         breaker.record_failure();
         if breaker.state() != before {
+            events::circuit_transition(backend_addr, before, breaker.state());
             persist(&self.state_file, &breakers);
         }
     }
@@ -311,20 +344,53 @@ impl CircuitBreakerManager {
             .collect()
     }
 
-    /// Reset specific backend circuit breaker
-    pub fn reset_backend(&self, backend_addr: &str) {
+    /// Every breaker with its trip time, for the circuit_breakers command.
+    pub fn snapshot(&self) -> Vec<BreakerStatus> {
+        let now = SystemTime::now();
+        let breakers = self.breakers.read();
+        let mut out: Vec<BreakerStatus> = breakers
+            .iter()
+            .map(|(addr, b)| BreakerStatus {
+                backend: addr.clone(),
+                state: b.state,
+                failure_count: b.failure_count,
+                error_threshold: b.error_threshold,
+                opened_at_ms: b.opened_at.map(epoch_ms),
+                last_failure_ms: b
+                    .last_failure_time
+                    .and_then(|t| now.checked_sub(t.elapsed()))
+                    .map(epoch_ms),
+            })
+            .collect();
+        out.sort_by(|a, b| a.backend.cmp(&b.backend));
+        out
+    }
+
+    /// Reset specific backend circuit breaker. Returns false if the backend
+    /// has no breaker yet (it has never been tried, so it's closed anyway).
+    pub fn reset_backend(&self, backend_addr: &str) -> bool {
         let mut breakers = self.breakers.write();
-        if let Some(breaker) = breakers.get_mut(backend_addr) {
-            breaker.reset();
-            persist(&self.state_file, &breakers);
+        let Some(breaker) = breakers.get_mut(backend_addr) else {
+            return false;
+        };
+        let before = breaker.state();
+        breaker.reset();
+        if before != CircuitState::Closed {
+            events::circuit_transition(backend_addr, before, CircuitState::Closed);
         }
+        persist(&self.state_file, &breakers);
+        true
     }
 
     /// Reset all circuit breakers
     pub fn reset_all(&self) {
         let mut breakers = self.breakers.write();
-        for breaker in breakers.values_mut() {
+        for (addr, breaker) in breakers.iter_mut() {
+            let before = breaker.state();
             breaker.reset();
+            if before != CircuitState::Closed {
+                events::circuit_transition(addr, before, CircuitState::Closed);
+            }
         }
         persist(&self.state_file, &breakers);
     }
@@ -401,6 +467,29 @@ mod tests {
         let _ = std::fs::remove_file(&path);
     }
 
+    #[test]
+    fn test_snapshot_reports_trip_and_reset_clears_it() {
+        let path = temp_state_file("snapshot");
+        let _ = std::fs::remove_file(&path);
+        let manager = CircuitBreakerManager::new_with_state_file(2, 5, path.clone());
+
+        manager.record_failure("backend1");
+        manager.record_failure("backend1");
+        let snapshot = manager.snapshot();
+        assert_eq!(snapshot.len(), 1);
+        assert_eq!(snapshot[0].state, CircuitState::Open);
+        assert_eq!(snapshot[0].failure_count, 2);
+        assert!(snapshot[0].opened_at_ms.is_some());
+
+        assert!(manager.reset_backend("backend1"));
+        assert!(!manager.reset_backend("never-tried"));
+        let snapshot = manager.snapshot();
+        assert_eq!(snapshot[0].state, CircuitState::Closed);
+        assert!(snapshot[0].opened_at_ms.is_none());
+
+        let _ = std::fs::remove_file(&path);
+    }
+
     fn temp_state_file(name: &str) -> String {
         std::env::temp_dir()
             .join(format!(
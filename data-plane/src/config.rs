@@ -1,13 +1,23 @@
 use dashmap::DashMap;
 use parking_lot::RwLock;
+use serde::Serialize;
+use std::sync::atomic::{AtomicBool, AtomicU64, Ordering};
 use std::sync::Arc;
-use std::time::Duration;
+use std::time::{Duration, Instant};
 use tokio::sync::Notify;
+use tokio_rustls::TlsAcceptor;
 
 use crate::circuit_breaker::CircuitBreakerManager;
-use crate::load_balancer::LoadBalancer;
+use crate::connection_limit::ConnectionLimiter;
+use crate::dark_launch::DarkLaunchRouter;
+use crate::geo::GeoRouter;
+use crate::header_rules::HeaderRouter;
+use crate::sni::SniRouter;
+use crate::load_balancer::{LoadBalancer, Locality};
 use crate::metrics::MetricsCollector;
 use crate::rate_limiter::RateLimiter;
+use crate::secrets::SecretStore;
+use crate::tls;
 
 pub mod proxy {
     tonic::include_proto!("proxy");
@@ -18,6 +28,119 @@ pub struct Backend {
     pub address: String,
     pub weight: i32,
     pub healthy: bool,
+    /// Failover tier, 0 first: backends are only used while every backend
+    /// in a lower tier is unhealthy
+    pub priority: i32,
+    /// Last resort: only used while every primary is down or drained
+    pub backup: bool,
+    /// Where the backend runs, for locality-aware selection; empty if unset
+    pub zone: String,
+    pub region: String,
+}
+
+/// Where mirrored TCP connections are copied to; off while there are no
+/// backends
+#[derive(Debug, Clone, Default)]
+pub struct Mirror {
+    /// Shadow backend addresses, taken in turn
+    pub backends: Vec<String>,
+    /// Share of connections mirrored, 0 to 100
+    pub percent: u32,
+}
+
+/// Sends TCP connections whose HTTP request head matches to backends of
+/// their own
+#[derive(Debug, Clone, Default)]
+pub struct HeaderRule {
+    pub name: String,
+    /// All have to hold
+    pub matches: Vec<HeaderMatch>,
+    pub backends: Vec<Backend>,
+}
+
+/// A header, by case-insensitive name, with exactly the value exact or a
+/// value regex matches in full; regex is empty for an exact match
+#[derive(Debug, Clone, Default)]
+pub struct HeaderMatch {
+    pub header: String,
+    pub exact: String,
+    pub regex: String,
+}
+
+/// Sends TLS connections whose ClientHello names one of server_names, a
+/// host or "*.domain", to backends of their own
+#[derive(Debug, Clone, Default)]
+pub struct SniRoute {
+    pub server_names: Vec<String>,
+    pub backends: Vec<Backend>,
+}
+
+/// Sends connections from clients in networks, CIDR blocks the control
+/// plane resolved from a GeoIP database, to backends of their own
+#[derive(Debug, Clone, Default)]
+pub struct GeoRoute {
+    pub name: String,
+    pub networks: Vec<String>,
+    pub backends: Vec<Backend>,
+}
+
+/// Sends percent of the TCP connections no rule or route takes to
+/// backends of their own, while its window is open
+#[derive(Debug, Clone, Default)]
+pub struct DarkLaunch {
+    pub name: String,
+    pub percent: u32,
+    pub backends: Vec<Backend>,
+}
+
+/// The cap on TCP connections proxied at once, and what happens to those
+/// over it
+#[derive(Debug, Clone, Default)]
+pub struct ConnectionLimit {
+    /// 0 for no limit
+    pub max: u32,
+    /// Hold connections over the limit until a slot comes free, rather than
+    /// closing them right away
+    pub queue: bool,
+    /// Most connections held at once; any more are closed
+    pub queue_size: u32,
+    /// How long a held connection waits before it's closed
+    pub queue_timeout: Duration,
+}
+
+/// Sticks UDP clients to a backend by hashing their source, whatever the
+/// algorithm
+#[derive(Debug, Clone, Default)]
+pub struct UdpAffinity {
+    /// "source_ip_port" or "source_ip"; empty for off
+    pub mode: String,
+    /// How long a session lasts without a packet
+    pub idle_timeout: Duration,
+}
+
+impl UdpAffinity {
+    pub fn enabled(&self) -> bool {
+        !self.mode.is_empty()
+    }
+}
+
+/// Keeps HTTP clients on a backend with a cookie set on the first response
+/// of a connection whose request didn't carry it, naming the backend picked
+#[derive(Debug, Clone, Default)]
+pub struct StickyCookie {
+    /// Empty for off
+    pub name: String,
+    /// Max-Age; 0 for a session cookie
+    pub ttl_secs: u32,
+    pub path: String,
+    pub secure: bool,
+    pub http_only: bool,
+}
+
+impl StickyCookie {
+    pub fn enabled(&self) -> bool {
+        !self.name.is_empty()
+    }
 }
 
 #[derive(Debug, Clone)]
@@ -28,8 +151,36 @@ pub struct ProxyConfig {
     pub udp_backends: Vec<Backend>,
     pub algorithm: String,
     pub session_affinity: bool,
+    /// What consistent_hash hashes: "source_ip" (or empty), "header" or
+    /// "cookie", the latter two named by hash_key_name
+    pub hash_key: String,
+    pub hash_key_name: String,
+    /// Points per backend on the consistent_hash ring
+    pub virtual_nodes: u32,
+    /// "ring" (or empty) or "maglev", with the Maglev table's size
+    pub hash_method: String,
+    pub maglev_table_size: u32,
+    /// "prefer_local" (or empty for off), with this data plane's zone
+    pub locality_policy: String,
+    pub locality: Locality,
+    pub udp_affinity: UdpAffinity,
+    pub sticky_cookie: StickyCookie,
+    /// Shadow backends that get a copy of a share of TCP connections
+    pub mirror: Mirror,
+    /// Tried in order before the TCP load balancer
+    pub header_rules: Vec<HeaderRule>,
+    /// Matched on the ClientHello before the TCP load balancer
+    pub sni_routes: Vec<SniRoute>,
+    /// Matched on the client's address before everything else
+    pub geo_routes: Vec<GeoRoute>,
+    /// Take their shares of what no rule or route took; only the ones on
+    pub dark_launches: Vec<DarkLaunch>,
+    /// Terminate TLS on the TCP listener with the certificates pushed by
+    /// UpdateSecrets
+    pub tls_enabled: bool,
     pub rate_limit_rps: i32,
     pub rate_limit_burst: i32,
+    pub connection_limit: ConnectionLimit,
     pub connect_timeout_secs: i32,
     pub idle_timeout_secs: i32,
     pub read_timeout_secs: i32,
@@ -37,17 +188,58 @@ pub struct ProxyConfig {
     pub circuit_breaker_timeout_secs: u32,
 }
 
+/// One row of the active connection table, as reported by the
+/// `dump_connections` operator command.
+#[derive(Debug, Clone, Serialize)]
+pub struct ConnectionInfo {
+    pub id: u64,
+    pub client: String,
+    /// Empty until a backend has been selected.
+    pub backend: String,
+    pub age_ms: u64,
+}
+
+struct ConnectionEntry {
+    client: String,
+    backend: String,
+    started: Instant,
+    /// Notified by close_connection; the proxy task tears the connection
+    /// down when it fires.
+    close: Arc<Notify>,
+    _token: Arc<()>,
+}
+
 pub struct ProxyState {
     config: RwLock<Option<ProxyConfig>>,
     config_notify: Arc<Notify>,
-    active_connections: DashMap<u64, Arc<()>>,
+    active_connections: DashMap<u64, ConnectionEntry>,
     connection_counter: parking_lot::Mutex<u64>,
     draining: parking_lot::Mutex<bool>,
+    /// Set by the pause_traffic command: new TCP connections and new UDP
+    /// sessions are refused while existing ones carry on. Unlike draining
+    /// it survives config pushes and is undone by resume_traffic.
+    paused: AtomicBool,
     pub circuit_breaker: RwLock<Arc<CircuitBreakerManager>>,
     pub rate_limiter: RwLock<Arc<RateLimiter>>,
+    /// Kept across config pushes, which only reconfigure it, so the count
+    /// of connections holding a slot survives them
+    pub connection_limiter: Arc<ConnectionLimiter>,
     pub metrics: Arc<MetricsCollector>,
     tcp_lb: RwLock<Arc<LoadBalancer>>,
     udp_lb: RwLock<Arc<LoadBalancer>>,
+    header_router: RwLock<Arc<HeaderRouter>>,
+    sni_router: RwLock<Arc<SniRouter>>,
+    geo_router: RwLock<Arc<GeoRouter>>,
+    dark_launch_router: RwLock<Arc<DarkLaunchRouter>>,
+    mirror: RwLock<Arc<Mirror>>,
+    udp_affinity: RwLock<UdpAffinity>,
+    /// TCP connections considered for mirroring, for spreading the
+    /// mirrored share evenly and taking the shadows in turn
+    mirror_counter: AtomicU64,
+    /// Built from the certificates in secrets, which come apart from the
+    /// config and survive its pushes; None until the first are pushed
+    tls_acceptor: RwLock<Option<TlsAcceptor>>,
+    secrets: parking_lot::Mutex<SecretStore>,
 }
 
 impl ProxyState {
@@ -64,11 +256,22 @@ impl ProxyState {
             active_connections: DashMap::new(),
             connection_counter: parking_lot::Mutex::new(0),
             draining: parking_lot::Mutex::new(false),
+            paused: AtomicBool::new(false),
             circuit_breaker: RwLock::new(default_circuit_breaker),
             rate_limiter: RwLock::new(default_rate_limiter),
+            connection_limiter: Arc::new(ConnectionLimiter::new()),
             metrics,
             tcp_lb: RwLock::new(default_tcp_lb),
             udp_lb: RwLock::new(default_udp_lb),
+            header_router: RwLock::new(Arc::new(HeaderRouter::empty())),
+            sni_router: RwLock::new(Arc::new(SniRouter::empty())),
+            geo_router: RwLock::new(Arc::new(GeoRouter::empty())),
+            dark_launch_router: RwLock::new(Arc::new(DarkLaunchRouter::empty())),
+            mirror: RwLock::new(Arc::new(Mirror::default())),
+            udp_affinity: RwLock::new(UdpAffinity::default()),
+            mirror_counter: AtomicU64::new(0),
+            tls_acceptor: RwLock::new(None),
+            secrets: parking_lot::Mutex::new(SecretStore::default()),
         }
     }
 
@@ -87,22 +290,71 @@ impl ProxyState {
             config.rate_limit_rps as u64,
             config.rate_limit_burst as u64,
         ));
-        let tcp_lb = Arc::new(LoadBalancer::new(
-            config.backends.clone(),
-            config.algorithm.clone(),
-        ));
-        let udp_lb = Arc::new(LoadBalancer::new(
-            config.udp_backends.clone(),
-            config.algorithm.clone(),
-        ));
+        let maglev_table_size = if config.hash_method == "maglev" {
+            config.maglev_table_size
+        } else {
+            0
+        };
+        let locality = (config.locality_policy == "prefer_local").then(|| config.locality.clone());
+        let tcp_lb = Arc::new(
+            LoadBalancer::new(config.backends.clone(), config.algorithm.clone())
+                .with_virtual_nodes(config.virtual_nodes)
+                .with_maglev(maglev_table_size)
+                .with_locality(locality.clone()),
+        );
+        // UDP affinity hashes the client's source onto the backends,
+        // whatever the TCP side uses
+        let udp_algorithm = if config.udp_affinity.enabled() {
+            "consistent_hash".to_string()
+        } else {
+            config.algorithm.clone()
+        };
+        let udp_lb = Arc::new(
+            LoadBalancer::new(config.udp_backends.clone(), udp_algorithm)
+                .with_virtual_nodes(config.virtual_nodes)
+                .with_maglev(maglev_table_size)
+                .with_locality(locality.clone()),
+        );
+        // Each rule's and route's backends are balanced the same way as the
+        // rest
+        let pool_lb = |backends: Vec<Backend>| {
+            LoadBalancer::new(backends, config.algorithm.clone())
+                .with_virtual_nodes(config.virtual_nodes)
+                .with_maglev(maglev_table_size)
+                .with_locality(locality.clone())
+        };
+        let header_router = Arc::new(HeaderRouter::new(&config.header_rules, pool_lb));
+        let sni_router = Arc::new(SniRouter::new(&config.sni_routes, pool_lb));
+        let geo_router = Arc::new(GeoRouter::new(&config.geo_routes, pool_lb));
+        let dark_launch_router = Arc::new(DarkLaunchRouter::new(&config.dark_launches, pool_lb));
 
         *self.rate_limiter.write() = rate_limiter;
+        self.connection_limiter
+            .configure(config.connection_limit.clone());
         *self.tcp_lb.write() = tcp_lb;
         *self.udp_lb.write() = udp_lb;
+        *self.header_router.write() = header_router;
+        *self.sni_router.write() = sni_router;
+        *self.geo_router.write() = geo_router;
+        *self.dark_launch_router.write() = dark_launch_router;
+        *self.mirror.write() = Arc::new(config.mirror.clone());
+        *self.udp_affinity.write() = config.udp_affinity.clone();
         *self.config.write() = Some(config);
         self.config_notify.notify_waiters();
     }
 
+    /// Swap in a new global rate limit without rebuilding the load
+    /// balancers, for the UpdateRateLimit RPC. The bucket starts full at the
+    /// new burst.
+    pub fn update_rate_limit(&self, rps: i32, burst: i32) -> Result<(), &'static str> {
+        let mut config = self.config.write();
+        let config = config.as_mut().ok_or("Proxy not configured")?;
+        config.rate_limit_rps = rps;
+        config.rate_limit_burst = burst;
+        *self.rate_limiter.write() = Arc::new(RateLimiter::new(rps as u64, burst as u64));
+        Ok(())
+    }
+
     pub fn get_tcp_lb(&self) -> Arc<LoadBalancer> {
         self.tcp_lb.read().clone()
     }
@@ -111,6 +363,89 @@ impl ProxyState {
         self.udp_lb.read().clone()
     }
 
+    pub fn get_header_router(&self) -> Arc<HeaderRouter> {
+        self.header_router.read().clone()
+    }
+
+    pub fn get_sni_router(&self) -> Arc<SniRouter> {
+        self.sni_router.read().clone()
+    }
+
+    pub fn get_geo_router(&self) -> Arc<GeoRouter> {
+        self.geo_router.read().clone()
+    }
+
+    pub fn get_dark_launch_router(&self) -> Arc<DarkLaunchRouter> {
+        self.dark_launch_router.read().clone()
+    }
+
+    pub fn get_tls_acceptor(&self) -> Option<TlsAcceptor> {
+        self.tls_acceptor.read().clone()
+    }
+
+    /// Replaces the certificates TLS is terminated with; connections
+    /// already through their handshake keep theirs.
+    pub fn set_tls_acceptor(&self, acceptor: TlsAcceptor) {
+        *self.tls_acceptor.write() = Some(acceptor);
+    }
+
+    /// Applies an update from StreamSecrets, returning its ack.
+    pub fn apply_secrets(&self, update: &proxy::SecretUpdate) -> proxy::SecretAck {
+        let mut secrets = self.secrets.lock();
+        self.apply_held_secrets(&mut secrets, update)
+    }
+
+    /// Replaces the certificates with those of an UpdateSecrets, checked
+    /// already, the first being the default.
+    pub fn replace_certificates(&self, certificates: &[proxy::TlsCertificate]) {
+        let mut secrets = self.secrets.lock();
+        let update = secrets.certificates_update(certificates);
+        self.apply_held_secrets(&mut secrets, &update);
+    }
+
+    /// The token held as name, for features authenticating with one.
+    pub fn get_secret_token(&self, name: &str) -> Option<Vec<u8>> {
+        self.secrets.lock().token(name).map(|token| token.to_vec())
+    }
+
+    fn apply_held_secrets(
+        &self,
+        secrets: &mut SecretStore,
+        update: &proxy::SecretUpdate,
+    ) -> proxy::SecretAck {
+        let (ack, certificates_changed) = secrets.apply(update);
+        if certificates_changed {
+            // Every certificate held was checked on its way in
+            match tls::acceptor(secrets.certificates()) {
+                Ok(acceptor) => self.set_tls_acceptor(acceptor),
+                Err(e) => tracing::error!("Failed to rebuild the TLS certificates: {}", e),
+            }
+        }
+        ack
+    }
+
+    pub fn get_udp_affinity(&self) -> UdpAffinity {
+        self.udp_affinity.read().clone()
+    }
+
+    /// The shadow backend a new TCP connection is copied to, or None if
+    /// it's not in the mirrored share. Mirrored connections are spread
+    /// evenly rather than in bursts: percent of every hundred, one at a
+    /// time.
+    pub fn mirror_target(&self) -> Option<String> {
+        let mirror = self.mirror.read().clone();
+        if mirror.backends.is_empty() || mirror.percent == 0 {
+            return None;
+        }
+        let n = self.mirror_counter.fetch_add(1, Ordering::Relaxed);
+        let percent = mirror.percent.min(100) as u64;
+        let (before, after) = (n * percent / 100, (n + 1) * percent / 100);
+        if after == before {
+            return None;
+        }
+        Some(mirror.backends[(before % mirror.backends.len() as u64) as usize].clone())
+    }
+
     pub fn get_config(&self) -> Option<ProxyConfig> {
         self.config.read().clone()
     }
@@ -125,15 +460,68 @@ impl ProxyState {
         }
     }
 
-    pub fn register_connection(&self) -> (u64, Arc<()>) {
+    pub fn register_connection(&self, client: String) -> (u64, Arc<()>) {
         let mut counter = self.connection_counter.lock();
         *counter += 1;
         let id = *counter;
         let token = Arc::new(());
-        self.active_connections.insert(id, token.clone());
+        self.active_connections.insert(
+            id,
+            ConnectionEntry {
+                client,
+                backend: String::new(),
+                started: Instant::now(),
+                close: Arc::new(Notify::new()),
+                _token: token.clone(),
+            },
+        );
         (id, token)
     }
 
+    /// The signal close_connection fires for a registered connection.
+    pub fn close_signal(&self, id: u64) -> Option<Arc<Notify>> {
+        self.active_connections.get(&id).map(|e| e.close.clone())
+    }
+
+    /// Ask the task proxying connection `id` to close it. Returns the
+    /// connection as it was, or None if there is no such connection. The
+    /// entry goes away once the task has actually closed it.
+    pub fn close_connection(&self, id: u64) -> Option<ConnectionInfo> {
+        let entry = self.active_connections.get(&id)?;
+        // notify_one keeps a permit, so a close that lands before the task
+        // starts waiting still takes effect
+        entry.close.notify_one();
+        Some(ConnectionInfo {
+            id,
+            client: entry.client.clone(),
+            backend: entry.backend.clone(),
+            age_ms: entry.started.elapsed().as_millis() as u64,
+        })
+    }
+
+    /// Record which backend a registered connection was routed to.
+    pub fn set_connection_backend(&self, id: u64, backend: &str) {
+        if let Some(mut entry) = self.active_connections.get_mut(&id) {
+            entry.backend = backend.to_string();
+        }
+    }
+
+    /// Snapshot of the active connection table, oldest first.
+    pub fn list_connections(&self) -> Vec<ConnectionInfo> {
+        let mut conns: Vec<ConnectionInfo> = self
+            .active_connections
+            .iter()
+            .map(|e| ConnectionInfo {
+                id: *e.key(),
+                client: e.client.clone(),
+                backend: e.backend.clone(),
+                age_ms: e.started.elapsed().as_millis() as u64,
+            })
+            .collect();
+        conns.sort_by_key(|c| c.id);
+        conns
+    }
+
     pub fn unregister_connection(&self, id: u64) {
         self.active_connections.remove(&id);
     }
@@ -159,6 +547,15 @@ impl ProxyState {
         *self.draining.lock() = false;
     }
 
+    pub fn is_paused(&self) -> bool {
+        self.paused.load(Ordering::Relaxed)
+    }
+
+    /// Sets the paused flag and returns its previous value.
+    pub fn set_paused(&self, paused: bool) -> bool {
+        self.paused.swap(paused, Ordering::Relaxed)
+    }
+
     pub fn get_metrics(&self) -> Arc<MetricsCollector> {
         self.metrics.clone()
     }
@@ -176,12 +573,32 @@ mod tests {
                 address: backend_addr.to_string(),
                 weight: 100,
                 healthy: true,
+                priority: 0,
+                backup: false,
+                zone: String::new(),
+                region: String::new(),
             }],
             udp_backends: vec![],
             algorithm: "round_robin".to_string(),
             session_affinity: false,
+            hash_key: String::new(),
+            hash_key_name: String::new(),
+            virtual_nodes: 160,
+            hash_method: String::new(),
+            maglev_table_size: 65537,
+            locality_policy: String::new(),
+            locality: Locality::default(),
+            udp_affinity: UdpAffinity::default(),
+            sticky_cookie: StickyCookie::default(),
+            mirror: Mirror::default(),
+            header_rules: vec![],
+            sni_routes: vec![],
+            geo_routes: vec![],
+            dark_launches: vec![],
+            tls_enabled: false,
             rate_limit_rps: 1000,
             rate_limit_burst: 100,
+            connection_limit: ConnectionLimit::default(),
             connect_timeout_secs: 5,
             idle_timeout_secs: 60,
             read_timeout_secs: 30,
@@ -227,6 +644,26 @@ mod tests {
         assert!(state.get_config().is_some());
     }
 
+    #[test]
+    fn test_mirror_target_spreads_the_share_over_the_shadows() {
+        let state = ProxyState::new();
+        assert_eq!(state.mirror_target(), None);
+
+        let mut config = test_config("backend-mirror-test:9999");
+        config.mirror = Mirror {
+            backends: vec!["shadow-a:1".to_string(), "shadow-b:1".to_string()],
+            percent: 25,
+        };
+        state.update_config(config);
+        let targets: Vec<Option<String>> = (0..100).map(|_| state.mirror_target()).collect();
+        let mirrored: Vec<usize> = (0..100).filter(|&i| targets[i].is_some()).collect();
+        // One in four, evenly spaced rather than the first 25 of each 100
+        assert_eq!(mirrored, (0..25).map(|k| 4 * k + 3).collect::<Vec<_>>());
+        assert_eq!(targets[3].as_deref(), Some("shadow-a:1"));
+        assert_eq!(targets[7].as_deref(), Some("shadow-b:1"));
+        assert_eq!(targets[11].as_deref(), Some("shadow-a:1"));
+    }
+
     #[test]
     fn test_backend_reload_preserves_circuit_breaker_state() {
         let state = ProxyState::new();
@@ -246,4 +683,48 @@ mod tests {
             "reloading the backend list must not reset an in-progress circuit breaker count"
         );
     }
+
+    #[test]
+    fn test_update_rate_limit_keeps_load_balancers() {
+        let state = ProxyState::new();
+        assert!(state.update_rate_limit(10, 5).is_err());
+
+        state.update_config(test_config("backend-rate-limit-test:9999"));
+        let lb = state.get_tcp_lb();
+        state.update_rate_limit(10, 5).unwrap();
+
+        let config = state.get_config().unwrap();
+        assert_eq!((config.rate_limit_rps, config.rate_limit_burst), (10, 5));
+        assert_eq!(state.rate_limiter.read().get_global_stats().1, 5);
+        assert!(Arc::ptr_eq(&lb, &state.get_tcp_lb()));
+    }
+
+    #[test]
+    fn test_pause_survives_config_push() {
+        let state = ProxyState::new();
+        assert!(!state.set_paused(true));
+        state.update_config(test_config("backend-pause-test:9999"));
+        state.reset_draining();
+        assert!(state.is_paused(), "a config push must not end a pause");
+        assert!(state.set_paused(false));
+        assert!(!state.is_paused());
+    }
+
+    #[tokio::test]
+    async fn test_close_connection_signals_the_proxy_task() {
+        let state = ProxyState::new();
+        assert!(state.close_connection(1).is_none());
+
+        let (id, _token) = state.register_connection("10.0.0.7:41000".to_string());
+        state.set_connection_backend(id, "db1.internal:5432");
+        let signal = state.close_signal(id).unwrap();
+
+        let closed = state.close_connection(id).unwrap();
+        assert_eq!(closed.client, "10.0.0.7:41000");
+        assert_eq!(closed.backend, "db1.internal:5432");
+        // The permit is stored, so waiting after the close returns at once
+        tokio::time::timeout(Duration::from_secs(1), signal.notified())
+            .await
+            .expect("close signal was not delivered");
+    }
 }
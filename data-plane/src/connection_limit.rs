@@ -0,0 +1,224 @@
+use parking_lot::RwLock;
+use std::sync::atomic::{AtomicUsize, Ordering};
+use std::sync::Arc;
+use tokio::sync::Notify;
+use tokio::time::Instant;
+
+use crate::config::ConnectionLimit;
+
+/// Why a connection didn't get a slot.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Overflow {
+    /// Over the limit with nowhere to wait: overflow is reject, or the
+    /// queue is full.
+    Rejected,
+    /// Queued for the whole queue timeout without a slot coming free.
+    TimedOut,
+}
+
+/// Caps the TCP connections proxied at once across the whole data plane, so
+/// a flood can't exhaust the host's file descriptors or memory. It outlives
+/// config pushes, which only change its settings: connections already
+/// holding a slot keep it when the limit is lowered.
+pub struct ConnectionLimiter {
+    settings: RwLock<ConnectionLimit>,
+    active: AtomicUsize,
+    queued: AtomicUsize,
+    /// Notified once per released slot; tokio wakes waiters in the order
+    /// they started waiting, so the queue is first come, first served.
+    released: Notify,
+}
+
+/// A connection's slot, given back when it's dropped.
+pub struct Permit {
+    limiter: Arc<ConnectionLimiter>,
+}
+
+impl Drop for Permit {
+    fn drop(&mut self) {
+        self.limiter.active.fetch_sub(1, Ordering::AcqRel);
+        self.limiter.released.notify_one();
+    }
+}
+
+/// Holds a place in the queue, given back when it's dropped.
+struct QueueSlot<'a>(&'a AtomicUsize);
+
+impl Drop for QueueSlot<'_> {
+    fn drop(&mut self) {
+        self.0.fetch_sub(1, Ordering::AcqRel);
+    }
+}
+
+impl ConnectionLimiter {
+    pub fn new() -> Self {
+        Self {
+            settings: RwLock::new(ConnectionLimit::default()),
+            active: AtomicUsize::new(0),
+            queued: AtomicUsize::new(0),
+            released: Notify::new(),
+        }
+    }
+
+    pub fn configure(&self, limit: ConnectionLimit) {
+        *self.settings.write() = limit;
+        // A raised or lifted limit may have room for the queue now
+        self.released.notify_waiters();
+    }
+
+    /// The most connections proxied at once, 0 for no limit.
+    pub fn limit(&self) -> u32 {
+        self.settings.read().max
+    }
+
+    /// Connections waiting for a slot.
+    pub fn queued(&self) -> usize {
+        self.queued.load(Ordering::Acquire)
+    }
+
+    /// Takes a slot for a new connection, waiting for one when overflow is
+    /// queue. A connection arriving while others wait joins the back of the
+    /// queue rather than taking a slot that comes free ahead of them.
+    pub async fn acquire(self: &Arc<Self>) -> Result<Permit, Overflow> {
+        let limit = self.settings.read().clone();
+        if !limit.queue || self.queued() == 0 {
+            if let Some(permit) = self.try_acquire(limit.max) {
+                return Ok(permit);
+            }
+        }
+        if !limit.queue {
+            return Err(Overflow::Rejected);
+        }
+        if self.queued.fetch_add(1, Ordering::AcqRel) >= limit.queue_size as usize {
+            self.queued.fetch_sub(1, Ordering::AcqRel);
+            return Err(Overflow::Rejected);
+        }
+        let _slot = QueueSlot(&self.queued);
+
+        let deadline = Instant::now() + limit.queue_timeout;
+        loop {
+            let notified = self.released.notified();
+            tokio::pin!(notified);
+            // Registered before the check, so a slot freed in between
+            // isn't missed
+            notified.as_mut().enable();
+            let max = self.settings.read().max;
+            if let Some(permit) = self.try_acquire(max) {
+                return Ok(permit);
+            }
+            if tokio::time::timeout_at(deadline, notified).await.is_err() {
+                return Err(Overflow::TimedOut);
+            }
+        }
+    }
+
+    fn try_acquire(self: &Arc<Self>, max: u32) -> Option<Permit> {
+        let mut active = self.active.load(Ordering::Acquire);
+        loop {
+            if max != 0 && active >= max as usize {
+                return None;
+            }
+            match self.active.compare_exchange_weak(
+                active,
+                active + 1,
+                Ordering::AcqRel,
+                Ordering::Acquire,
+            ) {
+                Ok(_) => {
+                    return Some(Permit {
+                        limiter: self.clone(),
+                    })
+                }
+                Err(current) => active = current,
+            }
+        }
+    }
+}
+
+impl Default for ConnectionLimiter {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::time::Duration;
+
+    fn limiter(max: u32, queue: bool) -> Arc<ConnectionLimiter> {
+        let limiter = Arc::new(ConnectionLimiter::new());
+        limiter.configure(ConnectionLimit {
+            max,
+            queue,
+            queue_size: 1,
+            queue_timeout: Duration::from_millis(200),
+        });
+        limiter
+    }
+
+    #[tokio::test]
+    async fn test_reject_over_limit() {
+        let limiter = limiter(1, false);
+        let permit = limiter.acquire().await.unwrap();
+        assert_eq!(limiter.acquire().await.err(), Some(Overflow::Rejected));
+
+        drop(permit);
+        assert!(limiter.acquire().await.is_ok());
+    }
+
+    #[tokio::test]
+    async fn test_no_limit() {
+        let limiter = limiter(0, false);
+        let mut permits = Vec::new();
+        for _ in 0..10 {
+            permits.push(limiter.acquire().await.unwrap());
+        }
+    }
+
+    #[tokio::test]
+    async fn test_queue_waits_for_a_released_slot() {
+        let limiter = limiter(1, true);
+        let permit = limiter.acquire().await.unwrap();
+
+        let waiter = {
+            let limiter = limiter.clone();
+            tokio::spawn(async move { limiter.acquire().await.map(|_| ()) })
+        };
+        tokio::time::sleep(Duration::from_millis(20)).await;
+        assert_eq!(limiter.queued(), 1);
+        // The queue holds one
+        assert_eq!(limiter.acquire().await.err(), Some(Overflow::Rejected));
+
+        drop(permit);
+        assert_eq!(waiter.await.unwrap(), Ok(()));
+        assert_eq!(limiter.queued(), 0);
+    }
+
+    #[tokio::test]
+    async fn test_queue_times_out() {
+        let limiter = limiter(1, true);
+        let _permit = limiter.acquire().await.unwrap();
+        assert_eq!(limiter.acquire().await.err(), Some(Overflow::TimedOut));
+        assert_eq!(limiter.queued(), 0);
+    }
+
+    #[tokio::test]
+    async fn test_raised_limit_admits_the_queue() {
+        let limiter = limiter(1, true);
+        let _permit = limiter.acquire().await.unwrap();
+
+        let waiter = {
+            let limiter = limiter.clone();
+            tokio::spawn(async move { limiter.acquire().await.map(|_| ()) })
+        };
+        tokio::time::sleep(Duration::from_millis(20)).await;
+        limiter.configure(ConnectionLimit {
+            max: 2,
+            queue: true,
+            queue_size: 1,
+            queue_timeout: Duration::from_millis(200),
+        });
+        assert_eq!(waiter.await.unwrap(), Ok(()));
+    }
+}
@@ -0,0 +1,112 @@
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::Arc;
+
+use crate::config::{Backend, DarkLaunch};
+use crate::load_balancer::LoadBalancer;
+
+/// Sends each dark launch's share of the TCP connections no rule or route
+/// took to its pool. The control plane only sends the rules whose windows
+/// are open, so every rule here is on. Rebuilt on every config push and
+/// backend reload.
+pub struct DarkLaunchRouter {
+    /// Name, percent and load balancer of each rule
+    rules: Vec<(String, u64, Arc<LoadBalancer>)>,
+    /// The rules' percents together, at most 100
+    total: u64,
+    /// Connections considered, for spreading the shares evenly
+    counter: AtomicU64,
+}
+
+impl DarkLaunchRouter {
+    /// A router over rules, each balanced by a load balancer build makes
+    /// from its backends.
+    pub fn new(rules: &[DarkLaunch], build: impl Fn(Vec<Backend>) -> LoadBalancer) -> Self {
+        let rules: Vec<_> = rules
+            .iter()
+            .filter(|r| r.percent > 0)
+            .map(|r| {
+                let lb = Arc::new(build(r.backends.clone()));
+                (r.name.clone(), r.percent.min(100) as u64, lb)
+            })
+            .collect();
+        let total = rules.iter().map(|(_, percent, _)| percent).sum::<u64>().min(100);
+        Self {
+            rules,
+            total,
+            counter: AtomicU64::new(0),
+        }
+    }
+
+    pub fn empty() -> Self {
+        Self::new(&[], |backends| LoadBalancer::new(backends, "round_robin".to_string()))
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.rules.is_empty()
+    }
+
+    /// The name and load balancer of the rule a new connection goes to, or
+    /// None if it's in no rule's share. Like mirroring, the shares are
+    /// spread evenly rather than in bursts: of every hundred connections,
+    /// each rule gets its percent, one at a time and the rules in turn.
+    pub fn route(&self) -> Option<(&str, Arc<LoadBalancer>)> {
+        if self.total == 0 {
+            return None;
+        }
+        let n = self.counter.fetch_add(1, Ordering::Relaxed);
+        let (before, after) = (n * self.total / 100, (n + 1) * self.total / 100);
+        if after == before {
+            return None;
+        }
+        let mut slot = before % self.total;
+        for (name, percent, lb) in &self.rules {
+            if slot < *percent {
+                return Some((name.as_str(), lb.clone()));
+            }
+            slot -= *percent;
+        }
+        None
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn rule(name: &str, percent: u32, address: &str) -> DarkLaunch {
+        DarkLaunch {
+            name: name.to_string(),
+            percent,
+            backends: vec![Backend {
+                address: address.to_string(),
+                weight: 100,
+                healthy: true,
+                priority: 0,
+                backup: false,
+                zone: String::new(),
+                region: String::new(),
+            }],
+        }
+    }
+
+    #[test]
+    fn test_shares_spread_over_every_hundred() {
+        let router = DarkLaunchRouter::new(
+            &[rule("checkout", 5, "beta-1:80"), rule("search", 15, "beta-2:80")],
+            |backends| LoadBalancer::new(backends, "round_robin".to_string()),
+        );
+        let routed: Vec<Option<String>> = (0..200)
+            .map(|_| router.route().map(|(name, _)| name.to_string()))
+            .collect();
+        let count = |name: &str| routed.iter().filter(|r| r.as_deref() == Some(name)).count();
+        assert_eq!(count("checkout"), 10);
+        assert_eq!(count("search"), 30);
+        // One in every five connections, never two in a row
+        assert!(routed[..4].iter().all(Option::is_none));
+        assert!(routed[4].is_some());
+        assert!(routed.windows(2).all(|w| w[0].is_none() || w[1].is_none()));
+
+        assert!(DarkLaunchRouter::empty().is_empty());
+        assert!(DarkLaunchRouter::empty().route().is_none());
+    }
+}
@@ -0,0 +1,182 @@
+use std::collections::HashMap;
+use std::sync::OnceLock;
+use std::time::{Duration, Instant, SystemTime, UNIX_EPOCH};
+
+use parking_lot::Mutex;
+use tokio::sync::broadcast;
+
+use crate::circuit_breaker::CircuitState;
+
+/// Events buffered per StreamEvents subscriber. Events are rare compared to
+/// access log records, so a smaller buffer than access_log's is plenty.
+const STREAM_BUFFER: usize = 1024;
+
+/// Rate limit denials arrive in floods; they're folded into at most one
+/// event per protocol per window, carrying the number of denials it covers.
+const RATE_LIMIT_WINDOW: Duration = Duration::from_secs(1);
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum EventKind {
+    CircuitOpened,
+    CircuitHalfOpen,
+    CircuitClosed,
+    RateLimitExceeded,
+    ConnectionReset,
+}
+
+/// A structured event pushed to the control plane over StreamEvents.
+#[derive(Debug, Clone)]
+pub struct Event {
+    pub kind: EventKind,
+    pub timestamp_ms: i64,
+    pub backend: String,
+    pub message: String,
+    pub attributes: HashMap<String, String>,
+}
+
+fn stream_sender() -> &'static broadcast::Sender<Event> {
+    static SENDER: OnceLock<broadcast::Sender<Event>> = OnceLock::new();
+    SENDER.get_or_init(|| broadcast::channel(STREAM_BUFFER).0)
+}
+
+/// Subscribe to every event emitted from now on, for the StreamEvents RPC.
+pub fn subscribe() -> broadcast::Receiver<Event> {
+    stream_sender().subscribe()
+}
+
+fn emit(kind: EventKind, backend: &str, message: String, attributes: HashMap<String, String>) {
+    let timestamp_ms = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .unwrap_or_default()
+        .as_millis() as i64;
+    // Err only means no control plane is currently subscribed.
+    let _ = stream_sender().send(Event {
+        kind,
+        timestamp_ms,
+        backend: backend.to_string(),
+        message,
+        attributes,
+    });
+}
+
+/// Report a circuit breaker state change for `backend`.
+pub fn circuit_transition(backend: &str, from: CircuitState, to: CircuitState) {
+    let kind = match to {
+        CircuitState::Open => EventKind::CircuitOpened,
+        CircuitState::HalfOpen => EventKind::CircuitHalfOpen,
+        CircuitState::Closed => EventKind::CircuitClosed,
+    };
+    let attributes = HashMap::from([
+        ("from".to_string(), format!("{:?}", from)),
+        ("to".to_string(), format!("{:?}", to)),
+    ]);
+    emit(
+        kind,
+        backend,
+        format!("circuit breaker {:?} -> {:?}", from, to),
+        attributes,
+    );
+}
+
+struct RateLimitWindow {
+    started: Instant,
+    denied: u64,
+}
+
+/// Count a rate limit denial. The first denial in a window is reported
+/// immediately; the rest are accumulated and reported with the first denial
+/// of the next window, so a sustained flood yields one event per second.
+pub fn rate_limit_exceeded(protocol: &'static str, client: &str) {
+    static WINDOWS: OnceLock<Mutex<HashMap<&'static str, RateLimitWindow>>> = OnceLock::new();
+    let denied = {
+        let mut windows = WINDOWS.get_or_init(|| Mutex::new(HashMap::new())).lock();
+        match windows.get_mut(protocol) {
+            Some(w) if w.started.elapsed() < RATE_LIMIT_WINDOW => {
+                w.denied += 1;
+                return;
+            }
+            Some(w) => {
+                let denied = w.denied + 1;
+                w.started = Instant::now();
+                w.denied = 0;
+                denied
+            }
+            None => {
+                windows.insert(
+                    protocol,
+                    RateLimitWindow {
+                        started: Instant::now(),
+                        denied: 0,
+                    },
+                );
+                1
+            }
+        }
+    };
+
+    let attributes = HashMap::from([
+        ("protocol".to_string(), protocol.to_string()),
+        ("client".to_string(), client.to_string()),
+        ("count".to_string(), denied.to_string()),
+    ]);
+    emit(
+        EventKind::RateLimitExceeded,
+        "",
+        format!("{} {} request(s) denied by rate limiter", denied, protocol),
+        attributes,
+    );
+}
+
+/// Report a proxied TCP connection torn down by a reset from either peer.
+pub fn connection_reset(backend: &str, direction: &'static str, client: &str) {
+    let attributes = HashMap::from([
+        ("direction".to_string(), direction.to_string()),
+        ("client".to_string(), client.to_string()),
+    ]);
+    emit(
+        EventKind::ConnectionReset,
+        backend,
+        format!("connection reset ({})", direction),
+        attributes,
+    );
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_rate_limit_events_are_aggregated() {
+        let mut rx = subscribe();
+        for _ in 0..100 {
+            rate_limit_exceeded("test", "10.0.0.1:5000");
+        }
+
+        // Other tests emit on the same global channel; only count ours.
+        let mut ours = Vec::new();
+        while let Ok(event) = rx.try_recv() {
+            if event.kind == EventKind::RateLimitExceeded
+                && event.attributes["protocol"] == "test"
+            {
+                ours.push(event);
+            }
+        }
+        assert_eq!(ours.len(), 1, "denials within the window must not emit");
+        assert_eq!(ours[0].attributes["count"], "1");
+    }
+
+    #[test]
+    fn test_circuit_transition_maps_kind() {
+        let mut rx = subscribe();
+        circuit_transition("b:1", CircuitState::Closed, CircuitState::Open);
+
+        let event = loop {
+            let event = rx.try_recv().expect("transition should emit an event");
+            if event.backend == "b:1" {
+                break event;
+            }
+        };
+        assert_eq!(event.kind, EventKind::CircuitOpened);
+        assert_eq!(event.attributes["from"], "Closed");
+    }
+}
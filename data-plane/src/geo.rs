@@ -0,0 +1,163 @@
+use std::net::IpAddr;
+use std::sync::Arc;
+
+use crate::config::{Backend, GeoRoute};
+use crate::load_balancer::LoadBalancer;
+
+/// Picks the backends of a connection from the client's address, by the
+/// networks the control plane resolved each route's countries and
+/// continents to. Rebuilt on every config push and backend reload.
+pub struct GeoRouter {
+    /// First and last address of each network with the index of its
+    /// route, sorted by first address
+    v4: Vec<(u32, u32, usize)>,
+    v6: Vec<(u128, u128, usize)>,
+    names: Vec<String>,
+    load_balancers: Vec<Arc<LoadBalancer>>,
+}
+
+impl GeoRouter {
+    /// A router over routes, each balanced by a load balancer build makes
+    /// from its backends. Networks that don't parse are left out;
+    /// validation rejects them before they get here.
+    pub fn new(routes: &[GeoRoute], build: impl Fn(Vec<Backend>) -> LoadBalancer) -> Self {
+        let mut v4 = Vec::new();
+        let mut v6 = Vec::new();
+        let mut names = Vec::with_capacity(routes.len());
+        let mut load_balancers = Vec::with_capacity(routes.len());
+        for (i, route) in routes.iter().enumerate() {
+            for network in &route.networks {
+                match parse_network(network) {
+                    Some((IpAddr::V4(addr), bits)) => {
+                        let (first, last) = bounds(u32::from(addr) as u128, bits, 32);
+                        v4.push((first as u32, last as u32, i));
+                    }
+                    Some((IpAddr::V6(addr), bits)) => {
+                        let (first, last) = bounds(u128::from(addr), bits, 128);
+                        v6.push((first, last, i));
+                    }
+                    None => {}
+                }
+            }
+            names.push(route.name.clone());
+            load_balancers.push(Arc::new(build(route.backends.clone())));
+        }
+        v4.sort_unstable();
+        v6.sort_unstable();
+        Self {
+            v4,
+            v6,
+            names,
+            load_balancers,
+        }
+    }
+
+    pub fn empty() -> Self {
+        Self {
+            v4: Vec::new(),
+            v6: Vec::new(),
+            names: Vec::new(),
+            load_balancers: Vec::new(),
+        }
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.v4.is_empty() && self.v6.is_empty()
+    }
+
+    /// The name and load balancer of the route whose networks hold client,
+    /// an IPv4-mapped IPv6 address counting as the IPv4 one.
+    pub fn route(&self, client: IpAddr) -> Option<(&str, Arc<LoadBalancer>)> {
+        let route = match client.to_canonical() {
+            IpAddr::V4(addr) => find(&self.v4, u32::from(addr)),
+            IpAddr::V6(addr) => find(&self.v6, u128::from(addr)),
+        }?;
+        Some((&self.names[route], self.load_balancers[route].clone()))
+    }
+}
+
+/// The route of the network in ranges holding addr; the networks never
+/// overlap, so only the last starting at or before addr can.
+fn find<T: Ord + Copy>(ranges: &[(T, T, usize)], addr: T) -> Option<usize> {
+    let i = ranges.partition_point(|&(first, _, _)| first <= addr);
+    let (_, last, route) = *ranges.get(i.checked_sub(1)?)?;
+    (addr <= last).then_some(route)
+}
+
+/// The first and last address of the bits-long prefix of addr, in an
+/// address space width bits wide.
+fn bounds(addr: u128, bits: u8, width: u8) -> (u128, u128) {
+    let host = width - bits;
+    let mask = if host == 0 { 0 } else { u128::MAX >> (128 - host) };
+    (addr & !mask, addr | mask)
+}
+
+/// Parses a CIDR block such as "10.0.0.0/8" or "2001:db8::/32".
+pub fn parse_network(network: &str) -> Option<(IpAddr, u8)> {
+    let (addr, bits) = network.split_once('/')?;
+    let addr: IpAddr = addr.parse().ok()?;
+    let bits: u8 = bits.parse().ok()?;
+    let width = if addr.is_ipv4() { 32 } else { 128 };
+    (bits <= width).then_some((addr, bits))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn backend(address: &str) -> Backend {
+        Backend {
+            address: address.to_string(),
+            weight: 100,
+            healthy: true,
+            priority: 0,
+            backup: false,
+            zone: String::new(),
+            region: String::new(),
+        }
+    }
+
+    fn route(name: &str, networks: &[&str], address: &str) -> GeoRoute {
+        GeoRoute {
+            name: name.to_string(),
+            networks: networks.iter().map(|n| n.to_string()).collect(),
+            backends: vec![backend(address)],
+        }
+    }
+
+    fn routed(router: &GeoRouter, client: &str) -> Option<String> {
+        router
+            .route(client.parse().unwrap())
+            .map(|(name, lb)| format!("{}={}", name, lb.select_backend().unwrap().address))
+    }
+
+    #[test]
+    fn test_route_by_client_network() {
+        let router = GeoRouter::new(
+            &[
+                route("eu", &["1.2.4.0/23", "2001:db8::/32", "5.0.0.0/8"], "eu:443"),
+                route("us", &["8.8.8.0/24", "0.0.0.0/32"], "us:443"),
+            ],
+            |backends| LoadBalancer::new(backends, "round_robin".to_string()),
+        );
+        assert!(!router.is_empty());
+        assert_eq!(routed(&router, "1.2.5.255"), Some("eu=eu:443".to_string()));
+        assert_eq!(routed(&router, "5.255.0.1"), Some("eu=eu:443".to_string()));
+        assert_eq!(routed(&router, "2001:db8:ffff::1"), Some("eu=eu:443".to_string()));
+        assert_eq!(routed(&router, "::ffff:8.8.8.8"), Some("us=us:443".to_string()));
+        assert_eq!(routed(&router, "0.0.0.0"), Some("us=us:443".to_string()));
+        assert_eq!(routed(&router, "1.2.3.255"), None);
+        assert_eq!(routed(&router, "1.2.6.0"), None);
+        assert_eq!(routed(&router, "2001:db9::1"), None);
+        assert!(GeoRouter::empty().is_empty());
+    }
+
+    #[test]
+    fn test_parse_network() {
+        assert_eq!(parse_network("10.0.0.0/8"), Some(("10.0.0.0".parse().unwrap(), 8)));
+        assert_eq!(parse_network("::/0"), Some(("::".parse().unwrap(), 0)));
+        assert_eq!(parse_network("10.0.0.0/33"), None);
+        assert_eq!(parse_network("10.0.0.0"), None);
+        assert_eq!(parse_network("eu/8"), None);
+    }
+}
@@ -1,120 +1,700 @@
+use std::net::SocketAddr;
 use std::sync::atomic::Ordering;
-use std::sync::Arc;
-use std::time::{SystemTime, UNIX_EPOCH};
+use std::sync::{Arc, OnceLock};
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
 
 use futures::{stream::BoxStream, StreamExt};
+use parking_lot::Mutex;
+use tokio::sync::broadcast::error::RecvError;
 use tokio_stream::wrappers::ReceiverStream;
+use tonic::codec::CompressionEncoding;
 use tonic::{Request, Response, Status};
 use tracing::{info, warn};
 
-use crate::config::{proxy, Backend, ProxyConfig, ProxyState};
+use crate::access_log;
+use crate::circuit_breaker::CircuitState;
+use crate::events::{self, EventKind};
+use crate::log_control;
+use crate::metrics::HistogramSnapshot;
+use crate::load_balancer::{Locality, DEFAULT_MAGLEV_TABLE_SIZE, DEFAULT_VIRTUAL_NODES};
+use crate::config::{
+    proxy, Backend, ConnectionInfo, ConnectionLimit, DarkLaunch, GeoRoute, HeaderMatch, HeaderRule,
+    Mirror, ProxyConfig, ProxyState, SniRoute, StickyCookie, UdpAffinity,
+};
+use crate::geo;
+use crate::header_rules;
+use crate::tls;
 
-pub struct ProxyControlService {
-    state: Arc<ProxyState>,
+fn unix_millis() -> i64 {
+    SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .unwrap_or_default()
+        .as_millis() as i64
 }
 
-impl ProxyControlService {
-    pub fn new(state: Arc<ProxyState>) -> Self {
-        Self { state }
-    }
+const DEFAULT_MAX_MESSAGE_BYTES: usize = 16 * 1024 * 1024;
 
-    pub fn into_service(self) -> proxy::proxy_control_server::ProxyControlServer<Self> {
-        proxy::proxy_control_server::ProxyControlServer::new(self)
-    }
+/// This data plane's ID in metrics snapshots: AEGIS_INSTANCE_ID, else the
+/// hostname (the pod name under Kubernetes), else empty for the control
+/// plane to fill in.
+fn instance_id() -> &'static str {
+    static ID: OnceLock<String> = OnceLock::new();
+    ID.get_or_init(|| {
+        std::env::var("AEGIS_INSTANCE_ID")
+            .or_else(|_| std::env::var("HOSTNAME"))
+            .unwrap_or_default()
+    })
 }
 
-#[tonic::async_trait]
-impl proxy::proxy_control_server::ProxyControl for ProxyControlService {
-    async fn update_config(
-        &self,
-        request: Request<proxy::ProxyConfig>,
-    ) -> Result<Response<proxy::ConfigAck>, Status> {
-        let pb_config = request.into_inner();
+/// The value of the environment variable var, if set, else pushed: a data
+/// plane's own AEGIS_ZONE and AEGIS_REGION win over the control plane's.
+fn env_or(var: &str, pushed: &str) -> String {
+    std::env::var(var)
+        .ok()
+        .filter(|v| !v.is_empty())
+        .unwrap_or_else(|| pushed.to_string())
+}
 
-        info!("Received configuration update");
+/// The shadow backends of a pushed config or backend list; unset is off.
+fn mirror_from_pb(mirror: Option<&proxy::MirrorConfig>) -> Mirror {
+    mirror
+        .map(|m| Mirror {
+            backends: m.backends.clone(),
+            percent: m.percent.clamp(0, 100) as u32,
+        })
+        .unwrap_or_default()
+}
 
-        // Convert protobuf config to internal config
-        let config = ProxyConfig {
-            tcp_address: pb_config
-                .listen
-                .as_ref()
-                .map(|l| l.tcp_address.clone())
-                .unwrap_or_default(),
-            udp_address: pb_config
-                .listen
-                .as_ref()
-                .map(|l| l.udp_address.clone())
-                .unwrap_or_default(),
-            backends: pb_config
-                .backends
-                .iter()
-                .map(|b| Backend {
-                    address: b.address.clone(),
-                    weight: b.weight,
-                    healthy: b.healthy,
-                })
-                .collect(),
-            udp_backends: pb_config
-                .udp_backends
+fn backend_from_pb(b: &proxy::Backend) -> Backend {
+    Backend {
+        address: b.address.clone(),
+        weight: b.weight,
+        healthy: b.healthy,
+        priority: b.priority,
+        backup: b.backup,
+        zone: b.zone.clone(),
+        region: b.region.clone(),
+    }
+}
+
+/// The header rules of a pushed config or backend list, with their
+/// backends' health.
+fn header_rules_from_pb(rules: &[proxy::HeaderRule]) -> Vec<HeaderRule> {
+    rules
+        .iter()
+        .map(|r| HeaderRule {
+            name: r.name.clone(),
+            matches: r
+                .matches
                 .iter()
-                .map(|b| Backend {
-                    address: b.address.clone(),
-                    weight: b.weight,
-                    healthy: b.healthy,
+                .map(|m| HeaderMatch {
+                    header: m.header.clone(),
+                    exact: m.exact.clone(),
+                    regex: m.regex.clone(),
                 })
                 .collect(),
-            algorithm: pb_config
+            backends: r.backends.iter().map(backend_from_pb).collect(),
+        })
+        .collect()
+}
+
+/// The SNI routes of a pushed config or backend list, with their backends'
+/// health.
+fn sni_routes_from_pb(routes: &[proxy::SniRoute]) -> Vec<SniRoute> {
+    routes
+        .iter()
+        .map(|r| SniRoute {
+            server_names: r.server_names.clone(),
+            backends: r.backends.iter().map(backend_from_pb).collect(),
+        })
+        .collect()
+}
+
+/// The geo routes of a pushed config or backend list, with their backends'
+/// health.
+fn geo_routes_from_pb(routes: &[proxy::GeoRoute]) -> Vec<GeoRoute> {
+    routes
+        .iter()
+        .map(|r| GeoRoute {
+            name: r.name.clone(),
+            networks: r.networks.clone(),
+            backends: r.backends.iter().map(backend_from_pb).collect(),
+        })
+        .collect()
+}
+
+/// The dark launches on now of a pushed config or backend list, with their
+/// backends' health.
+fn dark_launches_from_pb(rules: &[proxy::DarkLaunch]) -> Vec<DarkLaunch> {
+    rules
+        .iter()
+        .map(|r| DarkLaunch {
+            name: r.name.clone(),
+            percent: r.percent.max(0) as u32,
+            backends: r.backends.iter().map(backend_from_pb).collect(),
+        })
+        .collect()
+}
+
+/// Overflow "queue" holds connections over the limit; anything else, the
+/// default "reject" included, closes them.
+fn connection_limit_from_pb(limit: Option<&proxy::ConnectionLimitConfig>) -> ConnectionLimit {
+    limit
+        .map(|l| ConnectionLimit {
+            max: l.max.max(0) as u32,
+            queue: l.overflow == "queue",
+            queue_size: l.queue_size.max(0) as u32,
+            queue_timeout: Duration::from_millis(l.queue_timeout_ms.max(0) as u64),
+        })
+        .unwrap_or_default()
+}
+
+fn udp_affinity_from_pb(affinity: Option<&proxy::UdpAffinityConfig>) -> UdpAffinity {
+    affinity
+        .map(|a| UdpAffinity {
+            mode: a.mode.clone(),
+            idle_timeout: Duration::from_millis(a.idle_timeout_ms.max(0) as u64),
+        })
+        .unwrap_or_default()
+}
+
+fn sticky_cookie_from_pb(cookie: Option<&proxy::StickyCookieConfig>) -> StickyCookie {
+    cookie
+        .map(|c| StickyCookie {
+            name: c.name.clone(),
+            ttl_secs: c.ttl_seconds.max(0) as u32,
+            path: c.path.clone(),
+            secure: c.secure,
+            http_only: c.http_only,
+        })
+        .unwrap_or_default()
+}
+
+/// Features advertised in Hello. The control plane refuses to push a config
+/// that needs anything missing from this list, so add an entry whenever
+/// UpdateConfig learns to honour a new setting.
+const FEATURES: &[&str] = &[
+    "lb:round_robin",
+    "lb:weighted_round_robin",
+    "lb:weighted",
+    "lb:least_connections",
+    "lb:consistent_hash",
+    "lb:random",
+    "lb:latency_ewma",
+    "hash_key:header",
+    "hash_key:cookie",
+    "hash_method:maglev",
+    "backend_priority",
+    "backend_backup",
+    "locality:prefer_local",
+    "mirror",
+    "header_rules",
+    "sni_routes",
+    "geo_routes",
+    "dark_launches",
+    "session_affinity",
+    "udp_affinity",
+    "sticky_cookie",
+    "udp",
+    "read_timeout",
+    "get_stats",
+    "stream:access_logs",
+    "stream:events",
+    "command:flush_stats",
+    "command:dump_connections",
+    "command:debug_logging",
+    "command:pause_traffic",
+    "command:resume_traffic",
+    "command:circuit_breakers",
+    "command:reset_circuit",
+    "config:two_phase",
+    "update_rate_limit",
+    "connections",
+    "connection_limit",
+    "secrets",
+    "secret_stream",
+    "tls_termination",
+];
+
+fn connection_proto(c: ConnectionInfo) -> proxy::Connection {
+    proxy::Connection {
+        id: c.id,
+        client: c.client,
+        backend: c.backend,
+        age_ms: c.age_ms as i64,
+    }
+}
+
+/// Whether a connection from `client` (ip:port) matches the client_ip
+/// filter. Both sides are parsed so "::1" matches "[::1]:5000".
+fn client_ip_matches(client: &str, filter: &str) -> bool {
+    match (client.parse::<SocketAddr>(), filter.parse::<std::net::IpAddr>()) {
+        (Ok(addr), Ok(ip)) => addr.ip() == ip,
+        _ => false,
+    }
+}
+
+/// Point-in-time metrics, shared by StreamMetrics and GetStats so streamed
+/// and polled snapshots are identical.
+fn metrics_snapshot(state: &ProxyState) -> proxy::MetricsData {
+    let metrics = state.get_metrics();
+    let summary = metrics.get_summary();
+
+    let active_connections = summary.active_tcp_connections + summary.active_udp_sessions;
+    let total_connections = summary.tcp_connections + summary.udp_sessions;
+
+    let circuit_breaker = state.circuit_breaker.read().clone();
+    let ejected_backends = circuit_breaker
+        .get_all_states()
+        .values()
+        .filter(|(s, _)| *s == CircuitState::Open)
+        .count();
+    let (rate_limit_tokens, rate_limit_capacity) = state.rate_limiter.read().get_global_stats();
+    let backend_metrics = metrics
+        .get_backend_metrics()
+        .into_iter()
+        .map(|(address, backend)| {
+            let circuit_state = circuit_breaker
+                .get_state(&address)
+                .map(|s| format!("{:?}", s))
+                .unwrap_or_else(|| "unknown".to_string());
+            proxy::BackendMetrics {
+                address,
+                active_connections: backend.connections.load(Ordering::Relaxed) as i64,
+                total_requests: backend.requests.load(Ordering::Relaxed) as i64,
+                failed_requests: backend.failures.load(Ordering::Relaxed) as i64,
+                avg_latency_ms: 0.0,
+                circuit_state,
+                connect_latency: Some(histogram_to_proto(backend.latency.snapshot())),
+                connection_duration: Some(generic_histogram_to_proto(
+                    backend.connection_duration.snapshot(),
+                )),
+                request_bytes: Some(generic_histogram_to_proto(backend.request_bytes.snapshot())),
+                response_bytes: Some(generic_histogram_to_proto(backend.response_bytes.snapshot())),
+                connect_failures: Some(backend.connect_failures.load(Ordering::Relaxed) as i64),
+            }
+        })
+        .collect();
+
+    let timestamp = unix_millis();
+
+    proxy::MetricsData {
+        active_connections: active_connections as i64,
+        total_connections: total_connections as i64,
+        bytes_sent: summary.bytes_sent as i64,
+        bytes_received: summary.bytes_received as i64,
+        avg_latency_ms: summary.latency.avg,
+        p99_latency_ms: summary.latency.p99,
+        backend_metrics,
+        timestamp,
+        connect_latency: Some(histogram_to_proto(metrics.latency_histogram())),
+        instance_id: instance_id().to_string(),
+        rate_limit_allowed: summary.rate_limit_allowed as i64,
+        rate_limit_denied: summary.rate_limit_denied as i64,
+        rate_limit_tokens: rate_limit_tokens as i64,
+        rate_limit_capacity: rate_limit_capacity as i64,
+        circuit_breaker_opens: summary.circuit_breaker_open as i64,
+        circuit_breaker_half_opens: summary.circuit_breaker_half_open as i64,
+        ejected_backends: ejected_backends as i64,
+        connection_limit_rejected: summary.connection_limit_rejected as i64,
+        connection_queue_timeouts: summary.connection_queue_timeouts as i64,
+        connections_queued: state.connection_limiter.queued() as i64,
+        connection_limit: state.connection_limiter.limit() as i64,
+    }
+}
+
+fn histogram_to_proto(h: HistogramSnapshot) -> proxy::LatencyHistogram {
+    proxy::LatencyHistogram {
+        bounds_ms: h.bounds.to_vec(),
+        counts: h.counts,
+        count: h.count,
+        sum_ms: h.sum,
+        // The data plane doesn't trace connections yet, so it has no
+        // exemplars to offer
+        exemplars: Vec::new(),
+    }
+}
+
+fn generic_histogram_to_proto(h: HistogramSnapshot) -> proxy::Histogram {
+    proxy::Histogram {
+        bounds: h.bounds.to_vec(),
+        counts: h.counts,
+        count: h.count,
+        sum: h.sum,
+        exemplars: Vec::new(),
+    }
+}
+
+/// Convert a pushed protobuf config to the internal representation.
+fn config_from_proto(pb_config: &proxy::ProxyConfig) -> ProxyConfig {
+    ProxyConfig {
+        tcp_address: pb_config
+            .listen
+            .as_ref()
+            .map(|l| l.tcp_address.clone())
+            .unwrap_or_default(),
+        udp_address: pb_config
+            .listen
+            .as_ref()
+            .map(|l| l.udp_address.clone())
+            .unwrap_or_default(),
+        backends: pb_config.backends.iter().map(backend_from_pb).collect(),
+        udp_backends: pb_config.udp_backends.iter().map(backend_from_pb).collect(),
+        algorithm: pb_config
+            .load_balancing
+            .as_ref()
+            .map(|lb| lb.algorithm.clone())
+            .unwrap_or_else(|| "round_robin".to_string()),
+        session_affinity: pb_config
+            .load_balancing
+            .as_ref()
+            .map(|lb| lb.session_affinity)
+            .unwrap_or(false),
+        hash_key: pb_config
+            .load_balancing
+            .as_ref()
+            .and_then(|lb| lb.hash.as_ref())
+            .map(|h| h.key.clone())
+            .unwrap_or_default(),
+        hash_key_name: pb_config
+            .load_balancing
+            .as_ref()
+            .and_then(|lb| lb.hash.as_ref())
+            .map(|h| h.name.clone())
+            .unwrap_or_default(),
+        virtual_nodes: pb_config
+            .load_balancing
+            .as_ref()
+            .and_then(|lb| lb.hash.as_ref())
+            .map(|h| h.virtual_nodes)
+            .filter(|&n| n > 0)
+            .map(|n| n as u32)
+            .unwrap_or(DEFAULT_VIRTUAL_NODES),
+        hash_method: pb_config
+            .load_balancing
+            .as_ref()
+            .and_then(|lb| lb.hash.as_ref())
+            .map(|h| h.method.clone())
+            .unwrap_or_default(),
+        maglev_table_size: pb_config
+            .load_balancing
+            .as_ref()
+            .and_then(|lb| lb.hash.as_ref())
+            .map(|h| h.table_size)
+            .filter(|&n| n > 0)
+            .map(|n| n as u32)
+            .unwrap_or(DEFAULT_MAGLEV_TABLE_SIZE),
+        locality_policy: pb_config
+            .load_balancing
+            .as_ref()
+            .and_then(|lb| lb.locality.as_ref())
+            .map(|l| l.policy.clone())
+            .unwrap_or_default(),
+        locality: pb_config
+            .load_balancing
+            .as_ref()
+            .and_then(|lb| lb.locality.as_ref())
+            .map(|l| Locality {
+                zone: env_or("AEGIS_ZONE", &l.zone),
+                region: env_or("AEGIS_REGION", &l.region),
+                spillover_percent: l.spillover_percent.clamp(0, 100) as u32,
+                min_healthy_percent: l.min_healthy_percent.clamp(0, 100) as u32,
+            })
+            .unwrap_or_default(),
+        udp_affinity: udp_affinity_from_pb(
+            pb_config
                 .load_balancing
                 .as_ref()
-                .map(|lb| lb.algorithm.clone())
-                .unwrap_or_else(|| "round_robin".to_string()),
-            session_affinity: pb_config
+                .and_then(|lb| lb.udp_affinity.as_ref()),
+        ),
+        sticky_cookie: sticky_cookie_from_pb(
+            pb_config
                 .load_balancing
                 .as_ref()
-                .map(|lb| lb.session_affinity)
-                .unwrap_or(false),
-            rate_limit_rps: pb_config
-                .traffic
-                .as_ref()
-                .and_then(|t| t.rate_limit.as_ref())
-                .map(|rl| rl.requests_per_second)
-                .unwrap_or(1000),
-            rate_limit_burst: pb_config
-                .traffic
-                .as_ref()
-                .and_then(|t| t.rate_limit.as_ref())
-                .map(|rl| rl.burst)
-                .unwrap_or(100),
-            connect_timeout_secs: pb_config
-                .traffic
-                .as_ref()
-                .and_then(|t| t.timeout.as_ref())
-                .map(|to| to.connect_seconds)
-                .unwrap_or(5),
-            idle_timeout_secs: pb_config
-                .traffic
-                .as_ref()
-                .and_then(|t| t.timeout.as_ref())
-                .map(|to| to.idle_seconds)
-                .unwrap_or(60),
-            read_timeout_secs: pb_config
+                .and_then(|lb| lb.sticky_cookie.as_ref()),
+        ),
+        mirror: mirror_from_pb(pb_config.mirror.as_ref()),
+        header_rules: header_rules_from_pb(&pb_config.header_rules),
+        sni_routes: sni_routes_from_pb(&pb_config.sni_routes),
+        geo_routes: geo_routes_from_pb(&pb_config.geo_routes),
+        dark_launches: dark_launches_from_pb(&pb_config.dark_launches),
+        tls_enabled: pb_config.tls.as_ref().map(|t| t.enabled).unwrap_or(false),
+        rate_limit_rps: pb_config
+            .traffic
+            .as_ref()
+            .and_then(|t| t.rate_limit.as_ref())
+            .map(|rl| rl.requests_per_second)
+            .unwrap_or(1000),
+        rate_limit_burst: pb_config
+            .traffic
+            .as_ref()
+            .and_then(|t| t.rate_limit.as_ref())
+            .map(|rl| rl.burst)
+            .unwrap_or(100),
+        connection_limit: connection_limit_from_pb(
+            pb_config
                 .traffic
                 .as_ref()
-                .and_then(|t| t.timeout.as_ref())
-                .map(|to| to.read_seconds)
-                .unwrap_or(30),
-            circuit_breaker_threshold: pb_config
-                .circuit_breaker
-                .as_ref()
-                .map(|cb| cb.error_threshold as u32)
-                .unwrap_or(5),
-            circuit_breaker_timeout_secs: pb_config
-                .circuit_breaker
-                .as_ref()
-                .map(|cb| cb.timeout_seconds as u32)
-                .unwrap_or(30),
-        };
+                .and_then(|t| t.connection_limit.as_ref()),
+        ),
+        connect_timeout_secs: pb_config
+            .traffic
+            .as_ref()
+            .and_then(|t| t.timeout.as_ref())
+            .map(|to| to.connect_seconds)
+            .unwrap_or(5),
+        idle_timeout_secs: pb_config
+            .traffic
+            .as_ref()
+            .and_then(|t| t.timeout.as_ref())
+            .map(|to| to.idle_seconds)
+            .unwrap_or(60),
+        read_timeout_secs: pb_config
+            .traffic
+            .as_ref()
+            .and_then(|t| t.timeout.as_ref())
+            .map(|to| to.read_seconds)
+            .unwrap_or(30),
+        circuit_breaker_threshold: pb_config
+            .circuit_breaker
+            .as_ref()
+            .map(|cb| cb.error_threshold as u32)
+            .unwrap_or(5),
+        circuit_breaker_timeout_secs: pb_config
+            .circuit_breaker
+            .as_ref()
+            .map(|cb| cb.timeout_seconds as u32)
+            .unwrap_or(30),
+    }
+}
 
+/// Checks PrepareConfig runs before staging a config: everything here would
+/// otherwise only surface once traffic hits the new config.
+fn validate_config(config: &ProxyConfig) -> Result<(), String> {
+    let mut errs = Vec::new();
+
+    if !config.tcp_address.is_empty() && config.tcp_address.parse::<SocketAddr>().is_err() {
+        errs.push(format!("invalid tcp listen address {:?}", config.tcp_address));
+    }
+    if !config.udp_address.is_empty() && config.udp_address.parse::<SocketAddr>().is_err() {
+        errs.push(format!("invalid udp listen address {:?}", config.udp_address));
+    }
+    let lb_feature = format!("lb:{}", config.algorithm);
+    if !FEATURES.contains(&lb_feature.as_str()) {
+        errs.push(format!("unsupported load balancing algorithm {:?}", config.algorithm));
+    }
+    match config.hash_key.as_str() {
+        "" | "source_ip" => {}
+        key if FEATURES.contains(&format!("hash_key:{}", key).as_str()) => {
+            if config.hash_key_name.is_empty() {
+                errs.push(format!("hash key {} needs a name", key));
+            }
+        }
+        key => errs.push(format!("unsupported hash key {:?}", key)),
+    }
+    match config.hash_method.as_str() {
+        "" | "ring" => {}
+        "maglev" => {
+            if !is_prime(config.maglev_table_size) {
+                errs.push(format!(
+                    "maglev table size {} is not prime",
+                    config.maglev_table_size
+                ));
+            }
+        }
+        method => errs.push(format!("unsupported hash method {:?}", method)),
+    }
+    match config.locality_policy.as_str() {
+        "" => {}
+        policy if FEATURES.contains(&format!("locality:{}", policy).as_str()) => {
+            if config.locality.zone.is_empty() && config.locality.region.is_empty() {
+                errs.push(format!(
+                    "locality policy {} needs this data plane's zone or region: set AEGIS_ZONE or AEGIS_REGION",
+                    policy
+                ));
+            }
+        }
+        policy => errs.push(format!("unsupported locality policy {:?}", policy)),
+    }
+    for b in config.backends.iter().chain(config.udp_backends.iter()) {
+        if !is_host_port(&b.address) {
+            errs.push(format!("backend address {:?} must be host:port", b.address));
+        }
+        if b.weight < 0 {
+            errs.push(format!("backend {} has negative weight {}", b.address, b.weight));
+        }
+    }
+    let affinity = &config.udp_affinity;
+    if affinity.enabled() {
+        if affinity.mode != "source_ip_port" && affinity.mode != "source_ip" {
+            errs.push(format!("unknown udp affinity mode {:?}", affinity.mode));
+        }
+        if affinity.idle_timeout.is_zero() {
+            errs.push("udp affinity needs an idle timeout".to_string());
+        }
+    }
+    let cookie = &config.sticky_cookie;
+    if cookie.enabled() {
+        // Anything else would break the Set-Cookie header it goes into
+        let separator = |c: char| c.is_ascii_control() || " ()<>@,;:\\\"/[]?={}".contains(c);
+        if !cookie.name.is_ascii() || cookie.name.contains(separator) {
+            errs.push(format!("invalid sticky cookie name {:?}", cookie.name));
+        }
+        let bad_path = |c: char| c == ';' || c.is_control();
+        if !cookie.path.starts_with('/') || cookie.path.contains(bad_path) {
+            errs.push(format!("invalid sticky cookie path {:?}", cookie.path));
+        }
+        if !config.sni_routes.is_empty() {
+            errs.push("sticky cookies can't be combined with SNI routes".to_string());
+        }
+    }
+    for address in &config.mirror.backends {
+        if !is_host_port(address) {
+            errs.push(format!("mirror backend address {:?} must be host:port", address));
+        }
+    }
+    for rule in &config.header_rules {
+        if rule.matches.is_empty() {
+            errs.push(format!("header rule {} has no matches", rule.name));
+        }
+        for m in rule.matches.iter().filter(|m| !m.regex.is_empty()) {
+            if let Err(e) = header_rules::compile_regex(&m.regex) {
+                errs.push(format!(
+                    "header rule {}: invalid regex {:?}: {}",
+                    rule.name, m.regex, e
+                ));
+            }
+        }
+        for b in &rule.backends {
+            if !is_host_port(&b.address) {
+                errs.push(format!(
+                    "header rule {} backend address {:?} must be host:port",
+                    rule.name, b.address
+                ));
+            }
+        }
+    }
+    for route in &config.sni_routes {
+        if route.server_names.is_empty() || route.server_names.iter().any(String::is_empty) {
+            errs.push("an SNI route needs server names".to_string());
+        }
+        for b in &route.backends {
+            if !is_host_port(&b.address) {
+                errs.push(format!(
+                    "SNI route backend address {:?} must be host:port",
+                    b.address
+                ));
+            }
+        }
+    }
+    for route in &config.geo_routes {
+        if route.networks.is_empty() {
+            errs.push(format!("geo route {} has no networks", route.name));
+        }
+        for network in &route.networks {
+            if geo::parse_network(network).is_none() {
+                errs.push(format!("geo route {}: invalid network {:?}", route.name, network));
+            }
+        }
+        for b in &route.backends {
+            if !is_host_port(&b.address) {
+                errs.push(format!(
+                    "geo route {} backend address {:?} must be host:port",
+                    route.name, b.address
+                ));
+            }
+        }
+    }
+    for rule in &config.dark_launches {
+        if !(1..=100).contains(&rule.percent) {
+            errs.push(format!("dark launch {} percent must be 1 to 100", rule.name));
+        }
+        for b in &rule.backends {
+            if !is_host_port(&b.address) {
+                errs.push(format!(
+                    "dark launch {} backend address {:?} must be host:port",
+                    rule.name, b.address
+                ));
+            }
+        }
+    }
+    let dark_launch_total: u32 = config.dark_launches.iter().map(|r| r.percent).sum();
+    if dark_launch_total > 100 {
+        errs.push(format!("dark launch percents add up to {}, more than 100", dark_launch_total));
+    }
+    if config.rate_limit_rps < 0 || config.rate_limit_burst < 0 {
+        errs.push("rate limit values must not be negative".to_string());
+    }
+    if config.tls_enabled {
+        // The request head and ClientHello they read are encrypted, and
+        // peeking happens before termination
+        let hashes_head = config.algorithm == "consistent_hash"
+            && (config.hash_key == "header" || config.hash_key == "cookie");
+        if !config.header_rules.is_empty()
+            || !config.sni_routes.is_empty()
+            || config.sticky_cookie.enabled()
+            || hashes_head
+        {
+            errs.push(
+                "TLS termination can't be combined with header rules, SNI routes, sticky cookies or header and cookie hash keys"
+                    .to_string(),
+            );
+        }
+    }
+    let limit = &config.connection_limit;
+    if limit.max > 0 && limit.queue && (limit.queue_size == 0 || limit.queue_timeout.is_zero()) {
+        errs.push("a queueing connection limit needs a queue size and timeout".to_string());
+    }
+
+    if errs.is_empty() {
+        Ok(())
+    } else {
+        Err(errs.join("; "))
+    }
+}
+
+fn is_host_port(address: &str) -> bool {
+    address
+        .rsplit_once(':')
+        .map(|(host, port)| !host.is_empty() && port.parse::<u16>().is_ok())
+        .unwrap_or(false)
+}
+
+fn is_prime(n: u32) -> bool {
+    let n = n as u64;
+    n >= 2 && (2..).take_while(|d| d * d <= n).all(|d| n % d != 0)
+}
+
+/// A config accepted by PrepareConfig, waiting for CommitConfig.
+struct StagedConfig {
+    token: String,
+    config: ProxyConfig,
+}
+
+#[derive(Default)]
+struct Staging {
+    /// Only one config is staged at a time; a new Prepare replaces it.
+    staged: Option<StagedConfig>,
+    /// Token of the last commit, so a retried CommitConfig succeeds instead
+    /// of failing on a token that's no longer staged.
+    last_committed: Option<String>,
+    next_id: u64,
+}
+
+pub struct ProxyControlService {
+    state: Arc<ProxyState>,
+    staging: Mutex<Staging>,
+}
+
+impl ProxyControlService {
+    pub fn new(state: Arc<ProxyState>) -> Self {
+        Self {
+            state,
+            staging: Mutex::new(Staging::default()),
+        }
+    }
+
+    fn apply_config(&self, config: ProxyConfig) {
         info!(
             "Configured {} TCP backends and {} UDP backends on TCP:{}, UDP:{}",
             config.backends.len(),
@@ -126,6 +706,64 @@ impl proxy::proxy_control_server::ProxyControl for ProxyControlService {
         // Reset draining state when receiving new configuration
         self.state.reset_draining();
         self.state.update_config(config);
+    }
+
+    /// Wraps the service for tonic. gzip is accepted on requests and used
+    /// for responses whenever the control plane advertises it. Message
+    /// limits default to 16 MiB (tonic's own is 4 MiB, too small for configs
+    /// with thousands of backends) and can be tuned with
+    /// AEGIS_GRPC_MAX_MESSAGE_BYTES; keep it in line with the control
+    /// plane's grpc.max_send_msg_size / max_recv_msg_size.
+    pub fn into_service(self) -> proxy::proxy_control_server::ProxyControlServer<Self> {
+        let max_message_bytes: usize = std::env::var("AEGIS_GRPC_MAX_MESSAGE_BYTES")
+            .ok()
+            .and_then(|v| v.parse().ok())
+            .unwrap_or(DEFAULT_MAX_MESSAGE_BYTES);
+
+        proxy::proxy_control_server::ProxyControlServer::new(self)
+            .accept_compressed(CompressionEncoding::Gzip)
+            .send_compressed(CompressionEncoding::Gzip)
+            .max_decoding_message_size(max_message_bytes)
+            .max_encoding_message_size(max_message_bytes)
+    }
+}
+
+#[tonic::async_trait]
+impl proxy::proxy_control_server::ProxyControl for ProxyControlService {
+    async fn hello(
+        &self,
+        request: Request<proxy::HelloRequest>,
+    ) -> Result<Response<proxy::HelloResponse>, Status> {
+        let req = request.into_inner();
+        let unknown: Vec<&str> = req
+            .features
+            .iter()
+            .map(String::as_str)
+            .filter(|f| !FEATURES.contains(f))
+            .collect();
+        info!(
+            "Handshake from control plane {} ({} features, {} unsupported here: {:?})",
+            req.version,
+            req.features.len(),
+            unknown.len(),
+            unknown
+        );
+
+        Ok(Response::new(proxy::HelloResponse {
+            version: env!("CARGO_PKG_VERSION").to_string(),
+            features: FEATURES.iter().map(|f| f.to_string()).collect(),
+        }))
+    }
+
+    async fn update_config(
+        &self,
+        request: Request<proxy::ProxyConfig>,
+    ) -> Result<Response<proxy::ConfigAck>, Status> {
+        let pb_config = request.into_inner();
+
+        info!("Received configuration update");
+
+        self.apply_config(config_from_proto(&pb_config));
 
         Ok(Response::new(proxy::ConfigAck {
             success: true,
@@ -133,6 +771,94 @@ impl proxy::proxy_control_server::ProxyControl for ProxyControlService {
         }))
     }
 
+    async fn prepare_config(
+        &self,
+        request: Request<proxy::ProxyConfig>,
+    ) -> Result<Response<proxy::PrepareResponse>, Status> {
+        let config = config_from_proto(&request.into_inner());
+        if let Err(reason) = validate_config(&config) {
+            warn!("Rejected staged configuration: {}", reason);
+            return Ok(Response::new(proxy::PrepareResponse {
+                success: false,
+                message: reason,
+                token: String::new(),
+            }));
+        }
+
+        let mut staging = self.staging.lock();
+        staging.next_id += 1;
+        let token = format!("{}-{}", unix_millis(), staging.next_id);
+        if let Some(previous) = staging.staged.replace(StagedConfig {
+            token: token.clone(),
+            config,
+        }) {
+            info!("Discarding staged configuration {}", previous.token);
+        }
+        info!("Staged configuration {}", token);
+
+        Ok(Response::new(proxy::PrepareResponse {
+            success: true,
+            message: "Configuration staged".to_string(),
+            token,
+        }))
+    }
+
+    async fn commit_config(
+        &self,
+        request: Request<proxy::ConfigToken>,
+    ) -> Result<Response<proxy::ConfigAck>, Status> {
+        let token = request.into_inner().token;
+        let config = {
+            let mut staging = self.staging.lock();
+            if staging.last_committed.as_deref() == Some(token.as_str()) {
+                return Ok(Response::new(proxy::ConfigAck {
+                    success: true,
+                    message: "Configuration already committed".to_string(),
+                }));
+            }
+            match staging.staged.take() {
+                Some(staged) if staged.token == token => {
+                    staging.last_committed = Some(token.clone());
+                    staged.config
+                }
+                other => {
+                    staging.staged = other;
+                    return Err(Status::failed_precondition(format!(
+                        "no staged configuration with token {}",
+                        token
+                    )));
+                }
+            }
+        };
+
+        info!("Committing staged configuration {}", token);
+        self.apply_config(config);
+
+        Ok(Response::new(proxy::ConfigAck {
+            success: true,
+            message: "Configuration updated successfully".to_string(),
+        }))
+    }
+
+    async fn abort_config(
+        &self,
+        request: Request<proxy::ConfigToken>,
+    ) -> Result<Response<proxy::ConfigAck>, Status> {
+        let token = request.into_inner().token;
+        let mut staging = self.staging.lock();
+        // Aborting a token that isn't staged (already aborted, or replaced)
+        // is a no-op so the control plane can retry freely.
+        if staging.staged.as_ref().is_some_and(|s| s.token == token) {
+            staging.staged = None;
+            info!("Aborted staged configuration {}", token);
+        }
+
+        Ok(Response::new(proxy::ConfigAck {
+            success: true,
+            message: "Staged configuration discarded".to_string(),
+        }))
+    }
+
     async fn reload_backends(
         &self,
         request: Request<proxy::BackendList>,
@@ -146,15 +872,12 @@ impl proxy::proxy_control_server::ProxyControl for ProxyControlService {
             .get_config()
             .ok_or_else(|| Status::failed_precondition("Proxy not configured"))?;
 
-        config.backends = backend_list
-            .backends
-            .iter()
-            .map(|b| Backend {
-                address: b.address.clone(),
-                weight: b.weight,
-                healthy: b.healthy,
-            })
-            .collect();
+        config.backends = backend_list.backends.iter().map(backend_from_pb).collect();
+        config.mirror = mirror_from_pb(backend_list.mirror.as_ref());
+        config.header_rules = header_rules_from_pb(&backend_list.header_rules);
+        config.sni_routes = sni_routes_from_pb(&backend_list.sni_routes);
+        config.geo_routes = geo_routes_from_pb(&backend_list.geo_routes);
+        config.dark_launches = dark_launches_from_pb(&backend_list.dark_launches);
 
         self.state.update_config(config);
 
@@ -165,6 +888,134 @@ impl proxy::proxy_control_server::ProxyControl for ProxyControlService {
         }))
     }
 
+    async fn update_rate_limit(
+        &self,
+        request: Request<proxy::RateLimitConfig>,
+    ) -> Result<Response<proxy::ConfigAck>, Status> {
+        let limit = request.into_inner();
+        if limit.requests_per_second < 0 || limit.burst < 0 {
+            return Err(Status::invalid_argument(
+                "rate limit values must not be negative",
+            ));
+        }
+
+        self.state
+            .update_rate_limit(limit.requests_per_second, limit.burst)
+            .map_err(Status::failed_precondition)?;
+        info!(
+            "Rate limit set to {} rps, burst {}",
+            limit.requests_per_second, limit.burst
+        );
+
+        Ok(Response::new(proxy::ConfigAck {
+            success: true,
+            message: "Rate limit updated".to_string(),
+        }))
+    }
+
+    async fn update_secrets(
+        &self,
+        request: Request<proxy::SecretSet>,
+    ) -> Result<Response<proxy::ConfigAck>, Status> {
+        let set = request.into_inner();
+        // All or nothing, unlike a streamed update
+        tls::acceptor(&set.tls_certificates).map_err(Status::invalid_argument)?;
+        self.state.replace_certificates(&set.tls_certificates);
+        info!(
+            "TLS certificates replaced: {:?}",
+            set.tls_certificates.iter().map(|c| c.name.as_str()).collect::<Vec<_>>()
+        );
+
+        Ok(Response::new(proxy::ConfigAck {
+            success: true,
+            message: "Secrets updated".to_string(),
+        }))
+    }
+
+    type StreamSecretsStream = BoxStream<'static, Result<proxy::SecretAck, Status>>;
+
+    async fn stream_secrets(
+        &self,
+        request: Request<tonic::Streaming<proxy::SecretUpdate>>,
+    ) -> Result<Response<Self::StreamSecretsStream>, Status> {
+        let mut updates = request.into_inner();
+        let (tx, rx) = tokio::sync::mpsc::channel(16);
+        let state = self.state.clone();
+
+        tokio::spawn(async move {
+            loop {
+                let update = match updates.message().await {
+                    Ok(Some(update)) => update,
+                    Ok(None) => break,
+                    Err(e) => {
+                        warn!("Secret stream closed: {}", e);
+                        break;
+                    }
+                };
+                let ack = state.apply_secrets(&update);
+                let rejected: Vec<&str> = ack
+                    .secrets
+                    .iter()
+                    .filter(|s| !s.accepted)
+                    .map(|s| s.name.as_str())
+                    .collect();
+                info!(
+                    "Secret update {}: {} sent, {} removed, rejected {:?}",
+                    update.nonce,
+                    update.secrets.len(),
+                    update.removed.len(),
+                    rejected
+                );
+                if tx.send(Ok(ack)).await.is_err() {
+                    break;
+                }
+            }
+        });
+
+        Ok(Response::new(ReceiverStream::new(rx).boxed()))
+    }
+
+    async fn list_connections(
+        &self,
+        request: Request<proxy::ListConnectionsRequest>,
+    ) -> Result<Response<proxy::ConnectionList>, Status> {
+        let filter = request.into_inner();
+        if !filter.client_ip.is_empty() && filter.client_ip.parse::<std::net::IpAddr>().is_err() {
+            return Err(Status::invalid_argument(format!(
+                "invalid client_ip {:?}",
+                filter.client_ip
+            )));
+        }
+
+        let connections = self
+            .state
+            .list_connections()
+            .into_iter()
+            .filter(|c| filter.backend.is_empty() || c.backend == filter.backend)
+            .filter(|c| filter.client_ip.is_empty() || client_ip_matches(&c.client, &filter.client_ip))
+            .map(connection_proto)
+            .collect();
+        Ok(Response::new(proxy::ConnectionList { connections }))
+    }
+
+    async fn close_connection(
+        &self,
+        request: Request<proxy::CloseConnectionRequest>,
+    ) -> Result<Response<proxy::CloseConnectionResponse>, Status> {
+        let id = request.into_inner().id;
+        let closed = self
+            .state
+            .close_connection(id)
+            .ok_or_else(|| Status::not_found(format!("no active connection {}", id)))?;
+        info!(
+            "Closing connection {} from {} to {:?} on operator request",
+            id, closed.client, closed.backend
+        );
+        Ok(Response::new(proxy::CloseConnectionResponse {
+            connection: Some(connection_proto(closed)),
+        }))
+    }
+
     async fn drain_connections(
         &self,
         request: Request<proxy::DrainRequest>,
@@ -202,6 +1053,122 @@ impl proxy::proxy_control_server::ProxyControl for ProxyControlService {
         }))
     }
 
+    async fn get_stats(
+        &self,
+        _request: Request<()>,
+    ) -> Result<Response<proxy::MetricsData>, Status> {
+        Ok(Response::new(metrics_snapshot(&self.state)))
+    }
+
+    async fn execute_command(
+        &self,
+        request: Request<proxy::CommandRequest>,
+    ) -> Result<Response<proxy::CommandResponse>, Status> {
+        let req = request.into_inner();
+        info!("Operator command: {} {:?}", req.name, req.args);
+
+        let response = match req.name.as_str() {
+            "flush_stats" => {
+                self.state.metrics.flush_latency_samples();
+                proxy::CommandResponse {
+                    message: "latency samples flushed".to_string(),
+                    output: String::new(),
+                }
+            }
+            "dump_connections" => {
+                let conns = self.state.list_connections();
+                let output = serde_json::to_string(&conns)
+                    .map_err(|e| Status::internal(format!("failed to encode connections: {}", e)))?;
+                proxy::CommandResponse {
+                    message: format!("{} active connections", conns.len()),
+                    output,
+                }
+            }
+            "debug_logging" => match req.args.get("enabled").map(String::as_str) {
+                None | Some("true") => {
+                    let filter = req.args.get("filter").map(String::as_str).unwrap_or("debug");
+                    log_control::set_filter(filter).map_err(Status::invalid_argument)?;
+                    proxy::CommandResponse {
+                        message: format!("log filter set to {:?}", filter),
+                        output: String::new(),
+                    }
+                }
+                Some("false") => {
+                    let filter = log_control::reset().map_err(Status::failed_precondition)?;
+                    proxy::CommandResponse {
+                        message: format!("log filter restored to {:?}", filter),
+                        output: String::new(),
+                    }
+                }
+                Some(other) => {
+                    return Err(Status::invalid_argument(format!(
+                        "enabled must be \"true\" or \"false\", got {:?}",
+                        other
+                    )))
+                }
+            },
+            "pause_traffic" => {
+                let was_paused = self.state.set_paused(true);
+                proxy::CommandResponse {
+                    message: if was_paused {
+                        "traffic already paused".to_string()
+                    } else {
+                        format!(
+                            "traffic paused, {} active connections kept",
+                            self.state.active_connection_count()
+                        )
+                    },
+                    output: String::new(),
+                }
+            }
+            "resume_traffic" => {
+                let was_paused = self.state.set_paused(false);
+                proxy::CommandResponse {
+                    message: if was_paused {
+                        "traffic resumed".to_string()
+                    } else {
+                        "traffic was not paused".to_string()
+                    },
+                    output: String::new(),
+                }
+            }
+            "circuit_breakers" => {
+                let breakers = self.state.circuit_breaker.read().clone().snapshot();
+                let output = serde_json::to_string(&breakers).map_err(|e| {
+                    Status::internal(format!("failed to encode circuit breakers: {}", e))
+                })?;
+                proxy::CommandResponse {
+                    message: format!("{} circuit breakers", breakers.len()),
+                    output,
+                }
+            }
+            "reset_circuit" => {
+                let backend = req
+                    .args
+                    .get("backend")
+                    .ok_or_else(|| Status::invalid_argument("backend is required"))?;
+                if !self.state.circuit_breaker.read().clone().reset_backend(backend) {
+                    return Err(Status::not_found(format!(
+                        "no circuit breaker for backend {:?}",
+                        backend
+                    )));
+                }
+                proxy::CommandResponse {
+                    message: format!("circuit breaker for {} closed", backend),
+                    output: String::new(),
+                }
+            }
+            other => {
+                return Err(Status::invalid_argument(format!(
+                    "unknown command {:?}",
+                    other
+                )))
+            }
+        };
+
+        Ok(Response::new(response))
+    }
+
     type StreamMetricsStream = BoxStream<'static, Result<proxy::MetricsData, Status>>;
 
     async fn stream_metrics(
@@ -217,48 +1184,7 @@ impl proxy::proxy_control_server::ProxyControl for ProxyControlService {
             loop {
                 interval.tick().await;
 
-                let metrics = state.get_metrics();
-                let summary = metrics.get_summary();
-
-                let active_connections =
-                    summary.active_tcp_connections + summary.active_udp_sessions;
-                let total_connections = summary.tcp_connections + summary.udp_sessions;
-
-                let circuit_breaker = state.circuit_breaker.read().clone();
-                let backend_metrics = metrics
-                    .get_backend_metrics()
-                    .into_iter()
-                    .map(|(address, backend)| {
-                        let circuit_state = circuit_breaker
-                            .get_state(&address)
-                            .map(|s| format!("{:?}", s))
-                            .unwrap_or_else(|| "unknown".to_string());
-                        proxy::BackendMetrics {
-                            address,
-                            active_connections: backend.connections.load(Ordering::Relaxed) as i64,
-                            total_requests: backend.requests.load(Ordering::Relaxed) as i64,
-                            failed_requests: backend.failures.load(Ordering::Relaxed) as i64,
-                            avg_latency_ms: 0.0,
-                            circuit_state,
-                        }
-                    })
-                    .collect();
-
-                let timestamp = SystemTime::now()
-                    .duration_since(UNIX_EPOCH)
-                    .unwrap_or_default()
-                    .as_millis() as i64;
-
-                let data = proxy::MetricsData {
-                    active_connections: active_connections as i64,
-                    total_connections: total_connections as i64,
-                    bytes_sent: summary.bytes_sent as i64,
-                    bytes_received: summary.bytes_received as i64,
-                    avg_latency_ms: summary.latency.avg,
-                    p99_latency_ms: summary.latency.p99,
-                    backend_metrics,
-                    timestamp,
-                };
+                let data = metrics_snapshot(&state);
 
                 if tx.send(data).await.is_err() {
                     warn!("Metrics stream receiver dropped");
@@ -271,4 +1197,355 @@ impl proxy::proxy_control_server::ProxyControl for ProxyControlService {
 
         Ok(Response::new(stream))
     }
+
+    type StreamAccessLogsStream = BoxStream<'static, Result<proxy::AccessLogRecord, Status>>;
+
+    async fn stream_access_logs(
+        &self,
+        _request: Request<()>,
+    ) -> Result<Response<Self::StreamAccessLogsStream>, Status> {
+        let (tx, rx) = tokio::sync::mpsc::channel(100);
+        let mut entries = access_log::subscribe();
+
+        tokio::spawn(async move {
+            loop {
+                let entry = match entries.recv().await {
+                    Ok(entry) => entry,
+                    Err(RecvError::Lagged(skipped)) => {
+                        warn!("Access log stream lagging, skipped {} records", skipped);
+                        continue;
+                    }
+                    Err(RecvError::Closed) => break,
+                };
+
+                let record = proxy::AccessLogRecord {
+                    timestamp: unix_millis(),
+                    protocol: entry.protocol.to_string(),
+                    client_ip: entry.client_ip,
+                    backend: entry.backend,
+                    bytes_sent: entry.bytes_sent,
+                    bytes_received: entry.bytes_received,
+                    duration_ms: entry.duration_ms,
+                    error: entry.error.unwrap_or_default(),
+                };
+
+                if tx.send(Ok(record)).await.is_err() {
+                    warn!("Access log stream receiver dropped");
+                    break;
+                }
+            }
+        });
+
+        Ok(Response::new(ReceiverStream::new(rx).boxed()))
+    }
+
+    type StreamEventsStream = BoxStream<'static, Result<proxy::ProxyEvent, Status>>;
+
+    async fn stream_events(
+        &self,
+        _request: Request<()>,
+    ) -> Result<Response<Self::StreamEventsStream>, Status> {
+        let (tx, rx) = tokio::sync::mpsc::channel(100);
+        let mut subscription = events::subscribe();
+
+        tokio::spawn(async move {
+            loop {
+                let event = match subscription.recv().await {
+                    Ok(event) => event,
+                    Err(RecvError::Lagged(skipped)) => {
+                        warn!("Event stream lagging, skipped {} events", skipped);
+                        continue;
+                    }
+                    Err(RecvError::Closed) => break,
+                };
+
+                let event_type = match event.kind {
+                    EventKind::CircuitOpened => proxy::EventType::CircuitOpened,
+                    EventKind::CircuitHalfOpen => proxy::EventType::CircuitHalfOpen,
+                    EventKind::CircuitClosed => proxy::EventType::CircuitClosed,
+                    EventKind::RateLimitExceeded => proxy::EventType::RateLimitExceeded,
+                    EventKind::ConnectionReset => proxy::EventType::ConnectionReset,
+                };
+                let message = proxy::ProxyEvent {
+                    timestamp: event.timestamp_ms,
+                    r#type: event_type as i32,
+                    backend: event.backend,
+                    message: event.message,
+                    attributes: event.attributes,
+                };
+
+                if tx.send(Ok(message)).await.is_err() {
+                    warn!("Event stream receiver dropped");
+                    break;
+                }
+            }
+        });
+
+        Ok(Response::new(ReceiverStream::new(rx).boxed()))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn valid_config() -> ProxyConfig {
+        ProxyConfig {
+            tcp_address: "0.0.0.0:8080".to_string(),
+            udp_address: String::new(),
+            backends: vec![Backend {
+                address: "db1.internal:5432".to_string(),
+                weight: 100,
+                healthy: true,
+                priority: 0,
+                backup: false,
+                zone: String::new(),
+                region: String::new(),
+            }],
+            udp_backends: vec![],
+            algorithm: "round_robin".to_string(),
+            session_affinity: false,
+            hash_key: String::new(),
+            hash_key_name: String::new(),
+            virtual_nodes: 160,
+            hash_method: String::new(),
+            maglev_table_size: 65537,
+            locality_policy: String::new(),
+            locality: Locality::default(),
+            udp_affinity: UdpAffinity::default(),
+            sticky_cookie: StickyCookie::default(),
+            mirror: Mirror::default(),
+            header_rules: vec![],
+            sni_routes: vec![],
+            geo_routes: vec![],
+            dark_launches: vec![],
+            tls_enabled: false,
+            rate_limit_rps: 1000,
+            rate_limit_burst: 100,
+            connection_limit: ConnectionLimit::default(),
+            connect_timeout_secs: 5,
+            idle_timeout_secs: 60,
+            read_timeout_secs: 30,
+            circuit_breaker_threshold: 5,
+            circuit_breaker_timeout_secs: 30,
+        }
+    }
+
+    #[test]
+    fn test_client_ip_matches() {
+        assert!(client_ip_matches("10.0.0.7:41000", "10.0.0.7"));
+        assert!(client_ip_matches("[::1]:5000", "::1"));
+        assert!(!client_ip_matches("10.0.0.70:41000", "10.0.0.7"));
+        assert!(!client_ip_matches("not-an-address", "10.0.0.7"));
+    }
+
+    #[test]
+    fn test_validate_config_accepts_valid() {
+        assert!(validate_config(&valid_config()).is_ok());
+    }
+
+    #[test]
+    fn test_validate_config_collects_errors() {
+        let mut config = valid_config();
+        config.algorithm = "made_up".to_string();
+        config.backends[0].address = "no-port".to_string();
+
+        let err = validate_config(&config).unwrap_err();
+        assert!(err.contains("made_up"), "{}", err);
+        assert!(err.contains("no-port"), "{}", err);
+    }
+
+    #[test]
+    fn test_validate_config_checks_hash_key() {
+        let mut config = valid_config();
+        config.algorithm = "consistent_hash".to_string();
+        config.hash_key = "header".to_string();
+        config.hash_key_name = "X-User-ID".to_string();
+        assert!(validate_config(&config).is_ok());
+
+        config.hash_key_name = String::new();
+        assert!(validate_config(&config).unwrap_err().contains("needs a name"));
+
+        config.hash_key = "query".to_string();
+        assert!(validate_config(&config).unwrap_err().contains("query"));
+    }
+
+    #[test]
+    fn test_validate_config_checks_maglev_table_size() {
+        let mut config = valid_config();
+        config.algorithm = "consistent_hash".to_string();
+        config.hash_method = "maglev".to_string();
+        assert!(validate_config(&config).is_ok());
+
+        config.maglev_table_size = 65536;
+        assert!(validate_config(&config).unwrap_err().contains("not prime"));
+    }
+
+    #[test]
+    fn test_validate_config_needs_a_zone_for_prefer_local() {
+        let mut config = valid_config();
+        config.locality_policy = "prefer_local".to_string();
+        assert!(validate_config(&config).unwrap_err().contains("AEGIS_ZONE"));
+
+        config.locality.region = "eu-west-1".to_string();
+        assert!(validate_config(&config).is_ok());
+
+        config.locality_policy = "nearest".to_string();
+        assert!(validate_config(&config)
+            .unwrap_err()
+            .contains("unsupported locality policy"));
+    }
+
+    #[test]
+    fn test_connection_limit_from_pb() {
+        let limit = connection_limit_from_pb(Some(&proxy::ConnectionLimitConfig {
+            max: 100,
+            overflow: "queue".to_string(),
+            queue_timeout_ms: 2500,
+            queue_size: 50,
+        }));
+        assert_eq!(limit.max, 100);
+        assert!(limit.queue);
+        assert_eq!(limit.queue_size, 50);
+        assert_eq!(limit.queue_timeout, Duration::from_millis(2500));
+
+        let mut config = valid_config();
+        config.connection_limit = limit;
+        assert!(validate_config(&config).is_ok());
+        config.connection_limit.queue_size = 0;
+        assert!(validate_config(&config).unwrap_err().contains("queue size"));
+
+        assert_eq!(connection_limit_from_pb(None).max, 0);
+    }
+
+    #[test]
+    fn test_header_rules_from_pb() {
+        let rules = header_rules_from_pb(&[proxy::HeaderRule {
+            name: "debug".to_string(),
+            matches: vec![proxy::HeaderMatch {
+                header: "X-Debug".to_string(),
+                exact: "1".to_string(),
+                regex: String::new(),
+            }],
+            backends: vec![proxy::Backend {
+                address: "staging:3000".to_string(),
+                weight: 100,
+                healthy: true,
+                ..Default::default()
+            }],
+        }]);
+        assert_eq!(rules.len(), 1);
+        assert_eq!(rules[0].matches[0].header, "X-Debug");
+        assert_eq!(rules[0].backends[0].address, "staging:3000");
+
+        let mut config = valid_config();
+        config.header_rules = rules;
+        assert!(validate_config(&config).is_ok());
+        config.header_rules[0].matches[0].regex = "(".to_string();
+        assert!(validate_config(&config).unwrap_err().contains("invalid regex"));
+    }
+
+    #[test]
+    fn test_udp_affinity_from_pb() {
+        let affinity = udp_affinity_from_pb(Some(&proxy::UdpAffinityConfig {
+            mode: "source_ip".to_string(),
+            idle_timeout_ms: 300_000,
+        }));
+        assert!(affinity.enabled());
+        assert_eq!(affinity.idle_timeout, Duration::from_secs(300));
+        assert!(!udp_affinity_from_pb(None).enabled());
+
+        let mut config = valid_config();
+        config.udp_affinity = affinity;
+        assert!(validate_config(&config).is_ok());
+        config.udp_affinity.mode = "source_port".to_string();
+        assert!(validate_config(&config).unwrap_err().contains("source_port"));
+    }
+
+    #[test]
+    fn test_sticky_cookie_from_pb() {
+        let cookie = sticky_cookie_from_pb(Some(&proxy::StickyCookieConfig {
+            name: "aegis_backend".to_string(),
+            ttl_seconds: 3600,
+            path: "/".to_string(),
+            secure: false,
+            http_only: true,
+        }));
+        assert!(cookie.enabled());
+        assert_eq!(cookie.ttl_secs, 3600);
+        assert!(!sticky_cookie_from_pb(None).enabled());
+
+        let mut config = valid_config();
+        config.sticky_cookie = cookie;
+        assert!(validate_config(&config).is_ok());
+        config.sticky_cookie.name = "aegis backend".to_string();
+        assert!(validate_config(&config).unwrap_err().contains("sticky cookie name"));
+    }
+
+    #[test]
+    fn test_sni_routes_from_pb() {
+        let routes = sni_routes_from_pb(&[proxy::SniRoute {
+            server_names: vec!["api.example.com".to_string(), "*.api.example.com".to_string()],
+            backends: vec![proxy::Backend {
+                address: "api:443".to_string(),
+                weight: 100,
+                healthy: false,
+                ..Default::default()
+            }],
+        }]);
+        assert_eq!(routes[0].server_names[1], "*.api.example.com");
+        assert!(!routes[0].backends[0].healthy);
+
+        let mut config = valid_config();
+        config.sni_routes = routes;
+        assert!(validate_config(&config).is_ok());
+        config.sni_routes[0].server_names.clear();
+        assert!(validate_config(&config).unwrap_err().contains("server names"));
+    }
+
+    #[test]
+    fn test_geo_routes_from_pb() {
+        let routes = geo_routes_from_pb(&[proxy::GeoRoute {
+            name: "eu".to_string(),
+            networks: vec!["1.2.4.0/23".to_string(), "2001:db8::/32".to_string()],
+            backends: vec![proxy::Backend {
+                address: "eu:443".to_string(),
+                weight: 100,
+                healthy: false,
+                ..Default::default()
+            }],
+        }]);
+        assert_eq!(routes[0].networks[1], "2001:db8::/32");
+        assert!(!routes[0].backends[0].healthy);
+
+        let mut config = valid_config();
+        config.geo_routes = routes;
+        assert!(validate_config(&config).is_ok());
+        config.geo_routes[0].networks[0] = "1.2.4.0/33".to_string();
+        assert!(validate_config(&config).unwrap_err().contains("invalid network"));
+    }
+
+    #[test]
+    fn test_dark_launches_from_pb() {
+        let rules = dark_launches_from_pb(&[proxy::DarkLaunch {
+            name: "checkout".to_string(),
+            percent: 5,
+            backends: vec![proxy::Backend {
+                address: "beta:80".to_string(),
+                weight: 100,
+                healthy: true,
+                ..Default::default()
+            }],
+        }]);
+        assert_eq!(rules[0].percent, 5);
+
+        let mut config = valid_config();
+        config.dark_launches = rules;
+        assert!(validate_config(&config).is_ok());
+        config.dark_launches[0].percent = 0;
+        assert!(validate_config(&config).unwrap_err().contains("percent must be 1 to 100"));
+        config.dark_launches[0].percent = 60;
+        config.dark_launches.push(config.dark_launches[0].clone());
+        assert!(validate_config(&config).unwrap_err().contains("add up to 120"));
+    }
 }
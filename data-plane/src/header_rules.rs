@@ -0,0 +1,173 @@
+use regex::Regex;
+use std::sync::Arc;
+use tracing::warn;
+
+use crate::config::{Backend, HeaderRule};
+use crate::load_balancer::LoadBalancer;
+
+/// A header match with its value check compiled.
+enum ValueMatch {
+    Exact(String),
+    Regex(Regex),
+}
+
+struct CompiledRule {
+    name: String,
+    matches: Vec<(String, ValueMatch)>,
+    load_balancer: Arc<LoadBalancer>,
+}
+
+/// Picks the backends of a TCP connection from its HTTP request head: the
+/// first rule whose header matches all hold sends it to that rule's own
+/// load balancer. Rebuilt on every config push and backend reload.
+pub struct HeaderRouter {
+    rules: Vec<CompiledRule>,
+}
+
+/// Compiles a header rule's regex to match the whole value, as Envoy's
+/// safe_regex does.
+pub fn compile_regex(regex: &str) -> Result<Regex, regex::Error> {
+    Regex::new(&format!("^(?:{})$", regex))
+}
+
+impl HeaderRouter {
+    /// A router over rules, each balanced by a load balancer build makes
+    /// from its backends. A rule whose regex doesn't compile is left out;
+    /// PrepareConfig refuses such a config before it gets here.
+    pub fn new(rules: &[HeaderRule], build: impl Fn(Vec<Backend>) -> LoadBalancer) -> Self {
+        let rules = rules
+            .iter()
+            .filter_map(|rule| {
+                let mut matches = Vec::with_capacity(rule.matches.len());
+                for m in &rule.matches {
+                    let value = if m.regex.is_empty() {
+                        ValueMatch::Exact(m.exact.clone())
+                    } else {
+                        match compile_regex(&m.regex) {
+                            Ok(re) => ValueMatch::Regex(re),
+                            Err(e) => {
+                                warn!("Skipping header rule {}: {}", rule.name, e);
+                                return None;
+                            }
+                        }
+                    };
+                    matches.push((m.header.clone(), value));
+                }
+                Some(CompiledRule {
+                    name: rule.name.clone(),
+                    matches,
+                    load_balancer: Arc::new(build(rule.backends.clone())),
+                })
+            })
+            .collect();
+        Self { rules }
+    }
+
+    pub fn empty() -> Self {
+        Self { rules: Vec::new() }
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.rules.is_empty()
+    }
+
+    /// The name and load balancer of the first rule matching an HTTP/1
+    /// request head, or None when none does. A header sent more than once
+    /// matches if any of its values does.
+    pub fn route(&self, head: &[u8]) -> Option<(&str, Arc<LoadBalancer>)> {
+        let head = String::from_utf8_lossy(head);
+        let headers: Vec<(&str, &str)> = head
+            .split("\r\n")
+            .skip(1)
+            .filter_map(|line| line.split_once(':'))
+            .map(|(field, value)| (field.trim(), value.trim()))
+            .collect();
+        self.rules
+            .iter()
+            .find(|rule| {
+                rule.matches.iter().all(|(name, want)| {
+                    headers.iter().any(|(field, value)| {
+                        field.eq_ignore_ascii_case(name)
+                            && match want {
+                                ValueMatch::Exact(exact) => value == exact,
+                                ValueMatch::Regex(re) => re.is_match(value),
+                            }
+                    })
+                })
+            })
+            .map(|rule| (rule.name.as_str(), rule.load_balancer.clone()))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::config::HeaderMatch;
+
+    fn backend(address: &str) -> Backend {
+        Backend {
+            address: address.to_string(),
+            weight: 100,
+            healthy: true,
+            priority: 0,
+            backup: false,
+            zone: String::new(),
+            region: String::new(),
+        }
+    }
+
+    fn rule(name: &str, matches: &[(&str, &str, &str)], address: &str) -> HeaderRule {
+        HeaderRule {
+            name: name.to_string(),
+            matches: matches
+                .iter()
+                .map(|(header, exact, regex)| HeaderMatch {
+                    header: header.to_string(),
+                    exact: exact.to_string(),
+                    regex: regex.to_string(),
+                })
+                .collect(),
+            backends: vec![backend(address)],
+        }
+    }
+
+    fn router(rules: &[HeaderRule]) -> HeaderRouter {
+        HeaderRouter::new(rules, |backends| {
+            LoadBalancer::new(backends, "round_robin".to_string())
+        })
+    }
+
+    fn routed(router: &HeaderRouter, head: &[u8]) -> Option<String> {
+        router.route(head).map(|(_, lb)| lb.select_backend().unwrap().address)
+    }
+
+    #[test]
+    fn test_first_matching_rule_wins() {
+        let router = router(&[
+            rule("debug", &[("X-Debug", "1", ""), ("User-Agent", "", "internal-.*")], "staging:80"),
+            rule("any-debug", &[("X-Debug", "", "[0-9]+")], "qa:80"),
+        ]);
+        let head = b"GET / HTTP/1.1\r\nx-debug: 1\r\nUser-Agent: internal-probe/2";
+        assert_eq!(routed(&router, head), Some("staging:80".to_string()));
+        // All of a rule's matches have to hold
+        let head = b"GET / HTTP/1.1\r\nX-Debug: 1\r\nUser-Agent: curl/8";
+        assert_eq!(routed(&router, head), Some("qa:80".to_string()));
+        let head = b"GET / HTTP/1.1\r\nX-Debug: yes";
+        assert_eq!(routed(&router, head), None);
+    }
+
+    #[test]
+    fn test_regex_matches_the_whole_value() {
+        let router = router(&[rule("beta", &[("X-Tenant", "", "beta")], "beta:80")]);
+        assert!(routed(&router, b"GET / HTTP/1.1\r\nX-Tenant: beta").is_some());
+        assert!(routed(&router, b"GET / HTTP/1.1\r\nX-Tenant: not-beta").is_none());
+        // The request line isn't a header
+        assert!(routed(&router, b"X-Tenant: beta\r\nHost: a").is_none());
+    }
+
+    #[test]
+    fn test_invalid_regex_skips_the_rule() {
+        let router = router(&[rule("bad", &[("X-Debug", "", "(")], "staging:80")]);
+        assert!(router.is_empty());
+    }
+}
@@ -2,10 +2,21 @@ pub mod access_log;
 pub mod circuit_breaker;
 pub mod config;
 pub mod connection;
+pub mod connection_limit;
+pub mod dark_launch;
+pub mod events;
+pub mod geo;
 pub mod grpc_server;
+pub mod header_rules;
 pub mod load_balancer;
+pub mod log_control;
 pub mod metrics;
 pub mod metrics_server;
+pub mod mirror;
 pub mod rate_limiter;
+pub mod secrets;
+pub mod sni;
+pub mod sticky_cookie;
 pub mod tcp_proxy;
+pub mod tls;
 pub mod udp_proxy;
@@ -1,39 +1,100 @@
 use parking_lot::RwLock;
+use std::collections::hash_map::RandomState;
 use std::collections::HashMap;
-use std::hash::{Hash, Hasher};
+use std::hash::{BuildHasher, Hasher};
 use std::sync::atomic::{AtomicU64, AtomicUsize, Ordering};
 
+use tracing::warn;
+
 use crate::config::Backend;
+use crate::sticky_cookie;
+
+/// Weight of the newest connect latency in a backend's moving average
+const EWMA_ALPHA: f64 = 0.3;
+
+/// Points each backend gets on the consistent_hash ring unless configured
+pub const DEFAULT_VIRTUAL_NODES: u32 = 160;
+
+/// Maglev table size unless configured, as in Envoy; prime
+pub const DEFAULT_MAGLEV_TABLE_SIZE: u32 = 65537;
+
+/// Where this data plane runs and how strongly selection keeps to it, for
+/// the prefer_local locality policy
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct Locality {
+    pub zone: String,
+    pub region: String,
+    /// Percent of connections sent out of the local zone while it's healthy
+    pub spillover_percent: u32,
+    /// Below this percent of its backends healthy, the local zone's share
+    /// of connections shrinks in proportion; 0 to only spill once none is
+    pub min_healthy_percent: u32,
+}
 
 /// Load balancing algorithms for distributing traffic across backends
+#[derive(Debug, PartialEq)]
 pub enum Algorithm {
     RoundRobin,
     LeastConnections,
     WeightedRoundRobin,
     ConsistentHash,
+    Random,
+    LatencyEwma,
 }
 
 impl Algorithm {
-    pub fn from_str(s: &str) -> Self {
+    /// Parses a configured algorithm name. The control plane rejects
+    /// anything else at load time and PrepareConfig checks it against
+    /// FEATURES, so None only reaches a config read from elsewhere.
+    pub fn parse(s: &str) -> Option<Self> {
         match s {
-            "least_connections" => Algorithm::LeastConnections,
-            "weighted_round_robin" | "weighted" => Algorithm::WeightedRoundRobin,
-            "consistent_hash" => Algorithm::ConsistentHash,
-            _ => Algorithm::RoundRobin,
+            "round_robin" => Some(Algorithm::RoundRobin),
+            "least_connections" => Some(Algorithm::LeastConnections),
+            "weighted_round_robin" | "weighted" => Some(Algorithm::WeightedRoundRobin),
+            "consistent_hash" => Some(Algorithm::ConsistentHash),
+            "random" => Some(Algorithm::Random),
+            "latency_ewma" => Some(Algorithm::LatencyEwma),
+            _ => None,
         }
     }
+
+    pub fn from_str(s: &str) -> Self {
+        Self::parse(s).unwrap_or_else(|| {
+            warn!("Unknown load balancing algorithm {:?}, using round_robin", s);
+            Algorithm::RoundRobin
+        })
+    }
 }
 
 pub struct LoadBalancer {
     backends: RwLock<Vec<BackendWithStats>>,
     algorithm: Algorithm,
     round_robin_counter: AtomicUsize,
+    random_state: RandomState,
+    virtual_nodes: u32,
+    /// consistent_hash ring: (point, index into backends), sorted by point.
+    /// Built over every backend, healthy or not, so a backend going down
+    /// only moves its own keys.
+    ring: RwLock<Vec<(u64, usize)>>,
+    /// Maglev table size, 0 to use the ring instead
+    maglev_table_size: usize,
+    /// consistent_hash Maglev lookup table: an index into backends per
+    /// slot, over the healthy backends of the active tier only.
+    maglev: RwLock<Vec<usize>>,
+    /// prefer_local policy, None when selection ignores zones
+    locality: Option<Locality>,
+    /// Drives the spillover draw separately from round_robin_counter, so
+    /// the two don't fall into step
+    locality_counter: AtomicUsize,
 }
 
 /// Backend with connection tracking for least-connections algorithm
 pub struct BackendWithStats {
     pub backend: Backend,
     pub active_connections: AtomicU64,
+    /// Moving average of connect latency in microseconds, 0 until the
+    /// first connect, for latency_ewma
+    pub latency_ewma_us: AtomicU64,
 }
 
 impl Clone for BackendWithStats {
@@ -41,6 +102,7 @@ impl Clone for BackendWithStats {
         Self {
             backend: self.backend.clone(),
             active_connections: AtomicU64::new(self.active_connections.load(Ordering::Relaxed)),
+            latency_ewma_us: AtomicU64::new(self.latency_ewma_us.load(Ordering::Relaxed)),
         }
     }
 }
@@ -52,6 +114,7 @@ impl LoadBalancer {
             .map(|b| BackendWithStats {
                 backend: b,
                 active_connections: AtomicU64::new(0),
+                latency_ewma_us: AtomicU64::new(0),
             })
             .collect();
 
@@ -59,7 +122,131 @@ impl LoadBalancer {
             backends: RwLock::new(backends_with_stats),
             algorithm: Algorithm::from_str(&algorithm),
             round_robin_counter: AtomicUsize::new(0),
+            random_state: RandomState::new(),
+            virtual_nodes: DEFAULT_VIRTUAL_NODES,
+            ring: RwLock::new(Vec::new()),
+            maglev_table_size: 0,
+            maglev: RwLock::new(Vec::new()),
+            locality: None,
+            locality_counter: AtomicUsize::new(0),
+        }
+        .with_virtual_nodes(DEFAULT_VIRTUAL_NODES)
+    }
+
+    /// Sets the points per backend on the consistent_hash ring (at least
+    /// one) and rebuilds it.
+    pub fn with_virtual_nodes(mut self, virtual_nodes: u32) -> Self {
+        self.virtual_nodes = virtual_nodes.max(1);
+        self.rebuild_hash(&self.backends.read());
+        self
+    }
+
+    /// Switches consistent_hash from the ring to a Maglev table of
+    /// table_size slots, which should be prime; 0 keeps the ring.
+    pub fn with_maglev(mut self, table_size: u32) -> Self {
+        self.maglev_table_size = table_size as usize;
+        self.rebuild_hash(&self.backends.read());
+        self
+    }
+
+    /// Keeps selection to the backends in locality's zone, spilling over
+    /// as configured; None ignores zones. consistent_hash ignores it, since
+    /// a key has to land on the same backend from every zone.
+    pub fn with_locality(mut self, locality: Option<Locality>) -> Self {
+        self.locality = locality;
+        self
+    }
+
+    /// Rebuilds whichever of the ring or Maglev table consistent_hash uses
+    /// for backends. Other algorithms need neither.
+    fn rebuild_hash(&self, backends: &[BackendWithStats]) {
+        if self.algorithm != Algorithm::ConsistentHash {
+            return;
+        }
+        if self.maglev_table_size > 0 {
+            *self.maglev.write() = self.build_maglev(backends);
+            self.ring.write().clear();
+        } else {
+            *self.ring.write() = self.build_ring(backends);
+            self.maglev.write().clear();
+        }
+    }
+
+    /// Places virtual_nodes points per backend, hashed from its address,
+    /// so every data plane and restart builds the same ring.
+    fn build_ring(&self, backends: &[BackendWithStats]) -> Vec<(u64, usize)> {
+        let mut ring = Vec::with_capacity(backends.len() * self.virtual_nodes as usize);
+        for (idx, b) in backends.iter().enumerate() {
+            for vnode in 0..self.virtual_nodes {
+                let point = stable_hash(format!("{}#{}", b.backend.address, vnode).as_bytes());
+                ring.push((point, idx));
+            }
+        }
+        ring.sort_unstable();
+        ring
+    }
+
+    /// Fills the Maglev table (Eisenbud et al., 2016): each healthy backend
+    /// of the active tier walks its own permutation of the slots,
+    /// derived from its address, and the backends take turns claiming the
+    /// next free slot in theirs. Each ends up with a near equal share, and
+    /// a change to the set mostly only reassigns the slots of the backends
+    /// that came or went. Empty when no backend is healthy.
+    fn build_maglev(&self, backends: &[BackendWithStats]) -> Vec<usize> {
+        let size = self.maglev_table_size;
+        let Some(tier) = active_tier(backends) else {
+            return Vec::new();
+        };
+        // (index, offset, skip, next position in its permutation)
+        let mut walkers: Vec<(usize, usize, usize, usize)> = backends
+            .iter()
+            .enumerate()
+            .filter(|(_, b)| in_tier(b, tier))
+            .map(|(idx, b)| {
+                let addr = b.backend.address.as_bytes();
+                let offset = stable_hash(addr) as usize % size;
+                let skip = if size > 1 {
+                    stable_hash(&[addr, &b"#skip"[..]].concat()) as usize % (size - 1) + 1
+                } else {
+                    1
+                };
+                (idx, offset, skip, 0)
+            })
+            .collect();
+        if walkers.is_empty() {
+            return Vec::new();
+        }
+
+        let mut table = vec![usize::MAX; size];
+        let mut filled = 0;
+        'fill: loop {
+            for (idx, offset, skip, next) in walkers.iter_mut() {
+                // A prime size makes every permutation visit each slot, so
+                // this finds a free one; the bound guards a composite size
+                let mut slot = (*offset + *next * *skip) % size;
+                while table[slot] != usize::MAX && *next < size {
+                    *next += 1;
+                    slot = (*offset + *next * *skip) % size;
+                }
+                if table[slot] == usize::MAX {
+                    table[slot] = *idx;
+                    filled += 1;
+                }
+                *next += 1;
+                if filled == size {
+                    break 'fill;
+                }
+            }
+            if walkers.iter().all(|&(_, _, _, next)| next >= size) {
+                break;
+            }
+        }
+        // Only a composite size leaves gaps; give them to the first walker
+        let first = walkers[0].0;
+        for slot in table.iter_mut().filter(|s| **s == usize::MAX) {
+            *slot = first;
         }
+        table
     }
 
     /// Select a backend based on configured algorithm
@@ -70,20 +257,86 @@ impl LoadBalancer {
     /// Select backend with optional context (e.g., client IP for consistent hashing)
     pub fn select_backend_with_context(&self, context: Option<&str>) -> Option<Backend> {
         let backends = self.backends.read();
-        let healthy: Vec<_> = backends.iter().filter(|b| b.backend.healthy).collect();
-
-        if healthy.is_empty() {
-            return None;
+        let tier = active_tier(&backends)?;
+        let mut healthy: Vec<_> = backends.iter().filter(|b| in_tier(b, tier)).collect();
+        if let Some(locality) = &self.locality {
+            if self.algorithm != Algorithm::ConsistentHash {
+                healthy = self.prefer_local(locality, &backends, tier, healthy);
+            }
         }
 
         match self.algorithm {
             Algorithm::RoundRobin => self.round_robin(&healthy),
             Algorithm::LeastConnections => self.least_connections(&healthy),
             Algorithm::WeightedRoundRobin => self.weighted_round_robin(&healthy),
-            Algorithm::ConsistentHash => self.consistent_hash(&healthy, context),
+            Algorithm::ConsistentHash => self.consistent_hash(&backends, &healthy, context),
+            Algorithm::Random => self.random(&healthy),
+            Algorithm::LatencyEwma => self.latency_ewma(&healthy),
         }
     }
 
+    /// Narrows the active tier's healthy backends to the local zone or,
+    /// for the connections that spill over, to the rest of the region and
+    /// then anywhere. With no zone configured the region is what's local.
+    fn prefer_local<'a>(
+        &self,
+        locality: &Locality,
+        backends: &'a [BackendWithStats],
+        tier: Tier,
+        healthy: Vec<&'a BackendWithStats>,
+    ) -> Vec<&'a BackendWithStats> {
+        let is_local = |b: &BackendWithStats| {
+            if !locality.zone.is_empty() {
+                b.backend.zone == locality.zone
+            } else {
+                !locality.region.is_empty() && b.backend.region == locality.region
+            }
+        };
+        let (local, remote): (Vec<_>, Vec<_>) = healthy.into_iter().partition(|b| is_local(*b));
+        if remote.is_empty() {
+            return local;
+        }
+
+        // Percent of connections kept local
+        let mut share = if local.is_empty() {
+            0
+        } else {
+            100 - locality.spillover_percent.min(100)
+        };
+        if share > 0 && locality.min_healthy_percent > 0 {
+            let total = backends
+                .iter()
+                .filter(|b| (b.backend.backup, b.backend.priority) == tier && is_local(*b))
+                .count();
+            let healthy_percent = (local.len() * 100 / total) as u32;
+            if healthy_percent < locality.min_healthy_percent {
+                share = share * healthy_percent / locality.min_healthy_percent;
+            }
+        }
+        if share >= 100 || (share > 0 && self.locality_draw() < share) {
+            return local;
+        }
+
+        if !locality.zone.is_empty() && !locality.region.is_empty() {
+            let region: Vec<_> = remote
+                .iter()
+                .copied()
+                .filter(|b| b.backend.region == locality.region)
+                .collect();
+            if !region.is_empty() {
+                return region;
+            }
+        }
+        remote
+    }
+
+    /// A pseudo-random percentile, 0 to 99, for the spillover split
+    fn locality_draw(&self) -> u32 {
+        let mut hasher = self.random_state.build_hasher();
+        hasher.write_usize(self.locality_counter.fetch_add(1, Ordering::Relaxed));
+        (hasher.finish() % 100) as u32
+    }
+
     /// Simple round-robin selection
     fn round_robin(&self, backends: &[&BackendWithStats]) -> Option<Backend> {
         if backends.is_empty() {
@@ -139,27 +392,93 @@ impl LoadBalancer {
         self.round_robin(backends)
     }
 
-    /// Consistent hashing for session affinity
-    fn consistent_hash(
-        &self,
-        backends: &[&BackendWithStats],
-        context: Option<&str>,
-    ) -> Option<Backend> {
+    /// Uniformly random selection: a per-balancer keyed hash of a counter,
+    /// which needs no RNG and no lock
+    fn random(&self, backends: &[&BackendWithStats]) -> Option<Backend> {
+        if backends.is_empty() {
+            return None;
+        }
+
+        let mut hasher = self.random_state.build_hasher();
+        hasher.write_usize(self.round_robin_counter.fetch_add(1, Ordering::Relaxed));
+        let index = hasher.finish() as usize % backends.len();
+        Some(backends[index].backend.clone())
+    }
+
+    /// Latency EWMA: select the backend with the lowest moving average
+    /// connect latency weighted by its active connections, so a backend
+    /// that slows down sheds load before its circuit breaker trips. A
+    /// backend with no sample yet is scored at the average of the others;
+    /// with none sampled this is least connections. Ties rotate.
+    fn latency_ewma(&self, backends: &[&BackendWithStats]) -> Option<Backend> {
         if backends.is_empty() {
             return None;
         }
 
-        // Use context (e.g., client IP) for hash, or fall back to round-robin
-        let hash_value = if let Some(ctx) = context {
-            let mut hasher = std::collections::hash_map::DefaultHasher::new();
-            ctx.hash(&mut hasher);
-            hasher.finish() as usize
+        let sampled: Vec<u64> = backends
+            .iter()
+            .map(|b| b.latency_ewma_us.load(Ordering::Relaxed))
+            .filter(|&l| l > 0)
+            .collect();
+        let default_latency = if sampled.is_empty() {
+            1.0
         } else {
-            return self.round_robin(backends);
+            sampled.iter().sum::<u64>() as f64 / sampled.len() as f64
         };
 
-        let index = hash_value % backends.len();
-        Some(backends[index].backend.clone())
+        let start = self.round_robin_counter.fetch_add(1, Ordering::Relaxed);
+        let mut best: Option<(f64, usize)> = None;
+        for offset in 0..backends.len() {
+            let idx = (start + offset) % backends.len();
+            let b = backends[idx];
+            let latency = match b.latency_ewma_us.load(Ordering::Relaxed) {
+                0 => default_latency,
+                l => l as f64,
+            };
+            let score = latency * (b.active_connections.load(Ordering::Relaxed) + 1) as f64;
+            if best.map_or(true, |(s, _)| score < s) {
+                best = Some((score, idx));
+            }
+        }
+
+        best.map(|(_, idx)| backends[idx].backend.clone())
+    }
+
+    /// Consistent hashing: the backend in the key's Maglev slot, or the
+    /// first healthy backend clockwise of the key's point on the ring. Without a key (e.g. no header to hash) it
+    /// falls back to round-robin over the healthy backends.
+    fn consistent_hash(
+        &self,
+        all: &[BackendWithStats],
+        healthy: &[&BackendWithStats],
+        context: Option<&str>,
+    ) -> Option<Backend> {
+        let Some(ctx) = context else {
+            return self.round_robin(healthy);
+        };
+        if self.maglev_table_size > 0 {
+            let table = self.maglev.read();
+            if table.is_empty() {
+                return None;
+            }
+            let slot = stable_hash(ctx.as_bytes()) as usize % table.len();
+            return Some(all[table[slot]].backend.clone());
+        }
+        let ring = self.ring.read();
+        if ring.is_empty() {
+            return self.round_robin(healthy);
+        }
+
+        let point = stable_hash(ctx.as_bytes());
+        let tier = (healthy[0].backend.backup, healthy[0].backend.priority);
+        let start = ring.partition_point(|&(p, _)| p < point);
+        for i in 0..ring.len() {
+            let (_, idx) = ring[(start + i) % ring.len()];
+            if in_tier(&all[idx], tier) {
+                return Some(all[idx].backend.clone());
+            }
+        }
+        None
     }
 
     /// Increment active connection count for a backend
@@ -184,38 +503,81 @@ impl LoadBalancer {
         }
     }
 
-    /// Update backend list from control plane, preserving active connection counts
+    /// Fold a successful connect's latency into the backend's moving
+    /// average. Concurrent updates may drop a sample, which an average
+    /// doesn't miss.
+    pub fn record_latency(&self, backend_addr: &str, latency_ms: f64) {
+        let sample = (latency_ms * 1000.0).max(1.0);
+        let backends = self.backends.read();
+        for backend in backends.iter() {
+            if backend.backend.address == backend_addr {
+                let old = backend.latency_ewma_us.load(Ordering::Relaxed);
+                let next = if old == 0 {
+                    sample
+                } else {
+                    EWMA_ALPHA * sample + (1.0 - EWMA_ALPHA) * old as f64
+                };
+                backend
+                    .latency_ewma_us
+                    .store(next.round().max(1.0) as u64, Ordering::Relaxed);
+                break;
+            }
+        }
+    }
+
+    /// Update backend list from control plane, preserving active connection
+    /// counts and latency averages
     pub fn update_backends(&self, backends: Vec<Backend>) {
         let mut current = self.backends.write();
-        let existing: HashMap<String, u64> = current
+        let existing: HashMap<String, (u64, u64)> = current
             .iter()
             .map(|b| {
                 (
                     b.backend.address.clone(),
-                    b.active_connections.load(Ordering::Relaxed),
+                    (
+                        b.active_connections.load(Ordering::Relaxed),
+                        b.latency_ewma_us.load(Ordering::Relaxed),
+                    ),
                 )
             })
             .collect();
         *current = backends
             .into_iter()
             .map(|b| {
-                let conns = existing.get(&b.address).copied().unwrap_or(0);
+                let (conns, latency) = existing.get(&b.address).copied().unwrap_or((0, 0));
                 BackendWithStats {
                     backend: b,
                     active_connections: AtomicU64::new(conns),
+                    latency_ewma_us: AtomicU64::new(latency),
                 }
             })
             .collect();
+        self.rebuild_hash(&current);
     }
 
-    /// Addresses of currently healthy backends, for callers (e.g. the
-    /// connection pool) that need to know what to pre-warm without going
-    /// through backend selection.
+    /// The healthy backend in the active tier a sticky cookie value names,
+    /// or None when it's gone, down or out of the tier.
+    pub fn select_sticky(&self, value: &str) -> Option<Backend> {
+        let backends = self.backends.read();
+        let tier = active_tier(&backends)?;
+        backends
+            .iter()
+            .find(|b| in_tier(b, tier) && sticky_cookie::cookie_value(&b.backend.address) == value)
+            .map(|b| b.backend.clone())
+    }
+
+    /// Addresses of the healthy backends selection currently picks from,
+    /// those in the active tier, for callers (e.g. the connection
+    /// pool) that need to know what to pre-warm without going through
+    /// backend selection.
     pub fn healthy_backend_addresses(&self) -> Vec<String> {
         let backends = self.backends.read();
+        let Some(tier) = active_tier(&backends) else {
+            return Vec::new();
+        };
         backends
             .iter()
-            .filter(|b| b.backend.healthy)
+            .filter(|b| in_tier(b, tier))
             .map(|b| b.backend.address.clone())
             .collect()
     }
@@ -235,6 +597,51 @@ impl LoadBalancer {
     }
 }
 
+/// A failover tier: (backup, priority), ordered so every primary tier
+/// comes before the backups.
+type Tier = (bool, i32);
+
+/// The tier traffic goes to: the lowest priority with a healthy primary,
+/// or once every primary is down or drained (weight 0), the lowest with a
+/// healthy backup. Drained primaries only serve when there's no backup to
+/// take over. None when no backend is healthy.
+fn active_tier(backends: &[BackendWithStats]) -> Option<Tier> {
+    let healthy = |backup: bool| {
+        backends
+            .iter()
+            .filter(move |b| b.backend.healthy && b.backend.backup == backup)
+    };
+    let primary = healthy(false).map(|b| b.backend.priority).min();
+    if primary.is_some() && healthy(false).any(|b| b.backend.weight > 0) {
+        return primary.map(|p| (false, p));
+    }
+    healthy(true)
+        .map(|b| b.backend.priority)
+        .min()
+        .map(|p| (true, p))
+        .or(primary.map(|p| (false, p)))
+}
+
+fn in_tier(b: &BackendWithStats, tier: Tier) -> bool {
+    b.backend.healthy && (b.backend.backup, b.backend.priority) == tier
+}
+
+/// FNV-1a with a splitmix64 finalizer: unlike DefaultHasher it's fixed
+/// across Rust releases, and the finalizer spreads the near-identical
+/// vnode keys around the ring.
+fn stable_hash(bytes: &[u8]) -> u64 {
+    let mut h: u64 = 0xcbf29ce484222325;
+    for &b in bytes {
+        h ^= b as u64;
+        h = h.wrapping_mul(0x100000001b3);
+    }
+    h ^= h >> 30;
+    h = h.wrapping_mul(0xbf58476d1ce4e5b9);
+    h ^= h >> 27;
+    h = h.wrapping_mul(0x94d049bb133111eb);
+    h ^ (h >> 31)
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -245,6 +652,10 @@ mod tests {
             address: addr.to_string(),
             weight,
             healthy: true,
+            priority: 0,
+            backup: false,
+            zone: String::new(),
+            region: String::new(),
         }
     }
 
@@ -334,14 +745,336 @@ mod tests {
         }
     }
 
+    #[test]
+    fn test_consistent_hash_moves_only_removed_backends_keys() {
+        let lb = LoadBalancer::new(
+            vec![backend("a:1", 100), backend("b:1", 100), backend("c:1", 100)],
+            "consistent_hash".to_string(),
+        );
+        let keys: Vec<String> = (0..300).map(|i| format!("10.0.{}.{}", i / 256, i % 256)).collect();
+        let before: Vec<String> = keys
+            .iter()
+            .map(|k| lb.select_backend_with_context(Some(k)).unwrap().address)
+            .collect();
+        for addr in ["a:1", "b:1", "c:1"] {
+            assert!(before.iter().any(|b| b == addr), "{} got no keys", addr);
+        }
+
+        let mut down = backend("b:1", 100);
+        down.healthy = false;
+        lb.update_backends(vec![backend("a:1", 100), down, backend("c:1", 100)]);
+        for (key, was) in keys.iter().zip(&before) {
+            let now = lb.select_backend_with_context(Some(key)).unwrap().address;
+            if was != "b:1" {
+                assert_eq!(&now, was, "{} moved though its backend stayed up", key);
+            } else {
+                assert_ne!(now, "b:1");
+            }
+        }
+    }
+
+    #[test]
+    fn test_consistent_hash_ring_is_stable_across_balancers() {
+        let backends = vec![backend("a:1", 100), backend("b:1", 100), backend("c:1", 100)];
+        let one = LoadBalancer::new(backends.clone(), "consistent_hash".to_string())
+            .with_virtual_nodes(40);
+        let two = LoadBalancer::new(backends, "consistent_hash".to_string())
+            .with_virtual_nodes(40);
+        for i in 0..50 {
+            let key = format!("user-{}", i);
+            assert_eq!(
+                one.select_backend_with_context(Some(&key)).unwrap().address,
+                two.select_backend_with_context(Some(&key)).unwrap().address
+            );
+        }
+    }
+
+    #[test]
+    fn test_maglev_spreads_evenly_and_moves_few_keys() {
+        let addrs: Vec<String> = (0..5).map(|i| format!("10.1.0.{}:80", i)).collect();
+        let lb = LoadBalancer::new(
+            addrs.iter().map(|a| backend(a, 100)).collect(),
+            "consistent_hash".to_string(),
+        )
+        .with_maglev(65537);
+
+        let mut share: HashMap<usize, usize> = HashMap::new();
+        for &idx in lb.maglev.read().iter() {
+            *share.entry(idx).or_default() += 1;
+        }
+        for idx in 0..5 {
+            let slots = share[&idx] as f64 / 65537.0;
+            assert!((slots - 0.2).abs() < 0.01, "backend {} has {:.3} of the table", idx, slots);
+        }
+
+        let keys: Vec<String> = (0..2000).map(|i| format!("client-{}", i)).collect();
+        let before: Vec<String> = keys
+            .iter()
+            .map(|k| lb.select_backend_with_context(Some(k)).unwrap().address)
+            .collect();
+        let mut down = backend(&addrs[2], 100);
+        down.healthy = false;
+        let mut updated: Vec<Backend> = addrs.iter().map(|a| backend(a, 100)).collect();
+        updated[2] = down;
+        lb.update_backends(updated);
+
+        let mut moved = 0;
+        for (key, was) in keys.iter().zip(&before) {
+            let now = lb.select_backend_with_context(Some(key)).unwrap().address;
+            assert_ne!(now, addrs[2]);
+            if was != &addrs[2] && &now != was {
+                moved += 1;
+            }
+        }
+        // Maglev trades a little disruption for balance; the ring would
+        // move none
+        assert!(moved < keys.len() / 20, "{} keys of surviving backends moved", moved);
+    }
+
+    #[test]
+    fn test_maglev_with_no_healthy_backend_returns_none() {
+        let mut down = backend("a:1", 100);
+        down.healthy = false;
+        let lb = LoadBalancer::new(vec![down], "consistent_hash".to_string()).with_maglev(7);
+        assert!(lb.select_backend_with_context(Some("client")).is_none());
+    }
+
+    #[test]
+    fn test_priority_fails_over_only_when_tier_is_down() {
+        let tiered = |addr: &str, priority: i32, healthy: bool| Backend {
+            healthy,
+            priority,
+            ..backend(addr, 100)
+        };
+        let lb = LoadBalancer::new(
+            vec![tiered("a:1", 0, true), tiered("b:1", 0, true), tiered("c:1", 1, true)],
+            "round_robin".to_string(),
+        );
+        for _ in 0..10 {
+            assert_ne!(lb.select_backend().unwrap().address, "c:1");
+        }
+        assert_eq!(lb.healthy_backend_addresses().len(), 2);
+
+        lb.update_backends(vec![tiered("a:1", 0, false), tiered("b:1", 0, true), tiered("c:1", 1, true)]);
+        for _ in 0..10 {
+            assert_eq!(lb.select_backend().unwrap().address, "b:1");
+        }
+
+        lb.update_backends(vec![tiered("a:1", 0, false), tiered("b:1", 0, false), tiered("c:1", 1, true)]);
+        assert_eq!(lb.select_backend().unwrap().address, "c:1");
+        assert_eq!(lb.healthy_backend_addresses(), vec!["c:1".to_string()]);
+    }
+
+    #[test]
+    fn test_backup_only_when_every_primary_is_down_or_drained() {
+        let node = |addr: &str, priority: i32, backup: bool, healthy: bool, weight: i32| Backend {
+            priority,
+            backup,
+            healthy,
+            ..backend(addr, weight)
+        };
+        // The backup outranks a lower primary tier, so it's last whatever
+        // its priority
+        let lb = LoadBalancer::new(
+            vec![node("a:1", 0, false, false, 100), node("b:1", 3, false, true, 100), node("spare:1", 0, true, true, 100)],
+            "round_robin".to_string(),
+        );
+        for _ in 0..5 {
+            assert_eq!(lb.select_backend().unwrap().address, "b:1");
+        }
+
+        lb.update_backends(vec![node("a:1", 0, false, false, 100), node("b:1", 3, false, true, 0), node("spare:1", 0, true, true, 100)]);
+        assert_eq!(lb.select_backend().unwrap().address, "spare:1");
+
+        // With no healthy backup, a drained primary still beats nothing
+        lb.update_backends(vec![node("a:1", 0, false, false, 100), node("b:1", 3, false, true, 0), node("spare:1", 0, true, false, 100)]);
+        assert_eq!(lb.select_backend().unwrap().address, "b:1");
+
+        lb.update_backends(vec![node("b:1", 3, false, false, 100), node("spare:1", 0, true, false, 100)]);
+        assert!(lb.select_backend().is_none());
+    }
+
+    fn zoned(addr: &str, zone: &str, region: &str, healthy: bool) -> Backend {
+        Backend {
+            zone: zone.to_string(),
+            region: region.to_string(),
+            healthy,
+            ..backend(addr, 100)
+        }
+    }
+
+    fn local_to(zone: &str, spillover_percent: u32, min_healthy_percent: u32) -> Option<Locality> {
+        Some(Locality {
+            zone: zone.to_string(),
+            region: "us-east-1".to_string(),
+            spillover_percent,
+            min_healthy_percent,
+        })
+    }
+
+    fn picks(lb: &LoadBalancer, n: usize) -> StdHashMap<String, usize> {
+        let mut counts = StdHashMap::new();
+        for _ in 0..n {
+            *counts.entry(lb.select_backend().unwrap().address).or_insert(0) += 1;
+        }
+        counts
+    }
+
+    #[test]
+    fn test_prefer_local_spills_over_by_percentage() {
+        let backends = vec![
+            zoned("a1:1", "us-east-1a", "us-east-1", true),
+            zoned("a2:1", "us-east-1a", "us-east-1", true),
+            zoned("b:1", "us-east-1b", "us-east-1", true),
+        ];
+        let lb = LoadBalancer::new(backends.clone(), "round_robin".to_string())
+            .with_locality(local_to("us-east-1a", 0, 0));
+        assert!(!picks(&lb, 100).contains_key("b:1"));
+
+        let lb = LoadBalancer::new(backends, "round_robin".to_string())
+            .with_locality(local_to("us-east-1a", 20, 0));
+        let spilled = picks(&lb, 10000).get("b:1").copied().unwrap_or(0);
+        assert!((1700..2300).contains(&spilled), "spilled {} of 10000", spilled);
+    }
+
+    #[test]
+    fn test_prefer_local_falls_back_to_region_then_anywhere() {
+        let lb = LoadBalancer::new(
+            vec![
+                zoned("a:1", "us-east-1a", "us-east-1", false),
+                zoned("far:1", "eu-west-1a", "eu-west-1", true),
+                zoned("b:1", "us-east-1b", "us-east-1", true),
+            ],
+            "round_robin".to_string(),
+        )
+        .with_locality(local_to("us-east-1a", 0, 0));
+        assert_eq!(picks(&lb, 20).get("b:1"), Some(&20));
+
+        lb.update_backends(vec![
+            zoned("a:1", "us-east-1a", "us-east-1", false),
+            zoned("far:1", "eu-west-1a", "eu-west-1", true),
+            zoned("b:1", "us-east-1b", "us-east-1", false),
+        ]);
+        assert_eq!(lb.select_backend().unwrap().address, "far:1");
+    }
+
+    #[test]
+    fn test_prefer_local_shrinks_share_of_a_degraded_zone() {
+        // One of four local backends healthy against a 50% minimum: the
+        // zone keeps half its share
+        let mut backends: Vec<_> = (0..4)
+            .map(|i| zoned(&format!("a{}:1", i), "us-east-1a", "us-east-1", i == 0))
+            .collect();
+        backends.push(zoned("b:1", "us-east-1b", "us-east-1", true));
+        let lb = LoadBalancer::new(backends, "round_robin".to_string())
+            .with_locality(local_to("us-east-1a", 0, 50));
+        let local = picks(&lb, 10000).get("a0:1").copied().unwrap_or(0);
+        assert!((4500..5500).contains(&local), "kept {} of 10000 local", local);
+    }
+
+    #[test]
+    fn test_consistent_hash_keeps_to_the_active_tier() {
+        let tiered = |addr: &str, priority: i32| Backend {
+            priority,
+            ..backend(addr, 100)
+        };
+        for lb in [
+            LoadBalancer::new(vec![tiered("a:1", 0), tiered("b:1", 1)], "consistent_hash".to_string()),
+            LoadBalancer::new(vec![tiered("a:1", 0), tiered("b:1", 1)], "consistent_hash".to_string())
+                .with_maglev(251),
+        ] {
+            for i in 0..50 {
+                let key = format!("client-{}", i);
+                assert_eq!(lb.select_backend_with_context(Some(&key)).unwrap().address, "a:1");
+            }
+        }
+    }
+
+    #[test]
+    fn test_random_uses_every_backend() {
+        let lb = LoadBalancer::new(
+            vec![backend("a", 100), backend("b", 100), backend("c", 100)],
+            "random".to_string(),
+        );
+
+        let mut counts: StdHashMap<String, u32> = StdHashMap::new();
+        for _ in 0..3000 {
+            let b = lb.select_backend().unwrap();
+            *counts.entry(b.address).or_insert(0) += 1;
+        }
+
+        assert_eq!(counts.len(), 3);
+        for count in counts.values() {
+            assert!(*count > 800 && *count < 1200, "{:?}", counts);
+        }
+    }
+
+    #[test]
+    fn test_latency_ewma_prefers_fast_backend() {
+        let lb = LoadBalancer::new(
+            vec![backend("a", 100), backend("b", 100)],
+            "latency_ewma".to_string(),
+        );
+        lb.record_latency("a", 50.0);
+        lb.record_latency("b", 5.0);
+
+        for _ in 0..10 {
+            assert_eq!(lb.select_backend().unwrap().address, "b");
+        }
+
+        // Enough connections in flight outweigh the latency difference
+        for _ in 0..10 {
+            lb.increment_connections("b");
+        }
+        assert_eq!(lb.select_backend().unwrap().address, "a");
+    }
+
+    #[test]
+    fn test_latency_ewma_unsampled_backend_gets_average() {
+        let lb = LoadBalancer::new(
+            vec![backend("a", 100), backend("b", 100), backend("c", 100)],
+            "latency_ewma".to_string(),
+        );
+        lb.record_latency("a", 10.0);
+        lb.record_latency("b", 30.0);
+
+        // "c" is scored at 20ms, between the two
+        lb.increment_connections("a");
+        lb.increment_connections("a");
+        assert_eq!(lb.select_backend().unwrap().address, "c");
+    }
+
+    #[test]
+    fn test_algorithm_parse_rejects_unknown() {
+        assert_eq!(Algorithm::parse("latency_ewma"), Some(Algorithm::LatencyEwma));
+        assert_eq!(Algorithm::parse("weighted"), Some(Algorithm::WeightedRoundRobin));
+        assert_eq!(Algorithm::parse("made_up"), None);
+    }
+
     #[test]
     fn test_no_healthy_backends_returns_none() {
         let backends = vec![Backend {
             address: "a".to_string(),
             weight: 100,
             healthy: false,
+            priority: 0,
+            backup: false,
+            zone: String::new(),
+            region: String::new(),
         }];
         let lb = LoadBalancer::new(backends, "round_robin".to_string());
         assert!(lb.select_backend().is_none());
     }
+
+    #[test]
+    fn test_select_sticky_follows_the_cookie() {
+        let mut down = backend("b", 100);
+        down.healthy = false;
+        let lb = LoadBalancer::new(vec![backend("a", 100), down], "round_robin".to_string());
+        let value = sticky_cookie::cookie_value("a");
+        assert_eq!(lb.select_sticky(&value).unwrap().address, "a");
+        // A backend that's down or gone is balanced away from
+        assert!(lb.select_sticky(&sticky_cookie::cookie_value("b")).is_none());
+        assert!(lb.select_sticky("not-a-backend").is_none());
+    }
 }
@@ -0,0 +1,38 @@
+use std::sync::OnceLock;
+
+type FilterSetter = Box<dyn Fn(&str) -> Result<(), String> + Send + Sync>;
+
+struct LogControl {
+    startup_filter: String,
+    set: FilterSetter,
+}
+
+static CONTROL: OnceLock<LogControl> = OnceLock::new();
+
+/// Register the hook that swaps the tracing filter at runtime. main() calls
+/// this once with a closure over its `tracing_subscriber::reload::Handle`;
+/// `startup_filter` is what `reset` goes back to.
+pub fn install(startup_filter: String, set: FilterSetter) {
+    let _ = CONTROL.set(LogControl {
+        startup_filter,
+        set,
+    });
+}
+
+/// Replace the active filter with `directives` (EnvFilter syntax, e.g.
+/// "debug" or "info,aegis_data::tcp_proxy=trace").
+pub fn set_filter(directives: &str) -> Result<(), String> {
+    let control = CONTROL
+        .get()
+        .ok_or_else(|| "runtime log control not installed".to_string())?;
+    (control.set)(directives)
+}
+
+/// Restore the filter the process started with.
+pub fn reset() -> Result<String, String> {
+    let control = CONTROL
+        .get()
+        .ok_or_else(|| "runtime log control not installed".to_string())?;
+    (control.set)(&control.startup_filter)?;
+    Ok(control.startup_filter.clone())
+}
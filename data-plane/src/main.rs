@@ -1,22 +1,37 @@
 use std::sync::Arc;
 use tokio::signal;
 use tracing::{error, info};
+use tracing_subscriber::layer::SubscriberExt;
+use tracing_subscriber::util::SubscriberInitExt;
 
 use aegis_data::config::ProxyState;
 use aegis_data::grpc_server::ProxyControlService;
-use aegis_data::{connection, metrics_server, tcp_proxy, udp_proxy};
+use aegis_data::{connection, log_control, metrics_server, tcp_proxy, udp_proxy};
 
 #[tokio::main]
 async fn main() -> Result<(), Box<dyn std::error::Error>> {
-    // Initialize tracing
-    tracing_subscriber::fmt()
-        .with_env_filter(
-            tracing_subscriber::EnvFilter::try_from_default_env()
-                .unwrap_or_else(|_| tracing_subscriber::EnvFilter::new("info")),
-        )
+    // Initialize tracing. The filter sits behind a reload layer so the
+    // control plane's debug_logging command can change it at runtime.
+    let startup_filter = std::env::var("RUST_LOG").unwrap_or_else(|_| "info".to_string());
+    let env_filter = tracing_subscriber::EnvFilter::try_new(&startup_filter)
+        .unwrap_or_else(|_| tracing_subscriber::EnvFilter::new("info"));
+    let (filter, filter_handle) = tracing_subscriber::reload::Layer::new(env_filter);
+    tracing_subscriber::registry()
+        .with(filter)
+        .with(tracing_subscriber::fmt::layer())
         .init();
-
-    info!("Starting proxy data plane v0.1.0");
+    log_control::install(
+        startup_filter,
+        Box::new(move |directives: &str| {
+            let new_filter = tracing_subscriber::EnvFilter::try_new(directives)
+                .map_err(|e| format!("invalid log filter {:?}: {}", directives, e))?;
+            filter_handle
+                .reload(new_filter)
+                .map_err(|e| format!("failed to reload log filter: {}", e))
+        }),
+    );
+
+    info!("Starting proxy data plane v{}", env!("CARGO_PKG_VERSION"));
 
     // Create shared proxy state
     let proxy_state = Arc::new(ProxyState::new());
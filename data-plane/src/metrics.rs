@@ -2,6 +2,105 @@ use parking_lot::RwLock;
 use std::collections::HashMap;
 use std::sync::atomic::{AtomicU64, Ordering};
 
+/// Upper bounds of the connect latency histogram buckets, in milliseconds.
+pub const LATENCY_BUCKETS_MS: [f64; 14] = [
+    0.5, 1.0, 2.5, 5.0, 10.0, 25.0, 50.0, 100.0, 250.0, 500.0, 1000.0, 2500.0, 5000.0, 10000.0,
+];
+
+/// Upper bounds of the connection lifetime histogram buckets, in seconds.
+pub const DURATION_BUCKETS_SECS: [f64; 11] = [
+    0.1, 0.5, 1.0, 5.0, 10.0, 30.0, 60.0, 300.0, 600.0, 1800.0, 3600.0,
+];
+
+/// Upper bounds of the per-connection byte count histogram buckets: 256B
+/// to 256MiB in powers of four.
+pub const SIZE_BUCKETS_BYTES: [f64; 10] = [
+    256.0, 1024.0, 4096.0, 16384.0, 65536.0, 262144.0, 1048576.0, 4194304.0, 16777216.0,
+    268435456.0,
+];
+
+/// A cumulative histogram over a fixed set of bucket bounds. Unlike the
+/// sample window behind `LatencyStats` it's never reset, so snapshots from
+/// several data planes can be added up and re-quantiled.
+#[derive(Debug)]
+pub struct Histogram {
+    bounds: &'static [f64],
+    // buckets[i] counts observations in (bound[i-1], bound[i]]; the last
+    // slot is everything above the largest bound
+    buckets: Box<[AtomicU64]>,
+    // Sum in thousandths of the observed unit, since there's no atomic f64
+    sum_milli: AtomicU64,
+}
+
+/// What `Histogram::snapshot` returns: `counts` are cumulative, one per
+/// bound in `bounds`.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct HistogramSnapshot {
+    pub bounds: &'static [f64],
+    pub counts: Vec<u64>,
+    pub count: u64,
+    pub sum: f64,
+}
+
+impl Histogram {
+    pub fn new(bounds: &'static [f64]) -> Self {
+        Self {
+            bounds,
+            buckets: (0..=bounds.len()).map(|_| AtomicU64::new(0)).collect(),
+            sum_milli: AtomicU64::new(0),
+        }
+    }
+
+    /// A connect latency histogram, observed in milliseconds.
+    pub fn latency() -> Self {
+        Self::new(&LATENCY_BUCKETS_MS)
+    }
+
+    pub fn observe(&self, value: f64) {
+        let i = self
+            .bounds
+            .iter()
+            .position(|&bound| value <= bound)
+            .unwrap_or(self.bounds.len());
+        self.buckets[i].fetch_add(1, Ordering::Relaxed);
+        self.sum_milli
+            .fetch_add((value * 1000.0).max(0.0) as u64, Ordering::Relaxed);
+    }
+
+    /// The count is the bucket total rather than a separate counter, so a
+    /// snapshot taken mid-observe is still consistent.
+    pub fn snapshot(&self) -> HistogramSnapshot {
+        let mut running = 0u64;
+        let counts: Vec<u64> = self.buckets[..self.bounds.len()]
+            .iter()
+            .map(|b| {
+                running += b.load(Ordering::Relaxed);
+                running
+            })
+            .collect();
+        HistogramSnapshot {
+            bounds: self.bounds,
+            counts,
+            count: running + self.buckets[self.bounds.len()].load(Ordering::Relaxed),
+            sum: self.sum_milli.load(Ordering::Relaxed) as f64 / 1000.0,
+        }
+    }
+}
+
+impl Clone for Histogram {
+    fn clone(&self) -> Self {
+        Self {
+            bounds: self.bounds,
+            buckets: self
+                .buckets
+                .iter()
+                .map(|b| AtomicU64::new(b.load(Ordering::Relaxed)))
+                .collect(),
+            sum_milli: AtomicU64::new(self.sum_milli.load(Ordering::Relaxed)),
+        }
+    }
+}
+
 pub struct MetricsCollector {
     // Connection metrics
     pub tcp_connections: AtomicU64,
@@ -17,6 +116,7 @@ pub struct MetricsCollector {
 
     // Performance metrics
     latency_samples: RwLock<Vec<f64>>,
+    latency_histogram: Histogram,
 
     // Per-backend metrics
     backend_metrics: RwLock<HashMap<String, BackendMetrics>>,
@@ -32,6 +132,15 @@ pub struct MetricsCollector {
     // Connection pool metrics
     pub pool_hits: AtomicU64,
     pub pool_misses: AtomicU64,
+
+    // Traffic mirroring metrics
+    pub mirror_connections: AtomicU64,
+    pub mirror_failures: AtomicU64,
+    pub mirror_dropped: AtomicU64,
+
+    // Connection limit metrics
+    pub connection_limit_rejected: AtomicU64,
+    pub connection_queue_timeouts: AtomicU64,
 }
 
 #[derive(Debug)]
@@ -39,8 +148,15 @@ pub struct BackendMetrics {
     pub connections: AtomicU64,
     pub requests: AtomicU64,
     pub failures: AtomicU64,
+    // The failures before a connection was made, not in requests
+    pub connect_failures: AtomicU64,
     pub bytes_sent: AtomicU64,
     pub bytes_received: AtomicU64,
+    pub latency: Histogram,
+    // Observed once per connection (or UDP session) as it closes
+    pub connection_duration: Histogram,
+    pub request_bytes: Histogram,
+    pub response_bytes: Histogram,
 }
 
 impl Clone for BackendMetrics {
@@ -49,8 +165,13 @@ impl Clone for BackendMetrics {
             connections: AtomicU64::new(self.connections.load(Ordering::Relaxed)),
             requests: AtomicU64::new(self.requests.load(Ordering::Relaxed)),
             failures: AtomicU64::new(self.failures.load(Ordering::Relaxed)),
+            connect_failures: AtomicU64::new(self.connect_failures.load(Ordering::Relaxed)),
             bytes_sent: AtomicU64::new(self.bytes_sent.load(Ordering::Relaxed)),
             bytes_received: AtomicU64::new(self.bytes_received.load(Ordering::Relaxed)),
+            latency: self.latency.clone(),
+            connection_duration: self.connection_duration.clone(),
+            request_bytes: self.request_bytes.clone(),
+            response_bytes: self.response_bytes.clone(),
         }
     }
 }
@@ -61,8 +182,13 @@ impl BackendMetrics {
             connections: AtomicU64::new(0),
             requests: AtomicU64::new(0),
             failures: AtomicU64::new(0),
+            connect_failures: AtomicU64::new(0),
             bytes_sent: AtomicU64::new(0),
             bytes_received: AtomicU64::new(0),
+            latency: Histogram::latency(),
+            connection_duration: Histogram::new(&DURATION_BUCKETS_SECS),
+            request_bytes: Histogram::new(&SIZE_BUCKETS_BYTES),
+            response_bytes: Histogram::new(&SIZE_BUCKETS_BYTES),
         }
     }
 }
@@ -79,6 +205,7 @@ impl MetricsCollector {
             packets_sent: AtomicU64::new(0),
             packets_received: AtomicU64::new(0),
             latency_samples: RwLock::new(Vec::new()),
+            latency_histogram: Histogram::latency(),
             backend_metrics: RwLock::new(HashMap::new()),
             rate_limit_allowed: AtomicU64::new(0),
             rate_limit_denied: AtomicU64::new(0),
@@ -86,6 +213,11 @@ impl MetricsCollector {
             circuit_breaker_half_open: AtomicU64::new(0),
             pool_hits: AtomicU64::new(0),
             pool_misses: AtomicU64::new(0),
+            mirror_connections: AtomicU64::new(0),
+            mirror_failures: AtomicU64::new(0),
+            mirror_dropped: AtomicU64::new(0),
+            connection_limit_rejected: AtomicU64::new(0),
+            connection_queue_timeouts: AtomicU64::new(0),
         }
     }
 
@@ -128,6 +260,7 @@ impl MetricsCollector {
 
     // Latency tracking
     pub fn record_latency(&self, duration_ms: f64) {
+        self.latency_histogram.observe(duration_ms);
         let mut samples = self.latency_samples.write();
         samples.push(duration_ms);
 
@@ -138,6 +271,26 @@ impl MetricsCollector {
         }
     }
 
+    /// Discard the latency sample window so avg/p99 reflect only traffic from
+    /// now on. Counters and histograms are left alone: they're cumulative,
+    /// and the control plane derives its increments from them.
+    pub fn flush_latency_samples(&self) {
+        self.latency_samples.write().clear();
+    }
+
+    pub fn latency_histogram(&self) -> HistogramSnapshot {
+        self.latency_histogram.snapshot()
+    }
+
+    pub fn record_backend_latency(&self, backend: &str, duration_ms: f64) {
+        let mut backends = self.backend_metrics.write();
+        backends
+            .entry(backend.to_string())
+            .or_insert_with(BackendMetrics::new)
+            .latency
+            .observe(duration_ms);
+    }
+
     pub fn get_latency_stats(&self) -> LatencyStats {
         let samples = self.latency_samples.read();
 
@@ -185,6 +338,17 @@ impl MetricsCollector {
             .fetch_add(1, Ordering::Relaxed);
     }
 
+    /// Records a failure before any connection to the backend was made,
+    /// which is also one of its failures.
+    pub fn record_backend_connect_failure(&self, backend: &str) {
+        let mut backends = self.backend_metrics.write();
+        let metrics = backends
+            .entry(backend.to_string())
+            .or_insert_with(BackendMetrics::new);
+        metrics.failures.fetch_add(1, Ordering::Relaxed);
+        metrics.connect_failures.fetch_add(1, Ordering::Relaxed);
+    }
+
     pub fn record_backend_bytes_sent(&self, backend: &str, bytes: u64) {
         let mut backends = self.backend_metrics.write();
         backends
@@ -203,6 +367,24 @@ impl MetricsCollector {
             .fetch_add(bytes, Ordering::Relaxed);
     }
 
+    /// Record a finished connection (or expired UDP session) to `backend`:
+    /// how long it lasted and how many bytes went each way.
+    pub fn record_backend_connection_closed(
+        &self,
+        backend: &str,
+        duration_secs: f64,
+        bytes_sent: u64,
+        bytes_received: u64,
+    ) {
+        let mut backends = self.backend_metrics.write();
+        let metrics = backends
+            .entry(backend.to_string())
+            .or_insert_with(BackendMetrics::new);
+        metrics.connection_duration.observe(duration_secs);
+        metrics.request_bytes.observe(bytes_sent as f64);
+        metrics.response_bytes.observe(bytes_received as f64);
+    }
+
     pub fn get_backend_metrics(&self) -> HashMap<String, BackendMetrics> {
         self.backend_metrics.read().clone()
     }
@@ -235,6 +417,28 @@ impl MetricsCollector {
         self.pool_misses.fetch_add(1, Ordering::Relaxed);
     }
 
+    // Traffic mirroring metrics
+    pub fn record_mirror_connection(&self) {
+        self.mirror_connections.fetch_add(1, Ordering::Relaxed);
+    }
+
+    pub fn record_mirror_failure(&self) {
+        self.mirror_failures.fetch_add(1, Ordering::Relaxed);
+    }
+
+    pub fn record_mirror_dropped(&self) {
+        self.mirror_dropped.fetch_add(1, Ordering::Relaxed);
+    }
+
+    // Connection limit metrics
+    pub fn record_connection_limit_rejected(&self) {
+        self.connection_limit_rejected.fetch_add(1, Ordering::Relaxed);
+    }
+
+    pub fn record_connection_queue_timeout(&self) {
+        self.connection_queue_timeouts.fetch_add(1, Ordering::Relaxed);
+    }
+
     // Get summary for logging/monitoring
     pub fn get_summary(&self) -> MetricsSummary {
         MetricsSummary {
@@ -252,6 +456,11 @@ impl MetricsCollector {
             circuit_breaker_half_open: self.circuit_breaker_half_open.load(Ordering::Relaxed),
             pool_hits: self.pool_hits.load(Ordering::Relaxed),
             pool_misses: self.pool_misses.load(Ordering::Relaxed),
+            mirror_connections: self.mirror_connections.load(Ordering::Relaxed),
+            mirror_failures: self.mirror_failures.load(Ordering::Relaxed),
+            mirror_dropped: self.mirror_dropped.load(Ordering::Relaxed),
+            connection_limit_rejected: self.connection_limit_rejected.load(Ordering::Relaxed),
+            connection_queue_timeouts: self.connection_queue_timeouts.load(Ordering::Relaxed),
             latency: self.get_latency_stats(),
         }
     }
@@ -298,5 +507,10 @@ pub struct MetricsSummary {
     pub circuit_breaker_half_open: u64,
     pub pool_hits: u64,
     pub pool_misses: u64,
+    pub mirror_connections: u64,
+    pub mirror_failures: u64,
+    pub mirror_dropped: u64,
+    pub connection_limit_rejected: u64,
+    pub connection_queue_timeouts: u64,
     pub latency: LatencyStats,
 }
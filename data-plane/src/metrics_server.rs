@@ -147,6 +147,41 @@ fn render_metrics(state: &Arc<ProxyState>) -> Result<Vec<u8>, prometheus::Error>
         "Total backend connections that required a fresh dial (pool empty)",
         summary.pool_misses
     );
+    counter_total!(
+        "proxy_mirror_connections_total",
+        "Total TCP connections copied to a shadow backend",
+        summary.mirror_connections
+    );
+    counter_total!(
+        "proxy_mirror_failures_total",
+        "Total shadow backend connections that failed to connect or write",
+        summary.mirror_failures
+    );
+    counter_total!(
+        "proxy_mirror_dropped_total",
+        "Total shadow backend connections cut off for falling behind the client",
+        summary.mirror_dropped
+    );
+    counter_total!(
+        "proxy_connection_limit_rejected_total",
+        "Total TCP connections closed for being over the connection limit with no room to queue",
+        summary.connection_limit_rejected
+    );
+    counter_total!(
+        "proxy_connection_queue_timeouts_total",
+        "Total TCP connections closed after queueing for the whole queue timeout",
+        summary.connection_queue_timeouts
+    );
+    gauge!(
+        "proxy_connections_queued",
+        "TCP connections waiting for a slot under the connection limit",
+        state.connection_limiter.queued() as f64
+    );
+    gauge!(
+        "proxy_connection_limit",
+        "Most TCP connections proxied at once, 0 for no limit",
+        state.connection_limiter.limit() as f64
+    );
     gauge!(
         "proxy_latency_avg_ms",
         "Average backend connect latency in milliseconds",
@@ -0,0 +1,74 @@
+use std::sync::Arc;
+use std::time::Duration;
+use tokio::io::{AsyncReadExt, AsyncWriteExt};
+use tokio::net::TcpStream;
+use tokio::sync::mpsc;
+use tracing::debug;
+
+use crate::config::ProxyState;
+
+/// How many chunks of client data a shadow connection may fall behind by
+/// before it's cut off; a slow shadow must never hold the client up.
+const MIRROR_QUEUE: usize = 64;
+
+/// How long a shadow gets to finish its response once the client is done,
+/// before the connection to it is dropped.
+const MIRROR_LINGER: Duration = Duration::from_secs(1);
+
+/// Opens a copy of a client connection to the shadow backend. Chunks sent
+/// on the returned channel are written to the shadow in order, and what it
+/// sends back is read and thrown away. Dropping the sender ends the copy;
+/// a failed send means the shadow is gone or fell behind, and the sender
+/// should be dropped then too, since a stream with a gap is of no use to
+/// it. Shadows have no part in health, circuit breaking or the backend
+/// metrics.
+pub fn open(
+    shadow: String,
+    state: Arc<ProxyState>,
+    connect_timeout: Duration,
+) -> mpsc::Sender<Vec<u8>> {
+    let (tx, mut rx) = mpsc::channel::<Vec<u8>>(MIRROR_QUEUE);
+    state.metrics.record_mirror_connection();
+
+    tokio::spawn(async move {
+        let connect = tokio::time::timeout(connect_timeout, TcpStream::connect(&shadow));
+        let stream = match connect.await {
+            Ok(Ok(stream)) => stream,
+            Ok(Err(e)) => {
+                debug!("Failed to connect to shadow backend {}: {}", shadow, e);
+                state.metrics.record_mirror_failure();
+                return;
+            }
+            Err(_) => {
+                debug!("Timeout connecting to shadow backend {}", shadow);
+                state.metrics.record_mirror_failure();
+                return;
+            }
+        };
+        let (mut shadow_read, mut shadow_write) = stream.into_split();
+
+        // Keep reading so a chatty shadow never stalls on a full buffer
+        let mut discard = tokio::spawn(async move {
+            let mut buf = vec![0u8; 8192];
+            while let Ok(n) = shadow_read.read(&mut buf).await {
+                if n == 0 {
+                    break;
+                }
+            }
+        });
+
+        while let Some(chunk) = rx.recv().await {
+            if let Err(e) = shadow_write.write_all(&chunk).await {
+                debug!("Shadow backend {} write error: {}", shadow, e);
+                state.metrics.record_mirror_failure();
+                break;
+            }
+        }
+        let _ = shadow_write.shutdown().await;
+        if tokio::time::timeout(MIRROR_LINGER, &mut discard).await.is_err() {
+            discard.abort();
+        }
+    });
+
+    tx
+}
@@ -0,0 +1,233 @@
+//! The secrets the control plane pushes apart from the config, by name and
+//! version: the certificates TLS is terminated with, and opaque tokens.
+
+use std::collections::{BTreeMap, HashSet};
+
+use crate::config::proxy;
+use crate::tls;
+
+/// The secrets held, each at the version last accepted.
+#[derive(Default)]
+pub struct SecretStore {
+    secrets: BTreeMap<String, proxy::Secret>,
+    default_certificate: String,
+}
+
+fn is_certificate(secret: &proxy::Secret) -> bool {
+    matches!(secret.kind, Some(proxy::secret::Kind::TlsCertificate(_)))
+}
+
+fn validate(secret: &proxy::Secret) -> Result<(), String> {
+    if secret.name.is_empty() {
+        return Err("secret has no name".to_string());
+    }
+    match &secret.kind {
+        Some(proxy::secret::Kind::TlsCertificate(cert)) => tls::validate(cert),
+        Some(proxy::secret::Kind::Token(token)) if token.is_empty() => {
+            Err(format!("token {} is empty", secret.name))
+        }
+        Some(proxy::secret::Kind::Token(_)) => Ok(()),
+        None => Err(format!("secret {} has no content", secret.name)),
+    }
+}
+
+impl SecretStore {
+    /// Applies an update, checking each secret on its own: a rejected one
+    /// leaves the version held before in use. Returns the ack, and whether
+    /// the certificates changed.
+    pub fn apply(&mut self, update: &proxy::SecretUpdate) -> (proxy::SecretAck, bool) {
+        let mut certificates_changed = self.default_certificate != update.default_certificate;
+        if update.full {
+            let sent: HashSet<&str> = update.secrets.iter().map(|s| s.name.as_str()).collect();
+            self.secrets.retain(|name, secret| {
+                let keep = sent.contains(name.as_str());
+                certificates_changed |= !keep && is_certificate(secret);
+                keep
+            });
+        }
+        for name in &update.removed {
+            if let Some(secret) = self.secrets.remove(name) {
+                certificates_changed |= is_certificate(&secret);
+            }
+        }
+
+        let mut statuses = Vec::with_capacity(update.secrets.len());
+        for secret in &update.secrets {
+            let held = self.secrets.get(&secret.name);
+            // The same version is the same content, checked already
+            let unchanged = !secret.version.is_empty()
+                && held.map_or(false, |held| held.version == secret.version);
+            let result = if unchanged { Ok(()) } else { validate(secret) };
+            if result.is_ok() && !unchanged {
+                certificates_changed |=
+                    is_certificate(secret) || held.map_or(false, is_certificate);
+                self.secrets.insert(secret.name.clone(), secret.clone());
+            }
+            statuses.push(proxy::SecretStatus {
+                name: secret.name.clone(),
+                version: secret.version.clone(),
+                accepted: result.is_ok(),
+                error: result.err().unwrap_or_default(),
+            });
+        }
+        self.default_certificate = update.default_certificate.clone();
+
+        let ack = proxy::SecretAck {
+            nonce: update.nonce,
+            secrets: statuses,
+        };
+        (ack, certificates_changed)
+    }
+
+    /// The update replacing every certificate with those of an
+    /// UpdateSecrets, the first being the default, and leaving the tokens
+    /// as they are.
+    pub fn certificates_update(
+        &self,
+        certificates: &[proxy::TlsCertificate],
+    ) -> proxy::SecretUpdate {
+        let sent: HashSet<&str> = certificates.iter().map(|c| c.name.as_str()).collect();
+        proxy::SecretUpdate {
+            secrets: certificates
+                .iter()
+                .map(|cert| proxy::Secret {
+                    name: cert.name.clone(),
+                    version: String::new(),
+                    kind: Some(proxy::secret::Kind::TlsCertificate(cert.clone())),
+                })
+                .collect(),
+            removed: self
+                .secrets
+                .iter()
+                .filter(|(name, secret)| is_certificate(secret) && !sent.contains(name.as_str()))
+                .map(|(name, _)| name.clone())
+                .collect(),
+            default_certificate: certificates
+                .first()
+                .map(|c| c.name.clone())
+                .unwrap_or_default(),
+            ..Default::default()
+        }
+    }
+
+    /// The certificates held, the default first, then by name.
+    pub fn certificates(&self) -> Vec<&proxy::TlsCertificate> {
+        let mut certificates: Vec<(&String, &proxy::TlsCertificate)> = self
+            .secrets
+            .iter()
+            .filter_map(|(name, secret)| match &secret.kind {
+                Some(proxy::secret::Kind::TlsCertificate(cert)) => Some((name, cert)),
+                _ => None,
+            })
+            .collect();
+        if let Some(i) = certificates
+            .iter()
+            .position(|(name, _)| **name == self.default_certificate)
+        {
+            let default = certificates.remove(i);
+            certificates.insert(0, default);
+        }
+        certificates.into_iter().map(|(_, cert)| cert).collect()
+    }
+
+    /// The token held as name.
+    pub fn token(&self, name: &str) -> Option<&[u8]> {
+        match &self.secrets.get(name)?.kind {
+            Some(proxy::secret::Kind::Token(token)) => Some(token),
+            _ => None,
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn token(name: &str, version: &str, value: &str) -> proxy::Secret {
+        proxy::Secret {
+            name: name.to_string(),
+            version: version.to_string(),
+            kind: Some(proxy::secret::Kind::Token(value.as_bytes().to_vec())),
+        }
+    }
+
+    fn bad_certificate(name: &str) -> proxy::Secret {
+        proxy::Secret {
+            name: name.to_string(),
+            version: "1".to_string(),
+            kind: Some(proxy::secret::Kind::TlsCertificate(proxy::TlsCertificate {
+                name: name.to_string(),
+                certificate_chain: b"not a certificate".to_vec(),
+                private_key: vec![],
+                server_names: vec!["example.com".to_string()],
+            })),
+        }
+    }
+
+    #[test]
+    fn test_apply_acks_each_secret() {
+        let mut store = SecretStore::default();
+        let (ack, changed) = store.apply(&proxy::SecretUpdate {
+            nonce: 1,
+            full: true,
+            secrets: vec![token("backend", "a", "s3cret"), bad_certificate("site")],
+            ..Default::default()
+        });
+        assert_eq!(ack.nonce, 1);
+        assert!(ack.secrets[0].accepted);
+        assert!(!ack.secrets[1].accepted && ack.secrets[1].error.contains("certificate site"));
+        assert!(!changed, "a rejected certificate changes nothing");
+        assert_eq!(store.token("backend"), Some(&b"s3cret"[..]));
+
+        // A rejected new version leaves the old one in use
+        let (ack, _) = store.apply(&proxy::SecretUpdate {
+            nonce: 2,
+            secrets: vec![token("backend", "b", "")],
+            ..Default::default()
+        });
+        assert!(!ack.secrets[0].accepted);
+        assert_eq!(store.token("backend"), Some(&b"s3cret"[..]));
+
+        store.apply(&proxy::SecretUpdate {
+            nonce: 3,
+            removed: vec!["backend".to_string()],
+            ..Default::default()
+        });
+        assert_eq!(store.token("backend"), None);
+    }
+
+    #[test]
+    fn test_full_update_drops_what_it_leaves_out() {
+        let mut store = SecretStore::default();
+        store.apply(&proxy::SecretUpdate {
+            nonce: 1,
+            full: true,
+            secrets: vec![token("a", "1", "x"), token("b", "1", "y")],
+            ..Default::default()
+        });
+        store.apply(&proxy::SecretUpdate {
+            nonce: 1,
+            full: true,
+            secrets: vec![token("b", "1", "y")],
+            ..Default::default()
+        });
+        assert_eq!(store.token("a"), None);
+        assert_eq!(store.token("b"), Some(&b"y"[..]));
+    }
+
+    #[test]
+    fn test_certificates_update_keeps_tokens() {
+        let mut store = SecretStore::default();
+        store.apply(&proxy::SecretUpdate {
+            nonce: 1,
+            full: true,
+            secrets: vec![token("backend", "1", "x")],
+            ..Default::default()
+        });
+        let update = store.certificates_update(&[]);
+        assert!(update.removed.is_empty());
+        store.apply(&update);
+        assert!(store.token("backend").is_some());
+        assert!(store.certificates().is_empty());
+    }
+}
@@ -0,0 +1,248 @@
+use std::sync::Arc;
+
+use crate::config::{Backend, SniRoute};
+use crate::load_balancer::LoadBalancer;
+
+/// A TLS record header: content type, version, length
+const RECORD_HEADER_LEN: usize = 5;
+const CONTENT_TYPE_HANDSHAKE: u8 = 22;
+const HANDSHAKE_CLIENT_HELLO: u8 = 1;
+const EXTENSION_SERVER_NAME: u16 = 0;
+const NAME_TYPE_HOST_NAME: u8 = 0;
+
+/// Picks the backends of a TLS connection from the server name in its
+/// ClientHello, which is passed on to the backend untouched. Rebuilt on
+/// every config push and backend reload.
+pub struct SniRouter {
+    /// Server names, exact or "*.domain", with the index of their route
+    names: Vec<(String, usize)>,
+    load_balancers: Vec<Arc<LoadBalancer>>,
+}
+
+impl SniRouter {
+    /// A router over routes, each balanced by a load balancer build makes
+    /// from its backends.
+    pub fn new(routes: &[SniRoute], build: impl Fn(Vec<Backend>) -> LoadBalancer) -> Self {
+        let mut names = Vec::new();
+        let mut load_balancers = Vec::with_capacity(routes.len());
+        for (i, route) in routes.iter().enumerate() {
+            names.extend(route.server_names.iter().map(|n| (n.to_ascii_lowercase(), i)));
+            load_balancers.push(Arc::new(build(route.backends.clone())));
+        }
+        Self {
+            names,
+            load_balancers,
+        }
+    }
+
+    pub fn empty() -> Self {
+        Self {
+            names: Vec::new(),
+            load_balancers: Vec::new(),
+        }
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.names.is_empty()
+    }
+
+    /// The load balancer of the route naming server most specifically: an
+    /// exact name over any wildcard, and a longer wildcard over a shorter.
+    /// "*.example.com" matches every host under example.com but not
+    /// example.com itself.
+    pub fn route(&self, server: &str) -> Option<Arc<LoadBalancer>> {
+        let server = server.to_ascii_lowercase();
+        let mut best: Option<(usize, usize)> = None;
+        for (name, route) in &self.names {
+            let specificity = if *name == server {
+                usize::MAX
+            } else if let Some(domain) = name.strip_prefix('*') {
+                if server.len() > domain.len() && server.ends_with(domain) {
+                    domain.len()
+                } else {
+                    continue;
+                }
+            } else {
+                continue;
+            };
+            if best.map_or(true, |(s, _)| specificity > s) {
+                best = Some((specificity, *route));
+            }
+        }
+        best.map(|(_, route)| self.load_balancers[route].clone())
+    }
+}
+
+/// How many bytes a TLS ClientHello record starting buf takes, once its
+/// header is in; None when buf isn't the start of a handshake record.
+pub fn client_hello_len(buf: &[u8]) -> Option<usize> {
+    if buf.len() < RECORD_HEADER_LEN || buf[0] != CONTENT_TYPE_HANDSHAKE {
+        return None;
+    }
+    Some(RECORD_HEADER_LEN + u16::from_be_bytes([buf[3], buf[4]]) as usize)
+}
+
+/// The host name a TLS ClientHello record asks for with the server_name
+/// extension, or None when it doesn't send one or isn't a ClientHello. Only
+/// the first record is read; a ClientHello split over several fails to
+/// parse and goes unrouted.
+pub fn server_name(record: &[u8]) -> Option<String> {
+    let len = client_hello_len(record)?;
+    let mut r = Reader(record.get(RECORD_HEADER_LEN..len)?);
+    if r.u8()? != HANDSHAKE_CLIENT_HELLO {
+        return None;
+    }
+    let mut hello = Reader(r.take(r.u24()?)?);
+    hello.take(2 + 32)?; // client version, random
+    let session_id = hello.u8()? as usize;
+    hello.take(session_id)?;
+    let cipher_suites = hello.u16()? as usize;
+    hello.take(cipher_suites)?;
+    let compression_methods = hello.u8()? as usize;
+    hello.take(compression_methods)?;
+    let extensions = hello.u16()? as usize;
+    let mut extensions = Reader(hello.take(extensions)?);
+    while !extensions.0.is_empty() {
+        let kind = extensions.u16()?;
+        let len = extensions.u16()? as usize;
+        let data = extensions.take(len)?;
+        if kind != EXTENSION_SERVER_NAME {
+            continue;
+        }
+        let mut list = Reader(data);
+        let len = list.u16()? as usize;
+        let mut names = Reader(list.take(len)?);
+        while !names.0.is_empty() {
+            let name_type = names.u8()?;
+            let len = names.u16()? as usize;
+            let name = names.take(len)?;
+            if name_type == NAME_TYPE_HOST_NAME {
+                return std::str::from_utf8(name).ok().map(str::to_string);
+            }
+        }
+        return None;
+    }
+    None
+}
+
+/// Reads big-endian fields off the front of a byte slice.
+struct Reader<'a>(&'a [u8]);
+
+impl<'a> Reader<'a> {
+    fn take(&mut self, n: usize) -> Option<&'a [u8]> {
+        if n > self.0.len() {
+            return None;
+        }
+        let (head, rest) = self.0.split_at(n);
+        self.0 = rest;
+        Some(head)
+    }
+
+    fn u8(&mut self) -> Option<u8> {
+        self.take(1).map(|b| b[0])
+    }
+
+    fn u16(&mut self) -> Option<u16> {
+        self.take(2).map(|b| u16::from_be_bytes([b[0], b[1]]))
+    }
+
+    fn u24(&mut self) -> Option<usize> {
+        self.take(3)
+            .map(|b| ((b[0] as usize) << 16) | ((b[1] as usize) << 8) | b[2] as usize)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// A minimal ClientHello record, with a server_name extension for
+    /// server when it's given.
+    fn client_hello(server: Option<&str>) -> Vec<u8> {
+        let mut extensions = Vec::new();
+        // An extension before server_name, to be skipped
+        extensions.extend_from_slice(&[0x00, 0x0b, 0x00, 0x02, 0x01, 0x00]);
+        if let Some(server) = server {
+            let name = server.as_bytes();
+            let list_len = 3 + name.len();
+            extensions.extend_from_slice(&EXTENSION_SERVER_NAME.to_be_bytes());
+            extensions.extend_from_slice(&((list_len + 2) as u16).to_be_bytes());
+            extensions.extend_from_slice(&(list_len as u16).to_be_bytes());
+            extensions.push(NAME_TYPE_HOST_NAME);
+            extensions.extend_from_slice(&(name.len() as u16).to_be_bytes());
+            extensions.extend_from_slice(name);
+        }
+
+        let mut hello = vec![0x03, 0x03];
+        hello.extend_from_slice(&[0u8; 32]);
+        hello.push(0); // no session ID
+        hello.extend_from_slice(&[0x00, 0x02, 0x13, 0x01]);
+        hello.extend_from_slice(&[0x01, 0x00]);
+        hello.extend_from_slice(&(extensions.len() as u16).to_be_bytes());
+        hello.extend_from_slice(&extensions);
+
+        let mut handshake = vec![HANDSHAKE_CLIENT_HELLO];
+        handshake.extend_from_slice(&(hello.len() as u32).to_be_bytes()[1..]);
+        handshake.extend_from_slice(&hello);
+
+        let mut record = vec![CONTENT_TYPE_HANDSHAKE, 0x03, 0x01];
+        record.extend_from_slice(&(handshake.len() as u16).to_be_bytes());
+        record.extend_from_slice(&handshake);
+        record
+    }
+
+    fn backend(address: &str) -> Backend {
+        Backend {
+            address: address.to_string(),
+            weight: 100,
+            healthy: true,
+            priority: 0,
+            backup: false,
+            zone: String::new(),
+            region: String::new(),
+        }
+    }
+
+    fn route(names: &[&str], address: &str) -> SniRoute {
+        SniRoute {
+            server_names: names.iter().map(|n| n.to_string()).collect(),
+            backends: vec![backend(address)],
+        }
+    }
+
+    fn routed(router: &SniRouter, server: &str) -> Option<String> {
+        router
+            .route(server)
+            .map(|lb| lb.select_backend().unwrap().address)
+    }
+
+    #[test]
+    fn test_server_name_from_client_hello() {
+        let record = client_hello(Some("api.example.com"));
+        assert_eq!(client_hello_len(&record), Some(record.len()));
+        assert_eq!(server_name(&record), Some("api.example.com".to_string()));
+
+        assert_eq!(server_name(&client_hello(None)), None);
+        // Truncated before all of the record is in
+        assert_eq!(server_name(&record[..record.len() - 4]), None);
+        assert_eq!(server_name(b"GET / HTTP/1.1\r\n"), None);
+    }
+
+    #[test]
+    fn test_most_specific_name_wins() {
+        let router = SniRouter::new(
+            &[
+                route(&["*.example.com"], "wild:443"),
+                route(&["*.api.example.com"], "api-wild:443"),
+                route(&["api.example.com"], "api:443"),
+            ],
+            |backends| LoadBalancer::new(backends, "round_robin".to_string()),
+        );
+        assert_eq!(routed(&router, "API.example.com"), Some("api:443".to_string()));
+        assert_eq!(routed(&router, "v2.api.example.com"), Some("api-wild:443".to_string()));
+        assert_eq!(routed(&router, "www.example.com"), Some("wild:443".to_string()));
+        // A wildcard needs a label of its own
+        assert_eq!(routed(&router, "example.com"), None);
+        assert_eq!(routed(&router, "example.org"), None);
+    }
+}
@@ -0,0 +1,103 @@
+use crate::config::StickyCookie;
+
+/// FNV-1a's 64-bit offset basis and prime: a hash that's the same on every
+/// data plane and across restarts, unlike std's
+const FNV_OFFSET: u64 = 0xcbf2_9ce4_8422_2325;
+const FNV_PRIME: u64 = 0x0100_0000_01b3;
+
+/// The sticky cookie value naming a backend: a hash of its address, so the
+/// address isn't handed to clients and every data plane agrees on it.
+pub fn cookie_value(address: &str) -> String {
+    let mut hash = FNV_OFFSET;
+    for b in address.bytes() {
+        hash ^= b as u64;
+        hash = hash.wrapping_mul(FNV_PRIME);
+    }
+    format!("{:016x}", hash)
+}
+
+/// The Set-Cookie header line, CRLF included, pinning a client to the
+/// backend value names.
+pub fn set_cookie_header(cookie: &StickyCookie, value: &str) -> String {
+    let mut header = format!("Set-Cookie: {}={}; Path={}", cookie.name, value, cookie.path);
+    if cookie.ttl_secs > 0 {
+        header.push_str(&format!("; Max-Age={}", cookie.ttl_secs));
+    }
+    if cookie.secure {
+        header.push_str("; Secure");
+    }
+    if cookie.http_only {
+        header.push_str("; HttpOnly");
+    }
+    header.push_str("\r\n");
+    header
+}
+
+/// Inserts header after the status line of the HTTP/1 response buf starts
+/// with. None when buf doesn't start with a whole status line, or with an
+/// informational 1xx one, which a final response follows.
+pub fn insert_header(buf: &[u8], header: &str) -> Option<Vec<u8>> {
+    if !buf.starts_with(b"HTTP/1.") || buf.get(9) == Some(&b'1') {
+        return None;
+    }
+    let end = buf.windows(2).position(|w| w == b"\r\n")? + 2;
+    let mut out = Vec::with_capacity(buf.len() + header.len());
+    out.extend_from_slice(&buf[..end]);
+    out.extend_from_slice(header.as_bytes());
+    out.extend_from_slice(&buf[end..]);
+    Some(out)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn cookie() -> StickyCookie {
+        StickyCookie {
+            name: "aegis_backend".to_string(),
+            ttl_secs: 3600,
+            path: "/".to_string(),
+            secure: true,
+            http_only: true,
+        }
+    }
+
+    #[test]
+    fn test_cookie_value_is_stable() {
+        assert_eq!(cookie_value("10.0.0.1:3000"), cookie_value("10.0.0.1:3000"));
+        assert_ne!(cookie_value("10.0.0.1:3000"), cookie_value("10.0.0.2:3000"));
+        assert_eq!(cookie_value("").len(), 16);
+        assert_eq!(cookie_value(""), "cbf29ce484222325");
+    }
+
+    #[test]
+    fn test_set_cookie_header() {
+        assert_eq!(
+            set_cookie_header(&cookie(), "abc"),
+            "Set-Cookie: aegis_backend=abc; Path=/; Max-Age=3600; Secure; HttpOnly\r\n"
+        );
+        let session = StickyCookie {
+            ttl_secs: 0,
+            secure: false,
+            http_only: false,
+            ..cookie()
+        };
+        assert_eq!(
+            set_cookie_header(&session, "abc"),
+            "Set-Cookie: aegis_backend=abc; Path=/\r\n"
+        );
+    }
+
+    #[test]
+    fn test_insert_header_after_status_line() {
+        let header = "Set-Cookie: a=b\r\n";
+        let got = insert_header(b"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n", header);
+        assert_eq!(
+            got.as_deref(),
+            Some(&b"HTTP/1.1 200 OK\r\nSet-Cookie: a=b\r\nContent-Length: 0\r\n\r\n"[..])
+        );
+        assert!(insert_header(b"HTTP/1.1 100 Continue\r\n\r\n", header).is_none());
+        assert!(insert_header(b"HTTP/1.1 200 O", header).is_none());
+        assert!(insert_header(b"\x16\x03\x01", header).is_none());
+    }
+}
@@ -1,5 +1,6 @@
 use std::sync::atomic::{AtomicU64, Ordering};
 use std::sync::Arc;
+use std::time::Duration;
 use tokio::io::{AsyncReadExt, AsyncWriteExt};
 use tokio::net::{TcpListener, TcpStream};
 use tracing::{debug, error, info, warn};
@@ -7,7 +8,25 @@ use tracing::{debug, error, info, warn};
 use crate::access_log::AccessLogEntry;
 use crate::config::ProxyState;
 use crate::connection::ConnectionPool;
+use crate::connection_limit::Overflow;
+use crate::events;
 use crate::load_balancer::LoadBalancer;
+use crate::mirror;
+use crate::sni;
+use crate::sticky_cookie;
+use crate::tls::ClientStream;
+
+/// Most of a request head peeked for header rules, a header or cookie hash
+/// key or a sticky cookie; a longer head matches no rule, hashes the source
+/// IP and gets a new sticky cookie.
+const HEAD_PEEK_BYTES: usize = 8192;
+/// Most of a TLS ClientHello peeked for SNI routes: a whole record.
+const HELLO_PEEK_BYTES: usize = 5 + 16384;
+/// How long to wait for the client's request head or ClientHello before
+/// going on without it.
+const PEEK_TIMEOUT: Duration = Duration::from_millis(500);
+/// How long a client gets to finish a terminated TLS handshake.
+const TLS_HANDSHAKE_TIMEOUT: Duration = Duration::from_secs(10);
 
 pub async fn run(
     state: Arc<ProxyState>,
@@ -33,22 +52,43 @@ pub async fn run(
             }
         };
 
+        if state.is_paused() {
+            // Closing right away lets the client fail over instead of
+            // waiting in the accept backlog for a resume
+            debug!("Traffic paused, refusing connection from {}", client_addr);
+            drop(client_socket);
+            continue;
+        }
+
         debug!("Accepted connection from {}", client_addr);
 
         let state_clone = state.clone();
         let lb_clone = state.get_tcp_lb();
-        let config_clone = config.clone();
         let pool_clone = pool.clone();
 
         tokio::spawn(async move {
-            if let Err(e) = handle_connection(
-                client_socket,
-                state_clone,
-                lb_clone,
-                config_clone,
-                pool_clone,
-            )
-            .await
+            // Waited for here rather than in the accept loop, so a full
+            // queue never holds up turning other connections away
+            let _permit = match state_clone.connection_limiter.acquire().await {
+                Ok(permit) => permit,
+                Err(overflow) => {
+                    debug!(
+                        "Connection limit reached, closing connection from {} ({:?})",
+                        client_addr, overflow
+                    );
+                    match overflow {
+                        Overflow::Rejected => {
+                            state_clone.metrics.record_connection_limit_rejected()
+                        }
+                        Overflow::TimedOut => {
+                            state_clone.metrics.record_connection_queue_timeout()
+                        }
+                    }
+                    return;
+                }
+            };
+            if let Err(e) =
+                handle_connection(client_socket, state_clone, lb_clone, pool_clone).await
             {
                 error!("Connection error: {}", e);
             }
@@ -59,12 +99,16 @@ pub async fn run(
 }
 
 async fn handle_connection(
-    mut client: TcpStream,
+    client: TcpStream,
     state: Arc<ProxyState>,
     load_balancer: Arc<LoadBalancer>,
-    config: crate::config::ProxyConfig,
     pool: Arc<ConnectionPool>,
 ) -> Result<(), Box<dyn std::error::Error>> {
+    // The running config, read for each connection like the routers below,
+    // so a reload or push reaches the next connection; only the listen
+    // address is taken once, in run
+    let config = state.get_config().ok_or("Proxy not configured")?;
+
     // Get client address for rate limiting and logging
     let client_addr = client.peer_addr()?;
 
@@ -92,15 +136,44 @@ async fn handle_connection(
     {
         warn!("Rate limit exceeded for client: {}", client_addr);
         state.metrics.record_rate_limit_denied();
+        events::rate_limit_exceeded("tcp", &client_addr.to_string());
         log_access("", 0, 0, Some("rate limit exceeded".to_string()));
         return Err("Rate limit exceeded".into());
     }
 
     state.metrics.record_rate_limit_allowed();
+
+    // Terminated TLS is decrypted before anything else; validation keeps it
+    // apart from everything that peeks at what the client sends
+    let mut client = if config.tls_enabled {
+        let Some(acceptor) = state.get_tls_acceptor() else {
+            warn!("No TLS certificate pushed yet, closing connection from {}", client_addr);
+            log_access("", 0, 0, Some("no TLS certificate".to_string()));
+            return Err("No TLS certificate".into());
+        };
+        match tokio::time::timeout(TLS_HANDSHAKE_TIMEOUT, acceptor.accept(client)).await {
+            Ok(Ok(stream)) => ClientStream::Tls(Box::new(stream)),
+            Ok(Err(e)) => {
+                debug!("TLS handshake with {} failed: {}", client_addr, e);
+                log_access("", 0, 0, Some(format!("TLS handshake: {}", e)));
+                return Err(e.into());
+            }
+            Err(_) => {
+                debug!("TLS handshake with {} timed out", client_addr);
+                log_access("", 0, 0, Some("TLS handshake timeout".to_string()));
+                return Err("TLS handshake timeout".into());
+            }
+        }
+    } else {
+        ClientStream::Plain(client)
+    };
     state.metrics.record_tcp_connection();
 
     // Register connection
-    let (conn_id, _token) = state.register_connection();
+    let (conn_id, _token) = state.register_connection(client_addr.to_string());
+    let close_signal = state
+        .close_signal(conn_id)
+        .expect("connection was just registered");
 
     // Ensure we unregister on drop
     let _guard = ConnectionGuard {
@@ -108,13 +181,95 @@ async fn handle_connection(
         conn_id,
     };
 
-    // Select backend; only pass client IP for consistent hashing when session_affinity enabled
-    let context = if config.session_affinity {
-        Some(client_addr.ip().to_string())
+    // The request head is peeked at most once, for header rules, a header
+    // or cookie hash key and a sticky cookie alike
+    let router = state.get_header_router();
+    let hashes_head = config.algorithm == "consistent_hash"
+        && (config.hash_key == "header" || config.hash_key == "cookie");
+    let head = match client.tcp() {
+        Some(tcp) if !router.is_empty() || hashes_head || config.sticky_cookie.enabled() => {
+            peek_request_head(tcp).await
+        }
+        _ => None,
+    };
+
+    // A matching header rule sends the whole connection to its pool
+    let default_lb = load_balancer.clone();
+    let load_balancer = match head.as_deref().and_then(|head| router.route(head)) {
+        Some((rule, rule_lb)) => {
+            debug!("Header rule {} matched connection from {}", rule, client_addr);
+            rule_lb
+        }
+        None => load_balancer,
+    };
+
+    // A TLS connection naming an SNI route's server goes to its pool;
+    // validation keeps SNI routes apart from the HTTP reads above
+    let sni_router = state.get_sni_router();
+    let server = match client.tcp() {
+        Some(tcp) if !sni_router.is_empty() => peek_client_hello(tcp)
+            .await
+            .and_then(|hello| sni::server_name(&hello)),
+        _ => None,
+    };
+    let load_balancer = match server.as_deref().and_then(|name| sni_router.route(name)) {
+        Some(route_lb) => {
+            debug!(
+                "SNI route for {} matched connection from {}",
+                server.as_deref().unwrap_or_default(),
+                client_addr
+            );
+            route_lb
+        }
+        None => load_balancer,
+    };
+
+    // A client in a geo route's networks goes to its pool; validation keeps
+    // geo routes apart from header rules and SNI routes
+    let load_balancer = match state.get_geo_router().route(client_addr.ip()) {
+        Some((route, route_lb)) => {
+            debug!("Geo route {} matched connection from {}", route, client_addr);
+            route_lb
+        }
+        None => load_balancer,
+    };
+
+    // Dark launches take their shares of the connections nothing above took
+    let dark_launches = state.get_dark_launch_router();
+    let dark_launch = if Arc::ptr_eq(&load_balancer, &default_lb) {
+        dark_launches.route()
+    } else {
+        None
+    };
+    let load_balancer = match dark_launch {
+        Some((rule, rule_lb)) => {
+            debug!("Dark launch {} took connection from {}", rule, client_addr);
+            rule_lb
+        }
+        None => load_balancer,
+    };
+
+    // Select backend; consistent_hash always hashes something, other
+    // algorithms only take the client IP for session_affinity
+    let context = if config.algorithm == "consistent_hash" {
+        Some(hash_context(head.as_deref(), &config, &client_ip))
+    } else if config.session_affinity {
+        Some(client_ip.clone())
     } else {
         None
     };
-    let backend = match load_balancer.select_backend_with_context(context.as_deref()) {
+    // A sticky cookie sends the client back to the backend it names, and
+    // a client without one is told which backend it got
+    let sticky = &config.sticky_cookie;
+    let pinned = head
+        .as_deref()
+        .filter(|_| sticky.enabled())
+        .and_then(|head| request_hash_key(head, "cookie", &sticky.name))
+        .and_then(|value| load_balancer.select_sticky(&value));
+    let set_cookie = sticky.enabled() && pinned.is_none();
+    let selected =
+        pinned.or_else(|| load_balancer.select_backend_with_context(context.as_deref()));
+    let backend = match selected {
         Some(b) => b,
         None => {
             log_access("", 0, 0, Some("no healthy backends available".to_string()));
@@ -129,7 +284,7 @@ async fn handle_connection(
             backend.address
         );
         state.metrics.record_circuit_breaker_open();
-        state.metrics.record_backend_failure(&backend.address);
+        state.metrics.record_backend_connect_failure(&backend.address);
         log_access(
             &backend.address,
             0,
@@ -139,6 +294,7 @@ async fn handle_connection(
         return Err("Circuit breaker open".into());
     }
 
+    state.set_connection_backend(conn_id, &backend.address);
     debug!("Forwarding to backend: {}", backend.address);
     state.metrics.record_backend_connection(&backend.address);
 
@@ -179,6 +335,10 @@ async fn handle_connection(
                 .record_success(&backend.address);
             state.metrics.record_backend_request(&backend.address);
             state.metrics.record_latency(latency);
+            state
+                .metrics
+                .record_backend_latency(&backend.address, latency);
+            load_balancer.record_latency(&backend.address, latency);
             stream
         }
         Ok(Err(e)) => {
@@ -187,7 +347,7 @@ async fn handle_connection(
                 .circuit_breaker
                 .read()
                 .record_failure(&backend.address);
-            state.metrics.record_backend_failure(&backend.address);
+            state.metrics.record_backend_connect_failure(&backend.address);
             log_access(&backend.address, 0, 0, Some(e.to_string()));
             return Err(e.into());
         }
@@ -197,7 +357,7 @@ async fn handle_connection(
                 .circuit_breaker
                 .read()
                 .record_failure(&backend.address);
-            state.metrics.record_backend_failure(&backend.address);
+            state.metrics.record_backend_connect_failure(&backend.address);
             log_access(
                 &backend.address,
                 0,
@@ -216,7 +376,15 @@ async fn handle_connection(
     } else {
         None
     };
-    let (mut client_read, mut client_write) = client.split();
+    // A mirrored connection also sends what the client sends to a shadow
+    let mut mirror_tx = state.mirror_target().map(|shadow| {
+        mirror::open(
+            shadow,
+            state.clone(),
+            Duration::from_secs(config.connect_timeout_secs as u64),
+        )
+    });
+    let (mut client_read, mut client_write) = tokio::io::split(&mut client);
     let (mut backend_read, mut backend_write) = backend_stream.split();
 
     let conn_bytes_sent = Arc::new(AtomicU64::new(0));
@@ -254,6 +422,14 @@ async fn handle_connection(
                 .metrics
                 .record_backend_bytes_sent(&backend_addr_clone, n as u64);
             conn_bytes_sent_clone.fetch_add(n as u64, Ordering::Relaxed);
+            if let Some(tx) = &mirror_tx {
+                if let Err(e) = tx.try_send(buf[..n].to_vec()) {
+                    if matches!(e, tokio::sync::mpsc::error::TrySendError::Full(_)) {
+                        state_clone.metrics.record_mirror_dropped();
+                    }
+                    mirror_tx = None;
+                }
+            }
             backend_write.write_all(&buf[..n]).await?;
         }
     };
@@ -261,6 +437,10 @@ async fn handle_connection(
     let backend_addr_clone2 = backend.address.clone();
     let state_clone2 = state.clone();
     let conn_bytes_received_clone = conn_bytes_received.clone();
+    // Only the connection's first response can carry it
+    let mut set_cookie = set_cookie.then(|| {
+        sticky_cookie::set_cookie_header(sticky, &sticky_cookie::cookie_value(&backend.address))
+    });
 
     let backend_to_client = async move {
         let mut buf = vec![0u8; 8192];
@@ -289,7 +469,15 @@ async fn handle_connection(
                 .metrics
                 .record_backend_bytes_received(&backend_addr_clone2, n as u64);
             conn_bytes_received_clone.fetch_add(n as u64, Ordering::Relaxed);
+            if let Some(header) = set_cookie.take() {
+                if let Some(response) = sticky_cookie::insert_header(&buf[..n], &header) {
+                    client_write.write_all(&response).await?;
+                    continue;
+                }
+            }
             client_write.write_all(&buf[..n]).await?;
+            // Sends what TLS buffered; a no-op on a plain connection
+            client_write.flush().await?;
         }
     };
 
@@ -299,6 +487,13 @@ async fn handle_connection(
         result = client_to_backend => {
             if let Err(e) = result {
                 warn!("Client to backend error: {}", e);
+                if e.kind() == std::io::ErrorKind::ConnectionReset {
+                    events::connection_reset(
+                        &backend.address,
+                        "client_to_backend",
+                        &client_addr.to_string(),
+                    );
+                }
                 state.circuit_breaker.read().record_failure(&backend.address);
                 state.metrics.record_backend_failure(&backend.address);
                 conn_error = Some(e.to_string());
@@ -310,6 +505,13 @@ async fn handle_connection(
         result = backend_to_client => {
             if let Err(e) = result {
                 warn!("Backend to client error: {}", e);
+                if e.kind() == std::io::ErrorKind::ConnectionReset {
+                    events::connection_reset(
+                        &backend.address,
+                        "backend_to_client",
+                        &client_addr.to_string(),
+                    );
+                }
                 state.circuit_breaker.read().record_failure(&backend.address);
                 state.metrics.record_backend_failure(&backend.address);
                 conn_error = Some(e.to_string());
@@ -318,6 +520,13 @@ async fn handle_connection(
                 true
             }
         }
+        _ = close_signal.notified() => {
+            // Closed by an operator: not the backend's fault, so neither a
+            // failure nor a success for its circuit breaker
+            info!("Connection {} from {} closed by operator", conn_id, client_addr);
+            conn_error = Some("closed by operator".to_string());
+            false
+        }
     };
 
     if connection_ok {
@@ -327,6 +536,12 @@ async fn handle_connection(
             .record_success(&backend.address);
     }
     state.metrics.close_tcp_connection();
+    state.metrics.record_backend_connection_closed(
+        &backend.address,
+        conn_start.elapsed().as_secs_f64(),
+        conn_bytes_sent.load(Ordering::Relaxed),
+        conn_bytes_received.load(Ordering::Relaxed),
+    );
     debug!("Connection closed");
     log_access(
         &backend.address,
@@ -363,11 +578,107 @@ impl Drop for ConnectionGuard {
     }
 }
 
+/// The consistent_hash key for a connection: the configured header or
+/// cookie from the client's peeked request head, or the source IP when the
+/// key isn't one of those or the head doesn't carry it.
+fn hash_context(
+    head: Option<&[u8]>,
+    config: &crate::config::ProxyConfig,
+    client_ip: &str,
+) -> String {
+    if config.hash_key == "header" || config.hash_key == "cookie" {
+        let key = head.and_then(|head| {
+            request_hash_key(head, &config.hash_key, &config.hash_key_name)
+        });
+        if let Some(value) = key {
+            return value;
+        }
+    }
+    client_ip.to_string()
+}
+
+/// Peeks until the client has sent a whole request head, for up to
+/// PEEK_TIMEOUT. Peeking returns what's buffered straight away, so
+/// partial heads are retried after a short sleep.
+async fn peek_request_head(client: &TcpStream) -> Option<Vec<u8>> {
+    let mut buf = vec![0u8; HEAD_PEEK_BYTES];
+    let deadline = tokio::time::Instant::now() + PEEK_TIMEOUT;
+    loop {
+        let n = match tokio::time::timeout_at(deadline, client.peek(&mut buf)).await {
+            Ok(Ok(n)) if n > 0 => n,
+            _ => return None,
+        };
+        if let Some(end) = buf[..n].windows(4).position(|w| w == b"\r\n\r\n") {
+            buf.truncate(end);
+            return Some(buf);
+        }
+        if n == buf.len() || tokio::time::Instant::now() >= deadline {
+            return None;
+        }
+        tokio::time::sleep(Duration::from_millis(5)).await;
+    }
+}
+
+/// Peeks until the client has sent the whole first TLS record, for up to
+/// PEEK_TIMEOUT, giving up straight away on a connection that doesn't
+/// start with a handshake record.
+async fn peek_client_hello(client: &TcpStream) -> Option<Vec<u8>> {
+    let mut buf = vec![0u8; HELLO_PEEK_BYTES];
+    let deadline = tokio::time::Instant::now() + PEEK_TIMEOUT;
+    loop {
+        let n = match tokio::time::timeout_at(deadline, client.peek(&mut buf)).await {
+            Ok(Ok(n)) if n > 0 => n,
+            _ => return None,
+        };
+        if n >= 5 {
+            let len = sni::client_hello_len(&buf[..n])?;
+            if n >= len {
+                buf.truncate(len);
+                return Some(buf);
+            }
+        }
+        if tokio::time::Instant::now() >= deadline {
+            return None;
+        }
+        tokio::time::sleep(Duration::from_millis(5)).await;
+    }
+}
+
+/// Finds the hash key in an HTTP/1 request head: the named header's value
+/// (name matched case-insensitively), or the named cookie's from the Cookie
+/// headers. None when it's missing or empty.
+fn request_hash_key(head: &[u8], key: &str, name: &str) -> Option<String> {
+    let head = String::from_utf8_lossy(head);
+    let header = if key == "cookie" { "cookie" } else { name };
+    for line in head.split("\r\n").skip(1) {
+        let Some((field, value)) = line.split_once(':') else {
+            continue;
+        };
+        if !field.trim().eq_ignore_ascii_case(header) {
+            continue;
+        }
+        let value = value.trim();
+        if key != "cookie" {
+            if !value.is_empty() {
+                return Some(value.to_string());
+            }
+            continue;
+        }
+        for cookie in value.split(';') {
+            if let Some((k, v)) = cookie.trim().split_once('=') {
+                if k == name && !v.is_empty() {
+                    return Some(v.to_string());
+                }
+            }
+        }
+    }
+    None
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
     use crate::config::Backend;
-    use std::time::Duration;
 
     fn test_proxy_config(read_timeout_secs: i32) -> crate::config::ProxyConfig {
         crate::config::ProxyConfig {
@@ -377,8 +688,24 @@ mod tests {
             udp_backends: vec![],
             algorithm: "round_robin".to_string(),
             session_affinity: false,
+            hash_key: String::new(),
+            hash_key_name: String::new(),
+            virtual_nodes: 160,
+            hash_method: String::new(),
+            maglev_table_size: 65537,
+            locality_policy: String::new(),
+            locality: crate::load_balancer::Locality::default(),
+            udp_affinity: crate::config::UdpAffinity::default(),
+            sticky_cookie: crate::config::StickyCookie::default(),
+            mirror: crate::config::Mirror::default(),
+            header_rules: vec![],
+            sni_routes: vec![],
+            geo_routes: vec![],
+            dark_launches: vec![],
+            tls_enabled: false,
             rate_limit_rps: 1000,
             rate_limit_burst: 100,
+            connection_limit: crate::config::ConnectionLimit::default(),
             connect_timeout_secs: 5,
             idle_timeout_secs: 60,
             read_timeout_secs,
@@ -406,6 +733,7 @@ mod tests {
         connect_task.await.unwrap();
 
         let state = Arc::new(ProxyState::new());
+        state.update_config(test_proxy_config(0));
         state.circuit_breaker.read().record_failure(&backend_addr); // seed nonzero count
 
         let lb = Arc::new(LoadBalancer::new(
@@ -413,14 +741,16 @@ mod tests {
                 address: backend_addr.clone(),
                 weight: 100,
                 healthy: true,
+                priority: 0,
+                backup: false,
+                zone: String::new(),
+                region: String::new(),
             }],
             "round_robin".to_string(),
         ));
         let pool = ConnectionPool::new(0);
 
-        handle_connection(client_stream, state.clone(), lb, test_proxy_config(0), pool)
-            .await
-            .unwrap();
+        handle_connection(client_stream, state.clone(), lb, pool).await.unwrap();
 
         let states = state.circuit_breaker.read().get_all_states();
         assert_eq!(
@@ -452,19 +782,22 @@ mod tests {
         let (client_stream, _) = client_listener.accept().await.unwrap();
 
         let state = Arc::new(ProxyState::new());
+        state.update_config(test_proxy_config(0));
         let lb = Arc::new(LoadBalancer::new(
             vec![Backend {
                 address: backend_addr.clone(),
                 weight: 100,
                 healthy: true,
+                priority: 0,
+                backup: false,
+                zone: String::new(),
+                region: String::new(),
             }],
             "round_robin".to_string(),
         ));
         let pool = ConnectionPool::new(0);
 
-        handle_connection(client_stream, state.clone(), lb, test_proxy_config(0), pool)
-            .await
-            .unwrap();
+        handle_connection(client_stream, state.clone(), lb, pool).await.unwrap();
 
         let states = state.circuit_breaker.read().get_all_states();
         assert_eq!(
@@ -474,4 +807,289 @@ mod tests {
 
         connect_task.abort();
     }
+
+    #[tokio::test]
+    async fn test_handle_connection_copies_client_data_to_mirror() {
+        let backend_listener = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let backend_addr = backend_listener.local_addr().unwrap().to_string();
+        tokio::spawn(async move {
+            let (mut stream, _) = backend_listener.accept().await.unwrap();
+            let mut buf = Vec::new();
+            let _ = stream.read_to_end(&mut buf).await;
+        });
+        let shadow_listener = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let shadow_addr = shadow_listener.local_addr().unwrap().to_string();
+        let shadow_task = tokio::spawn(async move {
+            let (mut stream, _) = shadow_listener.accept().await.unwrap();
+            // A response the proxy has to throw away
+            stream.write_all(b"HTTP/1.1 500 shadow\r\n\r\n").await.unwrap();
+            let mut buf = Vec::new();
+            stream.read_to_end(&mut buf).await.unwrap();
+            buf
+        });
+
+        let client_listener = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let client_listener_addr = client_listener.local_addr().unwrap();
+        let connect_task = tokio::spawn(async move {
+            let mut stream = TcpStream::connect(client_listener_addr).await.unwrap();
+            stream.write_all(b"GET /checkout HTTP/1.1\r\n\r\n").await.unwrap();
+        });
+        let (client_stream, _) = client_listener.accept().await.unwrap();
+        connect_task.await.unwrap();
+
+        let state = Arc::new(ProxyState::new());
+        let mut config = test_proxy_config(0);
+        config.mirror = crate::config::Mirror {
+            backends: vec![shadow_addr],
+            percent: 100,
+        };
+        state.update_config(config);
+        let lb = Arc::new(LoadBalancer::new(
+            vec![Backend {
+                address: backend_addr.clone(),
+                weight: 100,
+                healthy: true,
+                priority: 0,
+                backup: false,
+                zone: String::new(),
+                region: String::new(),
+            }],
+            "round_robin".to_string(),
+        ));
+        let pool = ConnectionPool::new(0);
+
+        handle_connection(client_stream, state.clone(), lb, pool).await.unwrap();
+
+        let mirrored = tokio::time::timeout(Duration::from_secs(5), shadow_task)
+            .await
+            .expect("shadow never saw the connection close")
+            .unwrap();
+        assert_eq!(&mirrored[..], b"GET /checkout HTTP/1.1\r\n\r\n");
+        let summary = state.metrics.get_summary();
+        assert_eq!(summary.mirror_connections, 1);
+        assert_eq!(summary.mirror_failures, 0);
+        // The shadow isn't a backend as far as the metrics go
+        assert_eq!(summary.bytes_received, 0);
+    }
+
+    /// Sends request through handle_connection to lb, returning what the
+    /// client got back.
+    async fn proxy_request(
+        state: &Arc<ProxyState>,
+        lb: Arc<LoadBalancer>,
+        request: &[u8],
+    ) -> Vec<u8> {
+        let client_listener = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let client_listener_addr = client_listener.local_addr().unwrap();
+        let request = request.to_vec();
+        let client_task = tokio::spawn(async move {
+            let mut stream = TcpStream::connect(client_listener_addr).await.unwrap();
+            stream.write_all(&request).await.unwrap();
+            let mut response = Vec::new();
+            stream.read_to_end(&mut response).await.unwrap();
+            response
+        });
+        let (client_stream, _) = client_listener.accept().await.unwrap();
+        handle_connection(client_stream, state.clone(), lb, ConnectionPool::new(0))
+            .await
+            .unwrap();
+        client_task.await.unwrap()
+    }
+
+    /// A backend answering every connection with an empty 200 naming its
+    /// address in X-Backend.
+    async fn http_backend() -> String {
+        let listener = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let addr = listener.local_addr().unwrap().to_string();
+        let response = format!(
+            "HTTP/1.1 200 OK\r\nX-Backend: {}\r\nContent-Length: 0\r\n\r\n",
+            addr
+        );
+        tokio::spawn(async move {
+            while let Ok((mut stream, _)) = listener.accept().await {
+                let mut buf = [0u8; 1024];
+                let _ = stream.read(&mut buf).await;
+                let _ = stream.write_all(response.as_bytes()).await;
+            }
+        });
+        addr
+    }
+
+    fn single_backend_lb(address: &str) -> Arc<LoadBalancer> {
+        Arc::new(LoadBalancer::new(
+            vec![Backend {
+                address: address.to_string(),
+                weight: 100,
+                healthy: true,
+                priority: 0,
+                backup: false,
+                zone: String::new(),
+                region: String::new(),
+            }],
+            "round_robin".to_string(),
+        ))
+    }
+
+    #[tokio::test]
+    async fn test_handle_connection_uses_updated_sticky_cookie() {
+        let backend_addr = http_backend().await;
+        let state = Arc::new(ProxyState::new());
+        state.update_config(test_proxy_config(0));
+        let request = b"GET / HTTP/1.1\r\nHost: example.com\r\n\r\n";
+
+        let response = proxy_request(&state, single_backend_lb(&backend_addr), request).await;
+        assert!(response.starts_with(b"HTTP/1.1 200 OK\r\n"));
+        assert!(!String::from_utf8_lossy(&response).contains("Set-Cookie"));
+
+        // Turned on by a reload or push while the proxy runs
+        let mut config = test_proxy_config(0);
+        config.sticky_cookie = crate::config::StickyCookie {
+            name: "aegis_backend".to_string(),
+            path: "/".to_string(),
+            ..Default::default()
+        };
+        state.update_config(config);
+
+        let response = proxy_request(&state, single_backend_lb(&backend_addr), request).await;
+        let want = format!(
+            "Set-Cookie: aegis_backend={}; Path=/\r\n",
+            sticky_cookie::cookie_value(&backend_addr)
+        );
+        assert!(
+            String::from_utf8_lossy(&response).contains(&want),
+            "no {:?} in {:?}",
+            want,
+            String::from_utf8_lossy(&response)
+        );
+    }
+
+    #[tokio::test]
+    async fn test_handle_connection_uses_updated_hash_key() {
+        let addrs = [http_backend().await, http_backend().await];
+        let state = Arc::new(ProxyState::new());
+        let mut config = test_proxy_config(0);
+        config.algorithm = "consistent_hash".to_string();
+        config.backends = addrs
+            .iter()
+            .map(|address| Backend {
+                address: address.clone(),
+                weight: 100,
+                healthy: true,
+                priority: 0,
+                backup: false,
+                zone: String::new(),
+                region: String::new(),
+            })
+            .collect();
+        state.update_config(config.clone());
+
+        // A user the header hash puts on the other backend from the
+        // client's IP, which is hashed until the key changes
+        let lb = state.get_tcp_lb();
+        let by_ip = lb.select_backend_with_context(Some("127.0.0.1")).unwrap().address;
+        let user = (0..64)
+            .map(|i| i.to_string())
+            .find(|user| {
+                lb.select_backend_with_context(Some(user.as_str())).unwrap().address != by_ip
+            })
+            .expect("every user hashed onto one backend");
+        let request = format!("GET / HTTP/1.1\r\nX-User-ID: {}\r\n\r\n", user);
+        let backend_of = |response: Vec<u8>| {
+            let response = String::from_utf8_lossy(&response).into_owned();
+            addrs
+                .iter()
+                .find(|addr| response.contains(&format!("X-Backend: {}\r\n", addr)))
+                .cloned()
+                .unwrap_or_else(|| panic!("no backend named in {:?}", response))
+        };
+
+        let response = proxy_request(&state, state.get_tcp_lb(), request.as_bytes()).await;
+        assert_eq!(backend_of(response), by_ip);
+
+        config.hash_key = "header".to_string();
+        config.hash_key_name = "x-user-id".to_string();
+        state.update_config(config);
+
+        let response = proxy_request(&state, state.get_tcp_lb(), request.as_bytes()).await;
+        assert_ne!(backend_of(response), by_ip, "X-User-ID {} wasn't hashed", user);
+    }
+
+    #[tokio::test]
+    async fn test_handle_connection_uses_updated_tls_enabled() {
+        let backend_addr = http_backend().await;
+        let state = Arc::new(ProxyState::new());
+        state.update_config(test_proxy_config(0));
+        let request = b"GET / HTTP/1.1\r\n\r\n";
+
+        let response = proxy_request(&state, single_backend_lb(&backend_addr), request).await;
+        assert!(response.starts_with(b"HTTP/1.1 200 OK\r\n"));
+
+        // Terminating TLS with no certificate pushed yet turns the next
+        // connection away rather than passing it through in plaintext
+        let mut config = test_proxy_config(0);
+        config.tls_enabled = true;
+        state.update_config(config);
+
+        let client_listener = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let client_listener_addr = client_listener.local_addr().unwrap();
+        let connect_task = tokio::spawn(async move {
+            let mut stream = TcpStream::connect(client_listener_addr).await.unwrap();
+            stream.write_all(request).await.unwrap();
+            let mut response = Vec::new();
+            let _ = stream.read_to_end(&mut response).await;
+            response
+        });
+        let (client_stream, _) = client_listener.accept().await.unwrap();
+        let err = handle_connection(
+            client_stream,
+            state.clone(),
+            single_backend_lb(&backend_addr),
+            ConnectionPool::new(0),
+        )
+        .await
+        .unwrap_err();
+        assert_eq!(err.to_string(), "No TLS certificate");
+        assert!(connect_task.await.unwrap().is_empty());
+    }
+
+    #[test]
+    fn test_request_hash_key_reads_header_and_cookie() {
+        let head = b"GET /cart HTTP/1.1\r\nHost: shop\r\nx-user-id:  42 \r\nCookie: theme=dark; session=abc123\r\n";
+        assert_eq!(request_hash_key(head, "header", "X-User-ID"), Some("42".to_string()));
+        assert_eq!(request_hash_key(head, "cookie", "session"), Some("abc123".to_string()));
+        assert_eq!(request_hash_key(head, "cookie", "Session"), None);
+        assert_eq!(request_hash_key(head, "header", "X-Tenant"), None);
+    }
+
+    #[tokio::test]
+    async fn test_peek_request_head_leaves_it_unread() {
+        let listener = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let addr = listener.local_addr().unwrap();
+        let request = b"GET / HTTP/1.1\r\nX-User-ID: 7\r\n\r\n";
+        let client_task = tokio::spawn(async move {
+            let mut stream = TcpStream::connect(addr).await.unwrap();
+            // Split the head so the peek has to wait for the rest
+            stream.write_all(&request[..10]).await.unwrap();
+            tokio::time::sleep(Duration::from_millis(20)).await;
+            stream.write_all(&request[10..]).await.unwrap();
+            stream
+        });
+        let (mut server, _) = listener.accept().await.unwrap();
+
+        let mut config = test_proxy_config(30);
+        config.algorithm = "consistent_hash".to_string();
+        config.hash_key = "header".to_string();
+        config.hash_key_name = "x-user-id".to_string();
+        let head = peek_request_head(&server).await;
+        assert_eq!(hash_context(head.as_deref(), &config, "10.0.0.1"), "7");
+
+        let mut read = vec![0u8; request.len()];
+        server.read_exact(&mut read).await.unwrap();
+        assert_eq!(&read[..], &request[..]);
+
+        config.hash_key_name = "x-missing".to_string();
+        assert_eq!(hash_context(head.as_deref(), &config, "10.0.0.1"), "10.0.0.1");
+        assert_eq!(hash_context(None, &config, "10.0.0.1"), "10.0.0.1");
+        drop(client_task.await.unwrap());
+    }
 }
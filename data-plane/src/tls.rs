@@ -0,0 +1,255 @@
+//! TLS termination on the TCP listener, with the certificates pushed by the
+//! control plane's UpdateSecrets or StreamSecrets, picked by the server
+//! name in the ClientHello.
+
+use std::collections::HashMap;
+use std::io;
+use std::pin::Pin;
+use std::sync::Arc;
+use std::task::{Context, Poll};
+
+use tokio::io::{AsyncRead, AsyncWrite, ReadBuf};
+use tokio::net::TcpStream;
+use tokio_rustls::rustls::server::{ClientHello, ResolvesServerCert};
+use tokio_rustls::rustls::sign::{self, CertifiedKey};
+use tokio_rustls::rustls::{Certificate, PrivateKey, ServerConfig};
+use tokio_rustls::server::TlsStream;
+use tokio_rustls::TlsAcceptor;
+
+use crate::config::proxy;
+
+/// The pushed certificates by server name: exact names first, then
+/// wildcards by the domain they cover, then the first certificate for
+/// clients naming none of them (or none at all).
+#[derive(Default)]
+struct CertResolver {
+    exact: HashMap<String, Arc<CertifiedKey>>,
+    wildcard: HashMap<String, Arc<CertifiedKey>>,
+    default: Option<Arc<CertifiedKey>>,
+}
+
+impl CertResolver {
+    fn lookup(&self, server_name: Option<&str>) -> Option<Arc<CertifiedKey>> {
+        let name = server_name.map(|n| n.trim_end_matches('.').to_ascii_lowercase());
+        if let Some(name) = name.as_deref() {
+            if let Some(key) = self.exact.get(name) {
+                return Some(key.clone());
+            }
+            // *.example.com covers exactly one label more
+            if let Some((_, parent)) = name.split_once('.') {
+                if let Some(key) = self.wildcard.get(parent) {
+                    return Some(key.clone());
+                }
+            }
+        }
+        self.default.clone()
+    }
+}
+
+impl ResolvesServerCert for CertResolver {
+    fn resolve(&self, client_hello: ClientHello) -> Option<Arc<CertifiedKey>> {
+        self.lookup(client_hello.server_name())
+    }
+}
+
+/// Parses a pushed certificate: its PEM chain, leaf first, and the leaf's
+/// PEM private key, PKCS#8, SEC 1 or PKCS#1.
+fn certified_key(cert: &proxy::TlsCertificate) -> Result<CertifiedKey, String> {
+    let chain: Vec<Certificate> = rustls_pemfile::certs(&mut cert.certificate_chain.as_slice())
+        .map_err(|e| format!("certificate {}: invalid chain: {}", cert.name, e))?
+        .into_iter()
+        .map(Certificate)
+        .collect();
+    if chain.is_empty() {
+        return Err(format!("certificate {}: no certificate in its chain", cert.name));
+    }
+
+    let mut key = None;
+    let mut reader = cert.private_key.as_slice();
+    while let Some(item) = rustls_pemfile::read_one(&mut reader)
+        .map_err(|e| format!("certificate {}: invalid private key: {}", cert.name, e))?
+    {
+        match item {
+            rustls_pemfile::Item::PKCS8Key(der)
+            | rustls_pemfile::Item::ECKey(der)
+            | rustls_pemfile::Item::RSAKey(der) => {
+                key = Some(PrivateKey(der));
+                break;
+            }
+            _ => {}
+        }
+    }
+    let key = key.ok_or_else(|| format!("certificate {}: no private key", cert.name))?;
+    let signing_key = sign::any_supported_type(&key)
+        .map_err(|_| format!("certificate {}: unsupported private key type", cert.name))?;
+    Ok(CertifiedKey::new(chain, signing_key))
+}
+
+/// Checks a pushed certificate can be served on its own, as a streamed
+/// one is.
+pub fn validate(cert: &proxy::TlsCertificate) -> Result<(), String> {
+    certified_key(cert)?;
+    if cert.server_names.is_empty() {
+        return Err(format!("certificate {} has no server names", cert.name));
+    }
+    Ok(())
+}
+
+/// Builds the acceptor for the pushed certificates, the first being the
+/// default, checking every one before any of them replaces the ones in
+/// use. A server name in more than one certificate picks the first.
+pub fn acceptor<'a>(
+    certificates: impl IntoIterator<Item = &'a proxy::TlsCertificate>,
+) -> Result<TlsAcceptor, String> {
+    let mut resolver = CertResolver::default();
+    for cert in certificates {
+        let key = Arc::new(certified_key(cert)?);
+        if cert.server_names.is_empty() {
+            return Err(format!("certificate {} has no server names", cert.name));
+        }
+        for name in &cert.server_names {
+            let name = name.to_ascii_lowercase();
+            match name.strip_prefix("*.") {
+                Some(parent) => resolver.wildcard.entry(parent.to_string()),
+                None => resolver.exact.entry(name),
+            }
+            .or_insert_with(|| key.clone());
+        }
+        resolver.default.get_or_insert(key);
+    }
+
+    let mut config = ServerConfig::builder()
+        .with_safe_defaults()
+        .with_no_client_auth()
+        .with_cert_resolver(Arc::new(resolver));
+    // Backends get plain bytes and may speak anything; HTTP/1.1 is what a
+    // client offering ALPN most likely wants from a TCP proxy
+    config.alpn_protocols = vec![b"http/1.1".to_vec()];
+    Ok(TlsAcceptor::from(Arc::new(config)))
+}
+
+/// A client connection: as accepted, or after TLS termination.
+pub enum ClientStream {
+    Plain(TcpStream),
+    Tls(Box<TlsStream<TcpStream>>),
+}
+
+impl ClientStream {
+    /// The TCP stream to peek at, which only a plain connection has.
+    pub fn tcp(&self) -> Option<&TcpStream> {
+        match self {
+            ClientStream::Plain(stream) => Some(stream),
+            ClientStream::Tls(_) => None,
+        }
+    }
+}
+
+impl AsyncRead for ClientStream {
+    fn poll_read(
+        self: Pin<&mut Self>,
+        cx: &mut Context<'_>,
+        buf: &mut ReadBuf<'_>,
+    ) -> Poll<io::Result<()>> {
+        match self.get_mut() {
+            ClientStream::Plain(stream) => Pin::new(stream).poll_read(cx, buf),
+            ClientStream::Tls(stream) => Pin::new(stream.as_mut()).poll_read(cx, buf),
+        }
+    }
+}
+
+impl AsyncWrite for ClientStream {
+    fn poll_write(
+        self: Pin<&mut Self>,
+        cx: &mut Context<'_>,
+        buf: &[u8],
+    ) -> Poll<io::Result<usize>> {
+        match self.get_mut() {
+            ClientStream::Plain(stream) => Pin::new(stream).poll_write(cx, buf),
+            ClientStream::Tls(stream) => Pin::new(stream.as_mut()).poll_write(cx, buf),
+        }
+    }
+
+    fn poll_flush(self: Pin<&mut Self>, cx: &mut Context<'_>) -> Poll<io::Result<()>> {
+        match self.get_mut() {
+            ClientStream::Plain(stream) => Pin::new(stream).poll_flush(cx),
+            ClientStream::Tls(stream) => Pin::new(stream.as_mut()).poll_flush(cx),
+        }
+    }
+
+    fn poll_shutdown(self: Pin<&mut Self>, cx: &mut Context<'_>) -> Poll<io::Result<()>> {
+        match self.get_mut() {
+            ClientStream::Plain(stream) => Pin::new(stream).poll_shutdown(cx),
+            ClientStream::Tls(stream) => Pin::new(stream.as_mut()).poll_shutdown(cx),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn resolver_with(names: &[(&str, &str)]) -> CertResolver {
+        // The lookup only compares Arcs, so any key will do
+        let key = |_: &str| {
+            Arc::new(CertifiedKey {
+                cert: vec![],
+                key: Arc::new(NoKey),
+                ocsp: None,
+                sct_list: None,
+            })
+        };
+        let mut resolver = CertResolver::default();
+        for (name, cert) in names {
+            let k = key(cert);
+            match name.strip_prefix("*.") {
+                Some(parent) => resolver.wildcard.insert(parent.to_string(), k.clone()),
+                None => resolver.exact.insert(name.to_string(), k.clone()),
+            };
+            resolver.default.get_or_insert(k);
+        }
+        resolver
+    }
+
+    struct NoKey;
+
+    impl sign::SigningKey for NoKey {
+        fn choose_scheme(
+            &self,
+            _offered: &[tokio_rustls::rustls::SignatureScheme],
+        ) -> Option<Box<dyn sign::Signer>> {
+            None
+        }
+
+        fn algorithm(&self) -> tokio_rustls::rustls::SignatureAlgorithm {
+            tokio_rustls::rustls::SignatureAlgorithm::ECDSA
+        }
+    }
+
+    #[test]
+    fn test_lookup_prefers_exact_then_wildcard_then_default() {
+        let resolver = resolver_with(&[("example.com", "a"), ("*.example.com", "b")]);
+        let exact = resolver.exact["example.com"].clone();
+        let wildcard = resolver.wildcard["example.com"].clone();
+
+        assert!(Arc::ptr_eq(&resolver.lookup(Some("example.com")).unwrap(), &exact));
+        assert!(Arc::ptr_eq(&resolver.lookup(Some("API.example.com.")).unwrap(), &wildcard));
+        // A wildcard covers one label only
+        assert!(Arc::ptr_eq(&resolver.lookup(Some("a.b.example.com")).unwrap(), &exact));
+        assert!(Arc::ptr_eq(&resolver.lookup(None).unwrap(), &exact));
+    }
+
+    #[test]
+    fn test_acceptor_rejects_bad_certificates() {
+        let set = proxy::SecretSet {
+            tls_certificates: vec![proxy::TlsCertificate {
+                name: "broken".to_string(),
+                certificate_chain: b"not a certificate".to_vec(),
+                private_key: vec![],
+                server_names: vec!["example.com".to_string()],
+            }],
+        };
+        let err = acceptor(&set.tls_certificates).err().unwrap();
+        assert!(err.contains("certificate broken"), "{}", err);
+        assert!(validate(&set.tls_certificates[0]).is_err());
+    }
+}
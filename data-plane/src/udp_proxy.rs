@@ -6,8 +6,10 @@ use tokio::net::UdpSocket;
 use tracing::{debug, error, info, warn};
 
 use crate::access_log::AccessLogEntry;
-use crate::config::ProxyState;
+use crate::config::{ProxyState, UdpAffinity};
+use crate::events;
 
+/// How long a session lasts without a packet, unless UDP affinity sets it
 const SESSION_TIMEOUT: Duration = Duration::from_secs(60);
 const BUFFER_SIZE: usize = 65536;
 const CLEANUP_INTERVAL: Duration = Duration::from_secs(10);
@@ -104,6 +106,31 @@ impl Drop for UdpSession {
     fn drop(&mut self) {
         // Record session close
         self.state.metrics.close_udp_session();
+        self.state.metrics.record_backend_connection_closed(
+            &self.backend_addr,
+            self.created_at.elapsed().as_secs_f64(),
+            self.bytes_sent,
+            self.bytes_received,
+        );
+    }
+}
+
+fn session_timeout(affinity: &UdpAffinity) -> Duration {
+    if affinity.enabled() {
+        affinity.idle_timeout
+    } else {
+        SESSION_TIMEOUT
+    }
+}
+
+/// What a client's backend is hashed by: its whole source address for
+/// source_ip_port affinity, otherwise just the IP, so consistent_hash
+/// without affinity keeps a host on one backend as before.
+fn affinity_key(affinity: &UdpAffinity, peer: SocketAddr) -> String {
+    if affinity.mode == "source_ip_port" {
+        peer.to_string()
+    } else {
+        peer.ip().to_string()
     }
 }
 
@@ -126,16 +153,20 @@ pub async fn run(state: Arc<ProxyState>) -> Result<(), Box<dyn std::error::Error
     // Session cleanup task - removes expired sessions
     let sessions_clone = sessions.clone();
     let reverse_sessions_clone = reverse_sessions.clone();
+    let state_for_cleanup = state.clone();
     tokio::spawn(async move {
         let mut interval = tokio::time::interval(CLEANUP_INTERVAL);
         loop {
             interval.tick().await;
 
+            // Read each time round, so a config push changes it for the
+            // sessions already open
+            let timeout = session_timeout(&state_for_cleanup.get_udp_affinity());
             let mut expired_keys = Vec::new();
 
             // Find expired sessions
             for entry in sessions_clone.iter() {
-                if entry.value().is_expired(SESSION_TIMEOUT) {
+                if entry.value().is_expired(timeout) {
                     expired_keys.push(entry.key().clone());
                 }
             }
@@ -209,6 +240,11 @@ pub async fn run(state: Arc<ProxyState>) -> Result<(), Box<dyn std::error::Error
                 // Packet from client to backend - establish/update session
                 let client_key = peer_addr.to_string();
 
+                if state_clone.is_paused() && !sessions_clone.contains_key(&client_key) {
+                    debug!("Traffic paused, dropping packet from new UDP client {}", peer_addr);
+                    return;
+                }
+
                 // Check rate limit
                 if !state_clone
                     .rate_limiter
@@ -217,23 +253,24 @@ pub async fn run(state: Arc<ProxyState>) -> Result<(), Box<dyn std::error::Error
                 {
                     warn!("Rate limit exceeded for UDP client: {}", peer_addr);
                     state_clone.metrics.record_rate_limit_denied();
+                    events::rate_limit_exceeded("udp", &client_key);
                     return;
                 }
 
                 state_clone.metrics.record_rate_limit_allowed();
 
                 let lb = state_clone.get_udp_lb();
-                let backend =
-                    match lb.select_backend_with_context(Some(&peer_addr.ip().to_string())) {
-                        Some(b) => b,
-                        None => {
-                            warn!(
-                                "No healthy UDP backends available, dropping packet from {}",
-                                peer_addr
-                            );
-                            return;
-                        }
-                    };
+                let key = affinity_key(&state_clone.get_udp_affinity(), peer_addr);
+                let backend = match lb.select_backend_with_context(Some(&key)) {
+                    Some(b) => b,
+                    None => {
+                        warn!(
+                            "No healthy UDP backends available, dropping packet from {}",
+                            peer_addr
+                        );
+                        return;
+                    }
+                };
                 let resolved_backend_socket_addr: SocketAddr = match backend
                     .address
                     .to_socket_addrs()
@@ -290,7 +327,7 @@ pub async fn run(state: Arc<ProxyState>) -> Result<(), Box<dyn std::error::Error
                     state_clone.metrics.record_circuit_breaker_open();
                     state_clone
                         .metrics
-                        .record_backend_failure(&backend_addr_str);
+                        .record_backend_connect_failure(&backend_addr_str);
                     return;
                 }
 
@@ -324,7 +361,7 @@ pub async fn run(state: Arc<ProxyState>) -> Result<(), Box<dyn std::error::Error
                             .record_failure(&backend_addr_str);
                         state_clone
                             .metrics
-                            .record_backend_failure(&backend_addr_str);
+                            .record_backend_connect_failure(&backend_addr_str);
                     }
                 }
             }